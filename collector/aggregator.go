@@ -0,0 +1,187 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+const (
+	// DefaultAggregationWindow is the default interval at which aggregated
+	// flows are flushed downstream
+	DefaultAggregationWindow = 10 * time.Second
+	// DefaultMaxAggregatedFlows is the default maximum number of distinct
+	// flows kept during a window
+	DefaultMaxAggregatedFlows = 10000
+)
+
+// AggregatorConfig is the configuration of an Aggregator
+type AggregatorConfig struct {
+	// Window is the interval at which the aggregated flows are flushed
+	Window time.Duration
+	// MaxFlows is the maximum number of distinct flows kept during a
+	// window. Flows that do not fit are dropped and counted as overflow.
+	MaxFlows int
+}
+
+// aggregationKey identifies the flows that are aggregated together
+type aggregationKey struct {
+	sourceID      string
+	destinationID string
+	port          uint16
+	action        policy.ActionType
+	policyID      string
+	dropReason    string
+}
+
+// Aggregator is an EventCollector that aggregates the flow events of a
+// window before sending them to a downstream collector. Flows with the
+// same source ID, destination ID, destination port, action, policy ID and
+// drop reason are reported once with the sum of their counts. Container,
+// user and secrets events are sent downstream directly.
+type Aggregator struct {
+	downstream EventCollector
+	window     time.Duration
+	maxFlows   int
+	flows      map[aggregationKey]*FlowRecord
+	overflow   uint64
+	sync.Mutex
+}
+
+// NewAggregator creates a new aggregator that flushes to the downstream
+// collector
+func NewAggregator(downstream EventCollector, config *AggregatorConfig) *Aggregator {
+
+	c := AggregatorConfig{}
+	if config != nil {
+		c = *config
+	}
+
+	if c.Window <= 0 {
+		c.Window = DefaultAggregationWindow
+	}
+
+	if c.MaxFlows <= 0 {
+		c.MaxFlows = DefaultMaxAggregatedFlows
+	}
+
+	return &Aggregator{
+		downstream: downstream,
+		window:     c.Window,
+		maxFlows:   c.MaxFlows,
+		flows:      map[aggregationKey]*FlowRecord{},
+	}
+}
+
+// Run flushes the aggregated flows at every window until the context is
+// done. The remaining flows are flushed before it returns.
+func (a *Aggregator) Run(ctx context.Context) error {
+
+	go func() {
+		ticker := time.NewTicker(a.window)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				a.Flush()
+			case <-ctx.Done():
+				a.Flush()
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// CollectFlowEvent is part of the EventCollector interface.
+func (a *Aggregator) CollectFlowEvent(record *FlowRecord) {
+
+	key := flowAggregationKey(record)
+
+	// At least one flow is collected
+	count := record.Count
+	if count == 0 {
+		count = 1
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	if r, ok := a.flows[key]; ok {
+		r.Count += count
+		return
+	}
+
+	if len(a.flows) >= a.maxFlows {
+		a.overflow++
+		return
+	}
+
+	// The record is copied since the caller may reuse it
+	r := *record
+	r.Count = count
+	a.flows[key] = &r
+}
+
+// CollectContainerEvent is part of the EventCollector interface.
+func (a *Aggregator) CollectContainerEvent(record *ContainerRecord) {
+	a.downstream.CollectContainerEvent(record)
+}
+
+// CollectUserEvent is part of the EventCollector interface.
+func (a *Aggregator) CollectUserEvent(record *UserRecord) {
+	a.downstream.CollectUserEvent(record)
+}
+
+// CollectSecretsEvent is part of the SecretsEventCollector interface.
+func (a *Aggregator) CollectSecretsEvent(record *SecretsRecord) {
+	CollectSecretsEvent(a.downstream, record)
+}
+
+// Flush sends the aggregated flows downstream
+func (a *Aggregator) Flush() {
+
+	a.Lock()
+	flows := a.flows
+	a.flows = make(map[aggregationKey]*FlowRecord, len(flows))
+	a.Unlock()
+
+	for _, r := range flows {
+		a.downstream.CollectFlowEvent(r)
+	}
+}
+
+// Overflow returns the number of flow events that were dropped because
+// the maximum number of flows was reached
+func (a *Aggregator) Overflow() uint64 {
+
+	a.Lock()
+	defer a.Unlock()
+
+	return a.overflow
+}
+
+// flowAggregationKey returns the aggregation key of a flow
+func flowAggregationKey(r *FlowRecord) aggregationKey {
+
+	key := aggregationKey{
+		action:     r.Action,
+		policyID:   r.PolicyID,
+		dropReason: r.DropReason,
+	}
+
+	if r.Source != nil {
+		key.sourceID = r.Source.ID
+	}
+
+	if r.Destination != nil {
+		key.destinationID = r.Destination.ID
+		key.port = r.Destination.Port
+	}
+
+	return key
+}
@@ -0,0 +1,187 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// recordingCollector records the events it collects
+type recordingCollector struct {
+	flows      []*FlowRecord
+	containers []*ContainerRecord
+	users      []*UserRecord
+	secrets    []*SecretsRecord
+	sync.Mutex
+}
+
+func (r *recordingCollector) CollectFlowEvent(record *FlowRecord) {
+	r.Lock()
+	defer r.Unlock()
+	r.flows = append(r.flows, record)
+}
+
+func (r *recordingCollector) CollectContainerEvent(record *ContainerRecord) {
+	r.Lock()
+	defer r.Unlock()
+	r.containers = append(r.containers, record)
+}
+
+func (r *recordingCollector) CollectUserEvent(record *UserRecord) {
+	r.Lock()
+	defer r.Unlock()
+	r.users = append(r.users, record)
+}
+
+func (r *recordingCollector) CollectSecretsEvent(record *SecretsRecord) {
+	r.Lock()
+	defer r.Unlock()
+	r.secrets = append(r.secrets, record)
+}
+
+// flowOnlyCollector only implements the EventCollector interface
+type flowOnlyCollector struct {
+	r *recordingCollector
+}
+
+func (f *flowOnlyCollector) CollectFlowEvent(record *FlowRecord)           { f.r.CollectFlowEvent(record) }
+func (f *flowOnlyCollector) CollectContainerEvent(record *ContainerRecord) {}
+func (f *flowOnlyCollector) CollectUserEvent(record *UserRecord)           {}
+
+func (r *recordingCollector) flowCount() int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.flows)
+}
+
+func testFlowRecord(sourceID string, port uint16, action policy.ActionType, count int) *FlowRecord {
+	return &FlowRecord{
+		ContextID: "context",
+		Source: &EndPoint{
+			ID: sourceID,
+			IP: "10.1.1.1",
+		},
+		Destination: &EndPoint{
+			ID:   "destination",
+			IP:   "10.1.1.2",
+			Port: port,
+		},
+		Action:     action,
+		PolicyID:   "policy",
+		DropReason: PolicyDrop,
+		Count:      count,
+	}
+}
+
+func TestAggregator(t *testing.T) {
+
+	Convey("Given an aggregator", t, func() {
+
+		downstream := &recordingCollector{}
+		a := NewAggregator(downstream, &AggregatorConfig{MaxFlows: 2})
+
+		Convey("When I collect flows with the same key", func() {
+			first := testFlowRecord("source", 80, policy.Reject, 0)
+			a.CollectFlowEvent(first)
+			a.CollectFlowEvent(testFlowRecord("source", 80, policy.Reject, 3))
+
+			Convey("Then nothing should be sent before the flush", func() {
+				So(downstream.flowCount(), ShouldEqual, 0)
+			})
+
+			Convey("Then a single flow with the sum of the counts should be flushed", func() {
+				a.Flush()
+				So(len(downstream.flows), ShouldEqual, 1)
+				So(downstream.flows[0].Count, ShouldEqual, 4)
+				So(downstream.flows[0].Source.ID, ShouldEqual, "source")
+				So(first.Count, ShouldEqual, 0)
+			})
+
+			Convey("Then a second flush should not send anything", func() {
+				a.Flush()
+				a.Flush()
+				So(len(downstream.flows), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I collect flows with different keys", func() {
+			a.CollectFlowEvent(testFlowRecord("source", 80, policy.Reject, 1))
+			a.CollectFlowEvent(testFlowRecord("source", 80, policy.Accept, 1))
+			a.Flush()
+
+			Convey("Then they should be flushed separately", func() {
+				So(len(downstream.flows), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When I collect more distinct flows than the maximum", func() {
+			a.CollectFlowEvent(testFlowRecord("a", 80, policy.Reject, 1))
+			a.CollectFlowEvent(testFlowRecord("b", 80, policy.Reject, 1))
+			a.CollectFlowEvent(testFlowRecord("c", 80, policy.Reject, 1))
+			a.CollectFlowEvent(testFlowRecord("d", 443, policy.Reject, 1))
+			a.CollectFlowEvent(testFlowRecord("a", 80, policy.Reject, 1))
+
+			Convey("Then the new flows should be dropped and counted", func() {
+				So(a.Overflow(), ShouldEqual, 2)
+
+				a.Flush()
+				So(len(downstream.flows), ShouldEqual, 2)
+				total := 0
+				for _, f := range downstream.flows {
+					total += f.Count
+				}
+				So(total, ShouldEqual, 3)
+			})
+
+			Convey("Then new flows should be accepted after the flush", func() {
+				a.Flush()
+				a.CollectFlowEvent(testFlowRecord("c", 80, policy.Reject, 1))
+				a.Flush()
+				So(len(downstream.flows), ShouldEqual, 3)
+			})
+		})
+
+		Convey("When I collect container, user and secrets events", func() {
+			a.CollectContainerEvent(&ContainerRecord{ContextID: "context"})
+			a.CollectUserEvent(&UserRecord{ID: "user"})
+			a.CollectSecretsEvent(&SecretsRecord{Event: SecretsRotated})
+
+			Convey("Then they should be sent downstream directly", func() {
+				So(len(downstream.containers), ShouldEqual, 1)
+				So(len(downstream.users), ShouldEqual, 1)
+				So(len(downstream.secrets), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I collect secrets events for a collector that does not collect them", func() {
+			a := NewAggregator(&flowOnlyCollector{downstream}, nil)
+
+			Convey("Then they should be dropped", func() {
+				So(func() { a.CollectSecretsEvent(&SecretsRecord{Event: SecretsRotated}) }, ShouldNotPanic)
+				So(len(downstream.secrets), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When I run an aggregator with a short window", func() {
+			a := NewAggregator(downstream, &AggregatorConfig{Window: 10 * time.Millisecond})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			So(a.Run(ctx), ShouldBeNil)
+
+			a.CollectFlowEvent(testFlowRecord("source", 80, policy.Reject, 1))
+
+			Convey("Then the flows should be flushed periodically", func() {
+				deadline := time.Now().Add(2 * time.Second)
+				for downstream.flowCount() == 0 && time.Now().Before(deadline) {
+					time.Sleep(5 * time.Millisecond)
+				}
+				So(downstream.flowCount(), ShouldEqual, 1)
+			})
+		})
+	})
+}
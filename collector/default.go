@@ -26,6 +26,9 @@ func (d *DefaultCollector) CollectContainerEvent(record *ContainerRecord) {}
 // CollectUserEvent is part of the EventCollector interface.
 func (d *DefaultCollector) CollectUserEvent(record *UserRecord) {}
 
+// CollectSecretsEvent is part of the SecretsEventCollector interface.
+func (d *DefaultCollector) CollectSecretsEvent(record *SecretsRecord) {}
+
 // StatsFlowHash is a hash function to hash flows
 func StatsFlowHash(r *FlowRecord) string {
 	hash := xxhash.New()
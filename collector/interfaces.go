@@ -2,6 +2,7 @@ package collector
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/aporeto-inc/trireme-lib/policy"
 )
@@ -26,6 +27,12 @@ const (
 	InvalidState = "state"
 	// InvalidNonse indicates that the nonse check failed
 	InvalidNonse = "nonse"
+	// RevokedCredentials indicates that the certificate or the token of the
+	// peer was revoked
+	RevokedCredentials = "revoked"
+	// PinnedKeyChanged indicates that the peer used another key than the
+	// key pinned when it was first seen
+	PinnedKeyChanged = "keychanged"
 	// PolicyDrop indicates that the flow is rejected because of the policy decision
 	PolicyDrop = "policy"
 )
@@ -50,6 +57,22 @@ const (
 	ContainerDeleteUnknown = "unknowncontainer"
 )
 
+// Secrets event description
+const (
+	// SecretsRotated indicates that the secrets were rotated and that the
+	// previous secrets are accepted until the end of the grace period
+	SecretsRotated = "rotated"
+	// SecretsPreviousExpired indicates that the previous secrets of a
+	// rotation are not accepted anymore
+	SecretsPreviousExpired = "previousexpired"
+	// SecretsUpdated indicates that new secrets were loaded and pushed to
+	// the enforcers
+	SecretsUpdated = "updated"
+	// SecretsUpdateFailed indicates that new secrets could not be loaded
+	// or pushed to the enforcers
+	SecretsUpdateFailed = "updatefailed"
+)
+
 const (
 	// PolicyValid Normal flow accept
 	PolicyValid = "V"
@@ -72,6 +95,24 @@ type EventCollector interface {
 	CollectUserEvent(record *UserRecord)
 }
 
+// SecretsEventCollector is implemented by the event collectors that also
+// collect the secrets events. It is optional so that the existing
+// implementations of the EventCollector interface keep working.
+type SecretsEventCollector interface {
+
+	// CollectSecretsEvent collects a secrets event
+	CollectSecretsEvent(record *SecretsRecord)
+}
+
+// CollectSecretsEvent sends the secrets event to the collector if it
+// implements the SecretsEventCollector interface.
+func CollectSecretsEvent(c EventCollector, record *SecretsRecord) {
+
+	if s, ok := c.(SecretsEventCollector); ok {
+		s.CollectSecretsEvent(record)
+	}
+}
+
 // EndPointType is the type of an endpoint (PU or an external IP address )
 type EndPointType byte
 
@@ -154,3 +195,15 @@ type UserRecord struct {
 	ID     string
 	Claims []string
 }
+
+// SecretsRecord reports a change of the secrets used by the enforcers.
+type SecretsRecord struct {
+	Event string
+	// Expiry is the end of the grace period of a rotation
+	Expiry time.Time
+	// PreviousLastUsed is the last time a peer was accepted with the
+	// previous secrets of a rotation. It is zero if they were not used.
+	PreviousLastUsed time.Time
+	// Error describes the failure of a secrets update
+	Error string
+}
@@ -0,0 +1,280 @@
+package ipfix
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+)
+
+const (
+	defaultFlushInterval           = 5 * time.Second
+	defaultTemplateRefreshInterval = 10 * time.Minute
+	defaultMaxMessageSize          = 1400
+	defaultMaxPendingRecords       = 10000
+)
+
+// Config is the configuration of the exporter
+type Config struct {
+	// Address is the address of the IPFIX collector
+	Address string
+	// ObservationDomainID identifies the exporter at the collector
+	ObservationDomainID uint32
+	// EnterpriseNumber is the private enterprise number of the
+	// enterprise-specific information elements
+	EnterpriseNumber uint32
+	// FlushInterval is the interval at which the records are exported
+	FlushInterval time.Duration
+	// TemplateRefreshInterval is the interval at which the templates are
+	// sent again. Collectors forget templates since UDP is unreliable.
+	TemplateRefreshInterval time.Duration
+	// MaxMessageSize is the maximum size of a message. It must fit in the
+	// MTU of the path to the collector.
+	MaxMessageSize int
+	// MaxPendingRecords is the maximum number of records waiting to be
+	// exported. Records are dropped when it is reached.
+	MaxPendingRecords int
+}
+
+// pendingRecord is a data record waiting to be exported
+type pendingRecord struct {
+	template uint16
+	data     []byte
+}
+
+// Exporter is an EventCollector that exports the flow records over IPFIX.
+// Container, user and secrets events are ignored.
+type Exporter struct {
+	config       Config
+	conn         net.Conn
+	templateSet  []byte
+	pending      []*pendingRecord
+	sequence     uint32
+	lastTemplate time.Time
+	dropped      uint64
+	// flushLock serializes the exports
+	flushLock sync.Mutex
+	sync.Mutex
+}
+
+// NewExporter creates a new exporter for the collector at the configured address
+func NewExporter(config *Config) (*Exporter, error) {
+
+	if config == nil || config.Address == "" {
+		return nil, fmt.Errorf("no collector address provided")
+	}
+
+	c := *config
+
+	if c.EnterpriseNumber == 0 {
+		c.EnterpriseNumber = DefaultEnterpriseNumber
+	}
+
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = defaultFlushInterval
+	}
+
+	if c.TemplateRefreshInterval <= 0 {
+		c.TemplateRefreshInterval = defaultTemplateRefreshInterval
+	}
+
+	if c.MaxMessageSize <= 0 {
+		c.MaxMessageSize = defaultMaxMessageSize
+	}
+
+	if c.MaxPendingRecords <= 0 {
+		c.MaxPendingRecords = defaultMaxPendingRecords
+	}
+
+	templateSet := encodeTemplateSet(templates(), c.EnterpriseNumber)
+	if messageHeaderLength+len(templateSet) > c.MaxMessageSize {
+		return nil, fmt.Errorf("max message size %d is too small for the templates", c.MaxMessageSize)
+	}
+
+	conn, err := net.Dial("udp", c.Address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to collector %s: %s", c.Address, err)
+	}
+
+	return &Exporter{
+		config:      c,
+		conn:        conn,
+		templateSet: templateSet,
+	}, nil
+}
+
+// Run exports the pending records periodically until the context is done.
+// The remaining records are exported before it returns.
+func (e *Exporter) Run(ctx context.Context) error {
+
+	go func() {
+		ticker := time.NewTicker(e.config.FlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := e.Flush(); err != nil {
+					zap.L().Warn("Unable to export flow records", zap.Error(err))
+				}
+			case <-ctx.Done():
+				if err := e.Flush(); err != nil {
+					zap.L().Warn("Unable to export flow records", zap.Error(err))
+				}
+				e.conn.Close() // nolint
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// CollectFlowEvent is part of the EventCollector interface.
+func (e *Exporter) CollectFlowEvent(record *collector.FlowRecord) {
+
+	template, data := encodeRecord(record, time.Now())
+
+	e.Lock()
+	defer e.Unlock()
+
+	if len(e.pending) >= e.config.MaxPendingRecords {
+		e.dropped++
+		return
+	}
+
+	e.pending = append(e.pending, &pendingRecord{template: template, data: data})
+}
+
+// CollectContainerEvent is part of the EventCollector interface.
+func (e *Exporter) CollectContainerEvent(record *collector.ContainerRecord) {}
+
+// CollectUserEvent is part of the EventCollector interface.
+func (e *Exporter) CollectUserEvent(record *collector.UserRecord) {}
+
+// Dropped returns the number of records that were dropped because too many
+// records were waiting to be exported
+func (e *Exporter) Dropped() uint64 {
+
+	e.Lock()
+	defer e.Unlock()
+
+	return e.dropped
+}
+
+// Flush exports all the pending records. The templates are sent with the
+// first message and then every template refresh interval.
+func (e *Exporter) Flush() error {
+
+	e.flushLock.Lock()
+	defer e.flushLock.Unlock()
+
+	e.Lock()
+	records := e.pending
+	e.pending = nil
+	e.Unlock()
+
+	now := time.Now()
+	sendTemplates := now.Sub(e.lastTemplate) >= e.config.TemplateRefreshInterval
+
+	if len(records) == 0 && !sendTemplates {
+		return nil
+	}
+
+	for _, msg := range e.messages(records, sendTemplates, now) {
+		if _, err := e.conn.Write(msg); err != nil {
+			return fmt.Errorf("unable to send message: %s", err)
+		}
+	}
+
+	if sendTemplates {
+		e.lastTemplate = now
+	}
+
+	return nil
+}
+
+// messages builds the messages of the records. The records of each
+// template are grouped in the same data set as long as they fit in the
+// message.
+func (e *Exporter) messages(records []*pendingRecord, sendTemplates bool, now time.Time) [][]byte {
+
+	messages := [][]byte{}
+
+	var msg []byte
+	var setStart int
+	var setID uint16
+	var count uint32
+
+	closeSet := func() {
+		if setStart > 0 {
+			binary.BigEndian.PutUint16(msg[setStart+2:setStart+4], uint16(len(msg)-setStart))
+			setStart = 0
+		}
+	}
+
+	closeMessage := func() {
+		closeSet()
+		if len(msg) <= messageHeaderLength {
+			msg = nil
+			return
+		}
+		binary.BigEndian.PutUint16(msg[2:4], uint16(len(msg)))
+		messages = append(messages, msg)
+		e.sequence += count
+		msg = nil
+		count = 0
+	}
+
+	newMessage := func() {
+		msg = make([]byte, messageHeaderLength)
+		binary.BigEndian.PutUint16(msg[0:2], Version)
+		binary.BigEndian.PutUint32(msg[4:8], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(msg[8:12], e.sequence)
+		binary.BigEndian.PutUint32(msg[12:16], e.config.ObservationDomainID)
+		setID = 0
+	}
+
+	newMessage()
+	if sendTemplates {
+		msg = append(msg, e.templateSet...)
+	}
+
+	for _, r := range records {
+
+		size := len(r.data)
+		if r.template != setID {
+			size += setHeaderLength
+		}
+
+		if len(msg)+size > e.config.MaxMessageSize {
+			if messageHeaderLength+setHeaderLength+len(r.data) > e.config.MaxMessageSize {
+				zap.L().Warn("Dropping flow record larger than the max message size", zap.Int("size", len(r.data)))
+				continue
+			}
+			closeMessage()
+			newMessage()
+		}
+
+		if r.template != setID {
+			closeSet()
+			setStart = len(msg)
+			setID = r.template
+			msg = appendUint16(msg, setID)
+			msg = appendUint16(msg, 0)
+		}
+
+		msg = append(msg, r.data...)
+		count++
+	}
+
+	closeMessage()
+
+	return messages
+}
@@ -0,0 +1,283 @@
+// Package ipfix implements an EventCollector that exports flow records to an
+// IPFIX collector over UDP (RFC 7011). The fields of the flow records that
+// have no IANA information element are exported with enterprise-specific
+// information elements.
+package ipfix
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+)
+
+const (
+	// Version is the version of the IPFIX protocol
+	Version = 10
+
+	// TemplateSetID is the set ID of template sets
+	TemplateSetID = 2
+
+	// TemplateIDIPv4 is the template of flows between IPv4 endpoints
+	TemplateIDIPv4 = 256
+	// TemplateIDIPv6 is the template of flows between IPv6 endpoints
+	TemplateIDIPv6 = 257
+
+	// DefaultEnterpriseNumber is the private enterprise number used for the
+	// enterprise-specific information elements. It is the number reserved
+	// for documentation by RFC 5612 and should be overridden.
+	DefaultEnterpriseNumber = 32473
+
+	// VariableLength is the length of variable length information elements
+	VariableLength = 65535
+
+	messageHeaderLength = 16
+	setHeaderLength     = 4
+	enterpriseBit       = 0x8000
+)
+
+// IANA information elements
+const (
+	IEDeltaFlowCount           = 3
+	IEProtocolIdentifier       = 4
+	IESourceTransportPort      = 7
+	IESourceIPv4Address        = 8
+	IEDestinationTransportPort = 11
+	IEDestinationIPv4Address   = 12
+	IESourceIPv6Address        = 27
+	IEDestinationIPv6Address   = 28
+	IEForwardingStatus         = 89
+	IEFlowEndMilliseconds      = 153
+)
+
+// Enterprise-specific information elements
+const (
+	IEContextID        = 1
+	IESourceID         = 2
+	IEDestinationID    = 3
+	IEPolicyID         = 4
+	IEObservedPolicyID = 5
+	IEDropReason       = 6
+	IETags             = 7
+	IEAction           = 8
+	IEObservedAction   = 9
+	IEDestinationURI   = 10
+)
+
+// Forwarding status values (RFC 7270)
+const (
+	ForwardingStatusForwarded = 64
+	ForwardingStatusDropped   = 128
+	ForwardingStatusACLDeny   = 129
+)
+
+// field is an information element of a template
+type field struct {
+	id         uint16
+	length     uint16
+	enterprise bool
+}
+
+// template is the list of fields of a template
+type template struct {
+	id     uint16
+	fields []field
+}
+
+// templates returns the templates of the exporter
+func templates() []*template {
+
+	common := []field{
+		{id: IEProtocolIdentifier, length: 1},
+		{id: IESourceTransportPort, length: 2},
+		{id: IEDestinationTransportPort, length: 2},
+		{id: IEDeltaFlowCount, length: 8},
+		{id: IEForwardingStatus, length: 1},
+		{id: IEFlowEndMilliseconds, length: 8},
+		{id: IEContextID, length: VariableLength, enterprise: true},
+		{id: IESourceID, length: VariableLength, enterprise: true},
+		{id: IEDestinationID, length: VariableLength, enterprise: true},
+		{id: IEPolicyID, length: VariableLength, enterprise: true},
+		{id: IEObservedPolicyID, length: VariableLength, enterprise: true},
+		{id: IEDropReason, length: VariableLength, enterprise: true},
+		{id: IETags, length: VariableLength, enterprise: true},
+		{id: IEAction, length: VariableLength, enterprise: true},
+		{id: IEObservedAction, length: VariableLength, enterprise: true},
+		{id: IEDestinationURI, length: VariableLength, enterprise: true},
+	}
+
+	v4 := append([]field{
+		{id: IESourceIPv4Address, length: net.IPv4len},
+		{id: IEDestinationIPv4Address, length: net.IPv4len},
+	}, common...)
+
+	v6 := append([]field{
+		{id: IESourceIPv6Address, length: net.IPv6len},
+		{id: IEDestinationIPv6Address, length: net.IPv6len},
+	}, common...)
+
+	return []*template{
+		{id: TemplateIDIPv4, fields: v4},
+		{id: TemplateIDIPv6, fields: v6},
+	}
+}
+
+// encodeTemplateSet encodes the template set of the templates
+func encodeTemplateSet(templates []*template, enterpriseNumber uint32) []byte {
+
+	b := make([]byte, setHeaderLength)
+
+	for _, t := range templates {
+		b = appendUint16(b, t.id)
+		b = appendUint16(b, uint16(len(t.fields)))
+		for _, f := range t.fields {
+			if !f.enterprise {
+				b = appendUint16(b, f.id)
+				b = appendUint16(b, f.length)
+				continue
+			}
+			b = appendUint16(b, f.id|enterpriseBit)
+			b = appendUint16(b, f.length)
+			b = appendUint32(b, enterpriseNumber)
+		}
+	}
+
+	binary.BigEndian.PutUint16(b[0:2], TemplateSetID)
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+
+	return b
+}
+
+// encodeRecord encodes a flow record as a data record. It returns the
+// template of the record.
+func encodeRecord(r *collector.FlowRecord, now time.Time) (uint16, []byte) {
+
+	src, dst := &collector.EndPoint{}, &collector.EndPoint{}
+	if r.Source != nil {
+		src = r.Source
+	}
+	if r.Destination != nil {
+		dst = r.Destination
+	}
+
+	srcIP := net.ParseIP(src.IP)
+	dstIP := net.ParseIP(dst.IP)
+
+	id := uint16(TemplateIDIPv4)
+	b := []byte{}
+
+	if (srcIP != nil && srcIP.To4() == nil) || (dstIP != nil && dstIP.To4() == nil) {
+		id = TemplateIDIPv6
+		b = append(b, ipBytes(srcIP, net.IPv6len)...)
+		b = append(b, ipBytes(dstIP, net.IPv6len)...)
+	} else {
+		b = append(b, ipBytes(srcIP, net.IPv4len)...)
+		b = append(b, ipBytes(dstIP, net.IPv4len)...)
+	}
+
+	b = append(b, r.L4Protocol)
+	b = appendUint16(b, src.Port)
+	b = appendUint16(b, dst.Port)
+	b = appendUint64(b, uint64(flowCount(r)))
+	b = append(b, forwardingStatus(r))
+	b = appendUint64(b, uint64(now.UnixNano()/int64(time.Millisecond)))
+
+	tags := ""
+	if r.Tags != nil {
+		tags = strings.Join(r.Tags.GetSlice(), ",")
+	}
+
+	observedAction := ""
+	if r.ObservedAction != 0 {
+		observedAction = r.ObservedAction.String()
+	}
+
+	for _, s := range []string{
+		r.ContextID,
+		src.ID,
+		dst.ID,
+		r.PolicyID,
+		r.ObservedPolicyID,
+		r.DropReason,
+		tags,
+		r.Action.String(),
+		observedAction,
+		dst.URI,
+	} {
+		b = appendString(b, s)
+	}
+
+	return id, b
+}
+
+// flowCount returns the number of flows of a record. Records that are
+// reported only once have no count.
+func flowCount(r *collector.FlowRecord) int {
+	if r.Count <= 0 {
+		return 1
+	}
+	return r.Count
+}
+
+// forwardingStatus returns the forwarding status of a record
+func forwardingStatus(r *collector.FlowRecord) byte {
+
+	if !r.Action.Rejected() {
+		return ForwardingStatusForwarded
+	}
+
+	if r.DropReason == collector.PolicyDrop {
+		return ForwardingStatusACLDeny
+	}
+
+	return ForwardingStatusDropped
+}
+
+// ipBytes returns the address with the given length. Invalid addresses are
+// encoded as the unspecified address.
+func ipBytes(ip net.IP, length int) []byte {
+
+	if length == net.IPv4len {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4
+		}
+		return make([]byte, net.IPv4len)
+	}
+
+	if ip16 := ip.To16(); ip16 != nil {
+		return ip16
+	}
+
+	return make([]byte, net.IPv6len)
+}
+
+// appendString appends a variable length string (RFC 7011 section 7)
+func appendString(b []byte, s string) []byte {
+
+	if len(s) > VariableLength {
+		s = s[:VariableLength]
+	}
+
+	if len(s) < 255 {
+		b = append(b, byte(len(s)))
+	} else {
+		b = append(b, 255)
+		b = appendUint16(b, uint16(len(s)))
+	}
+
+	return append(b, s...)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
@@ -0,0 +1,252 @@
+package ipfix
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testSet is a set of a decoded message
+type testSet struct {
+	id   uint16
+	data []byte
+}
+
+// testMessage is a decoded message
+type testMessage struct {
+	version  uint16
+	length   uint16
+	sequence uint32
+	domain   uint32
+	sets     []testSet
+}
+
+func decodeMessage(b []byte) *testMessage {
+
+	m := &testMessage{
+		version:  binary.BigEndian.Uint16(b[0:2]),
+		length:   binary.BigEndian.Uint16(b[2:4]),
+		sequence: binary.BigEndian.Uint32(b[8:12]),
+		domain:   binary.BigEndian.Uint32(b[12:16]),
+	}
+
+	for i := messageHeaderLength; i < len(b); {
+		length := int(binary.BigEndian.Uint16(b[i+2 : i+4]))
+		m.sets = append(m.sets, testSet{
+			id:   binary.BigEndian.Uint16(b[i : i+2]),
+			data: b[i+setHeaderLength : i+length],
+		})
+		i += length
+	}
+
+	return m
+}
+
+// decodeStrings returns the variable length strings at the end of an IPv4 data record
+func decodeStrings(b []byte) ([]string, []byte) {
+
+	strs := []string{}
+	for len(strs) < 10 {
+		l := int(b[0])
+		b = b[1:]
+		if l == 255 {
+			l = int(binary.BigEndian.Uint16(b[0:2]))
+			b = b[2:]
+		}
+		strs = append(strs, string(b[:l]))
+		b = b[l:]
+	}
+
+	return strs, b
+}
+
+func receive(conn *net.UDPConn) []byte {
+	buf := make([]byte, 65535)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second)) // nolint
+	n, _, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		return nil
+	}
+	return buf[:n]
+}
+
+func testRecord(srcIP, dstIP string) *collector.FlowRecord {
+	return &collector.FlowRecord{
+		ContextID: "context",
+		Source: &collector.EndPoint{
+			ID:   "source",
+			IP:   srcIP,
+			Port: 3000,
+		},
+		Destination: &collector.EndPoint{
+			ID:   "destination",
+			IP:   dstIP,
+			Port: 80,
+		},
+		Tags:       policy.NewTagStoreFromSlice([]string{"app=web", "env=prod"}),
+		DropReason: collector.PolicyDrop,
+		PolicyID:   "policy",
+		Count:      3,
+		Action:     policy.Reject,
+		L4Protocol: 6,
+	}
+}
+
+func TestExporter(t *testing.T) {
+
+	Convey("Given a local IPFIX collector", t, func() {
+
+		listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+		So(err, ShouldBeNil)
+		defer listener.Close() // nolint
+
+		Convey("When I create an exporter without an address", func() {
+			_, err := NewExporter(&Config{})
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I create an exporter with a message size smaller than the templates", func() {
+			_, err := NewExporter(&Config{Address: listener.LocalAddr().String(), MaxMessageSize: 100})
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I export a flow record", func() {
+			e, err := NewExporter(&Config{
+				Address:             listener.LocalAddr().String(),
+				ObservationDomainID: 42,
+			})
+			So(err, ShouldBeNil)
+
+			e.CollectFlowEvent(testRecord("10.1.1.1", "10.1.1.2"))
+			So(e.Flush(), ShouldBeNil)
+
+			m := decodeMessage(receive(listener))
+
+			Convey("Then the message should carry the templates and the record", func() {
+				So(m.version, ShouldEqual, Version)
+				So(m.domain, ShouldEqual, 42)
+				So(m.sequence, ShouldEqual, 0)
+				So(len(m.sets), ShouldEqual, 2)
+				So(m.sets[0].id, ShouldEqual, TemplateSetID)
+				So(m.sets[1].id, ShouldEqual, TemplateIDIPv4)
+
+				// The first template is the IPv4 template with its enterprise fields
+				templates := m.sets[0].data
+				So(binary.BigEndian.Uint16(templates[0:2]), ShouldEqual, TemplateIDIPv4)
+				So(binary.BigEndian.Uint16(templates[2:4]), ShouldEqual, 18)
+				So(binary.BigEndian.Uint16(templates[4:6]), ShouldEqual, IESourceIPv4Address)
+				contextField := templates[4+8*4:]
+				So(binary.BigEndian.Uint16(contextField[0:2]), ShouldEqual, IEContextID|enterpriseBit)
+				So(binary.BigEndian.Uint16(contextField[2:4]), ShouldEqual, VariableLength)
+				So(binary.BigEndian.Uint32(contextField[4:8]), ShouldEqual, DefaultEnterpriseNumber)
+
+				data := m.sets[1].data
+				So(net.IP(data[0:4]).String(), ShouldEqual, "10.1.1.1")
+				So(net.IP(data[4:8]).String(), ShouldEqual, "10.1.1.2")
+				So(data[8], ShouldEqual, 6)
+				So(binary.BigEndian.Uint16(data[9:11]), ShouldEqual, 3000)
+				So(binary.BigEndian.Uint16(data[11:13]), ShouldEqual, 80)
+				So(binary.BigEndian.Uint64(data[13:21]), ShouldEqual, 3)
+				So(data[21], ShouldEqual, ForwardingStatusACLDeny)
+
+				strs, rest := decodeStrings(data[30:])
+				So(strs, ShouldResemble, []string{"context", "source", "destination", "policy", "", "policy", "app=web,env=prod", policy.Reject.String(), "", ""})
+				So(rest, ShouldBeEmpty)
+			})
+
+			Convey("Then the templates should not be sent with the next records", func() {
+				e.CollectFlowEvent(testRecord("2001:db8::1", "2001:db8::2"))
+				So(e.Flush(), ShouldBeNil)
+
+				m := decodeMessage(receive(listener))
+				So(m.sequence, ShouldEqual, 1)
+				So(len(m.sets), ShouldEqual, 1)
+				So(m.sets[0].id, ShouldEqual, TemplateIDIPv6)
+				So(net.IP(m.sets[0].data[0:16]).String(), ShouldEqual, "2001:db8::1")
+			})
+		})
+
+		Convey("When I export more records than fit in a message", func() {
+			e, err := NewExporter(&Config{
+				Address:        listener.LocalAddr().String(),
+				MaxMessageSize: 512,
+			})
+			So(err, ShouldBeNil)
+
+			for i := 0; i < 10; i++ {
+				e.CollectFlowEvent(testRecord("10.1.1.1", "10.1.1.2"))
+			}
+			So(e.Flush(), ShouldBeNil)
+
+			Convey("Then they should be split in several messages", func() {
+				records := 0
+				for records < 10 {
+					b := receive(listener)
+					So(b, ShouldNotBeNil)
+					So(len(b), ShouldBeLessThanOrEqualTo, 512)
+
+					m := decodeMessage(b)
+					So(m.length, ShouldEqual, len(b))
+					So(m.sequence, ShouldEqual, records)
+
+					for _, s := range m.sets {
+						if s.id == TemplateIDIPv4 {
+							for rest := s.data; len(rest) > 0; records++ {
+								_, rest = decodeStrings(rest[30:])
+							}
+						}
+					}
+				}
+				So(records, ShouldEqual, 10)
+			})
+		})
+
+		Convey("When too many records are pending", func() {
+			e, err := NewExporter(&Config{
+				Address:           listener.LocalAddr().String(),
+				MaxPendingRecords: 2,
+			})
+			So(err, ShouldBeNil)
+
+			for i := 0; i < 5; i++ {
+				e.CollectFlowEvent(testRecord("10.1.1.1", "10.1.1.2"))
+			}
+
+			Convey("Then the extra records should be dropped", func() {
+				So(e.Dropped(), ShouldEqual, 3)
+			})
+		})
+
+		Convey("When I run the exporter", func() {
+			e, err := NewExporter(&Config{
+				Address:       listener.LocalAddr().String(),
+				FlushInterval: 10 * time.Millisecond,
+			})
+			So(err, ShouldBeNil)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			So(e.Run(ctx), ShouldBeNil)
+
+			e.CollectFlowEvent(testRecord("10.1.1.1", "10.1.1.2"))
+
+			Convey("Then the records should be exported periodically", func() {
+				b := receive(listener)
+				So(b, ShouldNotBeNil)
+				So(decodeMessage(b).version, ShouldEqual, Version)
+			})
+		})
+	})
+}
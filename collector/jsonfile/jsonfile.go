@@ -0,0 +1,284 @@
+// Package jsonfile implements an EventCollector that writes the events as
+// JSON lines to a file. The file is rotated when it reaches a maximum size
+// or age, and the rotated files can be compressed.
+package jsonfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// Event types
+const (
+	EventFlow      = "flow"
+	EventContainer = "container"
+	EventUser      = "user"
+	EventSecrets   = "secrets"
+)
+
+// Config is the configuration of the collector
+type Config struct {
+	// Path is the path of the file
+	Path string
+	// MaxSize is the size in bytes above which the file is rotated. The
+	// file is not rotated on size if it is 0.
+	MaxSize int64
+	// MaxAge is the age after which the file is rotated. The file is not
+	// rotated on age if it is 0.
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files to keep. All the rotated
+	// files are kept if it is 0.
+	MaxBackups int
+	// Compress compresses the rotated files with gzip
+	Compress bool
+	// AcceptedFlowSampling writes one accepted flow out of every
+	// AcceptedFlowSampling accepted flows. All the accepted flows are
+	// written if it is 0 or 1. Rejected flows are never sampled.
+	AcceptedFlowSampling int
+}
+
+// Event is a line of the file
+type Event struct {
+	Time      time.Time       `json:"time"`
+	Type      string          `json:"type"`
+	Flow      *FlowEvent      `json:"flow,omitempty"`
+	Container *ContainerEvent `json:"container,omitempty"`
+	User      *UserEvent      `json:"user,omitempty"`
+	Secrets   *SecretsEvent   `json:"secrets,omitempty"`
+}
+
+// EndPoint is an endpoint of a flow event
+type EndPoint struct {
+	ID         string `json:"id,omitempty"`
+	IP         string `json:"ip,omitempty"`
+	Port       uint16 `json:"port,omitempty"`
+	URI        string `json:"uri,omitempty"`
+	HTTPMethod string `json:"httpMethod,omitempty"`
+	UserID     string `json:"userID,omitempty"`
+	Type       string `json:"type"`
+}
+
+// FlowEvent is the description of a collector.FlowRecord
+type FlowEvent struct {
+	ContextID        string    `json:"contextID"`
+	Source           *EndPoint `json:"source,omitempty"`
+	Destination      *EndPoint `json:"destination,omitempty"`
+	Tags             []string  `json:"tags,omitempty"`
+	Action           string    `json:"action"`
+	DropReason       string    `json:"dropReason,omitempty"`
+	PolicyID         string    `json:"policyID,omitempty"`
+	ObservedAction   string    `json:"observedAction,omitempty"`
+	ObservedPolicyID string    `json:"observedPolicyID,omitempty"`
+	ServiceType      string    `json:"serviceType,omitempty"`
+	ServiceID        string    `json:"serviceID,omitempty"`
+	Count            int       `json:"count"`
+	L4Protocol       uint8     `json:"l4Protocol,omitempty"`
+	// SampleRate is the number of accepted flows represented by this event
+	SampleRate int `json:"sampleRate,omitempty"`
+}
+
+// ContainerEvent is the description of a collector.ContainerRecord
+type ContainerEvent struct {
+	ContextID string            `json:"contextID"`
+	Event     string            `json:"event"`
+	IPAddress map[string]string `json:"ipAddresses,omitempty"`
+	Tags      []string          `json:"tags,omitempty"`
+}
+
+// UserEvent is the description of a collector.UserRecord
+type UserEvent struct {
+	ID     string   `json:"id"`
+	Claims []string `json:"claims,omitempty"`
+}
+
+// SecretsEvent is the description of a collector.SecretsRecord
+type SecretsEvent struct {
+	Event            string     `json:"event"`
+	Expiry           *time.Time `json:"expiry,omitempty"`
+	PreviousLastUsed *time.Time `json:"previousLastUsed,omitempty"`
+	Error            string     `json:"error,omitempty"`
+}
+
+// Collector is an EventCollector that writes the events to a file
+type Collector struct {
+	writer   *rotatingWriter
+	sampling int
+	accepted uint64
+	sync.Mutex
+}
+
+// NewCollector creates a new collector that writes to the configured file
+func NewCollector(config *Config) (*Collector, error) {
+
+	if config == nil || config.Path == "" {
+		return nil, fmt.Errorf("no file path provided")
+	}
+
+	if config.MaxSize < 0 || config.MaxAge < 0 || config.MaxBackups < 0 || config.AcceptedFlowSampling < 0 {
+		return nil, fmt.Errorf("invalid negative configuration for %s", config.Path)
+	}
+
+	writer, err := newRotatingWriter(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Collector{
+		writer:   writer,
+		sampling: config.AcceptedFlowSampling,
+	}, nil
+}
+
+// CollectFlowEvent is part of the EventCollector interface.
+func (c *Collector) CollectFlowEvent(record *collector.FlowRecord) {
+
+	event := flowEvent(record)
+
+	if record.Action.Accepted() && c.sampling > 1 {
+		c.Lock()
+		c.accepted++
+		sampled := (c.accepted-1)%uint64(c.sampling) == 0
+		c.Unlock()
+
+		if !sampled {
+			return
+		}
+		event.SampleRate = c.sampling
+	}
+
+	c.write(&Event{Type: EventFlow, Flow: event})
+}
+
+// CollectContainerEvent is part of the EventCollector interface.
+func (c *Collector) CollectContainerEvent(record *collector.ContainerRecord) {
+
+	event := &ContainerEvent{
+		ContextID: record.ContextID,
+		Event:     record.Event,
+		IPAddress: record.IPAddress,
+	}
+
+	if record.Tags != nil {
+		event.Tags = record.Tags.GetSlice()
+	}
+
+	c.write(&Event{Type: EventContainer, Container: event})
+}
+
+// CollectUserEvent is part of the EventCollector interface.
+func (c *Collector) CollectUserEvent(record *collector.UserRecord) {
+
+	c.write(&Event{
+		Type: EventUser,
+		User: &UserEvent{
+			ID:     record.ID,
+			Claims: record.Claims,
+		},
+	})
+}
+
+// CollectSecretsEvent is part of the SecretsEventCollector interface.
+func (c *Collector) CollectSecretsEvent(record *collector.SecretsRecord) {
+
+	event := &SecretsEvent{
+		Event: record.Event,
+		Error: record.Error,
+	}
+
+	if !record.Expiry.IsZero() {
+		event.Expiry = &record.Expiry
+	}
+
+	if !record.PreviousLastUsed.IsZero() {
+		event.PreviousLastUsed = &record.PreviousLastUsed
+	}
+
+	c.write(&Event{Type: EventSecrets, Secrets: event})
+}
+
+// Close closes the file. The events collected afterwards are discarded.
+func (c *Collector) Close() error {
+
+	c.Lock()
+	defer c.Unlock()
+
+	return c.writer.close()
+}
+
+// write writes an event as a line of the file
+func (c *Collector) write(event *Event) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	event.Time = c.writer.now().UTC()
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		zap.L().Warn("Unable to encode event", zap.String("type", event.Type), zap.Error(err))
+		return
+	}
+
+	if err := c.writer.write(append(line, '\n')); err != nil {
+		zap.L().Warn("Unable to write event", zap.String("type", event.Type), zap.Error(err))
+	}
+}
+
+// flowEvent returns the description of a flow record
+func flowEvent(record *collector.FlowRecord) *FlowEvent {
+
+	event := &FlowEvent{
+		ContextID:        record.ContextID,
+		Source:           endPoint(record.Source),
+		Destination:      endPoint(record.Destination),
+		Action:           record.Action.ActionString(),
+		DropReason:       record.DropReason,
+		PolicyID:         record.PolicyID,
+		ObservedPolicyID: record.ObservedPolicyID,
+		ServiceID:        record.ServiceID,
+		Count:            record.Count,
+		L4Protocol:       record.L4Protocol,
+	}
+
+	if record.Tags != nil {
+		event.Tags = record.Tags.GetSlice()
+	}
+
+	if record.ObservedAction != 0 {
+		event.ObservedAction = record.ObservedAction.ActionString()
+	}
+
+	switch record.ServiceType {
+	case policy.ServiceHTTP:
+		event.ServiceType = "http"
+	case policy.ServiceTCP:
+		event.ServiceType = "tcp"
+	}
+
+	return event
+}
+
+// endPoint returns the description of an endpoint
+func endPoint(e *collector.EndPoint) *EndPoint {
+
+	if e == nil {
+		return nil
+	}
+
+	return &EndPoint{
+		ID:         e.ID,
+		IP:         e.IP,
+		Port:       e.Port,
+		URI:        e.URI,
+		HTTPMethod: e.HTTPMethod,
+		UserID:     e.UserID,
+		Type:       e.Type.String(),
+	}
+}
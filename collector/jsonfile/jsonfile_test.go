@@ -0,0 +1,241 @@
+package jsonfile
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testFlow(action policy.ActionType) *collector.FlowRecord {
+	return &collector.FlowRecord{
+		ContextID: "context",
+		Source: &collector.EndPoint{
+			ID:   "source",
+			IP:   "10.1.1.1",
+			Type: collector.EnpointTypePU,
+		},
+		Destination: &collector.EndPoint{
+			ID:   "destination",
+			IP:   "10.1.1.2",
+			Port: 80,
+			Type: collector.EndPointTypeExteranlIPAddress,
+		},
+		Tags:        policy.NewTagStoreFromSlice([]string{"app=web"}),
+		Action:      action,
+		PolicyID:    "policy",
+		ServiceType: policy.ServiceHTTP,
+		Count:       1,
+		L4Protocol:  6,
+	}
+}
+
+func readEvents(r io.Reader) []*Event {
+
+	events := []*Event{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		event := &Event{}
+		So(json.Unmarshal(scanner.Bytes(), event), ShouldBeNil)
+		events = append(events, event)
+	}
+
+	return events
+}
+
+func readFile(path string) []*Event {
+
+	f, err := os.Open(path)
+	So(err, ShouldBeNil)
+	defer f.Close() // nolint
+
+	return readEvents(f)
+}
+
+func readGzipFile(path string) []*Event {
+
+	f, err := os.Open(path)
+	So(err, ShouldBeNil)
+	defer f.Close() // nolint
+
+	gz, err := gzip.NewReader(f)
+	So(err, ShouldBeNil)
+
+	return readEvents(gz)
+}
+
+func rotatedFiles(path string) []string {
+	files, err := filepath.Glob(path + ".*")
+	So(err, ShouldBeNil)
+	sort.Strings(files)
+	return files
+}
+
+func TestCollector(t *testing.T) {
+
+	Convey("Given a temporary directory", t, func() {
+
+		dir, err := ioutil.TempDir("", "jsonfile")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		path := filepath.Join(dir, "events", "audit.log")
+
+		Convey("When I create a collector without a path", func() {
+			_, err := NewCollector(&Config{})
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I create a collector with a negative configuration", func() {
+			_, err := NewCollector(&Config{Path: path, MaxSize: -1})
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I collect events", func() {
+			c, err := NewCollector(&Config{Path: path})
+			So(err, ShouldBeNil)
+
+			c.CollectFlowEvent(testFlow(policy.Reject))
+			c.CollectContainerEvent(&collector.ContainerRecord{
+				ContextID: "context",
+				Event:     collector.ContainerStart,
+				IPAddress: policy.ExtendedMap{"bridge": "10.1.1.1"},
+				Tags:      policy.NewTagStoreFromSlice([]string{"app=web"}),
+			})
+			c.CollectUserEvent(&collector.UserRecord{ID: "user", Claims: []string{"sub=alice"}})
+			c.CollectSecretsEvent(&collector.SecretsRecord{Event: collector.SecretsPreviousExpired})
+			So(c.Close(), ShouldBeNil)
+
+			Convey("Then they should be written as JSON lines", func() {
+				events := readFile(path)
+				So(len(events), ShouldEqual, 4)
+
+				So(events[0].Type, ShouldEqual, EventFlow)
+				So(events[0].Time.IsZero(), ShouldBeFalse)
+				So(events[0].Flow, ShouldResemble, &FlowEvent{
+					ContextID:   "context",
+					Source:      &EndPoint{ID: "source", IP: "10.1.1.1", Type: "pu"},
+					Destination: &EndPoint{ID: "destination", IP: "10.1.1.2", Port: 80, Type: "ext"},
+					Tags:        []string{"app=web"},
+					Action:      "reject",
+					PolicyID:    "policy",
+					ServiceType: "http",
+					Count:       1,
+					L4Protocol:  6,
+				})
+
+				So(events[1].Type, ShouldEqual, EventContainer)
+				So(events[1].Container, ShouldResemble, &ContainerEvent{
+					ContextID: "context",
+					Event:     collector.ContainerStart,
+					IPAddress: map[string]string{"bridge": "10.1.1.1"},
+					Tags:      []string{"app=web"},
+				})
+
+				So(events[2].Type, ShouldEqual, EventUser)
+				So(events[2].User, ShouldResemble, &UserEvent{ID: "user", Claims: []string{"sub=alice"}})
+
+				So(events[3].Type, ShouldEqual, EventSecrets)
+				So(events[3].Secrets, ShouldResemble, &SecretsEvent{Event: collector.SecretsPreviousExpired})
+			})
+
+			Convey("Then the events collected after closing should be discarded", func() {
+				c.CollectUserEvent(&collector.UserRecord{ID: "user"})
+				So(len(readFile(path)), ShouldEqual, 4)
+			})
+
+			Convey("Then a new collector should append to the file", func() {
+				c, err := NewCollector(&Config{Path: path})
+				So(err, ShouldBeNil)
+				c.CollectUserEvent(&collector.UserRecord{ID: "user"})
+				So(c.Close(), ShouldBeNil)
+
+				So(len(readFile(path)), ShouldEqual, 5)
+			})
+		})
+
+		Convey("When I sample the accepted flows", func() {
+			c, err := NewCollector(&Config{Path: path, AcceptedFlowSampling: 3})
+			So(err, ShouldBeNil)
+
+			for i := 0; i < 7; i++ {
+				c.CollectFlowEvent(testFlow(policy.Accept))
+			}
+			c.CollectFlowEvent(testFlow(policy.Reject))
+			So(c.Close(), ShouldBeNil)
+
+			Convey("Then one out of every three accepted flows and all the rejected flows should be written", func() {
+				events := readFile(path)
+				So(len(events), ShouldEqual, 4)
+				for _, e := range events[:3] {
+					So(e.Flow.Action, ShouldEqual, "accept")
+					So(e.Flow.SampleRate, ShouldEqual, 3)
+				}
+				So(events[3].Flow.Action, ShouldEqual, "reject")
+				So(events[3].Flow.SampleRate, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the file reaches the max size", func() {
+			c, err := NewCollector(&Config{Path: path, MaxSize: 1024, MaxBackups: 2})
+			So(err, ShouldBeNil)
+
+			for i := 0; i < 20; i++ {
+				c.CollectFlowEvent(testFlow(policy.Reject))
+			}
+			So(c.Close(), ShouldBeNil)
+
+			Convey("Then it should be rotated and the oldest backups removed", func() {
+				rotated := rotatedFiles(path)
+				So(len(rotated), ShouldEqual, 2)
+
+				for _, f := range append(rotated, path) {
+					info, err := os.Stat(f)
+					So(err, ShouldBeNil)
+					So(info.Size(), ShouldBeLessThanOrEqualTo, 1024)
+					So(len(readFile(f)), ShouldBeGreaterThan, 0)
+				}
+			})
+		})
+
+		Convey("When the file reaches the max age and compression is enabled", func() {
+			c, err := NewCollector(&Config{Path: path, MaxAge: time.Hour, Compress: true})
+			So(err, ShouldBeNil)
+
+			now := time.Now()
+			c.writer.now = func() time.Time { return now }
+
+			c.CollectFlowEvent(testFlow(policy.Reject))
+			c.CollectFlowEvent(testFlow(policy.Reject))
+
+			now = now.Add(time.Hour)
+			c.CollectFlowEvent(testFlow(policy.Reject))
+			So(c.Close(), ShouldBeNil)
+
+			Convey("Then it should be rotated and compressed", func() {
+				rotated := rotatedFiles(path)
+				So(len(rotated), ShouldEqual, 1)
+				So(filepath.Ext(rotated[0]), ShouldEqual, gzipExtension)
+				So(len(readGzipFile(rotated[0])), ShouldEqual, 2)
+				So(len(readFile(path)), ShouldEqual, 1)
+			})
+		})
+	})
+}
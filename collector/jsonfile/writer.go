@@ -0,0 +1,234 @@
+package jsonfile
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// rotatedTimeFormat is the format of the timestamp appended to rotated files
+const rotatedTimeFormat = "20060102T150405.000000000"
+
+// gzipExtension is the extension of the compressed rotated files
+const gzipExtension = ".gz"
+
+// rotatingWriter writes lines to a file and rotates it when it is too big
+// or too old. It is not safe for concurrent use.
+type rotatingWriter struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	// compressions tracks the rotated files being compressed
+	compressions sync.WaitGroup
+
+	// now is replaced in the tests
+	now func() time.Time
+}
+
+// newRotatingWriter opens the file at path. Lines are appended to an
+// existing file.
+func newRotatingWriter(config *Config) (*rotatingWriter, error) {
+
+	w := &rotatingWriter{
+		path:       config.Path,
+		maxSize:    config.MaxSize,
+		maxAge:     config.MaxAge,
+		maxBackups: config.MaxBackups,
+		compress:   config.Compress,
+		now:        time.Now,
+	}
+
+	if err := os.MkdirAll(filepath.Dir(w.path), 0755); err != nil {
+		return nil, fmt.Errorf("unable to create directory of %s: %s", w.path, err)
+	}
+
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// open opens the file at path
+func (w *rotatingWriter) open() error {
+
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %s", w.path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close() // nolint
+		return fmt.Errorf("unable to stat %s: %s", w.path, err)
+	}
+
+	w.file = file
+	w.size = info.Size()
+	w.opened = w.now()
+
+	return nil
+}
+
+// write writes a line to the file. The file is rotated first if the line
+// does not fit in it or if it is too old. A line larger than the max size
+// is written to an empty file.
+func (w *rotatingWriter) write(line []byte) error {
+
+	if w.file == nil {
+		return fmt.Errorf("%s is closed", w.path)
+	}
+
+	if w.size > 0 && w.shouldRotate(int64(len(line))) {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.file.Write(line)
+	w.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("unable to write to %s: %s", w.path, err)
+	}
+
+	return nil
+}
+
+// shouldRotate returns true if the file must be rotated before writing
+// length bytes
+func (w *rotatingWriter) shouldRotate(length int64) bool {
+
+	if w.maxSize > 0 && w.size+length > w.maxSize {
+		return true
+	}
+
+	return w.maxAge > 0 && w.now().Sub(w.opened) >= w.maxAge
+}
+
+// rotate renames the file with a timestamp and opens a new one
+func (w *rotatingWriter) rotate() error {
+
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("unable to close %s: %s", w.path, err)
+	}
+	w.file = nil
+
+	rotated := w.path + "." + w.now().UTC().Format(rotatedTimeFormat)
+	if err := os.Rename(w.path, rotated); err != nil {
+		return fmt.Errorf("unable to rotate %s: %s", w.path, err)
+	}
+
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	if !w.compress {
+		w.removeBackups()
+		return nil
+	}
+
+	w.compressions.Add(1)
+	go func() {
+		defer w.compressions.Done()
+		if err := compressFile(rotated); err != nil {
+			zap.L().Warn("Unable to compress rotated file", zap.String("file", rotated), zap.Error(err))
+		}
+		w.removeBackups()
+	}()
+
+	return nil
+}
+
+// removeBackups removes the oldest rotated files above the max number of
+// backups
+func (w *rotatingWriter) removeBackups() {
+
+	if w.maxBackups <= 0 {
+		return
+	}
+
+	backups, err := filepath.Glob(w.path + ".*")
+	if err != nil {
+		return
+	}
+
+	// Files that are being compressed are not backups yet
+	complete := []string{}
+	for _, b := range backups {
+		if w.compress && !strings.HasSuffix(b, gzipExtension) {
+			continue
+		}
+		complete = append(complete, b)
+	}
+
+	// The timestamps sort in chronological order
+	sort.Strings(complete)
+
+	for len(complete) > w.maxBackups {
+		if err := os.Remove(complete[0]); err != nil {
+			zap.L().Warn("Unable to remove rotated file", zap.String("file", complete[0]), zap.Error(err))
+		}
+		complete = complete[1:]
+	}
+}
+
+// close closes the file and waits for the compressions in progress
+func (w *rotatingWriter) close() error {
+
+	defer w.compressions.Wait()
+
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.file.Close()
+	w.file = nil
+
+	return err
+}
+
+// compressFile compresses a file with gzip and removes it
+func compressFile(path string) error {
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close() // nolint
+
+	out, err := os.OpenFile(path+gzipExtension, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if err == nil {
+		err = gz.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(path + gzipExtension) // nolint
+		return err
+	}
+
+	return os.Remove(path)
+}
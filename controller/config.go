@@ -13,9 +13,11 @@ import (
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/utils/rpcwrapper"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/supervisor"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/supervisor/proxy"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/certissuer"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packetprocessor"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/tokens"
 	"github.com/aporeto-inc/trireme-lib/utils/allocator"
 	"go.uber.org/zap"
 )
@@ -41,6 +43,17 @@ type config struct {
 	externalIPcacheTimeout time.Duration
 	targetNetworks         []string
 	proxyPort              int
+	captureMethod          rpcwrapper.CaptureType
+	fastPath               bool
+	fastPathInterfaces     []string
+	metricsAddress         string
+	secretsGracePeriod     time.Duration
+	tokenFormat            tokens.TokenFormat
+	tagDictionary          []string
+	claimsKey              []byte
+	certIssuer             certissuer.CertificateIssuer
+	certRenewal            float64
+	rejectInvalidPolicies  bool
 }
 
 // Option is provided using functional arguments.
@@ -67,6 +80,56 @@ func OptionSecret(s secrets.Secrets) Option {
 	}
 }
 
+// OptionSecretsRotationGracePeriod is an option to keep accepting the previous
+// secrets for the given period after UpdateSecrets, so that the peers that
+// have not rotated yet are not rejected.
+func OptionSecretsRotationGracePeriod(grace time.Duration) Option {
+	return func(cfg *config) {
+		cfg.secretsGracePeriod = grace
+	}
+}
+
+// OptionBinaryTokens is an option to create the tokens with the compact
+// binary encoding instead of JWTs. The tokens of both formats are accepted so
+// that the enforcers can be migrated one by one. The tags of the dictionary
+// are replaced by short references and must be known by all the enforcers.
+func OptionBinaryTokens(dictionary ...string) Option {
+	return func(cfg *config) {
+		cfg.tokenFormat = tokens.BinaryFormat
+		cfg.tagDictionary = dictionary
+	}
+}
+
+// OptionEncryptedClaims is an option to encrypt the tags of the tokens so
+// that they can not be read on the network. The tags of the SYN/ACK packets
+// are encrypted for the key of the initiator. The tags of the SYN packets are
+// encrypted for the key of the receiver learned from its previous SYN/ACK
+// packets. All the enforcers must support the encrypted tags before the
+// option is enabled.
+//
+// The key of the receiver is not known for the first SYN packets to a
+// destination, or once it is forgotten after a few minutes. The tags of
+// these SYN packets are encrypted with the key, which must be shared by all
+// the enforcers, and any holder of the key can read them. The key must be
+// protected like the enforcer private keys.
+func OptionEncryptedClaims(key []byte) Option {
+	return func(cfg *config) {
+		cfg.claimsKey = key
+	}
+}
+
+// OptionCertificateIssuer is an option to issue the service certificates of
+// the PUs that do not receive them with their policy. The certificates carry
+// the identity of the PUs and are renewed after the renewal fraction of their
+// lifetime. The default fraction is used if it is not between 0 and 1. Only
+// the local CA is supported by the remote enforcers.
+func OptionCertificateIssuer(issuer certissuer.CertificateIssuer, renewal float64) Option {
+	return func(cfg *config) {
+		cfg.certIssuer = issuer
+		cfg.certRenewal = renewal
+	}
+}
+
 // OptionEnforceLinuxProcess is an option to request support for linux process support.
 func OptionEnforceLinuxProcess() Option {
 	return func(cfg *config) {
@@ -95,6 +158,32 @@ func OptionTargetNetworks(n []string) Option {
 	}
 }
 
+// OptionNFTablesSupervisor is an option to use nftables instead of iptables
+// in the supervisors.
+func OptionNFTablesSupervisor() Option {
+	return func(cfg *config) {
+		cfg.captureMethod = rpcwrapper.NFTables
+	}
+}
+
+// OptionEBPFFastPath is an option to offload the authorized flows to an eBPF
+// program attached to the given interfaces, or to all the interfaces if none
+// is given. The datapath falls back to iptables if eBPF is not available.
+func OptionEBPFFastPath(interfaces ...string) Option {
+	return func(cfg *config) {
+		cfg.fastPath = true
+		cfg.fastPathInterfaces = interfaces
+	}
+}
+
+// OptionMetricsEndpoint is an option to expose the metrics in the Prometheus
+// format on the /metrics path of an HTTP server listening on the given address.
+func OptionMetricsEndpoint(address string) Option {
+	return func(cfg *config) {
+		cfg.metricsAddress = address
+	}
+}
+
 // OptionApplicationProxyPort is an option provide starting proxy port for application proxy
 func OptionApplicationProxyPort(proxyPort int) Option {
 	return func(cfg *config) {
@@ -116,6 +205,14 @@ func OptionPacketLogs() Option {
 	}
 }
 
+// OptionRejectInvalidPolicies is an option to refuse the policies that the
+// linter finds errors in, such as invalid addresses, ports or clauses.
+func OptionRejectInvalidPolicies() Option {
+	return func(cfg *config) {
+		cfg.rejectInvalidPolicies = true
+	}
+}
+
 func (t *trireme) newEnforcers() error {
 	zap.L().Debug("LinuxProcessSupport", zap.Bool("Status", t.config.linuxProcess))
 	var err error
@@ -132,6 +229,11 @@ func (t *trireme) newEnforcers() error {
 			t.config.procMountPoint,
 			t.config.externalIPcacheTimeout,
 			t.config.packetLogs,
+			t.config.tokenFormat,
+			t.config.tagDictionary,
+			t.config.claimsKey,
+			t.config.certIssuer,
+			t.config.certRenewal,
 		)
 		if err != nil {
 			return fmt.Errorf("Failed to initialize enforcer: %s ", err)
@@ -153,6 +255,11 @@ func (t *trireme) newEnforcers() error {
 			t.config.procMountPoint,
 			t.config.externalIPcacheTimeout,
 			t.config.packetLogs,
+			t.config.tokenFormat,
+			t.config.tagDictionary,
+			t.config.claimsKey,
+			t.config.certIssuer,
+			t.config.certRenewal,
 		)
 	}
 
@@ -162,7 +269,12 @@ func (t *trireme) newEnforcers() error {
 func (t *trireme) newSupervisors() error {
 
 	if t.config.linuxProcess {
-		sup, err := supervisor.NewSupervisor(
+		newSupervisor := supervisor.NewSupervisor
+		if t.config.captureMethod == rpcwrapper.NFTables {
+			newSupervisor = supervisor.NewNFTablesSupervisor
+		}
+
+		sup, err := newSupervisor(
 			t.config.collector,
 			t.enforcers[constants.LocalServer],
 			constants.LocalServer,
@@ -179,6 +291,7 @@ func (t *trireme) newSupervisors() error {
 			t.config.collector,
 			t.enforcers[constants.RemoteContainer],
 			t.rpchdl,
+			t.config.captureMethod,
 		)
 		if err != nil {
 			zap.L().Error("Unable to create proxy Supervisor:: Returned Error ", zap.Error(err))
@@ -203,6 +316,7 @@ func newTrireme(c *config) TriremeController {
 		supervisors:          map[constants.ModeType]supervisor.Supervisor{},
 		puTypeToEnforcerType: map[common.PUType]constants.ModeType{},
 		locks:                sync.Map{},
+		secrets:              c.secret,
 	}
 
 	zap.L().Debug("Creating Enforcers")
@@ -14,6 +14,8 @@ import (
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/utils/rpcwrapper"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/supervisor"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/metrics"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/allocator"
@@ -32,6 +34,9 @@ type trireme struct {
 	port                 allocator.Allocator
 	rpchdl               rpcwrapper.RPCClient
 	locks                sync.Map
+	secrets              secrets.Secrets
+	rotationTimer        *time.Timer
+	secretsLock          sync.Mutex
 }
 
 // New returns a trireme interface implementation based on configuration provided.
@@ -53,6 +58,11 @@ func New(serverID string, opts ...Option) TriremeController {
 		opt(c)
 	}
 
+	if c.fastPath {
+		c.fq.FastPath = true
+		c.fq.FastPathInterfaces = c.fastPathInterfaces
+	}
+
 	zap.L().Debug("Trireme configuration", zap.String("configuration", fmt.Sprintf("%+v", c)))
 
 	return newTrireme(c)
@@ -77,6 +87,12 @@ func (t *trireme) Run(ctx context.Context) error {
 		}
 	}
 
+	if t.config.metricsAddress != "" {
+		if err := metrics.ListenAndServe(ctx, t.config.metricsAddress, metrics.DefaultRegistry); err != nil {
+			return fmt.Errorf("unable to start the metrics endpoint: %s", err)
+		}
+	}
+
 	return nil
 }
 
@@ -90,6 +106,10 @@ func (t *trireme) CleanUp() error {
 
 // Enforce asks the controller to enforce policy to a processing unit
 func (t *trireme) Enforce(ctx context.Context, puID string, policy *policy.PUPolicy, runtime *policy.PURuntime) error {
+	if err := t.lintPolicy(puID, policy); err != nil {
+		return err
+	}
+
 	lock, _ := t.locks.LoadOrStore(puID, &sync.Mutex{})
 	lock.(*sync.Mutex).Lock()
 	defer lock.(*sync.Mutex).Unlock()
@@ -114,19 +134,83 @@ func (t *trireme) UpdatePolicy(ctx context.Context, puID string, plc *policy.PUP
 		return nil
 	}
 
+	if err := t.lintPolicy(puID, plc); err != nil {
+		return err
+	}
+
 	lock.(*sync.Mutex).Lock()
 	defer lock.(*sync.Mutex).Unlock()
 	return t.doUpdatePolicy(puID, plc, runtime)
 }
 
-// UpdateSecrets updates the secrets of the controllers.
-func (t *trireme) UpdateSecrets(secrets secrets.Secrets) error {
-	for _, enforcer := range t.enforcers {
-		if err := enforcer.UpdateSecrets(secrets); err != nil {
+// UpdateSecrets updates the secrets of the controllers. If a rotation grace
+// period is configured, the previous secrets are accepted until it is over.
+// The keys of the peers pinned with the previous secrets stay pinned.
+func (t *trireme) UpdateSecrets(s secrets.Secrets) error {
+
+	t.secretsLock.Lock()
+	defer t.secretsLock.Unlock()
+
+	if t.secrets != nil {
+		secrets.KeepPeerKeys(s, t.secrets)
+	}
+
+	if t.config.secretsGracePeriod > 0 && t.secrets != nil {
+		rotated, err := secrets.NewRotatedSecrets(s, t.secrets, t.config.secretsGracePeriod)
+		if err != nil {
+			zap.L().Warn("Unable to keep the previous secrets during the rotation", zap.Error(err))
+		} else {
+			s = rotated
+			t.startRotation(rotated)
+		}
+	}
+
+	t.secrets = s
+
+	var failed error
+	for mode, enforcer := range t.enforcers {
+		if err := enforcer.UpdateSecrets(s); err != nil {
 			zap.L().Error("unable to update secrets", zap.Error(err))
+			failed = fmt.Errorf("unable to update secrets of enforcer %d: %s", mode, err)
 		}
 	}
-	return nil
+	return failed
+}
+
+// startRotation reports the rotation and the end of its grace period. The
+// grace period of a previous rotation is cut short since its previous
+// secrets are not accepted anymore.
+func (t *trireme) startRotation(rotated *secrets.RotatedSecrets) {
+
+	if t.rotationTimer != nil && t.rotationTimer.Stop() {
+		if previous, ok := t.secrets.(*secrets.RotatedSecrets); ok {
+			t.reportPreviousExpired(previous)
+		}
+	}
+
+	collector.CollectSecretsEvent(t.config.collector, &collector.SecretsRecord{
+		Event:  collector.SecretsRotated,
+		Expiry: rotated.Expiry(),
+	})
+
+	t.rotationTimer = time.AfterFunc(time.Until(rotated.Expiry()), func() {
+		t.reportPreviousExpired(rotated)
+	})
+}
+
+// reportPreviousExpired reports that the previous secrets of a rotation are
+// not accepted anymore.
+func (t *trireme) reportPreviousExpired(rotated *secrets.RotatedSecrets) {
+
+	zap.L().Info("Previous secrets are not accepted anymore",
+		zap.Time("lastUsed", rotated.PreviousLastUsed()),
+	)
+
+	collector.CollectSecretsEvent(t.config.collector, &collector.SecretsRecord{
+		Event:            collector.SecretsPreviousExpired,
+		Expiry:           rotated.Expiry(),
+		PreviousLastUsed: rotated.PreviousLastUsed(),
+	})
 }
 
 // UpdateConfiguration updates the configuration of the controller. Only
@@ -150,6 +234,61 @@ func (t *trireme) UpdateConfiguration(networks []string) error {
 	return nil
 }
 
+// ListPUs returns the processing units enforced by all the enforcers.
+func (t *trireme) ListPUs() ([]*introspection.PUState, error) {
+
+	list := []*introspection.PUState{}
+
+	for _, e := range t.enforcers {
+		pus, err := e.ListPUs()
+		if err != nil {
+			zap.L().Warn("Unable to list processing units", zap.Error(err))
+			continue
+		}
+		list = append(list, pus...)
+	}
+
+	return list, nil
+}
+
+// GetPU returns the processing unit from the enforcer that enforces it.
+func (t *trireme) GetPU(puID string) (*introspection.PUState, error) {
+
+	for _, e := range t.enforcers {
+		if pu, err := e.GetPU(puID); err == nil {
+			return pu, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unable to find processing unit %s", puID)
+}
+
+// ListConnections returns the connections of the processing unit from the
+// enforcer that enforces it.
+func (t *trireme) ListConnections(puID string) ([]*introspection.ConnectionState, error) {
+
+	for _, e := range t.enforcers {
+		if connections, err := e.ListConnections(puID); err == nil {
+			return connections, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unable to find processing unit %s", puID)
+}
+
+// ListPeerKeys returns the keys of the peers seen by the enforcer that
+// enforces the processing unit. The remote enforcers have their own keys.
+func (t *trireme) ListPeerKeys(puID string) ([]secrets.PeerKey, error) {
+
+	for _, e := range t.enforcers {
+		if keys, err := e.ListPeerKeys(puID); err == nil {
+			return keys, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unable to find processing unit %s", puID)
+}
+
 // doHandleCreate is the detailed implementation of the create event.
 func (t *trireme) doHandleCreate(contextID string, policyInfo *policy.PUPolicy, runtimeInfo *policy.PURuntime) error {
 
@@ -276,3 +415,28 @@ func (t *trireme) doUpdatePolicy(contextID string, newPolicy *policy.PUPolicy, r
 
 	return nil
 }
+
+// lintPolicy refuses a policy with errors if the controller is configured to
+// reject the invalid policies. The warnings are only logged.
+func (t *trireme) lintPolicy(contextID string, plc *policy.PUPolicy) error {
+
+	if !t.config.rejectInvalidPolicies || plc == nil {
+		return nil
+	}
+
+	findings := policy.Lint(plc)
+	for _, f := range findings {
+		if f.Severity == policy.SeverityWarning {
+			zap.L().Warn("Policy warning",
+				zap.String("contextID", contextID),
+				zap.String("finding", f.String()),
+			)
+		}
+	}
+
+	if findings.HasErrors() {
+		return fmt.Errorf("invalid policy for pu %s: %s", contextID, findings.Errors())
+	}
+
+	return nil
+}
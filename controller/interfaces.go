@@ -3,6 +3,7 @@ package controller
 import (
 	"context"
 
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
 	"github.com/aporeto-inc/trireme-lib/policy"
 )
@@ -24,10 +25,27 @@ type TriremeController interface {
 	// UpdatePolicy updates the policy of the isolator for a container.
 	UpdatePolicy(ctx context.Context, puID string, policy *policy.PUPolicy, runtime *policy.PURuntime) error
 
-	// UpdateSecrets updates the secrets of running enforcers managed by trireme. Remote enforcers will get the secret updates with the next policy push
+	// UpdateSecrets updates the secrets of running enforcers managed by trireme, including
+	// the remote enforcers. The previous secrets are accepted during the rotation grace period.
+	// An error is returned if any of the enforcers could not be updated.
 	UpdateSecrets(secrets secrets.Secrets) error
 
 	// UpdateConfiguration updates the configuration of the controller. Only specific configuration
 	// parameters can be updated during run time.
 	UpdateConfiguration(networks []string) error
+
+	// ListPUs returns a snapshot of all the processing units that are enforced.
+	ListPUs() ([]*introspection.PUState, error)
+
+	// GetPU returns a snapshot of the policy, mark, ports and compiled rules of a
+	// processing unit.
+	GetPU(puID string) (*introspection.PUState, error)
+
+	// ListConnections returns a snapshot of the connections of a processing unit
+	// that are tracked by the enforcer.
+	ListConnections(puID string) ([]*introspection.ConnectionState, error)
+
+	// ListPeerKeys returns the keys of the peers seen by the enforcer of a
+	// processing unit, with their use and the tokens rejected by the pinning.
+	ListPeerKeys(puID string) ([]secrets.PeerKey, error)
 }
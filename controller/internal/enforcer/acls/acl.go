@@ -12,33 +12,47 @@ import (
 	"github.com/aporeto-inc/trireme-lib/policy"
 )
 
-func newACL() *acl {
+func newACL(protocol string) *acl {
 	return &acl{
-		sortedPrefixLens: make([]int, 0),
-		prefixLenMap:     make(map[int]*prefixRules),
+		protocol:           protocol,
+		sortedPrefixLens:   make([]int, 0),
+		prefixLenMap:       make(map[int]*prefixRules),
+		sortedV6PrefixLens: make([]int, 0),
+		v6PrefixLenMap:     make(map[int]*prefixRulesV6),
 	}
 }
 
-// acl holds all the ACLS in an internal DB
+// acl holds all the ACLS in an internal DB. IPv4 and IPv6 rules are kept
+// in separate tables.
 type acl struct {
-	sortedPrefixLens []int
-	prefixLenMap     map[int]*prefixRules
+	protocol           string
+	sortedPrefixLens   []int
+	prefixLenMap       map[int]*prefixRules
+	sortedV6PrefixLens []int
+	v6PrefixLenMap     map[int]*prefixRulesV6
+	// count is the number of rules added, which gives their order
+	count int
 }
 
 func (a *acl) reverseSort() {
 
 	// Get reverse sorted prefix lengths for reject rules
+	a.sortedPrefixLens = a.sortedPrefixLens[:0]
 	for k := range a.prefixLenMap {
 		a.sortedPrefixLens = append(a.sortedPrefixLens, k)
 	}
 	sort.Sort(sort.Reverse(sort.IntSlice(a.sortedPrefixLens)))
+
+	a.sortedV6PrefixLens = a.sortedV6PrefixLens[:0]
+	for k := range a.v6PrefixLenMap {
+		a.sortedV6PrefixLens = append(a.sortedV6PrefixLens, k)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(a.sortedV6PrefixLens)))
 }
 
 func (a *acl) addRule(rule policy.IPRule) (err error) {
 
-	var subnet, mask uint32
-
-	if strings.ToLower(rule.Protocol) != "tcp" {
+	if strings.ToLower(rule.Protocol) != a.protocol {
 		return nil
 	}
 
@@ -49,14 +63,15 @@ func (a *acl) addRule(rule policy.IPRule) (err error) {
 		return fmt.Errorf("invalid ip address: %s", parts[0])
 	}
 
-	subnet = binary.BigEndian.Uint32(subnetSlice.To4())
+	bits := 8 * net.IPv6len
+	if subnetSlice.To4() != nil {
+		bits = 8 * net.IPv4len
+	}
 
-	maskValue := 0
+	maskValue := bits
 
 	switch len(parts) {
 	case 1:
-		mask = 0xFFFFFFFF
-		maskValue = 32
 
 	case 2:
 		maskValue, err = strconv.Atoi(parts[1])
@@ -64,31 +79,61 @@ func (a *acl) addRule(rule policy.IPRule) (err error) {
 			return fmt.Errorf("invalid address: %s", err)
 		}
 
-		if mask > 32 {
-			return fmt.Errorf("invalid mask value: %d", mask)
+		if maskValue < 0 || maskValue > bits {
+			return fmt.Errorf("invalid mask value: %d", maskValue)
 		}
-		mask = binary.BigEndian.Uint32(net.CIDRMask(maskValue, 32))
 
 	default:
 		return fmt.Errorf("invalid address: %s", rule.Address)
 	}
+
+	r, err := newPortAction(rule)
+	if err != nil {
+		return fmt.Errorf("unable to create port action: %s", err)
+	}
+
+	r.order = rule.Order(a.count)
+	a.count++
+
+	if bits == 8*net.IPv4len {
+		a.addIPv4Rule(subnetSlice.To4(), maskValue, r)
+		return nil
+	}
+
+	a.addIPv6Rule(subnetSlice.To16(), maskValue, r)
+	return nil
+}
+
+// addIPv4Rule adds a port action for an IPv4 subnet
+func (a *acl) addIPv4Rule(ip net.IP, maskValue int, r *portAction) {
+
 	plenRules, ok := a.prefixLenMap[maskValue]
 	if !ok {
 		plenRules = &prefixRules{
-			mask:  mask,
+			mask:  binary.BigEndian.Uint32(net.CIDRMask(maskValue, 8*net.IPv4len)),
 			rules: make(map[uint32]portActionList),
 		}
 		a.prefixLenMap[maskValue] = plenRules
 	}
 
-	r, err := newPortAction(rule)
-	if err != nil {
-		return fmt.Errorf("unable to create port action: %s", err)
+	subnet := binary.BigEndian.Uint32(ip) & plenRules.mask
+	plenRules.rules[subnet] = append(plenRules.rules[subnet], r)
+}
+
+// addIPv6Rule adds a port action for an IPv6 subnet
+func (a *acl) addIPv6Rule(ip net.IP, maskValue int, r *portAction) {
+
+	plenRules, ok := a.v6PrefixLenMap[maskValue]
+	if !ok {
+		plenRules = &prefixRulesV6{
+			mask:  net.CIDRMask(maskValue, 8*net.IPv6len),
+			rules: make(map[[net.IPv6len]byte]portActionList),
+		}
+		a.v6PrefixLenMap[maskValue] = plenRules
 	}
 
-	subnet = subnet & mask
+	subnet := plenRules.key(ip)
 	plenRules.rules[subnet] = append(plenRules.rules[subnet], r)
-	return nil
 }
 
 // getMatchingAction does lookup in acl in a common way for accept/reject rules.
@@ -96,6 +141,22 @@ func (a *acl) getMatchingAction(ip []byte, port uint16, preReport *policy.FlowPo
 
 	report = preReport
 
+	if ip4 := net.IP(ip).To4(); ip4 != nil {
+		return a.getMatchingIPv4Action(ip4, port, report)
+	}
+
+	if len(ip) == net.IPv6len {
+		return a.getMatchingIPv6Action(ip, port, report)
+	}
+
+	return report, nil, fmt.Errorf("invalid ip address: %v", ip)
+}
+
+// getMatchingIPv4Action does the lookup of an IPv4 address.
+func (a *acl) getMatchingIPv4Action(ip []byte, port uint16, preReport *policy.FlowPolicy) (report *policy.FlowPolicy, packet *policy.FlowPolicy, err error) {
+
+	report = preReport
+
 	addr := binary.BigEndian.Uint32(ip)
 
 	// Iterate over all the bitmasks we have
@@ -120,3 +181,57 @@ func (a *acl) getMatchingAction(ip []byte, port uint16, preReport *policy.FlowPo
 
 	return report, packet, errors.New("No match")
 }
+
+// getMatchingIPv6Action does the lookup of an IPv6 address.
+func (a *acl) getMatchingIPv6Action(ip []byte, port uint16, preReport *policy.FlowPolicy) (report *policy.FlowPolicy, packet *policy.FlowPolicy, err error) {
+
+	report = preReport
+
+	// Iterate over all the bitmasks we have
+	for _, len := range a.sortedV6PrefixLens {
+
+		rules, ok := a.v6PrefixLenMap[len]
+		if !ok {
+			continue
+		}
+
+		// Do a lookup as a hash to see if we have a match
+		actionList, ok := rules.rules[rules.key(ip)]
+		if !ok {
+			continue
+		}
+
+		report, packet, err = actionList.lookup(port, report)
+		if err == nil {
+			return
+		}
+	}
+
+	return report, packet, errors.New("No match")
+}
+
+// forEachMatch calls f for the port actions of all the rules that match the
+// address and the port
+func (a *acl) forEachMatch(ip []byte, port uint16, f func(*portAction)) error {
+
+	if ip4 := net.IP(ip).To4(); ip4 != nil {
+		addr := binary.BigEndian.Uint32(ip4)
+		for _, len := range a.sortedPrefixLens {
+			if rules, ok := a.prefixLenMap[len]; ok {
+				rules.rules[addr&rules.mask].forEachMatch(port, f)
+			}
+		}
+		return nil
+	}
+
+	if len(ip) == net.IPv6len {
+		for _, len := range a.sortedV6PrefixLens {
+			if rules, ok := a.v6PrefixLenMap[len]; ok {
+				rules.rules[rules.key(ip)].forEachMatch(port, f)
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("invalid ip address: %v", ip)
+}
@@ -64,7 +64,7 @@ func TestLookup(t *testing.T) {
 
 	Convey("Given a good DB", t, func() {
 
-		a := newACL()
+		a := newACL("tcp")
 		So(a, ShouldNotBeNil)
 		for _, r := range rules {
 			err := a.addRule(r)
@@ -173,7 +173,7 @@ func TestObservedLookup(t *testing.T) {
 	)
 
 	Convey("Given a good DB", t, func() {
-		a := newACL()
+		a := newACL("tcp")
 		So(a, ShouldNotBeNil)
 		for _, r := range rulesWithObservation {
 			err := a.addRule(r)
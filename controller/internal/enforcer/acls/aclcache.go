@@ -2,6 +2,7 @@ package acls
 
 import (
 	"errors"
+	"net"
 
 	"github.com/aporeto-inc/trireme-lib/policy"
 )
@@ -14,6 +15,11 @@ type ACLCache struct {
 	reject  *acl
 	accept  *acl
 	observe *acl
+	// rules holds all the rules when the conflict mode or the priorities
+	// order them
+	rules      *acl
+	mode       policy.ConflictMode
+	priorities bool
 }
 
 type prefixRules struct {
@@ -21,18 +27,58 @@ type prefixRules struct {
 	rules map[uint32]portActionList
 }
 
-// NewACLCache creates a new ACL cache
+type prefixRulesV6 struct {
+	mask  net.IPMask
+	rules map[[net.IPv6len]byte]portActionList
+}
+
+// key returns the masked address that indexes the rules
+func (p *prefixRulesV6) key(ip []byte) (k [net.IPv6len]byte) {
+	for i := range k {
+		k[i] = ip[i] & p.mask[i]
+	}
+	return k
+}
+
+// NewACLCache creates a new ACL cache for TCP rules
 func NewACLCache() *ACLCache {
+	return NewACLCacheForProtocol("tcp")
+}
+
+// NewACLCacheForProtocol creates a new ACL cache that holds the rules of the
+// given protocol. Rules of other protocols are ignored.
+func NewACLCacheForProtocol(protocol string) *ACLCache {
+	return NewACLCacheWithMode(protocol, policy.DenyOverrides)
+}
+
+// NewACLCacheWithMode creates a new ACL cache that holds the rules of the
+// given protocol and resolves the conflicts between them with the mode.
+func NewACLCacheWithMode(protocol string, mode policy.ConflictMode) *ACLCache {
 	return &ACLCache{
-		reject:  newACL(),
-		accept:  newACL(),
-		observe: newACL(),
+		reject:  newACL(protocol),
+		accept:  newACL(protocol),
+		observe: newACL(protocol),
+		rules:   newACL(protocol),
+		mode:    mode,
 	}
 }
 
 // AddRule adds a single rule to the ACL Cache
 func (c *ACLCache) AddRule(rule policy.IPRule) (err error) {
 
+	if err = c.rules.addRule(rule); err != nil {
+		return err
+	}
+
+	if rule.Priority != 0 {
+		c.priorities = true
+	}
+
+	// The rules are only searched in the order of the mode
+	if c.mode != policy.DenyOverrides {
+		return nil
+	}
+
 	if rule.Policy.ObserveAction.ObserveApply() {
 		return c.observe.addRule(rule)
 	}
@@ -56,12 +102,17 @@ func (c *ACLCache) AddRuleList(rules policy.IPRuleList) (err error) {
 	c.reject.reverseSort()
 	c.accept.reverseSort()
 	c.observe.reverseSort()
+	c.rules.reverseSort()
 	return
 }
 
 // GetMatchingAction gets the matching action
 func (c *ACLCache) GetMatchingAction(ip []byte, port uint16) (report *policy.FlowPolicy, packet *policy.FlowPolicy, err error) {
 
+	if c.mode.Ordered(c.priorities) {
+		return c.getOrderedAction(ip, port)
+	}
+
 	report, packet, err = c.reject.getMatchingAction(ip, port, report)
 	if err == nil {
 		return
@@ -87,3 +138,40 @@ func (c *ACLCache) GetMatchingAction(ip []byte, port uint16) (report *policy.Flo
 
 	return report, packet, errors.New("no match")
 }
+
+// getOrderedAction gets the matching action of the first rule in the order of
+// the conflict mode. An observe continue rule is reported if it comes before
+// that rule.
+func (c *ACLCache) getOrderedAction(ip []byte, port uint16) (report *policy.FlowPolicy, packet *policy.FlowPolicy, err error) {
+
+	var reportAction, packetAction *portAction
+
+	// An invalid address does not match any rule
+	_ = c.rules.forEachMatch(ip, port, func(pa *portAction) {
+		best := &packetAction
+		if pa.policy.ObserveAction.ObserveContinue() {
+			best = &reportAction
+		}
+		if *best == nil || c.mode.Precedes(pa.order, (*best).order) {
+			*best = pa
+		}
+	})
+
+	if reportAction != nil && packetAction != nil && !c.mode.Precedes(reportAction.order, packetAction.order) {
+		reportAction = nil
+	}
+
+	packet = catchAllPolicy
+	if packetAction != nil {
+		packet = packetAction.policy
+	} else {
+		err = errors.New("no match")
+	}
+
+	report = packet
+	if reportAction != nil {
+		report = reportAction.policy
+	}
+
+	return report, packet, err
+}
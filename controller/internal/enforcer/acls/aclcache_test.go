@@ -200,3 +200,209 @@ func TestObserveContinueApplyCacheLookup(t *testing.T) {
 		})
 	})
 }
+
+func TestProtocolACLCacheLookup(t *testing.T) {
+
+	rules := policy.IPRuleList{
+		policy.IPRule{
+			Address:  "10.0.0.0/8",
+			Port:     "53",
+			Protocol: "tcp",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Reject,
+				PolicyID: "tcp10/8"},
+		},
+		policy.IPRule{
+			Address:  "10.0.0.0/8",
+			Port:     "53",
+			Protocol: "udp",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Accept,
+				PolicyID: "udp10/8"},
+		},
+	}
+
+	Convey("Given a TCP and a UDP ACL Cache with the same rules", t, func() {
+		tcp := NewACLCache()
+		So(tcp.AddRuleList(rules), ShouldBeNil)
+		udp := NewACLCacheForProtocol("udp")
+		So(udp.AddRuleList(rules), ShouldBeNil)
+
+		Convey("When I lookup the TCP cache, I should only match the tcp rule", func() {
+			a, p, err := tcp.GetMatchingAction(net.ParseIP("10.1.1.1").To4(), 53)
+			So(err, ShouldBeNil)
+			So(a.PolicyID, ShouldEqual, "tcp10/8")
+			So(p.Action, ShouldEqual, policy.Reject)
+		})
+
+		Convey("When I lookup the UDP cache, I should only match the udp rule", func() {
+			a, p, err := udp.GetMatchingAction(net.ParseIP("10.1.1.1").To4(), 53)
+			So(err, ShouldBeNil)
+			So(a.PolicyID, ShouldEqual, "udp10/8")
+			So(p.Action, ShouldEqual, policy.Accept)
+		})
+	})
+}
+
+func TestIPv6ACLCacheLookup(t *testing.T) {
+
+	rules := policy.IPRuleList{
+		policy.IPRule{
+			Address:  "2001:db8::/32",
+			Port:     "80",
+			Protocol: "tcp",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Accept,
+				PolicyID: "tcp2001:db8::/32"},
+		},
+		policy.IPRule{
+			Address:  "2001:db8:1::/48",
+			Port:     "80",
+			Protocol: "tcp",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Reject,
+				PolicyID: "tcp2001:db8:1::/48"},
+		},
+		policy.IPRule{
+			Address:  "0.0.0.0/0",
+			Port:     "80",
+			Protocol: "tcp",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Accept,
+				PolicyID: "tcp0/0"},
+		},
+	}
+
+	Convey("Given an ACL Cache with IPv4 and IPv6 rules", t, func() {
+		c := NewACLCache()
+		So(c.AddRuleList(rules), ShouldBeNil)
+
+		Convey("When I lookup an address in the IPv6 subnet, I should get accept", func() {
+			a, p, err := c.GetMatchingAction(net.ParseIP("2001:db8:2::1"), 80)
+			So(err, ShouldBeNil)
+			So(a.PolicyID, ShouldEqual, "tcp2001:db8::/32")
+			So(p.Action, ShouldEqual, policy.Accept)
+		})
+
+		Convey("When I lookup an address in the rejected IPv6 subnet, I should get reject", func() {
+			a, p, err := c.GetMatchingAction(net.ParseIP("2001:db8:1::1"), 80)
+			So(err, ShouldBeNil)
+			So(a.PolicyID, ShouldEqual, "tcp2001:db8:1::/48")
+			So(p.Action, ShouldEqual, policy.Reject)
+		})
+
+		Convey("When I lookup an IPv6 address outside the subnets, IPv4 rules should not match", func() {
+			_, p, err := c.GetMatchingAction(net.ParseIP("2001:db9::1"), 80)
+			So(err, ShouldNotBeNil)
+			So(p.PolicyID, ShouldEqual, "default")
+		})
+
+		Convey("When I lookup an IPv4 address in its 16 byte form, I should match the IPv4 rule", func() {
+			a, _, err := c.GetMatchingAction(net.ParseIP("10.1.1.1"), 80)
+			So(err, ShouldBeNil)
+			So(a.PolicyID, ShouldEqual, "tcp0/0")
+		})
+	})
+
+	Convey("Given an ACL Cache", t, func() {
+		c := NewACLCache()
+
+		Convey("When I add an IPv6 rule with an invalid mask, I should get an error", func() {
+			err := c.AddRule(policy.IPRule{
+				Address:  "2001:db8::/129",
+				Port:     "80",
+				Protocol: "tcp",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept},
+			})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestConflictModeCacheLookup(t *testing.T) {
+
+	rules := policy.IPRuleList{
+		policy.IPRule{
+			Address:  "172.0.0.0/8",
+			Port:     "1:100",
+			Protocol: "tcp",
+			Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "accept"},
+			Priority: 10,
+		},
+		policy.IPRule{
+			Address:  "172.17.0.0/16",
+			Port:     "1:100",
+			Protocol: "tcp",
+			Policy:   &policy.FlowPolicy{Action: policy.Reject, PolicyID: "reject"},
+		},
+		policy.IPRule{
+			Address:  "172.17.0.0/16",
+			Port:     "80",
+			Protocol: "tcp",
+			Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "web"},
+		},
+		policy.IPRule{
+			Address:  "172.0.0.0/8",
+			Port:     "1:100",
+			Protocol: "tcp",
+			Policy: &policy.FlowPolicy{
+				Action:        policy.Reject,
+				ObserveAction: policy.ObserveContinue,
+				PolicyID:      "observed",
+			},
+			Priority: 20,
+		},
+	}
+
+	ip := net.ParseIP("172.17.1.1").To4()
+
+	Convey("Given an ACL Cache in the deny overrides mode with priorities", t, func() {
+		c := NewACLCacheWithMode("tcp", policy.DenyOverrides)
+		So(c.AddRuleList(rules), ShouldBeNil)
+
+		Convey("The reject rule should override the accept rules", func() {
+			report, packet, err := c.GetMatchingAction(ip, 80)
+			So(err, ShouldBeNil)
+			So(packet.PolicyID, ShouldEqual, "reject")
+			So(report.PolicyID, ShouldEqual, "observed")
+		})
+	})
+
+	Convey("Given an ACL Cache in the first match mode", t, func() {
+		c := NewACLCacheWithMode("tcp", policy.FirstMatch)
+		So(c.AddRuleList(rules), ShouldBeNil)
+
+		Convey("The rule with the highest priority should decide", func() {
+			report, packet, err := c.GetMatchingAction(ip, 80)
+			So(err, ShouldBeNil)
+			So(packet.PolicyID, ShouldEqual, "accept")
+			So(report.PolicyID, ShouldEqual, "observed")
+		})
+
+		Convey("Nothing should match outside of the rules", func() {
+			_, packet, err := c.GetMatchingAction(ip, 200)
+			So(err, ShouldNotBeNil)
+			So(packet, ShouldEqual, catchAllPolicy)
+		})
+	})
+
+	Convey("Given an ACL Cache in the most specific mode", t, func() {
+		c := NewACLCacheWithMode("tcp", policy.MostSpecific)
+		So(c.AddRuleList(rules), ShouldBeNil)
+
+		Convey("The rule with the longest prefix and the fewest ports should decide", func() {
+			report, packet, err := c.GetMatchingAction(ip, 80)
+			So(err, ShouldBeNil)
+			So(packet.PolicyID, ShouldEqual, "web")
+			So(report.PolicyID, ShouldEqual, "web")
+
+			_, packet, err = c.GetMatchingAction(ip, 81)
+			So(err, ShouldBeNil)
+			So(packet.PolicyID, ShouldEqual, "reject")
+
+			_, packet, err = c.GetMatchingAction(net.ParseIP("172.18.1.1").To4(), 81)
+			So(err, ShouldBeNil)
+			So(packet.PolicyID, ShouldEqual, "accept")
+		})
+	})
+}
@@ -14,6 +14,7 @@ type portAction struct {
 	min    uint16
 	max    uint16
 	policy *policy.FlowPolicy
+	order  policy.RuleOrder
 }
 
 // portActionList is a list of Port Actions
@@ -91,3 +92,12 @@ func (p *portActionList) lookup(port uint16, preReported *policy.FlowPolicy) (re
 
 	return report, packet, errors.New("No match")
 }
+
+// forEachMatch calls f for the port actions that include the port
+func (p portActionList) forEachMatch(port uint16, f func(*portAction)) {
+	for _, pa := range p {
+		if port >= pa.min && port <= pa.max {
+			f(pa)
+		}
+	}
+}
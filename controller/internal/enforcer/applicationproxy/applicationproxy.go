@@ -6,6 +6,7 @@ import (
 	"crypto/x509"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
 	"sync"
 
@@ -19,6 +20,7 @@ import (
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/applicationproxy/tcp"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/nfqdatapath/tokenaccessor"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/portset"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/certissuer"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/urisearch"
@@ -42,8 +44,14 @@ type ServerInterface interface {
 }
 
 type clientData struct {
-	protomux  *protomux.MultiplexedListener
-	netserver map[protomux.ListenerType]ServerInterface
+	protomux    *protomux.MultiplexedListener
+	netserver   map[protomux.ListenerType]ServerInterface
+	externalCAs [][]byte
+	// svid is true if the servers use the SVID of the secrets for TLS
+	svid bool
+	// renewer renews the certificate of the certificate issuer used by the
+	// servers
+	renewer *certissuer.Renewer
 }
 
 // AppProxy maintains state for proxies connections from listen to backend.
@@ -58,13 +66,18 @@ type AppProxy struct {
 	jwtcache          cache.DataStore
 	systemCAPool      *x509.CertPool
 	secrets           secrets.Secrets
+	issuer            certissuer.CertificateIssuer
+	renewal           float64
 
 	clients cache.DataStore
 	sync.RWMutex
 }
 
-// NewAppProxy creates a new instance of the application proxy.
-func NewAppProxy(tp tokenaccessor.TokenAccessor, c collector.EventCollector, puFromID cache.DataStore, certificate *tls.Certificate, s secrets.Secrets) (*AppProxy, error) {
+// NewAppProxy creates a new instance of the application proxy. The issuer
+// is optional. If it is set, it issues the service certificates of the PUs
+// that do not receive them with their policy, and the certificates are
+// renewed after the renewal fraction of their lifetime.
+func NewAppProxy(tp tokenaccessor.TokenAccessor, c collector.EventCollector, puFromID cache.DataStore, certificate *tls.Certificate, s secrets.Secrets, issuer certissuer.CertificateIssuer, renewal float64) (*AppProxy, error) {
 
 	systemPool, err := x509.SystemCertPool()
 	if err != nil {
@@ -78,6 +91,12 @@ func NewAppProxy(tp tokenaccessor.TokenAccessor, c collector.EventCollector, puF
 		}
 	}
 
+	if issuer != nil {
+		if ok := systemPool.AppendCertsFromPEM(issuer.CertificateAuthority()); !ok {
+			return nil, fmt.Errorf("error while adding certificate issuer CA")
+		}
+	}
+
 	return &AppProxy{
 		collector:         c,
 		tokenaccessor:     tp,
@@ -89,6 +108,8 @@ func NewAppProxy(tp tokenaccessor.TokenAccessor, c collector.EventCollector, puF
 		dependentAPICache: cache.NewCache("dependencies"),
 		jwtcache:          cache.NewCache("jwtcache"),
 		systemCAPool:      systemPool,
+		issuer:            issuer,
+		renewal:           renewal,
 	}, nil
 }
 
@@ -101,7 +122,20 @@ func (p *AppProxy) Run(ctx context.Context) error {
 
 // Enforce implements enforcer.Enforcer interface. It will will create the necessary
 // proxies for the particular PU.
-func (p *AppProxy) Enforce(ctx context.Context, puID string, puInfo *policy.PUInfo) error {
+func (p *AppProxy) Enforce(ctx context.Context, puID string, puInfo *policy.PUInfo) (err error) {
+
+	// The service certificate is issued before the lock is taken, since the
+	// issuer may be a remote service. Its renewal is stopped if the PU is not
+	// enforced with it.
+	renewer, err := p.issueServiceCertificate(ctx, puID, puInfo)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil && renewer != nil {
+			renewer.Stop()
+		}
+	}()
 
 	p.Lock()
 	defer p.Unlock()
@@ -115,7 +149,7 @@ func (p *AppProxy) Enforce(ctx context.Context, puID string, puInfo *policy.PUIn
 	// For updates we need to update the certificates if we have new ones. Otherwise
 	// we return. There is nothing else to do in case of policy update.
 	if c, cerr := p.clients.Get(puID); cerr == nil {
-		_, perr := p.processCertificateUpdates(puInfo, c.(*clientData), caPool)
+		_, perr := p.processCertificateUpdates(ctx, puID, puInfo, c.(*clientData), caPool, renewer)
 		if perr != nil {
 			return perr
 		}
@@ -163,7 +197,7 @@ func (p *AppProxy) Enforce(ctx context.Context, puID string, puInfo *policy.PUIn
 		return fmt.Errorf("Unable to register services: %s ", err)
 	}
 
-	if _, err := p.processCertificateUpdates(puInfo, client, caPool); err != nil {
+	if _, err := p.processCertificateUpdates(ctx, puID, puInfo, client, caPool, renewer); err != nil {
 		return fmt.Errorf("Certificates not updated:  %s ", err)
 	}
 
@@ -201,6 +235,10 @@ func (p *AppProxy) Unenforce(ctx context.Context, puID string) error {
 	}
 	client := c.(*clientData)
 
+	if client.renewer != nil {
+		client.renewer.Stop()
+	}
+
 	// Shutdown all the servers and unregister listeners.
 	for t, server := range client.netserver {
 		if err := client.protomux.UnregisterListener(t); err != nil {
@@ -228,12 +266,43 @@ func (p *AppProxy) GetPortSetInstance() portset.PortSet {
 	return nil
 }
 
-// UpdateSecrets updates the secrets of running enforcers managed by trireme. Remote enforcers will
-// get the secret updates with the next policy push.
+// UpdateSecrets updates the secrets of running enforcers managed by trireme. The
+// CA of the new secrets is trusted in addition to the previous ones, so that the
+// peers that have not rotated yet are still accepted.
 func (p *AppProxy) UpdateSecrets(secret secrets.Secrets) error {
 	p.Lock()
 	defer p.Unlock()
+
+	if secret.PublicSecrets().SecretsType() != secrets.PSKType {
+		if ok := p.systemCAPool.AppendCertsFromPEM(secret.PublicSecrets().CertAuthority()); !ok {
+			return fmt.Errorf("error while adding provided CA")
+		}
+	}
+
 	p.secrets = secret
+
+	// The servers that use the SVID of the secrets for TLS are updated with
+	// the new SVID.
+	svid, ok := secrets.SVIDFrom(secret)
+	if !ok {
+		return nil
+	}
+
+	for _, puID := range p.clients.KeyList() {
+		c, err := p.clients.Get(puID)
+		if err != nil || !c.(*clientData).svid {
+			continue
+		}
+		client := c.(*clientData)
+		tlsCert, err := svid.TLSCertificate()
+		if err != nil {
+			return fmt.Errorf("Invalid certificates: %s", err)
+		}
+		if err := p.updateServerCertificates(client, tlsCert, string(svid.CertificatePEM), string(svid.KeyPEM), string(svid.BundlePEM())); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -332,16 +401,196 @@ func (p *AppProxy) createNetworkListener(port string) (net.Listener, error) {
 }
 
 // processCertificateUpdates processes the certificate information and updates
-// the servers.
-func (p *AppProxy) processCertificateUpdates(puInfo *policy.PUInfo, client *clientData, externalCAs [][]byte) (bool, error) {
+// the servers. The renewer is the one of the certificate issued for the PU
+// before the update, if any.
+func (p *AppProxy) processCertificateUpdates(ctx context.Context, puID string, puInfo *policy.PUInfo, client *clientData, externalCAs [][]byte, renewer *certissuer.Renewer) (bool, error) {
 
 	// If there are certificates provided, we will need to update them for the
-	// services. If the certificates are nil, we ignore them.
+	// services. If the certificates are nil, they are requested from the
+	// certificate issuer if there is one, or the SVID of the secrets is used
+	// if there is one. Otherwise we ignore them.
+	client.externalCAs = externalCAs
+	client.svid = false
+
+	var tlsCert *tls.Certificate
 	certPEM, keyPEM, caPEM := puInfo.Policy.ServiceCertificates()
+
+	if (certPEM == "" || keyPEM == "") && p.issuer != nil {
+		return true, p.processIssuedCertificates(ctx, puID, puInfo, client, renewer)
+	}
+
+	if client.renewer != nil {
+		client.renewer.Stop()
+		client.renewer = nil
+	}
+
 	if certPEM == "" || keyPEM == "" {
-		return false, nil
+		svid, ok := secrets.SVIDFrom(p.secrets)
+		if !ok {
+			return false, nil
+		}
+		// The key of the SVID may be in a key store and is used through
+		// its signer.
+		cert, err := svid.TLSCertificate()
+		if err != nil {
+			return false, fmt.Errorf("Invalid certificates: %s", err)
+		}
+		tlsCert = cert
+		certPEM, keyPEM, caPEM = string(svid.CertificatePEM), string(svid.KeyPEM), string(svid.BundlePEM())
+		client.svid = true
+	} else {
+		cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+		if err != nil {
+			return false, fmt.Errorf("Invalid certificates: %s", err)
+		}
+		tlsCert = &cert
+	}
+
+	if err := p.updateServerCertificates(client, tlsCert, certPEM, keyPEM, caPEM); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// processIssuedCertificates updates the servers with a certificate of the
+// certificate issuer. The certificate is issued again if the identity of the
+// PU changed, and it is renewed in the background until the PU is
+// unenforced.
+func (p *AppProxy) processIssuedCertificates(ctx context.Context, puID string, puInfo *policy.PUInfo, client *clientData, renewer *certissuer.Renewer) error {
+
+	if renewer == nil {
+		request := serviceCertificateRequest(puID, puInfo)
+		if client.renewer != nil && client.renewer.Request().Equal(request) {
+			renewer = client.renewer
+		} else {
+			// The identity of the PU changed after the certificate was
+			// issued by the caller
+			var err error
+			if renewer, err = p.startRenewer(ctx, puID, request); err != nil {
+				return err
+			}
+		}
+	}
+
+	tlsCert := renewer.Certificate()
+	certPEM, keyPEM := certissuer.EncodePEM(tlsCert)
+
+	if err := p.updateServerCertificates(client, tlsCert, string(certPEM), string(keyPEM), ""); err != nil {
+		if renewer != client.renewer {
+			renewer.Stop()
+		}
+		return err
+	}
+
+	if renewer != client.renewer {
+		if client.renewer != nil {
+			client.renewer.Stop()
+		}
+		client.renewer = renewer
+	}
+
+	return nil
+}
+
+// issueServiceCertificate issues the service certificate of the PU if it
+// comes from the certificate issuer and the identity of the PU changed. It
+// is called without the lock of the proxy and returns the renewer of the
+// new certificate, or nil if there is nothing to issue.
+func (p *AppProxy) issueServiceCertificate(ctx context.Context, puID string, puInfo *policy.PUInfo) (*certissuer.Renewer, error) {
+
+	if p.issuer == nil {
+		return nil, nil
+	}
+
+	if certPEM, keyPEM, _ := puInfo.Policy.ServiceCertificates(); certPEM != "" && keyPEM != "" {
+		return nil, nil
+	}
+
+	request := serviceCertificateRequest(puID, puInfo)
+
+	p.RLock()
+	unchanged := false
+	if c, err := p.clients.Get(puID); err == nil {
+		current := c.(*clientData).renewer
+		unchanged = current != nil && current.Request().Equal(request)
+	}
+	p.RUnlock()
+
+	if unchanged {
+		return nil, nil
+	}
+
+	return p.startRenewer(ctx, puID, request)
+}
+
+// startRenewer issues the first certificate of the request and renews it in
+// the background. A renewed certificate is only installed if the renewer is
+// still the one of the enforced PU, since the PU may have been unenforced or
+// its identity may have changed during the renewal.
+func (p *AppProxy) startRenewer(ctx context.Context, puID string, request *certissuer.Request) (*certissuer.Renewer, error) {
+
+	var renewer *certissuer.Renewer
+	renewer = certissuer.NewRenewer(p.issuer, request, p.renewal, func(cert *tls.Certificate) {
+		p.Lock()
+		defer p.Unlock()
+
+		c, err := p.clients.Get(puID)
+		if err != nil || c.(*clientData).renewer != renewer {
+			zap.L().Debug("Ignoring renewed service certificate of a removed PU", zap.String("puID", puID))
+			return
+		}
+
+		certPEM, keyPEM := certissuer.EncodePEM(cert)
+		if err := p.updateServerCertificates(c.(*clientData), cert, string(certPEM), string(keyPEM), ""); err != nil {
+			zap.L().Error("Unable to update renewed service certificate", zap.String("puID", puID), zap.Error(err))
+		}
+	})
+
+	if _, err := renewer.Start(ctx); err != nil {
+		return nil, fmt.Errorf("Unable to issue service certificate: %s", err)
+	}
+
+	return renewer, nil
+}
+
+// serviceCertificateRequest returns the identity of the PU that is carried
+// by its service certificate.
+func serviceCertificateRequest(puID string, puInfo *policy.PUInfo) *certissuer.Request {
+
+	request := &certissuer.Request{
+		ID: puInfo.Policy.ManagementID(),
+	}
+	if request.ID == "" {
+		request.ID = puID
+	}
+
+	for _, service := range puInfo.Policy.ExposedServices() {
+		if service.NetworkInfo != nil {
+			request.DNSNames = append(request.DNSNames, service.NetworkInfo.FQDNs...)
+		}
+	}
+
+	ips := puInfo.Policy.IPAddresses()
+	names := make([]string, 0, len(ips))
+	for name := range ips {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if ip := net.ParseIP(ips[name]); ip != nil {
+			request.IPAddresses = append(request.IPAddresses, ip)
+		}
 	}
 
+	return request
+}
+
+// updateServerCertificates updates the TLS certificates and the CAs of the
+// servers of the client.
+func (p *AppProxy) updateServerCertificates(client *clientData, tlsCert *tls.Certificate, certPEM, keyPEM, caPEM string) error {
+
 	// Process any updates on the cert pool
 	var caPool *x509.CertPool
 	if caPEM != "" {
@@ -350,22 +599,16 @@ func (p *AppProxy) processCertificateUpdates(puInfo *policy.PUInfo, client *clie
 		caPool = p.systemCAPool
 	}
 
-	for _, caCert := range externalCAs {
+	for _, caCert := range client.externalCAs {
 		if !caPool.AppendCertsFromPEM(caCert) {
 			zap.L().Warn("Failed to add CA certificate to chain")
 		}
 	}
 
-	// Create the TLS certificate
-	tlsCert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
-	if err != nil {
-		return false, fmt.Errorf("Invalid certificates: %s", err)
-	}
-
 	for _, server := range client.netserver {
-		server.UpdateSecrets(&tlsCert, caPool, p.secrets, certPEM, keyPEM)
+		server.UpdateSecrets(tlsCert, caPool, p.secrets, certPEM, keyPEM)
 	}
-	return true, nil
+	return nil
 }
 
 func serviceTypeToNetworkListenerType(serviceType policy.ServiceType) protomux.ListenerType {
@@ -0,0 +1,206 @@
+package applicationproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/common"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/applicationproxy/protomux"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/certissuer"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/cache"
+	"github.com/aporeto-inc/trireme-lib/utils/testpki"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testServer records the certificates of the updates
+type testServer struct {
+	cert *tls.Certificate
+	sync.Mutex
+}
+
+func (s *testServer) RunNetworkServer(ctx context.Context, l net.Listener, encrypted bool) error {
+	return nil
+}
+
+func (s *testServer) UpdateSecrets(cert *tls.Certificate, ca *x509.CertPool, secrets secrets.Secrets, certPEM, keyPEM string) {
+	s.Lock()
+	defer s.Unlock()
+	s.cert = cert
+}
+
+func (s *testServer) ShutDown() error {
+	return nil
+}
+
+func (s *testServer) certificate() *tls.Certificate {
+	s.Lock()
+	defer s.Unlock()
+	return s.cert
+}
+
+// newTestLocalCA creates a local CA with a self signed certificate
+func newTestLocalCA(validity time.Duration) *certissuer.LocalCA {
+
+	authority := testpki.NewAuthority("ca")
+
+	ca, err := certissuer.NewLocalCA(authority.KeyPEM(), authority.CertPEM(), "example.org", validity)
+	So(err, ShouldBeNil)
+
+	return ca
+}
+
+func newTestPUInfo() *policy.PUInfo {
+
+	exposed := policy.ApplicationServicesList{
+		&policy.ApplicationService{
+			NetworkInfo: &common.Service{FQDNs: []string{"frontend.example.org"}},
+		},
+	}
+
+	puPolicy := policy.NewPUPolicy("5c3d2f1a", policy.AllowAll, nil, nil, nil, nil, nil, nil,
+		policy.ExtendedMap{"bridge": "10.0.0.2", "another": "10.0.0.1", "invalid": "none"},
+		[]string{}, []string{}, &policy.ProxiedServicesInfo{}, exposed, nil, []string{})
+
+	return policy.PUInfoFromPolicyAndRuntime("pu1", puPolicy, policy.NewPURuntimeWithDefaults())
+}
+
+func TestServiceCertificateRequest(t *testing.T) {
+
+	Convey("Given a PU with services and addresses", t, func() {
+
+		puInfo := newTestPUInfo()
+
+		Convey("Then its certificate should carry its identity", func() {
+			request := serviceCertificateRequest("pu1", puInfo)
+			So(request.ID, ShouldEqual, "5c3d2f1a")
+			So(request.DNSNames, ShouldResemble, []string{"frontend.example.org"})
+			So(len(request.IPAddresses), ShouldEqual, 2)
+			So(request.IPAddresses[0].String(), ShouldEqual, "10.0.0.1")
+			So(request.IPAddresses[1].String(), ShouldEqual, "10.0.0.2")
+		})
+
+		Convey("Then the PU ID should be used without a management ID", func() {
+			request := serviceCertificateRequest("pu1", policy.NewPUInfo("pu1", common.ContainerPU))
+			So(request.ID, ShouldEqual, "pu1")
+		})
+	})
+}
+
+// updateLocked processes the certificates of the PU with the lock of the
+// proxy, as Enforce does
+func updateLocked(ctx context.Context, p *AppProxy, puInfo *policy.PUInfo, client *clientData, renewer *certissuer.Renewer) (bool, error) {
+
+	p.Lock()
+	defer p.Unlock()
+
+	return p.processCertificateUpdates(ctx, "pu1", puInfo, client, nil, renewer)
+}
+
+func TestIssuedCertificates(t *testing.T) {
+
+	Convey("Given an application proxy with a certificate issuer", t, func() {
+
+		ca := newTestLocalCA(time.Hour)
+		p := &AppProxy{
+			issuer:       ca,
+			renewal:      0.5,
+			systemCAPool: x509.NewCertPool(),
+			secrets:      secrets.NewPSKSecrets([]byte("psk")),
+			clients:      cache.NewCache("clients"),
+		}
+
+		server := &testServer{}
+		client := &clientData{
+			netserver: map[protomux.ListenerType]ServerInterface{protomux.HTTPSNetwork: server},
+		}
+		p.clients.AddOrUpdate("pu1", client)
+
+		puInfo := newTestPUInfo()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		Convey("When the policy has no service certificates", func() {
+			updated, err := updateLocked(ctx, p, puInfo, client, nil)
+			So(err, ShouldBeNil)
+			So(updated, ShouldBeTrue)
+
+			cert := server.certificate()
+			So(cert, ShouldNotBeNil)
+
+			Convey("Then the servers should use a certificate of the issuer", func() {
+				So(cert.Leaf.URIs[0].String(), ShouldEqual, "spiffe://example.org/5c3d2f1a")
+				So(client.renewer, ShouldNotBeNil)
+			})
+
+			Convey("Then the certificate should be kept if the identity did not change", func() {
+				renewer, err := p.issueServiceCertificate(ctx, "pu1", puInfo)
+				So(err, ShouldBeNil)
+				So(renewer, ShouldBeNil)
+
+				_, err = updateLocked(ctx, p, puInfo, client, nil)
+				So(err, ShouldBeNil)
+				So(server.certificate(), ShouldEqual, cert)
+			})
+
+			Convey("Then a certificate issued before the update should replace it", func() {
+				puInfo.Policy.SetIPAddresses(policy.ExtendedMap{"bridge": "10.0.0.3"})
+				renewer, err := p.issueServiceCertificate(ctx, "pu1", puInfo)
+				So(err, ShouldBeNil)
+				So(renewer, ShouldNotBeNil)
+
+				_, err = updateLocked(ctx, p, puInfo, client, renewer)
+				So(err, ShouldBeNil)
+				So(client.renewer, ShouldEqual, renewer)
+				So(server.certificate().Leaf.IPAddresses[0].String(), ShouldEqual, "10.0.0.3")
+			})
+
+			Convey("Then the certificates of the policy should replace it", func() {
+				issued, err := ca.Issue(ctx, &certissuer.Request{ID: "policy"})
+				So(err, ShouldBeNil)
+				certPEM, keyPEM := certissuer.EncodePEM(issued)
+				puInfo.Policy.UpdateServiceCertificates(string(certPEM), string(keyPEM))
+
+				_, err = updateLocked(ctx, p, puInfo, client, nil)
+				So(err, ShouldBeNil)
+				So(server.certificate().Certificate[0], ShouldResemble, issued.Certificate[0])
+				So(client.renewer, ShouldBeNil)
+			})
+		})
+
+		Convey("When the certificates expire soon", func() {
+			// The certificates are valid one minute before they are issued
+			// and are renewed about one second after
+			p.issuer = newTestLocalCA(2 * time.Second)
+			p.renewal = 0.99
+
+			_, err := updateLocked(ctx, p, puInfo, client, nil)
+			So(err, ShouldBeNil)
+			cert := server.certificate()
+
+			Convey("Then the servers should be updated with the renewed certificates", func() {
+				deadline := time.Now().Add(5 * time.Second)
+				for server.certificate() == cert && time.Now().Before(deadline) {
+					time.Sleep(10 * time.Millisecond)
+				}
+				So(server.certificate(), ShouldNotEqual, cert)
+				client.renewer.Stop()
+			})
+
+			Convey("Then the renewed certificates should be ignored once the PU is removed", func() {
+				So(p.clients.Remove("pu1"), ShouldBeNil)
+
+				time.Sleep(2 * time.Second)
+				So(server.certificate(), ShouldEqual, cert)
+				client.renewer.Stop()
+			})
+		})
+	})
+}
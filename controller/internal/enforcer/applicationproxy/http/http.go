@@ -18,12 +18,14 @@ import (
 	"github.com/aporeto-inc/trireme-lib/collector"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/applicationproxy/markedconn"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/nfqdatapath/tokenaccessor"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/metrics"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/pucontext"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/urisearch"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
+	"github.com/aporeto-inc/trireme-lib/utils/crypto"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/vulcand/oxy/forward"
 	"go.uber.org/zap"
@@ -239,6 +241,10 @@ func (p *Config) processAppRequest(w http.ResponseWriter, r *http.Request) {
 
 	zap.L().Debug("Processing Application Request", zap.String("URI", r.RequestURI), zap.String("Host", r.Host))
 
+	metrics.ProxyConnectionsTotal.Inc(metrics.ProxyHTTP, metrics.DirectionApplication)
+	metrics.ProxyConnections.Add(1, metrics.ProxyHTTP)
+	defer metrics.ProxyConnections.Add(-1, metrics.ProxyHTTP)
+
 	puContext, apiCache, err := p.retrieveContextAndPolicy(p.dependentAPICache, w, r)
 	if err != nil {
 		return
@@ -267,7 +273,7 @@ func (p *Config) processAppRequest(w http.ResponseWriter, r *http.Request) {
 		Tags:        puContext.Annotations(),
 	}
 
-	_, netaction, noNetAccesPolicy := puContext.ApplicationACLPolicyFromAddr(originalDestination.IP.To4(), uint16(originalDestination.Port))
+	_, netaction, noNetAccesPolicy := puContext.ApplicationACLPolicyFromAddr(originalDestination.IP, uint16(originalDestination.Port))
 	if noNetAccesPolicy == nil && netaction.Action.Rejected() {
 		http.Error(w, fmt.Sprintf("Unauthorized Service - Rejected Outgoing Request by Network Policies"), http.StatusNetworkAuthenticationRequired)
 		p.collector.CollectFlowEvent(record)
@@ -337,6 +343,10 @@ func (p *Config) processAppRequest(w http.ResponseWriter, r *http.Request) {
 func (p *Config) processNetRequest(w http.ResponseWriter, r *http.Request) {
 
 	zap.L().Debug("Processing Network Request", zap.String("URI", r.RequestURI), zap.String("Host", r.Host))
+
+	metrics.ProxyConnectionsTotal.Inc(metrics.ProxyHTTP, metrics.DirectionNetwork)
+	metrics.ProxyConnections.Add(1, metrics.ProxyHTTP)
+	defer metrics.ProxyConnections.Add(-1, metrics.ProxyHTTP)
 	originalDestination := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr)
 
 	sourceAddress, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
@@ -382,7 +392,7 @@ func (p *Config) processNetRequest(w http.ResponseWriter, r *http.Request) {
 	record.Tags = puContext.Annotations()
 	record.Destination.ID = puContext.ManagementID()
 
-	_, networkPolicy, noNetAccessPolicy := puContext.NetworkACLPolicyFromAddr(sourceAddress.IP.To4(), uint16(sourceAddress.Port))
+	_, networkPolicy, noNetAccessPolicy := puContext.NetworkACLPolicyFromAddr(sourceAddress.IP, uint16(sourceAddress.Port))
 	if noNetAccessPolicy == nil && networkPolicy.Action.Rejected() {
 		http.Error(w, fmt.Sprintf("Access denied by network policy"), http.StatusNetworkAuthenticationRequired)
 		record.Source.Type = collector.EndPointTypeExteranlIPAddress
@@ -435,6 +445,7 @@ func (p *Config) processNetRequest(w http.ResponseWriter, r *http.Request) {
 	var claims *JWTClaims
 	claims, err = p.parseClientToken(key, token)
 	if err != nil && len(userAttributes) == 0 && !rule.Public {
+		metrics.ProxyAuthFailures.Inc(metrics.ProxyHTTP)
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
@@ -451,6 +462,7 @@ func (p *Config) processNetRequest(w http.ResponseWriter, r *http.Request) {
 	if !rule.Public {
 		// Validate the policy and drop the request if there is no authorization.
 		if err = p.verifyPolicy(rule.Scopes, claims.Profile, claims.Scopes, userAttributes); err != nil {
+			metrics.ProxyAuthFailures.Inc(metrics.ProxyHTTP)
 			http.Error(w, err.Error(), http.StatusForbidden)
 			return
 		}
@@ -493,7 +505,13 @@ func (p *Config) createClientToken(puContext *pucontext.PUContext) (string, erro
 		Scopes:   puContext.Scopes(),
 		SourceID: puContext.ManagementID(),
 	}
-	return jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(p.secrets.EncodingKey())
+
+	signMethod, err := crypto.SigningMethod(p.secrets.EncodingKey())
+	if err != nil {
+		return "", err
+	}
+
+	return jwt.NewWithClaims(signMethod, claims).SignedString(p.secrets.EncodingKey())
 }
 
 func (p *Config) verifyPolicy(apitags []string, profile, scopes []string, userAttributes []string) error {
@@ -532,20 +550,52 @@ func (p *Config) parseClientToken(txtKey string, token string) (*JWTClaims, erro
 		return &JWTClaims{}, fmt.Errorf("Invalid Service Token")
 	}
 
+	// The SVID secrets return the verified certificate of the peer
+	cert, _ := key.(*x509.Certificate)
+	if cert != nil {
+		key = cert.PublicKey
+	}
+
 	claims := &JWTClaims{}
 	_, err = jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		ekey, ok := key.(*ecdsa.PublicKey)
-		if !ok {
+		signMethod, err := crypto.SigningMethod(key)
+		if err != nil || signMethod.Alg() != token.Method.Alg() {
 			return nil, fmt.Errorf("Invalid key")
 		}
-		return ekey, nil
+		return key, nil
 	})
 	if err != nil {
 		return claims, fmt.Errorf("Error parsing token: %s", err)
 	}
+
+	claims.Profile = spiffeProfile(claims.Profile, cert)
+
 	return claims, nil
 }
 
+// spiffeProfile replaces the reserved SPIFFE tags of the profile with the
+// SPIFFE ID of the verified certificate of the peer.
+func spiffeProfile(profile []string, cert *x509.Certificate) []string {
+
+	tags := make([]string, 0, len(profile)+2)
+	for _, tag := range profile {
+		if !policy.IsSpiffeTag(tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	if cert == nil {
+		return tags
+	}
+
+	id, trustDomain, err := secrets.SpiffeID(cert)
+	if err != nil {
+		return tags
+	}
+
+	return append(tags, policy.SpiffeIDKey+"="+id, policy.SpiffeTrustDomainKey+"="+trustDomain)
+}
+
 func (p *Config) isSecretsRequest(w http.ResponseWriter, r *http.Request) bool {
 
 	if r.Host != "169.254.254.1" {
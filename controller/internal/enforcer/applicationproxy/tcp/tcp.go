@@ -22,11 +22,13 @@ import (
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/constants"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/nfqdatapath/tokenaccessor"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/connection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/metrics"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/pucontext"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
+	"github.com/aporeto-inc/trireme-lib/utils/crypto"
 )
 
 const (
@@ -143,9 +145,13 @@ func (p *Proxy) handle(ctx context.Context, upConn net.Conn) {
 	}
 	defer downConn.Close() // nolint
 
+	metrics.ProxyConnections.Add(1, metrics.ProxyTCP)
+	defer metrics.ProxyConnections.Add(-1, metrics.ProxyTCP)
+
 	// Now let us handle the state machine for the down connection
 	isEncrypted, err := p.CompleteEndPointAuthorization(ip, port, upConn, downConn)
 	if err != nil {
+		metrics.ProxyAuthFailures.Inc(metrics.ProxyTCP)
 		zap.L().Error("Error on Authorization", zap.Error(err))
 		return
 	}
@@ -290,9 +296,12 @@ func (p *Proxy) CompleteEndPointAuthorization(downIP net.IP, downPort int, upCon
 
 	// If the backend is not a local IP it means that we are a client.
 	if _, ok := p.localIPs[backendip]; !ok {
+		metrics.ProxyConnectionsTotal.Inc(metrics.ProxyTCP, metrics.DirectionApplication)
 		return p.StartClientAuthStateMachine(downIP, downPort, downConn)
 	}
 
+	metrics.ProxyConnectionsTotal.Inc(metrics.ProxyTCP, metrics.DirectionNetwork)
+
 	isEncrypted, err := p.StartServerAuthStateMachine(downIP, downPort, upConn)
 	if err != nil {
 		return false, err
@@ -310,6 +319,8 @@ func (p *Proxy) StartClientAuthStateMachine(downIP net.IP, downPort int, downCon
 	}
 	isEncrypted := false
 	conn := connection.NewProxyConnection()
+	conn.Auth.RemoteIP = downIP.String()
+	conn.Auth.RemotePort = strconv.Itoa(downPort)
 
 	flowproperties := &proxyFlowProperties{
 		DestIP:     downIP.String(),
@@ -320,7 +331,7 @@ func (p *Proxy) StartClientAuthStateMachine(downIP net.IP, downPort int, downCon
 	}
 
 	// First validate that L3 policies do not require a reject.
-	networkReport, networkPolicy, noNetAccessPolicy := puContext.ApplicationACLPolicyFromAddr(downIP.To4(), uint16(downPort))
+	networkReport, networkPolicy, noNetAccessPolicy := puContext.ApplicationACLPolicyFromAddr(downIP, uint16(downPort))
 	if noNetAccessPolicy == nil && networkPolicy.Action.Rejected() {
 		fmt.Println("I am dropping at the entry", downIP.To4().String(), networkPolicy.Action.ActionString(), networkPolicy.PolicyID, "report", networkReport.PolicyID)
 		fmt.Printf("Flowproperties %+v\n ", flowproperties)
@@ -351,9 +362,9 @@ func (p *Proxy) StartClientAuthStateMachine(downIP net.IP, downPort int, downCon
 			if err != nil {
 				return false, fmt.Errorf("Failed to read peer token: %s", err)
 			}
-			claims, err := p.tokenaccessor.ParsePacketToken(&conn.Auth, msg)
+			claims, err := p.tokenaccessor.ParsePacketToken(&conn.Auth, msg, metrics.PacketSynAck)
 			if err != nil || claims == nil {
-				p.reportRejectedFlow(flowproperties, conn, collector.DefaultEndPoint, puContext.ManagementID(), puContext, collector.InvalidToken, nil, nil)
+				p.reportRejectedFlow(flowproperties, conn, collector.DefaultEndPoint, puContext.ManagementID(), puContext, tokenDropReason(err), nil, nil)
 				return false, fmt.Errorf("peer token reject because of bad claims: error: %s, claims: %v %v", err, claims, string(msg))
 			}
 			report, packet := puContext.SearchTxtRules(claims.T, false)
@@ -403,7 +414,7 @@ func (p *Proxy) StartServerAuthStateMachine(ip fmt.Stringer, backendport int, up
 	conn.SetState(connection.ServerReceivePeerToken)
 
 	// First validate that L3 policies do not require a reject.
-	networkReport, networkPolicy, noNetAccessPolicy := puContext.NetworkACLPolicyFromAddr(upConn.RemoteAddr().(*net.TCPAddr).IP.To4(), uint16(backendport))
+	networkReport, networkPolicy, noNetAccessPolicy := puContext.NetworkACLPolicyFromAddr(upConn.RemoteAddr().(*net.TCPAddr).IP, uint16(backendport))
 	if noNetAccessPolicy == nil && networkPolicy.Action.Rejected() {
 		flowProperties.SourceType = collector.EndPointTypeExteranlIPAddress
 		p.reportRejectedFlow(flowProperties, conn, collector.DefaultEndPoint, puContext.ManagementID(), puContext, collector.PolicyDrop, networkReport, networkPolicy)
@@ -424,9 +435,9 @@ func (p *Proxy) StartServerAuthStateMachine(ip fmt.Stringer, backendport int, up
 			if err != nil {
 				return false, fmt.Errorf("unable to receive syn token: %s", err)
 			}
-			claims, err := p.tokenaccessor.ParsePacketToken(&conn.Auth, msg)
+			claims, err := p.tokenaccessor.ParsePacketToken(&conn.Auth, msg, metrics.PacketSyn)
 			if err != nil || claims == nil {
-				p.reportRejectedFlow(flowProperties, conn, collector.DefaultEndPoint, puContext.ManagementID(), puContext, collector.InvalidToken, nil, nil)
+				p.reportRejectedFlow(flowProperties, conn, collector.DefaultEndPoint, puContext.ManagementID(), puContext, tokenDropReason(err), nil, nil)
 				return isEncrypted, fmt.Errorf("reported rejected flow due to invalid token: %s", err)
 			}
 			tags := claims.T.Copy()
@@ -528,6 +539,19 @@ func (p *Proxy) reportRejectedFlow(flowproperties *proxyFlowProperties, conn *co
 	p.reportFlow(flowproperties, conn, sourceID, destID, context, mode, report, packet)
 }
 
+// tokenDropReason returns the drop reason of a token that was rejected.
+// Tokens rejected because of revoked credentials or of a changed pinned key
+// have their own reason.
+func tokenDropReason(err error) string {
+	if crypto.IsRevoked(err) {
+		return collector.RevokedCredentials
+	}
+	if secrets.IsPinnedKeyChanged(err) {
+		return collector.PinnedKeyChanged
+	}
+	return collector.InvalidToken
+}
+
 func readMsg(reader io.Reader) ([]byte, error) {
 
 	lread := io.LimitReader(reader, 2)
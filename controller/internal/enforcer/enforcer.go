@@ -11,9 +11,12 @@ import (
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/nfqdatapath"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/nfqdatapath/tokenaccessor"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/portset"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/certissuer"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packetprocessor"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/tokens"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
 	"go.uber.org/zap"
@@ -38,8 +41,20 @@ type Enforcer interface {
 	// Run starts the PolicyEnforcer.
 	Run(ctx context.Context) error
 
-	// UpdateSecrets -- updates the secrets of running enforcers managed by trireme. Remote enforcers will get the secret updates with the next policy push
+	// UpdateSecrets -- updates the secrets of running enforcers managed by trireme, including the remote enforcers
 	UpdateSecrets(secrets secrets.Secrets) error
+
+	// ListPUs returns a snapshot of the PUs enforced by this enforcer.
+	ListPUs() ([]*introspection.PUState, error)
+
+	// GetPU returns a snapshot of the policy, mark, ports and rules of a PU.
+	GetPU(contextID string) (*introspection.PUState, error)
+
+	// ListConnections returns a snapshot of the connections tracked for a PU.
+	ListConnections(contextID string) ([]*introspection.ConnectionState, error)
+
+	// ListPeerKeys returns the keys of the peers seen by the enforcer of a PU.
+	ListPeerKeys(contextID string) ([]secrets.PeerKey, error)
 }
 
 // enforcer holds all the active implementations of the enforcer
@@ -130,6 +145,26 @@ func (e *enforcer) UpdateSecrets(secrets secrets.Secrets) error {
 	return nil
 }
 
+// ListPUs returns the PUs enforced by the transport path.
+func (e *enforcer) ListPUs() ([]*introspection.PUState, error) {
+	return e.transport.ListPUs()
+}
+
+// GetPU returns a PU enforced by the transport path.
+func (e *enforcer) GetPU(contextID string) (*introspection.PUState, error) {
+	return e.transport.GetPU(contextID)
+}
+
+// ListConnections returns the connections of a PU tracked by the transport path.
+func (e *enforcer) ListConnections(contextID string) ([]*introspection.ConnectionState, error) {
+	return e.transport.ListConnections(contextID)
+}
+
+// ListPeerKeys returns the keys of the peers seen by the transport path.
+func (e *enforcer) ListPeerKeys(contextID string) ([]secrets.PeerKey, error) {
+	return e.transport.ListPeerKeys(contextID)
+}
+
 // GetFilterQueue returns the current FilterQueueConfig of the transport path.
 func (e *enforcer) GetFilterQueue() *fqconfig.FilterQueue {
 	return e.transport.GetFilterQueue()
@@ -153,9 +188,14 @@ func New(
 	procMountPoint string,
 	externalIPCacheTimeout time.Duration,
 	packetLogs bool,
+	tokenFormat tokens.TokenFormat,
+	tagDictionary []string,
+	claimsKey []byte,
+	certIssuer certissuer.CertificateIssuer,
+	certRenewal float64,
 ) (Enforcer, error) {
 
-	tokenAccessor, err := tokenaccessor.New(serverID, validity, secrets)
+	tokenAccessor, err := tokenaccessor.New(serverID, validity, secrets, tokenFormat, tagDictionary, claimsKey)
 	if err != nil {
 		zap.L().Fatal("Cannot create a token engine")
 	}
@@ -178,7 +218,7 @@ func New(
 		puFromContextID,
 	)
 
-	tcpProxy, err := applicationproxy.NewAppProxy(tokenAccessor, collector, puFromContextID, nil, secrets)
+	tcpProxy, err := applicationproxy.NewAppProxy(tokenAccessor, collector, puFromContextID, nil, secrets, certIssuer, certRenewal)
 	if err != nil {
 		return nil, err
 	}
@@ -2,7 +2,9 @@ package lookup
 
 import (
 	"fmt"
+	"regexp"
 	"sort"
+	"strconv"
 
 	"go.uber.org/zap"
 
@@ -14,9 +16,26 @@ type ForwardingPolicy struct {
 	tags    []policy.KeyValueOperator
 	count   int
 	index   int
+	order   policy.RuleOrder
 	actions interface{}
 }
 
+// indexedClause is a clause of a policy in the equal table. The id identifies
+// the clause so that it is counted once per search, even if several tags or
+// several values of the clause match.
+type indexedClause struct {
+	id int
+	*ForwardingPolicy
+}
+
+// valueMatcher is a clause of a policy with an operator that can not be
+// indexed. It is evaluated on the values of the tags with its key.
+type valueMatcher struct {
+	id     int
+	policy *ForwardingPolicy
+	match  func(value string) bool
+}
+
 // intList is a list of integeres
 type intList []int
 
@@ -25,52 +44,56 @@ type PolicyDB struct {
 	// rules    []policy
 	numberOfPolicies       int
 	equalPrefixes          map[string]intList
-	equalMapTable          map[string]map[string][]*ForwardingPolicy
+	equalMapTable          map[string]map[string][]*indexedClause
 	notEqualMapTable       map[string]map[string][]*ForwardingPolicy
 	notStarTable           map[string][]*ForwardingPolicy
+	matcherTable           map[string][]*valueMatcher
+	numberOfClauses        int
 	defaultNotExistsPolicy *ForwardingPolicy
+	mode                   policy.ConflictMode
+	priorities             bool
 }
 
 //NewPolicyDB creates a new PolicyDB for efficient search of policies
 func NewPolicyDB() (m *PolicyDB) {
+	return NewPolicyDBWithMode(policy.DenyOverrides)
+}
+
+// NewPolicyDBWithMode creates a new PolicyDB that resolves the conflicts
+// between the policies that match with the given mode
+func NewPolicyDBWithMode(mode policy.ConflictMode) (m *PolicyDB) {
 
 	m = &PolicyDB{
 		numberOfPolicies:       0,
-		equalMapTable:          map[string]map[string][]*ForwardingPolicy{},
+		equalMapTable:          map[string]map[string][]*indexedClause{},
 		equalPrefixes:          map[string]intList{},
 		notEqualMapTable:       map[string]map[string][]*ForwardingPolicy{},
 		notStarTable:           map[string][]*ForwardingPolicy{},
+		matcherTable:           map[string][]*valueMatcher{},
 		defaultNotExistsPolicy: nil,
+		mode:                   mode,
 	}
 
 	return m
 }
 
+// sortedInsert inserts a value in the list sorted in decreasing order. The
+// values of the list are unique.
 func (array intList) sortedInsert(value int) intList {
-	l := len(array)
-	if l == 0 {
-		array = append(array, value)
-		return array
-	}
 
-	i := sort.Search(l, func(i int) bool {
+	i := sort.Search(len(array), func(i int) bool {
 		return array[i] <= value
 	})
 
-	if i == 0 { // new value is the largest
-		array = append([]int{value}, array...)
-		return array
-	}
-
-	if i == l-1 { // new value is the smallest
-		array = append(array, value)
+	if i < len(array) && array[i] == value {
 		return array
 	}
 
-	inserted := append(array[0:i], value)
-
-	return append(inserted, array[i:]...)
+	array = append(array, 0)
+	copy(array[i+1:], array[i:])
+	array[i] = value
 
+	return array
 }
 
 //AddPolicy adds a policy to the database
@@ -90,12 +113,7 @@ func (m *PolicyDB) AddPolicy(selector policy.TagSelector) (policyID int) {
 		switch keyValueOp.Operator {
 
 		case policy.KeyExists:
-			m.equalPrefixes[keyValueOp.Key] = m.equalPrefixes[keyValueOp.Key].sortedInsert(0)
-			if _, ok := m.equalMapTable[keyValueOp.Key]; !ok {
-				m.equalMapTable[keyValueOp.Key] = map[string][]*ForwardingPolicy{}
-			}
-			m.equalMapTable[keyValueOp.Key][""] = append(m.equalMapTable[keyValueOp.Key][""], &e)
-			e.count++
+			m.addClause(keyValueOp.Key, nil, []string{""}, &e)
 
 		case policy.KeyNotExists:
 			m.notStarTable[keyValueOp.Key] = append(m.notStarTable[keyValueOp.Key], &e)
@@ -104,17 +122,33 @@ func (m *PolicyDB) AddPolicy(selector policy.TagSelector) (policyID int) {
 			}
 
 		case policy.Equal:
-			if _, ok := m.equalMapTable[keyValueOp.Key]; !ok {
-				m.equalMapTable[keyValueOp.Key] = map[string][]*ForwardingPolicy{}
-			}
+			// The values with a trailing * are prefixes
+			values, prefixes := []string{}, []string{}
 			for _, v := range keyValueOp.Value {
 				if end := len(v) - 1; v[end] == '*' {
-					m.equalPrefixes[keyValueOp.Key] = m.equalPrefixes[keyValueOp.Key].sortedInsert(end)
-					m.equalMapTable[keyValueOp.Key][v[:end]] = append(m.equalMapTable[keyValueOp.Key][v[:end]], &e)
+					prefixes = append(prefixes, v[:end])
 				} else {
-					m.equalMapTable[keyValueOp.Key][v] = append(m.equalMapTable[keyValueOp.Key][v], &e)
+					values = append(values, v)
 				}
 			}
+			m.addClause(keyValueOp.Key, values, prefixes, &e)
+
+		case policy.Prefix:
+			m.addClause(keyValueOp.Key, nil, keyValueOp.Value, &e)
+
+		case policy.Regex, policy.GreaterThan, policy.GreaterOrEqual, policy.LessThan, policy.LessOrEqual:
+			match, err := valueMatch(keyValueOp)
+			if err != nil {
+				// The clause never matches, and so the policy
+				zap.L().Error("Invalid clause in policy", zap.String("key", keyValueOp.Key), zap.Error(err))
+			} else {
+				m.matcherTable[keyValueOp.Key] = append(m.matcherTable[keyValueOp.Key], &valueMatcher{
+					id:     m.numberOfClauses,
+					policy: &e,
+					match:  match,
+				})
+				m.numberOfClauses++
+			}
 			e.count++
 
 		default: // policy.NotEqual
@@ -133,12 +167,92 @@ func (m *PolicyDB) AddPolicy(selector policy.TagSelector) (policyID int) {
 
 	// Give the policy an index
 	e.index = m.numberOfPolicies
+	e.order = selector.Order(e.index)
+
+	if selector.Priority != 0 {
+		m.priorities = true
+	}
 
 	// Return the ID
 	return e.index
 
 }
 
+// addClause adds a clause of the policy to the equal table. The clause
+// matches the tags of the key with one of the values or with a value that
+// starts with one of the prefixes.
+func (m *PolicyDB) addClause(key string, values []string, prefixes []string, e *ForwardingPolicy) {
+
+	c := &indexedClause{id: m.numberOfClauses, ForwardingPolicy: e}
+	m.numberOfClauses++
+
+	if _, ok := m.equalMapTable[key]; !ok {
+		m.equalMapTable[key] = map[string][]*indexedClause{}
+	}
+
+	for _, v := range values {
+		m.equalMapTable[key][v] = append(m.equalMapTable[key][v], c)
+	}
+
+	for _, p := range prefixes {
+		m.equalPrefixes[key] = m.equalPrefixes[key].sortedInsert(len(p))
+		m.equalMapTable[key][p] = append(m.equalMapTable[key][p], c)
+	}
+
+	e.count++
+}
+
+// valueMatch returns the function that evaluates a clause with a regex or a
+// numeric operator on the value of a tag
+func valueMatch(kvo policy.KeyValueOperator) (func(string) bool, error) {
+
+	if err := kvo.Validate(); err != nil {
+		return nil, err
+	}
+
+	if kvo.Operator == policy.Regex {
+		expressions := make([]*regexp.Regexp, 0, len(kvo.Value))
+		for _, v := range kvo.Value {
+			re, err := policy.CompileTagRegex(v)
+			if err != nil {
+				return nil, err
+			}
+			expressions = append(expressions, re)
+		}
+
+		return func(value string) bool {
+			for _, re := range expressions {
+				if re.MatchString(value) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	limit, err := strconv.ParseFloat(kvo.Value[0], 64)
+	if err != nil {
+		return nil, err
+	}
+
+	var compare func(float64) bool
+	switch kvo.Operator {
+	case policy.GreaterThan:
+		compare = func(n float64) bool { return n > limit }
+	case policy.GreaterOrEqual:
+		compare = func(n float64) bool { return n >= limit }
+	case policy.LessThan:
+		compare = func(n float64) bool { return n < limit }
+	default: // policy.LessOrEqual
+		compare = func(n float64) bool { return n <= limit }
+	}
+
+	return func(value string) bool {
+		n, err := strconv.ParseFloat(value, 64)
+		return err == nil && compare(n)
+	}, nil
+}
+
 // Custom implementation for splitting strings. Gives significant performance
 // improvement. Do not allocate new strings
 func (m *PolicyDB) tagSplit(tag string, k *string, v *string) error {
@@ -167,11 +281,40 @@ func (m *PolicyDB) tagSplit(tag string, k *string, v *string) error {
 
 //Search searches for a set of tags in the database to find a policy match
 func (m *PolicyDB) Search(tags *policy.TagStore) (int, interface{}) {
+	return m.SearchFunc(tags, nil)
+}
+
+// SearchFunc searches for a set of tags in the database to find a policy
+// match with an action accepted by the filter. A nil filter accepts all the
+// actions. When the order of the policies matters, because of their
+// priorities or of the conflict mode, all the matches are evaluated and the
+// first policy in the order of the mode is returned. Otherwise the first
+// match is returned.
+func (m *PolicyDB) SearchFunc(tags *policy.TagStore, filter func(action interface{}) bool) (int, interface{}) {
+
+	ordered := m.mode.Ordered(m.priorities)
+
+	var best *ForwardingPolicy
+
+	// hit records a match and returns true if the search is over
+	hit := func(p *ForwardingPolicy) bool {
+		if filter != nil && !filter(p.actions) {
+			return false
+		}
+		if best == nil || m.mode.Precedes(p.order, best.order) {
+			best = p
+		}
+		return !ordered
+	}
 
 	count := make([]int, m.numberOfPolicies+1)
 
 	skip := make([]bool, m.numberOfPolicies+1)
 
+	// The clauses of the equal table and of the matchers are counted once,
+	// even if several tags have their key or a tag matches several values
+	matched := make([]bool, m.numberOfClauses)
+
 	// Disable all policies that fail the not key exists
 	copiedTags := tags.GetSlice()
 	var k, v string
@@ -191,15 +334,15 @@ func (m *PolicyDB) Search(tags *policy.TagStore) (int, interface{}) {
 			continue
 		}
 		// Search for matches of k=v
-		if index, action := searchInMapTabe(m.equalMapTable[k][v], count, skip); index >= 0 {
-			return index, action
+		if searchClauses(m.equalMapTable[k][v], matched, count, skip, hit) {
+			return best.index, best.actions
 		}
 
 		// Search for matches in prefixes
 		for _, i := range m.equalPrefixes[k] {
 			if i <= len(v) {
-				if index, action := searchInMapTabe(m.equalMapTable[k][v[:i]], count, skip); index >= 0 {
-					return index, action
+				if searchClauses(m.equalMapTable[k][v[:i]], matched, count, skip, hit) {
+					return best.index, best.actions
 				}
 			}
 		}
@@ -211,38 +354,79 @@ func (m *PolicyDB) Search(tags *policy.TagStore) (int, interface{}) {
 				continue
 			}
 
-			if index, action := searchInMapTabe(policies, count, skip); index >= 0 {
-				return index, action
+			if searchInMapTabe(policies, count, skip, hit) {
+				return best.index, best.actions
+			}
+		}
+
+		// Evaluate the clauses that are not indexed on the value of the tag
+		for _, matcher := range m.matcherTable[k] {
+			if matched[matcher.id] || !matcher.match(v) {
+				continue
+			}
+			matched[matcher.id] = true
+
+			if hitPolicy(matcher.policy, count, skip) && hit(matcher.policy) {
+				return best.index, best.actions
 			}
 		}
 	}
 
 	if m.defaultNotExistsPolicy != nil && !skip[m.defaultNotExistsPolicy.index] {
-		return m.defaultNotExistsPolicy.index, m.defaultNotExistsPolicy.actions
+		hit(m.defaultNotExistsPolicy)
 	}
 
-	return -1, nil
+	if best == nil {
+		return -1, nil
+	}
+
+	return best.index, best.actions
 }
 
-func searchInMapTabe(table []*ForwardingPolicy, count []int, skip []bool) (int, interface{}) {
+// searchInMapTabe counts the hits of the policies of the table and calls hit
+// for the policies that match. It returns true if the search is over.
+func searchInMapTabe(table []*ForwardingPolicy, count []int, skip []bool, hit func(*ForwardingPolicy) bool) bool {
 	for _, policy := range table {
+		if hitPolicy(policy, count, skip) && hit(policy) {
+			return true
+		}
+	}
 
-		// Skip the policy if we have marked it
-		if skip[policy.index] {
+	return false
+}
+
+// searchClauses counts the hits of the clauses of the table that have not
+// been counted yet and calls hit for the policies that match. It returns
+// true if the search is over.
+func searchClauses(table []*indexedClause, matched []bool, count []int, skip []bool, hit func(*ForwardingPolicy) bool) bool {
+	for _, c := range table {
+		if matched[c.id] {
 			continue
 		}
+		matched[c.id] = true
 
-		// Since a policy is hit, the count of remaining tags is reduced by one
-		count[policy.index]++
-
-		// If all tags of the policy have been hit, there is a match
-		if count[policy.index] == policy.count {
-			return policy.index, policy.actions
+		if hitPolicy(c.ForwardingPolicy, count, skip) && hit(c.ForwardingPolicy) {
+			return true
 		}
+	}
 
+	return false
+}
+
+// hitPolicy counts a hit of a clause of the policy and returns true if all
+// the clauses of the policy have been hit
+func hitPolicy(policy *ForwardingPolicy, count []int, skip []bool) bool {
+
+	// Skip the policy if we have marked it
+	if skip[policy.index] {
+		return false
 	}
 
-	return -1, nil
+	// Since a policy is hit, the count of remaining tags is reduced by one
+	count[policy.index]++
+
+	// If all tags of the policy have been hit, there is a match
+	return count[policy.index] == policy.count
 }
 
 // PrintPolicyDB is a debugging function to dump the map
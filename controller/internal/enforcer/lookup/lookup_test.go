@@ -47,6 +47,12 @@ var (
 		Operator: policy.KeyNotExists,
 	}
 
+	appEqBilling = policy.KeyValueOperator{
+		Key:      "app",
+		Value:    []string{"billing"},
+		Operator: policy.Equal,
+	}
+
 	vulnerKey = policy.KeyValueOperator{
 		Key:      "vulnerability",
 		Value:    []string{"high"},
@@ -402,6 +408,50 @@ func TestFuncSearch(t *testing.T) {
 	})
 }
 
+func TestFuncSearchSpiffe(t *testing.T) {
+	// policy1: $spiffe:id=spiffe://example.org/ns/prod/*
+	// policy2: $spiffe:trustdomain=partner.com and app=billing
+
+	Convey("Given a policyDB with SPIFFE selectors", t, func() {
+		policyDB := NewPolicyDB()
+
+		index1 := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{policy.NewSpiffeIDClause("spiffe://example.org/ns/prod/*")},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		})
+		index2 := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{policy.NewSpiffeTrustDomainClause("partner.com"), appEqBilling},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		})
+
+		Convey("The SPIFFE ID prefix should match", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue(policy.SpiffeIDKey, "spiffe://example.org/ns/prod/sa/web")
+
+			index, _ := policyDB.Search(tags)
+			So(index, ShouldEqual, index1)
+		})
+
+		Convey("Another SPIFFE ID should not match", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue(policy.SpiffeIDKey, "spiffe://example.org/ns/dev/sa/web")
+
+			index, _ := policyDB.Search(tags)
+			So(index, ShouldEqual, -1)
+		})
+
+		Convey("The trust domain and the tag should match", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue(policy.SpiffeIDKey, "spiffe://partner.com/billing")
+			tags.AppendKeyValue(policy.SpiffeTrustDomainKey, "partner.com")
+			tags.AppendKeyValue("app", "billing")
+
+			index, _ := policyDB.Search(tags)
+			So(index, ShouldEqual, index2)
+		})
+	})
+}
+
 // TestFuncDumbDB is a mock test for the print function
 func TestFuncDumpDB(t *testing.T) {
 	Convey("Given an empty policy DB", t, func() {
@@ -418,3 +468,226 @@ func TestFuncDumpDB(t *testing.T) {
 		})
 	})
 }
+
+// TestFuncSearchOperators tests the search of the prefix, regex and numeric operators
+func TestFuncSearchOperators(t *testing.T) {
+
+	teamPrefix := policy.KeyValueOperator{
+		Key:      "team",
+		Value:    []string{"payments-", "pay", "payments-eu"},
+		Operator: policy.Prefix,
+	}
+
+	envRegex := policy.KeyValueOperator{
+		Key:      "env",
+		Value:    []string{"prod|staging", "dev-[0-9]+"},
+		Operator: policy.Regex,
+	}
+
+	versionAtLeast2 := policy.KeyValueOperator{
+		Key:      "version",
+		Value:    []string{"2"},
+		Operator: policy.GreaterOrEqual,
+	}
+
+	versionBelow2 := policy.KeyValueOperator{
+		Key:      "version",
+		Value:    []string{"2"},
+		Operator: policy.LessThan,
+	}
+
+	Convey("Given a policyDB with the prefix, regex and numeric operators", t, func() {
+		policyDB := NewPolicyDB()
+
+		indexPrefix := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{teamPrefix, appEqWeb},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		})
+		indexRegex := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{envRegex, versionAtLeast2},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		})
+		indexNumeric := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{versionBelow2},
+			Policy: &policy.FlowPolicy{Action: policy.Reject},
+		})
+
+		search := func(tags ...string) int {
+			index, _ := policyDB.Search(policy.NewTagStoreFromSlice(tags))
+			return index
+		}
+
+		Convey("The prefixes should be indexed like the equal values with a trailing *", func() {
+			So(policyDB.equalPrefixes["team"], ShouldResemble, intList{11, 9, 3})
+		})
+
+		Convey("The prefix operator should match the values that start with a prefix", func() {
+			So(search("team=payments-eu", "app=web"), ShouldEqual, indexPrefix)
+			So(search("team=paypal", "app=web"), ShouldEqual, indexPrefix)
+			So(search("team=payments-eu"), ShouldEqual, -1)
+			So(search("team=billing", "app=web"), ShouldEqual, -1)
+		})
+
+		Convey("The regex and numeric operators should match the entire values and the numbers", func() {
+			So(search("env=prod", "version=2"), ShouldEqual, indexRegex)
+			So(search("env=dev-12", "version=10.5"), ShouldEqual, indexRegex)
+			So(search("env=production", "version=3"), ShouldEqual, -1)
+			So(search("env=prod", "version=latest"), ShouldEqual, -1)
+			So(search("version=1.9"), ShouldEqual, indexNumeric)
+		})
+
+		Convey("A clause should be counted once when several tags have its key", func() {
+			So(search("env=prod", "env=staging"), ShouldEqual, -1)
+		})
+	})
+
+	Convey("Given a policyDB with a prefix clause and an equal clause", t, func() {
+		policyDB := NewPolicyDB()
+		index := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{Key: "team", Value: []string{"pay"}, Operator: policy.Prefix},
+				{Key: "env", Value: []string{"prod"}, Operator: policy.Equal},
+			},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		})
+		starIndex := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{Key: "dept", Value: []string{"fin*"}, Operator: policy.Equal},
+				{Key: "env", Value: []string{"prod"}, Operator: policy.Equal},
+			},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		})
+
+		search := func(tags ...string) int {
+			index, _ := policyDB.Search(policy.NewTagStoreFromSlice(tags))
+			return index
+		}
+
+		Convey("A value equal to the prefix should count the clause once", func() {
+			So(search("team=pay"), ShouldEqual, -1)
+			So(search("dept=fin"), ShouldEqual, -1)
+			So(search("team=pay", "env=prod"), ShouldEqual, index)
+			So(search("dept=fin", "env=prod"), ShouldEqual, starIndex)
+		})
+
+		Convey("Several tags with the key should count the clause once", func() {
+			So(search("team=pay-a", "team=pay-b"), ShouldEqual, -1)
+			So(search("dept=fin-a", "dept=fin-b"), ShouldEqual, -1)
+			So(search("team=pay-a", "team=pay-b", "env=prod"), ShouldEqual, index)
+		})
+	})
+
+	Convey("Given a policyDB with an invalid clause", t, func() {
+		policyDB := NewPolicyDB()
+		policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{{Key: "env", Value: []string{"prod("}, Operator: policy.Regex}},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		})
+
+		Convey("The policy should never match", func() {
+			index, _ := policyDB.Search(policy.NewTagStoreFromSlice([]string{"env=prod("}))
+			So(index, ShouldEqual, -1)
+		})
+	})
+
+	Convey("Given a policyDB with several policies with the same key operators", t, func() {
+		policyDB := NewPolicyDB()
+		index := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{dcKeyExists, appEqWeb},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		})
+		policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{dcKeyExists, envEqDemo},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		})
+
+		Convey("The prefixes should be unique and the clauses should be counted once", func() {
+			So(policyDB.equalPrefixes["dc"], ShouldResemble, intList{0})
+
+			found, _ := policyDB.Search(policy.NewTagStoreFromSlice([]string{"dc=eu"}))
+			So(found, ShouldEqual, -1)
+
+			found, _ = policyDB.Search(policy.NewTagStoreFromSlice([]string{"dc=eu", "app=web"}))
+			So(found, ShouldEqual, index)
+		})
+	})
+}
+
+func TestFuncSearchPriorities(t *testing.T) {
+
+	accept := &policy.FlowPolicy{Action: policy.Accept, PolicyID: "accept"}
+	reject := &policy.FlowPolicy{Action: policy.Reject, PolicyID: "reject"}
+	specific := &policy.FlowPolicy{Action: policy.Accept, PolicyID: "specific"}
+
+	tags := policy.NewTagStoreFromSlice([]string{"app=web", "env=demo", "dc=eu"})
+
+	add := func(policyDB *PolicyDB) {
+		policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{appEqWeb},
+			Policy: accept,
+		})
+		policyDB.AddPolicy(policy.TagSelector{
+			Clause:   []policy.KeyValueOperator{envEqDemo},
+			Policy:   reject,
+			Priority: 10,
+		})
+		policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{dcKeyExists, appEqWeb, envEqDemo},
+			Policy: specific,
+		})
+	}
+
+	Convey("Given a policyDB with priorities in the first match mode", t, func() {
+		policyDB := NewPolicyDBWithMode(policy.FirstMatch)
+		add(policyDB)
+
+		Convey("The policy with the highest priority should match", func() {
+			index, action := policyDB.Search(tags)
+			So(index, ShouldEqual, 2)
+			So(action, ShouldEqual, reject)
+		})
+
+		Convey("The first policy accepted by the filter should match", func() {
+			index, action := policyDB.SearchFunc(tags, func(action interface{}) bool {
+				return action.(*policy.FlowPolicy).Action.Accepted()
+			})
+			So(index, ShouldEqual, 1)
+			So(action, ShouldEqual, accept)
+		})
+	})
+
+	Convey("Given a policyDB with priorities in the most specific mode", t, func() {
+		policyDB := NewPolicyDBWithMode(policy.MostSpecific)
+		add(policyDB)
+
+		Convey("The policy with the most clauses should match", func() {
+			index, action := policyDB.Search(tags)
+			So(index, ShouldEqual, 3)
+			So(action, ShouldEqual, specific)
+		})
+
+		Convey("The priority should order the policies that are as specific", func() {
+			index, _ := policyDB.Search(policy.NewTagStoreFromSlice([]string{"app=web", "env=demo"}))
+			So(index, ShouldEqual, 2)
+		})
+	})
+
+	Convey("Given a policyDB of the same action with priorities", t, func() {
+		policyDB := NewPolicyDB()
+		policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{appEqWeb},
+			Policy: accept,
+		})
+		policyDB.AddPolicy(policy.TagSelector{
+			Clause:   []policy.KeyValueOperator{envEqDemo},
+			Policy:   specific,
+			Priority: 1,
+		})
+
+		Convey("The policy with the highest priority should match", func() {
+			index, action := policyDB.Search(tags)
+			So(index, ShouldEqual, 2)
+			So(action, ShouldEqual, specific)
+		})
+	})
+}
@@ -10,6 +10,7 @@ import (
 
 	portset "github.com/aporeto-inc/trireme-lib/controller/internal/portset"
 	fqconfig "github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
+	introspection "github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
 	secrets "github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
 	policy "github.com/aporeto-inc/trireme-lib/policy"
 	gomock "github.com/golang/mock/gomock"
@@ -125,3 +126,63 @@ func (m *MockEnforcer) UpdateSecrets(secrets secrets.Secrets) error {
 func (mr *MockEnforcerMockRecorder) UpdateSecrets(secrets interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateSecrets", reflect.TypeOf((*MockEnforcer)(nil).UpdateSecrets), secrets)
 }
+
+// ListPUs mocks base method
+// nolint
+func (m *MockEnforcer) ListPUs() ([]*introspection.PUState, error) {
+	ret := m.ctrl.Call(m, "ListPUs")
+	ret0, _ := ret[0].([]*introspection.PUState)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListPUs indicates an expected call of ListPUs
+// nolint
+func (mr *MockEnforcerMockRecorder) ListPUs() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPUs", reflect.TypeOf((*MockEnforcer)(nil).ListPUs))
+}
+
+// GetPU mocks base method
+// nolint
+func (m *MockEnforcer) GetPU(contextID string) (*introspection.PUState, error) {
+	ret := m.ctrl.Call(m, "GetPU", contextID)
+	ret0, _ := ret[0].(*introspection.PUState)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPU indicates an expected call of GetPU
+// nolint
+func (mr *MockEnforcerMockRecorder) GetPU(contextID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPU", reflect.TypeOf((*MockEnforcer)(nil).GetPU), contextID)
+}
+
+// ListConnections mocks base method
+// nolint
+func (m *MockEnforcer) ListConnections(contextID string) ([]*introspection.ConnectionState, error) {
+	ret := m.ctrl.Call(m, "ListConnections", contextID)
+	ret0, _ := ret[0].([]*introspection.ConnectionState)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListConnections indicates an expected call of ListConnections
+// nolint
+func (mr *MockEnforcerMockRecorder) ListConnections(contextID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListConnections", reflect.TypeOf((*MockEnforcer)(nil).ListConnections), contextID)
+}
+
+// ListPeerKeys mocks base method
+// nolint
+func (m *MockEnforcer) ListPeerKeys(contextID string) ([]secrets.PeerKey, error) {
+	ret := m.ctrl.Call(m, "ListPeerKeys", contextID)
+	ret0, _ := ret[0].([]secrets.PeerKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListPeerKeys indicates an expected call of ListPeerKeys
+// nolint
+func (mr *MockEnforcerMockRecorder) ListPeerKeys(contextID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPeerKeys", reflect.TypeOf((*MockEnforcer)(nil).ListPeerKeys), contextID)
+}
@@ -18,11 +18,14 @@ import (
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/nfqdatapath/tokenaccessor"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/portset"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/connection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/ebpf"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/metrics"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packetprocessor"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/pucontext"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/tokens"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
 	"github.com/aporeto-inc/trireme-lib/utils/portcache"
@@ -67,6 +70,15 @@ type Datapath struct {
 	netReplyConnectionTracker   cache.DataStore
 	unknownSynConnectionTracker cache.DataStore
 
+	// UDP flows are tracked in separate caches since their five-tuple
+	// can overlap with TCP connections. The source port cache captures
+	// replies with possible NAT.
+	udpSourcePortConnectionCache cache.DataStore
+	udpAppOrigConnectionTracker  cache.DataStore
+	udpAppReplyConnectionTracker cache.DataStore
+	udpNetOrigConnectionTracker  cache.DataStore
+	udpNetReplyConnectionTracker cache.DataStore
+
 	// CacheTimeout used for Trireme auto-detecion
 	ExternalIPCacheTimeout time.Duration
 
@@ -76,13 +88,17 @@ type Datapath struct {
 	// mode captures the mode of the enforcer
 	mode constants.ModeType
 
-	// ack size
-	ackSize uint32
-
 	mutualAuthorization bool
 	packetLogs          bool
 
 	portSetInstance portset.PortSet
+
+	// bpf is the eBPF fast path. It is nil if the fast path is disabled
+	// or not supported.
+	bpf ebpf.BPFModule
+	// offloadedFlows tracks the flows of the fast path and their PU.
+	// Key=protocol and flow hash Value=offloadedFlow
+	offloadedFlows cache.DataStore
 }
 
 // New will create a new data path structure. It instantiates the data stores
@@ -163,14 +179,21 @@ func New(
 		collector:                   collector,
 		tokenAccessor:               tokenaccessor,
 		secrets:                     secrets,
-		ackSize:                     secrets.AckSize(),
 		mode:                        mode,
 		procMountPoint:              procMountPoint,
 		conntrackHdl:                conntrack.NewHandle(),
 		portSetInstance:             portSetInstance,
 		packetLogs:                  packetLogs,
+
+		udpSourcePortConnectionCache: cache.NewCacheWithExpiration("udpSourcePortConnectionCache", time.Second*24),
+		udpAppOrigConnectionTracker:  cache.NewCacheWithExpiration("udpAppOrigConnectionTracker", time.Second*24),
+		udpAppReplyConnectionTracker: cache.NewCacheWithExpiration("udpAppReplyConnectionTracker", time.Second*24),
+		udpNetOrigConnectionTracker:  cache.NewCacheWithExpiration("udpNetOrigConnectionTracker", time.Second*24),
+		udpNetReplyConnectionTracker: cache.NewCacheWithExpiration("udpNetReplyConnectionTracker", time.Second*24),
 	}
 
+	d.offloadedFlows = cache.NewCacheWithExpirationNotifier("offloadedFlows", offloadedFlowLifetime, d.expireOffloadedFlow)
+
 	packet.PacketLogLevel = packetLogs
 
 	d.nflogger = nflog.NewNFLogger(11, 10, d.puInfoDelegate, collector)
@@ -201,7 +224,7 @@ func NewWithDefaults(
 	}
 	defaultPacketLogs := false
 
-	tokenaccessor, err := tokenaccessor.New(serverID, defaultValidity, secrets)
+	tokenaccessor, err := tokenaccessor.New(serverID, defaultValidity, secrets, tokens.JWTFormat, nil, nil)
 	if err != nil {
 		zap.L().Fatal("Cannot create a token engine")
 	}
@@ -230,6 +253,11 @@ func (d *Datapath) Enforce(contextID string, puInfo *policy.PUInfo) error {
 
 	zap.L().Debug("Called Proxy Enforce")
 
+	// The flows of the fast path were authorized by the previous policy
+	if _, err := d.puFromContextID.Get(contextID); err == nil {
+		d.flushOffloadedFlows(contextID)
+	}
+
 	// Always create a new PU context
 	pu, err := pucontext.NewPU(contextID, puInfo, d.ExternalIPCacheTimeout)
 	if err != nil {
@@ -287,6 +315,9 @@ func (d *Datapath) Unenforce(contextID string) error {
 		}
 	}
 
+	// Cleanup the flows of the fast path
+	d.flushOffloadedFlows(contextID)
+
 	// Cleanup the contextID cache
 	if err := d.puFromContextID.RemoveWithDelay(contextID, 10*time.Second); err != nil {
 		zap.L().Warn("Unable to remove context from cache",
@@ -318,6 +349,10 @@ func (d *Datapath) Run(ctx context.Context) error {
 		d.service.Initialize(d.secrets, d.filterQueue)
 	}
 
+	d.startFastPath(ctx)
+
+	d.trackCaches(ctx)
+
 	d.startApplicationInterceptor(ctx)
 	d.startNetworkInterceptor(ctx)
 
@@ -326,6 +361,39 @@ func (d *Datapath) Run(ctx context.Context) error {
 	return nil
 }
 
+// trackCaches reports the size of the caches of the datapath in the metrics
+// until the context is done
+func (d *Datapath) trackCaches(ctx context.Context) {
+
+	caches := map[string]cache.DataStore{
+		"puFromContextID":              d.puFromContextID,
+		"puFromMark":                   d.puFromMark,
+		"sourcePortConnectionCache":    d.sourcePortConnectionCache,
+		"appOrigConnectionTracker":     d.appOrigConnectionTracker,
+		"appReplyConnectionTracker":    d.appReplyConnectionTracker,
+		"netOrigConnectionTracker":     d.netOrigConnectionTracker,
+		"netReplyConnectionTracker":    d.netReplyConnectionTracker,
+		"unknownSynConnectionTracker":  d.unknownSynConnectionTracker,
+		"udpSourcePortConnectionCache": d.udpSourcePortConnectionCache,
+		"udpAppOrigConnectionTracker":  d.udpAppOrigConnectionTracker,
+		"udpAppReplyConnectionTracker": d.udpAppReplyConnectionTracker,
+		"udpNetOrigConnectionTracker":  d.udpNetOrigConnectionTracker,
+		"udpNetReplyConnectionTracker": d.udpNetReplyConnectionTracker,
+		"offloadedFlows":               d.offloadedFlows,
+	}
+
+	for name, c := range caches {
+		metrics.CacheEntries.Track(name, c)
+	}
+
+	go func() {
+		<-ctx.Done()
+		for name := range caches {
+			metrics.CacheEntries.Untrack(name)
+		}
+	}()
+}
+
 // UpdateSecrets updates the secrets used for signing communication between trireme instances
 func (d *Datapath) UpdateSecrets(token secrets.Secrets) error {
 
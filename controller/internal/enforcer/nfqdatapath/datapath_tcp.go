@@ -12,6 +12,7 @@ import (
 	"github.com/aporeto-inc/trireme-lib/controller/constants"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/constants"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/connection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/metrics"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/pucontext"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/tokens"
@@ -43,6 +44,8 @@ func (d *Datapath) processNetworkTCPPackets(p *packet.Packet) (err error) {
 	// skip processing for SynAck packets that we don't have state
 	switch p.TCPFlags & packet.TCPSynAckMask {
 	case packet.TCPSynMask:
+		// A new connection on the tuple of an offloaded flow
+		d.removeOffloadedFlow(p)
 		conn, err = d.netSynRetrieveState(p)
 		if err != nil {
 			if d.packetLogs {
@@ -157,6 +160,8 @@ func (d *Datapath) processApplicationTCPPackets(p *packet.Packet) (err error) {
 
 	switch p.TCPFlags & packet.TCPSynAckMask {
 	case packet.TCPSynMask:
+		// A new connection on the tuple of an offloaded flow
+		d.removeOffloadedFlow(p)
 		conn, err = d.appSynRetrieveState(p)
 		if err != nil {
 			if d.packetLogs {
@@ -285,7 +290,10 @@ func (d *Datapath) processApplicationSynPacket(tcpPacket *packet.Packet, context
 	// Create TCP Option
 	tcpOptions := d.createTCPAuthenticationOption([]byte{})
 
-	// Create a token
+	// Create a token. The tags are encrypted for the receiver if its key
+	// is known for the destination.
+	conn.Auth.RemoteIP = tcpPacket.DestinationAddress.String()
+	conn.Auth.RemotePort = strconv.Itoa(int(tcpPacket.DestinationPort))
 	tcpData, err := d.tokenAccessor.CreateSynPacketToken(context, &conn.Auth)
 
 	if err != nil {
@@ -372,8 +380,8 @@ func (d *Datapath) processApplicationAckPacket(tcpPacket *packet.Packet, context
 		tcpOptions := d.createTCPAuthenticationOption([]byte{})
 
 		// Since we adjust sequence numbers let's make sure we haven't made a mistake
-		if len(token) != int(d.ackSize) {
-			return nil, fmt.Errorf("protocol error: tokenlen=%d acksize=%d", len(token), int(d.ackSize))
+		if ackSize := d.tokenAccessor.AckSize(); len(token) != int(ackSize) {
+			return nil, fmt.Errorf("protocol error: tokenlen=%d acksize=%d", len(token), int(ackSize))
 		}
 
 		// Attach the tags to the packet
@@ -501,33 +509,33 @@ func (d *Datapath) processNetworkSynPacket(context *pucontext.PUContext, conn *c
 
 	// Packets that have authorization information go through the auth path
 	// Decode the JWT token using the context key
-	claims, err = d.tokenAccessor.ParsePacketToken(&conn.Auth, tcpPacket.ReadTCPData())
+	claims, err = d.tokenAccessor.ParsePacketToken(&conn.Auth, tcpPacket.ReadTCPData(), metrics.PacketSyn)
 
 	// If the token signature is not valid,
 	// we must drop the connection and we drop the Syn packet. The source will
 	// retry but we have no state to maintain here.
 	if err != nil {
-		d.reportRejectedFlow(tcpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidToken, nil, nil)
+		d.reportRejectedFlow(tcpPacket, conn, "tcp", collector.DefaultEndPoint, context.ManagementID(), context, tokenDropReason(err, collector.InvalidToken), nil, nil)
 		return nil, nil, fmt.Errorf("Syn packet dropped because of invalid token: %s", err)
 	}
 
 	// if there are no claims we must drop the connection and we drop the Syn
 	// packet. The source will retry but we have no state to maintain here.
 	if claims == nil {
-		d.reportRejectedFlow(tcpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidToken, nil, nil)
+		d.reportRejectedFlow(tcpPacket, conn, "tcp", collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidToken, nil, nil)
 		return nil, nil, errors.New("Syn packet dropped because of no claims")
 	}
 
 	txLabel, ok := claims.T.Get(enforcerconstants.TransmitterLabel)
 	if err := tcpPacket.CheckTCPAuthenticationOption(enforcerconstants.TCPAuthenticationOptionBaseLen); !ok || err != nil {
-		d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID(), context, collector.InvalidFormat, nil, nil)
+		d.reportRejectedFlow(tcpPacket, conn, "tcp", txLabel, context.ManagementID(), context, collector.InvalidFormat, nil, nil)
 		return nil, nil, fmt.Errorf("TCP authentication option not found: %s", err)
 	}
 
 	// Remove any of our data from the packet. No matter what we don't need the
 	// metadata any more.
 	if err := tcpPacket.TCPDataDetach(enforcerconstants.TCPAuthenticationOptionBaseLen); err != nil {
-		d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID(), context, collector.InvalidFormat, nil, nil)
+		d.reportRejectedFlow(tcpPacket, conn, "tcp", txLabel, context.ManagementID(), context, collector.InvalidFormat, nil, nil)
 		return nil, nil, fmt.Errorf("Syn packet dropped because of invalid format: %s", err)
 	}
 
@@ -540,7 +548,7 @@ func (d *Datapath) processNetworkSynPacket(context *pucontext.PUContext, conn *c
 
 	report, packet := context.SearchRcvRules(tags)
 	if packet.Action.Rejected() {
-		d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID(), context, collector.PolicyDrop, report, packet)
+		d.reportRejectedFlow(tcpPacket, conn, "tcp", txLabel, context.ManagementID(), context, collector.PolicyDrop, report, packet)
 		return nil, nil, fmt.Errorf("connection rejected because of policy: %s", tags.String())
 	}
 
@@ -625,36 +633,39 @@ func (d *Datapath) processNetworkSynAckPacket(context *pucontext.PUContext, conn
 				zap.String("state", fmt.Sprintf("%d", conn.GetState())),
 			)
 		}
+
+		// The flow is not authorized anymore
+		d.removeOffloadedFlow(tcpPacket)
 	}
 
 	// Now we can process the SynAck packet with its options
 	tcpData := tcpPacket.ReadTCPData()
 	if len(tcpData) == 0 {
-		d.reportRejectedFlow(tcpPacket, nil, collector.DefaultEndPoint, context.ManagementID(), context, collector.MissingToken, nil, nil)
+		d.reportRejectedFlow(tcpPacket, nil, "tcp", collector.DefaultEndPoint, context.ManagementID(), context, collector.MissingToken, nil, nil)
 		return nil, nil, errors.New("SynAck packet dropped because of missing token")
 	}
 
-	claims, err = d.tokenAccessor.ParsePacketToken(&conn.Auth, tcpPacket.ReadTCPData())
+	claims, err = d.tokenAccessor.ParsePacketToken(&conn.Auth, tcpPacket.ReadTCPData(), metrics.PacketSynAck)
 	if err != nil {
-		d.reportRejectedFlow(tcpPacket, nil, collector.DefaultEndPoint, context.ManagementID(), context, collector.MissingToken, nil, nil)
+		d.reportRejectedFlow(tcpPacket, nil, "tcp", collector.DefaultEndPoint, context.ManagementID(), context, tokenDropReason(err, collector.MissingToken), nil, nil)
 		return nil, nil, fmt.Errorf("SynAck packet dropped because of bad claims: %s", err)
 	}
 
 	if claims == nil {
-		d.reportRejectedFlow(tcpPacket, nil, collector.DefaultEndPoint, context.ManagementID(), context, collector.MissingToken, nil, nil)
+		d.reportRejectedFlow(tcpPacket, nil, "tcp", collector.DefaultEndPoint, context.ManagementID(), context, collector.MissingToken, nil, nil)
 		return nil, nil, errors.New("SynAck packet dropped because of no claims")
 	}
 
 	tcpPacket.ConnectionMetadata = &conn.Auth
 
 	if err := tcpPacket.CheckTCPAuthenticationOption(enforcerconstants.TCPAuthenticationOptionBaseLen); err != nil {
-		d.reportRejectedFlow(tcpPacket, conn, context.ManagementID(), conn.Auth.RemoteContextID, context, collector.InvalidFormat, nil, nil)
+		d.reportRejectedFlow(tcpPacket, conn, "tcp", context.ManagementID(), conn.Auth.RemoteContextID, context, collector.InvalidFormat, nil, nil)
 		return nil, nil, errors.New("TCP authentication option not found")
 	}
 
 	// Remove any of our data
 	if err := tcpPacket.TCPDataDetach(enforcerconstants.TCPAuthenticationOptionBaseLen); err != nil {
-		d.reportRejectedFlow(tcpPacket, conn, context.ManagementID(), conn.Auth.RemoteContextID, context, collector.InvalidFormat, nil, nil)
+		d.reportRejectedFlow(tcpPacket, conn, "tcp", context.ManagementID(), conn.Auth.RemoteContextID, context, collector.InvalidFormat, nil, nil)
 		return nil, nil, fmt.Errorf("SynAck packet dropped because of invalid format: %s", err)
 	}
 
@@ -671,7 +682,7 @@ func (d *Datapath) processNetworkSynAckPacket(context *pucontext.PUContext, conn
 
 	report, packet := context.SearchTxtRules(claims.T, !d.mutualAuthorization)
 	if packet.Action.Rejected() {
-		d.reportRejectedFlow(tcpPacket, conn, context.ManagementID(), conn.Auth.RemoteContextID, context, collector.PolicyDrop, report, packet)
+		d.reportRejectedFlow(tcpPacket, conn, "tcp", context.ManagementID(), conn.Auth.RemoteContextID, context, collector.PolicyDrop, report, packet)
 		return nil, nil, fmt.Errorf("dropping because of reject rule on transmitter: %s", claims.T.String())
 	}
 
@@ -726,18 +737,18 @@ func (d *Datapath) processNetworkAckPacket(context *pucontext.PUContext, conn *c
 	if conn.GetState() == connection.TCPSynAckSend || conn.GetState() == connection.TCPSynReceived {
 
 		if err := tcpPacket.CheckTCPAuthenticationOption(enforcerconstants.TCPAuthenticationOptionBaseLen); err != nil {
-			d.reportRejectedFlow(tcpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidFormat, nil, nil)
+			d.reportRejectedFlow(tcpPacket, conn, "tcp", collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidFormat, nil, nil)
 			return nil, nil, fmt.Errorf("TCP authentication option not found: %s", err)
 		}
 
 		if _, err := d.tokenAccessor.ParseAckToken(&conn.Auth, tcpPacket.ReadTCPData()); err != nil {
-			d.reportRejectedFlow(tcpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidFormat, nil, nil)
+			d.reportRejectedFlow(tcpPacket, conn, "tcp", collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidFormat, nil, nil)
 			return nil, nil, fmt.Errorf("Ack packet dropped because signature validation failed: %s", err)
 		}
 
 		// Remove any of our data - adjust the sequence numbers
 		if err := tcpPacket.TCPDataDetach(enforcerconstants.TCPAuthenticationOptionBaseLen); err != nil {
-			d.reportRejectedFlow(tcpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidFormat, nil, nil)
+			d.reportRejectedFlow(tcpPacket, conn, "tcp", collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidFormat, nil, nil)
 			return nil, nil, fmt.Errorf("Ack packet dropped because of invalid format: %s", err)
 		}
 
@@ -748,7 +759,7 @@ func (d *Datapath) processNetworkAckPacket(context *pucontext.PUContext, conn *c
 				zap.L().Error("Flow rejected but not observed", zap.String("conn", context.ManagementID()))
 			}
 			// Flow has been allowed because we are observing a deny rule's impact on the system. Packets are forwarded, reported as dropped + observed.
-			d.reportRejectedFlow(tcpPacket, conn, conn.Auth.RemoteContextID, context.ManagementID(), context, collector.PolicyDrop, conn.ReportFlowPolicy, conn.PacketFlowPolicy)
+			d.reportRejectedFlow(tcpPacket, conn, "tcp", conn.Auth.RemoteContextID, context.ManagementID(), context, collector.PolicyDrop, conn.ReportFlowPolicy, conn.PacketFlowPolicy)
 		} else {
 			// We accept the packet as a new flow
 			d.reportAcceptedFlow(tcpPacket, conn, conn.Auth.RemoteContextID, context.ManagementID(), context, conn.ReportFlowPolicy, conn.PacketFlowPolicy)
@@ -778,7 +789,7 @@ func (d *Datapath) processNetworkAckPacket(context *pucontext.PUContext, conn *c
 	}
 
 	// Everything else is dropped - ACK received in the Syn state without a SynAck
-	d.reportRejectedFlow(tcpPacket, conn, conn.Auth.RemoteContextID, context.ManagementID(), context, collector.InvalidState, nil, nil)
+	d.reportRejectedFlow(tcpPacket, conn, "tcp", conn.Auth.RemoteContextID, context.ManagementID(), context, collector.InvalidState, nil, nil)
 	zap.L().Error("Invalid state reached",
 		zap.String("state", fmt.Sprintf("%d", conn.GetState())),
 		zap.String("context", context.ManagementID()),
@@ -1048,5 +1059,7 @@ func (d *Datapath) releaseFlow(context *pucontext.PUContext, report *policy.Flow
 		zap.L().Error("Failed to update conntrack table", zap.Error(err))
 	}
 
+	d.offloadFlow(context.ID(), tcpPacket)
+
 	d.reportReverseExternalServiceFlow(context, report, action, true, tcpPacket)
 }
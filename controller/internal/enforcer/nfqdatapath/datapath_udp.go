@@ -0,0 +1,503 @@
+package nfqdatapath
+
+// UDP flows are authorized by carrying the tokens in the datagrams of the flow.
+// The initiator prefixes its datagrams with a syn token until it receives a
+// reply. The receiver validates the token, removes it and prefixes its replies
+// with a synack token until it receives a datagram without a token. At that
+// point the flow is authorized on both sides and it is released to the kernel.
+// Datagrams of flows that never see a reply keep carrying the same token,
+// which is validated only once.
+
+// Go libraries
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/controller/constants"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/constants"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/connection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/metrics"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/pucontext"
+)
+
+// processNetworkUDPPackets processes UDP packets arriving from network and are destined to the application
+func (d *Datapath) processNetworkUDPPackets(p *packet.Packet) (err error) {
+
+	if d.packetLogs {
+		zap.L().Debug("Processing network udp packet ",
+			zap.String("flow", p.L4FlowHash()),
+		)
+
+		defer zap.L().Debug("Finished Processing network udp packet ",
+			zap.String("flow", p.L4FlowHash()),
+			zap.Error(err),
+		)
+	}
+
+	conn, err := d.netUDPRetrieveState(p)
+	if err != nil {
+		if d.packetLogs {
+			zap.L().Debug("Packet rejected",
+				zap.String("flow", p.L4FlowHash()),
+				zap.Error(err),
+			)
+		}
+		return err
+	}
+
+	conn.Lock()
+	defer conn.Unlock()
+
+	p.Print(packet.PacketStageIncoming)
+
+	if err = d.processNetworkUDPPacket(p, conn.Context, conn); err != nil {
+		p.Print(packet.PacketFailureAuth)
+		if d.packetLogs {
+			zap.L().Debug("Rejecting packet ",
+				zap.String("flow", p.L4FlowHash()),
+				zap.Error(err),
+			)
+		}
+		return fmt.Errorf("packet processing failed for network udp packet: %s", err)
+	}
+
+	// Accept the packet
+	p.UpdateUDPChecksum()
+	p.Print(packet.PacketStageOutgoing)
+
+	return nil
+}
+
+// processApplicationUDPPackets processes UDP packets arriving from an application and are destined to the network
+func (d *Datapath) processApplicationUDPPackets(p *packet.Packet) (err error) {
+
+	if d.packetLogs {
+		zap.L().Debug("Processing application udp packet ",
+			zap.String("flow", p.L4FlowHash()),
+		)
+
+		defer zap.L().Debug("Finished Processing application udp packet ",
+			zap.String("flow", p.L4FlowHash()),
+			zap.Error(err),
+		)
+	}
+
+	conn, err := d.appUDPRetrieveState(p)
+	if err != nil {
+		if d.packetLogs {
+			zap.L().Debug("Packet rejected",
+				zap.String("flow", p.L4FlowHash()),
+				zap.Error(err),
+			)
+		}
+		return err
+	}
+
+	conn.Lock()
+	defer conn.Unlock()
+
+	p.Print(packet.PacketStageIncoming)
+
+	if err = d.processApplicationUDPPacket(p, conn.Context, conn); err != nil {
+		p.Print(packet.PacketFailureAuth)
+		if d.packetLogs {
+			zap.L().Debug("Dropping packet  ",
+				zap.String("flow", p.L4FlowHash()),
+				zap.Error(err),
+			)
+		}
+		return fmt.Errorf("processing failed for application udp packet: %s", err)
+	}
+
+	// Accept the packet
+	p.UpdateUDPChecksum()
+	p.Print(packet.PacketStageOutgoing)
+
+	return nil
+}
+
+// processApplicationUDPPacket processes an application datagram based on the state of its flow
+func (d *Datapath) processApplicationUDPPacket(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection) error {
+
+	switch conn.GetState() {
+	case connection.UDPStart:
+		return d.processApplicationUDPSynPacket(udpPacket, context, conn)
+
+	case connection.UDPSynSend:
+		// No reply yet. Send the same token again.
+		return udpPacket.UDPTokenAttach(packet.UDPSynPacket, conn.Token)
+
+	case connection.UDPSynReceived:
+		return d.processApplicationUDPSynAckPacket(udpPacket, context, conn)
+
+	case connection.UDPSynAckSend:
+		// The initiator has not confirmed our token yet. Send the same token again.
+		return udpPacket.UDPTokenAttach(packet.UDPSynAckPacket, conn.Token)
+
+	case connection.UDPSynAckReceived:
+		// The peer is authorized. Datagrams without a token signal the
+		// peer that it can release the flow.
+		return nil
+
+	case connection.UDPData:
+		// Flows accepted by the ACLs are released on the first reply, once
+		// the kernel has confirmed the conntrack entry.
+		if _, err := d.udpAppReplyConnectionTracker.Get(udpPacket.L4FlowHash()); err == nil {
+			d.releaseUDPFlow(conn, udpPacket, true)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("invalid udp flow state: %d", conn.GetState())
+}
+
+// processApplicationUDPSynPacket processes the first datagram of a flow initiated by the application
+func (d *Datapath) processApplicationUDPSynPacket(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection) error {
+
+	// A new flow on the tuple of an offloaded flow
+	d.removeOffloadedFlow(udpPacket)
+
+	hash := udpPacket.L4FlowHash()
+
+	// Destinations covered by the application ACLs are external services that
+	// cannot process our tokens. The ACLs decide about the flow.
+	if report, plc, err := context.ApplicationUDPACLPolicyFromAddr(udpPacket.DestinationAddress, udpPacket.DestinationPort); err == nil {
+		d.reportExternalServiceFlow(context, report, plc, true, udpPacket)
+		if plc.Action.Rejected() {
+			return fmt.Errorf("udp flow rejected by application acls: %s", plc.PolicyID)
+		}
+
+		conn.SetState(connection.UDPData)
+		d.udpAppOrigConnectionTracker.AddOrUpdate(hash, conn)
+		d.udpNetReplyConnectionTracker.AddOrUpdate(udpPacket.L4ReverseFlowHash(), conn)
+		return nil
+	}
+
+	// The token engine caches and randomizes the syn token in place. Keep our
+	// own copy for the retransmissions of this flow. The tags are encrypted
+	// for the receiver if its key is known for the destination.
+	conn.Auth.RemoteIP = udpPacket.DestinationAddress.String()
+	conn.Auth.RemotePort = strconv.Itoa(int(udpPacket.DestinationPort))
+	token, err := d.tokenAccessor.CreateSynPacketToken(context, &conn.Auth)
+	if err != nil {
+		return err
+	}
+
+	if len(token) == 0 {
+		return errors.New("unable to create syn token")
+	}
+
+	conn.Token = append([]byte{}, token...)
+	conn.SetState(connection.UDPSynSend)
+
+	// Populate the caches to track the flow
+	d.udpAppOrigConnectionTracker.AddOrUpdate(hash, conn)
+	d.udpNetReplyConnectionTracker.AddOrUpdate(udpPacket.L4ReverseFlowHash(), conn)
+	d.udpSourcePortConnectionCache.AddOrUpdate(udpPacket.SourcePortHash(packet.PacketTypeApplication), conn)
+
+	return udpPacket.UDPTokenAttach(packet.UDPSynPacket, conn.Token)
+}
+
+// processApplicationUDPSynAckPacket processes the first reply of the application to an authorized flow
+func (d *Datapath) processApplicationUDPSynAckPacket(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection) error {
+
+	token, err := d.tokenAccessor.CreateSynAckPacketToken(context, &conn.Auth)
+	if err != nil {
+		return err
+	}
+
+	if len(token) == 0 {
+		return errors.New("unable to create synack token")
+	}
+
+	conn.Token = token
+	conn.SetState(connection.UDPSynAckSend)
+
+	return udpPacket.UDPTokenAttach(packet.UDPSynAckPacket, conn.Token)
+}
+
+// processNetworkUDPPacket processes a network datagram based on its authorization header
+func (d *Datapath) processNetworkUDPPacket(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection) error {
+
+	if udpPacket.CheckUDPAuthenticationMarker() != nil {
+		return d.processNetworkUDPDataPacket(udpPacket, context, conn)
+	}
+
+	switch udpPacket.UDPAuthPacketType() {
+	case packet.UDPSynPacket:
+		return d.processNetworkUDPSynPacket(udpPacket, context, conn)
+
+	case packet.UDPSynAckPacket:
+		return d.processNetworkUDPSynAckPacket(udpPacket, context, conn)
+	}
+
+	d.reportRejectedFlow(udpPacket, conn, "udp", collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidFormat, nil, nil)
+	return fmt.Errorf("invalid udp authorization packet type: %d", udpPacket.UDPAuthPacketType())
+}
+
+// processNetworkUDPSynPacket processes a datagram carrying the token of the initiator of a flow
+func (d *Datapath) processNetworkUDPSynPacket(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection) error {
+
+	// A new flow on the tuple of an offloaded flow
+	d.removeOffloadedFlow(udpPacket)
+
+	token := udpPacket.ReadUDPToken()
+
+	// Retransmissions carry the same token that has already been validated
+	if conn.GetState() != connection.UDPStart && bytes.Equal(token, conn.PeerToken) {
+		return udpPacket.UDPTokenDetach()
+	}
+
+	claims, err := d.tokenAccessor.ParsePacketToken(&conn.Auth, token, metrics.PacketSyn)
+	if err != nil {
+		d.reportRejectedFlow(udpPacket, conn, "udp", collector.DefaultEndPoint, context.ManagementID(), context, tokenDropReason(err, collector.InvalidToken), nil, nil)
+		return fmt.Errorf("udp syn packet dropped because of invalid token: %s", err)
+	}
+
+	if claims == nil {
+		d.reportRejectedFlow(udpPacket, conn, "udp", collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidToken, nil, nil)
+		return errors.New("udp syn packet dropped because of no claims")
+	}
+
+	// The token is removed in place. Keep a copy to detect retransmissions.
+	peerToken := append([]byte{}, token...)
+
+	if err = udpPacket.UDPTokenDetach(); err != nil {
+		d.reportRejectedFlow(udpPacket, conn, "udp", conn.Auth.RemoteContextID, context.ManagementID(), context, collector.InvalidFormat, nil, nil)
+		return fmt.Errorf("udp syn packet dropped because of invalid format: %s", err)
+	}
+
+	// Add the port as a label with an @ prefix. These labels are invalid otherwise
+	// If all policies are restricted by port numbers this will allow port-specific policies
+	tags := claims.T.Copy()
+	tags.AppendKeyValue(enforcerconstants.PortNumberLabelString, strconv.Itoa(int(udpPacket.DestinationPort)))
+
+	report, plc := context.SearchRcvRules(tags)
+	if plc.Action.Rejected() {
+		d.reportRejectedFlow(udpPacket, conn, "udp", conn.Auth.RemoteContextID, context.ManagementID(), context, collector.PolicyDrop, report, plc)
+		return fmt.Errorf("udp flow rejected because of policy: %s", tags.String())
+	}
+
+	conn.PeerToken = peerToken
+	conn.Token = nil
+	conn.ReportFlowPolicy = report
+	conn.PacketFlowPolicy = plc
+	conn.SetState(connection.UDPSynReceived)
+
+	d.udpNetOrigConnectionTracker.AddOrUpdate(udpPacket.L4FlowHash(), conn)
+	d.udpAppReplyConnectionTracker.AddOrUpdate(udpPacket.L4ReverseFlowHash(), conn)
+
+	if report.Action.Rejected() {
+		// Flow is allowed because we are observing the impact of a reject rule
+		d.reportRejectedFlow(udpPacket, conn, "udp", conn.Auth.RemoteContextID, context.ManagementID(), context, collector.PolicyDrop, report, plc)
+		return nil
+	}
+
+	d.reportAcceptedFlow(udpPacket, conn, conn.Auth.RemoteContextID, context.ManagementID(), context, report, plc)
+	return nil
+}
+
+// processNetworkUDPSynAckPacket processes a reply carrying the token of the receiver of a flow
+func (d *Datapath) processNetworkUDPSynAckPacket(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection) error {
+
+	state := conn.GetState()
+	if state != connection.UDPSynSend && state != connection.UDPSynAckReceived {
+		d.reportRejectedFlow(udpPacket, conn, "udp", collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidState, nil, nil)
+		return fmt.Errorf("udp synack packet dropped because of invalid state: %d", state)
+	}
+
+	token := udpPacket.ReadUDPToken()
+
+	// Replies carry the same token until the peer receives our plain datagrams
+	if state == connection.UDPSynAckReceived && bytes.Equal(token, conn.PeerToken) {
+		return udpPacket.UDPTokenDetach()
+	}
+
+	claims, err := d.tokenAccessor.ParsePacketToken(&conn.Auth, token, metrics.PacketSynAck)
+	if err != nil {
+		d.reportRejectedFlow(udpPacket, conn, "udp", collector.DefaultEndPoint, context.ManagementID(), context, tokenDropReason(err, collector.InvalidToken), nil, nil)
+		return fmt.Errorf("udp synack packet dropped because of bad claims: %s", err)
+	}
+
+	if claims == nil {
+		d.reportRejectedFlow(udpPacket, conn, "udp", collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidToken, nil, nil)
+		return errors.New("udp synack packet dropped because of no claims")
+	}
+
+	// The reply must be issued for the nonce of our syn token
+	if !bytes.Equal(claims.RMT, conn.Auth.LocalContext) {
+		d.reportRejectedFlow(udpPacket, conn, "udp", context.ManagementID(), conn.Auth.RemoteContextID, context, collector.InvalidNonse, nil, nil)
+		return errors.New("udp synack packet dropped because of nonce mismatch")
+	}
+
+	if d.mutualAuthorization {
+		report, plc := context.SearchTxtRules(claims.T, !d.mutualAuthorization)
+		if plc.Action.Rejected() {
+			d.reportRejectedFlow(udpPacket, conn, "udp", context.ManagementID(), conn.Auth.RemoteContextID, context, collector.PolicyDrop, report, plc)
+			return fmt.Errorf("dropping because of reject rule on transmitter: %s", claims.T.String())
+		}
+	}
+
+	// The token is removed in place. Keep a copy to detect retransmissions.
+	peerToken := append([]byte{}, token...)
+
+	if err = udpPacket.UDPTokenDetach(); err != nil {
+		d.reportRejectedFlow(udpPacket, conn, "udp", context.ManagementID(), conn.Auth.RemoteContextID, context, collector.InvalidFormat, nil, nil)
+		return fmt.Errorf("udp synack packet dropped because of invalid format: %s", err)
+	}
+
+	conn.PeerToken = peerToken
+	conn.Token = nil
+	conn.SetState(connection.UDPSynAckReceived)
+
+	d.udpNetReplyConnectionTracker.AddOrUpdate(udpPacket.L4FlowHash(), conn)
+
+	return nil
+}
+
+// processNetworkUDPDataPacket processes a network datagram without authorization header
+func (d *Datapath) processNetworkUDPDataPacket(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection) error {
+
+	switch conn.GetState() {
+	case connection.UDPStart:
+		// New flows without a token are initiated by external services.
+		// The network ACLs decide about the flow.
+		report, plc, err := context.NetworkACLPolicy(udpPacket)
+		d.reportExternalServiceFlow(context, report, plc, false, udpPacket)
+		if err != nil || plc.Action.Rejected() {
+			return fmt.Errorf("no auth or acls: udp flow dropped: %v", err)
+		}
+
+		conn.SetState(connection.UDPData)
+		d.udpNetOrigConnectionTracker.AddOrUpdate(udpPacket.L4FlowHash(), conn)
+		d.udpAppReplyConnectionTracker.AddOrUpdate(udpPacket.L4ReverseFlowHash(), conn)
+		return nil
+
+	case connection.UDPSynSend:
+		// The peer replied without a token. It is an external service and the
+		// application ACLs decide about the flow.
+		report, plc, err := context.ApplicationACLPolicy(udpPacket)
+		d.reportReverseExternalServiceFlow(context, report, plc, true, udpPacket)
+		if err != nil || plc.Action.Rejected() {
+			return fmt.Errorf("no auth or acls: udp reply dropped: %v", err)
+		}
+
+		conn.SetState(connection.UDPData)
+		d.releaseUDPFlow(conn, udpPacket, true)
+		return nil
+
+	case connection.UDPSynAckSend:
+		// The initiator has validated our token and stopped sending its own.
+		conn.SetState(connection.UDPData)
+		d.releaseUDPFlow(conn, udpPacket, false)
+		return nil
+
+	case connection.UDPSynAckReceived:
+		// The receiver has released the flow on its side.
+		conn.SetState(connection.UDPData)
+		d.releaseUDPFlow(conn, udpPacket, true)
+		return nil
+
+	case connection.UDPData:
+		if _, err := d.udpNetReplyConnectionTracker.Get(udpPacket.L4FlowHash()); err == nil {
+			d.releaseUDPFlow(conn, udpPacket, true)
+		}
+		return nil
+	}
+
+	// The initiator cannot stop sending its token before it has received ours
+	d.reportRejectedFlow(udpPacket, conn, "udp", conn.Auth.RemoteContextID, context.ManagementID(), context, collector.InvalidState, nil, nil)
+	return fmt.Errorf("udp packet dropped because of invalid state: %d", conn.GetState())
+}
+
+// appUDPRetrieveState retrieves the state of the flow of an application datagram.
+// If no state is found, it creates a new connection record.
+func (d *Datapath) appUDPRetrieveState(p *packet.Packet) (*connection.UDPConnection, error) {
+
+	hash := p.L4FlowHash()
+
+	if conn, err := d.udpAppReplyConnectionTracker.GetReset(hash, 0); err == nil {
+		return conn.(*connection.UDPConnection), nil
+	}
+
+	if conn, err := d.udpAppOrigConnectionTracker.GetReset(hash, 0); err == nil {
+		return conn.(*connection.UDPConnection), nil
+	}
+
+	context, err := d.contextFromIP(true, p.SourceAddress.String(), p.Mark, p.SourcePort)
+	if err != nil {
+		return nil, errors.New("no context in app processing")
+	}
+
+	return connection.NewUDPConnection(context), nil
+}
+
+// netUDPRetrieveState retrieves the state of the flow of a network datagram.
+// If no state is found, it creates a new connection record.
+func (d *Datapath) netUDPRetrieveState(p *packet.Packet) (*connection.UDPConnection, error) {
+
+	hash := p.L4FlowHash()
+
+	if conn, err := d.udpNetOrigConnectionTracker.GetReset(hash, 0); err == nil {
+		return conn.(*connection.UDPConnection), nil
+	}
+
+	// Syn packets always belong to flows initiated by the peer
+	if p.UDPAuthPacketType() != packet.UDPSynPacket {
+		if conn, err := d.udpNetReplyConnectionTracker.GetReset(hash, 0); err == nil {
+			return conn.(*connection.UDPConnection), nil
+		}
+	}
+
+	// The source of a synack might have been translated on the way.
+	// Match it with the flow that we initiated from the same port.
+	if p.UDPAuthPacketType() == packet.UDPSynAckPacket {
+		if conn, err := d.udpSourcePortConnectionCache.GetReset(p.SourcePortHash(packet.PacketTypeNetwork), 0); err == nil {
+			return conn.(*connection.UDPConnection), nil
+		}
+	}
+
+	context, err := d.contextFromIP(false, p.DestinationAddress.String(), p.Mark, p.DestinationPort)
+	if err != nil {
+		return nil, errors.New("no context in net processing")
+	}
+
+	return connection.NewUDPConnection(context), nil
+}
+
+// releaseUDPFlow releases the flow to the kernel by marking its conntrack entry.
+// Conntrack entries are indexed by the original direction of the flow, which is
+// the reverse direction of a reply.
+func (d *Datapath) releaseUDPFlow(conn *connection.UDPConnection, udpPacket *packet.Packet, reply bool) {
+
+	srcIP, dstIP := udpPacket.SourceAddress.String(), udpPacket.DestinationAddress.String()
+	srcPort, dstPort := udpPacket.SourcePort, udpPacket.DestinationPort
+	if reply {
+		srcIP, dstIP = dstIP, srcIP
+		srcPort, dstPort = dstPort, srcPort
+	}
+
+	if err := d.conntrackHdl.ConntrackTableUpdateMark(
+		srcIP,
+		dstIP,
+		udpPacket.IPProto,
+		srcPort,
+		dstPort,
+		constants.DefaultConnMark,
+	); err != nil {
+		zap.L().Error("Failed to update conntrack table for udp flow",
+			zap.String("context", conn.Context.ManagementID()),
+			zap.String("flow", udpPacket.L4FlowHash()),
+			zap.String("state", fmt.Sprintf("%d", conn.GetState())),
+			zap.Error(err),
+		)
+	}
+
+	d.offloadFlow(conn.Context.ID(), udpPacket)
+}
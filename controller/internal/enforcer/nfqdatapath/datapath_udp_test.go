@@ -0,0 +1,204 @@
+package nfqdatapath
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/connection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/metrics"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newUDPTestPacket creates a datagram with valid checksums
+func newUDPTestPacket(src, dst string, sport, dport uint16, payload []byte) *packet.Packet {
+
+	buffer := make([]byte, 28+len(payload))
+	buffer[0] = 0x45
+	binary.BigEndian.PutUint16(buffer[2:4], uint16(len(buffer)))
+	buffer[8] = 64
+	buffer[9] = packet.IPProtocolUDP
+	copy(buffer[12:16], net.ParseIP(src).To4())
+	copy(buffer[16:20], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint16(buffer[20:22], sport)
+	binary.BigEndian.PutUint16(buffer[22:24], dport)
+	binary.BigEndian.PutUint16(buffer[24:26], uint16(8+len(payload)))
+	copy(buffer[28:], payload)
+
+	p, err := packet.New(0, buffer, "0")
+	So(err, ShouldBeNil)
+	p.UpdateIPChecksum()
+	p.UpdateUDPChecksum()
+
+	return p
+}
+
+// wireUDPPacket returns a copy of the packet as received by the other side
+func wireUDPPacket(p *packet.Packet) *packet.Packet {
+
+	output := make([]byte, len(p.GetBytes()))
+	copy(output, p.GetBytes())
+
+	outPacket, err := packet.New(0, output, "0")
+	So(err, ShouldBeNil)
+	So(outPacket.VerifyIPChecksum(), ShouldBeTrue)
+	So(outPacket.VerifyUDPChecksum(), ShouldBeTrue)
+
+	return outPacket
+}
+
+// tokenVerifications returns the number of tokens of the packet type that
+// have been verified
+func tokenVerifications(packetType string) uint64 {
+
+	for _, sample := range metrics.TokenLatency.Collect().Samples {
+		if sample.LabelValues[0] == metrics.OperationVerify && sample.LabelValues[1] == packetType {
+			return sample.Count
+		}
+	}
+
+	return 0
+}
+
+func TestUDPFlowAuthorization(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance and have a valid processing unit context", t, func() {
+
+		puInfo1, puInfo2, enforcer, err1, err2, _, _ := setupProcessingUnitsInDatapathAndEnforce(nil, false, "container")
+		So(puInfo1, ShouldNotBeNil)
+		So(puInfo2, ShouldNotBeNil)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		client, server := "10.1.10.76", "164.67.228.152"
+		request, reply := []byte("request"), []byte("reply")
+
+		Convey("When the client sends a datagram", func() {
+
+			appSyn := newUDPTestPacket(client, server, 5000, 53, request)
+			err := enforcer.processApplicationUDPPackets(appSyn)
+			So(err, ShouldBeNil)
+
+			Convey("Then the datagram should carry a syn token", func() {
+				So(appSyn.CheckUDPAuthenticationMarker(), ShouldBeNil)
+				So(appSyn.UDPAuthPacketType(), ShouldEqual, packet.UDPSynPacket)
+				So(len(appSyn.GetBytes()), ShouldBeGreaterThan, 28+len(request)+packet.UDPAuthHeaderLen)
+			})
+
+			Convey("When the server receives the datagram", func() {
+
+				netSyn := wireUDPPacket(appSyn)
+				err := enforcer.processNetworkUDPPackets(netSyn)
+				So(err, ShouldBeNil)
+
+				Convey("Then the token should be removed and the flow should wait for the reply", func() {
+					So(netSyn.ReadUDPData(), ShouldResemble, request)
+					So(netSyn.VerifyUDPChecksum(), ShouldBeTrue)
+
+					conn, err := enforcer.udpNetOrigConnectionTracker.Get(netSyn.L4FlowHash())
+					So(err, ShouldBeNil)
+					So(conn.(*connection.UDPConnection).GetState(), ShouldEqual, connection.UDPSynReceived)
+				})
+
+				Convey("When the client retransmits the datagram", func() {
+
+					appRetransmit := newUDPTestPacket(client, server, 5000, 53, request)
+					err := enforcer.processApplicationUDPPackets(appRetransmit)
+					So(err, ShouldBeNil)
+					So(appRetransmit.ReadUDPToken(), ShouldResemble, appSyn.ReadUDPToken())
+
+					err = enforcer.processNetworkUDPPackets(wireUDPPacket(appRetransmit))
+
+					Convey("Then the retransmission should be accepted", func() {
+						So(err, ShouldBeNil)
+					})
+				})
+
+				Convey("When the server replies and the client receives the reply", func() {
+
+					appSynAck := newUDPTestPacket(server, client, 53, 5000, reply)
+					err := enforcer.processApplicationUDPPackets(appSynAck)
+					So(err, ShouldBeNil)
+					So(appSynAck.UDPAuthPacketType(), ShouldEqual, packet.UDPSynAckPacket)
+
+					syns, synAcks := tokenVerifications(metrics.PacketSyn), tokenVerifications(metrics.PacketSynAck)
+
+					netSynAck := wireUDPPacket(appSynAck)
+					err = enforcer.processNetworkUDPPackets(netSynAck)
+					So(err, ShouldBeNil)
+
+					Convey("Then the token should be verified as a synack token", func() {
+						So(tokenVerifications(metrics.PacketSyn), ShouldEqual, syns)
+						So(tokenVerifications(metrics.PacketSynAck), ShouldEqual, synAcks+1)
+					})
+
+					Convey("Then the token should be removed and the client should be authorized", func() {
+						So(netSynAck.ReadUDPData(), ShouldResemble, reply)
+
+						conn, err := enforcer.udpNetReplyConnectionTracker.Get(netSynAck.L4FlowHash())
+						So(err, ShouldBeNil)
+						So(conn.(*connection.UDPConnection).GetState(), ShouldEqual, connection.UDPSynAckReceived)
+					})
+
+					Convey("When the client sends the next datagram", func() {
+
+						appData := newUDPTestPacket(client, server, 5000, 53, request)
+						err := enforcer.processApplicationUDPPackets(appData)
+						So(err, ShouldBeNil)
+
+						netData := wireUDPPacket(appData)
+						err = enforcer.processNetworkUDPPackets(netData)
+						So(err, ShouldBeNil)
+
+						Convey("Then the datagram should be sent without a token and the server should release the flow", func() {
+							So(netData.CheckUDPAuthenticationMarker(), ShouldNotBeNil)
+							So(netData.ReadUDPData(), ShouldResemble, request)
+
+							conn, err := enforcer.udpNetOrigConnectionTracker.Get(netData.L4FlowHash())
+							So(err, ShouldBeNil)
+							So(conn.(*connection.UDPConnection).GetState(), ShouldEqual, connection.UDPData)
+						})
+					})
+				})
+			})
+
+			Convey("When the token is modified on the way", func() {
+
+				netSyn := wireUDPPacket(appSyn)
+				token := netSyn.ReadUDPToken()
+				token[len(token)/2] ^= 0xff
+				netSyn.UpdateUDPChecksum()
+
+				err := enforcer.processNetworkUDPPackets(netSyn)
+
+				Convey("Then the datagram should be dropped", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+
+		Convey("When a datagram without a token arrives on a new flow that is not allowed by the acls", func() {
+
+			netData := newUDPTestPacket(server, client, 53, 6000, request)
+			err := enforcer.processNetworkUDPPackets(netData)
+
+			Convey("Then the datagram should be dropped", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When a server reply arrives for a flow that it has not authorized", func() {
+
+			netSynAck := newUDPTestPacket(server, client, 53, 7000, reply)
+			So(netSynAck.UDPTokenAttach(packet.UDPSynAckPacket, []byte("token")), ShouldBeNil)
+			netSynAck.UpdateUDPChecksum()
+
+			err := enforcer.processNetworkUDPPackets(wireUDPPacket(netSynAck))
+
+			Convey("Then the datagram should be dropped", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,146 @@
+package nfqdatapath
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/ebpf"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
+	"github.com/aporeto-inc/trireme-lib/utils/cache"
+	"go.uber.org/zap"
+)
+
+// offloadedFlowLifetime is the time after which an offloaded flow is removed
+// from the fast path. The flows that are still active keep being accepted
+// through conntrack.
+const offloadedFlowLifetime = time.Hour
+
+// offloadedFlow is a flow of the fast path
+type offloadedFlow struct {
+	contextID string
+	packet    *packet.Packet
+}
+
+// startFastPath loads and attaches the eBPF fast path if it is enabled in the
+// filter queue configuration. Any failure is logged and the datapath keeps
+// using the iptables and NFQUEUE path only.
+func (d *Datapath) startFastPath(ctx context.Context) {
+
+	if d.filterQueue == nil || !d.filterQueue.IsFastPathEnabled() || d.bpf != nil {
+		return
+	}
+
+	bpf, err := ebpf.LoadBPF(uint32(d.filterQueue.GetMarkValue()))
+	if err != nil {
+		zap.L().Warn("Unable to load the ebpf fast path. Falling back to iptables", zap.Error(err))
+		return
+	}
+
+	if err := bpf.Attach(d.filterQueue.FastPathInterfaces); err != nil {
+		zap.L().Warn("Unable to attach the ebpf fast path. Falling back to iptables", zap.Error(err))
+		bpf.Cleanup()
+		return
+	}
+
+	d.bpf = bpf
+
+	go func() {
+		<-ctx.Done()
+		bpf.Cleanup()
+	}()
+}
+
+// offloadFlow adds a released flow of the PU to the fast path. Flows that
+// cannot be offloaded keep going through conntrack.
+func (d *Datapath) offloadFlow(contextID string, p *packet.Packet) {
+
+	if d.bpf == nil {
+		return
+	}
+
+	if err := d.bpf.CreateFlow(p); err != nil {
+		zap.L().Debug("Unable to offload flow to the fast path",
+			zap.String("flow", p.L4FlowHash()),
+			zap.Error(err),
+		)
+		return
+	}
+
+	// The packet buffer is reused by the queue. Only the tuple is kept.
+	flow := &offloadedFlow{
+		contextID: contextID,
+		packet: &packet.Packet{
+			IPProto:            p.IPProto,
+			SourceAddress:      append(net.IP{}, p.SourceAddress...),
+			DestinationAddress: append(net.IP{}, p.DestinationAddress...),
+			SourcePort:         p.SourcePort,
+			DestinationPort:    p.DestinationPort,
+		},
+	}
+
+	d.offloadedFlows.AddOrUpdate(offloadedFlowKey(p.IPProto, p.L4FlowHash()), flow)
+}
+
+// removeOffloadedFlow removes the flow of the packet from the fast path in
+// both directions. It is called when a connection starts on the tuple, so
+// that the new connection is authorized by the datapath.
+func (d *Datapath) removeOffloadedFlow(p *packet.Packet) {
+
+	if d.bpf == nil {
+		return
+	}
+
+	for _, hash := range []string{p.L4FlowHash(), p.L4ReverseFlowHash()} {
+		key := offloadedFlowKey(p.IPProto, hash)
+		item, err := d.offloadedFlows.Get(key)
+		if err != nil {
+			continue
+		}
+		if err := d.offloadedFlows.Remove(key); err != nil {
+			continue
+		}
+		d.removeFlow(item.(*offloadedFlow))
+	}
+}
+
+// flushOffloadedFlows removes all the flows of the PU from the fast path
+func (d *Datapath) flushOffloadedFlows(contextID string) {
+
+	if d.bpf == nil {
+		return
+	}
+
+	for _, key := range d.offloadedFlows.KeyList() {
+		item, err := d.offloadedFlows.Get(key)
+		if err != nil || item.(*offloadedFlow).contextID != contextID {
+			continue
+		}
+		if err := d.offloadedFlows.Remove(key); err != nil {
+			continue
+		}
+		d.removeFlow(item.(*offloadedFlow))
+	}
+}
+
+// offloadedFlowKey returns the key of a flow in the cache of the fast path
+func offloadedFlowKey(proto uint8, hash string) string {
+	return strconv.Itoa(int(proto)) + ":" + hash
+}
+
+// expireOffloadedFlow removes an expired flow from the fast path
+func (d *Datapath) expireOffloadedFlow(c cache.DataStore, id interface{}, item interface{}) {
+	d.removeFlow(item.(*offloadedFlow))
+}
+
+// removeFlow removes a flow from the fast path
+func (d *Datapath) removeFlow(flow *offloadedFlow) {
+
+	if err := d.bpf.RemoveFlow(flow.packet); err != nil {
+		zap.L().Debug("Unable to remove flow from the fast path",
+			zap.String("flow", flow.packet.L4FlowHash()),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,173 @@
+package nfqdatapath
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/utils/packetgen"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/connection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testBPFModule struct {
+	flows   []string
+	removed []string
+	err     error
+}
+
+func (b *testBPFModule) Attach(interfaces []string) error { return nil }
+
+func (b *testBPFModule) CreateFlow(p *packet.Packet) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.flows = append(b.flows, p.L4FlowHash())
+	return nil
+}
+
+func (b *testBPFModule) RemoveFlow(p *packet.Packet) error {
+	b.removed = append(b.removed, p.L4FlowHash())
+	return nil
+}
+
+func (b *testBPFModule) Cleanup() {}
+
+func TestFastPath(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance", t, func() {
+
+		_, _, enforcer, err1, err2, _, _ := setupProcessingUnitsInDatapathAndEnforce(nil, false, "container")
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		p := newUDPTestPacket("10.1.10.76", "164.67.228.152", 5000, 53, []byte("request"))
+
+		Convey("When the fast path is disabled", func() {
+			enforcer.startFastPath(context.Background())
+
+			Convey("Then no module should be loaded and flows should not be offloaded", func() {
+				So(enforcer.bpf, ShouldBeNil)
+				So(func() { enforcer.offloadFlow("pu", p) }, ShouldNotPanic)
+			})
+		})
+
+		Convey("When the fast path is enabled", func() {
+			bpf := &testBPFModule{}
+			enforcer.bpf = bpf
+
+			Convey("Then a released flow should be offloaded", func() {
+				enforcer.offloadFlow("pu", p)
+				So(bpf.flows, ShouldResemble, []string{p.L4FlowHash()})
+			})
+
+			Convey("Then a flow that cannot be offloaded should be ignored", func() {
+				bpf.err = fmt.Errorf("map is full")
+				So(func() { enforcer.offloadFlow("pu", p) }, ShouldNotPanic)
+				So(bpf.flows, ShouldBeEmpty)
+			})
+
+			Convey("Then a new flow on the tuple should remove the offloaded flow in both directions", func() {
+				enforcer.offloadFlow("pu", p)
+				reply := newUDPTestPacket("164.67.228.152", "10.1.10.76", 53, 5000, []byte("reply"))
+				enforcer.removeOffloadedFlow(reply)
+				So(bpf.removed, ShouldResemble, []string{p.L4FlowHash()})
+				So(enforcer.offloadedFlows.SizeOf(), ShouldEqual, 0)
+
+				enforcer.removeOffloadedFlow(p)
+				So(len(bpf.removed), ShouldEqual, 1)
+			})
+
+			Convey("Then the flows of a PU should be flushed", func() {
+				other := newUDPTestPacket("10.1.10.76", "164.67.228.152", 5001, 53, []byte("request"))
+				enforcer.offloadFlow("pu", p)
+				enforcer.offloadFlow("other", other)
+				enforcer.flushOffloadedFlows("pu")
+				So(bpf.removed, ShouldResemble, []string{p.L4FlowHash()})
+				So(enforcer.offloadedFlows.SizeOf(), ShouldEqual, 1)
+			})
+
+			Convey("Then an expired flow should be removed", func() {
+				enforcer.offloadFlow("pu", p)
+				item, err := enforcer.offloadedFlows.Get(offloadedFlowKey(p.IPProto, p.L4FlowHash()))
+				So(err, ShouldBeNil)
+				enforcer.expireOffloadedFlow(enforcer.offloadedFlows, nil, item)
+				So(bpf.removed, ShouldResemble, []string{p.L4FlowHash()})
+			})
+		})
+	})
+}
+
+func TestFastPathPolicyChanges(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance with the fast path", t, func() {
+
+		puInfo1, _, enforcer, err1, err2, _, _ := setupProcessingUnitsInDatapathAndEnforce(nil, false, "container")
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		bpf := &testBPFModule{}
+		enforcer.bpf = bpf
+
+		p := newUDPTestPacket("10.1.10.76", "164.67.228.152", 5000, 53, []byte("request"))
+		enforcer.offloadFlow(puInfo1.ContextID, p)
+
+		Convey("When the policy of the PU is updated", func() {
+			err := enforcer.Enforce(puInfo1.ContextID, puInfo1)
+			So(err, ShouldBeNil)
+
+			Convey("Then its flows should be removed from the fast path", func() {
+				So(bpf.removed, ShouldResemble, []string{p.L4FlowHash()})
+			})
+		})
+
+		Convey("When the PU is unsupervised", func() {
+			err := enforcer.Unenforce(puInfo1.ContextID)
+			So(err, ShouldBeNil)
+
+			Convey("Then its flows should be removed from the fast path", func() {
+				So(bpf.removed, ShouldResemble, []string{p.L4FlowHash()})
+			})
+		})
+	})
+}
+
+func TestFastPathSynOnOffloadedFlow(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance with the fast path", t, func() {
+
+		_, _, enforcer, err1, err2, _, _ := setupProcessingUnitsInDatapathAndEnforce(nil, false, "container")
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		bpf := &testBPFModule{}
+		enforcer.bpf = bpf
+
+		PacketFlow := packetgen.NewTemplateFlow()
+		_, err := PacketFlow.GenerateTCPFlow(packetgen.PacketFlowTypeGoodFlowTemplate)
+		So(err, ShouldBeNil)
+
+		buffer, err := PacketFlow.GetFirstSynPacket().ToBytes()
+		So(err, ShouldBeNil)
+		syn, err := packet.New(0, buffer, "0")
+		So(err, ShouldBeNil)
+		syn.UpdateIPChecksum()
+		syn.UpdateTCPChecksum()
+
+		Convey("When a SYN is sent on the tuple of an offloaded flow", func() {
+			enforcer.offloadFlow("pu", syn)
+			err := enforcer.processApplicationTCPPackets(syn)
+			So(err, ShouldBeNil)
+
+			Convey("Then the flow should be removed from the fast path and the SYN authorized by the datapath", func() {
+				So(bpf.removed, ShouldResemble, []string{syn.L4FlowHash()})
+				So(enforcer.offloadedFlows.SizeOf(), ShouldEqual, 0)
+
+				conn, err := enforcer.appOrigConnectionTracker.Get(syn.L4FlowHash())
+				So(err, ShouldBeNil)
+				So(conn.(*connection.TCPConnection).GetState(), ShouldEqual, connection.TCPSynSend)
+			})
+		})
+	})
+}
@@ -0,0 +1,171 @@
+package nfqdatapath
+
+import (
+	"fmt"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/connection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/pucontext"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/cache"
+)
+
+// tracker describes a connection tracker for introspection
+type tracker struct {
+	cache     cache.DataStore
+	protocol  string
+	direction string
+	reply     bool
+}
+
+// trackers returns the connection trackers. The original directions come
+// first so that a connection is reported with its original flow when it is
+// still there.
+func (d *Datapath) trackers() []tracker {
+
+	return []tracker{
+		{d.appOrigConnectionTracker, introspection.ProtocolTCP, introspection.DirectionApplication, false},
+		{d.netOrigConnectionTracker, introspection.ProtocolTCP, introspection.DirectionNetwork, false},
+		{d.netReplyConnectionTracker, introspection.ProtocolTCP, introspection.DirectionApplication, true},
+		{d.appReplyConnectionTracker, introspection.ProtocolTCP, introspection.DirectionNetwork, true},
+		{d.udpAppOrigConnectionTracker, introspection.ProtocolUDP, introspection.DirectionApplication, false},
+		{d.udpNetOrigConnectionTracker, introspection.ProtocolUDP, introspection.DirectionNetwork, false},
+		{d.udpNetReplyConnectionTracker, introspection.ProtocolUDP, introspection.DirectionApplication, true},
+		{d.udpAppReplyConnectionTracker, introspection.ProtocolUDP, introspection.DirectionNetwork, true},
+	}
+}
+
+// ListPUs returns a snapshot of all the PUs enforced by the datapath
+func (d *Datapath) ListPUs() ([]*introspection.PUState, error) {
+
+	list := []*introspection.PUState{}
+
+	for _, key := range d.puFromContextID.KeyList() {
+		contextID, ok := key.(string)
+		if !ok {
+			continue
+		}
+
+		pu, err := d.GetPU(contextID)
+		if err != nil {
+			// The PU was removed while we were listing
+			continue
+		}
+
+		list = append(list, pu)
+	}
+
+	return list, nil
+}
+
+// ListPeerKeys returns the keys of the peers seen with the secrets of the
+// datapath. They are shared by all the PUs of the datapath.
+func (d *Datapath) ListPeerKeys(contextID string) ([]secrets.PeerKey, error) {
+
+	if _, err := d.puFromContextID.Get(contextID); err != nil {
+		return nil, fmt.Errorf("unable to find context %s: %s", contextID, err)
+	}
+
+	inventory, ok := d.secrets.(secrets.PeerKeyInventory)
+	if !ok {
+		return []secrets.PeerKey{}, nil
+	}
+
+	return inventory.PeerKeys(), nil
+}
+
+// GetPU returns a snapshot of the PU with the given context id
+func (d *Datapath) GetPU(contextID string) (*introspection.PUState, error) {
+
+	item, err := d.puFromContextID.Get(contextID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find context %s: %s", contextID, err)
+	}
+
+	return item.(*pucontext.PUContext).Snapshot(), nil
+}
+
+// ListConnections returns a snapshot of the connections of the PU with the
+// given context id that are tracked by the datapath
+func (d *Datapath) ListConnections(contextID string) ([]*introspection.ConnectionState, error) {
+
+	if _, err := d.puFromContextID.Get(contextID); err != nil {
+		return nil, fmt.Errorf("unable to find context %s: %s", contextID, err)
+	}
+
+	list := []*introspection.ConnectionState{}
+	seen := map[interface{}]bool{}
+
+	for _, t := range d.trackers() {
+		for _, key := range t.cache.KeyList() {
+			item, err := t.cache.Get(key)
+			if err != nil || item == nil || seen[item] {
+				continue
+			}
+
+			state := connectionState(contextID, item)
+			if state == nil {
+				continue
+			}
+			seen[item] = true
+
+			state.Flow = fmt.Sprintf("%v", key)
+			state.Protocol = t.protocol
+			state.Direction = t.direction
+			state.Reply = t.reply
+
+			list = append(list, state)
+		}
+	}
+
+	return list, nil
+}
+
+// connectionState returns the state of the connection if it belongs to the context
+func connectionState(contextID string, item interface{}) *introspection.ConnectionState {
+
+	var state *introspection.ConnectionState
+
+	switch conn := item.(type) {
+	case *connection.TCPConnection:
+		conn.RLock()
+		defer conn.RUnlock()
+
+		if conn.Context == nil || conn.Context.ID() != contextID {
+			return nil
+		}
+
+		state = newConnectionState(conn.GetState().String(), &conn.Auth, conn.PacketFlowPolicy)
+		state.ServiceConnection = conn.ServiceConnection
+
+	case *connection.UDPConnection:
+		conn.RLock()
+		defer conn.RUnlock()
+
+		if conn.Context == nil || conn.Context.ID() != contextID {
+			return nil
+		}
+
+		state = newConnectionState(conn.GetState().String(), &conn.Auth, conn.PacketFlowPolicy)
+	}
+
+	return state
+}
+
+func newConnectionState(state string, auth *connection.AuthInfo, flowPolicy *policy.FlowPolicy) *introspection.ConnectionState {
+
+	s := &introspection.ConnectionState{
+		State:           state,
+		RemoteContextID: auth.RemoteContextID,
+		RemoteIP:        auth.RemoteIP,
+		RemotePort:      auth.RemotePort,
+	}
+
+	if flowPolicy != nil {
+		s.PolicyID = flowPolicy.PolicyID
+		s.Action = flowPolicy.Action.ActionString()
+	}
+
+	return s
+}
@@ -0,0 +1,123 @@
+package nfqdatapath
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/constants"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/connection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/pucontext"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIntrospection(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance and enforce two processing units", t, func() {
+
+		puInfo1, puInfo2, enforcer, err1, err2, _, _ := setupProcessingUnitsInDatapathAndEnforce(nil, false, "container")
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		Convey("When I list the processing units", func() {
+			pus, err := enforcer.ListPUs()
+
+			Convey("Then I should get both of them", func() {
+				So(err, ShouldBeNil)
+				So(len(pus), ShouldEqual, 2)
+
+				ids := []string{pus[0].ContextID, pus[1].ContextID}
+				So(ids, ShouldContain, puInfo1.ContextID)
+				So(ids, ShouldContain, puInfo2.ContextID)
+			})
+		})
+
+		Convey("When I get a processing unit", func() {
+			pu, err := enforcer.GetPU(puInfo1.ContextID)
+
+			Convey("Then I should get its identity and its rules", func() {
+				So(err, ShouldBeNil)
+				So(pu.ContextID, ShouldEqual, puInfo1.ContextID)
+				So(pu.Identity, ShouldContain, enforcerconstants.TransmitterLabel+"=value")
+				So(len(pu.ReceiverRules), ShouldEqual, 1)
+				So(pu.ReceiverRules[0].Policy.Action, ShouldEqual, policy.Accept)
+			})
+		})
+
+		Convey("When I get a processing unit that is not enforced", func() {
+			_, err := enforcer.GetPU("unknown")
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When a connection of the processing unit is tracked in both directions", func() {
+			item, err := enforcer.puFromContextID.Get(puInfo1.ContextID)
+			So(err, ShouldBeNil)
+
+			conn := connection.NewTCPConnection(item.(*pucontext.PUContext))
+			conn.SetState(connection.TCPSynAckReceived)
+			conn.Auth.RemoteContextID = "remote"
+			conn.PacketFlowPolicy = &policy.FlowPolicy{Action: policy.Accept, PolicyID: "policy"}
+			enforcer.appOrigConnectionTracker.AddOrUpdate("10.1.1.1:10.1.1.2:2000:80", conn)
+			enforcer.netReplyConnectionTracker.AddOrUpdate("10.1.1.2:10.1.1.1:80:2000", conn)
+
+			Convey("Then it should be listed once with its original flow", func() {
+				connections, err := enforcer.ListConnections(puInfo1.ContextID)
+				So(err, ShouldBeNil)
+				So(len(connections), ShouldEqual, 1)
+				So(connections[0], ShouldResemble, &introspection.ConnectionState{
+					Flow:            "10.1.1.1:10.1.1.2:2000:80",
+					Protocol:        introspection.ProtocolTCP,
+					Direction:       introspection.DirectionApplication,
+					State:           "SynAckReceived",
+					RemoteContextID: "remote",
+					PolicyID:        "policy",
+					Action:          "accept",
+				})
+			})
+
+			Convey("Then it should not be listed for the other processing unit", func() {
+				connections, err := enforcer.ListConnections(puInfo2.ContextID)
+				So(err, ShouldBeNil)
+				So(connections, ShouldBeEmpty)
+			})
+
+			Convey("Then it should be listed with its reply flow once the original flow is released", func() {
+				So(enforcer.appOrigConnectionTracker.Remove("10.1.1.1:10.1.1.2:2000:80"), ShouldBeNil)
+
+				connections, err := enforcer.ListConnections(puInfo1.ContextID)
+				So(err, ShouldBeNil)
+				So(len(connections), ShouldEqual, 1)
+				So(connections[0].Flow, ShouldEqual, "10.1.1.2:10.1.1.1:80:2000")
+				So(connections[0].Reply, ShouldBeTrue)
+			})
+		})
+
+		Convey("When I list the connections of a processing unit that is not enforced", func() {
+			_, err := enforcer.ListConnections("unknown")
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I list the keys of the peers of a processing unit", func() {
+			keys, err := enforcer.ListPeerKeys(puInfo1.ContextID)
+
+			Convey("Then I should get the keys seen by the secrets of the enforcer", func() {
+				So(err, ShouldBeNil)
+				So(keys, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I list the keys of the peers of a processing unit that is not enforced", func() {
+			_, err := enforcer.ListPeerKeys("unknown")
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	nfqueue "github.com/aporeto-inc/netlink-go/nfqueue"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/metrics"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
 	"go.uber.org/zap"
 )
@@ -80,10 +81,13 @@ func (d *Datapath) processNetworkPacketsFromNFQ(p *nfqueue.NFPacket) {
 		netPacket.Print(packet.PacketFailureCreate)
 	} else if netPacket.IPProto == packet.IPProtocolTCP {
 		err = d.processNetworkTCPPackets(netPacket)
+	} else if netPacket.IPProto == packet.IPProtocolUDP {
+		err = d.processNetworkUDPPackets(netPacket)
 	} else {
 		err = fmt.Errorf("invalid ip protocol: %d", netPacket.IPProto)
 	}
 	if err != nil {
+		metrics.QueuePackets.Inc(metrics.DirectionNetwork, strconv.Itoa(int(p.QueueHandle.QueueNum)), metrics.VerdictDrop)
 		length := uint32(len(p.Buffer))
 		buffer := p.Buffer
 		p.QueueHandle.SetVerdict2(uint32(p.QueueHandle.QueueNum), 0, uint32(p.Mark), length, uint32(p.ID), buffer)
@@ -91,6 +95,7 @@ func (d *Datapath) processNetworkPacketsFromNFQ(p *nfqueue.NFPacket) {
 	}
 
 	// // Accept the packet
+	metrics.QueuePackets.Inc(metrics.DirectionNetwork, strconv.Itoa(int(p.QueueHandle.QueueNum)), metrics.VerdictAccept)
 	buffer := make([]byte, len(netPacket.Buffer)+netPacket.TCPOptionLength()+netPacket.TCPDataLength())
 	copyIndex := copy(buffer, netPacket.Buffer)
 	copyIndex += copy(buffer[copyIndex:], netPacket.GetTCPOptions())
@@ -114,11 +119,14 @@ func (d *Datapath) processApplicationPacketsFromNFQ(p *nfqueue.NFPacket) {
 		appPacket.Print(packet.PacketFailureCreate)
 	} else if appPacket.IPProto == packet.IPProtocolTCP {
 		err = d.processApplicationTCPPackets(appPacket)
+	} else if appPacket.IPProto == packet.IPProtocolUDP {
+		err = d.processApplicationUDPPackets(appPacket)
 	} else {
 		err = fmt.Errorf("invalid ip protocol: %d", appPacket.IPProto)
 	}
 
 	if err != nil {
+		metrics.QueuePackets.Inc(metrics.DirectionApplication, strconv.Itoa(int(p.QueueHandle.QueueNum)), metrics.VerdictDrop)
 		length := uint32(len(p.Buffer))
 		buffer := p.Buffer
 		p.QueueHandle.SetVerdict2(uint32(p.QueueHandle.QueueNum), 0, uint32(p.Mark), length, uint32(p.ID), buffer)
@@ -126,6 +134,7 @@ func (d *Datapath) processApplicationPacketsFromNFQ(p *nfqueue.NFPacket) {
 	}
 
 	// Accept the packet
+	metrics.QueuePackets.Inc(metrics.DirectionApplication, strconv.Itoa(int(p.QueueHandle.QueueNum)), metrics.VerdictAccept)
 	buffer := make([]byte, len(appPacket.Buffer)+appPacket.TCPOptionLength()+appPacket.TCPDataLength())
 	copyIndex := copy(buffer, appPacket.Buffer)
 	copyIndex += copy(buffer[copyIndex:], appPacket.GetTCPOptions())
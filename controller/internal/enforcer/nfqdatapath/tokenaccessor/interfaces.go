@@ -14,10 +14,11 @@ type TokenAccessor interface {
 	SetToken(serverID string, validity time.Duration, secret secrets.Secrets) error
 	GetTokenValidity() time.Duration
 	GetTokenServerID() string
+	AckSize() uint32
 
 	CreateAckPacketToken(context *pucontext.PUContext, auth *connection.AuthInfo) ([]byte, error)
 	CreateSynPacketToken(context *pucontext.PUContext, auth *connection.AuthInfo) (token []byte, err error)
 	CreateSynAckPacketToken(context *pucontext.PUContext, auth *connection.AuthInfo) (token []byte, err error)
-	ParsePacketToken(auth *connection.AuthInfo, data []byte) (*tokens.ConnectionClaims, error)
+	ParsePacketToken(auth *connection.AuthInfo, data []byte, packetType string) (*tokens.ConnectionClaims, error)
 	ParseAckToken(auth *connection.AuthInfo, data []byte) (*tokens.ConnectionClaims, error)
 }
@@ -3,37 +3,74 @@ package tokenaccessor
 import (
 	"bytes"
 	"errors"
+	"net"
 	"sync"
 	"time"
 
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/constants"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/connection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/metrics"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/pucontext"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/tokens"
+	"github.com/aporeto-inc/trireme-lib/utils/cache"
+	"go.uber.org/zap"
 )
 
+// receiverKeyLifetime is the time the key of a receiver learned from a
+// SYN/ACK is used to encrypt the tags of the SYN tokens. It bounds the time
+// the SYN packets are encrypted for a key that the receiver replaced.
+const receiverKeyLifetime = 5 * time.Minute
+
 // tokenAccessor is a wrapper around tokenEngine to provide locks for accessing
 type tokenAccessor struct {
 	sync.RWMutex
-	tokens   tokens.TokenEngine
-	serverID string
-	validity time.Duration
+	tokens     tokens.TokenEngine
+	jwt        *tokens.JWTConfig
+	binary     *tokens.BinaryConfig
+	ackSize    uint32
+	format     tokens.TokenFormat
+	dictionary *tokens.TagDictionary
+	encryption *tokens.ClaimsEncryption
+	receivers  cache.DataStore
+	serverID   string
+	validity   time.Duration
 }
 
-// New creates a new instance of TokenAccessor interface
-func New(serverID string, validity time.Duration, secret secrets.Secrets) (TokenAccessor, error) {
+// New creates a new instance of TokenAccessor interface. The tokens are
+// created in the given format and the tokens of both formats are accepted.
+// The tags of the dictionary are replaced by references in the binary
+// tokens. The tags are encrypted if a claims key shared by all the
+// enforcers is provided. The tags of the SYN tokens are then encrypted for
+// the key of the receiver learned from its previous SYN/ACK tokens.
+func New(serverID string, validity time.Duration, secret secrets.Secrets, format tokens.TokenFormat, dictionary []string, claimsKey []byte) (TokenAccessor, error) {
 
-	tokenEngine, err := tokens.NewJWT(validity, serverID, secret)
+	tagDictionary, err := tokens.NewTagDictionary(dictionary)
 	if err != nil {
 		return nil, err
 	}
 
-	return &tokenAccessor{
-		tokens:   tokenEngine,
-		serverID: serverID,
-		validity: validity,
-	}, nil
+	var encryption *tokens.ClaimsEncryption
+	if len(claimsKey) > 0 {
+		if encryption, err = tokens.NewClaimsEncryption(claimsKey); err != nil {
+			return nil, err
+		}
+	}
+
+	t := &tokenAccessor{
+		format:     format,
+		dictionary: tagDictionary,
+		encryption: encryption,
+		receivers:  cache.NewCacheWithExpiration("ReceiverKeys", receiverKeyLifetime),
+		serverID:   serverID,
+		validity:   validity,
+	}
+
+	if err := t.SetToken(serverID, validity, secret); err != nil {
+		return nil, err
+	}
+
+	return t, nil
 }
 
 func (t *tokenAccessor) getToken() tokens.TokenEngine {
@@ -44,19 +81,69 @@ func (t *tokenAccessor) getToken() tokens.TokenEngine {
 	return t.tokens
 }
 
+// getDecoder returns the token engine of the format of the token
+func (t *tokenAccessor) getDecoder(isAck bool, data []byte) tokens.TokenEngine {
+
+	t.RLock()
+	defer t.RUnlock()
+
+	if t.binary != nil && tokens.IsBinaryToken(isAck, data) {
+		return t.binary
+	}
+
+	return t.jwt
+}
+
 // SetToken updates sthe stored token in the struct
 func (t *tokenAccessor) SetToken(serverID string, validity time.Duration, secret secrets.Secrets) error {
 
 	t.Lock()
 	defer t.Unlock()
-	tokenEngine, err := tokens.NewJWT(validity, serverID, secret)
+
+	jwtEngine, err := tokens.NewJWT(validity, serverID, secret)
 	if err != nil {
 		return err
 	}
-	t.tokens = tokenEngine
+
+	// The binary engine is only required to decode the tokens of the
+	// peers when the tokens are created as JWTs
+	binaryEngine, err := tokens.NewBinary(validity, serverID, secret, t.dictionary)
+	if err != nil {
+		if t.format == tokens.BinaryFormat {
+			return err
+		}
+		zap.L().Warn("Unable to create binary token engine", zap.Error(err))
+	}
+
+	jwtEngine.EnableClaimsEncryption(t.encryption)
+	if binaryEngine != nil {
+		binaryEngine.EnableClaimsEncryption(t.encryption)
+	}
+
+	t.jwt = jwtEngine
+	t.binary = binaryEngine
+
+	switch t.format {
+	case tokens.BinaryFormat:
+		t.tokens = binaryEngine
+		t.ackSize = binaryEngine.AckSize()
+	default:
+		t.tokens = jwtEngine
+		t.ackSize = secret.AckSize()
+	}
+
 	return nil
 }
 
+// AckSize returns the size of the ack tokens
+func (t *tokenAccessor) AckSize() uint32 {
+
+	t.RLock()
+	defer t.RUnlock()
+
+	return t.ackSize
+}
+
 // GetTokenValidity returns the duration the token is valid for
 func (t *tokenAccessor) GetTokenValidity() time.Duration {
 	return t.validity
@@ -70,6 +157,8 @@ func (t *tokenAccessor) GetTokenServerID() string {
 // CreateAckPacketToken creates the authentication token
 func (t *tokenAccessor) CreateAckPacketToken(context *pucontext.PUContext, auth *connection.AuthInfo) ([]byte, error) {
 
+	defer metrics.TokenLatency.ObserveDuration(time.Now(), metrics.OperationCreate, metrics.PacketAck)
+
 	claims := &tokens.ConnectionClaims{
 		LCL: auth.LocalContext,
 		RMT: auth.RemoteContext,
@@ -77,6 +166,7 @@ func (t *tokenAccessor) CreateAckPacketToken(context *pucontext.PUContext, auth
 
 	token, err := t.getToken().CreateAndSign(true, claims, auth.LocalContext)
 	if err != nil {
+		metrics.TokenErrors.Inc(metrics.OperationCreate, metrics.PacketAck)
 		return []byte{}, err
 	}
 
@@ -86,14 +176,21 @@ func (t *tokenAccessor) CreateAckPacketToken(context *pucontext.PUContext, auth
 // createSynPacketToken creates the authentication token
 func (t *tokenAccessor) CreateSynPacketToken(context *pucontext.PUContext, auth *connection.AuthInfo) (token []byte, err error) {
 
-	token, serviceContext, err := context.GetCachedTokenAndServiceContext()
-	if err == nil && bytes.Equal(auth.LocalServiceContext, serviceContext) {
-		// Randomize the nonce and send it
-		err = t.getToken().Randomize(token, auth.LocalContext)
-		if err == nil {
-			return token, nil
+	defer metrics.TokenLatency.ObserveDuration(time.Now(), metrics.OperationCreate, metrics.PacketSyn)
+
+	// The tokens encrypted for a receiver are not cached for the PU
+	receiverKey := t.receiverKey(auth)
+
+	if receiverKey == nil {
+		cached, serviceContext, err := context.GetCachedTokenAndServiceContext()
+		if err == nil && bytes.Equal(auth.LocalServiceContext, serviceContext) {
+			// Randomize the nonce and send it
+			err = t.getToken().Randomize(cached, auth.LocalContext)
+			if err == nil {
+				return cached, nil
+			}
+			// If there is an error, let's try to create a new one
 		}
-		// If there is an error, let's try to create a new one
 	}
 
 	claims := &tokens.ConnectionClaims{
@@ -101,11 +198,21 @@ func (t *tokenAccessor) CreateSynPacketToken(context *pucontext.PUContext, auth
 		EK: auth.LocalServiceContext,
 	}
 
+	// The tags are encrypted with the shared key if the key of the receiver
+	// is not known
+	if claims, err = t.encryptClaims(claims, receiverKey); err != nil {
+		metrics.TokenErrors.Inc(metrics.OperationCreate, metrics.PacketSyn)
+		return []byte{}, err
+	}
+
 	if token, err = t.getToken().CreateAndSign(false, claims, auth.LocalContext); err != nil {
+		metrics.TokenErrors.Inc(metrics.OperationCreate, metrics.PacketSyn)
 		return []byte{}, nil
 	}
 
-	context.UpdateCachedTokenAndServiceContext(token, auth.LocalServiceContext)
+	if receiverKey == nil {
+		context.UpdateCachedTokenAndServiceContext(token, auth.LocalServiceContext)
+	}
 
 	return token, nil
 }
@@ -114,26 +221,77 @@ func (t *tokenAccessor) CreateSynPacketToken(context *pucontext.PUContext, auth
 // We need to sign the received token. No caching possible here
 func (t *tokenAccessor) CreateSynAckPacketToken(context *pucontext.PUContext, auth *connection.AuthInfo) (token []byte, err error) {
 
+	defer metrics.TokenLatency.ObserveDuration(time.Now(), metrics.OperationCreate, metrics.PacketSynAck)
+
 	claims := &tokens.ConnectionClaims{
 		T:   context.Identity(),
 		RMT: auth.RemoteContext,
 		EK:  auth.LocalServiceContext,
 	}
 
+	// The tags are encrypted for the key of the initiator
+	if claims, err = t.encryptClaims(claims, auth.RemotePublicKey); err != nil {
+		metrics.TokenErrors.Inc(metrics.OperationCreate, metrics.PacketSynAck)
+		return []byte{}, err
+	}
+
 	if token, err = t.getToken().CreateAndSign(false, claims, auth.LocalContext); err != nil {
+		metrics.TokenErrors.Inc(metrics.OperationCreate, metrics.PacketSynAck)
 		return []byte{}, nil
 	}
 
 	return token, nil
 }
 
+// encryptClaims encrypts the tags of the claims if the encryption is enabled
+func (t *tokenAccessor) encryptClaims(claims *tokens.ConnectionClaims, peerKey interface{}) (*tokens.ConnectionClaims, error) {
+
+	if t.encryption == nil {
+		return claims, nil
+	}
+
+	return t.encryption.Encrypt(claims, peerKey)
+}
+
+// receiverKey returns the key of the receiver of a connection learned from
+// its previous SYN/ACK tokens, or nil if it is not known
+func (t *tokenAccessor) receiverKey(auth *connection.AuthInfo) interface{} {
+
+	if t.encryption == nil || auth.RemoteIP == "" {
+		return nil
+	}
+
+	key, err := t.receivers.Get(net.JoinHostPort(auth.RemoteIP, auth.RemotePort))
+	if err != nil {
+		return nil
+	}
+
+	return key
+}
+
+// learnReceiverKey records the key of the receiver of a connection so that
+// the tags of the next SYN tokens to the receiver are encrypted for it
+func (t *tokenAccessor) learnReceiverKey(auth *connection.AuthInfo, key interface{}) {
+
+	if t.encryption == nil || auth.RemoteIP == "" || key == nil {
+		return
+	}
+
+	t.receivers.AddOrUpdate(net.JoinHostPort(auth.RemoteIP, auth.RemotePort), key)
+}
+
 // parsePacketToken parses the packet token and populates the right state.
-// Returns an error if the token cannot be parsed or the signature fails
-func (t *tokenAccessor) ParsePacketToken(auth *connection.AuthInfo, data []byte) (*tokens.ConnectionClaims, error) {
+// Returns an error if the token cannot be parsed or the signature fails.
+// The packet type is the label of the metrics, metrics.PacketSyn or
+// metrics.PacketSynAck.
+func (t *tokenAccessor) ParsePacketToken(auth *connection.AuthInfo, data []byte, packetType string) (*tokens.ConnectionClaims, error) {
+
+	defer metrics.TokenLatency.ObserveDuration(time.Now(), metrics.OperationVerify, packetType)
 
 	// Validate the certificate and parse the token
-	claims, nonce, cert, err := t.getToken().Decode(false, data, auth.RemotePublicKey)
+	claims, nonce, cert, err := t.getDecoder(false, data).Decode(false, data, auth.RemotePublicKey)
 	if err != nil {
+		metrics.TokenErrors.Inc(metrics.OperationVerify, packetType)
 		return nil, err
 	}
 
@@ -148,6 +306,10 @@ func (t *tokenAccessor) ParsePacketToken(auth *connection.AuthInfo, data []byte)
 	auth.RemoteContextID = remoteContextID
 	auth.RemoteServiceContext = claims.EK
 
+	if packetType == metrics.PacketSynAck {
+		t.learnReceiverKey(auth, cert)
+	}
+
 	return claims, nil
 }
 
@@ -155,9 +317,12 @@ func (t *tokenAccessor) ParsePacketToken(auth *connection.AuthInfo, data []byte)
 // and it needs to be recovered
 func (t *tokenAccessor) ParseAckToken(auth *connection.AuthInfo, data []byte) (*tokens.ConnectionClaims, error) {
 
+	defer metrics.TokenLatency.ObserveDuration(time.Now(), metrics.OperationVerify, metrics.PacketAck)
+
 	// Validate the certificate and parse the token
-	claims, _, _, err := t.getToken().Decode(true, data, auth.RemotePublicKey)
+	claims, _, _, err := t.getDecoder(true, data).Decode(true, data, auth.RemotePublicKey)
 	if err != nil {
+		metrics.TokenErrors.Inc(metrics.OperationVerify, metrics.PacketAck)
 		return nil, err
 	}
 
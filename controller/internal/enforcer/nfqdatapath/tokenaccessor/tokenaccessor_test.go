@@ -0,0 +1,108 @@
+package tokenaccessor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/common"
+	enforcerconstants "github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/constants"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/connection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/metrics"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/pucontext"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/tokens"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/testpki"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var claimsKey = []byte("shared claims key")
+
+func newTestAccessor(ca *testpki.Authority, serverID string) TokenAccessor {
+
+	leaf := ca.Issue(serverID)
+
+	s, err := secrets.NewPKISecrets(leaf.KeyPEM(), leaf.CertPEM(), ca.CertPEM(), nil)
+	So(err, ShouldBeNil)
+
+	t, err := New(serverID, time.Hour, s, tokens.JWTFormat, nil, claimsKey)
+	So(err, ShouldBeNil)
+
+	return t
+}
+
+func newTestContext(contextID string) *pucontext.PUContext {
+
+	puInfo := policy.NewPUInfo(contextID, common.ContainerPU)
+	puInfo.Policy.AddIdentityTag(enforcerconstants.TransmitterLabel, contextID)
+	puInfo.Policy.AddIdentityTag("app", "frontend")
+
+	context, err := pucontext.NewPU(contextID, puInfo, time.Hour)
+	So(err, ShouldBeNil)
+
+	return context
+}
+
+func TestReceiverKeys(t *testing.T) {
+
+	Convey("Given an initiator, a receiver and another enforcer that share the claims key", t, func() {
+
+		ca := testpki.NewAuthority("ca")
+		initiator := newTestAccessor(ca, "initiator")
+		receiver := newTestAccessor(ca, "receiver")
+		other := newTestAccessor(ca, "other")
+
+		initiatorContext := newTestContext("pu1")
+		receiverContext := newTestContext("pu2")
+
+		newAuth := func(ip string) *connection.AuthInfo {
+			return &connection.AuthInfo{
+				LocalContext: []byte("1234567890123456"),
+				RemoteIP:     ip,
+				RemotePort:   "80",
+			}
+		}
+
+		auth := newAuth("10.0.0.2")
+		syn, err := initiator.CreateSynPacketToken(initiatorContext, auth)
+		So(err, ShouldBeNil)
+
+		Convey("Then the first syn token to the receiver should be readable with the shared key", func() {
+			claims, err := other.ParsePacketToken(&connection.AuthInfo{}, syn, metrics.PacketSyn)
+			So(err, ShouldBeNil)
+			So(claims.T.Tags, ShouldContain, "app=frontend")
+		})
+
+		Convey("When the initiator receives the syn/ack token of the receiver", func() {
+			receiverAuth := &connection.AuthInfo{LocalContext: []byte("abcdefghijklmnop")}
+			_, err := receiver.ParsePacketToken(receiverAuth, syn, metrics.PacketSyn)
+			So(err, ShouldBeNil)
+
+			synAck, err := receiver.CreateSynAckPacketToken(receiverContext, receiverAuth)
+			So(err, ShouldBeNil)
+
+			_, err = initiator.ParsePacketToken(auth, synAck, metrics.PacketSynAck)
+			So(err, ShouldBeNil)
+
+			next, err := initiator.CreateSynPacketToken(initiatorContext, newAuth("10.0.0.2"))
+			So(err, ShouldBeNil)
+
+			Convey("Then the next syn tokens to the receiver should only be readable by the receiver", func() {
+				claims, err := receiver.ParsePacketToken(&connection.AuthInfo{}, next, metrics.PacketSyn)
+				So(err, ShouldBeNil)
+				So(claims.T.Tags, ShouldContain, "app=frontend")
+
+				_, err = other.ParsePacketToken(&connection.AuthInfo{}, next, metrics.PacketSyn)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then the syn tokens to the other destinations should still use the shared key", func() {
+				token, err := initiator.CreateSynPacketToken(initiatorContext, newAuth("10.0.0.3"))
+				So(err, ShouldBeNil)
+
+				_, err = other.ParsePacketToken(&connection.AuthInfo{}, token, metrics.PacketSyn)
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
@@ -3,19 +3,27 @@ package nfqdatapath
 import (
 	"github.com/aporeto-inc/trireme-lib/collector"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/connection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/metrics"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/pucontext"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
 	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/crypto"
 )
 
-func (d *Datapath) reportAcceptedFlow(p *packet.Packet, conn *connection.TCPConnection, sourceID string, destID string, context *pucontext.PUContext, report *policy.FlowPolicy, packet *policy.FlowPolicy) {
+// flowReporter is a connection that records the reports of its flow
+type flowReporter interface {
+	SetReported(flowState bool)
+}
+
+func (d *Datapath) reportAcceptedFlow(p *packet.Packet, conn flowReporter, sourceID string, destID string, context *pucontext.PUContext, report *policy.FlowPolicy, packet *policy.FlowPolicy) {
 	if conn != nil {
 		conn.SetReported(connection.AcceptReported)
 	}
-	d.reportFlow(p, conn, sourceID, destID, context, "", report, packet)
+	d.reportFlow(p, nil, sourceID, destID, context, "", report, packet)
 }
 
-func (d *Datapath) reportRejectedFlow(p *packet.Packet, conn *connection.TCPConnection, sourceID string, destID string, context *pucontext.PUContext, mode string, report *policy.FlowPolicy, packet *policy.FlowPolicy) {
+func (d *Datapath) reportRejectedFlow(p *packet.Packet, conn flowReporter, protocol string, sourceID string, destID string, context *pucontext.PUContext, mode string, report *policy.FlowPolicy, packet *policy.FlowPolicy) {
 	if conn != nil && mode == collector.PolicyDrop {
 		conn.SetReported(connection.RejectReported)
 	}
@@ -29,7 +37,37 @@ func (d *Datapath) reportRejectedFlow(p *packet.Packet, conn *connection.TCPConn
 	if packet == nil {
 		packet = report
 	}
-	d.reportFlow(p, conn, sourceID, destID, context, mode, report, packet)
+	countDrop(protocol, mode, packet)
+	d.reportFlow(p, nil, sourceID, destID, context, mode, report, packet)
+}
+
+// protocolName returns the name of the transport protocol of the packet
+func protocolName(p *packet.Packet) string {
+	if p.IPProto == packet.IPProtocolUDP {
+		return "udp"
+	}
+	return "tcp"
+}
+
+// countDrop counts a rejected flow. Flows that are only reported as rejected
+// by an observed policy are not dropped and are not counted.
+func countDrop(protocol string, mode string, packet *policy.FlowPolicy) {
+	if mode != collector.PolicyDrop || packet.Action.Rejected() {
+		metrics.Drops.Inc(protocol, mode)
+	}
+}
+
+// tokenDropReason returns the drop reason of a token that was rejected.
+// Tokens rejected because of revoked credentials or of a changed pinned key
+// have their own reason.
+func tokenDropReason(err error, reason string) string {
+	if crypto.IsRevoked(err) {
+		return collector.RevokedCredentials
+	}
+	if secrets.IsPinnedKeyChanged(err) {
+		return collector.PinnedKeyChanged
+	}
+	return reason
 }
 
 func (d *Datapath) reportExternalServiceFlowCommon(context *pucontext.PUContext, report *policy.FlowPolicy, packet *policy.FlowPolicy, app bool, p *packet.Packet, src, dst *collector.EndPoint) {
@@ -62,6 +100,10 @@ func (d *Datapath) reportExternalServiceFlowCommon(context *pucontext.PUContext,
 		record.ObservedPolicyID = packet.PolicyID
 	}
 
+	if packet.Action.Rejected() {
+		metrics.Drops.Inc(protocolName(p), collector.PolicyDrop)
+	}
+
 	d.collector.CollectFlowEvent(record)
 }
 
@@ -7,6 +7,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,10 +20,14 @@ import (
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/utils/rpcwrapper"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/portset"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/processmon"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/certissuer"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/metrics"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packetprocessor"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/remoteenforcer"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/tokens"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/crypto"
 )
@@ -31,6 +36,11 @@ import (
 type ProxyInfo struct {
 	MutualAuth             bool
 	PacketLogs             bool
+	TokenFormat            tokens.TokenFormat
+	TagDictionary          []string
+	ClaimsKey              []byte
+	CertificateIssuer      certissuer.CertificateIssuer
+	CertificateRenewal     float64
 	Secrets                secrets.Secrets
 	serverID               string
 	validity               time.Duration
@@ -52,6 +62,11 @@ func (s *ProxyInfo) InitRemoteEnforcer(contextID string) error {
 
 	resp := &rpcwrapper.Response{}
 
+	// The secrets can change async to the init call from UpdateSecrets
+	s.RLock()
+	publicSecrets := s.Secrets.PublicSecrets()
+	s.RUnlock()
+
 	request := &rpcwrapper.Request{
 		Payload: &rpcwrapper.InitRequestPayload{
 			FqConfig:               s.filterQueue,
@@ -60,10 +75,23 @@ func (s *ProxyInfo) InitRemoteEnforcer(contextID string) error {
 			ServerID:               s.serverID,
 			ExternalIPCacheTimeout: s.ExternalIPCacheTimeout,
 			PacketLogs:             s.PacketLogs,
-			Secrets:                s.Secrets.PublicSecrets(),
+			Secrets:                publicSecrets,
+			TokenFormat:            s.TokenFormat,
+			TagDictionary:          s.TagDictionary,
+			ClaimsKey:              s.ClaimsKey,
+			CertificateRenewal:     s.CertificateRenewal,
 		},
 	}
 
+	// Only the local CA can be transmitted to the remote enforcers
+	if s.CertificateIssuer != nil {
+		if ca, ok := s.CertificateIssuer.(*certissuer.LocalCA); ok {
+			request.Payload.(*rpcwrapper.InitRequestPayload).CertificateIssuer = ca
+		} else {
+			zap.L().Warn("Certificate issuer is not supported by the remote enforcers", zap.String("contextID", contextID))
+		}
+	}
+
 	if err := s.rpchdl.RemoteCall(contextID, remoteenforcer.InitEnforcer, request, resp); err != nil {
 		return fmt.Errorf("failed to initialize remote enforcer: status: %s: %s", resp.Status, err)
 	}
@@ -79,25 +107,36 @@ func (s *ProxyInfo) InitRemoteEnforcer(contextID string) error {
 	return nil
 }
 
-// UpdateSecrets updates the secrets used for signing communication between trireme instances
+// UpdateSecrets updates the secrets used for signing communication between trireme instances.
+// All the remote enforcers are updated immediately, even if some of them fail.
 func (s *ProxyInfo) UpdateSecrets(token secrets.Secrets) error {
 	s.Lock()
 	s.Secrets = token
 	s.Unlock()
 
-	resp := &rpcwrapper.Response{}
 	request := &rpcwrapper.Request{
 		Payload: &rpcwrapper.UpdateSecretsPayload{
-			Secrets: s.Secrets.PublicSecrets(),
+			Secrets: token.PublicSecrets(),
 		},
 	}
 
+	failed := []string{}
 	for _, contextID := range s.rpchdl.ContextList() {
+		resp := &rpcwrapper.Response{}
 		if err := s.rpchdl.RemoteCall(contextID, remoteenforcer.UpdateSecrets, request, resp); err != nil {
-			return fmt.Errorf("Failed to update secrets. status %s: %s", resp.Status, err)
+			zap.L().Error("Failed to update secrets of remote enforcer",
+				zap.String("contextID", contextID),
+				zap.String("status", resp.Status),
+				zap.Error(err),
+			)
+			failed = append(failed, contextID)
 		}
 	}
 
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to update secrets of remote enforcers: %s", strings.Join(failed, ", "))
+	}
+
 	return nil
 }
 
@@ -153,9 +192,100 @@ func (s *ProxyInfo) Unenforce(contextID string) error {
 	delete(s.initDone, contextID)
 	s.Unlock()
 
+	metrics.DefaultRegistry.RemoveRemote(contextID)
+
 	return nil
 }
 
+// ListPUs returns the PUs enforced by all the remote enforcers.
+func (s *ProxyInfo) ListPUs() ([]*introspection.PUState, error) {
+
+	s.RLock()
+	contextIDs := make([]string, 0, len(s.initDone))
+	for contextID := range s.initDone {
+		contextIDs = append(contextIDs, contextID)
+	}
+	s.RUnlock()
+
+	list := []*introspection.PUState{}
+	for _, contextID := range contextIDs {
+		pu, err := s.GetPU(contextID)
+		if err != nil {
+			zap.L().Warn("Unable to retrieve PU from remote enforcer", zap.String("contextID", contextID), zap.Error(err))
+			continue
+		}
+		list = append(list, pu)
+	}
+
+	return list, nil
+}
+
+// GetPU makes a RPC call to retrieve the PU from the remote enforcer.
+func (s *ProxyInfo) GetPU(contextID string) (*introspection.PUState, error) {
+
+	resp := &rpcwrapper.Response{}
+	request := &rpcwrapper.Request{
+		Payload: &rpcwrapper.IntrospectPayload{
+			ContextID: contextID,
+		},
+	}
+
+	if err := s.rpchdl.RemoteCall(contextID, remoteenforcer.GetPU, request, resp); err != nil {
+		return nil, fmt.Errorf("unable to retrieve pu %s from remote enforcer: %s", contextID, err)
+	}
+
+	payload, ok := resp.Payload.(rpcwrapper.PUStateResponsePayload)
+	if !ok || payload.PU == nil {
+		return nil, fmt.Errorf("invalid response from remote enforcer of %s", contextID)
+	}
+
+	return payload.PU, nil
+}
+
+// ListConnections makes a RPC call to retrieve the connections of a PU from the remote enforcer.
+func (s *ProxyInfo) ListConnections(contextID string) ([]*introspection.ConnectionState, error) {
+
+	resp := &rpcwrapper.Response{}
+	request := &rpcwrapper.Request{
+		Payload: &rpcwrapper.IntrospectPayload{
+			ContextID: contextID,
+		},
+	}
+
+	if err := s.rpchdl.RemoteCall(contextID, remoteenforcer.ListConnections, request, resp); err != nil {
+		return nil, fmt.Errorf("unable to retrieve connections of %s from remote enforcer: %s", contextID, err)
+	}
+
+	payload, ok := resp.Payload.(rpcwrapper.ConnectionsResponsePayload)
+	if !ok {
+		return nil, fmt.Errorf("invalid response from remote enforcer of %s", contextID)
+	}
+
+	return payload.Connections, nil
+}
+
+// ListPeerKeys makes a RPC call to retrieve the keys of the peers seen by the remote enforcer of a PU.
+func (s *ProxyInfo) ListPeerKeys(contextID string) ([]secrets.PeerKey, error) {
+
+	resp := &rpcwrapper.Response{}
+	request := &rpcwrapper.Request{
+		Payload: &rpcwrapper.IntrospectPayload{
+			ContextID: contextID,
+		},
+	}
+
+	if err := s.rpchdl.RemoteCall(contextID, remoteenforcer.ListPeerKeys, request, resp); err != nil {
+		return nil, fmt.Errorf("unable to retrieve peer keys of %s from remote enforcer: %s", contextID, err)
+	}
+
+	payload, ok := resp.Payload.(rpcwrapper.PeerKeysResponsePayload)
+	if !ok {
+		return nil, fmt.Errorf("invalid response from remote enforcer of %s", contextID)
+	}
+
+	return payload.Keys, nil
+}
+
 // GetFilterQueue returns the current FilterQueueConfig.
 func (s *ProxyInfo) GetFilterQueue() *fqconfig.FilterQueue {
 	return s.filterQueue
@@ -191,6 +321,11 @@ func NewProxyEnforcer(mutualAuth bool,
 	procMountPoint string,
 	ExternalIPCacheTimeout time.Duration,
 	packetLogs bool,
+	tokenFormat tokens.TokenFormat,
+	tagDictionary []string,
+	claimsKey []byte,
+	certIssuer certissuer.CertificateIssuer,
+	certRenewal float64,
 ) enforcer.Enforcer {
 	return newProxyEnforcer(
 		mutualAuth,
@@ -207,6 +342,11 @@ func NewProxyEnforcer(mutualAuth bool,
 		ExternalIPCacheTimeout,
 		nil,
 		packetLogs,
+		tokenFormat,
+		tagDictionary,
+		claimsKey,
+		certIssuer,
+		certRenewal,
 	)
 }
 
@@ -225,6 +365,11 @@ func newProxyEnforcer(mutualAuth bool,
 	ExternalIPCacheTimeout time.Duration,
 	portSetInstance portset.PortSet,
 	packetLogs bool,
+	tokenFormat tokens.TokenFormat,
+	tagDictionary []string,
+	claimsKey []byte,
+	certIssuer certissuer.CertificateIssuer,
+	certRenewal float64,
 ) enforcer.Enforcer {
 
 	statsServersecret, err := crypto.GenerateRandomString(32)
@@ -249,6 +394,11 @@ func newProxyEnforcer(mutualAuth bool,
 		procMountPoint:         procMountPoint,
 		ExternalIPCacheTimeout: ExternalIPCacheTimeout,
 		PacketLogs:             packetLogs,
+		TokenFormat:            tokenFormat,
+		TagDictionary:          tagDictionary,
+		ClaimsKey:              claimsKey,
+		CertificateIssuer:      certIssuer,
+		CertificateRenewal:     certRenewal,
 		portSetInstance:        portSetInstance,
 		collector:              collector,
 	}
@@ -285,6 +435,11 @@ func NewDefaultProxyEnforcer(serverID string,
 		procMountPoint,
 		defaultExternalIPCacheTimeout,
 		defaultPacketLogs,
+		tokens.JWTFormat,
+		nil,
+		nil,
+		nil,
+		0,
 	)
 }
 
@@ -312,5 +467,9 @@ func (r *StatsServer) GetStats(req rpcwrapper.Request, resp *rpcwrapper.Response
 		r.collector.CollectUserEvent(record)
 	}
 
+	if payload.Metrics != nil {
+		metrics.DefaultRegistry.SetRemote(payload.Source, payload.Metrics)
+	}
+
 	return nil
 }
@@ -1,7 +1,7 @@
 package enforcerproxy
 
 import (
-	"crypto/ecdsa"
+	"crypto"
 	"testing"
 	"time"
 
@@ -13,6 +13,7 @@ import (
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/remoteenforcer"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/tokens"
 	"github.com/aporeto-inc/trireme-lib/policy"
 
 	mockrpcwrapper "github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/utils/rpcwrapper/mock"
@@ -87,7 +88,7 @@ func secretGen(keyPEM, certPEM, caPool []byte) secrets.Secrets {
 		newSecret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
 		return newSecret
 	}
-	newSecret, _ := secrets.NewPKISecrets([]byte(keyPEM), []byte(certPEM), []byte(caPool), map[string]*ecdsa.PublicKey{})
+	newSecret, _ := secrets.NewPKISecrets([]byte(keyPEM), []byte(certPEM), []byte(caPool), map[string]crypto.PublicKey{})
 	return newSecret
 }
 
@@ -141,6 +142,11 @@ func setupProxyEnforcer(rpchdl rpcwrapper.RPCClient, prochdl processmon.ProcessM
 		defaultExternalIPCacheTimeout,
 		nil,
 		false,
+		tokens.JWTFormat,
+		nil,
+		nil,
+		nil,
+		0,
 	)
 	return policyEnf
 }
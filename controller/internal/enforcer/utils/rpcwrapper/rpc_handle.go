@@ -252,6 +252,8 @@ func RegisterTypes() {
 	gob.Register(&secrets.CompactPKIPublicSecrets{})
 	gob.Register(&secrets.PKIPublicSecrets{})
 	gob.Register(&secrets.PSKPublicSecrets{})
+	gob.Register(&secrets.RotatedPublicSecrets{})
+	gob.Register(&secrets.SVIDPublicSecrets{})
 	gob.RegisterName("github.com/aporeto-inc/internal/enforcer/utils/rpcwrapper.Init_Request_Payload", *(&InitRequestPayload{}))
 	gob.RegisterName("github.com/aporeto-inc/internal/enforcer/utils/rpcwrapper.Init_Response_Payload", *(&InitResponsePayload{}))
 	gob.RegisterName("github.com/aporeto-inc/internal/enforcer/utils/rpcwrapper.Init_Supervisor_Payload", *(&InitSupervisorPayload{}))
@@ -263,4 +265,8 @@ func RegisterTypes() {
 	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.UnSupervise_Payload", *(&UnSupervisePayload{}))
 	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Stats_Payload", *(&StatsPayload{}))
 	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.UpdateSecrets_Payload", *(&UpdateSecretsPayload{}))
+	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Introspect_Payload", *(&IntrospectPayload{}))
+	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.PUState_Response_Payload", *(&PUStateResponsePayload{}))
+	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Connections_Response_Payload", *(&ConnectionsResponsePayload{}))
+	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.PeerKeys_Response_Payload", *(&PeerKeysResponsePayload{}))
 }
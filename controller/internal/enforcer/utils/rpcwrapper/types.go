@@ -4,8 +4,12 @@ import (
 	"time"
 
 	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/certissuer"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/metrics"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/tokens"
 	"github.com/aporeto-inc/trireme-lib/policy"
 )
 
@@ -17,6 +21,8 @@ const (
 	IPTables CaptureType = iota
 	// IPSets forces an IPSet implementation
 	IPSets
+	// NFTables forces an nftables implementation
+	NFTables
 )
 
 //Request exported
@@ -34,7 +40,8 @@ const (
 //Response is the response for every RPC call. This is used to carry the status of the actual function call
 //made on the remote end
 type Response struct {
-	Status string
+	Status  string
+	Payload interface{} `json:",omitempty"`
 }
 
 //InitRequestPayload Payload for enforcer init request
@@ -46,6 +53,11 @@ type InitRequestPayload struct {
 	ServerID               string                `json:",omitempty"`
 	ExternalIPCacheTimeout time.Duration         `json:",omitempty"`
 	Secrets                secrets.PublicSecrets `json:",omitempty"`
+	TokenFormat            tokens.TokenFormat    `json:",omitempty"`
+	TagDictionary          []string              `json:",omitempty"`
+	ClaimsKey              []byte                `json:",omitempty"`
+	CertificateIssuer      *certissuer.LocalCA   `json:",omitempty"`
+	CertificateRenewal     float64               `json:",omitempty"`
 }
 
 // UpdateSecretsPayload payload for the update secrets to remote enforcers
@@ -106,9 +118,32 @@ type UnEnforceResponsePayload struct {
 type StatsPayload struct {
 	Flows map[string]*collector.FlowRecord `json:",omitempty"`
 	Users map[string]*collector.UserRecord `json:",omitempty"`
+	// Source is the context of the remote enforcer that sends the metrics
+	Source  string            `json:",omitempty"`
+	Metrics []*metrics.Family `json:",omitempty"`
 }
 
 //ExcludeIPRequestPayload carries the list of excluded ips
 type ExcludeIPRequestPayload struct {
 	IPs []string `json:",omitempty"`
 }
+
+// IntrospectPayload carries the context of an introspection request
+type IntrospectPayload struct {
+	ContextID string `json:",omitempty"`
+}
+
+// PUStateResponsePayload carries the state of a PU in the response of an introspection request
+type PUStateResponsePayload struct {
+	PU *introspection.PUState `json:",omitempty"`
+}
+
+// ConnectionsResponsePayload carries the connections of a PU in the response of an introspection request
+type ConnectionsResponsePayload struct {
+	Connections []*introspection.ConnectionState `json:",omitempty"`
+}
+
+// PeerKeysResponsePayload carries the keys of the peers seen by an enforcer in the response of an introspection request
+type PeerKeysResponsePayload struct {
+	Keys []secrets.PeerKey `json:",omitempty"`
+}
@@ -16,13 +16,24 @@ type PortManipulator interface {
 	AddPortToUser(userName string, port string) (bool, error)
 }
 
+// Programmer programs the ports of the users in the port sets of the
+// packet filter.
+type Programmer interface {
+	AddPort(portSetName string, port string) error
+	DeletePort(portSetName string, port string) error
+}
+
 // PortSet provides an interface to update the
-// mappings required to program the ipset portsets.
+// mappings required to program the portsets.
 type PortSet interface {
 	UserManipulator
 
 	PortManipulator
 
+	// SetProgrammer replaces the programmer of the port sets. The ipsets
+	// are programmed by default.
+	SetProgrammer(programmer Programmer)
+
 	addPortSet(userName string, port string) error
 	deletePortSet(userName string, port string) error
 }
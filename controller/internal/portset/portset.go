@@ -7,6 +7,7 @@ import (
 	"os/user"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
@@ -36,6 +37,32 @@ type portSetInstance struct {
 	userPortMap       cache.DataStore
 	markUserMap       cache.DataStore
 	contextIDFromPort *portcache.PortCache
+	programmer        Programmer
+
+	sync.RWMutex
+}
+
+// ipsetProgrammer programs the ports in ipsets
+type ipsetProgrammer struct{}
+
+// AddPort adds the port to the ipset
+func (ipsetProgrammer) AddPort(portSetName string, port string) error {
+
+	ips := ipset.IPSet{
+		Name: portSetName,
+	}
+
+	return ips.Add(port, 0)
+}
+
+// DeletePort deletes the port from the ipset
+func (ipsetProgrammer) DeletePort(portSetName string, port string) error {
+
+	ips := ipset.IPSet{
+		Name: portSetName,
+	}
+
+	return ips.Del(port)
 }
 
 // expirer deletes the port entry in the portset when the key uid:port expires.
@@ -76,6 +103,7 @@ func New(contextIDFromPort *portcache.PortCache) PortSet {
 		userPortMap:       cache.NewCacheWithExpirationNotifier("userPortMap", portEntryTimeout*time.Second, expirer),
 		markUserMap:       cache.NewCache("markUserMap"),
 		contextIDFromPort: contextIDFromPort,
+		programmer:        ipsetProgrammer{},
 	}
 
 	go startPortSetTask(p)
@@ -83,6 +111,24 @@ func New(contextIDFromPort *portcache.PortCache) PortSet {
 	return p
 }
 
+// SetProgrammer replaces the programmer of the port sets
+func (p *portSetInstance) SetProgrammer(programmer Programmer) {
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.programmer = programmer
+}
+
+// getProgrammer returns the programmer of the port sets
+func (p *portSetInstance) getProgrammer() Programmer {
+
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.programmer
+}
+
 func getUserName(uid string) (string, error) {
 
 	u, err := user.LookupId(uid)
@@ -158,7 +204,7 @@ func (p *portSetInstance) GetUserMark(mark string) (string, error) {
 	return user, nil
 }
 
-// addPortSet programs the ipset portset with port. The portset name is derived from userPortSet cache.
+// addPortSet programs the portset with port. The portset name is derived from userPortSet cache.
 func (p *portSetInstance) addPortSet(userName string, port string) (err error) {
 
 	puPortSetName, err := p.getUserPortSet(userName)
@@ -166,15 +212,11 @@ func (p *portSetInstance) addPortSet(userName string, port string) (err error) {
 		return fmt.Errorf("unable to get portset from uid: %s", err)
 	}
 
-	ips := ipset.IPSet{
-		Name: puPortSetName,
-	}
-
 	if _, err = strconv.Atoi(port); err != nil {
 		return fmt.Errorf("invalid port: %s", err)
 	}
 
-	if err = ips.Add(port, 0); err != nil {
+	if err = p.getProgrammer().AddPort(puPortSetName, port); err != nil {
 		return fmt.Errorf("unable to add port to set: %s", err)
 	}
 
@@ -189,15 +231,11 @@ func (p *portSetInstance) deletePortSet(userName string, port string) error {
 		return fmt.Errorf("unable to get portset from uid: %s", err)
 	}
 
-	ips := ipset.IPSet{
-		Name: puPortSetName,
-	}
-
 	if _, err = strconv.Atoi(port); err != nil {
 		return fmt.Errorf("invalid port: %s", err)
 	}
 
-	if err = ips.Del(port); err != nil {
+	if err = p.getProgrammer().DeletePort(puPortSetName, port); err != nil {
 		return fmt.Errorf("unable to delete port from portset: %s", err)
 	}
 
@@ -0,0 +1,86 @@
+package supervisor
+
+import (
+	"context"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"go.uber.org/zap"
+)
+
+// dualStack is an Implementor that programs the ipv4 and ipv6 packet filters
+// together. Each implementor only manages the addresses of its own family.
+type dualStack struct {
+	ipv4 Implementor
+	ipv6 Implementor
+}
+
+// newDualStack returns an implementor that drives both the ipv4 and ipv6 implementors
+func newDualStack(ipv4, ipv6 Implementor) Implementor {
+	return &dualStack{
+		ipv4: ipv4,
+		ipv6: ipv6,
+	}
+}
+
+// ConfigureRules implements the ConfigureRules of the Implementor interface
+func (d *dualStack) ConfigureRules(version int, contextID string, containerInfo *policy.PUInfo) error {
+
+	if err := d.ipv4.ConfigureRules(version, contextID, containerInfo); err != nil {
+		return err
+	}
+
+	return d.ipv6.ConfigureRules(version, contextID, containerInfo)
+}
+
+// UpdateRules implements the UpdateRules of the Implementor interface
+func (d *dualStack) UpdateRules(version int, contextID string, containerInfo *policy.PUInfo, oldContainerInfo *policy.PUInfo) error {
+
+	if err := d.ipv4.UpdateRules(version, contextID, containerInfo, oldContainerInfo); err != nil {
+		return err
+	}
+
+	return d.ipv6.UpdateRules(version, contextID, containerInfo, oldContainerInfo)
+}
+
+// DeleteRules implements the DeleteRules of the Implementor interface. The ipv6
+// rules are always deleted, even if the ipv4 rules fail.
+func (d *dualStack) DeleteRules(version int, contextID string, port string, mark string, uid string, proxyPort string) error {
+
+	err := d.ipv4.DeleteRules(version, contextID, port, mark, uid, proxyPort)
+
+	if err6 := d.ipv6.DeleteRules(version, contextID, port, mark, uid, proxyPort); err6 != nil {
+		zap.L().Warn("Some ipv6 rules were not deleted", zap.Error(err6))
+	}
+
+	return err
+}
+
+// SetTargetNetworks implements the SetTargetNetworks of the Implementor interface
+func (d *dualStack) SetTargetNetworks(current, networks []string) error {
+
+	if err := d.ipv4.SetTargetNetworks(current, networks); err != nil {
+		return err
+	}
+
+	return d.ipv6.SetTargetNetworks(current, networks)
+}
+
+// Run implements the Run of the Implementor interface
+func (d *dualStack) Run(ctx context.Context) error {
+
+	if err := d.ipv4.Run(ctx); err != nil {
+		return err
+	}
+
+	return d.ipv6.Run(ctx)
+}
+
+// CleanUp implements the CleanUp of the Implementor interface
+func (d *dualStack) CleanUp() error {
+
+	if err := d.ipv4.CleanUp(); err != nil {
+		zap.L().Warn("Unable to clean up the ipv4 rules", zap.Error(err))
+	}
+
+	return d.ipv6.CleanUp()
+}
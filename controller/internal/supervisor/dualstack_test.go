@@ -0,0 +1,55 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	mock_supervisor "github.com/aporeto-inc/trireme-lib/controller/internal/supervisor/mock"
+	"github.com/golang/mock/gomock"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDualStack(t *testing.T) {
+
+	Convey("Given a dual stack implementor", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		ipv4 := mock_supervisor.NewMockImplementor(ctrl)
+		ipv6 := mock_supervisor.NewMockImplementor(ctrl)
+		impl := newDualStack(ipv4, ipv6)
+		puInfo := createPUInfo()
+
+		Convey("When I configure the rules, both implementors should be configured", func() {
+			ipv4.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
+			ipv6.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
+			So(impl.ConfigureRules(0, "contextID", puInfo), ShouldBeNil)
+		})
+
+		Convey("When the ipv4 implementor fails to configure the rules, I should get an error", func() {
+			ipv4.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(errors.New("error"))
+			So(impl.ConfigureRules(0, "contextID", puInfo), ShouldNotBeNil)
+		})
+
+		Convey("When the ipv4 implementor fails to delete the rules, the ipv6 rules should still be deleted", func() {
+			ipv4.EXPECT().DeleteRules(0, "contextID", "", "", "", "").Return(errors.New("error"))
+			ipv6.EXPECT().DeleteRules(0, "contextID", "", "", "", "").Return(nil)
+			So(impl.DeleteRules(0, "contextID", "", "", "", ""), ShouldNotBeNil)
+		})
+
+		Convey("When I set the target networks, both implementors should receive them", func() {
+			networks := []string{"172.17.0.0/16", "2001:db8::/32"}
+			ipv4.EXPECT().SetTargetNetworks([]string{}, networks).Return(nil)
+			ipv6.EXPECT().SetTargetNetworks([]string{}, networks).Return(nil)
+			So(impl.SetTargetNetworks([]string{}, networks), ShouldBeNil)
+		})
+
+		Convey("When I run it and the ipv6 implementor fails, I should get an error", func() {
+			ipv4.EXPECT().Run(gomock.Any()).Return(nil)
+			ipv6.EXPECT().Run(gomock.Any()).Return(errors.New("error"))
+			So(impl.Run(context.Background()), ShouldNotBeNil)
+		})
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/aporeto-inc/trireme-lib/controller/constants"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/supervisor/provider"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/cgnetcls"
@@ -234,7 +235,7 @@ func (i *Instance) trapRules(appChain string, netChain string) [][]string {
 	// Application Packets - SYN
 	rules = append(rules, []string{
 		i.appPacketIPTableContext, appChain,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", i.targetSetName, "dst",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN",
 		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueSynStr(),
 	})
@@ -242,14 +243,14 @@ func (i *Instance) trapRules(appChain string, netChain string) [][]string {
 	// Application Packets - Evertyhing but SYN and SYN,ACK (first 4 packets). SYN,ACK is captured by global rule
 	rules = append(rules, []string{
 		i.appPacketIPTableContext, appChain,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", i.targetSetName, "dst",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "ACK",
 		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueAckStr(),
 	})
 
 	rules = append(rules, []string{
 		i.appPacketIPTableContext, appChain,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", i.targetSetName, "dst",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueAckStr(),
 	})
@@ -257,18 +258,34 @@ func (i *Instance) trapRules(appChain string, netChain string) [][]string {
 	// Network Packets - SYN
 	rules = append(rules, []string{
 		i.netPacketIPTableContext, netChain,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
+		"-m", "set", "--match-set", i.targetSetName, "src",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN",
 		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueSynStr(),
 	})
 	// Network Packets - Evertyhing but SYN and SYN,ACK (first 4 packets). SYN,ACK is captured by global rule
 	rules = append(rules, []string{
 		i.netPacketIPTableContext, netChain,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
+		"-m", "set", "--match-set", i.targetSetName, "src",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "ACK",
 		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueAckStr(),
 	})
 
+	// Application Packets - UDP. Released flows are accepted by the global connmark rule
+	rules = append(rules, []string{
+		i.appPacketIPTableContext, appChain,
+		"-m", "set", "--match-set", i.targetSetName, "dst",
+		"-p", "udp",
+		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueAckStr(),
+	})
+
+	// Network Packets - UDP
+	rules = append(rules, []string{
+		i.netPacketIPTableContext, netChain,
+		"-m", "set", "--match-set", i.targetSetName, "src",
+		"-p", "udp",
+		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueAckStr(),
+	})
+
 	return rules
 }
 
@@ -345,18 +362,124 @@ func (i *Instance) addPacketTrap(appChain string, netChain string, networks []st
 
 }
 
+// aclWriter programs the rules of the ACLs of a chain. Without order, the
+// accept rules are appended to the chain and the reject rules are inserted at
+// its top so that they override the accept rules. When the ACLs are ordered,
+// the rules of every ACL are kept together and programmed in the order of the
+// ACLs: the reject ACLs go to the top of the chain in the deny overrides mode,
+// and all the ACLs are appended in the other modes.
+type aclWriter struct {
+	ipt     provider.IptablesProvider
+	table   string
+	chain   string
+	mode    policy.ConflictMode
+	ordered bool
+	// block holds the rules of the current ACL and top is true if they are
+	// inserted at the top of the chain
+	block [][]string
+	top   bool
+	// the rules of the ordered ACLs at the top and at the end of the chain
+	inserts [][]string
+	appends [][]string
+}
+
+// newACLWriter creates a writer of the ACLs of a chain
+func newACLWriter(ipt provider.IptablesProvider, table, chain string, mode policy.ConflictMode, priorities bool) *aclWriter {
+	return &aclWriter{
+		ipt:     ipt,
+		table:   table,
+		chain:   chain,
+		mode:    mode,
+		ordered: mode.Ordered(priorities),
+	}
+}
+
+// append adds a rule after the previous rules of the ACL
+func (w *aclWriter) append(rulespec ...string) error {
+
+	if !w.ordered {
+		return w.ipt.Append(w.table, w.chain, rulespec...)
+	}
+
+	w.block = append(w.block, rulespec)
+
+	return nil
+}
+
+// insert adds a rule before the previous rules of the ACL
+func (w *aclWriter) insert(rulespec ...string) error {
+
+	if !w.ordered {
+		return w.ipt.Insert(w.table, w.chain, 1, rulespec...)
+	}
+
+	w.block = append([][]string{rulespec}, w.block...)
+	w.top = true
+
+	return nil
+}
+
+// next ends the rules of the current ACL
+func (w *aclWriter) next() {
+
+	if w.top && w.mode == policy.DenyOverrides {
+		w.inserts = append(w.inserts, w.block...)
+	} else {
+		w.appends = append(w.appends, w.block...)
+	}
+
+	w.block = nil
+	w.top = false
+}
+
+// flush programs the rules of the ordered ACLs
+func (w *aclWriter) flush() error {
+
+	w.next()
+
+	for idx, rule := range w.inserts {
+		if err := w.ipt.Insert(w.table, w.chain, idx+1, rule...); err != nil {
+			return err
+		}
+	}
+
+	for _, rule := range w.appends {
+		if err := w.ipt.Append(w.table, w.chain, rule...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // addAppACLs adds a set of rules to the external services that are initiated
 // by an application. The allow rules are inserted with highest priority.
-func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList) error {
+// When the conflict mode or the priorities order the rules, the rules are
+// programmed in the order in which they are evaluated.
+func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList, mode policy.ConflictMode) error {
+
+	w := newACLWriter(i.ipt, i.appPacketIPTableContext, chain, mode, rules.HasPriorities())
+	if w.ordered {
+		rules = rules.Sort(mode)
+	}
 
 	for loop := 0; loop < 3; loop++ {
 
+		// The ordered ACLs are programmed in a single pass
+		if w.ordered && loop > 0 {
+			break
+		}
+
 		for _, rule := range rules {
 
+			if !i.isFamilyAddress(rule.Address) {
+				continue
+			}
+
 			observeContinue := rule.Policy.ObserveAction.ObserveContinue()
 			switch loop {
 			case 0:
-				if !observeContinue {
+				if !observeContinue && !w.ordered {
 					continue
 				}
 			case 1:
@@ -369,6 +492,8 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 				}
 			}
 
+			w.next()
+
 			proto := strings.ToLower(rule.Protocol)
 
 			if proto == "udp" || proto == "tcp" {
@@ -377,9 +502,7 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 				case policy.Accept:
 
 					if rule.Policy.Action&policy.Log > 0 || observeContinue {
-						if err := i.ipt.Append(
-							i.appPacketIPTableContext,
-							chain,
+						if err := w.append(
 							"-p", rule.Protocol,
 							"-d", rule.Address,
 							"--dport", rule.Port,
@@ -393,8 +516,7 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 					}
 
 					if observeContinue {
-						if err := i.ipt.Append(
-							i.appPacketIPTableContext, chain,
+						if err := w.append(
 							"-p", rule.Protocol, "-m", "state", "--state", "NEW",
 							"-d", rule.Address,
 							"--dport", rule.Port,
@@ -404,8 +526,7 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 							return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
 						}
 					} else {
-						if err := i.ipt.Append(
-							i.appPacketIPTableContext, chain,
+						if err := w.append(
 							"-p", rule.Protocol, "-m", "state", "--state", "NEW",
 							"-d", rule.Address,
 							"--dport", rule.Port,
@@ -417,8 +538,7 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 
 				case policy.Reject:
 					if observeContinue {
-						if err := i.ipt.Insert(
-							i.appPacketIPTableContext, chain, 1,
+						if err := w.insert(
 							"-p", rule.Protocol, "-m", "state", "--state", "NEW",
 							"-d", rule.Address,
 							"--dport", rule.Port,
@@ -428,8 +548,7 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 							return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
 						}
 					} else {
-						if err := i.ipt.Insert(
-							i.appPacketIPTableContext, chain, 1,
+						if err := w.insert(
 							"-p", rule.Protocol, "-m", "state", "--state", "NEW",
 							"-d", rule.Address,
 							"--dport", rule.Port,
@@ -440,10 +559,7 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 					}
 
 					if rule.Policy.Action&policy.Log > 0 || observeContinue {
-						if err := i.ipt.Insert(
-							i.appPacketIPTableContext,
-							chain,
-							1,
+						if err := w.insert(
 							"-p", rule.Protocol,
 							"-d", rule.Address,
 							"--dport", rule.Port,
@@ -466,9 +582,7 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 				case policy.Accept:
 
 					if rule.Policy.Action&policy.Log > 0 || observeContinue {
-						if err := i.ipt.Append(
-							i.appPacketIPTableContext,
-							chain,
+						if err := w.append(
 							"-p", rule.Protocol,
 							"-d", rule.Address,
 							"-m", "state", "--state", "NEW",
@@ -481,8 +595,7 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 					}
 
 					if observeContinue {
-						if err := i.ipt.Append(
-							i.appPacketIPTableContext, chain,
+						if err := w.append(
 							"-p", rule.Protocol,
 							"-d", rule.Address,
 							"-m", "mark", "!", "--mark", observeMark,
@@ -491,8 +604,7 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 							return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
 						}
 					} else {
-						if err := i.ipt.Append(
-							i.appPacketIPTableContext, chain,
+						if err := w.append(
 							"-p", rule.Protocol,
 							"-d", rule.Address,
 							"-j", "ACCEPT",
@@ -503,8 +615,7 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 
 				case policy.Reject:
 					if observeContinue {
-						if err := i.ipt.Insert(
-							i.appPacketIPTableContext, chain, 1,
+						if err := w.insert(
 							"-p", rule.Protocol,
 							"-d", rule.Address,
 							"-m", "mark", "!", "--mark", observeMark,
@@ -513,8 +624,7 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 							return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
 						}
 					} else {
-						if err := i.ipt.Insert(
-							i.appPacketIPTableContext, chain, 1,
+						if err := w.insert(
 							"-p", rule.Protocol,
 							"-d", rule.Address,
 							"-j", "DROP",
@@ -524,10 +634,7 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 					}
 
 					if rule.Policy.Action&policy.Log > 0 || observeContinue {
-						if err := i.ipt.Insert(
-							i.appPacketIPTableContext,
-							chain,
-							1,
+						if err := w.insert(
 							"-p", rule.Protocol,
 							"-d", rule.Address,
 							"-m", "state", "--state", "NEW",
@@ -545,10 +652,14 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 		}
 	}
 
+	if err := w.flush(); err != nil {
+		return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
+	}
+
 	// Accept established connections
 	if err := i.ipt.Append(
 		i.appPacketIPTableContext, chain,
-		"-d", "0.0.0.0/0",
+		"-d", i.allNetworks,
 		"-p", "udp", "-m", "state", "--state", "ESTABLISHED",
 		"-j", "ACCEPT"); err != nil {
 
@@ -557,7 +668,7 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 
 	if err := i.ipt.Append(
 		i.appPacketIPTableContext, chain,
-		"-d", "0.0.0.0/0",
+		"-d", i.allNetworks,
 		"-p", "tcp", "-m", "state", "--state", "ESTABLISHED",
 		"-j", "ACCEPT"); err != nil {
 
@@ -568,7 +679,7 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 	if err := i.ipt.Append(
 		i.appPacketIPTableContext,
 		chain,
-		"-d", "0.0.0.0/0",
+		"-d", i.allNetworks,
 		"-m", "state", "--state", "NEW",
 		"-j", "NFLOG", "--nflog-group", "10",
 		"--nflog-prefix", policy.DefaultLogPrefix(contextID),
@@ -579,7 +690,7 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 	// Drop everything else
 	if err := i.ipt.Append(
 		i.appPacketIPTableContext, chain,
-		"-d", "0.0.0.0/0",
+		"-d", i.allNetworks,
 		"-j", "DROP"); err != nil {
 
 		return fmt.Errorf("unable to add default drop acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
@@ -590,16 +701,32 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 
 // addNetACLs adds iptables rules that manage traffic from external services. The
 // explicit rules are added with the highest priority since they are direct allows.
-func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList) error {
+// When the conflict mode or the priorities order the rules, the rules are
+// programmed in the order in which they are evaluated.
+func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList, mode policy.ConflictMode) error {
+
+	w := newACLWriter(i.ipt, i.netPacketIPTableContext, chain, mode, rules.HasPriorities())
+	if w.ordered {
+		rules = rules.Sort(mode)
+	}
 
 	for loop := 0; loop < 3; loop++ {
 
+		// The ordered ACLs are programmed in a single pass
+		if w.ordered && loop > 0 {
+			break
+		}
+
 		for _, rule := range rules {
 
+			if !i.isFamilyAddress(rule.Address) {
+				continue
+			}
+
 			observeContinue := rule.Policy.ObserveAction.ObserveContinue()
 			switch loop {
 			case 0:
-				if !observeContinue {
+				if !observeContinue && !w.ordered {
 					continue
 				}
 			case 1:
@@ -612,6 +739,8 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 				}
 			}
 
+			w.next()
+
 			proto := strings.ToLower(rule.Protocol)
 
 			if proto == "udp" || proto == "tcp" {
@@ -620,9 +749,7 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 				case policy.Accept:
 
 					if rule.Policy.Action&policy.Log > 0 || observeContinue {
-						if err := i.ipt.Append(
-							i.netPacketIPTableContext,
-							chain,
+						if err := w.append(
 							"-p", rule.Protocol,
 							"-s", rule.Address,
 							"--dport", rule.Port,
@@ -636,8 +763,7 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 					}
 
 					if observeContinue {
-						if err := i.ipt.Append(
-							i.netPacketIPTableContext, chain,
+						if err := w.append(
 							"-p", rule.Protocol,
 							"-s", rule.Address,
 							"--dport", rule.Port,
@@ -647,8 +773,7 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 							return fmt.Errorf("unable to add net acl rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
 						}
 					} else {
-						if err := i.ipt.Append(
-							i.netPacketIPTableContext, chain,
+						if err := w.append(
 							"-p", rule.Protocol,
 							"-s", rule.Address,
 							"--dport", rule.Port,
@@ -660,8 +785,7 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 
 				case policy.Reject:
 					if observeContinue {
-						if err := i.ipt.Insert(
-							i.netPacketIPTableContext, chain, 1,
+						if err := w.insert(
 							"-p", rule.Protocol,
 							"-s", rule.Address,
 							"--dport", rule.Port,
@@ -671,8 +795,7 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 							return fmt.Errorf("unable to add net acl rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
 						}
 					} else {
-						if err := i.ipt.Insert(
-							i.netPacketIPTableContext, chain, 1,
+						if err := w.insert(
 							"-p", rule.Protocol,
 							"-s", rule.Address,
 							"--dport", rule.Port,
@@ -683,10 +806,7 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 					}
 
 					if rule.Policy.Action&policy.Log > 0 || observeContinue {
-						if err := i.ipt.Insert(
-							i.netPacketIPTableContext,
-							chain,
-							1,
+						if err := w.insert(
 							"-p", rule.Protocol,
 							"-s", rule.Address,
 							"--dport", rule.Port,
@@ -708,9 +828,7 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 				switch rule.Policy.Action & (policy.Accept | policy.Reject) {
 				case policy.Accept:
 					if rule.Policy.Action&policy.Log > 0 || observeContinue {
-						if err := i.ipt.Append(
-							i.netPacketIPTableContext,
-							chain,
+						if err := w.append(
 							"-p", rule.Protocol,
 							"-s", rule.Address,
 							"-m", "mark", "!", "--mark", observeMark,
@@ -723,8 +841,7 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 					}
 
 					if observeContinue {
-						if err := i.ipt.Append(
-							i.netPacketIPTableContext, chain,
+						if err := w.append(
 							"-p", rule.Protocol,
 							"-s", rule.Address,
 							"-m", "mark", "!", "--mark", observeMark,
@@ -733,8 +850,7 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 							return fmt.Errorf("unable to add net acl rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
 						}
 					} else {
-						if err := i.ipt.Append(
-							i.netPacketIPTableContext, chain,
+						if err := w.append(
 							"-p", rule.Protocol,
 							"-s", rule.Address,
 							"-j", "ACCEPT",
@@ -745,8 +861,7 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 
 				case policy.Reject:
 					if observeContinue {
-						if err := i.ipt.Insert(
-							i.netPacketIPTableContext, chain, 1,
+						if err := w.insert(
 							"-p", rule.Protocol,
 							"-s", rule.Address,
 							"-m", "mark", "!", "--mark", observeMark,
@@ -755,8 +870,7 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 							return fmt.Errorf("unable to add net acl rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
 						}
 					} else {
-						if err := i.ipt.Insert(
-							i.netPacketIPTableContext, chain, 1,
+						if err := w.insert(
 							"-p", rule.Protocol,
 							"-s", rule.Address,
 							"-j", "DROP",
@@ -766,10 +880,7 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 					}
 
 					if rule.Policy.Action&policy.Log > 0 || observeContinue {
-						if err := i.ipt.Insert(
-							i.netPacketIPTableContext,
-							chain,
-							1,
+						if err := w.insert(
 							"-p", rule.Protocol,
 							"-s", rule.Address,
 							"-m", "mark", "!", "--mark", observeMark,
@@ -787,10 +898,14 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 		}
 	}
 
+	if err := w.flush(); err != nil {
+		return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
+	}
+
 	// Accept established connections
 	if err := i.ipt.Append(
 		i.netPacketIPTableContext, chain,
-		"-s", "0.0.0.0/0",
+		"-s", i.allNetworks,
 		"-p", "tcp", "-m", "state", "--state", "ESTABLISHED",
 		"-j", "ACCEPT",
 	); err != nil {
@@ -800,7 +915,7 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 
 	if err := i.ipt.Append(
 		i.netPacketIPTableContext, chain,
-		"-s", "0.0.0.0/0",
+		"-s", i.allNetworks,
 		"-p", "udp", "-m", "state", "--state", "ESTABLISHED",
 		"-j", "ACCEPT",
 	); err != nil {
@@ -812,7 +927,7 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 	if err := i.ipt.Append(
 		i.netPacketIPTableContext,
 		chain,
-		"-s", "0.0.0.0/0",
+		"-s", i.allNetworks,
 		"-m", "state", "--state", "NEW",
 		"-j", "NFLOG", "--nflog-group", "11",
 		"--nflog-prefix", policy.DefaultLogPrefix(contextID),
@@ -823,7 +938,7 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 	// Drop everything else
 	if err := i.ipt.Append(
 		i.netPacketIPTableContext, chain,
-		"-s", "0.0.0.0/0",
+		"-s", i.allNetworks,
 		"-j", "DROP",
 	); err != nil {
 
@@ -900,7 +1015,7 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 	err = i.ipt.Insert(
 		i.appPacketIPTableContext,
 		appChain, 1,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", i.targetSetName, "dst",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetApplicationQueueSynAckStr())
 	if err != nil {
@@ -910,7 +1025,7 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 	err = i.ipt.Insert(
 		i.appPacketIPTableContext,
 		appChain, 1,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", i.targetSetName, "dst",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "MARK", "--set-mark", strconv.Itoa(cgnetcls.Initialmarkval-1))
 	if err != nil {
@@ -940,7 +1055,7 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 	err = i.ipt.Insert(
 		i.netPacketIPTableContext,
 		netChain, 1,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
+		"-m", "set", "--match-set", i.targetSetName, "src",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN", "--tcp-option",
 		"34", "-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetNetworkQueueSynStr())
 
@@ -951,7 +1066,7 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 	err = i.ipt.Insert(
 		i.netPacketIPTableContext,
 		netChain, 1,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
+		"-m", "set", "--match-set", i.targetSetName, "src",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetNetworkQueueSynAckStr())
 
@@ -968,6 +1083,18 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 		return fmt.Errorf("unable to add capture synack rule for table %s, chain %s: %s", i.appPacketIPTableContext, i.appPacketIPTableSection, err)
 	}
 
+	if i.fqc.IsFastPathEnabled() {
+		err = i.ipt.Insert(
+			i.netPacketIPTableContext,
+			netChain, 1,
+			"-m", "mark", "--mark", strconv.Itoa(i.fqc.GetMarkValue()),
+			"-m", "state", "--state", "ESTABLISHED",
+			"-j", "ACCEPT")
+		if err != nil {
+			return fmt.Errorf("unable to add fast path accept rule for table %s, chain %s: %s", i.netPacketIPTableContext, netChain, err)
+		}
+	}
+
 	err = i.ipt.Insert(i.appProxyIPTableContext,
 		ipTableSectionPreRouting, 1,
 		"-j", natProxyInputChain)
@@ -1044,7 +1171,7 @@ func (i *Instance) CleanGlobalRules() error {
 	if err := i.ipt.Delete(
 		i.appPacketIPTableContext,
 		i.appPacketIPTableSection,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", i.targetSetName, "dst",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetApplicationQueueAckStr()); err != nil {
 		zap.L().Debug("Can not clear the SynAck packet capcture app chain", zap.Error(err))
@@ -1053,7 +1180,7 @@ func (i *Instance) CleanGlobalRules() error {
 	if err := i.ipt.Delete(
 		i.netPacketIPTableContext,
 		i.netPacketIPTableSection,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
+		"-m", "set", "--match-set", i.targetSetName, "src",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetNetworkQueueAckStr()); err != nil {
 		zap.L().Debug("Can not clear the SynAck packet capcture net chain", zap.Error(err))
@@ -285,7 +285,7 @@ func TestAddAppACLs(t *testing.T) {
 				return errors.New("error")
 			})
 
-			err := i.addAppACLs("", "chain", policy.IPRuleList{})
+			err := i.addAppACLs("", "chain", policy.IPRuleList{}, policy.DenyOverrides)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -299,7 +299,7 @@ func TestAddAppACLs(t *testing.T) {
 				return nil
 			})
 
-			err := i.addAppACLs("", "chain", policy.IPRuleList{})
+			err := i.addAppACLs("", "chain", policy.IPRuleList{}, policy.DenyOverrides)
 			Convey("I should get  error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -335,7 +335,7 @@ func TestAddAppACLs(t *testing.T) {
 				}
 				return fmt.Errorf("error %s", rulespec)
 			})
-			err := i.addAppACLs("chain", "", rules)
+			err := i.addAppACLs("chain", "", rules, policy.DenyOverrides)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -368,7 +368,7 @@ func TestAddAppACLs(t *testing.T) {
 				}
 				return fmt.Errorf("error %s", rulespec)
 			})
-			err := i.addAppACLs("chain", "", rules)
+			err := i.addAppACLs("chain", "", rules, policy.DenyOverrides)
 			Convey("I should get no error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -401,7 +401,7 @@ func TestAddAppACLs(t *testing.T) {
 				}
 				return fmt.Errorf("error %s", rulespec)
 			})
-			err := i.addAppACLs("chain", "", rules)
+			err := i.addAppACLs("chain", "", rules, policy.DenyOverrides)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -433,7 +433,7 @@ func TestAddNetAcls(t *testing.T) {
 				return errors.New("error")
 			})
 
-			err := i.addNetACLs("", "chain", policy.IPRuleList{})
+			err := i.addNetACLs("", "chain", policy.IPRuleList{}, policy.DenyOverrides)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -447,7 +447,7 @@ func TestAddNetAcls(t *testing.T) {
 				return nil
 			})
 
-			err := i.addNetACLs("", "chain", policy.IPRuleList{})
+			err := i.addNetACLs("", "chain", policy.IPRuleList{}, policy.DenyOverrides)
 			Convey("I should get  error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -486,7 +486,7 @@ func TestAddNetAcls(t *testing.T) {
 				}
 				return fmt.Errorf("error %s", rulespec)
 			})
-			err := i.addNetACLs("chain", "", rules)
+			err := i.addNetACLs("chain", "", rules, policy.DenyOverrides)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -522,7 +522,7 @@ func TestAddNetAcls(t *testing.T) {
 				}
 				return fmt.Errorf("error %s", rulespec)
 			})
-			err := i.addNetACLs("chain", "", rules)
+			err := i.addNetACLs("chain", "", rules, policy.DenyOverrides)
 			Convey("I should get no error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -558,7 +558,7 @@ func TestAddNetAcls(t *testing.T) {
 				}
 				return fmt.Errorf("error %s", rulespec)
 			})
-			err := i.addNetACLs("chain", "", rules)
+			err := i.addNetACLs("chain", "", rules, policy.DenyOverrides)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -900,3 +900,184 @@ func TestUpdateTargetNetworks(t *testing.T) {
 		})
 	})
 }
+
+func TestIPv6TargetNetworksAndACLs(t *testing.T) {
+	Convey("Given an ipv6 iptables controller,", t, func() {
+		i, _ := NewIPv6Instance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+		ipsets := provider.NewTestIpsetProvider()
+		i.ipset = ipsets
+
+		Convey("When I set mixed target networks, only the ipv6 networks should be added to an inet6 set", func() {
+
+			added := []string{}
+			ipsets.MockNewIpset(t, func(name string, hasht string, p *ipset.Params) (provider.Ipset, error) {
+				if name != targetNetworkSetIPv6 || p.HashFamily != "inet6" {
+					return nil, errors.New("wrong set")
+				}
+				testset := provider.NewTestIpset()
+				testset.MockAdd(t, func(entry string, timeout int) error {
+					added = append(added, entry)
+					return nil
+				})
+				return testset, nil
+			})
+
+			matches := []string{}
+			iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+				if matchSpec("--match-set", rulespec) == nil {
+					matches = append(matches, rulespec...)
+				}
+				return nil
+			})
+
+			err := i.SetTargetNetworks([]string{}, []string{"10.1.1.0/24", "2001:db8::/32"})
+			So(err, ShouldBeNil)
+			So(added, ShouldResemble, []string{"2001:db8::/32"})
+			So(matchSpec(targetNetworkSetIPv6, matches), ShouldBeNil)
+			So(matchSpec(targetNetworkSet, matches), ShouldNotBeNil)
+		})
+
+		Convey("When I set no target networks, all the ipv6 networks should be captured", func() {
+
+			added := []string{}
+			ipsets.MockNewIpset(t, func(name string, hasht string, p *ipset.Params) (provider.Ipset, error) {
+				testset := provider.NewTestIpset()
+				testset.MockAdd(t, func(entry string, timeout int) error {
+					added = append(added, entry)
+					return nil
+				})
+				return testset, nil
+			})
+			iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+				return nil
+			})
+
+			err := i.SetTargetNetworks([]string{}, []string{})
+			So(err, ShouldBeNil)
+			So(added, ShouldResemble, []string{"::/1", "8000::/1"})
+		})
+
+		Convey("When I add app ACLs with ipv4 and ipv6 rules, only the ipv6 rules should be programmed", func() {
+
+			rules := policy.IPRuleList{
+				policy.IPRule{
+					Address:  "192.30.253.0/24",
+					Port:     "80",
+					Protocol: "TCP",
+					Policy:   &policy.FlowPolicy{Action: policy.Accept | policy.Log},
+				},
+				policy.IPRule{
+					Address:  "2001:db8::/32",
+					Port:     "443",
+					Protocol: "TCP",
+					Policy:   &policy.FlowPolicy{Action: policy.Accept | policy.Log},
+				},
+			}
+
+			iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+				if matchSpec("192.30.253.0/24", rulespec) == nil || matchSpec("0.0.0.0/0", rulespec) == nil {
+					return errors.New("ipv4 address in ipv6 chain")
+				}
+				return nil
+			})
+
+			err := i.addAppACLs("", "chain", rules, policy.DenyOverrides)
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+func TestAddACLsConflictModes(t *testing.T) {
+
+	Convey("Given an iptables controller with overlapping ACLs", t, func() {
+
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, portset.New(nil))
+		recorder := newRuleRecorder()
+		i.ipt = recorder
+
+		acl := func(address string, action policy.ActionType, priority int) policy.IPRule {
+			return policy.IPRule{
+				Address:  address,
+				Port:     "80",
+				Protocol: "tcp",
+				Policy:   &policy.FlowPolicy{Action: action},
+				Priority: priority,
+			}
+		}
+
+		rules := policy.IPRuleList{
+			acl("10.0.0.0/8", policy.Accept, 0),
+			acl("10.1.0.0/16", policy.Reject, 0),
+			acl("10.1.1.0/24", policy.Accept, 5),
+		}
+
+		// programmed returns the targets and the addresses of the ACLs of
+		// a chain in the order of the chain
+		programmed := func(table, chain string) []string {
+			acls := []string{}
+			for _, rule := range recorder.rules(table, chain) {
+				if matchSpec("--dport", rule) != nil {
+					continue
+				}
+				var address, target string
+				for idx := range rule[:len(rule)-1] {
+					switch rule[idx] {
+					case "-d", "-s":
+						address = rule[idx+1]
+					case "-j":
+						target = rule[idx+1]
+					}
+				}
+				acls = append(acls, target+" "+address)
+			}
+			return acls
+		}
+
+		So(recorder.NewChain(i.appPacketIPTableContext, "app"), ShouldBeNil)
+		So(recorder.NewChain(i.netPacketIPTableContext, "net"), ShouldBeNil)
+
+		Convey("When I add the ACLs in the deny overrides mode without priorities", func() {
+			rules[2].Priority = 0
+			So(i.addAppACLs("", "app", rules, policy.DenyOverrides), ShouldBeNil)
+
+			Convey("Then the reject ACLs should be at the top and the accept ACLs in their order", func() {
+				So(programmed(i.appPacketIPTableContext, "app"), ShouldResemble, []string{
+					"DROP 10.1.0.0/16", "ACCEPT 10.0.0.0/8", "ACCEPT 10.1.1.0/24",
+				})
+			})
+		})
+
+		Convey("When I add the ACLs in the deny overrides mode with priorities", func() {
+			So(i.addAppACLs("", "app", rules, policy.DenyOverrides), ShouldBeNil)
+
+			Convey("Then the accept ACLs should be ordered by priority", func() {
+				So(programmed(i.appPacketIPTableContext, "app"), ShouldResemble, []string{
+					"DROP 10.1.0.0/16", "ACCEPT 10.1.1.0/24", "ACCEPT 10.0.0.0/8",
+				})
+			})
+		})
+
+		Convey("When I add the ACLs in the first match mode", func() {
+			So(i.addNetACLs("", "net", rules, policy.FirstMatch), ShouldBeNil)
+
+			Convey("Then the ACLs should be programmed by priority and then in order", func() {
+				So(programmed(i.netPacketIPTableContext, "net"), ShouldResemble, []string{
+					"ACCEPT 10.1.1.0/24", "ACCEPT 10.0.0.0/8", "DROP 10.1.0.0/16",
+				})
+			})
+		})
+
+		Convey("When I add logged ACLs in the most specific mode", func() {
+			rules[1].Policy.Action |= policy.Log
+			So(i.addAppACLs("", "app", rules, policy.MostSpecific), ShouldBeNil)
+
+			Convey("Then the ACLs should be programmed from the most specific with the logs before the drops", func() {
+				So(programmed(i.appPacketIPTableContext, "app"), ShouldResemble, []string{
+					"ACCEPT 10.1.1.0/24", "NFLOG 10.1.0.0/16", "DROP 10.1.0.0/16", "ACCEPT 10.0.0.0/8",
+				})
+			})
+		})
+	})
+}
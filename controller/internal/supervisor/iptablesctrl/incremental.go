@@ -0,0 +1,363 @@
+package iptablesctrl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aporeto-inc/trireme-lib/common"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"go.uber.org/zap"
+)
+
+// ruleInsert is a rule inserted at a position of a chain
+type ruleInsert struct {
+	pos  int
+	rule []string
+}
+
+// ruleDelta is the difference between the rules of two versions of a chain.
+// The insertions are applied first, in order, and then the deletions.
+type ruleDelta struct {
+	inserts []ruleInsert
+	deletes [][]string
+}
+
+// empty returns true if the chain does not change
+func (d *ruleDelta) empty() bool {
+	return len(d.inserts) == 0 && len(d.deletes) == 0
+}
+
+// structuralChange returns true if the update of a PU requires new chains.
+// The rules that send the traffic to the chains of the PU depend on the
+// options of the runtime, so any change of these options requires the
+// version flip. The ACLs, the excluded networks and the proxy sets can be
+// updated in place.
+func structuralChange(containerInfo, oldContainerInfo *policy.PUInfo) bool {
+
+	if oldContainerInfo == nil || oldContainerInfo.Policy == nil || oldContainerInfo.Runtime == nil || containerInfo.Runtime == nil {
+		return true
+	}
+
+	old := oldContainerInfo.Runtime.Options()
+	new := containerInfo.Runtime.Options()
+
+	return old.ProxyPort != new.ProxyPort ||
+		old.CgroupMark != new.CgroupMark ||
+		old.UserID != new.UserID ||
+		common.ConvertServicesToPortList(old.Services) != common.ConvertServicesToPortList(new.Services)
+}
+
+// updateRulesInPlace updates the chains of the previous version of the PU
+// with the difference between the old and the new policy and renames them
+// to the chains of the new version. It returns an error without touching the
+// chains if the rules that are kept do not keep their order, in which case
+// the chains must be rebuilt.
+func (i *Instance) updateRulesInPlace(version int, contextID string, containerInfo *policy.PUInfo, oldContainerInfo *policy.PUInfo) error {
+
+	appChain, netChain, err := i.chainName(contextID, version)
+	if err != nil {
+		return err
+	}
+
+	oldAppChain, oldNetChain, err := i.chainName(contextID, version^1)
+	if err != nil {
+		return err
+	}
+
+	oldApp, oldNet, err := i.chainContents(contextID, oldAppChain, oldNetChain, oldContainerInfo)
+	if err != nil {
+		return err
+	}
+
+	newApp, newNet, err := i.chainContents(contextID, oldAppChain, oldNetChain, containerInfo)
+	if err != nil {
+		return err
+	}
+
+	appDelta, err := diffRules(oldApp, newApp)
+	if err != nil {
+		return fmt.Errorf("chain %s: %s", oldAppChain, err)
+	}
+
+	netDelta, err := diffRules(oldNet, newNet)
+	if err != nil {
+		return fmt.Errorf("chain %s: %s", oldNetChain, err)
+	}
+
+	if err := i.updateProxySetDelta(oldContainerInfo.Policy, containerInfo.Policy, puPortSetName(contextID, proxyPortSetPrefix)); err != nil {
+		return err
+	}
+
+	if err := i.applyDelta(i.appPacketIPTableContext, oldAppChain, appDelta); err != nil {
+		return err
+	}
+
+	if err := i.applyDelta(i.netPacketIPTableContext, oldNetChain, netDelta); err != nil {
+		return err
+	}
+
+	zap.L().Debug("Updated the rules in place",
+		zap.String("contextID", contextID),
+		zap.Int("appInserts", len(appDelta.inserts)),
+		zap.Int("appDeletes", len(appDelta.deletes)),
+		zap.Int("netInserts", len(netDelta.inserts)),
+		zap.Int("netDeletes", len(netDelta.deletes)),
+	)
+
+	return i.renameContainerChains(oldAppChain, oldNetChain, appChain, netChain)
+}
+
+// chainContents returns the rules that installRules programs in the app and
+// net chains of a PU, in the order of the chains. The rules are computed by
+// the install functions with an in memory provider.
+func (i *Instance) chainContents(contextID, appChain, netChain string, containerInfo *policy.PUInfo) (app [][]string, net [][]string, err error) {
+
+	recorder := newRuleRecorder()
+
+	r := *i
+	r.ipt = recorder
+
+	policyrules := containerInfo.Policy
+
+	if err := r.addContainerChain(appChain, netChain); err != nil {
+		return nil, nil, err
+	}
+
+	if err := r.addPacketTrap(appChain, netChain, policyrules.TriremeNetworks()); err != nil {
+		return nil, nil, err
+	}
+
+	if err := r.addAppACLs(contextID, appChain, policyrules.ApplicationACLs(), policyrules.ConflictMode()); err != nil {
+		return nil, nil, err
+	}
+
+	if err := r.addNetACLs(contextID, netChain, policyrules.NetworkACLs(), policyrules.ConflictMode()); err != nil {
+		return nil, nil, err
+	}
+
+	if err := r.addExclusionACLs(appChain, netChain, i.filterAddresses(policyrules.ExcludedNetworks())); err != nil {
+		return nil, nil, err
+	}
+
+	return recorder.rules(i.appPacketIPTableContext, appChain), recorder.rules(i.netPacketIPTableContext, netChain), nil
+}
+
+// applyDelta applies the difference of the rules of a chain
+func (i *Instance) applyDelta(table, chain string, delta *ruleDelta) error {
+
+	for _, insert := range delta.inserts {
+		if err := i.ipt.Insert(table, chain, insert.pos, insert.rule...); err != nil {
+			return fmt.Errorf("unable to insert rule for table %s, chain %s: %s", table, chain, err)
+		}
+	}
+
+	for _, rule := range delta.deletes {
+		if err := i.ipt.Delete(table, chain, rule...); err != nil {
+			return fmt.Errorf("unable to delete rule for table %s, chain %s: %s", table, chain, err)
+		}
+	}
+
+	return nil
+}
+
+// renameContainerChains renames the chains of a PU. The rules that send the
+// traffic to the chains follow them.
+func (i *Instance) renameContainerChains(oldAppChain, oldNetChain, appChain, netChain string) error {
+
+	if err := i.ipt.RenameChain(i.appPacketIPTableContext, oldAppChain, appChain); err != nil {
+		return fmt.Errorf("unable to rename chain %s of context %s: %s", oldAppChain, i.appPacketIPTableContext, err)
+	}
+
+	if err := i.ipt.RenameChain(i.netPacketIPTableContext, oldNetChain, netChain); err != nil {
+		if rerr := i.ipt.RenameChain(i.appPacketIPTableContext, appChain, oldAppChain); rerr != nil {
+			zap.L().Warn("Failed to restore the name of the app chain", zap.String("appChain", oldAppChain), zap.Error(rerr))
+		}
+		return fmt.Errorf("unable to rename chain %s of context %s: %s", oldNetChain, i.netPacketIPTableContext, err)
+	}
+
+	return nil
+}
+
+// diffRules returns the insertions and deletions that change the rules of a
+// chain from old to new. The rules that are in both versions must have the
+// same order and the rules must be unique, otherwise the chain cannot be
+// updated in place.
+func diffRules(old, new [][]string) (*ruleDelta, error) {
+
+	oldIndex := map[string]int{}
+	for idx, rule := range old {
+		key := ruleKey(rule)
+		if _, ok := oldIndex[key]; ok {
+			return nil, fmt.Errorf("duplicate rule %s", strings.Join(rule, " "))
+		}
+		oldIndex[key] = idx
+	}
+
+	delta := &ruleDelta{}
+	seen := map[string]bool{}
+	next := 0
+	pos := 0
+
+	for _, rule := range new {
+		key := ruleKey(rule)
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate rule %s", strings.Join(rule, " "))
+		}
+		seen[key] = true
+
+		idx, ok := oldIndex[key]
+		if !ok {
+			pos++
+			delta.inserts = append(delta.inserts, ruleInsert{pos: pos, rule: rule})
+			continue
+		}
+
+		if idx < next {
+			return nil, errors.New("rules are reordered")
+		}
+
+		// The old rules before a kept rule are deleted after the insertions
+		for ; next < idx; next++ {
+			pos++
+			delta.deletes = append(delta.deletes, old[next])
+		}
+
+		next++
+		pos++
+	}
+
+	delta.deletes = append(delta.deletes, old[next:]...)
+
+	return delta, nil
+}
+
+// ruleKey returns a key that identifies a rule
+func ruleKey(rule []string) string {
+	return strings.Join(rule, "\x00")
+}
+
+// ruleRecorder is an iptables provider that keeps the chains in memory. It is
+// used to compute the rules that the install functions program in a chain.
+type ruleRecorder struct {
+	chains map[string][][]string
+}
+
+// newRuleRecorder returns an empty recorder
+func newRuleRecorder() *ruleRecorder {
+	return &ruleRecorder{
+		chains: map[string][][]string{},
+	}
+}
+
+// rules returns the rules of a chain
+func (r *ruleRecorder) rules(table, chain string) [][]string {
+	return r.chains[table+"/"+chain]
+}
+
+// Append implements the IptablesProvider interface
+func (r *ruleRecorder) Append(table, chain string, rulespec ...string) error {
+
+	key := table + "/" + chain
+	if _, ok := r.chains[key]; !ok {
+		return fmt.Errorf("chain %s does not exist", chain)
+	}
+
+	r.chains[key] = append(r.chains[key], append([]string{}, rulespec...))
+
+	return nil
+}
+
+// Insert implements the IptablesProvider interface
+func (r *ruleRecorder) Insert(table, chain string, pos int, rulespec ...string) error {
+
+	key := table + "/" + chain
+	rules, ok := r.chains[key]
+	if !ok {
+		return fmt.Errorf("chain %s does not exist", chain)
+	}
+
+	if pos < 1 || pos > len(rules)+1 {
+		return fmt.Errorf("invalid position %d in chain %s", pos, chain)
+	}
+
+	rules = append(rules, nil)
+	copy(rules[pos:], rules[pos-1:])
+	rules[pos-1] = append([]string{}, rulespec...)
+	r.chains[key] = rules
+
+	return nil
+}
+
+// Delete implements the IptablesProvider interface
+func (r *ruleRecorder) Delete(table, chain string, rulespec ...string) error {
+
+	key := table + "/" + chain
+	rules := r.chains[key]
+
+	spec := ruleKey(rulespec)
+	for idx, rule := range rules {
+		if ruleKey(rule) == spec {
+			r.chains[key] = append(rules[:idx], rules[idx+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("rule not found in chain %s", chain)
+}
+
+// ListChains implements the IptablesProvider interface
+func (r *ruleRecorder) ListChains(table string) ([]string, error) {
+
+	chains := []string{}
+	for key := range r.chains {
+		if strings.HasPrefix(key, table+"/") {
+			chains = append(chains, strings.TrimPrefix(key, table+"/"))
+		}
+	}
+
+	return chains, nil
+}
+
+// ClearChain implements the IptablesProvider interface
+func (r *ruleRecorder) ClearChain(table, chain string) error {
+
+	r.chains[table+"/"+chain] = [][]string{}
+
+	return nil
+}
+
+// DeleteChain implements the IptablesProvider interface
+func (r *ruleRecorder) DeleteChain(table, chain string) error {
+
+	delete(r.chains, table+"/"+chain)
+
+	return nil
+}
+
+// NewChain implements the IptablesProvider interface
+func (r *ruleRecorder) NewChain(table, chain string) error {
+
+	key := table + "/" + chain
+	if _, ok := r.chains[key]; ok {
+		return fmt.Errorf("chain %s already exists", chain)
+	}
+
+	r.chains[key] = [][]string{}
+
+	return nil
+}
+
+// RenameChain implements the IptablesProvider interface
+func (r *ruleRecorder) RenameChain(table, oldChain, newChain string) error {
+
+	rules, ok := r.chains[table+"/"+oldChain]
+	if !ok {
+		return fmt.Errorf("chain %s does not exist", oldChain)
+	}
+
+	delete(r.chains, table+"/"+oldChain)
+	r.chains[table+"/"+newChain] = rules
+
+	return nil
+}
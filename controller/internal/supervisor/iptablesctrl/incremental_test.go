@@ -0,0 +1,291 @@
+package iptablesctrl
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/common"
+	"github.com/aporeto-inc/trireme-lib/controller/constants"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/portset"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/supervisor/provider"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testPUInfo returns the PU info of a container with the ACLs and the
+// excluded networks
+func testPUInfo(acls policy.IPRuleList, excluded []string, runtime *policy.PURuntime) *policy.PUInfo {
+
+	ipl := policy.ExtendedMap{}
+	ipl[policy.DefaultNamespace] = "172.17.0.1"
+	policyrules := policy.NewPUPolicy("Context",
+		policy.Police,
+		acls,
+		acls,
+		nil,
+		nil,
+		nil,
+		nil,
+		ipl,
+		[]string{"172.17.0.0/24"},
+		excluded,
+		&policy.ProxiedServicesInfo{},
+		nil,
+		nil,
+		[]string{},
+	)
+
+	containerinfo := policy.NewPUInfo("Context", common.ContainerPU)
+	containerinfo.Policy = policyrules
+	containerinfo.Runtime = runtime
+
+	return containerinfo
+}
+
+// testACL returns a tcp ACL of the network 192.30.253.0/24
+func testACL(port string, action policy.ActionType) policy.IPRule {
+	return policy.IPRule{
+		Address:  "192.30.253.0/24",
+		Port:     port,
+		Protocol: "tcp",
+		Policy:   &policy.FlowPolicy{Action: action, PolicyID: port},
+	}
+}
+
+// mockRecorder makes the test provider program the chains of the recorder.
+// The rules that send the traffic to the chains are not recorded.
+func mockRecorder(t *testing.T, iptables provider.TestIptablesProvider, recorder *ruleRecorder) {
+
+	recorded := func(table, chain string) bool {
+		_, ok := recorder.chains[table+"/"+chain]
+		return ok
+	}
+
+	iptables.MockAppend(t, func(table, chain string, rulespec ...string) error {
+		if !recorded(table, chain) {
+			return nil
+		}
+		return recorder.Append(table, chain, rulespec...)
+	})
+	iptables.MockInsert(t, func(table, chain string, pos int, rulespec ...string) error {
+		if !recorded(table, chain) {
+			return nil
+		}
+		return recorder.Insert(table, chain, pos, rulespec...)
+	})
+	iptables.MockDelete(t, func(table, chain string, rulespec ...string) error {
+		if !recorded(table, chain) {
+			return nil
+		}
+		return recorder.Delete(table, chain, rulespec...)
+	})
+	iptables.MockNewChain(t, recorder.NewChain)
+	iptables.MockClearChain(t, recorder.ClearChain)
+	iptables.MockDeleteChain(t, recorder.DeleteChain)
+	iptables.MockRenameChain(t, recorder.RenameChain)
+}
+
+func TestUpdateRulesInPlace(t *testing.T) {
+
+	Convey("Given an iptables controller with the rules of a PU", t, func() {
+
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+
+		recorder := newRuleRecorder()
+		mockRecorder(t, iptables, recorder)
+
+		runtime := policy.NewPURuntimeWithDefaults()
+		old := testPUInfo(policy.IPRuleList{
+			testACL("80", policy.Accept),
+			testACL("443", policy.Accept),
+			testACL("22", policy.Reject|policy.Log),
+		}, []string{"10.1.0.0/16"}, runtime)
+
+		app0, net0, _ := i.chainName("Context", 0)
+		app1, net1, _ := i.chainName("Context", 1)
+
+		So(i.installRules("Context", app0, net0, "", old), ShouldBeNil)
+
+		newChains := 0
+		iptables.MockNewChain(t, func(table, chain string) error {
+			newChains++
+			return recorder.NewChain(table, chain)
+		})
+
+		Convey("When I change the ACLs and the excluded networks", func() {
+			updated := testPUInfo(policy.IPRuleList{
+				testACL("443", policy.Accept),
+				testACL("8080", policy.Accept),
+				testACL("22", policy.Reject|policy.Log),
+				testACL("23", policy.Reject),
+			}, []string{"10.2.0.0/16"}, runtime)
+
+			err := i.UpdateRules(1, "Context", updated, old)
+
+			Convey("Then the chains should be updated in place and renamed", func() {
+				So(err, ShouldBeNil)
+				So(newChains, ShouldEqual, 0)
+				So(recorder.chains, ShouldNotContainKey, "mangle/"+app0)
+				So(recorder.chains, ShouldNotContainKey, "mangle/"+net0)
+
+				app, net, err := i.chainContents("Context", app1, net1, updated)
+				So(err, ShouldBeNil)
+				So(recorder.rules("mangle", app1), ShouldResemble, app)
+				So(recorder.rules("mangle", net1), ShouldResemble, net)
+			})
+		})
+
+		Convey("When the policy does not change", func() {
+			inserts := 0
+			iptables.MockInsert(t, func(table, chain string, pos int, rulespec ...string) error {
+				inserts++
+				return recorder.Insert(table, chain, pos, rulespec...)
+			})
+
+			err := i.UpdateRules(1, "Context", old, old)
+
+			Convey("Then the chains should only be renamed", func() {
+				So(err, ShouldBeNil)
+				So(inserts, ShouldEqual, 0)
+				So(newChains, ShouldEqual, 0)
+				So(recorder.chains, ShouldContainKey, "mangle/"+app1)
+				So(recorder.chains, ShouldContainKey, "mangle/"+net1)
+			})
+		})
+
+		Convey("When the order of the ACLs changes", func() {
+			updated := testPUInfo(policy.IPRuleList{
+				testACL("443", policy.Accept),
+				testACL("80", policy.Accept),
+				testACL("22", policy.Reject|policy.Log),
+			}, []string{"10.1.0.0/16"}, runtime)
+
+			err := i.UpdateRules(1, "Context", updated, old)
+
+			Convey("Then the chains should be rebuilt", func() {
+				So(err, ShouldBeNil)
+				So(newChains, ShouldEqual, 2)
+				So(recorder.chains, ShouldNotContainKey, "mangle/"+app0)
+
+				app, net, err := i.chainContents("Context", app1, net1, updated)
+				So(err, ShouldBeNil)
+				So(recorder.rules("mangle", app1), ShouldResemble, app)
+				So(recorder.rules("mangle", net1), ShouldResemble, net)
+			})
+		})
+
+		Convey("When the options of the runtime change", func() {
+			options := runtime.Options()
+			options.ProxyPort = "5001"
+			newRuntime := policy.NewPURuntimeWithDefaults()
+			newRuntime.SetOptions(options)
+
+			updated := testPUInfo(policy.IPRuleList{testACL("80", policy.Accept)}, nil, newRuntime)
+
+			err := i.UpdateRules(1, "Context", updated, old)
+
+			Convey("Then the chains should be rebuilt", func() {
+				So(err, ShouldBeNil)
+				So(newChains, ShouldEqual, 2)
+				So(recorder.chains, ShouldNotContainKey, "mangle/"+app0)
+				So(recorder.chains, ShouldContainKey, "mangle/"+app1)
+			})
+		})
+
+		Convey("When the chains cannot be renamed", func() {
+			iptables.MockRenameChain(t, func(table, oldChain, newChain string) error {
+				if oldChain == net0 {
+					return errors.New("error")
+				}
+				return recorder.RenameChain(table, oldChain, newChain)
+			})
+
+			updated := testPUInfo(policy.IPRuleList{testACL("8080", policy.Accept)}, nil, runtime)
+
+			err := i.UpdateRules(1, "Context", updated, old)
+
+			Convey("Then the chains should be rebuilt", func() {
+				So(err, ShouldBeNil)
+				So(newChains, ShouldEqual, 2)
+				So(recorder.chains, ShouldNotContainKey, "mangle/"+app0)
+				So(recorder.chains, ShouldNotContainKey, "mangle/"+net0)
+
+				app, net, err := i.chainContents("Context", app1, net1, updated)
+				So(err, ShouldBeNil)
+				So(recorder.rules("mangle", app1), ShouldResemble, app)
+				So(recorder.rules("mangle", net1), ShouldResemble, net)
+			})
+		})
+	})
+}
+
+func TestDiffRules(t *testing.T) {
+
+	Convey("Given the rules of a chain", t, func() {
+
+		a, b, c, d := []string{"a"}, []string{"b"}, []string{"c"}, []string{"d"}
+
+		Convey("Then the delta should insert the new rules at their position and delete the old rules", func() {
+			delta, err := diffRules([][]string{a, b, c}, [][]string{d, a, c})
+			So(err, ShouldBeNil)
+			So(delta.inserts, ShouldResemble, []ruleInsert{{pos: 1, rule: d}})
+			So(delta.deletes, ShouldResemble, [][]string{b})
+
+			delta, err = diffRules([][]string{a, b, c}, [][]string{b, d})
+			So(err, ShouldBeNil)
+			So(delta.inserts, ShouldResemble, []ruleInsert{{pos: 3, rule: d}})
+			So(delta.deletes, ShouldResemble, [][]string{a, c})
+
+			recorder := newRuleRecorder()
+			So(recorder.NewChain("mangle", "chain"), ShouldBeNil)
+			for _, rule := range [][]string{a, b, c} {
+				So(recorder.Append("mangle", "chain", rule...), ShouldBeNil)
+			}
+
+			i := &Instance{ipt: recorder}
+			So(i.applyDelta("mangle", "chain", delta), ShouldBeNil)
+			So(recorder.rules("mangle", "chain"), ShouldResemble, [][]string{b, d})
+		})
+
+		Convey("Then the rules that change their order should be rejected", func() {
+			_, err := diffRules([][]string{a, b, c}, [][]string{d, b, a, c})
+			So(err, ShouldNotBeNil)
+
+			_, err = diffRules([][]string{a, b, c}, [][]string{b, d, c, a})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then the same rules should have an empty delta", func() {
+			delta, err := diffRules([][]string{a, b}, [][]string{a, b})
+			So(err, ShouldBeNil)
+			So(delta.empty(), ShouldBeTrue)
+		})
+
+		Convey("Then duplicate rules should be rejected", func() {
+			_, err := diffRules([][]string{a, a}, [][]string{a})
+			So(err, ShouldNotBeNil)
+
+			_, err = diffRules([][]string{a}, [][]string{b, b})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestSetDelta(t *testing.T) {
+
+	Convey("Given the entries of a set", t, func() {
+
+		Convey("Then the delta should add the new entries and delete the old ones", func() {
+			add, del := setDelta([]string{"1", "2", "3"}, []string{"3", "4", "4", "1"})
+			So(add, ShouldResemble, []string{"4"})
+			So(del, ShouldResemble, []string{"2"})
+
+			add, del = setDelta([]string{"1"}, []string{"1"})
+			So(add, ShouldBeEmpty)
+			So(del, ShouldBeEmpty)
+		})
+	})
+}
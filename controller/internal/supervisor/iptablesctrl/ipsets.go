@@ -44,9 +44,9 @@ func (i *Instance) updateTargetNetworks(old, new []string) error {
 // createTargetSet creates a new target set
 func (i *Instance) createTargetSet(networks []string) error {
 
-	ips, err := i.ipset.NewIpset(targetNetworkSet, "hash:net", &ipset.Params{})
+	ips, err := i.ipset.NewIpset(i.targetSetName, "hash:net", i.ipsetParams())
 	if err != nil {
-		return fmt.Errorf("unable to create ipset for %s: %s", targetNetworkSet, err)
+		return fmt.Errorf("unable to create ipset for %s: %s", i.targetSetName, err)
 	}
 
 	i.targetSet = ips
@@ -64,12 +64,12 @@ func (i *Instance) createTargetSet(networks []string) error {
 func (i *Instance) createProxySets(portSetName string) error {
 	destSetName, srcSetName, srvSetName := i.getSetNames(portSetName)
 
-	_, err := i.ipset.NewIpset(destSetName, "hash:ip,port", &ipset.Params{})
+	_, err := i.ipset.NewIpset(destSetName, "hash:ip,port", i.ipsetParams())
 	if err != nil {
 		return fmt.Errorf("unable to create ipset for %s: %s", destSetName, err)
 	}
 
-	_, err = i.ipset.NewIpset(srcSetName, "hash:ip,port", &ipset.Params{})
+	_, err = i.ipset.NewIpset(srcSetName, "hash:ip,port", i.ipsetParams())
 	if err != nil {
 		return fmt.Errorf("unable to create ipset for %s: %s", srcSetName, err)
 	}
@@ -85,7 +85,7 @@ func (i *Instance) createProxySets(portSetName string) error {
 
 // createUIDSets creates the UID specific sets
 func (i *Instance) createUIDSets(contextID string, puInfo *policy.PUInfo) error {
-	if puInfo.Runtime.Options().UserID != "" {
+	if puInfo.Runtime.Options().UserID != "" && !i.ipv6 {
 		portSetName := puPortSetName(contextID, PuPortSet)
 
 		if puseterr := i.createPUPortSet(portSetName); puseterr != nil {
@@ -97,8 +97,9 @@ func (i *Instance) createUIDSets(contextID string, puInfo *policy.PUInfo) error
 
 func (i *Instance) updateProxySet(policy *policy.PUPolicy, portSetName string) error {
 
-	services := policy.ProxiedServices()
+	dstEntries, srcEntries, srvEntries := i.proxySetEntries(policy)
 	dstSetName, srcSetName, srvSetName := i.getSetNames(portSetName)
+
 	vipTargetSet := ipset.IPSet{
 		Name: dstSetName,
 	}
@@ -106,23 +107,10 @@ func (i *Instance) updateProxySet(policy *policy.PUPolicy, portSetName string) e
 		zap.L().Warn("Unable to flush the vip proxy set")
 	}
 
-	for _, net := range services.PublicIPPortPair {
-		if err := vipTargetSet.Add(net, 0); err != nil {
+	for _, entry := range dstEntries {
+		if err := vipTargetSet.Add(entry, 0); err != nil {
 			zap.L().Error("Failed to add vip", zap.Error(err))
-			return fmt.Errorf("unable to add public ip %s to target networks ipset: %s", net, err)
-		}
-	}
-
-	for _, dependentService := range policy.DependentServices() {
-		addresses := dependentService.NetworkInfo.Addresses
-		min, max := dependentService.NetworkInfo.Ports.Range()
-		for _, addr := range addresses {
-			for i := int(min); i <= int(max); i++ {
-				pair := addr.IP.To4().String() + "," + strconv.Itoa(i)
-				if err := vipTargetSet.Add(pair, 0); err != nil {
-					return fmt.Errorf("unable to add dependent ip %s to target networks ipset: %s", pair, err)
-				}
-			}
+			return fmt.Errorf("unable to add public ip %s to target networks ipset: %s", entry, err)
 		}
 	}
 
@@ -133,6 +121,13 @@ func (i *Instance) updateProxySet(policy *policy.PUPolicy, portSetName string) e
 		zap.L().Warn("Unable to flush the pip proxy set")
 	}
 
+	for _, entry := range srcEntries {
+		if err := pipTargetSet.Add(entry, 0); err != nil {
+			zap.L().Error("Failed to add vip", zap.Error(err))
+			return fmt.Errorf("unable to add private ip %s to target networks ipset: %s", entry, err)
+		}
+	}
+
 	srvTargetSet := ipset.IPSet{
 		Name: srvSetName,
 	}
@@ -140,39 +135,160 @@ func (i *Instance) updateProxySet(policy *policy.PUPolicy, portSetName string) e
 		zap.L().Warn("Unable to flush the pip proxy set")
 	}
 
+	for _, entry := range srvEntries {
+		if err := srvTargetSet.Add(entry, 0); err != nil {
+			zap.L().Error("Failed to add port to srv target set", zap.Error(err))
+			return fmt.Errorf("unable to add port %s to target ports ipset: %s", entry, err)
+		}
+	}
+
+	return nil
+}
+
+// updateProxySetDelta updates the proxy sets of a PU from the services of
+// the old policy to the services of the new policy. Only the entries that
+// changed are added or removed, so the sets are never flushed.
+func (i *Instance) updateProxySetDelta(old, new *policy.PUPolicy, portSetName string) error {
+
+	oldDst, oldSrc, oldSrv := i.proxySetEntries(old)
+	newDst, newSrc, newSrv := i.proxySetEntries(new)
+	dstSetName, srcSetName, srvSetName := i.getSetNames(portSetName)
+
+	if err := updateSetEntries(dstSetName, oldDst, newDst); err != nil {
+		return err
+	}
+
+	if err := updateSetEntries(srcSetName, oldSrc, newSrc); err != nil {
+		return err
+	}
+
+	return updateSetEntries(srvSetName, oldSrv, newSrv)
+}
+
+// updateSetEntries adds the new entries and removes the old entries of a set
+func updateSetEntries(setName string, old, new []string) error {
+
+	add, del := setDelta(old, new)
+	if len(add) == 0 && len(del) == 0 {
+		return nil
+	}
+
+	set := ipset.IPSet{
+		Name: setName,
+	}
+
+	for _, entry := range add {
+		if err := set.Add(entry, 0); err != nil {
+			return fmt.Errorf("unable to add %s to ipset %s: %s", entry, setName, err)
+		}
+	}
+
+	for _, entry := range del {
+		if err := set.Del(entry); err != nil {
+			zap.L().Debug("unable to remove entry from set", zap.String("set", setName), zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// setDelta returns the entries of new that are not in old and the entries of
+// old that are not in new
+func setDelta(old, new []string) (add, del []string) {
+
+	deleteMap := map[string]bool{}
+	for _, entry := range old {
+		deleteMap[entry] = true
+	}
+
+	added := map[string]bool{}
+	for _, entry := range new {
+		if _, ok := deleteMap[entry]; ok {
+			deleteMap[entry] = false
+			continue
+		}
+
+		if !added[entry] {
+			added[entry] = true
+			add = append(add, entry)
+		}
+	}
+
+	for _, entry := range old {
+		if deleteMap[entry] {
+			deleteMap[entry] = false
+			del = append(del, entry)
+		}
+	}
+
+	return add, del
+}
+
+// proxySetEntries returns the entries of the dst, src and srv proxy sets
+// for the services of the policy
+func (i *Instance) proxySetEntries(policy *policy.PUPolicy) (dst, src, srv []string) {
+
+	services := policy.ProxiedServices()
+
+	for _, net := range services.PublicIPPortPair {
+		if !i.isFamilyAddress(strings.Split(net, ",")[0]) {
+			continue
+		}
+		dst = append(dst, net)
+	}
+
+	for _, dependentService := range policy.DependentServices() {
+		addresses := dependentService.NetworkInfo.Addresses
+		min, max := dependentService.NetworkInfo.Ports.Range()
+		for _, addr := range addresses {
+			if (addr.IP.To4() == nil) != i.ipv6 {
+				continue
+			}
+			for port := int(min); port <= int(max); port++ {
+				dst = append(dst, addr.IP.String()+","+strconv.Itoa(port))
+			}
+		}
+	}
+
 	for _, net := range services.PrivateIPPortPair {
-		if err := pipTargetSet.Add(net, 0); err != nil {
-			zap.L().Error("Failed to add vip", zap.Error(err))
-			return fmt.Errorf("unable to add private ip %s to target networks ipset: %s", net, err)
+		if !i.isFamilyAddress(strings.Split(net, ",")[0]) {
+			continue
 		}
+		src = append(src, net)
 		parts := strings.Split(net, ",")
 		if len(parts) != 2 {
 			continue
 		}
-		if err := srvTargetSet.Add(parts[1], 0); err != nil {
-			zap.L().Error("Failed to add port to srv target set", zap.Error(err))
-			return fmt.Errorf("Unable to add port mapping: %s ", err)
-		}
+		srv = append(srv, parts[1])
 	}
 
 	for _, exposedService := range policy.ExposedServices() {
 		min, max := exposedService.PrivateNetworkInfo.Ports.Range()
-		for i := int(min); i <= int(max); i++ {
-			if err := srvTargetSet.Add(strconv.Itoa(i), 0); err != nil {
-				zap.L().Error("Failed to add vip", zap.Error(err))
-				return fmt.Errorf("unable to add ip %d to target ports ipset: %s", i, err)
-			}
+		for port := int(min); port <= int(max); port++ {
+			srv = append(srv, strconv.Itoa(port))
 		}
 	}
-	return nil
+
+	return dst, src, srv
 }
 
 //getSetNamePair returns a pair of strings represent proxySetNames
 func (i *Instance) getSetNames(portSetName string) (string, string, string) {
+	if i.ipv6 {
+		portSetName = portSetName + ipv6SetSuffix
+	}
 	return "dst-" + portSetName, "src-" + portSetName, "srv-" + portSetName
 
 }
 
+// ipsetParams returns the parameters of the hash sets for the ip family of the instance
+func (i *Instance) ipsetParams() *ipset.Params {
+	if i.ipv6 {
+		return &ipset.Params{HashFamily: "inet6"}
+	}
+	return &ipset.Params{}
+}
+
 //Not using ipset from coreos library they don't support bitmap:port
 func (i *Instance) createPUPortSet(setname string) error {
 	//Bitmap type is not supported by the ipset library
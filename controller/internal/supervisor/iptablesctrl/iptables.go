@@ -7,7 +7,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"strconv"
+	"strings"
 
 	"github.com/aporeto-inc/trireme-lib/common"
 	"github.com/aporeto-inc/trireme-lib/controller/constants"
@@ -26,6 +28,12 @@ const (
 	appChainPrefix   = chainPrefix + "App-"
 	netChainPrefix   = chainPrefix + "Net-"
 	targetNetworkSet = "TargetNetSet"
+	// targetNetworkSetIPv6 is the target network set of the ip6tables instance
+	targetNetworkSetIPv6 = "TargetNetSetV6"
+	// ipv6SetSuffix is appended to the names of the ipv6 proxy sets
+	ipv6SetSuffix   = "-v6"
+	ipv4AllNetworks = "0.0.0.0/0"
+	ipv6AllNetworks = "::/0"
 	// PuPortSet The prefix for portset names
 	PuPortSet                = "PUPort-"
 	proxyPortSetPrefix       = "Proxy-"
@@ -56,6 +64,9 @@ type Instance struct {
 	appSynAckIPTableSection string
 	mode                    constants.ModeType
 	portSetInstance         portset.PortSet
+	ipv6                    bool
+	targetSetName           string
+	allNetworks             string
 }
 
 // NewInstance creates a new iptables controller instance
@@ -66,15 +77,27 @@ func NewInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType, portset por
 		return nil, fmt.Errorf("unable to initialize iptables provider: %s", err)
 	}
 
-	ips := provider.NewGoIPsetProvider()
+	return newInstance(fqc, mode, portset, ipt, false), nil
+}
+
+// NewIPv6Instance creates a new controller instance that programs the ip6tables
+// and the inet6 ipsets. It only manages the ipv6 addresses of the policies.
+func NewIPv6Instance(fqc *fqconfig.FilterQueue, mode constants.ModeType, portset portset.PortSet) (*Instance, error) {
+
+	ipt, err := provider.NewGoIP6TablesProvider()
 	if err != nil {
-		return nil, fmt.Errorf("unable to initialize ipsets: %s", err)
+		return nil, fmt.Errorf("unable to initialize ip6tables provider: %s", err)
 	}
 
+	return newInstance(fqc, mode, portset, ipt, true), nil
+}
+
+func newInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType, portset portset.PortSet, ipt provider.IptablesProvider, ipv6 bool) *Instance {
+
 	i := &Instance{
-		fqc:   fqc,
-		ipt:   ipt,
-		ipset: ips,
+		fqc:                     fqc,
+		ipt:                     ipt,
+		ipset:                   provider.NewGoIPsetProvider(),
 		appPacketIPTableContext: "mangle",
 		netPacketIPTableContext: "mangle",
 		appProxyIPTableContext:  "nat",
@@ -84,10 +107,17 @@ func NewInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType, portset por
 		appCgroupIPTableSection: ipTableSectionOutput,
 		netPacketIPTableSection: ipTableSectionInput,
 		appSynAckIPTableSection: ipTableSectionOutput,
+		ipv6:                    ipv6,
+		targetSetName:           targetNetworkSet,
+		allNetworks:             ipv4AllNetworks,
 	}
 
-	return i, nil
+	if ipv6 {
+		i.targetSetName = targetNetworkSetIPv6
+		i.allNetworks = ipv6AllNetworks
+	}
 
+	return i
 }
 
 // chainPrefix returns the chain name for the specific PU.
@@ -147,7 +177,8 @@ func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *
 		return err
 	}
 
-	// Optionally create the UID set
+	// Optionally create the UID set. The port sets are family agnostic and
+	// they are owned by the ipv4 instance.
 	if err := i.createUIDSets(contextID, containerInfo); err != nil {
 		return err
 	}
@@ -174,7 +205,7 @@ func (i *Instance) DeleteRules(version int, contextID string, port string, mark
 		zap.L().Warn("Failed to clean container chains while deleting the rules", zap.Error(err))
 	}
 
-	if uid != "" {
+	if uid != "" && !i.ipv6 {
 		if err := i.deleteUIDSets(contextID, uid, mark); err != nil {
 			return err
 		}
@@ -183,8 +214,11 @@ func (i *Instance) DeleteRules(version int, contextID string, port string, mark
 	return i.deleteProxySets(proxyPortSetName)
 }
 
-// UpdateRules implements the update part of the interface. Update will call
-// installrules to install the new rules and then it will delete the old rules.
+// UpdateRules implements the update part of the interface. When only the
+// ACLs, the excluded networks or the proxy sets change, the difference is
+// applied to the chains of the previous version, which are then renamed.
+// Otherwise update will call installrules to install the new rules and then
+// it will delete the old rules.
 func (i *Instance) UpdateRules(version int, contextID string, containerInfo *policy.PUInfo, oldContainerInfo *policy.PUInfo) error {
 
 	policyrules := containerInfo.Policy
@@ -192,6 +226,14 @@ func (i *Instance) UpdateRules(version int, contextID string, containerInfo *pol
 		return errors.New("policy rules cannot be nil")
 	}
 
+	if !structuralChange(containerInfo, oldContainerInfo) {
+		err := i.updateRulesInPlace(version, contextID, containerInfo, oldContainerInfo)
+		if err == nil {
+			return nil
+		}
+		zap.L().Debug("Unable to update the rules in place, rebuilding the chains", zap.String("contextID", contextID), zap.Error(err))
+	}
+
 	proxyPort := containerInfo.Runtime.Options().ProxyPort
 	proxySetName := puPortSetName(contextID, proxyPortSetPrefix)
 
@@ -207,7 +249,7 @@ func (i *Instance) UpdateRules(version int, contextID string, containerInfo *pol
 
 	// Install the new rules
 	if err := i.installRules(contextID, appChain, netChain, proxySetName, containerInfo); err != nil {
-		return nil
+		return err
 	}
 
 	// Remove mapping from old chain
@@ -267,13 +309,20 @@ func (i *Instance) CleanUp() error {
 	return nil
 }
 
-// SetTargetNetworks updates ths target networks for SynAck packets
+// SetTargetNetworks updates ths target networks for SynAck packets. Only the
+// networks of the ip family of the instance are programmed.
 func (i *Instance) SetTargetNetworks(current, networks []string) error {
 
 	if len(networks) == 0 {
 		networks = []string{"0.0.0.0/1", "128.0.0.0/1"}
+		if i.ipv6 {
+			networks = []string{"::/1", "8000::/1"}
+		}
 	}
 
+	current = i.filterAddresses(current)
+	networks = i.filterAddresses(networks)
+
 	// Cleanup old ACLs
 	if len(current) > 0 {
 		return i.updateTargetNetworks(current, networks)
@@ -292,6 +341,31 @@ func (i *Instance) SetTargetNetworks(current, networks []string) error {
 	return nil
 }
 
+// isFamilyAddress returns true if the given address or network belongs to the
+// ip family of the instance.
+func (i *Instance) isFamilyAddress(address string) bool {
+
+	ip := net.ParseIP(strings.Split(address, "/")[0])
+	if ip == nil {
+		return false
+	}
+
+	return (ip.To4() == nil) == i.ipv6
+}
+
+// filterAddresses returns the addresses or networks of the ip family of the instance.
+func (i *Instance) filterAddresses(addresses []string) []string {
+
+	filtered := []string{}
+	for _, address := range addresses {
+		if i.isFamilyAddress(address) {
+			filtered = append(filtered, address)
+		}
+	}
+
+	return filtered
+}
+
 // InitializeChains initializes the chains.
 func (i *Instance) InitializeChains() error {
 
@@ -354,8 +428,10 @@ func (i *Instance) configureLinuxRules(contextID, appChain, netChain, proxyPortS
 		if i.portSetInstance == nil {
 			return errors.New("enforcer portset instance cannot be nil for host")
 		}
-		if err := i.portSetInstance.AddUserPortSet(uid, portSetName, mark); err != nil {
-			return err
+		if !i.ipv6 {
+			if err := i.portSetInstance.AddUserPortSet(uid, portSetName, mark); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -435,13 +511,13 @@ func (i *Instance) installRules(contextID, appChain, netChain, proxySetName stri
 		return err
 	}
 
-	if err := i.addAppACLs(contextID, appChain, policyrules.ApplicationACLs()); err != nil {
+	if err := i.addAppACLs(contextID, appChain, policyrules.ApplicationACLs(), policyrules.ConflictMode()); err != nil {
 		return err
 	}
 
-	if err := i.addNetACLs(contextID, netChain, policyrules.NetworkACLs()); err != nil {
+	if err := i.addNetACLs(contextID, netChain, policyrules.NetworkACLs(), policyrules.ConflictMode()); err != nil {
 		return err
 	}
 
-	return i.addExclusionACLs(appChain, netChain, policyrules.ExcludedNetworks())
+	return i.addExclusionACLs(appChain, netChain, i.filterAddresses(policyrules.ExcludedNetworks()))
 }
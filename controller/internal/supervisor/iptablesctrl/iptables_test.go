@@ -28,6 +28,25 @@ func TestNewInstance(t *testing.T) {
 	})
 }
 
+func TestNewIPv6Instance(t *testing.T) {
+	Convey("When I create a new ipv6 iptables instance", t, func() {
+		i, err := NewIPv6Instance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		Convey("It should succeed and use the ipv6 sets and networks", func() {
+			So(err, ShouldBeNil)
+			So(i, ShouldNotBeNil)
+			So(i.ipv6, ShouldBeTrue)
+			So(i.targetSetName, ShouldEqual, targetNetworkSetIPv6)
+			So(i.allNetworks, ShouldEqual, "::/0")
+			dst, src, srv := i.getSetNames("Proxy-set")
+			So(dst, ShouldEqual, "dst-Proxy-set-v6")
+			So(src, ShouldEqual, "src-Proxy-set-v6")
+			So(srv, ShouldEqual, "srv-Proxy-set-v6")
+			So(i.isFamilyAddress("2001:db8::1"), ShouldBeTrue)
+			So(i.isFamilyAddress("10.0.0.0/8"), ShouldBeFalse)
+		})
+	})
+}
+
 func TestChainName(t *testing.T) {
 	Convey("When I test the creation of the name of the chain", t, func() {
 		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
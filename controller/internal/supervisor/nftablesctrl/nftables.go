@@ -0,0 +1,477 @@
+// Package nftablesctrl implements the supervisor with nftables. It uses the same
+// chain layout as the iptables implementation, but all the rules of an operation
+// are applied in a single atomic transaction and the ipsets are replaced by
+// native nftables sets.
+//
+// The transactions are written in the nft syntax and committed by the provider
+// with the nft utility, which reads the whole batch on its standard input and
+// sends it to the kernel as a single netlink transaction, so the atomicity is
+// the one of netlink. Programming netlink directly would require a library that
+// compiles every rule to the kernel expressions and follows their changes
+// across the kernel versions, while the nft syntax is stable and is the same as
+// the one printed by "nft list ruleset", which makes the rules easy to audit.
+// No such library is vendored, and the nft utility is installed wherever
+// nftables is. A batch costs one process execution, which is cheap compared to
+// the one execution per rule of the iptables implementation. The tests use the
+// fake provider, which records the committed transactions.
+//
+// The uid processing units without ports are matched with their user in the
+// application direction. In the network direction, the ports the user listens
+// on are kept in a native set of the processing unit by the portset instance,
+// which programs the sets of the supervisor instead of the ipsets. The uid
+// processing units with ports are handled like the cgroup processing units, as
+// in the iptables implementation.
+package nftablesctrl
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/aporeto-inc/trireme-lib/common"
+	"github.com/aporeto-inc/trireme-lib/controller/constants"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/portset"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/supervisor/provider"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"go.uber.org/zap"
+)
+
+const (
+	tableFamily          = "inet"
+	tableName            = "trireme"
+	chainPrefix          = "TRIREME-"
+	appChainPrefix       = chainPrefix + "App-"
+	netChainPrefix       = chainPrefix + "Net-"
+	appBaseChain         = "OUTPUT"
+	netBaseChain         = "INPUT"
+	natPreRoutingChain   = "NAT-PREROUTING"
+	natOutputChain       = "NAT-OUTPUT"
+	natProxyOutputChain  = "RedirProxy-App"
+	natProxyInputChain   = "RedirProxy-Net"
+	proxyOutputChain     = "Proxy-App"
+	proxyInputChain      = "Proxy-Net"
+	proxyMark            = "0x40"
+	observeMark          = "39"
+	targetNetworkSet     = "TargetNetSet"
+	targetNetworkSetIPv6 = "TargetNetSetV6"
+	proxyPortSetPrefix   = "Proxy-"
+	uidPortSetPrefix     = "UID-"
+	ipv6SetSuffix        = "-v6"
+	// mangleHookPriority is the priority of the iptables mangle table
+	mangleHookPriority = -150
+	// natHookPriority is the priority of the iptables nat table
+	natHookPriority = -100
+)
+
+// dispatchChains are the chains that hold the rules of the processing units
+// that send traffic to their chains.
+var dispatchChains = []string{
+	appBaseChain,
+	netBaseChain,
+	natProxyOutputChain,
+	natProxyInputChain,
+	proxyOutputChain,
+	proxyInputChain,
+}
+
+// Instance is the structure holding all information about the nftables implementation
+type Instance struct {
+	fqc             *fqconfig.FilterQueue
+	nft             provider.NftablesProvider
+	mode            constants.ModeType
+	portSetInstance portset.PortSet
+}
+
+// NewInstance creates a new nftables controller instance. The port sets of the
+// uid processing units are programmed in the table of the instance.
+func NewInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType, portSetInstance portset.PortSet) (*Instance, error) {
+
+	nft, err := provider.NewNftProvider()
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize nftables provider: %s", err)
+	}
+
+	i := &Instance{
+		fqc:             fqc,
+		nft:             nft,
+		mode:            mode,
+		portSetInstance: portSetInstance,
+	}
+
+	if portSetInstance != nil {
+		portSetInstance.SetProgrammer(i)
+	}
+
+	return i, nil
+}
+
+// AddPort implements the programmer of the port sets. It adds the port to
+// the port set of a uid processing unit.
+func (i *Instance) AddPort(portSetName string, port string) error {
+
+	tx := newTransaction()
+	tx.add("add element %s %s %s { %s }", tableFamily, tableName, portSetName, port)
+
+	return i.nft.Commit(tx.commands)
+}
+
+// DeletePort implements the programmer of the port sets. It deletes the port
+// from the port set of a uid processing unit.
+func (i *Instance) DeletePort(portSetName string, port string) error {
+
+	tx := newTransaction()
+	tx.add("delete element %s %s %s { %s }", tableFamily, tableName, portSetName, port)
+
+	return i.nft.Commit(tx.commands)
+}
+
+// chainName returns the chain names for the specific PU.
+func (i *Instance) chainName(contextID string, version int) (app, net string, err error) {
+	hash := md5.New()
+
+	if _, err := io.WriteString(hash, contextID); err != nil {
+		return "", "", err
+	}
+	output := base64.URLEncoding.EncodeToString(hash.Sum(nil))
+	if len(contextID) > 4 {
+		contextID = contextID[:4] + string(output[:6])
+	} else {
+		contextID = contextID + string(output[:6])
+	}
+
+	app = appChainPrefix + contextID + "-" + strconv.Itoa(version)
+	net = netChainPrefix + contextID + "-" + strconv.Itoa(version)
+
+	return app, net, nil
+}
+
+// proxySetName returns the name of the proxy sets of the pu.
+func proxySetName(contextID string) string {
+	return puSetName(proxyPortSetPrefix, contextID)
+}
+
+// uidPortSetName returns the name of the port set of a uid pu.
+func uidPortSetName(contextID string) string {
+	return puSetName(uidPortSetPrefix, contextID)
+}
+
+// puSetName returns the name of a set of the pu with the prefix.
+func puSetName(prefix, contextID string) string {
+	hash := md5.New()
+
+	if _, err := io.WriteString(hash, contextID); err != nil {
+		return ""
+	}
+
+	output := base64.URLEncoding.EncodeToString(hash.Sum(nil))
+
+	if len(contextID) > 4 {
+		contextID = contextID[:4] + string(output[:4])
+	} else {
+		contextID = contextID + string(output[:4])
+	}
+
+	return prefix + contextID
+}
+
+// ConfigureRules implements the ConfigureRules interface. The proxy sets, the
+// chains of the PU and all the ACLs are created in a single transaction.
+func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *policy.PUInfo) error {
+
+	if containerInfo.Policy == nil {
+		return errors.New("policy rules cannot be nil")
+	}
+
+	uidChains := i.uidChains(containerInfo)
+	if uidChains && i.portSetInstance == nil {
+		return errors.New("enforcer portset instance cannot be nil for host")
+	}
+
+	appChain, netChain, err := i.chainName(contextID, version)
+	if err != nil {
+		return err
+	}
+
+	setName := proxySetName(contextID)
+
+	tx := newTransaction()
+	i.createProxySets(tx, setName)
+	i.updateProxySets(tx, containerInfo.Policy, setName)
+
+	if uidChains {
+		tx.add("add set %s %s %s { type inet_service; }", tableFamily, tableName, uidPortSetName(contextID))
+	}
+
+	if err := i.installRules(tx, contextID, appChain, netChain, setName, containerInfo); err != nil {
+		return err
+	}
+
+	if err := i.nft.Commit(tx.commands); err != nil {
+		return err
+	}
+
+	if !uidChains {
+		return nil
+	}
+
+	// The portset instance adds the ports the user listens on to the set
+	options := containerInfo.Runtime.Options()
+
+	return i.portSetInstance.AddUserPortSet(options.UserID, uidPortSetName(contextID), options.CgroupMark)
+}
+
+// uidChains returns true if the traffic of the PU is sent to its chains by
+// its user and the ports the user listens on, like with the uid chains of the
+// iptables implementation
+func (i *Instance) uidChains(containerInfo *policy.PUInfo) bool {
+
+	options := containerInfo.Runtime.Options()
+
+	return i.mode == constants.LocalServer && options.UserID != "" && common.ConvertServicesToPortList(options.Services) == "0"
+}
+
+// DeleteRules implements the DeleteRules interface
+func (i *Instance) DeleteRules(version int, contextID string, port string, mark string, uid string, proxyPort string) error {
+
+	appChain, netChain, err := i.chainName(contextID, version)
+	if err != nil {
+		return err
+	}
+
+	tx := newTransaction()
+
+	// The chains cannot be deleted while rules jump to them
+	if err := i.deleteChainRules(tx, appChain); err != nil {
+		return fmt.Errorf("unable to list the rules of the pu: %s", err)
+	}
+
+	i.deleteContainerChains(tx, appChain, netChain)
+	i.deleteProxySets(tx, proxySetName(contextID))
+
+	uidChains := i.mode == constants.LocalServer && uid != "" && port == "0"
+	if uidChains {
+		tx.add("delete set %s %s %s", tableFamily, tableName, uidPortSetName(contextID))
+	}
+
+	if err := i.nft.Commit(tx.commands); err != nil {
+		return err
+	}
+
+	if !uidChains || i.portSetInstance == nil {
+		return nil
+	}
+
+	return i.portSetInstance.DelUserPortSet(uid, mark)
+}
+
+// UpdateRules implements the update part of the interface. The new rules are
+// installed and the old rules are removed in the same transaction, so the
+// update is hitless.
+func (i *Instance) UpdateRules(version int, contextID string, containerInfo *policy.PUInfo, oldContainerInfo *policy.PUInfo) error {
+
+	if containerInfo.Policy == nil {
+		return errors.New("policy rules cannot be nil")
+	}
+
+	appChain, netChain, err := i.chainName(contextID, version)
+	if err != nil {
+		return err
+	}
+
+	oldAppChain, oldNetChain, err := i.chainName(contextID, version^1)
+	if err != nil {
+		return err
+	}
+
+	setName := proxySetName(contextID)
+
+	tx := newTransaction()
+	i.updateProxySets(tx, containerInfo.Policy, setName)
+
+	if err := i.installRules(tx, contextID, appChain, netChain, setName, containerInfo); err != nil {
+		return err
+	}
+
+	if err := i.deleteChainRules(tx, oldAppChain); err != nil {
+		return err
+	}
+
+	i.deleteContainerChains(tx, oldAppChain, oldNetChain)
+
+	return i.nft.Commit(tx.commands)
+}
+
+// Run starts the nftables controller
+func (i *Instance) Run(ctx context.Context) error {
+
+	// Clean any previous rules and create the table
+	tx := newTransaction()
+	i.cleanTable(tx)
+	i.initializeTable(tx)
+
+	if err := i.nft.Commit(tx.commands); err != nil {
+		return fmt.Errorf("unable to initialize table: %s", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		zap.L().Debug("Stop the supervisor")
+
+		i.CleanUp() // nolint
+	}()
+
+	zap.L().Debug("Started the nftables controller")
+
+	return nil
+}
+
+// CleanUp requires the implementor to clean up all rules
+func (i *Instance) CleanUp() error {
+
+	tx := newTransaction()
+	i.cleanTable(tx)
+
+	if err := i.nft.Commit(tx.commands); err != nil {
+		zap.L().Error("Failed to clean the nftables table", zap.Error(err))
+	}
+
+	return nil
+}
+
+// SetTargetNetworks updates ths target networks for SynAck packets
+func (i *Instance) SetTargetNetworks(current, networks []string) error {
+
+	if len(networks) == 0 {
+		networks = []string{"0.0.0.0/1", "128.0.0.0/1", "::/1", "8000::/1"}
+	}
+
+	tx := newTransaction()
+
+	// Update the target sets
+	if len(current) > 0 {
+		i.updateTargetNetworks(tx, current, networks)
+		return i.nft.Commit(tx.commands)
+	}
+
+	i.addTargetNetworks(tx, networks)
+
+	// Insert the rules that point to the target networks
+	i.setGlobalRules(tx)
+
+	if err := i.nft.Commit(tx.commands); err != nil {
+		return fmt.Errorf("failed to update synack networks: %s", err)
+	}
+
+	return nil
+}
+
+// installRules adds the chains of the PU, the rules that send traffic to them
+// and all the ACLs to the transaction.
+func (i *Instance) installRules(tx *transaction, contextID, appChain, netChain, setName string, containerInfo *policy.PUInfo) error {
+
+	policyrules := containerInfo.Policy
+	proxyPort := containerInfo.Runtime.Options().ProxyPort
+
+	tx.add("add chain %s %s %s", tableFamily, tableName, appChain)
+	tx.add("add chain %s %s %s", tableFamily, tableName, netChain)
+
+	switch i.mode {
+	case constants.RemoteContainer:
+		i.addContainerChainRules(tx, appChain, netChain)
+	case constants.LocalServer:
+		mark := containerInfo.Runtime.Options().CgroupMark
+		if mark == "" {
+			return errors.New("no mark value found")
+		}
+		if i.uidChains(containerInfo) {
+			i.addUIDChainRules(tx, appChain, netChain, mark, containerInfo.Runtime.Options().UserID, uidPortSetName(contextID))
+			break
+		}
+		port := common.ConvertServicesToPortList(containerInfo.Runtime.Options().Services)
+		i.addCgroupChainRules(tx, appChain, netChain, mark, port)
+	}
+
+	if proxyPort != "" {
+		i.addProxyRules(tx, appChain, setName, proxyPort)
+	}
+
+	appRules := &ruleList{}
+	i.addPacketTrap(appRules, "daddr", i.fqc.GetApplicationQueueSynStr(), i.fqc.GetApplicationQueueAckStr(), true)
+	i.addACLs(appRules, contextID, "daddr", "10", true, policyrules.ApplicationACLs(), policyrules.ConflictMode())
+	i.addExclusionACLs(appRules, "daddr", policyrules.ExcludedNetworks())
+
+	netRules := &ruleList{}
+	i.addPacketTrap(netRules, "saddr", i.fqc.GetNetworkQueueSynStr(), i.fqc.GetNetworkQueueAckStr(), false)
+	i.addACLs(netRules, contextID, "saddr", "11", false, policyrules.NetworkACLs(), policyrules.ConflictMode())
+	i.addExclusionACLs(netRules, "saddr", policyrules.ExcludedNetworks())
+
+	for _, rule := range appRules.rules {
+		tx.addRule(appChain, rule)
+	}
+
+	for _, rule := range netRules.rules {
+		tx.addRule(netChain, rule)
+	}
+
+	return nil
+}
+
+// deleteChainRules adds the deletion of the rules that send traffic to the
+// chains of the PU to the transaction. The rules are found by their comment.
+func (i *Instance) deleteChainRules(tx *transaction, appChain string) error {
+
+	for _, chain := range dispatchChains {
+		rules, err := i.nft.ListRules(tableFamily, tableName, chain)
+		if err != nil {
+			return err
+		}
+
+		for _, rule := range rules {
+			if rule.Comment == appChain {
+				tx.add("delete rule %s %s %s handle %d", tableFamily, tableName, chain, rule.Handle)
+			}
+		}
+	}
+
+	return nil
+}
+
+// deleteContainerChains adds the deletion of the chains of the PU to the transaction
+func (i *Instance) deleteContainerChains(tx *transaction, appChain, netChain string) {
+
+	for _, chain := range []string{appChain, netChain} {
+		tx.add("flush chain %s %s %s", tableFamily, tableName, chain)
+		tx.add("delete chain %s %s %s", tableFamily, tableName, chain)
+	}
+}
+
+// cleanTable adds the deletion of the table to the transaction. The table
+// is added first, so that the deletion succeeds if the table does not exist.
+func (i *Instance) cleanTable(tx *transaction) {
+
+	tx.add("add table %s %s", tableFamily, tableName)
+	tx.add("delete table %s %s", tableFamily, tableName)
+}
+
+// initializeTable creates the table, the base chains and the target sets
+func (i *Instance) initializeTable(tx *transaction) {
+
+	tx.add("add table %s %s", tableFamily, tableName)
+
+	tx.add("add chain %s %s %s { type filter hook output priority %d; policy accept; }", tableFamily, tableName, appBaseChain, mangleHookPriority)
+	tx.add("add chain %s %s %s { type filter hook input priority %d; policy accept; }", tableFamily, tableName, netBaseChain, mangleHookPriority)
+	tx.add("add chain %s %s %s { type nat hook prerouting priority %d; policy accept; }", tableFamily, tableName, natPreRoutingChain, natHookPriority)
+	tx.add("add chain %s %s %s { type nat hook output priority %d; policy accept; }", tableFamily, tableName, natOutputChain, natHookPriority)
+
+	for _, chain := range []string{natProxyInputChain, natProxyOutputChain, proxyInputChain, proxyOutputChain} {
+		tx.add("add chain %s %s %s", tableFamily, tableName, chain)
+	}
+
+	tx.add("add set %s %s %s { type ipv4_addr; flags interval; }", tableFamily, tableName, targetNetworkSet)
+	tx.add("add set %s %s %s { type ipv6_addr; flags interval; }", tableFamily, tableName, targetNetworkSetIPv6)
+}
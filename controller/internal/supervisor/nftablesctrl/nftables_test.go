@@ -0,0 +1,496 @@
+package nftablesctrl
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/common"
+	"github.com/aporeto-inc/trireme-lib/controller/constants"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/portset"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/supervisor/provider"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/portspec"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestInstance(mode constants.ModeType) (*Instance, provider.TestNftablesProvider) {
+
+	nft := provider.NewTestNftablesProvider()
+
+	i := &Instance{
+		fqc:             fqconfig.NewFilterQueueWithDefaults(),
+		nft:             nft,
+		mode:            mode,
+		portSetInstance: portset.New(nil),
+	}
+	i.portSetInstance.SetProgrammer(i)
+
+	return i, nft
+}
+
+func newTestPUInfo(options *policy.OptionsType) *policy.PUInfo {
+
+	appACLs := policy.IPRuleList{
+		policy.IPRule{
+			Address:  "192.30.253.0/24",
+			Port:     "80",
+			Protocol: "TCP",
+			Policy:   &policy.FlowPolicy{Action: policy.Reject},
+		},
+		policy.IPRule{
+			Address:  "2001:db8::/32",
+			Port:     "443",
+			Protocol: "TCP",
+			Policy:   &policy.FlowPolicy{Action: policy.Accept},
+		},
+	}
+
+	netACLs := policy.IPRuleList{
+		policy.IPRule{
+			Address:  "10.0.0.0/8",
+			Port:     "1000:2000",
+			Protocol: "UDP",
+			Policy:   &policy.FlowPolicy{Action: policy.Accept | policy.Log},
+		},
+	}
+
+	services := &policy.ProxiedServicesInfo{
+		PublicIPPortPair:  []string{"10.1.1.1,80", "2001:db8::1,80"},
+		PrivateIPPortPair: []string{"172.17.0.2,8080"},
+	}
+
+	policyrules := policy.NewPUPolicy("Context",
+		policy.Police,
+		appACLs,
+		netACLs,
+		nil,
+		nil,
+		nil,
+		nil,
+		policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.2"},
+		[]string{"172.17.0.0/24"},
+		[]string{"20.0.0.0/8"},
+		services,
+		nil,
+		nil,
+		[]string{})
+
+	puInfo := policy.NewPUInfo("Context", common.ContainerPU)
+	puInfo.Policy = policyrules
+	puInfo.Runtime = policy.NewPURuntime("", 0, "", nil, nil, common.ContainerPU, options)
+
+	return puInfo
+}
+
+// indexOf returns the position of the first command that contains all the terms
+func indexOf(commands []string, terms ...string) int {
+
+	for i, command := range commands {
+		found := true
+		for _, term := range terms {
+			if !strings.Contains(command, term) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func TestRun(t *testing.T) {
+
+	Convey("Given an nftables controller", t, func() {
+		i, nft := newTestInstance(constants.RemoteContainer)
+
+		Convey("When I run it, it should recreate the table in one transaction", func() {
+			err := i.Run(context.Background())
+			So(err, ShouldBeNil)
+			So(len(nft.Transactions()), ShouldEqual, 1)
+
+			tx := nft.Transactions()[0]
+			So(tx[0], ShouldEqual, "add table inet trireme")
+			So(tx[1], ShouldEqual, "delete table inet trireme")
+			So(indexOf(tx, "add chain inet trireme OUTPUT", "hook output"), ShouldBeGreaterThan, 1)
+			So(indexOf(tx, "add set inet trireme TargetNetSetV6", "ipv6_addr"), ShouldBeGreaterThan, 1)
+		})
+
+		Convey("When the transaction fails, it should return an error", func() {
+			nft.MockCommit(t, func(commands []string) error {
+				return errors.New("error")
+			})
+			err := i.Run(context.Background())
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestSetTargetNetworks(t *testing.T) {
+
+	Convey("Given an nftables controller", t, func() {
+		i, nft := newTestInstance(constants.RemoteContainer)
+
+		Convey("When I set the target networks for the first time", func() {
+			err := i.SetTargetNetworks([]string{}, []string{"10.1.1.0/24", "2001:db8::/32"})
+			So(err, ShouldBeNil)
+
+			tx := nft.Transactions()[0]
+			Convey("The networks should be added to the set of their family with the global rules", func() {
+				So(indexOf(tx, "add element inet trireme TargetNetSet { 10.1.1.0/24 }"), ShouldBeGreaterThanOrEqualTo, 0)
+				So(indexOf(tx, "add element inet trireme TargetNetSetV6 { 2001:db8::/32 }"), ShouldBeGreaterThanOrEqualTo, 0)
+				So(indexOf(tx, "add rule inet trireme INPUT", "tcp option 34 exists", "queue num"), ShouldBeGreaterThanOrEqualTo, 0)
+				So(indexOf(tx, "add rule inet trireme OUTPUT", "ct mark"), ShouldBeGreaterThanOrEqualTo, 0)
+				So(indexOf(tx, "add rule inet trireme INPUT", "meta mark 4369 ct state established accept"), ShouldEqual, -1)
+			})
+		})
+
+		Convey("When the fast path is enabled and I set the target networks for the first time", func() {
+			i.fqc.FastPath = true
+			err := i.SetTargetNetworks([]string{}, []string{"10.1.1.0/24"})
+			So(err, ShouldBeNil)
+
+			tx := nft.Transactions()[0]
+			Convey("The packets marked by the fast path should be accepted before they are queued", func() {
+				accept := indexOf(tx, "add rule inet trireme INPUT", "meta mark 4369 ct state established accept")
+				So(accept, ShouldBeGreaterThanOrEqualTo, 0)
+				So(accept, ShouldBeLessThan, indexOf(tx, "add rule inet trireme INPUT", "tcp option 34 exists", "queue num"))
+			})
+		})
+
+		Convey("When I update the target networks", func() {
+			err := i.SetTargetNetworks([]string{"10.1.1.0/24", "20.1.1.0/24"}, []string{"20.1.1.0/24", "2001:db8::/32"})
+			So(err, ShouldBeNil)
+
+			tx := nft.Transactions()[0]
+			Convey("Only the changes should be applied", func() {
+				So(tx, ShouldResemble, []string{
+					"add element inet trireme TargetNetSetV6 { 2001:db8::/32 }",
+					"delete element inet trireme TargetNetSet { 10.1.1.0/24 }",
+				})
+			})
+		})
+	})
+}
+
+func TestConfigureRules(t *testing.T) {
+
+	Convey("Given an nftables controller for containers", t, func() {
+		i, nft := newTestInstance(constants.RemoteContainer)
+		appChain, netChain, _ := i.chainName("Context", 0)
+
+		Convey("When I configure the rules of a PU", func() {
+			err := i.ConfigureRules(0, "Context", newTestPUInfo(&policy.OptionsType{ProxyPort: "5000"}))
+			So(err, ShouldBeNil)
+
+			Convey("All the rules should be applied in one transaction", func() {
+				So(len(nft.Transactions()), ShouldEqual, 1)
+			})
+
+			tx := nft.Transactions()[0]
+
+			Convey("The traffic should be sent to the chains of the PU", func() {
+				So(indexOf(tx, "add chain inet trireme "+appChain), ShouldBeGreaterThanOrEqualTo, 0)
+				So(indexOf(tx, "add rule inet trireme OUTPUT jump "+appChain, "comment \""+appChain+"\""), ShouldBeGreaterThanOrEqualTo, 0)
+				So(indexOf(tx, "add rule inet trireme INPUT jump "+netChain, "comment \""+appChain+"\""), ShouldBeGreaterThanOrEqualTo, 0)
+			})
+
+			Convey("The proxy sets should hold the services of their family", func() {
+				So(indexOf(tx, "add element", "dst-Proxy-", "10.1.1.1 . 80"), ShouldBeGreaterThanOrEqualTo, 0)
+				So(indexOf(tx, "add element", "-v6", "2001:db8::1 . 80"), ShouldBeGreaterThanOrEqualTo, 0)
+				So(indexOf(tx, "add element", "srv-Proxy-", "{ 8080 }"), ShouldBeGreaterThanOrEqualTo, 0)
+				So(indexOf(tx, "redirect to :5000"), ShouldBeGreaterThanOrEqualTo, 0)
+			})
+
+			Convey("The ACLs should be ordered like the iptables rules", func() {
+				exclusion := indexOf(tx, appChain+" ip daddr 20.0.0.0/8 accept")
+				reject := indexOf(tx, appChain+" ip daddr 192.30.253.0/24 tcp dport 80 ct state new drop")
+				trap := indexOf(tx, appChain+" ip daddr @TargetNetSet tcp flags & (syn | ack) == syn queue")
+				accept := indexOf(tx, appChain+" ip6 daddr 2001:db8::/32 tcp dport 443 ct state new accept")
+				drop := indexOf(tx, "add rule inet trireme "+appChain+" drop")
+
+				So(exclusion, ShouldBeGreaterThanOrEqualTo, 0)
+				So(exclusion, ShouldBeLessThan, reject)
+				So(reject, ShouldBeLessThan, trap)
+				So(trap, ShouldBeLessThan, accept)
+				So(accept, ShouldBeLessThan, drop)
+
+				So(indexOf(tx, netChain+" ip saddr 10.0.0.0/8 udp dport 1000-2000 meta mark != 39 ct state new log group 11"), ShouldBeGreaterThanOrEqualTo, 0)
+				So(indexOf(tx, netChain+" ip saddr 20.0.0.0/8 tcp option 34 missing accept"), ShouldBeGreaterThanOrEqualTo, 0)
+			})
+		})
+
+		Convey("When the transaction fails, I should get an error", func() {
+			nft.MockCommit(t, func(commands []string) error {
+				return errors.New("error")
+			})
+			err := i.ConfigureRules(0, "Context", newTestPUInfo(nil))
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given an nftables controller for linux processes", t, func() {
+		i, nft := newTestInstance(constants.LocalServer)
+		appChain, netChain, _ := i.chainName("Context", 0)
+
+		Convey("When I configure the rules of a cgroup PU", func() {
+			options := &policy.OptionsType{
+				CgroupMark: "100",
+				Services: []common.Service{
+					{Protocol: 6, Ports: &portspec.PortSpec{Min: 80, Max: 80}},
+				},
+			}
+			err := i.ConfigureRules(0, "Context", newTestPUInfo(options))
+			So(err, ShouldBeNil)
+
+			tx := nft.Transactions()[0]
+			Convey("The traffic of the cgroup and of its ports should be sent to the chains of the PU", func() {
+				So(indexOf(tx, "OUTPUT meta cgroup 100 jump "+appChain), ShouldBeGreaterThanOrEqualTo, 0)
+				So(indexOf(tx, "INPUT tcp dport { 80 } jump "+netChain), ShouldBeGreaterThanOrEqualTo, 0)
+			})
+		})
+
+		Convey("When I configure the rules of a PU without a mark, I should get an error", func() {
+			err := i.ConfigureRules(0, "Context", newTestPUInfo(&policy.OptionsType{}))
+			So(err, ShouldNotBeNil)
+			So(len(nft.Transactions()), ShouldEqual, 0)
+		})
+
+		Convey("When I configure the rules of a uid PU", func() {
+			options := &policy.OptionsType{CgroupMark: "100", UserID: "1000"}
+			err := i.ConfigureRules(0, "Context", newTestPUInfo(options))
+			So(err, ShouldBeNil)
+
+			setName := uidPortSetName("Context")
+			tx := nft.Transactions()[0]
+			Convey("The traffic of the user and of its ports should be sent to the chains of the PU", func() {
+				So(indexOf(tx, "add set inet trireme "+setName+" { type inet_service; }"), ShouldBeGreaterThanOrEqualTo, 0)
+				So(indexOf(tx, "OUTPUT meta skuid 1000 meta mark set 100"), ShouldBeGreaterThanOrEqualTo, 0)
+				So(indexOf(tx, "OUTPUT meta skuid 1000 jump "+appChain), ShouldBeGreaterThanOrEqualTo, 0)
+				So(indexOf(tx, "INPUT tcp dport @"+setName+" jump "+netChain), ShouldBeGreaterThanOrEqualTo, 0)
+				So(indexOf(tx, "meta cgroup"), ShouldEqual, -1)
+			})
+
+			Convey("The portset instance should program the ports of the user in the set of the PU", func() {
+				user, err := i.portSetInstance.GetUserMark("100")
+				So(err, ShouldBeNil)
+				So(user, ShouldEqual, "1000")
+
+				_, err = i.portSetInstance.AddPortToUser("1000", "8080")
+				So(err, ShouldBeNil)
+				So(nft.Transactions()[1], ShouldResemble, []string{"add element inet trireme " + setName + " { 8080 }"})
+			})
+
+			Convey("When I delete the rules, the set of the PU and the user should be removed", func() {
+				err := i.DeleteRules(0, "Context", "0", "100", "1000", "")
+				So(err, ShouldBeNil)
+
+				tx := nft.Transactions()[1]
+				So(indexOf(tx, "delete set inet trireme "+setName), ShouldBeGreaterThan, indexOf(tx, "delete chain inet trireme "+netChain))
+
+				_, err = i.portSetInstance.GetUserMark("100")
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I configure the rules of a uid PU without a portset instance, I should get an error", func() {
+			i.portSetInstance = nil
+			err := i.ConfigureRules(0, "Context", newTestPUInfo(&policy.OptionsType{CgroupMark: "100", UserID: "1000"}))
+			So(err, ShouldNotBeNil)
+			So(len(nft.Transactions()), ShouldEqual, 0)
+		})
+
+		Convey("When I configure the rules of a uid PU with ports", func() {
+			options := &policy.OptionsType{
+				CgroupMark: "100",
+				UserID:     "1000",
+				Services: []common.Service{
+					{Protocol: 6, Ports: &portspec.PortSpec{Min: 80, Max: 80}},
+				},
+			}
+			err := i.ConfigureRules(0, "Context", newTestPUInfo(options))
+			So(err, ShouldBeNil)
+
+			tx := nft.Transactions()[0]
+			Convey("The PU should be handled like a cgroup PU", func() {
+				So(indexOf(tx, "OUTPUT meta cgroup 100 jump "+appChain), ShouldBeGreaterThanOrEqualTo, 0)
+				So(indexOf(tx, "INPUT tcp dport { 80 } jump "+netChain), ShouldBeGreaterThanOrEqualTo, 0)
+			})
+		})
+	})
+}
+
+func TestAddACLsConflictModes(t *testing.T) {
+
+	Convey("Given an nftables controller with overlapping ACLs", t, func() {
+		i, nft := newTestInstance(constants.LocalServer)
+
+		acl := func(address string, action policy.ActionType, priority int) policy.IPRule {
+			return policy.IPRule{
+				Address:  address,
+				Port:     "80",
+				Protocol: "tcp",
+				Policy:   &policy.FlowPolicy{Action: action},
+				Priority: priority,
+			}
+		}
+
+		rules := policy.IPRuleList{
+			acl("10.0.0.0/8", policy.Accept, 0),
+			acl("10.1.0.0/16", policy.Reject, 0),
+			acl("10.1.1.0/24", policy.Accept, 5),
+		}
+
+		// programmed returns the verdicts and the addresses of the ACLs in
+		// the order of the rules
+		programmed := func(list *ruleList) []string {
+			acls := []string{}
+			for _, rule := range list.rules {
+				if !strings.Contains(rule, "dport 80") {
+					continue
+				}
+				fields := strings.Fields(rule)
+				verdict := fields[len(fields)-1]
+				if strings.Contains(rule, " log group ") {
+					verdict = "log"
+				}
+				acls = append(acls, verdict+" "+fields[2])
+			}
+			return acls
+		}
+
+		Convey("When I add the ACLs in the deny overrides mode without priorities", func() {
+			rules[2].Priority = 0
+			list := &ruleList{}
+			i.addACLs(list, "", "daddr", "10", true, rules, policy.DenyOverrides)
+
+			Convey("Then the reject ACLs should be at the top and the accept ACLs in their order", func() {
+				So(programmed(list), ShouldResemble, []string{
+					"drop 10.1.0.0/16", "accept 10.0.0.0/8", "accept 10.1.1.0/24",
+				})
+			})
+		})
+
+		Convey("When I add the ACLs in the deny overrides mode with priorities", func() {
+			list := &ruleList{}
+			i.addACLs(list, "", "daddr", "10", true, rules, policy.DenyOverrides)
+
+			Convey("Then the accept ACLs should be ordered by priority", func() {
+				So(programmed(list), ShouldResemble, []string{
+					"drop 10.1.0.0/16", "accept 10.1.1.0/24", "accept 10.0.0.0/8",
+				})
+			})
+		})
+
+		Convey("When I add the ACLs in the first match mode", func() {
+			list := &ruleList{}
+			i.addACLs(list, "", "saddr", "11", false, rules, policy.FirstMatch)
+
+			Convey("Then the ACLs should be added by priority and then in order", func() {
+				So(programmed(list), ShouldResemble, []string{
+					"accept 10.1.1.0/24", "accept 10.0.0.0/8", "drop 10.1.0.0/16",
+				})
+			})
+		})
+
+		Convey("When I add logged ACLs in the most specific mode", func() {
+			rules[1].Policy.Action |= policy.Log
+			list := &ruleList{}
+			i.addACLs(list, "", "daddr", "10", true, rules, policy.MostSpecific)
+
+			Convey("Then the ACLs should be added from the most specific with the logs before the drops", func() {
+				So(programmed(list), ShouldResemble, []string{
+					"accept 10.1.1.0/24", "log 10.1.0.0/16", "drop 10.1.0.0/16", "accept 10.0.0.0/8",
+				})
+			})
+		})
+
+		Convey("When I configure the rules of a PU in the first match mode", func() {
+			puInfo := newTestPUInfo(&policy.OptionsType{CgroupMark: "100"})
+			puInfo.Policy.SetConflictMode(policy.FirstMatch)
+			So(i.ConfigureRules(0, "Context", puInfo), ShouldBeNil)
+
+			Convey("Then the ACLs should be added in their order", func() {
+				appChain, _, _ := i.chainName("Context", 0)
+				tx := nft.Transactions()[0]
+				reject := indexOf(tx, appChain+" ip daddr 192.30.253.0/24 tcp dport 80 ct state new drop")
+				accept := indexOf(tx, appChain+" ip6 daddr 2001:db8::/32 tcp dport 443 ct state new accept")
+				trap := indexOf(tx, appChain+" ip daddr @TargetNetSet tcp flags & (syn | ack) == syn queue")
+				So(reject, ShouldBeGreaterThan, trap)
+				So(reject, ShouldBeLessThan, accept)
+			})
+		})
+	})
+}
+
+func TestUpdateAndDeleteRules(t *testing.T) {
+
+	Convey("Given an nftables controller with a configured PU", t, func() {
+		i, nft := newTestInstance(constants.RemoteContainer)
+		oldAppChain, oldNetChain, _ := i.chainName("Context", 0)
+		newAppChain, _, _ := i.chainName("Context", 1)
+
+		nft.MockListRules(t, func(family, table, chain string) ([]provider.NftRule, error) {
+			if chain == appBaseChain {
+				return []provider.NftRule{
+					{Handle: 4, Comment: ""},
+					{Handle: 7, Comment: oldAppChain},
+					{Handle: 9, Comment: newAppChain},
+				}, nil
+			}
+			return []provider.NftRule{}, nil
+		})
+
+		Convey("When I update the rules", func() {
+			err := i.UpdateRules(1, "Context", newTestPUInfo(nil), newTestPUInfo(nil))
+			So(err, ShouldBeNil)
+			So(len(nft.Transactions()), ShouldEqual, 1)
+
+			tx := nft.Transactions()[0]
+			Convey("The new chains should be installed before the old rules are removed in the same transaction", func() {
+				install := indexOf(tx, "add rule inet trireme OUTPUT jump "+newAppChain)
+				remove := indexOf(tx, "delete rule inet trireme OUTPUT handle 7")
+				So(install, ShouldBeGreaterThanOrEqualTo, 0)
+				So(remove, ShouldBeGreaterThan, install)
+				So(indexOf(tx, "handle 4"), ShouldEqual, -1)
+				So(indexOf(tx, "handle 9"), ShouldEqual, -1)
+				So(indexOf(tx, "delete chain inet trireme "+oldNetChain), ShouldBeGreaterThan, remove)
+			})
+		})
+
+		Convey("When I delete the rules", func() {
+			err := i.DeleteRules(0, "Context", "", "", "", "5000")
+			So(err, ShouldBeNil)
+
+			tx := nft.Transactions()[0]
+			Convey("The rules, the chains and the sets of the PU should be removed", func() {
+				So(tx[0], ShouldEqual, "delete rule inet trireme OUTPUT handle 7")
+				So(indexOf(tx, "delete chain inet trireme "+oldAppChain), ShouldBeGreaterThan, 0)
+				So(indexOf(tx, "delete set inet trireme src-"+proxySetName("Context")+"-v6"), ShouldBeGreaterThan, 0)
+			})
+		})
+
+		Convey("When the rules cannot be listed, the update should fail", func() {
+			nft.MockListRules(t, func(family, table, chain string) ([]provider.NftRule, error) {
+				return nil, errors.New("error")
+			})
+			err := i.UpdateRules(1, "Context", newTestPUInfo(nil), newTestPUInfo(nil))
+			So(err, ShouldNotBeNil)
+			So(len(nft.Transactions()), ShouldEqual, 0)
+		})
+
+		Convey("When the rules cannot be listed, the deletion should fail without a transaction", func() {
+			nft.MockListRules(t, func(family, table, chain string) ([]provider.NftRule, error) {
+				return nil, errors.New("error")
+			})
+			err := i.DeleteRules(0, "Context", "", "", "", "5000")
+			So(err, ShouldNotBeNil)
+			So(len(nft.Transactions()), ShouldEqual, 0)
+		})
+	})
+}
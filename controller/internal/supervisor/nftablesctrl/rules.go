@@ -0,0 +1,464 @@
+package nftablesctrl
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/aporeto-inc/trireme-lib/controller/constants"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/cgnetcls"
+)
+
+// transaction holds the commands that are committed atomically
+type transaction struct {
+	commands []string
+}
+
+func newTransaction() *transaction {
+	return &transaction{
+		commands: []string{},
+	}
+}
+
+// add adds a command to the transaction
+func (t *transaction) add(format string, args ...interface{}) {
+	t.commands = append(t.commands, fmt.Sprintf(format, args...))
+}
+
+// addRule appends a rule to a chain of the table
+func (t *transaction) addRule(chain string, rule string) {
+	t.add("add rule %s %s %s %s", tableFamily, tableName, chain, rule)
+}
+
+// ruleList holds the rules of a PU chain while it is built. Like in the
+// iptables implementation, rules are either inserted at the top or appended.
+type ruleList struct {
+	rules []string
+}
+
+func (r *ruleList) insert(rule string) {
+	r.rules = append([]string{rule}, r.rules...)
+}
+
+func (r *ruleList) append(rule string) {
+	r.rules = append(r.rules, rule)
+}
+
+// insertList inserts the rules at the top in their order
+func (r *ruleList) insertList(rules []string) {
+	r.rules = append(append([]string{}, rules...), r.rules...)
+}
+
+// appendList appends the rules in their order
+func (r *ruleList) appendList(rules []string) {
+	r.rules = append(r.rules, rules...)
+}
+
+// family returns the nftables family keyword and the target set of an address
+// or network. It returns an empty family if the address is not valid.
+func family(address string) (string, string) {
+
+	ip := net.ParseIP(strings.Split(address, "/")[0])
+	if ip == nil {
+		return "", ""
+	}
+
+	if ip.To4() == nil {
+		return "ip6", targetNetworkSetIPv6
+	}
+
+	return "ip", targetNetworkSet
+}
+
+// portRange converts the iptables port ranges to nftables port ranges
+func portRange(ports string) string {
+	return strings.Replace(ports, ":", "-", -1)
+}
+
+// queueRange converts the iptables queue balance string to an nftables range
+func queueRange(queues string) string {
+	return strings.Replace(queues, ":", "-", -1)
+}
+
+// targetFamilies are the families and target sets of the target networks
+var targetFamilies = [][]string{
+	{"ip", targetNetworkSet},
+	{"ip6", targetNetworkSetIPv6},
+}
+
+// updateTargetNetworks updates the target sets. Only the changes are added
+// to the transaction.
+func (i *Instance) updateTargetNetworks(tx *transaction, old, new []string) {
+
+	deleteMap := map[string]bool{}
+	for _, net := range old {
+		deleteMap[net] = true
+	}
+
+	for _, net := range new {
+		if _, ok := deleteMap[net]; ok {
+			deleteMap[net] = false
+			continue
+		}
+
+		if _, set := family(net); set != "" {
+			tx.add("add element %s %s %s { %s }", tableFamily, tableName, set, net)
+		}
+	}
+
+	for net, delete := range deleteMap {
+		if !delete {
+			continue
+		}
+		if _, set := family(net); set != "" {
+			tx.add("delete element %s %s %s { %s }", tableFamily, tableName, set, net)
+		}
+	}
+}
+
+// addTargetNetworks adds the networks to the target sets
+func (i *Instance) addTargetNetworks(tx *transaction, networks []string) {
+
+	for _, net := range networks {
+		if _, set := family(net); set != "" {
+			tx.add("add element %s %s %s { %s }", tableFamily, tableName, set, net)
+		}
+	}
+}
+
+// setGlobalRules adds the global rules to the transaction
+func (i *Instance) setGlobalRules(tx *transaction) {
+
+	connmark := fmt.Sprintf("ct mark %d accept", constants.DefaultConnMark)
+	synack := "tcp flags & (syn | ack) == syn | ack"
+
+	tx.addRule(appBaseChain, "jump "+proxyOutputChain)
+	tx.addRule(appBaseChain, connmark)
+	for _, f := range targetFamilies {
+		tx.addRule(appBaseChain, fmt.Sprintf("%s daddr @%s %s meta mark set %d", f[0], f[1], synack, cgnetcls.Initialmarkval-1))
+		tx.addRule(appBaseChain, fmt.Sprintf("%s daddr @%s %s queue num %s bypass", f[0], f[1], synack, queueRange(i.fqc.GetApplicationQueueSynAckStr())))
+	}
+
+	tx.addRule(netBaseChain, "jump "+proxyInputChain)
+	tx.addRule(netBaseChain, connmark)
+	if i.fqc.IsFastPathEnabled() {
+		tx.addRule(netBaseChain, fmt.Sprintf("meta mark %d ct state established accept", i.fqc.GetMarkValue()))
+	}
+	for _, f := range targetFamilies {
+		tx.addRule(netBaseChain, fmt.Sprintf("%s saddr @%s %s queue num %s bypass", f[0], f[1], synack, queueRange(i.fqc.GetNetworkQueueSynAckStr())))
+		tx.addRule(netBaseChain, fmt.Sprintf("%s saddr @%s tcp flags & (syn | ack) == syn tcp option %d exists queue num %s bypass", f[0], f[1], packet.TCPAuthenticationOption, queueRange(i.fqc.GetNetworkQueueSynStr())))
+	}
+
+	tx.addRule(natPreRoutingChain, "jump "+natProxyInputChain)
+	tx.addRule(natOutputChain, "jump "+natProxyOutputChain)
+
+	for _, chain := range []string{natProxyInputChain, natProxyOutputChain, proxyInputChain, proxyOutputChain} {
+		tx.addRule(chain, "meta mark "+proxyMark+" accept")
+	}
+}
+
+// addContainerChainRules sends all the traffic to the chains of the PU
+func (i *Instance) addContainerChainRules(tx *transaction, appChain, netChain string) {
+
+	tx.addRule(appBaseChain, fmt.Sprintf("jump %s comment \"%s\"", appChain, appChain))
+	tx.addRule(netBaseChain, fmt.Sprintf("jump %s comment \"%s\"", netChain, appChain))
+}
+
+// addCgroupChainRules sends the traffic of the cgroup and of its ports to the chains of the PU
+func (i *Instance) addCgroupChainRules(tx *transaction, appChain, netChain, mark, port string) {
+
+	tx.addRule(appBaseChain, fmt.Sprintf("meta cgroup %s meta mark set %s comment \"%s\"", mark, mark, appChain))
+	tx.addRule(appBaseChain, fmt.Sprintf("meta cgroup %s jump %s comment \"%s\"", mark, appChain, appChain))
+	tx.addRule(netBaseChain, fmt.Sprintf("tcp dport { %s } jump %s comment \"%s\"", portRange(port), netChain, appChain))
+}
+
+// addUIDChainRules sends the traffic of the user and of the ports it listens
+// on to the chains of the PU
+func (i *Instance) addUIDChainRules(tx *transaction, appChain, netChain, mark, uid, portSetName string) {
+
+	tx.addRule(appBaseChain, fmt.Sprintf("meta skuid %s meta mark set %s comment \"%s\"", uid, mark, appChain))
+	tx.addRule(appBaseChain, fmt.Sprintf("meta skuid %s jump %s comment \"%s\"", uid, appChain, appChain))
+	tx.addRule(netBaseChain, fmt.Sprintf("tcp dport @%s jump %s comment \"%s\"", portSetName, netChain, appChain))
+}
+
+// addProxyRules adds the rules that redirect the proxied traffic. The rules
+// are tagged with the app chain of the PU so that they can be deleted.
+func (i *Instance) addProxyRules(tx *transaction, appChain, setName, proxyPort string) {
+
+	dstSetName, srcSetName, srvSetName := getSetNames(setName)
+	comment := fmt.Sprintf("comment \"%s\"", appChain)
+	redirect := fmt.Sprintf("meta mark != %s redirect to :%s %s", proxyMark, proxyPort, comment)
+
+	for _, f := range [][]string{{"ip", ""}, {"ip6", ipv6SetSuffix}} {
+		fam, suffix := f[0], f[1]
+
+		tx.addRule(natProxyInputChain, fmt.Sprintf("%s saddr . tcp dport @%s %s", fam, srcSetName+suffix, redirect))
+		tx.addRule(natProxyInputChain, fmt.Sprintf("%s daddr . tcp dport @%s %s", fam, dstSetName+suffix, redirect))
+		tx.addRule(natProxyOutputChain, fmt.Sprintf("%s daddr . tcp dport @%s %s", fam, dstSetName+suffix, redirect))
+
+		tx.addRule(proxyInputChain, fmt.Sprintf("%s saddr . tcp sport @%s accept %s", fam, dstSetName+suffix, comment))
+		tx.addRule(proxyInputChain, fmt.Sprintf("%s saddr . tcp dport @%s accept %s", fam, srcSetName+suffix, comment))
+		tx.addRule(proxyOutputChain, fmt.Sprintf("%s daddr . tcp dport @%s meta mark != %s accept %s", fam, dstSetName+suffix, proxyMark, comment))
+	}
+
+	tx.addRule(natProxyInputChain, fmt.Sprintf("tcp dport @%s %s", srvSetName, redirect))
+
+	tx.addRule(proxyInputChain, fmt.Sprintf("tcp dport @%s accept %s", srvSetName, comment))
+	tx.addRule(proxyInputChain, fmt.Sprintf("tcp sport @%s accept %s", srvSetName, comment))
+	tx.addRule(proxyInputChain, fmt.Sprintf("tcp dport %s accept %s", proxyPort, comment))
+
+	tx.addRule(proxyOutputChain, fmt.Sprintf("tcp sport %s accept %s", proxyPort, comment))
+	tx.addRule(proxyOutputChain, fmt.Sprintf("tcp sport @%s accept %s", srvSetName, comment))
+	tx.addRule(proxyOutputChain, fmt.Sprintf("tcp dport @%s accept %s", srvSetName, comment))
+}
+
+// addPacketTrap adds the rules that capture the control packets to user space.
+// SynAck packets are captured by the global rules.
+func (i *Instance) addPacketTrap(rules *ruleList, direction, synQueues, ackQueues string, app bool) {
+
+	for _, f := range targetFamilies {
+		match := fmt.Sprintf("%s %s @%s", f[0], direction, f[1])
+
+		rules.append(fmt.Sprintf("%s tcp flags & (syn | ack) == syn queue num %s", match, queueRange(synQueues)))
+		rules.append(fmt.Sprintf("%s tcp flags & (syn | ack) == ack queue num %s", match, queueRange(ackQueues)))
+		if app {
+			rules.append(fmt.Sprintf("%s tcp flags & (syn | ack) == syn | ack queue num %s", match, queueRange(ackQueues)))
+		}
+		rules.append(fmt.Sprintf("%s meta l4proto udp queue num %s", match, queueRange(ackQueues)))
+	}
+}
+
+// aclMatch returns the match expression of an ACL rule
+func aclMatch(direction string, rule policy.IPRule) string {
+
+	fam, _ := family(rule.Address)
+	match := fmt.Sprintf("%s %s %s", fam, direction, rule.Address)
+
+	proto := strings.ToLower(rule.Protocol)
+	switch proto {
+	case "tcp", "udp":
+		return fmt.Sprintf("%s %s dport %s", match, proto, portRange(rule.Port))
+	case "", "all":
+		return match
+	default:
+		return fmt.Sprintf("%s meta l4proto %s", match, proto)
+	}
+}
+
+// addACLs adds the ACLs of the PU to the rule list. The accept rules are
+// appended and the reject rules are inserted with the highest priority. The
+// observed rules are ordered like the iptables implementation orders them.
+// When the conflict mode or the priorities order the ACLs, the rules of every
+// ACL are kept together in the order in which the ACLs are evaluated: the
+// reject ACLs go to the top in the deny overrides mode, and all the ACLs are
+// appended in the other modes.
+func (i *Instance) addACLs(rules *ruleList, contextID, direction, group string, app bool, acls policy.IPRuleList, mode policy.ConflictMode) {
+
+	ordered := mode.Ordered(acls.HasPriorities())
+	if ordered {
+		acls = acls.Sort(mode)
+	}
+
+	// The rules of the ordered ACLs at the top of the list
+	inserts := []string{}
+
+	for loop := 0; loop < 3; loop++ {
+
+		// The ordered ACLs are added in a single pass
+		if ordered && loop > 0 {
+			break
+		}
+
+		for _, rule := range acls {
+
+			if fam, _ := family(rule.Address); fam == "" {
+				continue
+			}
+
+			observeContinue := rule.Policy.ObserveAction.ObserveContinue()
+			switch loop {
+			case 0:
+				if !observeContinue && !ordered {
+					continue
+				}
+			case 1:
+				if rule.Policy.ObserveAction.Observed() {
+					continue
+				}
+			case 2:
+				if !rule.Policy.ObserveAction.ObserveApply() {
+					continue
+				}
+			}
+
+			match := aclMatch(direction, rule)
+			proto := strings.ToLower(rule.Protocol)
+
+			// Only the application tcp and udp verdicts are limited to new connections
+			state := ""
+			if app && (proto == "tcp" || proto == "udp") {
+				state = " ct state new"
+			}
+
+			logRule := fmt.Sprintf("%s meta mark != %s ct state new log group %s prefix \"%s\"", match, observeMark, group, rule.Policy.LogPrefix(contextID))
+			observeRule := fmt.Sprintf("%s%s meta mark != %s meta mark set %s", match, state, observeMark, observeMark)
+			log := rule.Policy.Action&policy.Log > 0 || observeContinue
+
+			// The rules of the ACL
+			block := &ruleList{}
+			top := false
+
+			switch rule.Policy.Action & (policy.Accept | policy.Reject) {
+			case policy.Accept:
+				if log {
+					block.append(logRule)
+				}
+				if observeContinue {
+					block.append(observeRule)
+				} else {
+					block.append(fmt.Sprintf("%s%s accept", match, state))
+				}
+
+			case policy.Reject:
+				if observeContinue {
+					block.insert(observeRule)
+				} else {
+					block.insert(fmt.Sprintf("%s%s drop", match, state))
+				}
+				if log {
+					block.insert(logRule)
+				}
+				top = true
+			}
+
+			switch {
+			case ordered && top && mode == policy.DenyOverrides:
+				inserts = append(inserts, block.rules...)
+			case ordered:
+				rules.appendList(block.rules)
+			case top:
+				rules.insertList(block.rules)
+			default:
+				rules.appendList(block.rules)
+			}
+		}
+	}
+
+	rules.insertList(inserts)
+
+	// Accept established connections
+	rules.append("meta l4proto { tcp, udp } ct state established accept")
+
+	// Log everything else
+	rules.append(fmt.Sprintf("ct state new log group %s prefix \"%s\"", group, policy.DefaultLogPrefix(contextID)))
+
+	// Drop everything else
+	rules.append("drop")
+}
+
+// addExclusionACLs adds the set of networks that must be excluded
+func (i *Instance) addExclusionACLs(rules *ruleList, direction string, exclusions []string) {
+
+	for _, e := range exclusions {
+
+		fam, _ := family(e)
+		if fam == "" {
+			continue
+		}
+
+		if direction == "daddr" {
+			rules.insert(fmt.Sprintf("%s daddr %s accept", fam, e))
+			continue
+		}
+
+		rules.insert(fmt.Sprintf("%s saddr %s tcp option %d missing accept", fam, e, packet.TCPAuthenticationOption))
+	}
+}
+
+// getSetNames returns the names of the proxy sets. The ipv6 sets have the
+// ipv6 suffix.
+func getSetNames(setName string) (string, string, string) {
+	return "dst-" + setName, "src-" + setName, "srv-" + setName
+}
+
+// createProxySets adds the proxy sets of a PU to the transaction
+func (i *Instance) createProxySets(tx *transaction, setName string) {
+
+	dstSetName, srcSetName, srvSetName := getSetNames(setName)
+
+	for _, name := range []string{dstSetName, srcSetName} {
+		tx.add("add set %s %s %s { type ipv4_addr . inet_service; }", tableFamily, tableName, name)
+		tx.add("add set %s %s %s { type ipv6_addr . inet_service; }", tableFamily, tableName, name+ipv6SetSuffix)
+	}
+
+	tx.add("add set %s %s %s { type inet_service; }", tableFamily, tableName, srvSetName)
+}
+
+// deleteProxySets adds the deletion of the proxy sets of a PU to the transaction
+func (i *Instance) deleteProxySets(tx *transaction, setName string) {
+
+	dstSetName, srcSetName, srvSetName := getSetNames(setName)
+
+	for _, name := range []string{dstSetName, dstSetName + ipv6SetSuffix, srcSetName, srcSetName + ipv6SetSuffix, srvSetName} {
+		tx.add("delete set %s %s %s", tableFamily, tableName, name)
+	}
+}
+
+// addIPPortElement adds an ip,port pair to the set of its family
+func addIPPortElement(tx *transaction, setName string, ip string, port string) {
+
+	fam, _ := family(ip)
+	switch fam {
+	case "ip":
+		tx.add("add element %s %s %s { %s . %s }", tableFamily, tableName, setName, ip, port)
+	case "ip6":
+		tx.add("add element %s %s %s { %s . %s }", tableFamily, tableName, setName+ipv6SetSuffix, ip, port)
+	}
+}
+
+// updateProxySets flushes the proxy sets and adds the services of the policy
+func (i *Instance) updateProxySets(tx *transaction, policy *policy.PUPolicy, setName string) {
+
+	services := policy.ProxiedServices()
+	dstSetName, srcSetName, srvSetName := getSetNames(setName)
+
+	for _, name := range []string{dstSetName, dstSetName + ipv6SetSuffix, srcSetName, srcSetName + ipv6SetSuffix, srvSetName} {
+		tx.add("flush set %s %s %s", tableFamily, tableName, name)
+	}
+
+	for _, pair := range services.PublicIPPortPair {
+		parts := strings.Split(pair, ",")
+		if len(parts) != 2 {
+			continue
+		}
+		addIPPortElement(tx, dstSetName, parts[0], parts[1])
+	}
+
+	for _, dependentService := range policy.DependentServices() {
+		min, max := dependentService.NetworkInfo.Ports.Range()
+		for _, addr := range dependentService.NetworkInfo.Addresses {
+			for port := int(min); port <= int(max); port++ {
+				addIPPortElement(tx, dstSetName, addr.IP.String(), strconv.Itoa(port))
+			}
+		}
+	}
+
+	for _, pair := range services.PrivateIPPortPair {
+		parts := strings.Split(pair, ",")
+		if len(parts) != 2 {
+			continue
+		}
+		addIPPortElement(tx, srcSetName, parts[0], parts[1])
+		tx.add("add element %s %s %s { %s }", tableFamily, tableName, srvSetName, parts[1])
+	}
+
+	for _, exposedService := range policy.ExposedServices() {
+		min, max := exposedService.PrivateNetworkInfo.Ports.Range()
+		for port := int(min); port <= int(max); port++ {
+			tx.add("add element %s %s %s { %d }", tableFamily, tableName, srvSetName, port)
+		}
+	}
+}
@@ -19,6 +19,8 @@ type IptablesProvider interface {
 	DeleteChain(table, chain string) error
 	// NewChain creates a new chain
 	NewChain(table, chain string) error
+	// RenameChain renames a chain in the table. The references to the chain follow it
+	RenameChain(table, oldChain, newChain string) error
 }
 
 // NewGoIPTablesProvider returns an IptablesProvider interface based on the go-iptables
@@ -26,3 +28,9 @@ type IptablesProvider interface {
 func NewGoIPTablesProvider() (IptablesProvider, error) {
 	return iptables.New()
 }
+
+// NewGoIP6TablesProvider returns an IptablesProvider interface based on the go-iptables
+// external package that programs the ip6tables.
+func NewGoIP6TablesProvider() (IptablesProvider, error) {
+	return iptables.NewWithProtocol(iptables.ProtocolIPv6)
+}
@@ -13,6 +13,7 @@ type iptablesProviderMockedMethods struct {
 	clearChainMock  func(table, chain string) error
 	deleteChainMock func(table, chain string) error
 	newChainMock    func(table, chain string) error
+	renameChainMock func(table, oldChain, newChain string) error
 }
 
 // TestIptablesProvider is a test implementation for IptablesProvider
@@ -25,6 +26,7 @@ type TestIptablesProvider interface {
 	MockClearChain(t *testing.T, impl func(table, chain string) error)
 	MockDeleteChain(t *testing.T, impl func(table, chain string) error)
 	MockNewChain(t *testing.T, impl func(table, chain string) error)
+	MockRenameChain(t *testing.T, impl func(table, oldChain, newChain string) error)
 }
 
 // A testIptablesProvider is an empty TransactionalManipulator that can be easily mocked.
@@ -77,6 +79,11 @@ func (m *testIptablesProvider) MockNewChain(t *testing.T, impl func(table, chain
 	m.currentMocks(t).newChainMock = impl
 }
 
+func (m *testIptablesProvider) MockRenameChain(t *testing.T, impl func(table, oldChain, newChain string) error) {
+
+	m.currentMocks(t).renameChainMock = impl
+}
+
 func (m *testIptablesProvider) Append(table, chain string, rulespec ...string) error {
 
 	if mock := m.currentMocks(m.currentTest); mock != nil && mock.appendMock != nil {
@@ -140,6 +147,15 @@ func (m *testIptablesProvider) NewChain(table, chain string) error {
 	return nil
 }
 
+func (m *testIptablesProvider) RenameChain(table, oldChain, newChain string) error {
+
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.renameChainMock != nil {
+		return mock.renameChainMock(table, oldChain, newChain)
+	}
+
+	return nil
+}
+
 func (m *testIptablesProvider) currentMocks(t *testing.T) *iptablesProviderMockedMethods {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -0,0 +1,101 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// NftRule is a rule of an nftables chain as reported by the kernel.
+type NftRule struct {
+	// Handle is the kernel handle of the rule. It is used to delete the rule.
+	Handle int
+	// Comment is the comment of the rule.
+	Comment string
+}
+
+// NftablesProvider is an abstraction of all the methods an implementation of
+// nftables needs to provide. All the commands that are given to Commit are
+// applied as a single netlink transaction. Either all of them succeed or
+// the rule-set is left untouched.
+type NftablesProvider interface {
+	// Commit applies the commands as a single atomic transaction
+	Commit(commands []string) error
+	// ListRules lists the rules of a chain in the given table
+	ListRules(family, table, chain string) ([]NftRule, error)
+}
+
+var nftRuleRegexp = regexp.MustCompile(`(?:comment "([^"]*)" )?# handle ([0-9]+)$`)
+
+type nftProvider struct {
+	path string
+}
+
+// NewNftProvider returns an NftablesProvider based on the nft utility. The
+// utility sends every batch file to the kernel over netlink as one transaction.
+func NewNftProvider() (NftablesProvider, error) {
+
+	path, err := exec.LookPath("nft")
+	if err != nil {
+		return nil, fmt.Errorf("nft utility not found: %s", err)
+	}
+
+	return &nftProvider{
+		path: path,
+	}, nil
+}
+
+// Commit implements the Commit of the NftablesProvider interface
+func (n *nftProvider) Commit(commands []string) error {
+
+	if len(commands) == 0 {
+		return nil
+	}
+
+	cmd := exec.Command(n.path, "-f", "-")
+	cmd.Stdin = strings.NewReader(strings.Join(commands, "\n") + "\n")
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("unable to apply nftables transaction: %s: %s", err, string(bytes.TrimSpace(out)))
+	}
+
+	return nil
+}
+
+// ListRules implements the ListRules of the NftablesProvider interface
+func (n *nftProvider) ListRules(family, table, chain string) ([]NftRule, error) {
+
+	out, err := exec.Command(n.path, "-a", "list", "chain", family, table, chain).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list chain %s: %s: %s", chain, err, string(bytes.TrimSpace(out)))
+	}
+
+	rules := []NftRule{}
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		// Skip the chain definition itself
+		if strings.HasPrefix(line, "chain ") {
+			continue
+		}
+
+		match := nftRuleRegexp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		handle, err := strconv.Atoi(match[2])
+		if err != nil {
+			continue
+		}
+
+		rules = append(rules, NftRule{
+			Handle:  handle,
+			Comment: match[1],
+		})
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,94 @@
+package provider
+
+import (
+	"sync"
+	"testing"
+)
+
+type nftablesProviderMockedMethods struct {
+	commitMock    func(commands []string) error
+	listRulesMock func(family, table, chain string) ([]NftRule, error)
+}
+
+// TestNftablesProvider is a test implementation for NftablesProvider
+type TestNftablesProvider interface {
+	NftablesProvider
+	MockCommit(t *testing.T, impl func(commands []string) error)
+	MockListRules(t *testing.T, impl func(family, table, chain string) ([]NftRule, error))
+	// Transactions returns all the transactions that were committed successfully
+	Transactions() [][]string
+}
+
+// A testNftablesProvider is a fake netlink provider that records the transactions
+// and that can be easily mocked.
+type testNftablesProvider struct {
+	mocks        map[*testing.T]*nftablesProviderMockedMethods
+	transactions [][]string
+	lock         *sync.Mutex
+	currentTest  *testing.T
+}
+
+// NewTestNftablesProvider returns a new TestNftablesProvider.
+func NewTestNftablesProvider() TestNftablesProvider {
+	return &testNftablesProvider{
+		lock:         &sync.Mutex{},
+		mocks:        map[*testing.T]*nftablesProviderMockedMethods{},
+		transactions: [][]string{},
+	}
+}
+
+func (m *testNftablesProvider) MockCommit(t *testing.T, impl func(commands []string) error) {
+
+	m.currentMocks(t).commitMock = impl
+}
+
+func (m *testNftablesProvider) MockListRules(t *testing.T, impl func(family, table, chain string) ([]NftRule, error)) {
+
+	m.currentMocks(t).listRulesMock = impl
+}
+
+func (m *testNftablesProvider) Commit(commands []string) error {
+
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.commitMock != nil {
+		if err := mock.commitMock(commands); err != nil {
+			return err
+		}
+	}
+
+	m.lock.Lock()
+	m.transactions = append(m.transactions, commands)
+	m.lock.Unlock()
+
+	return nil
+}
+
+func (m *testNftablesProvider) ListRules(family, table, chain string) ([]NftRule, error) {
+
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.listRulesMock != nil {
+		return mock.listRulesMock(family, table, chain)
+	}
+
+	return nil, nil
+}
+
+func (m *testNftablesProvider) Transactions() [][]string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.transactions
+}
+
+func (m *testNftablesProvider) currentMocks(t *testing.T) *nftablesProviderMockedMethods {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	mocks := m.mocks[t]
+
+	if mocks == nil {
+		mocks = &nftablesProviderMockedMethods{}
+		m.mocks[t] = mocks
+	}
+
+	m.currentTest = t
+	return mocks
+}
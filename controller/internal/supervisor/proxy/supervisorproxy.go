@@ -30,6 +30,7 @@ type ProxyInfo struct {
 	prochdl        processmon.ProcessManager
 	rpchdl         rpcwrapper.RPCClient
 	initDone       map[string]bool
+	captureMethod  rpcwrapper.CaptureType
 
 	sync.Mutex
 }
@@ -85,7 +86,7 @@ func (s *ProxyInfo) SetTargetNetworks(networks []string) error {
 			request := &rpcwrapper.Request{
 				Payload: &rpcwrapper.InitSupervisorPayload{
 					TriremeNetworks: networks,
-					CaptureMethod:   rpcwrapper.IPTables,
+					CaptureMethod:   s.captureMethod,
 				},
 			}
 
@@ -111,8 +112,9 @@ func (s *ProxyInfo) Run(ctx context.Context) error {
 	return nil
 }
 
-// NewProxySupervisor creates a new IptablesSupervisor launcher
-func NewProxySupervisor(collector collector.EventCollector, enforcer enforcer.Enforcer, rpchdl rpcwrapper.RPCClient) (*ProxyInfo, error) {
+// NewProxySupervisor creates a new IptablesSupervisor launcher. The capture method
+// selects the implementation of the remote supervisors.
+func NewProxySupervisor(collector collector.EventCollector, enforcer enforcer.Enforcer, rpchdl rpcwrapper.RPCClient, captureMethod rpcwrapper.CaptureType) (*ProxyInfo, error) {
 
 	if collector == nil {
 		return nil, errors.New("collector cannot be nil")
@@ -130,6 +132,7 @@ func NewProxySupervisor(collector collector.EventCollector, enforcer enforcer.En
 		rpchdl:         rpchdl,
 		initDone:       make(map[string]bool),
 		ExcludedIPs:    []string{},
+		captureMethod:  captureMethod,
 	}
 
 	return s, nil
@@ -142,7 +145,7 @@ func (s *ProxyInfo) InitRemoteSupervisor(contextID string, puInfo *policy.PUInfo
 	request := &rpcwrapper.Request{
 		Payload: &rpcwrapper.InitSupervisorPayload{
 			TriremeNetworks: puInfo.Policy.TriremeNetworks(),
-			CaptureMethod:   rpcwrapper.IPTables,
+			CaptureMethod:   s.captureMethod,
 		},
 	}
 
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -14,7 +15,9 @@ import (
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/portset"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/supervisor/iptablesctrl"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/supervisor/nftablesctrl"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/metrics"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
 )
@@ -56,6 +59,22 @@ type Config struct {
 // simplifies the lookup operations at the expense of memory.
 func NewSupervisor(collector collector.EventCollector, enforcerInstance enforcer.Enforcer, mode constants.ModeType, networks []string) (*Config, error) {
 
+	return newSupervisor(collector, enforcerInstance, mode, networks, newIptablesImplementor)
+}
+
+// NewNFTablesSupervisor will create a new connection supervisor that uses nftables
+// to redirect specific packets to userspace. The rules of every operation are
+// applied in a single atomic transaction.
+func NewNFTablesSupervisor(collector collector.EventCollector, enforcerInstance enforcer.Enforcer, mode constants.ModeType, networks []string) (*Config, error) {
+
+	return newSupervisor(collector, enforcerInstance, mode, networks, newNftablesImplementor)
+}
+
+// implementorFactory creates the packet filter implementation of the supervisor
+type implementorFactory func(filterQueue *fqconfig.FilterQueue, mode constants.ModeType, portSetInstance portset.PortSet) (Implementor, error)
+
+func newSupervisor(collector collector.EventCollector, enforcerInstance enforcer.Enforcer, mode constants.ModeType, networks []string, factory implementorFactory) (*Config, error) {
+
 	if collector == nil || enforcerInstance == nil {
 		return nil, errors.New("Invalid parameters")
 	}
@@ -70,7 +89,7 @@ func NewSupervisor(collector collector.EventCollector, enforcerInstance enforcer
 		return nil, errors.New("portSetInstance cannot be nil")
 	}
 
-	impl, err := iptablesctrl.NewInstance(filterQueue, mode, portSetInstance)
+	impl, err := factory(filterQueue, mode, portSetInstance)
 	if err != nil {
 		return nil, fmt.Errorf("unable to initialize supervisor controllers: %s", err)
 	}
@@ -87,6 +106,32 @@ func NewSupervisor(collector collector.EventCollector, enforcerInstance enforcer
 	}, nil
 }
 
+// newIptablesImplementor creates the iptables implementation. The ipv6
+// implementation is optional. If ip6tables are not available only the ipv4
+// traffic is supervised.
+func newIptablesImplementor(filterQueue *fqconfig.FilterQueue, mode constants.ModeType, portSetInstance portset.PortSet) (Implementor, error) {
+
+	impl, err := iptablesctrl.NewInstance(filterQueue, mode, portSetInstance)
+	if err != nil {
+		return nil, err
+	}
+
+	impl6, err := iptablesctrl.NewIPv6Instance(filterQueue, mode, portSetInstance)
+	if err != nil {
+		zap.L().Warn("Unable to initialize ipv6 supervisor controller. Only ipv4 traffic will be supervised", zap.Error(err))
+		return impl, nil
+	}
+
+	return newDualStack(impl, impl6), nil
+}
+
+// newNftablesImplementor creates the nftables implementation. The inet table
+// of nftables supervises the ipv4 and ipv6 traffic together.
+func newNftablesImplementor(filterQueue *fqconfig.FilterQueue, mode constants.ModeType, portSetInstance portset.PortSet) (Implementor, error) {
+
+	return nftablesctrl.NewInstance(filterQueue, mode, portSetInstance)
+}
+
 // Supervise creates a mapping between an IP address and the corresponding labels.
 // it invokes the various handlers that process the parameter policy.
 func (s *Config) Supervise(contextID string, pu *policy.PUInfo) error {
@@ -117,7 +162,10 @@ func (s *Config) Unsupervise(contextID string) error {
 	cfg := data.(*cacheData)
 	port := cfg.containerInfo.Runtime.Options().ProxyPort
 
-	if err := s.impl.DeleteRules(cfg.version, contextID, cfg.port, cfg.mark, cfg.uid, port); err != nil {
+	start := time.Now()
+	err = s.impl.DeleteRules(cfg.version, contextID, cfg.port, cfg.mark, cfg.uid, port)
+	observeRules("delete", start, err)
+	if err != nil {
 		zap.L().Warn("Some rules were not deleted during unsupervise", zap.Error(err))
 	}
 
@@ -156,7 +204,7 @@ func (s *Config) SetTargetNetworks(networks []string) error {
 
 	// If there are no target networks, capture all traffic
 	if len(networks) == 0 {
-		networks = []string{"0.0.0.0/1", "128.0.0.0/1"}
+		networks = []string{"0.0.0.0/1", "128.0.0.0/1", "::/1", "8000::/1"}
 	}
 	s.triremeNetworks = networks
 
@@ -181,7 +229,10 @@ func (s *Config) doCreatePU(contextID string, pu *policy.PUInfo) error {
 	s.versionTracker.AddOrUpdate(contextID, c)
 
 	// Configure the rules
-	if err := s.impl.ConfigureRules(c.version, contextID, pu); err != nil {
+	start := time.Now()
+	err := s.impl.ConfigureRules(c.version, contextID, pu)
+	observeRules("configure", start, err)
+	if err != nil {
 		// Revert what you can since we have an error - it will fail most likely
 		s.Unsupervise(contextID) // nolint
 		return err
@@ -203,12 +254,19 @@ func (s *Config) doUpdatePU(contextID string, pu *policy.PUInfo) error {
 	}
 
 	c := data.(*cacheData)
-	if err := s.impl.UpdateRules(c.version, contextID, pu, c.containerInfo); err != nil {
+	start := time.Now()
+	err = s.impl.UpdateRules(c.version, contextID, pu, c.containerInfo)
+	observeRules("update", start, err)
+	if err != nil {
 		// Try to clean up, even though this is fatal and it will most likely fail
 		s.Unsupervise(contextID) // nolint
 		return err
 	}
 
+	// Keep the policy of the rules so that the next update is computed
+	// against it
+	c.containerInfo = pu
+
 	return nil
 }
 
@@ -217,3 +275,14 @@ func revert(a, b interface{}) interface{} {
 	entry.version = entry.version ^ 1
 	return entry
 }
+
+// observeRules records the time spent to program the rules of a PU
+func observeRules(operation string, start time.Time, err error) {
+
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+
+	metrics.RuleLatency.ObserveDuration(start, operation, result)
+}
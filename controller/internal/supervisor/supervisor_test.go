@@ -150,6 +150,20 @@ func TestSupervise(t *testing.T) {
 			})
 		})
 
+		Convey("When I send supervise commands for a third time, the update should be against the last policy", func() {
+			secondInfo := createPUInfo()
+			thirdInfo := createPUInfo()
+			impl.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
+			impl.EXPECT().UpdateRules(1, "contextID", secondInfo, puInfo).Return(nil)
+			impl.EXPECT().UpdateRules(0, "contextID", thirdInfo, secondInfo).Return(nil)
+			So(s.Supervise("contextID", puInfo), ShouldBeNil)
+			So(s.Supervise("contextID", secondInfo), ShouldBeNil)
+			err := s.Supervise("contextID", thirdInfo)
+			Convey("I should not get an error", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
 		Convey("When I send supervise command for a second time, and the update fails", func() {
 			impl.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
 			impl.EXPECT().UpdateRules(1, "contextID", gomock.Any(), gomock.Any()).Return(errors.New("error"))
@@ -8,6 +8,7 @@ import (
 	context "context"
 	reflect "reflect"
 
+	introspection "github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
 	secrets "github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
 	policy "github.com/aporeto-inc/trireme-lib/policy"
 	gomock "github.com/golang/mock/gomock"
@@ -137,3 +138,63 @@ func (m *MockTriremeController) UpdateConfiguration(networks []string) error {
 func (mr *MockTriremeControllerMockRecorder) UpdateConfiguration(networks interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateConfiguration", reflect.TypeOf((*MockTriremeController)(nil).UpdateConfiguration), networks)
 }
+
+// ListPUs mocks base method
+// nolint
+func (m *MockTriremeController) ListPUs() ([]*introspection.PUState, error) {
+	ret := m.ctrl.Call(m, "ListPUs")
+	ret0, _ := ret[0].([]*introspection.PUState)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListPUs indicates an expected call of ListPUs
+// nolint
+func (mr *MockTriremeControllerMockRecorder) ListPUs() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPUs", reflect.TypeOf((*MockTriremeController)(nil).ListPUs))
+}
+
+// GetPU mocks base method
+// nolint
+func (m *MockTriremeController) GetPU(puID string) (*introspection.PUState, error) {
+	ret := m.ctrl.Call(m, "GetPU", puID)
+	ret0, _ := ret[0].(*introspection.PUState)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPU indicates an expected call of GetPU
+// nolint
+func (mr *MockTriremeControllerMockRecorder) GetPU(puID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPU", reflect.TypeOf((*MockTriremeController)(nil).GetPU), puID)
+}
+
+// ListConnections mocks base method
+// nolint
+func (m *MockTriremeController) ListConnections(puID string) ([]*introspection.ConnectionState, error) {
+	ret := m.ctrl.Call(m, "ListConnections", puID)
+	ret0, _ := ret[0].([]*introspection.ConnectionState)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListConnections indicates an expected call of ListConnections
+// nolint
+func (mr *MockTriremeControllerMockRecorder) ListConnections(puID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListConnections", reflect.TypeOf((*MockTriremeController)(nil).ListConnections), puID)
+}
+
+// ListPeerKeys mocks base method
+// nolint
+func (m *MockTriremeController) ListPeerKeys(puID string) ([]secrets.PeerKey, error) {
+	ret := m.ctrl.Call(m, "ListPeerKeys", puID)
+	ret0, _ := ret[0].([]secrets.PeerKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListPeerKeys indicates an expected call of ListPeerKeys
+// nolint
+func (mr *MockTriremeControllerMockRecorder) ListPeerKeys(puID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPeerKeys", reflect.TypeOf((*MockTriremeController)(nil).ListPeerKeys), puID)
+}
@@ -0,0 +1,73 @@
+package certissuer
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+)
+
+// CertificateIssuer issues the service certificates of the processing
+// units. The application proxy calls it for the processing units that do
+// not receive their certificates with their policy.
+type CertificateIssuer interface {
+	// Issue returns a new certificate for the request. The leaf of the
+	// certificate must be set.
+	Issue(ctx context.Context, request *Request) (*tls.Certificate, error)
+	// CertificateAuthority returns the PEM of the CAs that verify the
+	// certificates of the issuer.
+	CertificateAuthority() []byte
+}
+
+// Request describes the identity of a processing unit that is carried by
+// its certificate.
+type Request struct {
+	// ID is the identity of the processing unit
+	ID string
+	// DNSNames are the names of the services of the processing unit
+	DNSNames []string
+	// IPAddresses are the addresses of the processing unit
+	IPAddresses []net.IP
+}
+
+// Equal returns true if the requests ask for the same certificate.
+func (r *Request) Equal(o *Request) bool {
+
+	if r == nil || o == nil {
+		return r == o
+	}
+
+	if r.ID != o.ID || len(r.DNSNames) != len(o.DNSNames) || len(r.IPAddresses) != len(o.IPAddresses) {
+		return false
+	}
+
+	for i := range r.DNSNames {
+		if r.DNSNames[i] != o.DNSNames[i] {
+			return false
+		}
+	}
+
+	for i := range r.IPAddresses {
+		if !r.IPAddresses[i].Equal(o.IPAddresses[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// EncodePEM returns the PEM of the certificate chain and of the private key.
+// The key PEM is empty if the key can not be exported.
+func EncodePEM(cert *tls.Certificate) (certPEM []byte, keyPEM []byte) {
+
+	for _, der := range cert.Certificate {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+
+	if der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey); err == nil {
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	}
+
+	return certPEM, keyPEM
+}
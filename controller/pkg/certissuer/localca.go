@@ -0,0 +1,140 @@
+package certissuer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	cryptoutils "github.com/aporeto-inc/trireme-lib/utils/crypto"
+)
+
+// clockSkew is the time the certificates are valid before they are issued,
+// so that the peers with a late clock accept them.
+const clockSkew = time.Minute
+
+// LocalCA issues the service certificates with a CA held by the enforcer.
+// The identity of the processing units is carried by a SPIFFE ID in the URI
+// SANs of the certificates.
+type LocalCA struct {
+	KeyPEM         []byte
+	CertificatePEM []byte
+	TrustDomain    string
+	Validity       time.Duration
+	key            crypto.Signer
+	cert           *x509.Certificate
+}
+
+// NewLocalCA creates a certificate issuer with the key and the certificate
+// of a CA. The certificates are valid for the validity period, limited by
+// the validity of the CA.
+func NewLocalCA(keyPEM, certPEM []byte, trustDomain string, validity time.Duration) (*LocalCA, error) {
+
+	key, err := cryptoutils.LoadPrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ca key: %s", err)
+	}
+
+	cert, err := cryptoutils.LoadCertificate(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ca certificate: %s", err)
+	}
+
+	if !cert.IsCA {
+		return nil, errors.New("certificate is not a ca")
+	}
+
+	if !cryptoutils.KeyMatchesCertificate(key, cert) {
+		return nil, errors.New("ca certificate does not match key")
+	}
+
+	if trustDomain == "" {
+		return nil, errors.New("trust domain can not be empty")
+	}
+
+	if validity <= 0 {
+		return nil, errors.New("validity must be positive")
+	}
+
+	return &LocalCA{
+		KeyPEM:         keyPEM,
+		CertificatePEM: certPEM,
+		TrustDomain:    strings.ToLower(trustDomain),
+		Validity:       validity,
+		key:            key,
+		cert:           cert,
+	}, nil
+}
+
+// Issue implements the CertificateIssuer interface. The certificates have a
+// new P-256 key.
+func (c *LocalCA) Issue(ctx context.Context, request *Request) (*tls.Certificate, error) {
+
+	if request.ID == "" {
+		return nil, errors.New("certificate request without id")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(c.Validity)
+	if notAfter.After(c.cert.NotAfter) {
+		notAfter = c.cert.NotAfter
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: request.ID},
+		NotBefore:    now.Add(-clockSkew),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		URIs:         []*url.URL{c.SpiffeID(request.ID)},
+		DNSNames:     request.DNSNames,
+		IPAddresses:  request.IPAddresses,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, c.cert, &key.PublicKey, c.key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to issue certificate: %s", err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// CertificateAuthority implements the CertificateIssuer interface.
+func (c *LocalCA) CertificateAuthority() []byte {
+	return c.CertificatePEM
+}
+
+// SpiffeID returns the SPIFFE ID of the processing unit with the ID
+func (c *LocalCA) SpiffeID(id string) *url.URL {
+	return &url.URL{Scheme: "spiffe", Host: c.TrustDomain, Path: "/" + strings.TrimPrefix(id, "/")}
+}
@@ -0,0 +1,115 @@
+package certissuer
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/utils/testpki"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLocalCA(t *testing.T) {
+
+	Convey("Given a local CA", t, func() {
+
+		authority := testpki.NewAuthority("ca", testpki.WithValidity(24*time.Hour))
+		keyPEM, certPEM := authority.KeyPEM(), authority.CertPEM()
+
+		ca, err := NewLocalCA(keyPEM, certPEM, "Example.org", time.Hour)
+		So(err, ShouldBeNil)
+		So(ca.CertificateAuthority(), ShouldResemble, certPEM)
+
+		request := &Request{
+			ID:          "5c3d2f1a",
+			DNSNames:    []string{"frontend.example.org"},
+			IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+		}
+
+		Convey("Then the certificates should carry the identity of the processing unit", func() {
+			cert, err := ca.Issue(context.Background(), request)
+			So(err, ShouldBeNil)
+
+			So(cert.Leaf.Subject.CommonName, ShouldEqual, "5c3d2f1a")
+			So(len(cert.Leaf.URIs), ShouldEqual, 1)
+			So(cert.Leaf.URIs[0].String(), ShouldEqual, "spiffe://example.org/5c3d2f1a")
+			So(cert.Leaf.DNSNames, ShouldResemble, request.DNSNames)
+			So(cert.Leaf.IPAddresses[0].Equal(request.IPAddresses[0]), ShouldBeTrue)
+			So(cert.Leaf.NotAfter.Sub(cert.Leaf.NotBefore), ShouldEqual, time.Hour+clockSkew)
+
+			pool := x509.NewCertPool()
+			So(pool.AppendCertsFromPEM(certPEM), ShouldBeTrue)
+			_, err = cert.Leaf.Verify(x509.VerifyOptions{Roots: pool, DNSName: "frontend.example.org"})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Then the certificates should be encoded in PEM", func() {
+			cert, err := ca.Issue(context.Background(), request)
+			So(err, ShouldBeNil)
+
+			chainPEM, leafKeyPEM := EncodePEM(cert)
+			block, _ := pem.Decode(chainPEM)
+			So(block.Bytes, ShouldResemble, cert.Certificate[0])
+			So(leafKeyPEM, ShouldNotBeEmpty)
+		})
+
+		Convey("Then the requests without id should be rejected", func() {
+			_, err := ca.Issue(context.Background(), &Request{})
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a CA that expires soon", t, func() {
+
+		authority := testpki.NewAuthority("ca", testpki.WithValidity(time.Minute))
+		keyPEM, certPEM := authority.KeyPEM(), authority.CertPEM()
+
+		ca, err := NewLocalCA(keyPEM, certPEM, "example.org", time.Hour)
+		So(err, ShouldBeNil)
+
+		Convey("Then the certificates should not outlive the CA", func() {
+			cert, err := ca.Issue(context.Background(), &Request{ID: "pu"})
+			So(err, ShouldBeNil)
+			So(cert.Leaf.NotAfter.After(ca.cert.NotAfter), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given invalid CA configurations", t, func() {
+
+		authority := testpki.NewAuthority("ca")
+		keyPEM, certPEM := authority.KeyPEM(), authority.CertPEM()
+		leaf := testpki.NewAuthority("ca", testpki.NotCA())
+		otherKeyPEM, leafPEM := leaf.KeyPEM(), leaf.CertPEM()
+
+		Convey("Then they should be rejected", func() {
+			_, err := NewLocalCA(keyPEM, leafPEM, "example.org", time.Hour)
+			So(err, ShouldNotBeNil)
+			_, err = NewLocalCA(otherKeyPEM, certPEM, "example.org", time.Hour)
+			So(err, ShouldNotBeNil)
+			_, err = NewLocalCA(keyPEM, certPEM, "", time.Hour)
+			So(err, ShouldNotBeNil)
+			_, err = NewLocalCA(keyPEM, certPEM, "example.org", 0)
+			So(err, ShouldNotBeNil)
+			_, err = NewLocalCA(keyPEM[:10], certPEM, "example.org", time.Hour)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestRequestEqual(t *testing.T) {
+
+	Convey("Given certificate requests", t, func() {
+
+		r := &Request{ID: "pu", DNSNames: []string{"a"}, IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}}
+
+		Convey("Then they should be compared by value", func() {
+			So(r.Equal(&Request{ID: "pu", DNSNames: []string{"a"}, IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}}), ShouldBeTrue)
+			So(r.Equal(&Request{ID: "pu", DNSNames: []string{"b"}, IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}}), ShouldBeFalse)
+			So(r.Equal(&Request{ID: "pu", DNSNames: []string{"a"}}), ShouldBeFalse)
+			So(r.Equal(nil), ShouldBeFalse)
+		})
+	})
+}
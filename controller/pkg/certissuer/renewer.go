@@ -0,0 +1,166 @@
+package certissuer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Defaults of the renewal of the certificates
+const (
+	// DefaultRenewalFraction renews the certificates after two thirds of
+	// their lifetime
+	DefaultRenewalFraction = 2.0 / 3.0
+	// defaultRetryInterval is the interval between two attempts to renew a
+	// certificate after a failure
+	defaultRetryInterval = 30 * time.Second
+)
+
+// Renewer keeps the certificate of a request valid. The certificate is
+// issued again after a fraction of its lifetime and the new certificate is
+// passed to the update function. The previous certificate stays in use
+// until then, so that the connections are not dropped.
+type Renewer struct {
+	issuer   CertificateIssuer
+	request  *Request
+	fraction float64
+	retry    time.Duration
+	update   func(*tls.Certificate)
+	cert     *tls.Certificate
+	cancel   context.CancelFunc
+	sync.RWMutex
+}
+
+// NewRenewer creates a renewer of the certificate of the request. The
+// fraction is the fraction of the lifetime of the certificates after which
+// they are renewed. The default fraction is used if it is not between 0
+// and 1.
+func NewRenewer(issuer CertificateIssuer, request *Request, fraction float64, update func(*tls.Certificate)) *Renewer {
+
+	if fraction <= 0 || fraction >= 1 {
+		fraction = DefaultRenewalFraction
+	}
+
+	return &Renewer{
+		issuer:   issuer,
+		request:  request,
+		fraction: fraction,
+		retry:    defaultRetryInterval,
+		update:   update,
+	}
+}
+
+// Start issues the first certificate and renews it in the background until
+// the context is done or the renewer is stopped. The update function is
+// only called for the renewed certificates.
+func (r *Renewer) Start(ctx context.Context) (*tls.Certificate, error) {
+
+	cert, err := r.issue(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Lock()
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.Unlock()
+
+	go r.run(ctx)
+
+	return cert, nil
+}
+
+// Stop stops the renewal of the certificate. It does not wait for a renewal
+// in progress, whose update function may still be called, so the update
+// function must check that the certificate is still in use.
+func (r *Renewer) Stop() {
+
+	r.Lock()
+	defer r.Unlock()
+
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
+
+// Request returns the request of the renewer
+func (r *Renewer) Request() *Request {
+	return r.request
+}
+
+// Certificate returns the current certificate
+func (r *Renewer) Certificate() *tls.Certificate {
+
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.cert
+}
+
+// run renews the certificate until the context is done
+func (r *Renewer) run(ctx context.Context) {
+
+	for {
+		timer := time.NewTimer(r.nextRenewal())
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		cert, err := r.issue(ctx)
+		if err != nil {
+			zap.L().Warn("Unable to renew service certificate",
+				zap.String("id", r.request.ID),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		r.update(cert)
+	}
+}
+
+// issue issues a new certificate and makes it the current one
+func (r *Renewer) issue(ctx context.Context) (*tls.Certificate, error) {
+
+	cert, err := r.issuer.Issue(ctx, r.request)
+	if err != nil {
+		return nil, err
+	}
+
+	if cert.Leaf == nil {
+		return nil, errors.New("issued certificate without leaf")
+	}
+
+	r.Lock()
+	r.cert = cert
+	r.Unlock()
+
+	return cert, nil
+}
+
+// nextRenewal returns the time until the renewal of the current
+// certificate. It is the retry interval if the renewal is late, so that
+// a failing issuer is not called in a loop.
+func (r *Renewer) nextRenewal() time.Duration {
+
+	leaf := r.Certificate().Leaf
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	renewal := leaf.NotBefore.Add(time.Duration(float64(lifetime) * r.fraction))
+
+	if wait := time.Until(renewal); wait > 0 {
+		return wait
+	}
+
+	return r.retry
+}
@@ -0,0 +1,117 @@
+package certissuer
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testIssuer issues certificates with a short lifetime
+type testIssuer struct {
+	lifetime time.Duration
+	fail     bool
+	issued   int64
+	sync.Mutex
+}
+
+func (i *testIssuer) Issue(ctx context.Context, request *Request) (*tls.Certificate, error) {
+	i.Lock()
+	defer i.Unlock()
+
+	if i.fail {
+		return nil, errors.New("issuer unavailable")
+	}
+
+	i.issued++
+	now := time.Now()
+
+	return &tls.Certificate{
+		Leaf: &x509.Certificate{
+			SerialNumber: big.NewInt(i.issued),
+			NotBefore:    now,
+			NotAfter:     now.Add(i.lifetime),
+		},
+	}, nil
+}
+
+func (i *testIssuer) CertificateAuthority() []byte {
+	return nil
+}
+
+func (i *testIssuer) setFail(fail bool) {
+	i.Lock()
+	defer i.Unlock()
+	i.fail = fail
+}
+
+func TestRenewer(t *testing.T) {
+
+	Convey("Given a renewer of short lived certificates", t, func() {
+
+		issuer := &testIssuer{lifetime: 200 * time.Millisecond}
+		updates := make(chan *tls.Certificate, 10)
+
+		r := NewRenewer(issuer, &Request{ID: "pu"}, 0.5, func(cert *tls.Certificate) {
+			updates <- cert
+		})
+		r.retry = 20 * time.Millisecond
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cert, err := r.Start(ctx)
+		So(err, ShouldBeNil)
+		So(r.Certificate(), ShouldEqual, cert)
+
+		Convey("Then the certificate should be renewed before it expires", func() {
+			select {
+			case renewed := <-updates:
+				So(renewed.Leaf.SerialNumber.Int64(), ShouldEqual, 2)
+				So(renewed.Leaf.NotBefore.Before(cert.Leaf.NotAfter), ShouldBeTrue)
+				So(r.Certificate(), ShouldEqual, renewed)
+			case <-time.After(time.Second):
+				So("certificate not renewed", ShouldBeEmpty)
+			}
+		})
+
+		Convey("Then the renewal should be retried after a failure", func() {
+			issuer.setFail(true)
+			time.Sleep(150 * time.Millisecond)
+			So(r.Certificate(), ShouldEqual, cert)
+
+			issuer.setFail(false)
+			select {
+			case renewed := <-updates:
+				So(renewed.Leaf.SerialNumber.Int64(), ShouldEqual, 2)
+			case <-time.After(time.Second):
+				So("certificate not renewed", ShouldBeEmpty)
+			}
+		})
+
+		Convey("Then the renewal should stop with the renewer", func() {
+			r.Stop()
+			time.Sleep(200 * time.Millisecond)
+			So(len(updates), ShouldEqual, 0)
+			So(r.Certificate(), ShouldEqual, cert)
+		})
+	})
+
+	Convey("Given a failing issuer", t, func() {
+
+		issuer := &testIssuer{fail: true}
+		r := NewRenewer(issuer, &Request{ID: "pu"}, 2, func(*tls.Certificate) {})
+
+		Convey("Then the renewer should not start", func() {
+			_, err := r.Start(context.Background())
+			So(err, ShouldNotBeNil)
+			So(r.fraction, ShouldEqual, DefaultRenewalFraction)
+		})
+	})
+}
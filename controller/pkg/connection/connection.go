@@ -19,6 +19,9 @@ type TCPFlowState int
 // ProxyConnState identifies the constants of the state of a proxied connection
 type ProxyConnState int
 
+// UDPFlowState identifies the constants of the state of a UDP flow
+type UDPFlowState int
+
 const (
 
 	// TCPSynSend is the state where the Syn packets has been send, but no response has been received
@@ -65,6 +68,27 @@ const (
 	// ServerAuthenticatePair -- Authenticate pair of tokens
 	ServerAuthenticatePair
 )
+const (
+
+	// UDPStart is the state of a flow where no datagram has been processed
+	UDPStart UDPFlowState = iota
+
+	// UDPSynSend is the state where datagrams are send with a syn token, but no response has been received
+	UDPSynSend
+
+	// UDPSynReceived indicates that a datagram with a valid syn token has been received
+	UDPSynReceived
+
+	// UDPSynAckSend indicates that replies are send with a synack token
+	UDPSynAckSend
+
+	// UDPSynAckReceived is the state where a reply with a valid synack token has been received
+	UDPSynAckReceived
+
+	// UDPData indicates that the datagrams of the flow are now plain data
+	UDPData
+)
+
 const (
 
 	// RejectReported represents that flow was reported as rejected
@@ -74,6 +98,42 @@ const (
 	AcceptReported bool = false
 )
 
+var tcpFlowStateNames = map[TCPFlowState]string{
+	TCPSynSend:        "SynSend",
+	TCPSynReceived:    "SynReceived",
+	TCPSynAckSend:     "SynAckSend",
+	TCPSynAckReceived: "SynAckReceived",
+	TCPAckSend:        "AckSend",
+	TCPAckProcessed:   "AckProcessed",
+	TCPData:           "Data",
+	UnknownState:      "Unknown",
+}
+
+var udpFlowStateNames = map[UDPFlowState]string{
+	UDPStart:          "Start",
+	UDPSynSend:        "SynSend",
+	UDPSynReceived:    "SynReceived",
+	UDPSynAckSend:     "SynAckSend",
+	UDPSynAckReceived: "SynAckReceived",
+	UDPData:           "Data",
+}
+
+// String returns the name of the state
+func (s TCPFlowState) String() string {
+	if name, ok := tcpFlowStateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("TCPFlowState(%d)", int(s))
+}
+
+// String returns the name of the state
+func (s UDPFlowState) String() string {
+	if name, ok := udpFlowStateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("UDPFlowState(%d)", int(s))
+}
+
 // AuthInfo keeps authentication information about a connection
 type AuthInfo struct {
 	LocalContext         []byte
@@ -186,6 +246,88 @@ func NewTCPConnection(context *pucontext.PUContext) *TCPConnection {
 	}
 }
 
+// UDPConnection is information regarding a UDP flow
+type UDPConnection struct {
+	sync.RWMutex
+
+	state UDPFlowState
+	Auth  AuthInfo
+
+	// Debugging Information
+	flowReported int
+
+	// Context is the pucontext.PUContext that is associated with this connection
+	// Minimizes the number of caches and lookups
+	Context *pucontext.PUContext
+
+	// Token is the token attached to the datagrams in the current state. It is
+	// reused for retransmissions since datagrams are not acknowledged.
+	Token []byte
+
+	// PeerToken is the last token received and validated for this flow. It avoids
+	// validating the same token on every datagram.
+	PeerToken []byte
+
+	// Debugging information - pushed to the end for compact structure
+	flowLastReporting bool
+
+	// ReportFlowPolicy holds the last matched observed policy
+	ReportFlowPolicy *policy.FlowPolicy
+
+	// PacketFlowPolicy holds the last matched actual policy
+	PacketFlowPolicy *policy.FlowPolicy
+}
+
+// NewUDPConnection returns a UDPConnection information struct
+func NewUDPConnection(context *pucontext.PUContext) *UDPConnection {
+
+	nonce, err := crypto.GenerateRandomBytes(16)
+	if err != nil {
+		return nil
+	}
+	return &UDPConnection{
+		state:   UDPStart,
+		Context: context,
+		Auth: AuthInfo{
+			LocalContext: nonce,
+		},
+	}
+}
+
+// String returns a printable version of connection
+func (c *UDPConnection) String() string {
+
+	return fmt.Sprintf("state:%d auth: %+v", c.state, c.Auth)
+}
+
+// GetState is used to return the state
+func (c *UDPConnection) GetState() UDPFlowState {
+
+	return c.state
+}
+
+// SetState is used to setup the state for the UDP connection
+func (c *UDPConnection) SetState(state UDPFlowState) {
+
+	c.state = state
+}
+
+// SetReported is used to track if a flow is reported
+func (c *UDPConnection) SetReported(flowState bool) {
+
+	c.flowReported++
+
+	if c.flowReported > 1 && c.flowLastReporting != flowState {
+		zap.L().Info("Connection reported multiple times",
+			zap.Int("report count", c.flowReported),
+			zap.Bool("previous", c.flowLastReporting),
+			zap.Bool("next", flowState),
+		)
+	}
+
+	c.flowLastReporting = flowState
+}
+
 // ProxyConnection is a record to keep state of proxy auth
 type ProxyConnection struct {
 	sync.Mutex
@@ -0,0 +1,72 @@
+// Package ebpf implements an optional kernel fast path for established flows.
+//
+// Once the datapath has authorized a flow, its 5-tuple is added to a BPF map.
+// A TC classifier attached to the ingress of the interfaces looks up every
+// packet in the map and sets the mark of the filter queue configuration on
+// the packets of authorized flows. The iptables rules accept these packets
+// before the NFQUEUE rules when they belong to an established connection, so
+// they are never sent to user space. Packets of unknown flows are left
+// untouched and follow the iptables path.
+//
+// The TCP packets with the SYN flag are never marked, so a new connection
+// that reuses the tuple of an offloaded flow is always authorized by the
+// datapath. The datapath removes the flows of a processing unit when its
+// policy changes or when it is unsupervised.
+package ebpf
+
+import (
+	"fmt"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
+)
+
+const (
+	// MaxFlows is the maximum number of flows in the map. The map is an LRU
+	// map so the least recently used flows are evicted when it is full.
+	MaxFlows = 65536
+
+	// flowKeySize is the size of the key of the flow map
+	flowKeySize = 16
+	// flowValueSize is the size of the value of the flow map
+	flowValueSize = 4
+)
+
+// flowKey returns the key of the flow map for the packet. The addresses
+// and the ports are kept in network order, exactly as the program reads
+// them from the packet. Only IPv4 flows are offloaded.
+func flowKey(p *packet.Packet) ([]byte, error) {
+
+	if p.IPProto != packet.IPProtocolTCP && p.IPProto != packet.IPProtocolUDP {
+		return nil, fmt.Errorf("unsupported protocol %d", p.IPProto)
+	}
+
+	src := p.SourceAddress.To4()
+	dst := p.DestinationAddress.To4()
+	if src == nil || dst == nil {
+		return nil, fmt.Errorf("only ipv4 flows are supported")
+	}
+
+	key := make([]byte, flowKeySize)
+	copy(key[0:4], src)
+	copy(key[4:8], dst)
+	key[8] = byte(p.SourcePort >> 8)
+	key[9] = byte(p.SourcePort)
+	key[10] = byte(p.DestinationPort >> 8)
+	key[11] = byte(p.DestinationPort)
+	key[12] = p.IPProto
+
+	return key, nil
+}
+
+// reverseFlowKey returns the key of the flow in the other direction.
+func reverseFlowKey(key []byte) []byte {
+
+	reverse := make([]byte, flowKeySize)
+	copy(reverse[0:4], key[4:8])
+	copy(reverse[4:8], key[0:4])
+	copy(reverse[8:10], key[10:12])
+	copy(reverse[10:12], key[8:10])
+	copy(reverse[12:], key[12:])
+
+	return reverse
+}
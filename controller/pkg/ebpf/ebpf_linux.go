@@ -0,0 +1,366 @@
+// +build linux
+
+package ebpf
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"unsafe"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// bpf(2) commands, map and program types
+const (
+	bpfMapCreate     = 0
+	bpfMapUpdateElem = 2
+	bpfMapDeleteElem = 3
+	bpfProgLoad      = 5
+	bpfObjPin        = 6
+
+	bpfMapTypeLRUHash   = 9
+	bpfProgTypeSchedCls = 3
+	bpfAny              = 0
+
+	bpfFSPath     = "/sys/fs/bpf"
+	programName   = "prog"
+	flowMapName   = "flows"
+	filterPrio    = "49152"
+	filterHandle  = "0x7"
+	verifierLogSz = 65536
+)
+
+type mapCreateAttr struct {
+	mapType    uint32
+	keySize    uint32
+	valueSize  uint32
+	maxEntries uint32
+	mapFlags   uint32
+}
+
+type mapElemAttr struct {
+	mapFD uint32
+	_     uint32
+	key   uint64
+	value uint64
+	flags uint64
+}
+
+type progLoadAttr struct {
+	progType    uint32
+	insnCnt     uint32
+	insns       uint64
+	license     uint64
+	logLevel    uint32
+	logSize     uint32
+	logBuf      uint64
+	kernVersion uint32
+	progFlags   uint32
+}
+
+type objPinAttr struct {
+	pathname  uint64
+	bpfFD     uint32
+	fileFlags uint32
+}
+
+type bpfModule struct {
+	tc         string
+	path       string
+	mapFD      int
+	progFD     int
+	interfaces []string
+	sync.Mutex
+}
+
+// IsEBPFSupported returns true if the bpf filesystem and the tc utility
+// are available on this host.
+func IsEBPFSupported() bool {
+
+	if _, err := exec.LookPath("tc"); err != nil {
+		return false
+	}
+
+	var st unix.Statfs_t
+	if err := unix.Statfs(bpfFSPath, &st); err != nil {
+		return false
+	}
+
+	return st.Type == unix.BPF_FS_MAGIC
+}
+
+// LoadBPF creates the flow map and loads the program that marks the packets
+// of the flows in the map with the given mark. The objects are pinned in
+// a directory owned by this process so that several enforcers can run on
+// the same host.
+func LoadBPF(mark uint32) (BPFModule, error) {
+
+	if !IsEBPFSupported() {
+		return nil, fmt.Errorf("ebpf is not supported on this host")
+	}
+
+	tc, err := exec.LookPath("tc")
+	if err != nil {
+		return nil, fmt.Errorf("tc utility not found: %s", err)
+	}
+
+	b := &bpfModule{
+		tc:     tc,
+		path:   filepath.Join(bpfFSPath, "trireme-"+strconv.Itoa(os.Getpid())),
+		mapFD:  -1,
+		progFD: -1,
+	}
+
+	if err := os.MkdirAll(b.path, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create bpf directory %s: %s", b.path, err)
+	}
+
+	if b.mapFD, err = createMap(); err != nil {
+		b.Cleanup()
+		return nil, err
+	}
+
+	code, err := flowProgram(b.mapFD, mark)
+	if err != nil {
+		b.Cleanup()
+		return nil, err
+	}
+
+	if b.progFD, err = loadProgram(code); err != nil {
+		b.Cleanup()
+		return nil, err
+	}
+
+	if err := pinObject(b.progFD, filepath.Join(b.path, programName)); err != nil {
+		b.Cleanup()
+		return nil, err
+	}
+
+	if err := pinObject(b.mapFD, filepath.Join(b.path, flowMapName)); err != nil {
+		b.Cleanup()
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Attach implements the Attach method of the BPFModule interface. If no
+// interface is given, the program is attached to all the interfaces that
+// are up except the loopback.
+func (b *bpfModule) Attach(interfaces []string) error {
+
+	b.Lock()
+	defer b.Unlock()
+
+	if len(interfaces) == 0 {
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return fmt.Errorf("unable to list interfaces: %s", err)
+		}
+		for _, iface := range ifaces {
+			if iface.Flags&net.FlagLoopback == 0 && iface.Flags&net.FlagUp != 0 {
+				interfaces = append(interfaces, iface.Name)
+			}
+		}
+	}
+
+	for _, iface := range interfaces {
+		if err := b.runTC("qdisc", "replace", "dev", iface, "clsact"); err != nil {
+			return err
+		}
+
+		if err := b.runTC("filter", "replace", "dev", iface, "ingress", "prio", filterPrio, "handle", filterHandle,
+			"bpf", "direct-action", "object-pinned", filepath.Join(b.path, programName)); err != nil {
+			return err
+		}
+
+		b.interfaces = append(b.interfaces, iface)
+	}
+
+	return nil
+}
+
+// CreateFlow implements the CreateFlow method of the BPFModule interface
+func (b *bpfModule) CreateFlow(p *packet.Packet) error {
+
+	key, err := flowKey(p)
+	if err != nil {
+		return err
+	}
+
+	value := make([]byte, flowValueSize)
+	value[0] = 1
+
+	if err := updateElem(b.mapFD, key, value); err != nil {
+		return err
+	}
+
+	return updateElem(b.mapFD, reverseFlowKey(key), value)
+}
+
+// RemoveFlow implements the RemoveFlow method of the BPFModule interface
+func (b *bpfModule) RemoveFlow(p *packet.Packet) error {
+
+	key, err := flowKey(p)
+	if err != nil {
+		return err
+	}
+
+	if err := deleteElem(b.mapFD, key); err != nil {
+		return err
+	}
+
+	return deleteElem(b.mapFD, reverseFlowKey(key))
+}
+
+// Cleanup implements the Cleanup method of the BPFModule interface
+func (b *bpfModule) Cleanup() {
+
+	b.Lock()
+	defer b.Unlock()
+
+	for _, iface := range b.interfaces {
+		if err := b.runTC("filter", "delete", "dev", iface, "ingress", "prio", filterPrio, "handle", filterHandle, "bpf"); err != nil {
+			zap.L().Warn("Unable to detach the bpf program", zap.String("interface", iface), zap.Error(err))
+		}
+	}
+	b.interfaces = nil
+
+	if err := os.RemoveAll(b.path); err != nil {
+		zap.L().Warn("Unable to remove the pinned bpf objects", zap.String("path", b.path), zap.Error(err))
+	}
+
+	for _, fd := range []int{b.progFD, b.mapFD} {
+		if fd >= 0 {
+			if err := unix.Close(fd); err != nil {
+				zap.L().Debug("Unable to close bpf object", zap.Error(err))
+			}
+		}
+	}
+	b.progFD, b.mapFD = -1, -1
+}
+
+// runTC runs the tc utility
+func (b *bpfModule) runTC(args ...string) error {
+
+	if out, err := exec.Command(b.tc, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("unable to run tc %v: %s: %s", args, err, string(bytes.TrimSpace(out)))
+	}
+
+	return nil
+}
+
+// bpfCall invokes the bpf(2) system call
+func bpfCall(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+
+	r, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+
+	return int(r), nil
+}
+
+func createMap() (int, error) {
+
+	attr := mapCreateAttr{
+		mapType:    bpfMapTypeLRUHash,
+		keySize:    flowKeySize,
+		valueSize:  flowValueSize,
+		maxEntries: MaxFlows,
+	}
+
+	fd, err := bpfCall(bpfMapCreate, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		return -1, fmt.Errorf("unable to create the flow map: %s", err)
+	}
+
+	return fd, nil
+}
+
+func loadProgram(code []byte) (int, error) {
+
+	license := []byte("GPL\x00")
+	log := make([]byte, verifierLogSz)
+
+	attr := progLoadAttr{
+		progType: bpfProgTypeSchedCls,
+		insnCnt:  uint32(len(code) / instructionSize),
+		insns:    uint64(uintptr(unsafe.Pointer(&code[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		logLevel: 1,
+		logSize:  uint32(len(log)),
+		logBuf:   uint64(uintptr(unsafe.Pointer(&log[0]))),
+	}
+
+	fd, err := bpfCall(bpfProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(code)
+	runtime.KeepAlive(license)
+	if err != nil {
+		return -1, fmt.Errorf("unable to load the flow program: %s: %s", err, string(bytes.TrimRight(log, "\x00")))
+	}
+
+	return fd, nil
+}
+
+func pinObject(fd int, path string) error {
+
+	name := append([]byte(path), 0)
+
+	attr := objPinAttr{
+		pathname: uint64(uintptr(unsafe.Pointer(&name[0]))),
+		bpfFD:    uint32(fd),
+	}
+
+	_, err := bpfCall(bpfObjPin, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(name)
+	if err != nil {
+		return fmt.Errorf("unable to pin %s: %s", path, err)
+	}
+
+	return nil
+}
+
+func updateElem(fd int, key, value []byte) error {
+
+	attr := mapElemAttr{
+		mapFD: uint32(fd),
+		key:   uint64(uintptr(unsafe.Pointer(&key[0]))),
+		value: uint64(uintptr(unsafe.Pointer(&value[0]))),
+		flags: bpfAny,
+	}
+
+	_, err := bpfCall(bpfMapUpdateElem, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(key)
+	runtime.KeepAlive(value)
+	if err != nil {
+		return fmt.Errorf("unable to add flow to the map: %s", err)
+	}
+
+	return nil
+}
+
+func deleteElem(fd int, key []byte) error {
+
+	attr := mapElemAttr{
+		mapFD: uint32(fd),
+		key:   uint64(uintptr(unsafe.Pointer(&key[0]))),
+	}
+
+	_, err := bpfCall(bpfMapDeleteElem, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(key)
+	if err != nil && err != unix.ENOENT {
+		return fmt.Errorf("unable to remove flow from the map: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,15 @@
+// +build !linux
+
+package ebpf
+
+import "fmt"
+
+// IsEBPFSupported returns false on platforms other than linux.
+func IsEBPFSupported() bool {
+	return false
+}
+
+// LoadBPF is not supported on platforms other than linux.
+func LoadBPF(mark uint32) (BPFModule, error) {
+	return nil, fmt.Errorf("ebpf is only supported on linux")
+}
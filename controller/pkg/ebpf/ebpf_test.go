@@ -0,0 +1,153 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestPacket(src, dst string, sport, dport uint16) *packet.Packet {
+
+	buffer := make([]byte, 40)
+	buffer[0] = 0x45
+	binary.BigEndian.PutUint16(buffer[2:4], uint16(len(buffer)))
+	buffer[8] = 64
+	buffer[9] = packet.IPProtocolTCP
+	copy(buffer[12:16], net.ParseIP(src).To4())
+	copy(buffer[16:20], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint16(buffer[20:22], sport)
+	binary.BigEndian.PutUint16(buffer[22:24], dport)
+	buffer[32] = 0x50
+
+	p, err := packet.New(0, buffer, "0")
+	So(err, ShouldBeNil)
+
+	return p
+}
+
+func TestFlowKey(t *testing.T) {
+
+	Convey("Given a tcp packet", t, func() {
+
+		p := newTestPacket("10.1.1.1", "10.2.2.2", 4000, 80)
+
+		Convey("When I compute the key of its flow", func() {
+			key, err := flowKey(p)
+
+			Convey("Then the key should be in network order", func() {
+				So(err, ShouldBeNil)
+				So(key, ShouldResemble, []byte{10, 1, 1, 1, 10, 2, 2, 2, 0x0f, 0xa0, 0, 80, packet.IPProtocolTCP, 0, 0, 0})
+			})
+
+			Convey("Then the reverse key should swap the addresses and the ports", func() {
+				So(reverseFlowKey(key), ShouldResemble, []byte{10, 2, 2, 2, 10, 1, 1, 1, 0, 80, 0x0f, 0xa0, packet.IPProtocolTCP, 0, 0, 0})
+				So(reverseFlowKey(reverseFlowKey(key)), ShouldResemble, key)
+			})
+		})
+
+		Convey("When the packet is not tcp or udp", func() {
+			p.IPProto = 1
+			_, err := flowKey(p)
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the packet is an ipv6 packet", func() {
+			p.SourceAddress = net.ParseIP("2001:db8::1")
+			_, err := flowKey(p)
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestFlowProgram(t *testing.T) {
+
+	Convey("Given I assemble the flow program", t, func() {
+
+		code, err := flowProgram(7, 0x1111)
+		So(err, ShouldBeNil)
+		So(len(code)%instructionSize, ShouldEqual, 0)
+
+		instructions := [][]byte{}
+		for i := 0; i < len(code); i += instructionSize {
+			instructions = append(instructions, code[i:i+instructionSize])
+		}
+
+		Convey("Then the program should exit with TC_ACT_OK", func() {
+			last := instructions[len(instructions)-1]
+			So(last[0], ShouldEqual, opExit)
+			previous := instructions[len(instructions)-2]
+			So(previous[0], ShouldEqual, opMovImm)
+			So(binary.LittleEndian.Uint32(previous[4:8]), ShouldEqual, tcActOK)
+		})
+
+		Convey("Then the map fd should be loaded as a pseudo map fd", func() {
+			found := false
+			for _, insn := range instructions {
+				if insn[0] == opLdImm64 {
+					found = true
+					So(insn[1], ShouldEqual, pseudoMapFD<<4|r1)
+					So(binary.LittleEndian.Uint32(insn[4:8]), ShouldEqual, 7)
+				}
+			}
+			So(found, ShouldBeTrue)
+		})
+
+		Convey("Then the mark should be written in the context", func() {
+			found := false
+			for i, insn := range instructions {
+				if insn[0] == opStxW && insn[1] == r1<<4|r6 {
+					found = true
+					So(int16(binary.LittleEndian.Uint16(insn[2:4])), ShouldEqual, skbMarkOffset)
+					So(binary.LittleEndian.Uint32(instructions[i-1][4:8]), ShouldEqual, 0x1111)
+				}
+			}
+			So(found, ShouldBeTrue)
+		})
+
+		Convey("Then the tcp packets with the SYN flag should not be marked", func() {
+			found := false
+			for i, insn := range instructions {
+				if insn[0] == opLdxB && insn[1] == r2<<4|r4 && int16(binary.LittleEndian.Uint16(insn[2:4])) == tcpFlagsOffset {
+					found = true
+					So(instructions[i+1][0], ShouldEqual, opAndImm)
+					So(binary.LittleEndian.Uint32(instructions[i+1][4:8]), ShouldEqual, tcpSynFlag)
+					So(instructions[i+2][0], ShouldEqual, opJneImm)
+					target := i + 3 + int(int16(binary.LittleEndian.Uint16(instructions[i+2][2:4])))
+					So(target, ShouldEqual, len(instructions)-2)
+				}
+			}
+			So(found, ShouldBeTrue)
+		})
+
+		Convey("Then all the jumps should land in the program", func() {
+			for i, insn := range instructions {
+				switch insn[0] {
+				case opJeqImm, opJneImm, opJgtReg:
+					target := i + 1 + int(int16(binary.LittleEndian.Uint16(insn[2:4])))
+					So(target, ShouldBeGreaterThan, i)
+					So(target, ShouldBeLessThan, len(instructions))
+				}
+			}
+		})
+	})
+
+	Convey("Given a program that jumps to an unknown label", t, func() {
+		a := newAssembler()
+		a.jump(opJeqImm, r0, 0, 0, "unknown")
+		a.emit(opExit, 0, 0, 0, 0)
+
+		Convey("Then the assembly should fail", func() {
+			_, err := a.assemble()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
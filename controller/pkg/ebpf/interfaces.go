@@ -0,0 +1,17 @@
+package ebpf
+
+import "github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
+
+// BPFModule is the kernel fast path of the datapath. It holds the map of
+// authorized flows that the eBPF programs consult before the packets reach
+// the NFQUEUE rules.
+type BPFModule interface {
+	// Attach attaches the programs to the ingress hook of the interfaces.
+	Attach(interfaces []string) error
+	// CreateFlow authorizes the flow of the packet in both directions.
+	CreateFlow(p *packet.Packet) error
+	// RemoveFlow removes the flow of the packet in both directions.
+	RemoveFlow(p *packet.Packet) error
+	// Cleanup detaches the programs and releases the kernel objects.
+	Cleanup()
+}
@@ -0,0 +1,205 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Opcodes of the eBPF instruction set used by the flow program
+const (
+	opLdxW    = 0x61
+	opLdxH    = 0x69
+	opLdxB    = 0x71
+	opStW     = 0x62
+	opStxW    = 0x63
+	opStxB    = 0x73
+	opAddImm  = 0x07
+	opAndImm  = 0x57
+	opMovReg  = 0xbf
+	opMovImm  = 0xb7
+	opLdImm64 = 0x18
+	opJeqImm  = 0x15
+	opJneImm  = 0x55
+	opJgtReg  = 0x2d
+	opCall    = 0x85
+	opExit    = 0x95
+)
+
+// Registers of the eBPF virtual machine
+const (
+	r0 = iota
+	r1
+	r2
+	r3
+	r4
+	r5
+	r6
+	r7
+	r8
+	r9
+	r10
+)
+
+const (
+	// pseudoMapFD tells the kernel that the immediate of a 64 bit load is a map fd
+	pseudoMapFD = 1
+	// helperMapLookupElem is the id of the bpf_map_lookup_elem helper
+	helperMapLookupElem = 1
+	// tcActOK lets the packet continue its way in the stack
+	tcActOK = 0
+
+	// Offsets of the fields of struct __sk_buff
+	skbMarkOffset    = 8
+	skbDataOffset    = 76
+	skbDataEndOffset = 80
+
+	// Offsets in the frame. The program only handles untagged ethernet
+	// frames with an IPv4 header without options.
+	ethTypeOffset   = 12
+	ipVerIHLOffset  = 14
+	ipFragOffset    = 20
+	ipProtoOffset   = 23
+	ipSrcOffset     = 26
+	ipDstOffset     = 30
+	l4PortsOffset   = 34
+	minFrameLength  = 38
+	tcpFlagsOffset  = 47
+	tcpFrameLength  = 48
+	tcpSynFlag      = 0x02
+	ipv4VerIHL      = 0x45
+	instructionSize = 8
+)
+
+// instruction is a single eBPF instruction. Jumps refer to a label that
+// is resolved into an offset when the program is assembled.
+type instruction struct {
+	opcode uint8
+	dst    uint8
+	src    uint8
+	offset int16
+	imm    int32
+	label  string
+}
+
+// assembler builds an eBPF program
+type assembler struct {
+	instructions []instruction
+	labels       map[string]int
+}
+
+func newAssembler() *assembler {
+	return &assembler{
+		instructions: []instruction{},
+		labels:       map[string]int{},
+	}
+}
+
+// emit appends an instruction to the program
+func (a *assembler) emit(opcode, dst, src uint8, offset int16, imm int32) {
+	a.instructions = append(a.instructions, instruction{opcode: opcode, dst: dst, src: src, offset: offset, imm: imm})
+}
+
+// jump appends a conditional jump to the label
+func (a *assembler) jump(opcode, dst, src uint8, imm int32, label string) {
+	a.instructions = append(a.instructions, instruction{opcode: opcode, dst: dst, src: src, imm: imm, label: label})
+}
+
+// label marks the position of the next instruction
+func (a *assembler) label(name string) {
+	a.labels[name] = len(a.instructions)
+}
+
+// assemble resolves the labels and returns the bytecode. The instructions
+// are encoded in little endian, which is the byte order of the platforms
+// the enforcer runs on.
+func (a *assembler) assemble() ([]byte, error) {
+
+	code := make([]byte, len(a.instructions)*instructionSize)
+
+	for pos, insn := range a.instructions {
+		if insn.label != "" {
+			target, ok := a.labels[insn.label]
+			if !ok {
+				return nil, fmt.Errorf("unknown label %s", insn.label)
+			}
+			insn.offset = int16(target - pos - 1)
+		}
+
+		b := code[pos*instructionSize : (pos+1)*instructionSize]
+		b[0] = insn.opcode
+		b[1] = insn.src<<4 | insn.dst
+		binary.LittleEndian.PutUint16(b[2:4], uint16(insn.offset))
+		binary.LittleEndian.PutUint32(b[4:8], uint32(insn.imm))
+	}
+
+	return code, nil
+}
+
+// flowProgram returns the TC classifier that sets the mark on the packets
+// of the flows found in the map, except the TCP packets with the SYN flag.
+func flowProgram(mapFD int, mark uint32) ([]byte, error) {
+
+	a := newAssembler()
+
+	// Keep the context and check that the headers are in the linear data
+	a.emit(opMovReg, r6, r1, 0, 0)
+	a.emit(opLdxW, r2, r6, skbDataOffset, 0)
+	a.emit(opLdxW, r3, r6, skbDataEndOffset, 0)
+	a.emit(opMovReg, r4, r2, 0, 0)
+	a.emit(opAddImm, r4, 0, 0, minFrameLength)
+	a.jump(opJgtReg, r4, r3, 0, "pass")
+
+	// IPv4 without options. The ethernet type is read in little endian.
+	a.emit(opLdxH, r4, r2, ethTypeOffset, 0)
+	a.jump(opJneImm, r4, 0, 0x0008, "pass")
+	a.emit(opLdxB, r4, r2, ipVerIHLOffset, 0)
+	a.jump(opJneImm, r4, 0, ipv4VerIHL, "pass")
+
+	// Fragments do not carry the ports
+	a.emit(opLdxH, r4, r2, ipFragOffset, 0)
+	a.emit(opAndImm, r4, 0, 0, 0xff3f)
+	a.jump(opJneImm, r4, 0, 0, "pass")
+
+	// TCP or UDP
+	a.emit(opLdxB, r5, r2, ipProtoOffset, 0)
+	a.jump(opJeqImm, r5, 0, 17, "key")
+	a.jump(opJneImm, r5, 0, 6, "pass")
+
+	// The SYN and SYN-ACK packets are never marked. A new connection on the
+	// tuple of an offloaded flow must be authorized by the datapath.
+	a.emit(opMovReg, r4, r2, 0, 0)
+	a.emit(opAddImm, r4, 0, 0, tcpFrameLength)
+	a.jump(opJgtReg, r4, r3, 0, "pass")
+	a.emit(opLdxB, r4, r2, tcpFlagsOffset, 0)
+	a.emit(opAndImm, r4, 0, 0, tcpSynFlag)
+	a.jump(opJneImm, r4, 0, 0, "pass")
+
+	// Build the key on the stack
+	a.label("key")
+	a.emit(opStW, r10, 0, -4, 0)
+	a.emit(opStxB, r10, r5, -4, 0)
+	a.emit(opLdxW, r4, r2, ipSrcOffset, 0)
+	a.emit(opStxW, r10, r4, -16, 0)
+	a.emit(opLdxW, r4, r2, ipDstOffset, 0)
+	a.emit(opStxW, r10, r4, -12, 0)
+	a.emit(opLdxW, r4, r2, l4PortsOffset, 0)
+	a.emit(opStxW, r10, r4, -8, 0)
+
+	// Lookup the flow
+	a.emit(opLdImm64, r1, pseudoMapFD, 0, int32(mapFD))
+	a.emit(0, 0, 0, 0, 0)
+	a.emit(opMovReg, r2, r10, 0, 0)
+	a.emit(opAddImm, r2, 0, 0, -flowKeySize)
+	a.emit(opCall, 0, 0, 0, helperMapLookupElem)
+	a.jump(opJeqImm, r0, 0, 0, "pass")
+
+	// Authorized flow. Mark the packet so that it is accepted by iptables.
+	a.emit(opMovImm, r1, 0, 0, int32(mark))
+	a.emit(opStxW, r6, r1, skbMarkOffset, 0)
+
+	a.label("pass")
+	a.emit(opMovImm, r0, 0, 0, tcActOK)
+	a.emit(opExit, 0, 0, 0, 0)
+
+	return a.assemble()
+}
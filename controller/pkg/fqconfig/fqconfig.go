@@ -36,6 +36,12 @@ type FilterQueue struct {
 	ApplicationQueuesSvcStr string
 	// ApplicationQueuesSynAckStr is the queue string for application synack packets
 	ApplicationQueuesSynAckStr string
+	// FastPath enables the eBPF fast path. Packets of authorized flows are
+	// marked with MarkValue in the kernel and accepted before the NFQUEUE rules
+	FastPath bool
+	// FastPathInterfaces are the interfaces where the fast path is attached.
+	// All the interfaces are used if it is empty
+	FastPathInterfaces []string
 }
 
 // NewFilterQueueWithDefaults return a default filter queue config
@@ -101,6 +107,11 @@ func (f *FilterQueue) GetMarkValue() int {
 	return f.MarkValue
 }
 
+// IsFastPathEnabled returns true if the eBPF fast path is enabled
+func (f *FilterQueue) IsFastPathEnabled() bool {
+	return f.FastPath
+}
+
 // GetNetworkQueueStart returns start of network queues to be used by iptables action
 func (f *FilterQueue) GetNetworkQueueStart() uint16 {
 	return f.NetworkQueue
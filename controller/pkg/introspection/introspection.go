@@ -0,0 +1,64 @@
+// Package introspection holds the read-only views of the state of the
+// enforcers. They are snapshots that are safe to hand over to callers and
+// to send over RPC. Nothing in these types refers back to the datapath.
+package introspection
+
+import (
+	"github.com/aporeto-inc/trireme-lib/common"
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// Protocols of the connections
+const (
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+)
+
+// Directions of the connections
+const (
+	// DirectionApplication is a connection initiated by the PU
+	DirectionApplication = "application"
+	// DirectionNetwork is a connection initiated by a remote endpoint
+	DirectionNetwork = "network"
+)
+
+// PUState is a snapshot of a processing unit enforced by the datapath.
+type PUState struct {
+	ContextID    string
+	ManagementID string
+	Type         common.PUType
+	Mark         string
+	Ports        []string
+	ProxyPort    string
+	Identity     []string
+	Annotations  []string
+	Scopes       []string
+
+	// The rules the datapath compiled for this PU
+	ApplicationACLs  policy.IPRuleList
+	NetworkACLs      policy.IPRuleList
+	TransmitterRules policy.TagSelectorList
+	ReceiverRules    policy.TagSelectorList
+}
+
+// ConnectionState is a snapshot of a connection tracked by the datapath.
+type ConnectionState struct {
+	// Flow is the flow as seen by the tracker: source ip, destination ip,
+	// source port and destination port.
+	Flow      string
+	Protocol  string
+	Direction string
+	// Reply is true if the flow is the reply direction of the connection
+	Reply bool
+	State string
+
+	// Authentication information
+	RemoteContextID   string
+	RemoteIP          string
+	RemotePort        string
+	ServiceConnection bool
+
+	// The policy that was matched for the connection, if any
+	PolicyID string
+	Action   string
+}
@@ -0,0 +1,336 @@
+// Package metrics provides the counters, gauges and histograms of the
+// enforcers and exposes them in the Prometheus text format. Metrics are
+// registered in a Registry that can also hold the metrics forwarded by the
+// remote enforcers.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Type is the type of a metric
+type Type string
+
+// Metric types
+const (
+	TypeCounter   Type = "counter"
+	TypeGauge     Type = "gauge"
+	TypeHistogram Type = "histogram"
+)
+
+// SourceLabel is the label added to the metrics forwarded by a remote enforcer
+const SourceLabel = "enforcer"
+
+// Sample is the value of a metric for one set of label values
+type Sample struct {
+	LabelValues []string
+	Value       float64
+
+	// Histograms only
+	Count   uint64
+	Sum     float64
+	Buckets []uint64
+}
+
+// Family is a snapshot of a metric with all its samples. Families are plain
+// data so that they can be sent over RPC.
+type Family struct {
+	Name       string
+	Help       string
+	Type       Type
+	LabelNames []string
+	// Upper bounds of the buckets of histograms
+	Buckets []float64
+	Samples []*Sample
+}
+
+// Collector is implemented by everything that can be registered
+type Collector interface {
+	// Name returns the name of the metric
+	Name() string
+	// Collect returns a snapshot of the metric
+	Collect() *Family
+}
+
+// Registry holds the collectors of a process and the snapshots received
+// from remote enforcers.
+type Registry struct {
+	collectors map[string]Collector
+	remote     map[string][]*Family
+	sync.RWMutex
+}
+
+// DefaultRegistry is the registry of all the metrics defined by this package
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry creates a new empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: map[string]Collector{},
+		remote:     map[string][]*Family{},
+	}
+}
+
+// Register adds a collector to the registry
+func (r *Registry) Register(c Collector) error {
+
+	r.Lock()
+	defer r.Unlock()
+
+	if _, ok := r.collectors[c.Name()]; ok {
+		return fmt.Errorf("metric %s already registered", c.Name())
+	}
+
+	r.collectors[c.Name()] = c
+	return nil
+}
+
+// MustRegister adds collectors to the registry and panics on duplicates.
+// It is meant to be used at init time.
+func (r *Registry) MustRegister(collectors ...Collector) {
+	for _, c := range collectors {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Unregister removes a collector from the registry
+func (r *Registry) Unregister(name string) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.collectors, name)
+}
+
+// Gather returns a snapshot of the local metrics sorted by name
+func (r *Registry) Gather() []*Family {
+
+	r.RLock()
+	collectors := make([]Collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		collectors = append(collectors, c)
+	}
+	r.RUnlock()
+
+	families := make([]*Family, 0, len(collectors))
+	for _, c := range collectors {
+		families = append(families, c.Collect())
+	}
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].Name < families[j].Name
+	})
+
+	return families
+}
+
+// SetRemote stores the metrics received from a remote enforcer. They replace
+// any previous snapshot of the same source.
+func (r *Registry) SetRemote(source string, families []*Family) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.remote[source] = families
+}
+
+// RemoveRemote removes the metrics of a remote enforcer
+func (r *Registry) RemoveRemote(source string) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.remote, source)
+}
+
+// merged returns the local and remote metrics. The samples of the remote
+// enforcers are merged in the local families with an additional label.
+func (r *Registry) merged() []*Family {
+
+	families := r.Gather()
+
+	byName := map[string]*Family{}
+	for _, f := range families {
+		byName[f.Name] = f
+	}
+
+	r.RLock()
+	sources := make([]string, 0, len(r.remote))
+	for source := range r.remote {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	for _, source := range sources {
+		for _, rf := range r.remote[source] {
+			f, ok := byName[rf.Name]
+			if !ok {
+				f = &Family{
+					Name:       rf.Name,
+					Help:       rf.Help,
+					Type:       rf.Type,
+					LabelNames: rf.LabelNames,
+					Buckets:    rf.Buckets,
+				}
+				byName[rf.Name] = f
+				families = append(families, f)
+			}
+			f.Samples = append(f.Samples, remoteSamples(source, rf)...)
+		}
+	}
+	r.RUnlock()
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].Name < families[j].Name
+	})
+
+	return families
+}
+
+// remoteSamples returns the samples of a remote family with the source label
+func remoteSamples(source string, f *Family) []*Sample {
+
+	samples := make([]*Sample, 0, len(f.Samples))
+
+	for _, s := range f.Samples {
+		c := *s
+		c.LabelValues = append(append([]string{}, s.LabelValues...), source)
+		samples = append(samples, &c)
+	}
+
+	return samples
+}
+
+// Write writes all the metrics in the Prometheus text format
+func (r *Registry) Write(w io.Writer) error {
+
+	b := bufio.NewWriter(w)
+
+	for _, f := range r.merged() {
+		if err := writeFamily(b, f); err != nil {
+			return err
+		}
+	}
+
+	return b.Flush()
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	if err := r.Write(w); err != nil {
+		http.Error(w, fmt.Sprintf("unable to write metrics: %s", err), http.StatusInternalServerError)
+	}
+}
+
+// writeFamily writes a family in the text format
+func writeFamily(w *bufio.Writer, f *Family) error {
+
+	if len(f.Samples) == 0 {
+		return nil
+	}
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.Name, escapeHelp(f.Help), f.Name, f.Type); err != nil {
+		return err
+	}
+
+	for _, s := range f.Samples {
+
+		labels := f.LabelNames
+		if len(s.LabelValues) > len(labels) {
+			labels = append(append([]string{}, labels...), SourceLabel)
+		}
+
+		if f.Type != TypeHistogram {
+			if err := writeSample(w, f.Name, labels, s.LabelValues, "", "", s.Value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for i, bound := range f.Buckets {
+			if i >= len(s.Buckets) {
+				break
+			}
+			if err := writeSample(w, f.Name+"_bucket", labels, s.LabelValues, "le", formatFloat(bound), float64(s.Buckets[i])); err != nil {
+				return err
+			}
+		}
+
+		if err := writeSample(w, f.Name+"_bucket", labels, s.LabelValues, "le", "+Inf", float64(s.Count)); err != nil {
+			return err
+		}
+
+		if err := writeSample(w, f.Name+"_sum", labels, s.LabelValues, "", "", s.Sum); err != nil {
+			return err
+		}
+
+		if err := writeSample(w, f.Name+"_count", labels, s.LabelValues, "", "", float64(s.Count)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeSample writes one line of the text format
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraLabel, extraValue string, value float64) error {
+
+	pairs := []string{}
+	for i, l := range labels {
+		if i < len(values) {
+			pairs = append(pairs, l+"=\""+escapeLabel(values[i])+"\"")
+		}
+	}
+
+	if extraLabel != "" {
+		pairs = append(pairs, extraLabel+"=\""+extraValue+"\"")
+	}
+
+	line := name
+	if len(pairs) > 0 {
+		line += "{" + strings.Join(pairs, ",") + "}"
+	}
+
+	_, err := fmt.Fprintf(w, "%s %s\n", line, formatFloat(value))
+	return err
+}
+
+func formatFloat(v float64) string {
+
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpReplacer  = strings.NewReplacer("\\", "\\\\", "\n", "\\n")
+	labelReplacer = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\"", "\\\"")
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
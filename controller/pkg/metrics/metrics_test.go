@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testSizer int
+
+func (s testSizer) SizeOf() int { return int(s) }
+
+func TestMetrics(t *testing.T) {
+
+	Convey("Given a registry with a counter, a gauge, a histogram and a size gauge", t, func() {
+
+		r := NewRegistry()
+
+		counter := NewCounterVec("test_packets_total", "Test packets.", "direction")
+		gauge := NewGaugeVec("test_connections", "Test connections.", "proxy")
+		histogram := NewHistogramVec("test_duration_seconds", "Test durations.", []float64{0.1, 1}, "operation")
+		sizes := NewSizeGauge("test_cache_entries", "Test caches.", "cache")
+
+		r.MustRegister(counter, gauge, histogram, sizes)
+
+		Convey("When I register a metric twice", func() {
+			err := r.Register(NewCounterVec("test_packets_total", "Duplicate.", "direction"))
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I update the metrics", func() {
+			counter.Inc("network")
+			counter.Add(2, "network")
+			counter.Add(-1, "network")
+			counter.Inc("network", "extra")
+			gauge.Add(1, "tcp")
+			gauge.Add(1, "tcp")
+			gauge.Add(-1, "tcp")
+			histogram.Observe(0.05, "create")
+			histogram.Observe(0.5, "create")
+			histogram.Observe(5, "create")
+			sizes.Track("tracker", testSizer(3))
+
+			Convey("Then their values should be correct", func() {
+				So(counter.Value("network"), ShouldEqual, 3)
+				So(gauge.Value("tcp"), ShouldEqual, 1)
+				So(histogram.Count("create"), ShouldEqual, 3)
+			})
+
+			Convey("Then they should be written in the text format", func() {
+				buf := &bytes.Buffer{}
+				So(r.Write(buf), ShouldBeNil)
+
+				So(buf.String(), ShouldEqual, `# HELP test_cache_entries Test caches.
+# TYPE test_cache_entries gauge
+test_cache_entries{cache="tracker"} 3
+# HELP test_connections Test connections.
+# TYPE test_connections gauge
+test_connections{proxy="tcp"} 1
+# HELP test_duration_seconds Test durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{operation="create",le="0.1"} 1
+test_duration_seconds_bucket{operation="create",le="1"} 2
+test_duration_seconds_bucket{operation="create",le="+Inf"} 3
+test_duration_seconds_sum{operation="create"} 5.55
+test_duration_seconds_count{operation="create"} 3
+# HELP test_packets_total Test packets.
+# TYPE test_packets_total counter
+test_packets_total{direction="network"} 3
+`)
+			})
+
+			Convey("Then an untracked cache should not be reported", func() {
+				sizes.Untrack("tracker")
+				So(sizes.Collect().Samples, ShouldBeEmpty)
+			})
+
+			Convey("When I add the metrics of a remote enforcer", func() {
+				remote := NewRegistry()
+				remoteCounter := NewCounterVec("test_packets_total", "Test packets.", "direction")
+				remoteOnly := NewCounterVec("test_remote_total", "Remote \"only\".", "reason")
+				remote.MustRegister(remoteCounter, remoteOnly)
+				remoteCounter.Inc("application")
+				remoteOnly.Inc("a\"b")
+
+				r.SetRemote("pu1", remote.Gather())
+
+				Convey("Then they should be merged with the source label", func() {
+					buf := &bytes.Buffer{}
+					So(r.Write(buf), ShouldBeNil)
+
+					So(buf.String(), ShouldContainSubstring, `test_packets_total{direction="network"} 3
+test_packets_total{direction="application",enforcer="pu1"} 1
+`)
+					So(buf.String(), ShouldContainSubstring, `# HELP test_remote_total Remote "only".
+# TYPE test_remote_total counter
+test_remote_total{reason="a\"b",enforcer="pu1"} 1
+`)
+				})
+
+				Convey("Then they should be removed when the remote enforcer is removed", func() {
+					r.RemoveRemote("pu1")
+
+					buf := &bytes.Buffer{}
+					So(r.Write(buf), ShouldBeNil)
+					So(buf.String(), ShouldNotContainSubstring, "pu1")
+				})
+			})
+
+			Convey("Then they should be served over HTTP", func() {
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+				body, err := ioutil.ReadAll(w.Body)
+				So(err, ShouldBeNil)
+				So(w.Code, ShouldEqual, 200)
+				So(w.Header().Get("Content-Type"), ShouldStartWith, "text/plain")
+				So(string(body), ShouldContainSubstring, `test_packets_total{direction="network"} 3`)
+			})
+		})
+	})
+
+	Convey("Given the default registry", t, func() {
+
+		Convey("Then all the drop reasons should be reported", func() {
+			buf := &bytes.Buffer{}
+			So(DefaultRegistry.Write(buf), ShouldBeNil)
+
+			for _, reason := range DropReasons {
+				So(buf.String(), ShouldContainSubstring, `trireme_datapath_drops_total{protocol="tcp",reason="`+reason+`"}`)
+			}
+		})
+	})
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// ListenAndServe serves the metrics of the registry on the /metrics path of
+// the given address until the context is done.
+func ListenAndServe(ctx context.Context, address string, r *Registry) error {
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s: %s", address, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+
+	server := &http.Server{Handler: mux}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			zap.L().Error("Metrics server stopped", zap.Error(err))
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		server.Close() // nolint
+	}()
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"github.com/aporeto-inc/trireme-lib/collector"
+)
+
+// Label values of the metrics
+const (
+	DirectionApplication = "application"
+	DirectionNetwork     = "network"
+
+	VerdictAccept = "accept"
+	VerdictDrop   = "drop"
+
+	OperationCreate = "create"
+	OperationVerify = "verify"
+
+	PacketSyn    = "syn"
+	PacketSynAck = "synack"
+	PacketAck    = "ack"
+
+	ProxyTCP  = "tcp"
+	ProxyHTTP = "http"
+)
+
+// DropReasons are all the reasons for which the datapath drops flows
+var DropReasons = []string{
+	collector.MissingToken,
+	collector.InvalidToken,
+	collector.InvalidFormat,
+	collector.InvalidContext,
+	collector.InvalidConnection,
+	collector.InvalidState,
+	collector.InvalidNonse,
+	collector.RevokedCredentials,
+	collector.PinnedKeyChanged,
+	collector.PolicyDrop,
+}
+
+var (
+	// QueuePackets counts the packets processed by the datapath per NFQUEUE
+	QueuePackets = NewCounterVec(
+		"trireme_nfqueue_packets_total",
+		"Packets processed by the datapath per NFQUEUE.",
+		"direction", "queue", "verdict",
+	)
+
+	// Drops counts the flows dropped by the datapath per reason
+	Drops = NewCounterVec(
+		"trireme_datapath_drops_total",
+		"Flows dropped by the datapath per reason.",
+		"protocol", "reason",
+	)
+
+	// TokenLatency is the time spent to create and verify tokens
+	TokenLatency = NewHistogramVec(
+		"trireme_token_duration_seconds",
+		"Time spent to create and verify authorization tokens.",
+		DefaultLatencyBuckets,
+		"operation", "packet",
+	)
+
+	// TokenErrors counts the tokens that could not be created or verified
+	TokenErrors = NewCounterVec(
+		"trireme_token_errors_total",
+		"Authorization tokens that could not be created or verified.",
+		"operation", "packet",
+	)
+
+	// CacheEntries is the number of entries of the caches of the datapath
+	CacheEntries = NewSizeGauge(
+		"trireme_cache_entries",
+		"Number of entries of the caches of the datapath.",
+		"cache",
+	)
+
+	// RuleLatency is the time spent to program the rules of a PU
+	RuleLatency = NewHistogramVec(
+		"trireme_supervisor_rules_duration_seconds",
+		"Time spent to program the rules of a processing unit.",
+		DefaultLatencyBuckets,
+		"operation", "result",
+	)
+
+	// ProxyConnections is the number of connections open in the proxies
+	ProxyConnections = NewGaugeVec(
+		"trireme_proxy_connections",
+		"Connections open in the application proxies.",
+		"proxy",
+	)
+
+	// ProxyConnectionsTotal counts the connections handled by the proxies
+	ProxyConnectionsTotal = NewCounterVec(
+		"trireme_proxy_connections_total",
+		"Connections and requests handled by the application proxies.",
+		"proxy", "direction",
+	)
+
+	// ProxyAuthFailures counts the connections rejected by the proxies
+	// because the peer could not be authorized
+	ProxyAuthFailures = NewCounterVec(
+		"trireme_proxy_auth_failures_total",
+		"Connections and requests rejected by the application proxies because of authorization failures.",
+		"proxy",
+	)
+
+	// SecretsPreviousAccepted counts the peers accepted with the previous
+	// secrets during a rotation
+	SecretsPreviousAccepted = NewCounterVec(
+		"trireme_secrets_previous_accepted_total",
+		"Peers accepted with the previous secrets during a rotation.",
+	)
+)
+
+func init() {
+
+	for _, reason := range DropReasons {
+		Drops.Add(0, "tcp", reason)
+		Drops.Add(0, "udp", reason)
+	}
+
+	DefaultRegistry.MustRegister(
+		QueuePackets,
+		Drops,
+		TokenLatency,
+		TokenErrors,
+		CacheEntries,
+		RuleLatency,
+		ProxyConnections,
+		ProxyConnectionsTotal,
+		ProxyAuthFailures,
+		SecretsPreviousAccepted,
+	)
+}
@@ -0,0 +1,355 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the buckets in seconds of the latency histograms
+var DefaultLatencyBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// desc holds the description of a metric
+type desc struct {
+	name       string
+	help       string
+	labelNames []string
+}
+
+// Name returns the name of the metric
+func (d *desc) Name() string {
+	return d.name
+}
+
+func (d *desc) family(t Type) *Family {
+	return &Family{
+		Name:       d.name,
+		Help:       d.help,
+		Type:       t,
+		LabelNames: append([]string{}, d.labelNames...),
+	}
+}
+
+// key returns the key of a set of label values. It returns false if the
+// number of values does not match the labels of the metric.
+func (d *desc) key(labelValues []string) (string, bool) {
+
+	if len(labelValues) != len(d.labelNames) {
+		return "", false
+	}
+
+	return strings.Join(labelValues, "\xff"), true
+}
+
+// value is the value of a counter or a gauge for a set of label values
+type value struct {
+	labelValues []string
+	value       float64
+}
+
+// vec holds the values of a counter or a gauge
+type vec struct {
+	desc
+	values map[string]*value
+	sync.Mutex
+}
+
+func newVec(name, help string, labelNames []string) vec {
+	return vec{
+		desc:   desc{name: name, help: help, labelNames: labelNames},
+		values: map[string]*value{},
+	}
+}
+
+func (v *vec) add(delta float64, set bool, labelValues []string) {
+
+	k, ok := v.key(labelValues)
+	if !ok {
+		return
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	val, ok := v.values[k]
+	if !ok {
+		val = &value{labelValues: append([]string{}, labelValues...)}
+		v.values[k] = val
+	}
+
+	if set {
+		val.value = delta
+		return
+	}
+
+	val.value += delta
+}
+
+func (v *vec) get(labelValues []string) float64 {
+
+	k, ok := v.key(labelValues)
+	if !ok {
+		return 0
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	if val, ok := v.values[k]; ok {
+		return val.value
+	}
+
+	return 0
+}
+
+func (v *vec) collect(t Type) *Family {
+
+	f := v.family(t)
+
+	v.Lock()
+	for _, val := range v.values {
+		f.Samples = append(f.Samples, &Sample{
+			LabelValues: append([]string{}, val.labelValues...),
+			Value:       val.value,
+		})
+	}
+	v.Unlock()
+
+	sortSamples(f.Samples)
+
+	return f
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	vec
+}
+
+// NewCounterVec creates a new counter
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{vec: newVec(name, help, labelNames)}
+}
+
+// Inc increments the counter of the label values by one
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.add(1, false, labelValues)
+}
+
+// Add adds a positive value to the counter of the label values
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	c.add(delta, false, labelValues)
+}
+
+// Value returns the value of the counter of the label values
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	return c.get(labelValues)
+}
+
+// Collect implements the Collector interface
+func (c *CounterVec) Collect() *Family {
+	return c.collect(TypeCounter)
+}
+
+// GaugeVec is a gauge partitioned by labels
+type GaugeVec struct {
+	vec
+}
+
+// NewGaugeVec creates a new gauge
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{vec: newVec(name, help, labelNames)}
+}
+
+// Set sets the gauge of the label values
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.add(v, true, labelValues)
+}
+
+// Add adds a value to the gauge of the label values
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.add(delta, false, labelValues)
+}
+
+// Value returns the value of the gauge of the label values
+func (g *GaugeVec) Value(labelValues ...string) float64 {
+	return g.get(labelValues)
+}
+
+// Collect implements the Collector interface
+func (g *GaugeVec) Collect() *Family {
+	return g.collect(TypeGauge)
+}
+
+// histogramValue is the value of a histogram for a set of label values
+type histogramValue struct {
+	labelValues []string
+	count       uint64
+	sum         float64
+	buckets     []uint64
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	desc
+	buckets []float64
+	values  map[string]*histogramValue
+	sync.Mutex
+}
+
+// NewHistogramVec creates a new histogram with the given bucket upper bounds
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+
+	b := append([]float64{}, buckets...)
+	sort.Float64s(b)
+
+	return &HistogramVec{
+		desc:    desc{name: name, help: help, labelNames: labelNames},
+		buckets: b,
+		values:  map[string]*histogramValue{},
+	}
+}
+
+// Observe adds an observation to the histogram of the label values
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+
+	k, ok := h.key(labelValues)
+	if !ok {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	val, ok := h.values[k]
+	if !ok {
+		val = &histogramValue{
+			labelValues: append([]string{}, labelValues...),
+			buckets:     make([]uint64, len(h.buckets)),
+		}
+		h.values[k] = val
+	}
+
+	val.count++
+	val.sum += v
+
+	for i, bound := range h.buckets {
+		if v <= bound {
+			val.buckets[i]++
+		}
+	}
+}
+
+// ObserveDuration adds the time elapsed since start in seconds
+func (h *HistogramVec) ObserveDuration(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+// Count returns the number of observations of the label values
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+
+	k, ok := h.key(labelValues)
+	if !ok {
+		return 0
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	if val, ok := h.values[k]; ok {
+		return val.count
+	}
+
+	return 0
+}
+
+// Collect implements the Collector interface
+func (h *HistogramVec) Collect() *Family {
+
+	f := h.family(TypeHistogram)
+	f.Buckets = append([]float64{}, h.buckets...)
+
+	h.Lock()
+	for _, val := range h.values {
+		f.Samples = append(f.Samples, &Sample{
+			LabelValues: append([]string{}, val.labelValues...),
+			Count:       val.count,
+			Sum:         val.sum,
+			Buckets:     append([]uint64{}, val.buckets...),
+		})
+	}
+	h.Unlock()
+
+	sortSamples(f.Samples)
+
+	return f
+}
+
+// Sizer is implemented by the caches whose size is reported
+type Sizer interface {
+	SizeOf() int
+}
+
+// SizeGauge is a gauge that reports the size of named caches when the
+// metrics are gathered
+type SizeGauge struct {
+	desc
+	caches map[string]Sizer
+	sync.Mutex
+}
+
+// NewSizeGauge creates a new gauge of cache sizes. The name of the caches is
+// reported with the given label.
+func NewSizeGauge(name, help, label string) *SizeGauge {
+	return &SizeGauge{
+		desc:   desc{name: name, help: help, labelNames: []string{label}},
+		caches: map[string]Sizer{},
+	}
+}
+
+// Track adds a cache to the gauge. It replaces any cache with the same name.
+func (g *SizeGauge) Track(name string, c Sizer) {
+
+	g.Lock()
+	defer g.Unlock()
+
+	g.caches[name] = c
+}
+
+// Untrack removes a cache from the gauge
+func (g *SizeGauge) Untrack(name string) {
+
+	g.Lock()
+	defer g.Unlock()
+
+	delete(g.caches, name)
+}
+
+// Collect implements the Collector interface
+func (g *SizeGauge) Collect() *Family {
+
+	f := g.family(TypeGauge)
+
+	g.Lock()
+	for name, c := range g.caches {
+		f.Samples = append(f.Samples, &Sample{
+			LabelValues: []string{name},
+			Value:       float64(c.SizeOf()),
+		})
+	}
+	g.Unlock()
+
+	sortSamples(f.Samples)
+
+	return f
+}
+
+// sortSamples sorts samples by label values so that the output is stable
+func sortSamples(samples []*Sample) {
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].LabelValues, "\xff") < strings.Join(samples[j].LabelValues, "\xff")
+	})
+}
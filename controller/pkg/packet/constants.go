@@ -8,6 +8,24 @@ const (
 	minIPHdrSize = 20
 
 	minIPHdrWords = (minIPHdrSize / 4)
+
+	// minUDPPacketLen is the min udp packet size
+	minUDPPacketLen = 28
+
+	// minIPv6HdrSize is the size of the IPv6 header without extension headers
+	minIPv6HdrSize = 40
+
+	// minTCPHdrSize is the size of the TCP header without options
+	minTCPHdrSize = 20
+)
+
+// IP Versions
+const (
+	// IPVersion4 is the version of IPv4 packets
+	IPVersion4 = 4
+
+	// IPVersion6 is the version of IPv6 packets
+	IPVersion6 = 6
 )
 
 // IP Header field position constants
@@ -34,6 +52,21 @@ const (
 	ipDestAddrPos = 16
 )
 
+// IPv6 Header field position constants
+const (
+	// ipv6PayloadLengthPos is location of the IPv6 payload length
+	ipv6PayloadLengthPos = 4
+
+	// ipv6NextHeaderPos is location of the IPv6 next header
+	ipv6NextHeaderPos = 6
+
+	// ipv6SourceAddrPos is location of source IPv6 address
+	ipv6SourceAddrPos = 8
+
+	// ipv6DestAddrPos is location of destination IPv6 address
+	ipv6DestAddrPos = 24
+)
+
 // IP Protocol numbers
 const (
 	// IPProtocolTCP defines the constant for UDP protocol number
@@ -46,30 +79,33 @@ const (
 // IP Header masks
 const (
 	ipHdrLenMask = 0xF
+
+	ipVersionShift = 4
 )
 
-// TCP Header field position constants
+// TCP Header field position constants. Positions are relative to the
+// beginning of the TCP header.
 const (
 	// tcpSourcePortPos is the location of source port
-	tcpSourcePortPos = 20
+	tcpSourcePortPos = 0
 
 	// tcpDestPortPos is the location of destination port
-	tcpDestPortPos = 22
+	tcpDestPortPos = 2
 
 	// tcpSeqPos is the location of seq
-	tcpSeqPos = 24
+	tcpSeqPos = 4
 
 	// tcpAckPos is the location of seq
-	tcpAckPos = 28
+	tcpAckPos = 8
 
 	// tcpDataOffsetPos is the location of the TCP data offset
-	tcpDataOffsetPos = 32
+	tcpDataOffsetPos = 12
 
 	//tcpFlagsOfsetPos is the location of the TCP flags
-	tcpFlagsOffsetPos = 33
+	tcpFlagsOffsetPos = 13
 
 	// TCPChecksumPos is the location of TCP checksum
-	TCPChecksumPos = 36
+	TCPChecksumPos = 16
 )
 
 // TCP Header masks
@@ -96,6 +132,45 @@ const (
 	TCPPshMask = 0x8
 )
 
+// UDP Header field position constants. Positions are relative to the
+// beginning of the UDP header.
+const (
+	// udpHeaderLen is the length of the UDP header
+	udpHeaderLen = 8
+
+	// udpLengthPos is the location of the UDP length
+	udpLengthPos = 4
+
+	// UDPChecksumPos is the location of UDP checksum
+	UDPChecksumPos = 6
+)
+
+// UDP Authorization related constants. Datagrams that carry an authorization
+// token have their payload prefixed with a header made of an 8 byte marker,
+// a 1 byte packet type, a reserved byte and the 2 byte length of the token.
+const (
+	// UDPAuthMarker identifies datagrams that carry an authorization header
+	UDPAuthMarker = "\xa7TRIREME"
+
+	// UDPAuthHeaderLen is the length of the authorization header
+	UDPAuthHeaderLen = 12
+
+	// udpAuthMarkerLen is the length of the marker
+	udpAuthMarkerLen = len(UDPAuthMarker)
+
+	// udpAuthTypePos is the location of the packet type in the authorization header
+	udpAuthTypePos = 8
+
+	// udpAuthTokenLenPos is the location of the token length in the authorization header
+	udpAuthTokenLenPos = 10
+
+	// UDPSynPacket is the type of the datagrams sent by the initiator of a flow
+	UDPSynPacket = uint8(1)
+
+	// UDPSynAckPacket is the type of the datagrams sent in response by the receiver of a flow
+	UDPSynAckPacket = uint8(2)
+)
+
 // TCP Options Related constants
 const (
 	// TCPAuthenticationOption is the option number will be using
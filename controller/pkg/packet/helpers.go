@@ -8,6 +8,7 @@ import (
 	"strconv"
 
 	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 // Helpher functions for the package, mainly for debugging and validation
@@ -27,6 +28,11 @@ func (p *Packet) VerifyIPChecksum() bool {
 // packet with the value.
 func (p *Packet) UpdateIPChecksum() {
 
+	// IPv6 headers have no checksum
+	if p.IsIPv6() {
+		return
+	}
+
 	p.ipChecksum = p.computeIPChecksum()
 
 	binary.BigEndian.PutUint16(p.Buffer[ipChecksumPos:ipChecksumPos+2], p.ipChecksum)
@@ -48,12 +54,31 @@ func (p *Packet) UpdateTCPChecksum() {
 
 	p.TCPChecksum = p.computeTCPChecksum()
 
-	binary.BigEndian.PutUint16(p.Buffer[TCPChecksumPos:TCPChecksumPos+2], p.TCPChecksum)
+	binary.BigEndian.PutUint16(p.Buffer[p.l4BeginPos+TCPChecksumPos:p.l4BeginPos+TCPChecksumPos+2], p.TCPChecksum)
+}
+
+// VerifyUDPChecksum returns true if the UDP header checksum is correct
+// for this packet, false otherwise. Note that the checksum is not
+// modified.
+func (p *Packet) VerifyUDPChecksum() bool {
+
+	sum := p.computeUDPChecksum()
+
+	return sum == p.UDPChecksum
+}
+
+// UpdateUDPChecksum computes the UDP header checksum and updates the
+// packet with the value.
+func (p *Packet) UpdateUDPChecksum() {
+
+	p.UDPChecksum = p.computeUDPChecksum()
+
+	binary.BigEndian.PutUint16(p.Buffer[p.l4BeginPos+UDPChecksumPos:p.l4BeginPos+UDPChecksumPos+2], p.UDPChecksum)
 }
 
 // UpdateTCPFlags
 func (p *Packet) updateTCPFlags(tcpFlags uint8) {
-	p.Buffer[tcpFlagsOffsetPos] = tcpFlags
+	p.Buffer[p.l4BeginPos+tcpFlagsOffsetPos] = tcpFlags
 }
 
 // ConvertAcktoFinAck function removes the data from the packet
@@ -80,7 +105,14 @@ func (p *Packet) String() string {
 	var buf bytes.Buffer
 	buf.WriteString("(error)")
 
-	header, err := ipv4.ParseHeader(p.Buffer)
+	var header fmt.Stringer
+	var err error
+
+	if p.IsIPv6() {
+		header, err = ipv6.ParseHeader(p.Buffer)
+	} else {
+		header, err = ipv4.ParseHeader(p.Buffer)
+	}
 
 	if err == nil {
 		buf.Reset()
@@ -100,6 +132,10 @@ func (p *Packet) String() string {
 // Computes the IP header checksum. The packet is not modified.
 func (p *Packet) computeIPChecksum() uint16 {
 
+	if p.IsIPv6() {
+		return 0
+	}
+
 	// IP packet checksum is computed with the checksum value set to zero
 	binary.BigEndian.PutUint16(p.Buffer[ipChecksumPos:ipChecksumPos+2], uint16(0))
 
@@ -115,39 +151,78 @@ func (p *Packet) computeIPChecksum() uint16 {
 // Computes the TCP header checksum. The packet is not modified.
 func (p *Packet) computeTCPChecksum() uint16 {
 
-	var pseudoHeaderLen uint16 = 12
 	tcpSize := uint16(len(p.Buffer)) - p.l4BeginPos
-	bufLen := pseudoHeaderLen + tcpSize
-	buf := make([]byte, bufLen)
+	buf := p.pseudoHeader(IPProtocolTCP, uint32(tcpSize)+uint32(len(p.tcpData)+len(p.tcpOptions)))
+	pseudoHeaderLen := len(buf)
 
-	// Construct the pseudo-header for TCP checksum computation:
+	// The TCP buffer (real header + payload)
+	buf = append(buf, p.Buffer[p.l4BeginPos:]...)
 
-	// bytes 0-3: Source IP address
-	copy(buf[0:4], p.Buffer[ipSourceAddrPos:ipSourceAddrPos+4])
+	// Set current checksum to zero (in buf, not changing packet)
+	buf[pseudoHeaderLen+TCPChecksumPos] = 0
+	buf[pseudoHeaderLen+TCPChecksumPos+1] = 0
 
-	// bytes 4-7: Destination IP address
-	copy(buf[4:8], p.Buffer[ipDestAddrPos:ipDestAddrPos+4])
+	buf = append(buf, p.tcpOptions...)
+	buf = append(buf, p.tcpData...)
 
-	// byte 8: Constant zero
-	buf[8] = 0
+	return checksum(buf)
+}
 
-	// byte 9: Protocol (6==TCP)
-	buf[9] = 6
+// Computes the UDP header checksum. The packet is not modified.
+func (p *Packet) computeUDPChecksum() uint16 {
 
-	// bytes 10,11: TCP buffer size (real header + payload)
-	binary.BigEndian.PutUint16(buf[10:12], tcpSize+uint16(len(p.tcpData)+len(p.tcpOptions)))
+	udpSize := uint16(len(p.Buffer)) - p.l4BeginPos
+	buf := p.pseudoHeader(IPProtocolUDP, uint32(udpSize))
+	pseudoHeaderLen := len(buf)
 
-	// bytes 12+: The TCP buffer (real header + payload)
-	copy(buf[12:], p.Buffer[p.l4BeginPos:])
+	// The UDP buffer (real header + payload)
+	buf = append(buf, p.Buffer[p.l4BeginPos:]...)
 
 	// Set current checksum to zero (in buf, not changing packet)
-	buf[pseudoHeaderLen+16] = 0
-	buf[pseudoHeaderLen+17] = 0
+	buf[pseudoHeaderLen+UDPChecksumPos] = 0
+	buf[pseudoHeaderLen+UDPChecksumPos+1] = 0
 
-	buf = append(buf, p.tcpOptions...)
-	buf = append(buf, p.tcpData...)
+	// A computed checksum of zero is transmitted as all ones
+	if sum := checksum(buf); sum != 0 {
+		return sum
+	}
 
-	return checksum(buf)
+	return 0xFFFF
+}
+
+// pseudoHeader builds the pseudo-header used in the L4 checksum computation
+// for the IP version of the packet.
+func (p *Packet) pseudoHeader(protocol uint8, l4Length uint32) []byte {
+
+	if p.IsIPv6() {
+		buf := make([]byte, 40)
+
+		// bytes 0-15: Source IP address, bytes 16-31: Destination IP address
+		copy(buf[0:16], p.Buffer[ipv6SourceAddrPos:ipv6SourceAddrPos+16])
+		copy(buf[16:32], p.Buffer[ipv6DestAddrPos:ipv6DestAddrPos+16])
+
+		// bytes 32-35: L4 length, bytes 36-38: zero, byte 39: Next header
+		binary.BigEndian.PutUint32(buf[32:36], l4Length)
+		buf[39] = protocol
+
+		return buf
+	}
+
+	buf := make([]byte, 12)
+
+	// bytes 0-3: Source IP address
+	copy(buf[0:4], p.Buffer[ipSourceAddrPos:ipSourceAddrPos+4])
+
+	// bytes 4-7: Destination IP address
+	copy(buf[4:8], p.Buffer[ipDestAddrPos:ipDestAddrPos+4])
+
+	// byte 8: Constant zero, byte 9: Protocol
+	buf[9] = protocol
+
+	// bytes 10,11: L4 buffer size (real header + payload)
+	binary.BigEndian.PutUint16(buf[10:12], uint16(l4Length))
+
+	return buf
 }
 
 // incCsum16 implements rfc1624, equation 3.
@@ -49,7 +49,7 @@ func init() {
 
 // New returns a pointer to Packet structure built from the
 // provided bytes buffer which is expected to contain valid TCP/IP
-// packet bytes.
+// packet bytes. Both IPv4 and IPv6 packets are supported.
 func New(context uint64, bytes []byte, mark string) (packet *Packet, err error) {
 
 	var p Packet
@@ -64,7 +64,65 @@ func New(context uint64, bytes []byte, mark string) (packet *Packet, err error)
 	p.tcpOptions = []byte{}
 	p.tcpData = []byte{}
 
+	if len(bytes) == 0 {
+		return nil, fmt.Errorf("empty ip packet")
+	}
+
+	p.IPVersion = bytes[ipHdrLenPos] >> ipVersionShift
+
 	// IP Header Processing
+	switch p.IPVersion {
+	case IPVersion4:
+		if err := p.parseIPv4Header(); err != nil {
+			return nil, err
+		}
+	case IPVersion6:
+		if err := p.parseIPv6Header(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported ip version: %d", p.IPVersion)
+	}
+
+	if p.IPTotalLength != uint16(len(p.Buffer)) {
+		if p.IPTotalLength < uint16(len(p.Buffer)) {
+			p.Buffer = p.Buffer[:p.IPTotalLength]
+		} else {
+			return nil, fmt.Errorf("stated ip packet length %d differs from bytes available %d", p.IPTotalLength, len(p.Buffer))
+		}
+	}
+
+	p.context = context
+
+	// UDP Header Processing
+	if p.IPProto == IPProtocolUDP {
+		p.SourcePort = binary.BigEndian.Uint16(p.Buffer[p.l4BeginPos+tcpSourcePortPos : p.l4BeginPos+tcpSourcePortPos+2])
+		p.DestinationPort = binary.BigEndian.Uint16(p.Buffer[p.l4BeginPos+tcpDestPortPos : p.l4BeginPos+tcpDestPortPos+2])
+		p.UDPChecksum = binary.BigEndian.Uint16(p.Buffer[p.l4BeginPos+UDPChecksumPos : p.l4BeginPos+UDPChecksumPos+2])
+		return &p, nil
+	}
+
+	// TCP Header Processing
+	tcpHeader := p.Buffer[p.l4BeginPos:]
+	p.TCPChecksum = binary.BigEndian.Uint16(tcpHeader[TCPChecksumPos : TCPChecksumPos+2])
+	p.SourcePort = binary.BigEndian.Uint16(tcpHeader[tcpSourcePortPos : tcpSourcePortPos+2])
+	p.DestinationPort = binary.BigEndian.Uint16(tcpHeader[tcpDestPortPos : tcpDestPortPos+2])
+	p.TCPAck = binary.BigEndian.Uint32(tcpHeader[tcpAckPos : tcpAckPos+4])
+	p.TCPSeq = binary.BigEndian.Uint32(tcpHeader[tcpSeqPos : tcpSeqPos+4])
+	p.tcpDataOffset = (tcpHeader[tcpDataOffsetPos] & tcpDataOffsetMask) >> 4
+	p.TCPFlags = tcpHeader[tcpFlagsOffsetPos]
+
+	return &p, nil
+}
+
+// parseIPv4Header parses the IPv4 header of the packet
+func (p *Packet) parseIPv4Header() error {
+
+	if len(p.Buffer) < minIPHdrSize {
+		return fmt.Errorf("ip packet too small: length=%d", len(p.Buffer))
+	}
+
+	bytes := p.Buffer
 	p.ipHeaderLen = bytes[ipHdrLenPos] & ipHdrLenMask
 	p.IPProto = bytes[ipProtoPos]
 	p.IPTotalLength = binary.BigEndian.Uint16(bytes[ipLengthPos : ipLengthPos+2])
@@ -74,35 +132,66 @@ func New(context uint64, bytes []byte, mark string) (packet *Packet, err error)
 	p.DestinationAddress = net.IP(bytes[ipDestAddrPos : ipDestAddrPos+4])
 
 	// Some sanity checking...
-	if p.IPTotalLength < minIPPacketLen {
-		return nil, fmt.Errorf("ip packet too small: hdrlen=%d", p.ipHeaderLen)
+	minPacketLen := uint16(minIPPacketLen)
+	if p.IPProto == IPProtocolUDP {
+		minPacketLen = minUDPPacketLen
 	}
 
-	if p.ipHeaderLen != minIPHdrWords {
-		return nil, fmt.Errorf("packets with ip options not supported: hdrlen=%d", p.ipHeaderLen)
+	if p.IPTotalLength < minPacketLen {
+		return fmt.Errorf("ip packet too small: hdrlen=%d", p.ipHeaderLen)
 	}
 
-	if p.IPTotalLength != uint16(len(p.Buffer)) {
-		if p.IPTotalLength < uint16(len(p.Buffer)) {
-			p.Buffer = p.Buffer[:p.IPTotalLength]
-		} else {
-			return nil, fmt.Errorf("stated ip packet length %d differs from bytes available %d", p.IPTotalLength, len(p.Buffer))
-		}
+	if p.ipHeaderLen != minIPHdrWords {
+		return fmt.Errorf("packets with ip options not supported: hdrlen=%d", p.ipHeaderLen)
 	}
 
-	// TCP Header Processing
 	p.l4BeginPos = minIPHdrSize
-	p.TCPChecksum = binary.BigEndian.Uint16(bytes[TCPChecksumPos : TCPChecksumPos+2])
-	p.SourcePort = binary.BigEndian.Uint16(bytes[tcpSourcePortPos : tcpSourcePortPos+2])
-	p.DestinationPort = binary.BigEndian.Uint16(bytes[tcpDestPortPos : tcpDestPortPos+2])
-	p.TCPAck = binary.BigEndian.Uint32(bytes[tcpAckPos : tcpAckPos+4])
-	p.TCPSeq = binary.BigEndian.Uint32(bytes[tcpSeqPos : tcpSeqPos+4])
-	p.tcpDataOffset = (bytes[tcpDataOffsetPos] & tcpDataOffsetMask) >> 4
-	p.TCPFlags = bytes[tcpFlagsOffsetPos]
 
-	p.context = context
+	return nil
+}
 
-	return &p, nil
+// parseIPv6Header parses the IPv6 header of the packet. Extension headers
+// are not supported.
+func (p *Packet) parseIPv6Header() error {
+
+	if len(p.Buffer) < minIPv6HdrSize {
+		return fmt.Errorf("ipv6 packet too small: length=%d", len(p.Buffer))
+	}
+
+	bytes := p.Buffer
+	p.IPProto = bytes[ipv6NextHeaderPos]
+	p.SourceAddress = net.IP(bytes[ipv6SourceAddrPos : ipv6SourceAddrPos+net.IPv6len])
+	p.DestinationAddress = net.IP(bytes[ipv6DestAddrPos : ipv6DestAddrPos+net.IPv6len])
+
+	// The total length of the packet is kept in IPTotalLength for both versions
+	totalLength := minIPv6HdrSize + int(binary.BigEndian.Uint16(bytes[ipv6PayloadLengthPos:ipv6PayloadLengthPos+2]))
+	if totalLength > 0xFFFF {
+		return fmt.Errorf("ipv6 packet too big: length=%d", totalLength)
+	}
+	p.IPTotalLength = uint16(totalLength)
+
+	if p.IPProto != IPProtocolTCP && p.IPProto != IPProtocolUDP {
+		return fmt.Errorf("ipv6 packets with extension headers not supported: nextheader=%d", p.IPProto)
+	}
+
+	// Some sanity checking...
+	minPacketLen := uint16(minIPv6HdrSize + minTCPHdrSize)
+	if p.IPProto == IPProtocolUDP {
+		minPacketLen = minIPv6HdrSize + udpHeaderLen
+	}
+
+	if p.IPTotalLength < minPacketLen {
+		return fmt.Errorf("ipv6 packet too small: length=%d", p.IPTotalLength)
+	}
+
+	p.l4BeginPos = minIPv6HdrSize
+
+	return nil
+}
+
+// IsIPv6 returns true if the packet is an IPv6 packet
+func (p *Packet) IsIPv6() bool {
+	return p.IPVersion == IPVersion6
 }
 
 // IsEmptyTCPPayload returns the TCP data offset
@@ -164,7 +253,17 @@ func (p *Packet) Print(context uint64) {
 	var buf string
 	print := false
 
-	if logPkt {
+	if logPkt && p.IPProto == IPProtocolUDP {
+		buf += fmt.Sprintf("Packet: %5d %5s %25s %15s %5d %15s %5d %6s %6d %5d %5d\n",
+			p.ipID,
+			flagsToDir(p.context|context),
+			flagsToStr(p.context|context),
+			p.SourceAddress.String(), p.SourcePort,
+			p.DestinationAddress.String(), p.DestinationPort,
+			"udp", p.IPTotalLength-p.UDPDataStartBytes(),
+			p.UDPChecksum, p.computeUDPChecksum())
+		print = true
+	} else if logPkt {
 		if printCount%200 == 0 {
 			buf += fmt.Sprintf("Packet: %5s %5s %25s %15s %5s %15s %5s %6s %20s %20s %6s %20s %20s %2s %5s %5s\n",
 				"IPID", "Dir", "Comment", "SIP", "SP", "DIP", "DP", "Flags", "TCPSeq", "TCPAck", "TCPLen", "ExpAck", "ExpSeq", "DO", "Acsum", "Ccsum")
@@ -188,8 +287,8 @@ func (p *Packet) Print(context uint64) {
 			p.ipID,
 			flagsToDir(p.context|context),
 			flagsToStr(p.context|context),
-			p.SourceAddress.To4().String(), p.SourcePort,
-			p.DestinationAddress.To4().String(), p.DestinationPort,
+			p.SourceAddress.String(), p.SourcePort,
+			p.DestinationAddress.String(), p.DestinationPort,
 			tcpFlagsToStr(p.TCPFlags),
 			p.TCPSeq, p.TCPAck, p.IPTotalLength-p.TCPDataStartBytes(),
 			expAck, expAck, p.tcpDataOffset,
@@ -257,11 +356,18 @@ func (p *Packet) CheckTCPAuthenticationOption(iOptionLength int) (err error) {
 // FixupIPHdrOnDataModify modifies the IP header fields and checksum
 func (p *Packet) FixupIPHdrOnDataModify(old, new uint16) {
 
+	// Update IP Total Length.
+	p.IPTotalLength = p.IPTotalLength + new - old
+
+	// IPv6 headers carry the payload length and no checksum
+	if p.IsIPv6() {
+		binary.BigEndian.PutUint16(p.Buffer[ipv6PayloadLengthPos:ipv6PayloadLengthPos+2], p.IPTotalLength-minIPv6HdrSize)
+		return
+	}
+
 	// IP Header Processing
 	// IP chekcsum fixup.
 	p.ipChecksum = incCsum16(p.ipChecksum, old, new)
-	// Update IP Total Length.
-	p.IPTotalLength = p.IPTotalLength + new - old
 
 	binary.BigEndian.PutUint16(p.Buffer[ipLengthPos:ipLengthPos+2], p.IPTotalLength)
 	binary.BigEndian.PutUint16(p.Buffer[ipChecksumPos:ipChecksumPos+2], p.ipChecksum)
@@ -271,28 +377,28 @@ func (p *Packet) FixupIPHdrOnDataModify(old, new uint16) {
 func (p *Packet) IncreaseTCPSeq(incr uint32) {
 
 	p.TCPSeq = p.TCPSeq + incr
-	binary.BigEndian.PutUint32(p.Buffer[tcpSeqPos:tcpSeqPos+4], p.TCPSeq)
+	binary.BigEndian.PutUint32(p.Buffer[p.l4BeginPos+tcpSeqPos:p.l4BeginPos+tcpSeqPos+4], p.TCPSeq)
 }
 
 // DecreaseTCPSeq decreases TCP seq number by decr
 func (p *Packet) DecreaseTCPSeq(decr uint32) {
 
 	p.TCPSeq = p.TCPSeq - decr
-	binary.BigEndian.PutUint32(p.Buffer[tcpSeqPos:tcpSeqPos+4], p.TCPSeq)
+	binary.BigEndian.PutUint32(p.Buffer[p.l4BeginPos+tcpSeqPos:p.l4BeginPos+tcpSeqPos+4], p.TCPSeq)
 }
 
 // IncreaseTCPAck increases TCP ack number by incr
 func (p *Packet) IncreaseTCPAck(incr uint32) {
 
 	p.TCPAck = p.TCPAck + incr
-	binary.BigEndian.PutUint32(p.Buffer[tcpAckPos:tcpAckPos+4], p.TCPAck)
+	binary.BigEndian.PutUint32(p.Buffer[p.l4BeginPos+tcpAckPos:p.l4BeginPos+tcpAckPos+4], p.TCPAck)
 }
 
 // DecreaseTCPAck decreases TCP ack number by decr
 func (p *Packet) DecreaseTCPAck(decr uint32) {
 
 	p.TCPAck = p.TCPAck - decr
-	binary.BigEndian.PutUint32(p.Buffer[tcpAckPos:tcpAckPos+4], p.TCPAck)
+	binary.BigEndian.PutUint32(p.Buffer[p.l4BeginPos+tcpAckPos:p.l4BeginPos+tcpAckPos+4], p.TCPAck)
 }
 
 // FixupTCPHdrOnTCPDataDetach modifies the TCP header fields and checksum
@@ -300,7 +406,7 @@ func (p *Packet) FixupTCPHdrOnTCPDataDetach(dataLength uint16, optionLength uint
 
 	// Update DataOffset
 	p.tcpDataOffset = p.tcpDataOffset - uint8(optionLength/4)
-	p.Buffer[tcpDataOffsetPos] = p.tcpDataOffset << 4
+	p.Buffer[p.l4BeginPos+tcpDataOffsetPos] = p.tcpDataOffset << 4
 }
 
 // tcpDataDetach splits the p.Buffer into p.Buffer (header + some options), p.tcpOptions (optionLength) and p.TCPData (dataLength)
@@ -360,8 +466,8 @@ func (p *Packet) FixupTCPHdrOnTCPDataAttach(tcpOptions []byte, tcpData []byte) {
 
 	// Modify the fields
 	p.tcpDataOffset = p.tcpDataOffset + uint8(numberOfOptions)
-	binary.BigEndian.PutUint16(p.Buffer[TCPChecksumPos:TCPChecksumPos+2], p.TCPChecksum)
-	p.Buffer[tcpDataOffsetPos] = p.tcpDataOffset << 4
+	binary.BigEndian.PutUint16(p.Buffer[p.l4BeginPos+TCPChecksumPos:p.l4BeginPos+TCPChecksumPos+2], p.TCPChecksum)
+	p.Buffer[p.l4BeginPos+tcpDataOffsetPos] = p.tcpDataOffset << 4
 }
 
 // tcpDataAttach splits the p.Buffer into p.Buffer (header + some options), p.tcpOptions (optionLength) and p.TCPData (dataLength)
@@ -429,3 +535,122 @@ func (p *Packet) TCPOptionLength() int {
 func (p *Packet) TCPDataLength() int {
 	return len(p.tcpData)
 }
+
+// UDPDataStartBytes provides the udp data start offset in bytes
+func (p *Packet) UDPDataStartBytes() uint16 {
+	return p.l4BeginPos + udpHeaderLen
+}
+
+// ReadUDPData returns the payload of a UDP packet
+// It does not remove the payload from the packet
+func (p *Packet) ReadUDPData() []byte {
+
+	if uint16(len(p.Buffer)) >= p.IPTotalLength && p.IPTotalLength >= p.UDPDataStartBytes() {
+		return p.Buffer[p.UDPDataStartBytes():p.IPTotalLength]
+	}
+
+	return []byte{}
+}
+
+// CheckUDPAuthenticationMarker ensures that the payload of a UDP packet starts
+// with a well formed authorization header
+func (p *Packet) CheckUDPAuthenticationMarker() error {
+
+	data := p.ReadUDPData()
+
+	if len(data) < UDPAuthHeaderLen || string(data[:udpAuthMarkerLen]) != UDPAuthMarker {
+		return fmt.Errorf("udp authentication marker not found: datalength=%d", len(data))
+	}
+
+	tokenLength := int(binary.BigEndian.Uint16(data[udpAuthTokenLenPos : udpAuthTokenLenPos+2]))
+	if tokenLength > len(data)-UDPAuthHeaderLen {
+		return fmt.Errorf("udp authentication token exceeds payload: tokenlength=%d datalength=%d", tokenLength, len(data))
+	}
+
+	return nil
+}
+
+// UDPAuthPacketType returns the packet type of the authorization header. It
+// returns 0 if the packet does not carry a valid header
+func (p *Packet) UDPAuthPacketType() uint8 {
+
+	if p.CheckUDPAuthenticationMarker() != nil {
+		return 0
+	}
+
+	return p.ReadUDPData()[udpAuthTypePos]
+}
+
+// ReadUDPToken returns the token carried in the authorization header.
+// It does not remove the token from the packet
+func (p *Packet) ReadUDPToken() []byte {
+
+	if p.CheckUDPAuthenticationMarker() != nil {
+		return []byte{}
+	}
+
+	data := p.ReadUDPData()
+	tokenLength := binary.BigEndian.Uint16(data[udpAuthTokenLenPos : udpAuthTokenLenPos+2])
+
+	return data[UDPAuthHeaderLen : UDPAuthHeaderLen+tokenLength]
+}
+
+// UDPTokenAttach prefixes the payload of the UDP packet with an authorization
+// header of the given type carrying the token. It updates the IP and UDP headers.
+// The UDP checksum must be updated by the caller.
+func (p *Packet) UDPTokenAttach(packetType uint8, token []byte) error {
+
+	if uint16(len(p.Buffer)) < p.IPTotalLength || p.IPTotalLength < p.UDPDataStartBytes() {
+		return fmt.Errorf("udp token attach failed: invalid packet: iptotallength=%d", p.IPTotalLength)
+	}
+
+	increase := UDPAuthHeaderLen + len(token)
+	if int(p.IPTotalLength)+increase > 0xFFFF {
+		return fmt.Errorf("udp token attach failed: packet too big: tokenlength=%d iptotallength=%d", len(token), p.IPTotalLength)
+	}
+
+	header := make([]byte, UDPAuthHeaderLen)
+	copy(header, UDPAuthMarker)
+	header[udpAuthTypePos] = packetType
+	binary.BigEndian.PutUint16(header[udpAuthTokenLenPos:udpAuthTokenLenPos+2], uint16(len(token)))
+
+	start := p.UDPDataStartBytes()
+
+	buffer := make([]byte, 0, int(p.IPTotalLength)+increase)
+	buffer = append(buffer, p.Buffer[:start]...)
+	buffer = append(buffer, header...)
+	buffer = append(buffer, token...)
+	buffer = append(buffer, p.Buffer[start:p.IPTotalLength]...)
+	p.Buffer = buffer
+
+	p.FixupIPHdrOnDataModify(p.IPTotalLength, p.IPTotalLength+uint16(increase))
+	p.fixupUDPLength()
+
+	return nil
+}
+
+// UDPTokenDetach removes the authorization header and the token from the
+// payload of the UDP packet. It updates the IP and UDP headers. The UDP
+// checksum must be updated by the caller.
+func (p *Packet) UDPTokenDetach() error {
+
+	if err := p.CheckUDPAuthenticationMarker(); err != nil {
+		return fmt.Errorf("udp token detach failed: %s", err)
+	}
+
+	start := p.UDPDataStartBytes()
+	decrease := UDPAuthHeaderLen + len(p.ReadUDPToken())
+
+	copy(p.Buffer[start:], p.Buffer[start+uint16(decrease):p.IPTotalLength])
+	p.Buffer = p.Buffer[:p.IPTotalLength-uint16(decrease)]
+
+	p.FixupIPHdrOnDataModify(p.IPTotalLength, p.IPTotalLength-uint16(decrease))
+	p.fixupUDPLength()
+
+	return nil
+}
+
+// fixupUDPLength updates the UDP length field based on the IP total length
+func (p *Packet) fixupUDPLength() {
+	binary.BigEndian.PutUint16(p.Buffer[p.l4BeginPos+udpLengthPos:p.l4BeginPos+udpLengthPos+2], p.IPTotalLength-p.l4BeginPos)
+}
@@ -355,6 +355,273 @@ func TestRawChecksums(t *testing.T) {
 	}
 }
 
+// udpTestPacket is a datagram from 10.0.0.1:50000 to 10.0.0.2:53 with payload "hello".
+var udpTestPacket = []byte{0x45, 0x00, 0x00, 0x21, 0x12, 0x34, 0x40, 0x00, 0x40, 0x11, 0x14,
+	0x96, 0x0a, 0x00, 0x00, 0x01, 0x0a, 0x00, 0x00, 0x02, 0xc3, 0x50, 0x00, 0x35, 0x00, 0x0d,
+	0xe4, 0x79, 0x68, 0x65, 0x6c, 0x6c, 0x6f}
+
+func TestGoodUDPPacket(t *testing.T) {
+
+	t.Parallel()
+	pkt := getUDPTestPacket(t)
+
+	if pkt.IPProto != IPProtocolUDP {
+		t.Error("Unexpected ip protocol")
+	}
+
+	if !pkt.VerifyIPChecksum() {
+		t.Error("Test packet IP checksum failed")
+	}
+
+	if !pkt.VerifyUDPChecksum() {
+		t.Error("UDP checksum failed")
+	}
+
+	if pkt.SourcePort != 50000 || pkt.DestinationPort != 53 {
+		t.Error("Unexpected ports")
+	}
+
+	if string(pkt.ReadUDPData()) != "hello" {
+		t.Errorf("Unexpected payload %s", string(pkt.ReadUDPData()))
+	}
+
+	if pkt.CheckUDPAuthenticationMarker() == nil {
+		t.Error("Expected no authentication marker")
+	}
+
+	if pkt.UDPAuthPacketType() != 0 {
+		t.Error("Expected no packet type")
+	}
+}
+
+func TestUDPTokenAttachDetach(t *testing.T) {
+
+	t.Parallel()
+	pkt := getUDPTestPacket(t)
+	token := []byte("sometoken")
+
+	if err := pkt.UDPTokenAttach(UDPSynPacket, token); err != nil {
+		t.Fatal(err)
+	}
+	pkt.UpdateUDPChecksum()
+
+	if pkt.IPTotalLength != uint16(len(udpTestPacket)+UDPAuthHeaderLen+len(token)) {
+		t.Errorf("Unexpected ip length %d", pkt.IPTotalLength)
+	}
+
+	if !pkt.VerifyIPChecksum() {
+		t.Error("IP checksum failed after attach")
+	}
+
+	// Parse the packet again to validate the headers on the wire
+	attached, err := New(0, pkt.GetBytes(), "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !attached.VerifyUDPChecksum() {
+		t.Error("UDP checksum failed after attach")
+	}
+
+	if err := attached.CheckUDPAuthenticationMarker(); err != nil {
+		t.Error(err)
+	}
+
+	if attached.UDPAuthPacketType() != UDPSynPacket {
+		t.Error("Unexpected packet type")
+	}
+
+	if string(attached.ReadUDPToken()) != string(token) {
+		t.Errorf("Unexpected token %s", string(attached.ReadUDPToken()))
+	}
+
+	if err := attached.UDPTokenDetach(); err != nil {
+		t.Fatal(err)
+	}
+	attached.UpdateUDPChecksum()
+
+	if string(attached.GetBytes()) != string(udpTestPacket) {
+		t.Error("Packet not restored after detach")
+	}
+}
+
+func TestUDPInvalidTokenLength(t *testing.T) {
+
+	t.Parallel()
+	pkt := getUDPTestPacket(t)
+
+	if err := pkt.UDPTokenAttach(UDPSynAckPacket, []byte("sometoken")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Claim a token longer than the payload
+	pkt.Buffer[pkt.UDPDataStartBytes()+udpAuthTokenLenPos] = 0xFF
+
+	if pkt.CheckUDPAuthenticationMarker() == nil {
+		t.Error("Expected invalid token length error")
+	}
+
+	if pkt.UDPTokenDetach() == nil {
+		t.Error("Expected detach error")
+	}
+}
+
+// udpv6TestPacket is a datagram from [2001:db8::1]:50000 to [2001:db8::2]:53 with payload "hello".
+var udpv6TestPacket = []byte{0x60, 0x00, 0x00, 0x00, 0x00, 0x0d, 0x11, 0x40, 0x20, 0x01, 0x0d,
+	0xb8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x20, 0x01,
+	0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xc3,
+	0x50, 0x00, 0x35, 0x00, 0x0d, 0x9d, 0x07, 0x68, 0x65, 0x6c, 0x6c, 0x6f}
+
+// synv6TestPacket is a SYN packet from [2001:db8::1]:40000 to [2001:db8::2]:80.
+var synv6TestPacket = []byte{0x60, 0x00, 0x00, 0x00, 0x00, 0x14, 0x06, 0x40, 0x20, 0x01, 0x0d,
+	0xb8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x20, 0x01,
+	0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x9c,
+	0x40, 0x00, 0x50, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x50, 0x02, 0xff, 0xff,
+	0xb7, 0xdc, 0x00, 0x00}
+
+func TestGoodIPv6TCPPacket(t *testing.T) {
+
+	t.Parallel()
+	pkt := getBytesTestPacket(t, synv6TestPacket)
+
+	if !pkt.IsIPv6() || pkt.IPProto != IPProtocolTCP {
+		t.Errorf("Unexpected ip version %d or protocol %d", pkt.IPVersion, pkt.IPProto)
+	}
+
+	if pkt.SourceAddress.String() != "2001:db8::1" || pkt.DestinationAddress.String() != "2001:db8::2" {
+		t.Errorf("Unexpected addresses %s %s", pkt.SourceAddress, pkt.DestinationAddress)
+	}
+
+	if pkt.SourcePort != 40000 || pkt.DestinationPort != 80 || pkt.TCPSeq != 1 || pkt.TCPFlags != TCPSynMask {
+		t.Error("Unexpected tcp header fields")
+	}
+
+	if pkt.IPTotalLength != uint16(len(synv6TestPacket)) {
+		t.Errorf("Unexpected ip length %d", pkt.IPTotalLength)
+	}
+
+	if !pkt.VerifyIPChecksum() || !pkt.VerifyTCPChecksum() {
+		t.Error("Test packet checksum failed")
+	}
+}
+
+func TestIPv6TCPDataAttachDetach(t *testing.T) {
+
+	t.Parallel()
+	pkt := getBytesTestPacket(t, synv6TestPacket)
+
+	options := []byte{TCPAuthenticationOption, 4, 0, 0}
+	data := []byte("token")
+
+	if err := pkt.TCPDataAttach(options, data); err != nil {
+		t.Fatal(err)
+	}
+	pkt.UpdateTCPChecksum()
+
+	attached := getBytesTestPacket(t, pkt.GetBytes())
+	if attached.IPTotalLength != uint16(len(synv6TestPacket)+len(options)+len(data)) {
+		t.Errorf("Unexpected ip length %d", attached.IPTotalLength)
+	}
+
+	if !attached.VerifyTCPChecksum() {
+		t.Error("TCP checksum failed after attach")
+	}
+
+	if err := attached.CheckTCPAuthenticationOption(len(options)); err != nil {
+		t.Error(err)
+	}
+
+	if string(attached.ReadTCPData()) != string(data) {
+		t.Errorf("Unexpected tcp data %s", string(attached.ReadTCPData()))
+	}
+
+	if err := attached.TCPDataDetach(uint16(len(options))); err != nil {
+		t.Fatal(err)
+	}
+	attached.DropDetachedBytes()
+	attached.UpdateTCPChecksum()
+
+	if string(attached.GetBytes()) != string(synv6TestPacket) {
+		t.Error("Packet not restored after detach")
+	}
+}
+
+func TestIPv6UDPTokenAttachDetach(t *testing.T) {
+
+	t.Parallel()
+	pkt := getBytesTestPacket(t, udpv6TestPacket)
+
+	if !pkt.IsIPv6() || pkt.SourcePort != 50000 || pkt.DestinationPort != 53 {
+		t.Error("Unexpected udp header fields")
+	}
+
+	if !pkt.VerifyUDPChecksum() {
+		t.Error("Test packet UDP checksum failed")
+	}
+
+	if err := pkt.UDPTokenAttach(UDPSynPacket, []byte("sometoken")); err != nil {
+		t.Fatal(err)
+	}
+	pkt.UpdateUDPChecksum()
+
+	attached := getBytesTestPacket(t, pkt.GetBytes())
+	if attached.UDPAuthPacketType() != UDPSynPacket || string(attached.ReadUDPToken()) != "sometoken" {
+		t.Error("Token not found after attach")
+	}
+
+	if !attached.VerifyUDPChecksum() {
+		t.Error("UDP checksum failed after attach")
+	}
+
+	if err := attached.UDPTokenDetach(); err != nil {
+		t.Fatal(err)
+	}
+	attached.UpdateUDPChecksum()
+
+	if string(attached.GetBytes()) != string(udpv6TestPacket) {
+		t.Error("Packet not restored after detach")
+	}
+}
+
+func TestIPv6ExtensionHeadersNotSupported(t *testing.T) {
+
+	t.Parallel()
+
+	tmp := make([]byte, len(udpv6TestPacket))
+	copy(tmp, udpv6TestPacket)
+
+	// Hop-by-hop options header
+	tmp[ipv6NextHeaderPos] = 0
+
+	if _, err := New(0, tmp, "0"); err == nil {
+		t.Error("Expected error for extension headers")
+	}
+}
+
+func getBytesTestPacket(t *testing.T, bytes []byte) *Packet {
+
+	tmp := make([]byte, len(bytes))
+	copy(tmp, bytes)
+
+	pkt, err := New(0, tmp, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkt
+}
+
+func getUDPTestPacket(t *testing.T) *Packet {
+
+	tmp := make([]byte, len(udpTestPacket))
+	copy(tmp, udpTestPacket)
+
+	pkt, err := New(0, tmp, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkt
+}
+
 func getTestPacket(t *testing.T, id SamplePacketName) *Packet {
 
 	tmp := make([]byte, len(testPackets[id]))
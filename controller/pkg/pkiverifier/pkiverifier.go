@@ -1,16 +1,19 @@
 package pkiverifier
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/x509"
 	"errors"
+	"fmt"
 	"math/big"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
+	cryptoutils "github.com/aporeto-inc/trireme-lib/utils/crypto"
 )
 
 const (
@@ -25,34 +28,51 @@ type PKITokenIssuer interface {
 
 // PKITokenVerifier is the interface of an object that can verify a PKI token.
 type PKITokenVerifier interface {
-	Verify([]byte) (*ecdsa.PublicKey, error)
+	Verify([]byte) (crypto.PublicKey, error)
 }
 
+// verifierClaims carry the public key of the certificate. P-256 keys are
+// carried as X and Y for compatibility. The other keys are carried in their
+// PKIX encoding. The serial number and the authority key id identify the
+// certificate for the revocation checks.
 type verifierClaims struct {
-	X *big.Int
-	Y *big.Int
+	X              *big.Int
+	Y              *big.Int
+	PublicKey      []byte   `json:",omitempty"`
+	SerialNumber   *big.Int `json:"SN,omitempty"`
+	AuthorityKeyID []byte   `json:"AKI,omitempty"`
 	jwt.StandardClaims
 }
 
+// verifiedKey is a key verified from a token. It keeps the identity of
+// its certificate and of the token issuer for the revocation checks.
+type verifiedKey struct {
+	key            crypto.PublicKey
+	issuer         int
+	serialNumber   *big.Int
+	authorityKeyID []byte
+}
+
 type tokenManager struct {
-	publicKeys []*ecdsa.PublicKey
-	privateKey *ecdsa.PrivateKey
-	signMethod jwt.SigningMethod
-	keycache   cache.DataStore
-	validity   time.Duration
+	publicKeys   []crypto.PublicKey
+	certificates []*x509.Certificate
+	revocation   *cryptoutils.RevocationChecker
+	privateKey   crypto.Signer
+	keycache     cache.DataStore
+	validity     time.Duration
 }
 
-// NewPKIIssuer initializes a new signer structure
-func NewPKIIssuer(privateKey *ecdsa.PrivateKey) PKITokenIssuer {
+// NewPKIIssuer initializes a new signer structure. The tokens are signed
+// with the signing method of the key.
+func NewPKIIssuer(privateKey crypto.Signer) PKITokenIssuer {
 
 	return &tokenManager{
 		privateKey: privateKey,
-		signMethod: jwt.SigningMethodES256,
 	}
 }
 
 // NewPKIVerifier returns a new PKIConfiguration.
-func NewPKIVerifier(publicKeys []*ecdsa.PublicKey, cacheValidity time.Duration) PKITokenVerifier {
+func NewPKIVerifier(publicKeys []crypto.PublicKey, cacheValidity time.Duration) PKITokenVerifier {
 
 	validity := defaultValidity * time.Second
 	if cacheValidity > 0 {
@@ -61,36 +81,79 @@ func NewPKIVerifier(publicKeys []*ecdsa.PublicKey, cacheValidity time.Duration)
 
 	return &tokenManager{
 		publicKeys: publicKeys,
-		signMethod: jwt.SigningMethodES256,
 		keycache:   cache.NewCacheWithExpiration("PKIVerifierKey", validity),
 		validity:   validity,
 	}
 }
 
+// NewPKIVerifierWithRevocation returns a new PKIConfiguration that verifies
+// the tokens with the keys of the certificates of the token issuers. The
+// tokens are rejected if the certificate of their issuer or the certificate
+// they carry is revoked, even if their key is cached.
+func NewPKIVerifierWithRevocation(certificates []*x509.Certificate, revocation *cryptoutils.RevocationChecker, cacheValidity time.Duration) PKITokenVerifier {
+
+	publicKeys := make([]crypto.PublicKey, len(certificates))
+	for i, cert := range certificates {
+		publicKeys[i] = cert.PublicKey
+	}
+
+	p := NewPKIVerifier(publicKeys, cacheValidity).(*tokenManager)
+	p.certificates = certificates
+	p.revocation = revocation
+
+	return p
+}
+
 // Verify verifies a token and returns the public key
-func (p *tokenManager) Verify(token []byte) (*ecdsa.PublicKey, error) {
+func (p *tokenManager) Verify(token []byte) (crypto.PublicKey, error) {
 
 	tokenString := string(token)
-	if pk, err := p.keycache.Get(tokenString); err == nil {
-		return pk.(*ecdsa.PublicKey), nil
+	if cached, err := p.keycache.Get(tokenString); err == nil {
+		v := cached.(*verifiedKey)
+		if err := p.checkRevocation(v); err != nil {
+			p.keycache.Remove(tokenString) // nolint: errcheck
+			return nil, err
+		}
+		return v.key, nil
 	}
 
 	claims := &verifierClaims{}
 	var JWTToken *jwt.Token
 	var err error
-	for _, pk := range p.publicKeys {
+	for i, pk := range p.publicKeys {
+
+		method, merr := cryptoutils.SigningMethod(pk)
+		if merr != nil {
+			continue
+		}
 
 		JWTToken, err = jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+			if token.Method.Alg() != method.Alg() {
+				return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+			}
 			return pk, nil
 		})
 		if err != nil || !JWTToken.Valid {
 			continue
 		}
 
-		pk := KeyFromClaims(claims)
+		pk, err := KeyFromClaims(claims)
+		if err != nil {
+			return nil, err
+		}
+
+		v := &verifiedKey{
+			key:            pk,
+			issuer:         i,
+			serialNumber:   claims.SerialNumber,
+			authorityKeyID: claims.AuthorityKeyID,
+		}
+		if err := p.checkRevocation(v); err != nil {
+			return nil, err
+		}
 
 		if time.Now().Add(p.validity).Unix() <= claims.ExpiresAt {
-			p.keycache.AddOrUpdate(tokenString, pk)
+			p.keycache.AddOrUpdate(tokenString, v)
 		}
 		return pk, nil
 	}
@@ -101,15 +164,30 @@ func (p *tokenManager) Verify(token []byte) (*ecdsa.PublicKey, error) {
 // CreateTokenFromCertificate creates and signs a token
 func (p *tokenManager) CreateTokenFromCertificate(cert *x509.Certificate) ([]byte, error) {
 
+	signMethod, err := cryptoutils.SigningMethod(p.privateKey.Public())
+	if err != nil {
+		return []byte{}, err
+	}
+
 	// Combine the application claims with the standard claims
-	claims := &verifierClaims{
-		X: cert.PublicKey.(*ecdsa.PublicKey).X,
-		Y: cert.PublicKey.(*ecdsa.PublicKey).Y,
+	claims := &verifierClaims{}
+	if key, ok := cert.PublicKey.(*ecdsa.PublicKey); ok && key.Curve == elliptic.P256() {
+		claims.X = key.X
+		claims.Y = key.Y
+	} else {
+		if err := cryptoutils.CheckPublicKey(cert.PublicKey); err != nil {
+			return []byte{}, err
+		}
+		if claims.PublicKey, err = x509.MarshalPKIXPublicKey(cert.PublicKey); err != nil {
+			return []byte{}, err
+		}
 	}
 	claims.ExpiresAt = cert.NotAfter.Unix()
+	claims.SerialNumber = cert.SerialNumber
+	claims.AuthorityKeyID = cert.AuthorityKeyId
 
 	// Create the token and sign with our key
-	strtoken, err := jwt.NewWithClaims(p.signMethod, claims).SignedString(p.privateKey)
+	strtoken, err := jwt.NewWithClaims(signMethod, claims).SignedString(p.privateKey)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -117,11 +195,44 @@ func (p *tokenManager) CreateTokenFromCertificate(cert *x509.Certificate) ([]byt
 	return []byte(strtoken), nil
 }
 
+// checkRevocation returns an error if the certificate of the issuer of the
+// token or the certificate carried by the token is revoked
+func (p *tokenManager) checkRevocation(v *verifiedKey) error {
+
+	if p.revocation == nil {
+		return nil
+	}
+
+	if err := p.revocation.Check(p.certificates[v.issuer], nil); err != nil {
+		return fmt.Errorf("token issuer revoked: %w", err)
+	}
+
+	if err := p.revocation.CheckSerial(v.authorityKeyID, v.serialNumber); err != nil {
+		return fmt.Errorf("token certificate revoked: %w", err)
+	}
+
+	return nil
+}
+
 // KeyFromClaims creates the public key structure from the claims
-func KeyFromClaims(claims *verifierClaims) *ecdsa.PublicKey {
-	return &ecdsa.PublicKey{
-		Curve: elliptic.P256(),
-		X:     claims.X,
-		Y:     claims.Y,
+func KeyFromClaims(claims *verifierClaims) (crypto.PublicKey, error) {
+
+	if len(claims.PublicKey) == 0 {
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     claims.X,
+			Y:     claims.Y,
+		}, nil
 	}
+
+	key, err := x509.ParsePKIXPublicKey(claims.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %s", err)
+	}
+
+	if err := cryptoutils.CheckPublicKey(key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
 }
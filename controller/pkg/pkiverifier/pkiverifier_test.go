@@ -1,7 +1,14 @@
 package pkiverifier
 
 import (
+	gocrypto "crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
 	"testing"
 	"time"
 
@@ -67,18 +74,18 @@ func TestNewConfig(t *testing.T) {
 		})
 
 		Convey("When I use NewPKIVerifier valid keys, it should succeed ", func() {
-			p := NewPKIVerifier([]*ecdsa.PublicKey{cert.PublicKey.(*ecdsa.PublicKey)}, -1).(*tokenManager)
+			p := NewPKIVerifier([]gocrypto.PublicKey{cert.PublicKey}, -1).(*tokenManager)
 			So(p, ShouldNotBeNil)
 			So(p.validity, ShouldEqual, defaultValidity*time.Second)
 			So(p.privateKey, ShouldBeNil)
-			So(p.publicKeys, ShouldResemble, []*ecdsa.PublicKey{cert.PublicKey.(*ecdsa.PublicKey)})
+			So(p.publicKeys, ShouldResemble, []gocrypto.PublicKey{cert.PublicKey})
 		})
 		Convey("When I use NewPKIVerifier valid keys with a custom validity, it should succeed ", func() {
-			p := NewPKIVerifier([]*ecdsa.PublicKey{cert.PublicKey.(*ecdsa.PublicKey)}, 10*time.Second).(*tokenManager)
+			p := NewPKIVerifier([]gocrypto.PublicKey{cert.PublicKey}, 10*time.Second).(*tokenManager)
 			So(p, ShouldNotBeNil)
 			So(p.validity, ShouldEqual, 10*time.Second)
 			So(p.privateKey, ShouldBeNil)
-			So(p.publicKeys, ShouldResemble, []*ecdsa.PublicKey{cert.PublicKey.(*ecdsa.PublicKey)})
+			So(p.publicKeys, ShouldResemble, []gocrypto.PublicKey{cert.PublicKey})
 		})
 	})
 }
@@ -88,16 +95,16 @@ func TestCreateAndVerify(t *testing.T) {
 		key, cert, _, err := crypto.LoadAndVerifyECSecrets([]byte(keyPEM), []byte(certPEM), []byte(caPool))
 		So(err, ShouldBeNil)
 		p := NewPKIIssuer(key)
-		v := NewPKIVerifier([]*ecdsa.PublicKey{cert.PublicKey.(*ecdsa.PublicKey)}, -1)
+		v := NewPKIVerifier([]gocrypto.PublicKey{cert.PublicKey}, -1)
 		So(p, ShouldNotBeNil)
 		Convey("When I create a token", func() {
 			token, err1 := p.CreateTokenFromCertificate(cert)
 			So(err1, ShouldBeNil)
 			rxtoken, err2 := v.Verify(token)
 			So(err2, ShouldBeNil)
-			So(*rxtoken.X, ShouldResemble, *cert.PublicKey.(*ecdsa.PublicKey).X)
-			So(*rxtoken.Y, ShouldResemble, *cert.PublicKey.(*ecdsa.PublicKey).Y)
-			So(rxtoken.Curve, ShouldResemble, cert.PublicKey.(*ecdsa.PublicKey).Curve)
+			So(*rxtoken.(*ecdsa.PublicKey).X, ShouldResemble, *cert.PublicKey.(*ecdsa.PublicKey).X)
+			So(*rxtoken.(*ecdsa.PublicKey).Y, ShouldResemble, *cert.PublicKey.(*ecdsa.PublicKey).Y)
+			So(rxtoken.(*ecdsa.PublicKey).Curve, ShouldResemble, cert.PublicKey.(*ecdsa.PublicKey).Curve)
 		})
 	})
 
@@ -105,7 +112,7 @@ func TestCreateAndVerify(t *testing.T) {
 		key, cert, _, err := crypto.LoadAndVerifyECSecrets([]byte(keyPEM), []byte(certPEM), []byte(caPool))
 		So(err, ShouldBeNil)
 		p := NewPKIIssuer(key)
-		v := NewPKIVerifier([]*ecdsa.PublicKey{cert.PublicKey.(*ecdsa.PublicKey)}, -1)
+		v := NewPKIVerifier([]gocrypto.PublicKey{cert.PublicKey}, -1)
 		So(p, ShouldNotBeNil)
 		Convey("When I a receive a bad token, I should get an error", func() {
 			token, err1 := p.CreateTokenFromCertificate(cert)
@@ -122,7 +129,7 @@ func TestCaching(t *testing.T) {
 		key, cert, _, err := crypto.LoadAndVerifyECSecrets([]byte(keyPEM), []byte(certPEM), []byte(caPool))
 		So(err, ShouldBeNil)
 		p := NewPKIIssuer(key)
-		v := NewPKIVerifier([]*ecdsa.PublicKey{cert.PublicKey.(*ecdsa.PublicKey)}, 1*time.Second)
+		v := NewPKIVerifier([]gocrypto.PublicKey{cert.PublicKey}, 1*time.Second)
 
 		So(p, ShouldNotBeNil)
 
@@ -147,3 +154,64 @@ func TestCaching(t *testing.T) {
 		})
 	})
 }
+
+// newTestCertificate creates a self signed certificate for the key
+func newTestCertificate(key gocrypto.Signer) *x509.Certificate {
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "enforcer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	So(err, ShouldBeNil)
+
+	cert, err := x509.ParseCertificate(der)
+	So(err, ShouldBeNil)
+
+	return cert
+}
+
+func TestCreateAndVerifyKeyTypes(t *testing.T) {
+
+	Convey("Given issuer and certificate keys of different types", t, func() {
+
+		p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+		p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		So(err, ShouldBeNil)
+		_, ed, err := ed25519.GenerateKey(rand.Reader)
+		So(err, ShouldBeNil)
+
+		keys := []gocrypto.Signer{p256, p384, ed}
+
+		Convey("Then the tokens of every issuer should carry the key of every certificate", func() {
+			for _, issuerKey := range keys {
+				p := NewPKIIssuer(issuerKey)
+				v := NewPKIVerifier([]gocrypto.PublicKey{issuerKey.Public()}, -1)
+
+				for _, key := range keys {
+					token, err := p.CreateTokenFromCertificate(newTestCertificate(key))
+					So(err, ShouldBeNil)
+
+					rxkey, err := v.Verify(token)
+					So(err, ShouldBeNil)
+					So(rxkey, ShouldResemble, key.Public())
+				}
+			}
+		})
+
+		Convey("Then the tokens should be rejected by a verifier of another issuer", func() {
+			p := NewPKIIssuer(ed)
+			v := NewPKIVerifier([]gocrypto.PublicKey{p384.Public()}, -1)
+
+			token, err := p.CreateTokenFromCertificate(newTestCertificate(p256))
+			So(err, ShouldBeNil)
+
+			_, err = v.Verify(token)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,225 @@
+package pucontext
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/acls"
+	enforcerconstants "github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/constants"
+	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/lookup"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// FlowQuery describes a flow to evaluate against the policy of a PU
+type FlowQuery struct {
+	// Direction is introspection.DirectionNetwork for flows initiated by a
+	// remote endpoint and introspection.DirectionApplication for flows
+	// initiated by the PU.
+	Direction string
+	// LocalIdentity is the identity of the PU. The identity of the policy
+	// is used if it is nil.
+	LocalIdentity *policy.TagStore
+	// RemoteTags are the tags of the remote PU. If they are nil, the remote
+	// endpoint is treated as an external network and the ACLs are evaluated.
+	RemoteTags *policy.TagStore
+	RemoteIP   net.IP
+	// Port is the port of the service. This is the local port for network
+	// flows and the remote port for application flows.
+	Port     uint16
+	Protocol string
+}
+
+// RuleEvaluation is the result of the evaluation of one rule of the policy
+type RuleEvaluation struct {
+	// Index is the index of the rule in its list
+	Index int
+	// Selector is set for tag based rules
+	Selector *policy.TagSelector
+	// ACL is set for IP based rules
+	ACL *policy.IPRule
+	// Matched is true if the rule on its own matches the flow
+	Matched bool
+}
+
+// FlowExplanation is the decision for a flow and how it was reached
+type FlowExplanation struct {
+	LocalIdentity *policy.TagStore
+	// Tags are the tags that were searched, including the port label
+	Tags *policy.TagStore
+
+	// Packet is the action applied to the packets of the flow
+	Packet *policy.FlowPolicy
+	// Report is the action reported to the collector
+	Report *policy.FlowPolicy
+
+	// RuleIndex is the index of the rule that decided the packet action and
+	// ReportRuleIndex the one that decided the reported action. They are -1
+	// if the default action was applied.
+	RuleIndex       int
+	ReportRuleIndex int
+	PolicyID        string
+	ObserveAction   policy.ObserveActionType
+
+	// Evaluated are all the rules that were evaluated for the flow
+	Evaluated []*RuleEvaluation
+}
+
+// ExplainFlow evaluates a flow against a policy and returns the decision the
+// datapath would take along with the rules that led to it. Application flows
+// to other PUs are evaluated as if mutual authorization was enabled.
+func ExplainFlow(plc *policy.PUPolicy, query *FlowQuery) (*FlowExplanation, error) {
+
+	if plc == nil || query == nil {
+		return nil, fmt.Errorf("policy and flow must be provided")
+	}
+
+	if query.Direction != introspection.DirectionNetwork && query.Direction != introspection.DirectionApplication {
+		return nil, fmt.Errorf("invalid direction: %s", query.Direction)
+	}
+
+	explanation := &FlowExplanation{
+		LocalIdentity: query.LocalIdentity,
+	}
+
+	if explanation.LocalIdentity == nil {
+		explanation.LocalIdentity = plc.Identity()
+	}
+
+	var err error
+	if query.RemoteTags != nil {
+		explainTagRules(plc, query, explanation)
+	} else {
+		err = explainACLs(plc, query, explanation)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	explanation.PolicyID = explanation.Packet.PolicyID
+	explanation.ObserveAction = explanation.Report.ObserveAction
+
+	return explanation, nil
+}
+
+// explainTagRules evaluates the flow against the receiver or transmitter rules
+func explainTagRules(plc *policy.PUPolicy, query *FlowQuery, explanation *FlowExplanation) {
+
+	rules := copyTagSelectors(plc.TransmitterRules())
+	tags := query.RemoteTags.Copy()
+
+	if query.Direction == introspection.DirectionNetwork {
+		rules = copyTagSelectors(plc.ReceiverRules())
+		tags.AppendKeyValue(enforcerconstants.PortNumberLabelString, strconv.Itoa(int(query.Port)))
+	}
+
+	p := &PUContext{conflictMode: plc.ConflictMode()}
+	explanation.Report, explanation.Packet = p.searchRules(p.createRuleDBs(rules), tags, false)
+	explanation.Tags = tags
+	explanation.RuleIndex = -1
+	explanation.ReportRuleIndex = -1
+
+	for i := range rules {
+		db := lookup.NewPolicyDB()
+		db.AddPolicy(rules[i])
+		index, _ := db.Search(tags)
+
+		explanation.Evaluated = append(explanation.Evaluated, &RuleEvaluation{
+			Index:    i,
+			Selector: &rules[i],
+			Matched:  index >= 0,
+		})
+
+		if explanation.RuleIndex < 0 && rules[i].Policy == explanation.Packet {
+			explanation.RuleIndex = i
+		}
+
+		if explanation.ReportRuleIndex < 0 && rules[i].Policy == explanation.Report {
+			explanation.ReportRuleIndex = i
+		}
+	}
+}
+
+// explainACLs evaluates the flow against the network or application ACLs
+func explainACLs(plc *policy.PUPolicy, query *FlowQuery, explanation *FlowExplanation) error {
+
+	if query.RemoteIP == nil {
+		return fmt.Errorf("remote ip must be provided for flows without tags")
+	}
+
+	rules := copyIPRules(plc.ApplicationACLs())
+	if query.Direction == introspection.DirectionNetwork {
+		rules = copyIPRules(plc.NetworkACLs())
+	}
+
+	protocol := query.Protocol
+	if protocol == "" {
+		protocol = "tcp"
+	}
+
+	cache := acls.NewACLCacheWithMode(protocol, plc.ConflictMode())
+	if err := cache.AddRuleList(rules); err != nil {
+		return fmt.Errorf("unable to compile acls: %s", err)
+	}
+
+	// The catch all policy is returned when nothing matches
+	explanation.Report, explanation.Packet, _ = cache.GetMatchingAction(query.RemoteIP, query.Port)
+	explanation.RuleIndex = -1
+	explanation.ReportRuleIndex = -1
+
+	for i := range rules {
+		single := acls.NewACLCacheForProtocol(protocol)
+		if err := single.AddRuleList(policy.IPRuleList{rules[i]}); err != nil {
+			return fmt.Errorf("unable to compile acl %d: %s", i, err)
+		}
+		report, packet, _ := single.GetMatchingAction(query.RemoteIP, query.Port)
+
+		explanation.Evaluated = append(explanation.Evaluated, &RuleEvaluation{
+			Index:   i,
+			ACL:     &rules[i],
+			Matched: report == rules[i].Policy || packet == rules[i].Policy,
+		})
+
+		if explanation.RuleIndex < 0 && rules[i].Policy == explanation.Packet {
+			explanation.RuleIndex = i
+		}
+
+		if explanation.ReportRuleIndex < 0 && rules[i].Policy == explanation.Report {
+			explanation.ReportRuleIndex = i
+		}
+	}
+
+	return nil
+}
+
+// copyTagSelectors copies the rules with their own flow policies. The
+// search can modify the flow policies and every rule needs a distinct
+// policy to be identified.
+func copyTagSelectors(rules policy.TagSelectorList) policy.TagSelectorList {
+
+	list := rules.Copy()
+	for i := range list {
+		if list[i].Policy != nil {
+			p := *list[i].Policy
+			list[i].Policy = &p
+		}
+	}
+
+	return list
+}
+
+// copyIPRules copies the rules with their own flow policies
+func copyIPRules(rules policy.IPRuleList) policy.IPRuleList {
+
+	list := rules.Copy()
+	for i := range list {
+		if list[i].Policy != nil {
+			p := *list[i].Policy
+			list[i].Policy = &p
+		}
+	}
+
+	return list
+}
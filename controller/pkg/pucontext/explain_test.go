@@ -0,0 +1,251 @@
+package pucontext
+
+import (
+	"net"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func explainTestPolicy() *policy.PUPolicy {
+
+	rxtags := policy.TagSelectorList{
+		{
+			Clause: []policy.KeyValueOperator{
+				{Key: "app", Value: []string{"web"}, Operator: policy.Equal},
+			},
+			Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "accept-web"},
+		},
+		{
+			Clause: []policy.KeyValueOperator{
+				{Key: "env", Value: []string{"dev"}, Operator: policy.Equal},
+			},
+			Policy: &policy.FlowPolicy{Action: policy.Reject, PolicyID: "reject-dev"},
+		},
+		{
+			Clause: []policy.KeyValueOperator{
+				{Key: "app", Value: []string{"db"}, Operator: policy.Equal},
+			},
+			Policy: &policy.FlowPolicy{Action: policy.Reject, ObserveAction: policy.ObserveContinue, PolicyID: "observe-db"},
+		},
+		{
+			Clause: []policy.KeyValueOperator{
+				{Key: "app", Value: []string{"db"}, Operator: policy.Equal},
+			},
+			Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "accept-db"},
+		},
+	}
+
+	netACLs := policy.IPRuleList{
+		{
+			Address:  "10.0.0.0/8",
+			Port:     "80",
+			Protocol: "tcp",
+			Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "acl-accept"},
+		},
+		{
+			Address:  "10.1.0.0/16",
+			Port:     "80",
+			Protocol: "tcp",
+			Policy:   &policy.FlowPolicy{Action: policy.Reject, PolicyID: "acl-reject"},
+		},
+	}
+
+	identity := policy.NewTagStoreFromSlice([]string{"app=server"})
+
+	return policy.NewPUPolicy("id", policy.Police, nil, netACLs, nil, rxtags, identity, nil, nil, []string{}, []string{}, &policy.ProxiedServicesInfo{}, nil, nil, []string{})
+}
+
+func TestExplainFlow(t *testing.T) {
+
+	Convey("Given a policy with tag rules and ACLs", t, func() {
+		plc := explainTestPolicy()
+
+		Convey("When I explain a flow with an invalid direction", func() {
+			_, err := ExplainFlow(plc, &FlowQuery{Direction: "sideways"})
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I explain a flow from a remote PU that matches an accept rule", func() {
+			e, err := ExplainFlow(plc, &FlowQuery{
+				Direction:  introspection.DirectionNetwork,
+				RemoteTags: policy.NewTagStoreFromSlice([]string{"app=web"}),
+				Port:       80,
+			})
+
+			Convey("Then the flow should be accepted by the first rule", func() {
+				So(err, ShouldBeNil)
+				So(e.Packet.Action.Accepted(), ShouldBeTrue)
+				So(e.RuleIndex, ShouldEqual, 0)
+				So(e.PolicyID, ShouldEqual, "accept-web")
+				So(e.LocalIdentity.GetSlice(), ShouldContain, "app=server")
+				So(e.Tags.GetSlice(), ShouldContain, "$sys:port=80")
+				So(len(e.Evaluated), ShouldEqual, 4)
+				So(e.Evaluated[0].Matched, ShouldBeTrue)
+				So(e.Evaluated[1].Matched, ShouldBeFalse)
+			})
+		})
+
+		Convey("When I explain a flow that matches both an accept and a reject rule", func() {
+			e, err := ExplainFlow(plc, &FlowQuery{
+				Direction:  introspection.DirectionNetwork,
+				RemoteTags: policy.NewTagStoreFromSlice([]string{"app=web", "env=dev"}),
+				Port:       80,
+			})
+
+			Convey("Then the reject rule should win", func() {
+				So(err, ShouldBeNil)
+				So(e.Packet.Action.Rejected(), ShouldBeTrue)
+				So(e.RuleIndex, ShouldEqual, 1)
+				So(e.PolicyID, ShouldEqual, "reject-dev")
+				So(e.Evaluated[0].Matched, ShouldBeTrue)
+				So(e.Evaluated[1].Matched, ShouldBeTrue)
+			})
+		})
+
+		Convey("When I explain a flow that matches an observed rule", func() {
+			e, err := ExplainFlow(plc, &FlowQuery{
+				Direction:  introspection.DirectionNetwork,
+				RemoteTags: policy.NewTagStoreFromSlice([]string{"app=db"}),
+				Port:       80,
+			})
+
+			Convey("Then the packet should be accepted and the reject reported", func() {
+				So(err, ShouldBeNil)
+				So(e.Packet.Action.Accepted(), ShouldBeTrue)
+				So(e.RuleIndex, ShouldEqual, 3)
+				So(e.Report.Action.Rejected(), ShouldBeTrue)
+				So(e.ReportRuleIndex, ShouldEqual, 2)
+				So(e.ObserveAction, ShouldEqual, policy.ObserveContinue)
+			})
+		})
+
+		Convey("When I explain a flow that matches no rule", func() {
+			e, err := ExplainFlow(plc, &FlowQuery{
+				Direction:  introspection.DirectionNetwork,
+				RemoteTags: policy.NewTagStoreFromSlice([]string{"app=other"}),
+				Port:       80,
+			})
+
+			Convey("Then the flow should be rejected by default", func() {
+				So(err, ShouldBeNil)
+				So(e.Packet.Action.Rejected(), ShouldBeTrue)
+				So(e.RuleIndex, ShouldEqual, -1)
+				So(e.PolicyID, ShouldEqual, "")
+			})
+		})
+
+		Convey("When I explain a flow from an external network", func() {
+			e, err := ExplainFlow(plc, &FlowQuery{
+				Direction: introspection.DirectionNetwork,
+				RemoteIP:  net.ParseIP("10.1.1.1"),
+				Port:      80,
+				Protocol:  "tcp",
+			})
+
+			Convey("Then the reject ACL should win and both ACLs should match", func() {
+				So(err, ShouldBeNil)
+				So(e.Packet.Action.Rejected(), ShouldBeTrue)
+				So(e.RuleIndex, ShouldEqual, 1)
+				So(e.PolicyID, ShouldEqual, "acl-reject")
+				So(len(e.Evaluated), ShouldEqual, 2)
+				So(e.Evaluated[0].Matched, ShouldBeTrue)
+				So(e.Evaluated[1].Matched, ShouldBeTrue)
+			})
+		})
+
+		Convey("When I explain a flow from an external network that matches no ACL", func() {
+			e, err := ExplainFlow(plc, &FlowQuery{
+				Direction: introspection.DirectionNetwork,
+				RemoteIP:  net.ParseIP("192.168.1.1"),
+				Port:      80,
+			})
+
+			Convey("Then the catch all policy should be applied", func() {
+				So(err, ShouldBeNil)
+				So(e.Packet.Action.Rejected(), ShouldBeTrue)
+				So(e.RuleIndex, ShouldEqual, -1)
+				So(e.PolicyID, ShouldEqual, "default")
+			})
+		})
+
+		Convey("When I explain a flow from an external network without an address", func() {
+			_, err := ExplainFlow(plc, &FlowQuery{Direction: introspection.DirectionNetwork})
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I explain flows with the first match mode", func() {
+			plc.SetConflictMode(policy.FirstMatch)
+
+			e, err := ExplainFlow(plc, &FlowQuery{
+				Direction:  introspection.DirectionNetwork,
+				RemoteTags: policy.NewTagStoreFromSlice([]string{"app=web", "env=dev"}),
+				Port:       80,
+			})
+
+			Convey("Then the first rule that matches should win", func() {
+				So(err, ShouldBeNil)
+				So(e.Packet.Action.Accepted(), ShouldBeTrue)
+				So(e.RuleIndex, ShouldEqual, 0)
+				So(e.PolicyID, ShouldEqual, "accept-web")
+			})
+
+			e, err = ExplainFlow(plc, &FlowQuery{
+				Direction: introspection.DirectionNetwork,
+				RemoteIP:  net.ParseIP("10.1.1.1"),
+				Port:      80,
+				Protocol:  "tcp",
+			})
+
+			Convey("Then the first ACL that matches should win", func() {
+				So(err, ShouldBeNil)
+				So(e.Packet.Action.Accepted(), ShouldBeTrue)
+				So(e.PolicyID, ShouldEqual, "acl-accept")
+			})
+		})
+
+		Convey("When I explain a flow with the most specific mode", func() {
+			plc.SetConflictMode(policy.MostSpecific)
+
+			e, err := ExplainFlow(plc, &FlowQuery{
+				Direction: introspection.DirectionNetwork,
+				RemoteIP:  net.ParseIP("10.1.1.1"),
+				Port:      80,
+				Protocol:  "tcp",
+			})
+
+			Convey("Then the ACL with the longest prefix should win", func() {
+				So(err, ShouldBeNil)
+				So(e.Packet.Action.Rejected(), ShouldBeTrue)
+				So(e.PolicyID, ShouldEqual, "acl-reject")
+			})
+		})
+
+		Convey("When I explain an encrypted flow", func() {
+			plc.AddReceiverRules(policy.TagSelector{
+				Clause: []policy.KeyValueOperator{
+					{Key: "app", Value: []string{"web"}, Operator: policy.Equal},
+				},
+				Policy: &policy.FlowPolicy{Action: policy.Accept | policy.Encrypt, PolicyID: "encrypt-web"},
+			})
+
+			_, err := ExplainFlow(plc, &FlowQuery{
+				Direction:  introspection.DirectionNetwork,
+				RemoteTags: policy.NewTagStoreFromSlice([]string{"app=web"}),
+			})
+
+			Convey("Then the rules of the policy should not be modified", func() {
+				So(err, ShouldBeNil)
+				So(plc.ReceiverRules()[0].Policy.Action, ShouldEqual, policy.Accept)
+			})
+		})
+	})
+}
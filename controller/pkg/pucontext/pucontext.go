@@ -11,6 +11,7 @@ import (
 	"github.com/aporeto-inc/trireme-lib/common"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/acls"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/lookup"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/introspection"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packet"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
@@ -23,30 +24,40 @@ type policies struct {
 	acceptRules        *lookup.PolicyDB // Packet:  Forward       Report: Forward
 	observeApplyRules  *lookup.PolicyDB // Packet:  Forward       Report: Forward
 	encryptRules       *lookup.PolicyDB // Packet: Encrypt       Report: Encrypt
+	orderedRules       *lookup.PolicyDB // All the rules in the order of the conflict mode
+	rules              policy.TagSelectorList
+	mode               policy.ConflictMode
 }
 
 // PUContext holds data indexed by the PU ID
 type PUContext struct {
-	id                string
-	managementID      string
-	identity          *policy.TagStore
-	annotations       *policy.TagStore
-	txt               *policies
-	rcv               *policies
-	applicationACLs   *acls.ACLCache
-	networkACLs       *acls.ACLCache
-	externalIPCache   cache.DataStore
-	mark              string
-	ProxyPort         string
-	ports             []string
-	puType            common.PUType
-	synToken          []byte
-	synServiceContext []byte
-	synExpiration     time.Time
-	jwt               string
-	jwtExpiration     time.Time
-	scopes            []string
-	Extension         interface{}
+	id                 string
+	managementID       string
+	identity           *policy.TagStore
+	annotations        *policy.TagStore
+	txt                *policies
+	rcv                *policies
+	applicationACLs    *acls.ACLCache
+	networkACLs        *acls.ACLCache
+	applicationUDPACLs *acls.ACLCache
+	networkUDPACLs     *acls.ACLCache
+	externalIPCache    cache.DataStore
+	mark               string
+	ProxyPort          string
+	ports              []string
+	puType             common.PUType
+	synToken           []byte
+	synServiceContext  []byte
+	synExpiration      time.Time
+	jwt                string
+	jwtExpiration      time.Time
+	scopes             []string
+	appACLs            policy.IPRuleList
+	netACLs            policy.IPRuleList
+	txtRules           policy.TagSelectorList
+	rcvRules           policy.TagSelectorList
+	conflictMode       policy.ConflictMode
+	Extension          interface{}
 	sync.RWMutex
 }
 
@@ -54,16 +65,21 @@ type PUContext struct {
 func NewPU(contextID string, puInfo *policy.PUInfo, timeout time.Duration) (*PUContext, error) {
 
 	pu := &PUContext{
-		id:              contextID,
-		managementID:    puInfo.Policy.ManagementID(),
-		puType:          puInfo.Runtime.PUType(),
-		identity:        puInfo.Policy.Identity(),
-		annotations:     puInfo.Policy.Annotations(),
-		externalIPCache: cache.NewCacheWithExpiration("External IP Cache", timeout),
-		applicationACLs: acls.NewACLCache(),
-		networkACLs:     acls.NewACLCache(),
-		mark:            puInfo.Runtime.Options().CgroupMark,
-		scopes:          puInfo.Policy.Scopes(),
+		id:                 contextID,
+		managementID:       puInfo.Policy.ManagementID(),
+		puType:             puInfo.Runtime.PUType(),
+		identity:           puInfo.Policy.Identity(),
+		annotations:        puInfo.Policy.Annotations(),
+		externalIPCache:    cache.NewCacheWithExpiration("External IP Cache", timeout),
+		applicationACLs:    acls.NewACLCacheWithMode("tcp", puInfo.Policy.ConflictMode()),
+		networkACLs:        acls.NewACLCacheWithMode("tcp", puInfo.Policy.ConflictMode()),
+		applicationUDPACLs: acls.NewACLCacheWithMode("udp", puInfo.Policy.ConflictMode()),
+		networkUDPACLs:     acls.NewACLCacheWithMode("udp", puInfo.Policy.ConflictMode()),
+		conflictMode:       puInfo.Policy.ConflictMode(),
+		mark:               puInfo.Runtime.Options().CgroupMark,
+		scopes:             puInfo.Policy.Scopes(),
+		appACLs:            puInfo.Policy.ApplicationACLs(),
+		netACLs:            puInfo.Policy.NetworkACLs(),
 	}
 
 	pu.CreateRcvRules(puInfo.Policy.ReceiverRules())
@@ -81,6 +97,14 @@ func NewPU(contextID string, puInfo *policy.PUInfo, timeout time.Duration) (*PUC
 		return nil, err
 	}
 
+	if err := pu.applicationUDPACLs.AddRuleList(puInfo.Policy.ApplicationACLs()); err != nil {
+		return nil, err
+	}
+
+	if err := pu.networkUDPACLs.AddRuleList(puInfo.Policy.NetworkACLs()); err != nil {
+		return nil, err
+	}
+
 	return pu, nil
 
 }
@@ -127,7 +151,10 @@ func (p *PUContext) RetrieveCachedExternalFlowPolicy(id string) (interface{}, er
 
 // NetworkACLPolicy retrieves the policy based on ACLs
 func (p *PUContext) NetworkACLPolicy(packet *packet.Packet) (report *policy.FlowPolicy, action *policy.FlowPolicy, err error) {
-	return p.networkACLs.GetMatchingAction(packet.SourceAddress.To4(), packet.DestinationPort)
+	if isUDP(packet) {
+		return p.networkUDPACLs.GetMatchingAction(packet.SourceAddress, packet.DestinationPort)
+	}
+	return p.networkACLs.GetMatchingAction(packet.SourceAddress, packet.DestinationPort)
 }
 
 // NetworkACLPolicyFromAddr retrieve the policy given an address and port.
@@ -137,7 +164,10 @@ func (p *PUContext) NetworkACLPolicyFromAddr(addr net.IP, port uint16) (report *
 
 // ApplicationACLPolicy retrieves the policy based on ACLs
 func (p *PUContext) ApplicationACLPolicy(packet *packet.Packet) (report *policy.FlowPolicy, action *policy.FlowPolicy, err error) {
-	return p.applicationACLs.GetMatchingAction(packet.SourceAddress.To4(), packet.SourcePort)
+	if isUDP(packet) {
+		return p.applicationUDPACLs.GetMatchingAction(packet.SourceAddress, packet.SourcePort)
+	}
+	return p.applicationACLs.GetMatchingAction(packet.SourceAddress, packet.SourcePort)
 }
 
 // ApplicationACLPolicyFromAddr retrieve the policy given an address and port.
@@ -145,6 +175,11 @@ func (p *PUContext) ApplicationACLPolicyFromAddr(addr net.IP, port uint16) (repo
 	return p.applicationACLs.GetMatchingAction(addr, port)
 }
 
+// ApplicationUDPACLPolicyFromAddr retrieve the policy of UDP flows given an address and port.
+func (p *PUContext) ApplicationUDPACLPolicyFromAddr(addr net.IP, port uint16) (report *policy.FlowPolicy, action *policy.FlowPolicy, err error) {
+	return p.applicationUDPACLs.GetMatchingAction(addr, port)
+}
+
 // CacheExternalFlowPolicy will cache an external flow
 func (p *PUContext) CacheExternalFlowPolicy(packet *packet.Packet, plc interface{}) {
 	p.externalIPCache.AddOrUpdate(packet.SourceAddress.String()+":"+strconv.Itoa(int(packet.SourcePort)), plc)
@@ -227,6 +262,37 @@ func (p *PUContext) UpdateJWT(jwt string, expiration time.Time) {
 	p.jwtExpiration = expiration
 }
 
+// Snapshot returns a copy of the state of the PU for introspection
+func (p *PUContext) Snapshot() *introspection.PUState {
+
+	p.RLock()
+	defer p.RUnlock()
+
+	state := &introspection.PUState{
+		ContextID:        p.id,
+		ManagementID:     p.managementID,
+		Type:             p.puType,
+		Mark:             p.mark,
+		Ports:            append([]string{}, p.ports...),
+		ProxyPort:        p.ProxyPort,
+		Scopes:           append([]string{}, p.scopes...),
+		ApplicationACLs:  p.appACLs.Copy(),
+		NetworkACLs:      p.netACLs.Copy(),
+		TransmitterRules: p.txtRules.Copy(),
+		ReceiverRules:    p.rcvRules.Copy(),
+	}
+
+	if p.identity != nil {
+		state.Identity = p.identity.GetSlice()
+	}
+
+	if p.annotations != nil {
+		state.Annotations = p.annotations.GetSlice()
+	}
+
+	return state
+}
+
 // createRuleDBs creates the database of rules from the policy
 func (p *PUContext) createRuleDBs(policyRules policy.TagSelectorList) *policies {
 
@@ -237,6 +303,18 @@ func (p *PUContext) createRuleDBs(policyRules policy.TagSelectorList) *policies
 		observeAcceptRules: lookup.NewPolicyDB(),
 		observeApplyRules:  lookup.NewPolicyDB(),
 		encryptRules:       lookup.NewPolicyDB(),
+		mode:               p.conflictMode,
+	}
+
+	// In the other modes the action of a rule does not give its precedence,
+	// and all the rules are searched in the same database
+	if p.conflictMode != policy.DenyOverrides {
+		policyDB.orderedRules = lookup.NewPolicyDBWithMode(p.conflictMode)
+		policyDB.rules = policyRules
+		for _, rule := range policyRules {
+			policyDB.orderedRules.AddPolicy(rule)
+		}
+		return policyDB
 	}
 
 	for _, rule := range policyRules {
@@ -267,11 +345,13 @@ func (p *PUContext) createRuleDBs(policyRules policy.TagSelectorList) *policies
 // CreateRcvRules create receive rules for this PU based on the update of the policy.
 func (p *PUContext) CreateRcvRules(policyRules policy.TagSelectorList) {
 	p.rcv = p.createRuleDBs(policyRules)
+	p.rcvRules = policyRules
 }
 
 // CreateTxtRules create receive rules for this PU based on the update of the policy.
 func (p *PUContext) CreateTxtRules(policyRules policy.TagSelectorList) {
 	p.txt = p.createRuleDBs(policyRules)
+	p.txtRules = policyRules
 }
 
 // searchRules searches all reject, accpet and observed rules and returns reporting and packet forwarding action
@@ -281,6 +361,10 @@ func (p *PUContext) searchRules(
 	skipRejectPolicies bool,
 ) (report *policy.FlowPolicy, packet *policy.FlowPolicy) {
 
+	if policies.orderedRules != nil {
+		return p.searchOrderedRules(policies, tags, skipRejectPolicies)
+	}
+
 	var reportingAction *policy.FlowPolicy
 	var packetAction *policy.FlowPolicy
 
@@ -352,6 +436,56 @@ func (p *PUContext) searchRules(
 	return reportingAction, packetAction
 }
 
+// searchOrderedRules searches the rules in the order of the conflict mode of
+// the policies. The first rule that applies an action decides the packet
+// action. An observe continue rule is reported if it comes before that rule.
+func (p *PUContext) searchOrderedRules(
+	policies *policies,
+	tags *policy.TagStore,
+	skipRejectPolicies bool,
+) (report *policy.FlowPolicy, packet *policy.FlowPolicy) {
+
+	candidate := func(observed bool) func(interface{}) bool {
+		return func(action interface{}) bool {
+			p := action.(*policy.FlowPolicy)
+			if p.ObserveAction.ObserveContinue() != observed {
+				return false
+			}
+			if p.Action.Rejected() {
+				return !skipRejectPolicies
+			}
+			return p.Action.Accepted()
+		}
+	}
+
+	index, action := policies.orderedRules.SearchFunc(tags, candidate(false))
+	if index >= 0 {
+		packet = action.(*policy.FlowPolicy)
+	} else {
+		// Handle default if nothing provides to drop with no policyID.
+		packet = &policy.FlowPolicy{
+			Action:   policy.Reject,
+			PolicyID: "",
+		}
+	}
+
+	report = packet
+
+	observeIndex, observeAction := policies.orderedRules.SearchFunc(tags, candidate(true))
+	if observeIndex >= 0 {
+		// The indexes of the database are the positions of the rules
+		// starting at one
+		if index < 0 || policies.mode.Precedes(
+			policies.rules[observeIndex-1].Order(observeIndex),
+			policies.rules[index-1].Order(index),
+		) {
+			report = observeAction.(*policy.FlowPolicy)
+		}
+	}
+
+	return report, packet
+}
+
 // SearchTxtRules searches both receive and observed transmit rules and returns the index and action
 func (p *PUContext) SearchTxtRules(
 	tags *policy.TagStore,
@@ -366,3 +500,8 @@ func (p *PUContext) SearchRcvRules(
 ) (report *policy.FlowPolicy, packet *policy.FlowPolicy) {
 	return p.searchRules(p.rcv, tags, false)
 }
+
+// isUDP returns true if the packet is a UDP packet
+func isUDP(p *packet.Packet) bool {
+	return p.IPProto == packet.IPProtocolUDP
+}
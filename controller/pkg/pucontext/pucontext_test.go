@@ -0,0 +1,72 @@
+package pucontext
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSearchRulesConflictModes(t *testing.T) {
+
+	selector := func(key, value string, action policy.ActionType, observe policy.ObserveActionType, priority int) policy.TagSelector {
+		return policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{Key: key, Value: []string{value}, Operator: policy.Equal},
+			},
+			Policy:   &policy.FlowPolicy{Action: action, ObserveAction: observe, PolicyID: key + "-" + value},
+			Priority: priority,
+		}
+	}
+
+	rules := policy.TagSelectorList{
+		selector("app", "web", policy.Accept, policy.ObserveNone, 0),
+		selector("env", "dev", policy.Reject, policy.ObserveNone, 10),
+		selector("team", "a", policy.Reject, policy.ObserveContinue, 20),
+		selector("zone", "b", policy.Accept, policy.ObserveApply, 0),
+	}
+
+	Convey("Given the rules of a PU in the first match mode", t, func() {
+		p := &PUContext{conflictMode: policy.FirstMatch}
+		dbs := p.createRuleDBs(rules)
+
+		Convey("The rule with the highest priority should decide", func() {
+			report, packet := p.searchRules(dbs, policy.NewTagStoreFromSlice([]string{"app=web", "env=dev"}), false)
+			So(packet.PolicyID, ShouldEqual, "env-dev")
+			So(report, ShouldEqual, packet)
+		})
+
+		Convey("The reject rules should be skipped if requested", func() {
+			_, packet := p.searchRules(dbs, policy.NewTagStoreFromSlice([]string{"app=web", "env=dev"}), true)
+			So(packet.PolicyID, ShouldEqual, "app-web")
+		})
+
+		Convey("The observed rules before the decision should be reported", func() {
+			report, packet := p.searchRules(dbs, policy.NewTagStoreFromSlice([]string{"team=a", "zone=b"}), false)
+			So(packet.PolicyID, ShouldEqual, "zone-b")
+			So(report.PolicyID, ShouldEqual, "team-a")
+		})
+
+		Convey("The default should reject", func() {
+			report, packet := p.searchRules(dbs, policy.NewTagStoreFromSlice([]string{"team=a"}), false)
+			So(packet.Action.Rejected(), ShouldBeTrue)
+			So(packet.PolicyID, ShouldEqual, "")
+			So(report.PolicyID, ShouldEqual, "team-a")
+		})
+	})
+
+	Convey("Given the rules of a PU in the deny overrides mode", t, func() {
+		p := &PUContext{}
+		dbs := p.createRuleDBs(rules)
+
+		Convey("The reject rule should override the accept rules", func() {
+			_, packet := p.searchRules(dbs, policy.NewTagStoreFromSlice([]string{"app=web", "env=dev", "zone=b"}), false)
+			So(packet.PolicyID, ShouldEqual, "env-dev")
+		})
+
+		Convey("The observe apply rules should be evaluated last", func() {
+			_, packet := p.searchRules(dbs, policy.NewTagStoreFromSlice([]string{"app=web", "zone=b"}), false)
+			So(packet.PolicyID, ShouldEqual, "app-web")
+		})
+	})
+}
@@ -21,6 +21,12 @@ const (
 	EnforcerExit = "RemoteEnforcer.EnforcerExit"
 	// UpdateSecrets is string for invoking updatesecrets RPC
 	UpdateSecrets = "RemoteEnforcer.UpdateSecrets"
+	// GetPU is string for invoking the PU introspection RPC
+	GetPU = "RemoteEnforcer.GetPU"
+	// ListConnections is string for invoking the connections introspection RPC
+	ListConnections = "RemoteEnforcer.ListConnections"
+	// ListPeerKeys is string for invoking the peer keys introspection RPC
+	ListPeerKeys = "RemoteEnforcer.ListPeerKeys"
 )
 
 // RemoteIntf is the interface implemented by the remote enforcer
@@ -47,4 +53,13 @@ type RemoteIntf interface {
 	// EnforcerExit this method is called when  we received a killrpocess message from the controller
 	// This allows a graceful exit of the enforcer
 	EnforcerExit(req rpcwrapper.Request, resp *rpcwrapper.Response) error
+
+	// GetPU returns the state of a PU enforced by the remote enforcer
+	GetPU(req rpcwrapper.Request, resp *rpcwrapper.Response) error
+
+	// ListConnections returns the connections of a PU tracked by the remote enforcer
+	ListConnections(req rpcwrapper.Request, resp *rpcwrapper.Response) error
+
+	// ListPeerKeys returns the keys of the peers seen by the remote enforcer
+	ListPeerKeys(req rpcwrapper.Request, resp *rpcwrapper.Response) error
 }
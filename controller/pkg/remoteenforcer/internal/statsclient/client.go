@@ -4,17 +4,21 @@ import (
 	"context"
 	"errors"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/aporeto-inc/trireme-lib/controller/constants"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/utils/rpcwrapper"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/metrics"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/remoteenforcer/internal/statscollector"
 )
 
 const (
 	defaultStatsIntervalMiliseconds = 1000
+	defaultMetricsInterval          = 10
 	defaultUserRetention            = 10
 	statsContextID                  = "UNUSED"
 	statsRPCCommand                 = "StatsServer.GetStats"
@@ -29,7 +33,10 @@ type statsClient struct {
 	statsChannel  string
 	statsInterval time.Duration
 	userRetention time.Duration
-	stop          chan bool
+	// metricsInterval is the interval at which the metrics are forwarded
+	metricsInterval time.Duration
+	source          string
+	stop            chan bool
 }
 
 // NewStatsClient initializes a new stats client
@@ -42,7 +49,10 @@ func NewStatsClient(cr statscollector.Collector) (StatsClient, error) {
 		statsChannel:  os.Getenv(constants.EnvStatsChannel),
 		statsInterval: defaultStatsIntervalMiliseconds * time.Millisecond,
 		userRetention: defaultUserRetention * time.Minute,
-		stop:          make(chan bool),
+		// The context socket is named after the context of the enforcer
+		metricsInterval: defaultMetricsInterval * time.Second,
+		source:          strings.TrimSuffix(filepath.Base(os.Getenv(constants.EnvContextSocket)), ".sock"),
+		stop:            make(chan bool),
 	}
 
 	if sc.statsChannel == "" {
@@ -61,6 +71,7 @@ func (s *statsClient) sendStats(ctx context.Context) {
 
 	ticker := time.NewTicker(s.statsInterval)
 	userTicker := time.NewTicker(s.userRetention)
+	metricsTicker := time.NewTicker(s.metricsInterval)
 	// nolint : gosimple
 	for {
 		select {
@@ -87,6 +98,8 @@ func (s *statsClient) sendStats(ctx context.Context) {
 			); err != nil {
 				zap.L().Error("RPC failure in sending statistics: Unable to send flows")
 			}
+		case <-metricsTicker.C:
+			s.sendMetrics()
 		case <-userTicker.C:
 			s.collector.FlushUserCache()
 		case <-ctx.Done():
@@ -96,6 +109,26 @@ func (s *statsClient) sendStats(ctx context.Context) {
 
 }
 
+// sendMetrics forwards the metrics of the remote enforcer to the controller
+func (s *statsClient) sendMetrics() {
+
+	request := rpcwrapper.Request{
+		Payload: &rpcwrapper.StatsPayload{
+			Source:  s.source,
+			Metrics: metrics.DefaultRegistry.Gather(),
+		},
+	}
+
+	if err := s.rpchdl.RemoteCall(
+		statsContextID,
+		statsRPCCommand,
+		&request,
+		&rpcwrapper.Response{},
+	); err != nil {
+		zap.L().Error("RPC failure in sending statistics: Unable to send metrics")
+	}
+}
+
 // Start This is an private function called by the remoteenforcer to connect back
 // to the controller over a stats channel
 func (s *statsClient) Run(ctx context.Context) error {
@@ -135,3 +135,45 @@ func (m *MockRemoteIntf) EnforcerExit(req rpcwrapper.Request, resp *rpcwrapper.R
 func (mr *MockRemoteIntfMockRecorder) EnforcerExit(req, resp interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnforcerExit", reflect.TypeOf((*MockRemoteIntf)(nil).EnforcerExit), req, resp)
 }
+
+// GetPU mocks base method
+// nolint
+func (m *MockRemoteIntf) GetPU(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	ret := m.ctrl.Call(m, "GetPU", req, resp)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GetPU indicates an expected call of GetPU
+// nolint
+func (mr *MockRemoteIntfMockRecorder) GetPU(req, resp interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPU", reflect.TypeOf((*MockRemoteIntf)(nil).GetPU), req, resp)
+}
+
+// ListConnections mocks base method
+// nolint
+func (m *MockRemoteIntf) ListConnections(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	ret := m.ctrl.Call(m, "ListConnections", req, resp)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ListConnections indicates an expected call of ListConnections
+// nolint
+func (mr *MockRemoteIntfMockRecorder) ListConnections(req, resp interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListConnections", reflect.TypeOf((*MockRemoteIntf)(nil).ListConnections), req, resp)
+}
+
+// ListPeerKeys mocks base method
+// nolint
+func (m *MockRemoteIntf) ListPeerKeys(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	ret := m.ctrl.Call(m, "ListPeerKeys", req, resp)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ListPeerKeys indicates an expected call of ListPeerKeys
+// nolint
+func (mr *MockRemoteIntfMockRecorder) ListPeerKeys(req, resp interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPeerKeys", reflect.TypeOf((*MockRemoteIntf)(nil).ListPeerKeys), req, resp)
+}
@@ -21,11 +21,13 @@ import (
 	"syscall"
 
 	_ "github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/utils/nsenter" // nolint
+	_ "github.com/aporeto-inc/trireme-lib/utils/crypto/pkcs11"                        // nolint
 
 	"github.com/aporeto-inc/trireme-lib/controller/constants"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/enforcer/utils/rpcwrapper"
 	"github.com/aporeto-inc/trireme-lib/controller/internal/supervisor"
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/certissuer"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/packetprocessor"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/remoteenforcer/internal/statsclient"
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/remoteenforcer/internal/statscollector"
@@ -102,6 +104,13 @@ func (s *RemoteEnforcer) setupEnforcer(req rpcwrapper.Request) error {
 		return err
 	}
 
+	var issuer certissuer.CertificateIssuer
+	if ca := payload.CertificateIssuer; ca != nil {
+		if issuer, err = certissuer.NewLocalCA(ca.KeyPEM, ca.CertificatePEM, ca.TrustDomain, ca.Validity); err != nil {
+			return err
+		}
+	}
+
 	if s.enforcer, err = enforcer.New(
 		payload.MutualAuth,
 		payload.FqConfig,
@@ -114,6 +123,11 @@ func (s *RemoteEnforcer) setupEnforcer(req rpcwrapper.Request) error {
 		s.procMountPoint,
 		payload.ExternalIPCacheTimeout,
 		payload.PacketLogs,
+		payload.TokenFormat,
+		payload.TagDictionary,
+		payload.ClaimsKey,
+		issuer,
+		payload.CertificateRenewal,
 	); err != nil || s.enforcer == nil {
 		return fmt.Errorf("Error while initializing remote enforcer, %s", err)
 	}
@@ -213,6 +227,18 @@ func (s *RemoteEnforcer) InitSupervisor(req rpcwrapper.Request, resp *rpcwrapper
 		case rpcwrapper.IPSets:
 			//TO DO
 			return errors.New("ipsets not supported yet")
+		case rpcwrapper.NFTables:
+			supervisorHandle, err := supervisor.NewNFTablesSupervisor(
+				s.collector,
+				s.enforcer,
+				constants.RemoteContainer,
+				payload.TriremeNetworks,
+			)
+			if err != nil {
+				zap.L().Error("unable to instantiate the nftables supervisor", zap.Error(err))
+				return err
+			}
+			s.supervisor = supervisorHandle
 		default:
 			supervisorHandle, err := supervisor.NewSupervisor(
 				s.collector,
@@ -369,15 +395,22 @@ func (s *RemoteEnforcer) UpdateSecrets(req rpcwrapper.Request, resp *rpcwrapper.
 	cmdLock.Lock()
 	defer cmdLock.Unlock()
 	if s.enforcer == nil {
+		resp.Status = "enforcer not initialized"
 		return fmt.Errorf(resp.Status)
 	}
 
 	payload := req.Payload.(rpcwrapper.UpdateSecretsPayload)
-	s.secrets, err = secrets.NewSecrets(payload.Secrets)
+	updated, err := secrets.NewSecrets(payload.Secrets)
 	if err != nil {
 		return err
 	}
 
+	// The keys of the peers pinned by this enforcer stay pinned
+	if s.secrets != nil {
+		secrets.KeepPeerKeys(updated, s.secrets)
+	}
+	s.secrets = updated
+
 	err = s.enforcer.UpdateSecrets(s.secrets)
 	if err != nil {
 		return err
@@ -385,6 +418,93 @@ func (s *RemoteEnforcer) UpdateSecrets(req rpcwrapper.Request, resp *rpcwrapper.
 	return nil
 }
 
+// GetPU returns the state of a PU enforced by the remote enforcer
+func (s *RemoteEnforcer) GetPU(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = "get pu message auth failed"
+		return fmt.Errorf(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+
+	if s.enforcer == nil {
+		resp.Status = "enforcer not initialized - cannot introspect"
+		return fmt.Errorf(resp.Status)
+	}
+
+	payload := req.Payload.(rpcwrapper.IntrospectPayload)
+
+	pu, err := s.enforcer.GetPU(payload.ContextID)
+	if err != nil {
+		resp.Status = err.Error()
+		return err
+	}
+
+	resp.Payload = rpcwrapper.PUStateResponsePayload{PU: pu}
+
+	return nil
+}
+
+// ListConnections returns the connections of a PU tracked by the remote enforcer
+func (s *RemoteEnforcer) ListConnections(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = "list connections message auth failed"
+		return fmt.Errorf(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+
+	if s.enforcer == nil {
+		resp.Status = "enforcer not initialized - cannot introspect"
+		return fmt.Errorf(resp.Status)
+	}
+
+	payload := req.Payload.(rpcwrapper.IntrospectPayload)
+
+	connections, err := s.enforcer.ListConnections(payload.ContextID)
+	if err != nil {
+		resp.Status = err.Error()
+		return err
+	}
+
+	resp.Payload = rpcwrapper.ConnectionsResponsePayload{Connections: connections}
+
+	return nil
+}
+
+// ListPeerKeys returns the keys of the peers seen by the remote enforcer
+func (s *RemoteEnforcer) ListPeerKeys(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = "list peer keys message auth failed"
+		return fmt.Errorf(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+
+	if s.enforcer == nil {
+		resp.Status = "enforcer not initialized - cannot introspect"
+		return fmt.Errorf(resp.Status)
+	}
+
+	payload := req.Payload.(rpcwrapper.IntrospectPayload)
+
+	keys, err := s.enforcer.ListPeerKeys(payload.ContextID)
+	if err != nil {
+		resp.Status = err.Error()
+		return err
+	}
+
+	resp.Payload = rpcwrapper.PeerKeysResponsePayload{Keys: keys}
+
+	return nil
+}
+
 // LaunchRemoteEnforcer launches a remote enforcer
 func LaunchRemoteEnforcer(service packetprocessor.PacketProcessor) error {
 
@@ -60,3 +60,18 @@ func (s *RemoteEnforcer) Enforce(req rpcwrapper.Request, resp *rpcwrapper.Respon
 func (s *RemoteEnforcer) EnforcerExit(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
 	return nil
 }
+
+// GetPU returns the state of a PU enforced by the remote enforcer
+func (s *RemoteEnforcer) GetPU(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	return nil
+}
+
+// ListConnections returns the connections of a PU tracked by the remote enforcer
+func (s *RemoteEnforcer) ListConnections(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	return nil
+}
+
+// ListPeerKeys returns the keys of the peers seen by the remote enforcer
+func (s *RemoteEnforcer) ListPeerKeys(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	return nil
+}
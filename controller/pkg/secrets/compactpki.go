@@ -1,12 +1,12 @@
 package secrets
 
 import (
-	"crypto/ecdsa"
+	"crypto"
 	"crypto/x509"
 	"errors"
 
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/pkiverifier"
-	"github.com/aporeto-inc/trireme-lib/utils/crypto"
+	cryptoutils "github.com/aporeto-inc/trireme-lib/utils/crypto"
 	"go.uber.org/zap"
 )
 
@@ -16,10 +16,12 @@ type CompactPKI struct {
 	PublicKeyPEM  []byte
 	AuthorityPEM  []byte
 	TokenKeyPEMs  [][]byte
-	privateKey    *ecdsa.PrivateKey
+	privateKey    crypto.Signer
 	publicKey     *x509.Certificate
 	txKey         []byte
+	tokenCerts    []*x509.Certificate
 	verifier      pkiverifier.PKITokenVerifier
+	revocation    *cryptoutils.RevocationChecker
 }
 
 // NewCompactPKI creates new secrets for PKI implementation based on compact encoding
@@ -34,18 +36,23 @@ func NewCompactPKIWithTokenCA(keyPEM []byte, certPEM []byte, caPEM []byte, token
 
 	zap.L().Debug("Initializing with Compact PKI")
 
-	key, cert, _, err := crypto.LoadAndVerifyECSecrets(keyPEM, certPEM, caPEM)
+	key, cert, _, err := cryptoutils.LoadAndVerifySecrets(keyPEM, certPEM, caPEM)
 	if err != nil {
 		return nil, err
 	}
 
-	var tokenKeys []*ecdsa.PublicKey
+	var tokenKeys []crypto.PublicKey
+	var tokenCerts []*x509.Certificate
 	for _, ca := range tokenKeyPEMs {
-		caCert, err := crypto.LoadCertificate(ca)
+		caCert, err := cryptoutils.LoadCertificate(ca)
 		if err != nil {
 			return nil, err
 		}
-		tokenKeys = append(tokenKeys, caCert.PublicKey.(*ecdsa.PublicKey))
+		if err := cryptoutils.CheckPublicKey(caCert.PublicKey); err != nil {
+			return nil, err
+		}
+		tokenKeys = append(tokenKeys, caCert.PublicKey)
+		tokenCerts = append(tokenCerts, caCert)
 	}
 
 	if len(txKey) == 0 {
@@ -60,12 +67,28 @@ func NewCompactPKIWithTokenCA(keyPEM []byte, certPEM []byte, caPEM []byte, token
 		privateKey:    key,
 		publicKey:     cert,
 		txKey:         txKey,
+		tokenCerts:    tokenCerts,
 		verifier:      pkiverifier.NewPKIVerifier(tokenKeys, -1),
 	}
 
 	return p, nil
 }
 
+// EnableRevocation checks the revocation of the certificates of the token
+// issuers and of the certificates carried by the tokens.
+func (p *CompactPKI) EnableRevocation(config *cryptoutils.RevocationConfig) error {
+
+	revocation, err := cryptoutils.NewRevocationChecker(config)
+	if err != nil {
+		return err
+	}
+
+	p.revocation = revocation
+	p.verifier = pkiverifier.NewPKIVerifierWithRevocation(p.tokenCerts, revocation, -1)
+
+	return nil
+}
+
 // Type implements the interface Secrets
 func (p *CompactPKI) Type() PrivateSecretsType {
 	return PKICompactType
@@ -86,7 +109,7 @@ func (p *CompactPKI) DecodingKey(server string, ackKey interface{}, prevKey inte
 
 	// If we have an inband certificate, return this one
 	if ackKey != nil {
-		return ackKey.(*ecdsa.PublicKey), nil
+		return ackKey, nil
 	}
 
 	// Otherwise, return the prevCert
@@ -110,9 +133,9 @@ func (p *CompactPKI) TransmittedKey() []byte {
 	return p.txKey
 }
 
-// AckSize returns the default size of an ACK packet
+// AckSize returns the size of an ACK packet signed with the key
 func (p *CompactPKI) AckSize() uint32 {
-	return uint32(322)
+	return ackSize(322, p.privateKey)
 }
 
 // AuthPEM returns the Certificate Authority PEM
@@ -142,7 +165,7 @@ func (p *CompactPKI) EncodingPEM() []byte {
 
 // PublicSecrets returns the secrets that are marshallable over the RPC interface.
 func (p *CompactPKI) PublicSecrets() PublicSecrets {
-	return &CompactPKIPublicSecrets{
+	s := &CompactPKIPublicSecrets{
 		Type:        PKICompactType,
 		Key:         p.PrivateKeyPEM,
 		Certificate: p.PublicKeyPEM,
@@ -150,6 +173,12 @@ func (p *CompactPKI) PublicSecrets() PublicSecrets {
 		Token:       p.txKey,
 		TokenCAs:    p.TokenKeyPEMs,
 	}
+
+	if p.revocation != nil {
+		s.Revocation = p.revocation.Config()
+	}
+
+	return s
 }
 
 // CompactPKIPublicSecrets includes all the secrets that can be transmitted over
@@ -161,6 +190,7 @@ type CompactPKIPublicSecrets struct {
 	CA          []byte
 	TokenCAs    [][]byte
 	Token       []byte
+	Revocation  *cryptoutils.RevocationConfig
 }
 
 // SecretsType returns the type of secrets.
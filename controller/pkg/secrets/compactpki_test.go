@@ -3,10 +3,14 @@ package secrets
 import (
 	"crypto/ecdsa"
 	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/pkiverifier"
 	"github.com/aporeto-inc/trireme-lib/utils/crypto"
+	"github.com/aporeto-inc/trireme-lib/utils/testpki"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -156,3 +160,58 @@ func TestBasicInterfaceFunctions(t *testing.T) {
 		})
 	})
 }
+
+func TestCompactPKIRevocation(t *testing.T) {
+
+	Convey("Given compact PKI secrets with revocation checks", t, func() {
+
+		dir, err := ioutil.TempDir("", "crl")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint: errcheck
+
+		pki := testpki.NewPKI("revocation")
+		path := filepath.Join(dir, "ca.crl")
+		So(ioutil.WriteFile(path, pki.CA.CRL(1), 0600), ShouldBeNil)
+
+		cert, err := crypto.LoadCertificate(pki.Leaf.CertPEM())
+		So(err, ShouldBeNil)
+
+		token, err := pkiverifier.NewPKIIssuer(pki.CA.Key).CreateTokenFromCertificate(cert)
+		So(err, ShouldBeNil)
+
+		p, err := NewCompactPKIWithTokenCA(pki.Leaf.KeyPEM(), pki.Leaf.CertPEM(), pki.CA.CertPEM(), [][]byte{pki.CA.CertPEM()}, token)
+		So(err, ShouldBeNil)
+		So(p.EnableRevocation(&crypto.RevocationConfig{CRLPaths: []string{path}}), ShouldBeNil)
+
+		Convey("Then the token should be accepted and cached", func() {
+			_, err := p.VerifyPublicKey(token)
+			So(err, ShouldBeNil)
+
+			Convey("When the certificate of the token is revoked", func() {
+				So(ioutil.WriteFile(path, pki.CA.CRL(2, 2), 0600), ShouldBeNil)
+				So(p.revocation.Reload(), ShouldBeNil)
+
+				Convey("Then the cached token should be rejected", func() {
+					_, err := p.VerifyPublicKey(token)
+					So(crypto.IsRevoked(err), ShouldBeTrue)
+				})
+			})
+		})
+
+		Convey("When the certificate of the token issuer is revoked", func() {
+			So(ioutil.WriteFile(path, pki.CA.CRL(2, 1), 0600), ShouldBeNil)
+			So(p.revocation.Reload(), ShouldBeNil)
+
+			Convey("Then the token should be rejected", func() {
+				_, err := p.VerifyPublicKey(token)
+				So(crypto.IsRevoked(err), ShouldBeTrue)
+			})
+		})
+
+		Convey("Then the public secrets should carry the revocation configuration", func() {
+			public := p.PublicSecrets().(*CompactPKIPublicSecrets)
+			So(public.Revocation, ShouldNotBeNil)
+			So(public.Revocation.CRLPaths, ShouldResemble, []string{path})
+		})
+	})
+}
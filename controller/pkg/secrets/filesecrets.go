@@ -0,0 +1,382 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/utils/crypto"
+	"github.com/aporeto-inc/trireme-lib/utils/filewatcher"
+)
+
+// defaultDebounce is the default time to wait for the other files of a
+// bundle after a change
+const defaultDebounce = time.Second
+
+// FileSecretsConfig is the configuration of secrets loaded from PEM files
+type FileSecretsConfig struct {
+	// Type is the type of the secrets. Only PKIType and PKICompactType are
+	// supported.
+	Type PrivateSecretsType
+	// KeyPath is the path of the private key
+	KeyPath string
+	// CertPath is the path of the certificate
+	CertPath string
+	// CAPath is the path of the CA of the certificates
+	CAPath string
+	// TokenCAPaths are the paths of the CAs of the transmitted tokens of
+	// PKICompactType secrets. The CA is used if none is provided.
+	TokenCAPaths []string
+	// TokenPath is the path of the transmitted token of PKICompactType
+	// secrets
+	TokenPath string
+	// Debounce is the time to wait after a change for the other files of
+	// the bundle to be written
+	Debounce time.Duration
+	// Revocation is the optional configuration of the revocation checks of
+	// the certificates of the peers
+	Revocation *crypto.RevocationConfig
+	// Pinning pins the key first seen for each peer. It is only supported
+	// by PKIType secrets. The pinned keys are kept when the files change.
+	Pinning bool
+}
+
+// FileSecrets are secrets loaded from PEM files. The files are watched and
+// the secrets are reloaded when they change. The new bundle is validated
+// before it replaces the current secrets and is pushed to the updater.
+type FileSecrets struct {
+	config    FileSecretsConfig
+	collector collector.EventCollector
+	current   Secrets
+	contents  [][]byte
+	updater   SecretsUpdater
+	peers     *PeerKeys
+	sync.RWMutex
+}
+
+// NewFileSecrets loads the secrets from the configured files. The events
+// of the later updates are reported to the collector.
+func NewFileSecrets(config *FileSecretsConfig, c collector.EventCollector) (*FileSecrets, error) {
+
+	if config == nil {
+		return nil, errors.New("no configuration provided")
+	}
+
+	if config.Type != PKIType && config.Type != PKICompactType {
+		return nil, fmt.Errorf("unsupported secrets type %d", config.Type)
+	}
+
+	if config.KeyPath == "" || config.CertPath == "" || config.CAPath == "" {
+		return nil, errors.New("key, certificate and CA paths are required")
+	}
+
+	if config.Type == PKICompactType && config.TokenPath == "" {
+		return nil, errors.New("token path is required for compact PKI secrets")
+	}
+
+	if config.Pinning && config.Type != PKIType {
+		return nil, errors.New("pinning is only supported by PKI secrets")
+	}
+
+	if c == nil {
+		c = collector.NewDefaultCollector()
+	}
+
+	f := &FileSecrets{
+		config:    *config,
+		collector: c,
+		peers:     NewPeerKeys(config.Pinning),
+	}
+
+	if f.config.Debounce <= 0 {
+		f.config.Debounce = defaultDebounce
+	}
+
+	s, contents, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+
+	f.current = s
+	f.contents = contents
+
+	return f, nil
+}
+
+// Run watches the files until the context is done. The new secrets are
+// pushed to the updater, usually the controller that updates its enforcers
+// including the remote ones.
+func (f *FileSecrets) Run(ctx context.Context, updater SecretsUpdater) error {
+
+	changes, err := filewatcher.WatchDirectories(ctx, f.directories())
+	if err != nil {
+		return err
+	}
+
+	f.Lock()
+	f.updater = updater
+	f.Unlock()
+
+	go func() {
+		var debounce <-chan time.Time
+
+		for {
+			select {
+			case _, ok := <-changes:
+				if !ok {
+					return
+				}
+				debounce = time.After(f.config.Debounce)
+			case <-debounce:
+				debounce = nil
+				if err := f.Reload(); err != nil {
+					zap.L().Error("Unable to reload secrets", zap.Error(err))
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Reload loads the secrets from the files. If they changed, they are pushed
+// to the updater and replace the current secrets. Failures are reported to
+// the collector. The current secrets are kept if the new ones are invalid.
+func (f *FileSecrets) Reload() error {
+
+	s, contents, err := f.load()
+	if err != nil {
+		f.reportFailure(err)
+		return err
+	}
+
+	f.RLock()
+	unchanged := equalContents(f.contents, contents)
+	updater := f.updater
+	f.RUnlock()
+
+	if unchanged {
+		return nil
+	}
+
+	zap.L().Info("Secrets files changed", zap.String("cert", f.config.CertPath))
+
+	// The updater is called before the swap so that a rotation started by
+	// the update keeps the secrets currently wrapped as the previous ones.
+	// The current secrets and contents are only replaced once the update
+	// succeeded, so that a failed update is retried on the next reload.
+	if updater != nil {
+		if uerr := updater.UpdateSecrets(s); uerr != nil {
+			err = fmt.Errorf("unable to update secrets: %s", uerr)
+			f.reportFailure(err)
+			return err
+		}
+	}
+
+	f.Lock()
+	f.current = s
+	f.contents = contents
+	f.Unlock()
+
+	collector.CollectSecretsEvent(f.collector, &collector.SecretsRecord{
+		Event: collector.SecretsUpdated,
+	})
+
+	return nil
+}
+
+// Current returns the secrets currently loaded from the files
+func (f *FileSecrets) Current() Secrets {
+
+	f.RLock()
+	defer f.RUnlock()
+
+	return f.current
+}
+
+// Type implements the Secrets interface.
+func (f *FileSecrets) Type() PrivateSecretsType {
+	return f.Current().Type()
+}
+
+// EncodingKey implements the Secrets interface.
+func (f *FileSecrets) EncodingKey() interface{} {
+	return f.Current().EncodingKey()
+}
+
+// DecodingKey implements the Secrets interface.
+func (f *FileSecrets) DecodingKey(server string, ackCert, prevCert interface{}) (interface{}, error) {
+	return f.Current().DecodingKey(server, ackCert, prevCert)
+}
+
+// PublicKey implements the Secrets interface.
+func (f *FileSecrets) PublicKey() interface{} {
+	return f.Current().PublicKey()
+}
+
+// TransmittedKey implements the Secrets interface.
+func (f *FileSecrets) TransmittedKey() []byte {
+	return f.Current().TransmittedKey()
+}
+
+// VerifyPublicKey implements the Secrets interface.
+func (f *FileSecrets) VerifyPublicKey(pkey []byte) (interface{}, error) {
+	return f.Current().VerifyPublicKey(pkey)
+}
+
+// AckSize implements the Secrets interface.
+func (f *FileSecrets) AckSize() uint32 {
+	return f.Current().AckSize()
+}
+
+// PublicSecrets implements the Secrets interface.
+func (f *FileSecrets) PublicSecrets() PublicSecrets {
+	return f.Current().PublicSecrets()
+}
+
+// PeerKeys implements the PeerKeyInventory interface. The inventory is
+// kept when the files change.
+func (f *FileSecrets) PeerKeys() []PeerKey {
+	return f.peerKeys().List()
+}
+
+// peerKeys returns the inventory of the keys of the peers
+func (f *FileSecrets) peerKeys() *PeerKeys {
+
+	f.RLock()
+	defer f.RUnlock()
+
+	return f.peers
+}
+
+// setPeerKeys shares an inventory of the keys of the peers with the
+// secrets loaded from the files
+func (f *FileSecrets) setPeerKeys(peers *PeerKeys) {
+
+	f.Lock()
+	defer f.Unlock()
+
+	f.peers = peers
+	if holder, ok := f.current.(peerKeysHolder); ok {
+		holder.setPeerKeys(peers)
+	}
+}
+
+// load reads and validates the files. It returns the secrets and the
+// contents of the files.
+func (f *FileSecrets) load() (Secrets, [][]byte, error) {
+
+	paths := append([]string{f.config.KeyPath, f.config.CertPath, f.config.CAPath}, f.config.TokenCAPaths...)
+	if f.config.Type == PKICompactType {
+		paths = append(paths, f.config.TokenPath)
+	}
+
+	contents := make([][]byte, len(paths))
+	for i, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read %s: %s", path, err)
+		}
+		contents[i] = data
+	}
+
+	keyPEM, certPEM, caPEM := contents[0], contents[1], contents[2]
+
+	key, cert, _, err := crypto.LoadAndVerifySecrets(keyPEM, certPEM, caPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid secrets in %s: %s", f.config.CertPath, err)
+	}
+
+	if !crypto.KeyMatchesCertificate(key, cert) {
+		return nil, nil, fmt.Errorf("certificate %s does not match key %s", f.config.CertPath, f.config.KeyPath)
+	}
+
+	if f.config.Type == PKIType {
+		s, err := NewPKISecrets(keyPEM, certPEM, caPEM, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if f.config.Revocation != nil {
+			if err := s.EnableRevocation(f.config.Revocation); err != nil {
+				return nil, nil, err
+			}
+		}
+		s.setPeerKeys(f.peerKeys())
+		return s, contents, nil
+	}
+
+	tokenCAs := contents[3 : 3+len(f.config.TokenCAPaths)]
+	if len(tokenCAs) == 0 {
+		tokenCAs = [][]byte{caPEM}
+	}
+
+	s, err := NewCompactPKIWithTokenCA(keyPEM, certPEM, caPEM, tokenCAs, contents[len(contents)-1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid secrets in %s: %s", f.config.CertPath, err)
+	}
+
+	if f.config.Revocation != nil {
+		if err := s.EnableRevocation(f.config.Revocation); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return s, contents, nil
+}
+
+// directories returns the directories of the files. The directories are
+// watched since the files are usually replaced rather than written.
+func (f *FileSecrets) directories() []string {
+
+	paths := append([]string{f.config.KeyPath, f.config.CertPath, f.config.CAPath, f.config.TokenPath}, f.config.TokenCAPaths...)
+
+	seen := map[string]bool{}
+	dirs := []string{}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		dir := filepath.Dir(path)
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
+
+// reportFailure reports a failed update to the collector
+func (f *FileSecrets) reportFailure(err error) {
+
+	collector.CollectSecretsEvent(f.collector, &collector.SecretsRecord{
+		Event: collector.SecretsUpdateFailed,
+		Error: err.Error(),
+	})
+}
+
+// equalContents returns true if the contents of the files are the same
+func equalContents(a, b [][]byte) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
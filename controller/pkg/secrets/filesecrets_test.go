@@ -0,0 +1,228 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/utils/crypto"
+	"github.com/aporeto-inc/trireme-lib/utils/testpki"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// recordingCollector records the secrets events
+type recordingCollector struct {
+	collector.DefaultCollector
+	events []*collector.SecretsRecord
+	sync.Mutex
+}
+
+func (c *recordingCollector) CollectSecretsEvent(record *collector.SecretsRecord) {
+	c.Lock()
+	defer c.Unlock()
+	c.events = append(c.events, record)
+}
+
+func (c *recordingCollector) records() []*collector.SecretsRecord {
+	c.Lock()
+	defer c.Unlock()
+	return append([]*collector.SecretsRecord{}, c.events...)
+}
+
+// recordingUpdater records the updated secrets
+type recordingUpdater struct {
+	err     error
+	updates chan Secrets
+}
+
+func (u *recordingUpdater) UpdateSecrets(s Secrets) error {
+	u.updates <- s
+	return u.err
+}
+
+func writeTestPKI(dir string, p *testpki.PKI) {
+	So(ioutil.WriteFile(filepath.Join(dir, "key.pem"), p.Leaf.KeyPEM(), 0600), ShouldBeNil)
+	So(ioutil.WriteFile(filepath.Join(dir, "cert.pem"), p.Leaf.CertPEM(), 0600), ShouldBeNil)
+	So(ioutil.WriteFile(filepath.Join(dir, "ca.pem"), p.CA.CertPEM(), 0600), ShouldBeNil)
+}
+
+func TestFileSecrets(t *testing.T) {
+
+	Convey("Given PKI secrets in files", t, func() {
+
+		dir, err := ioutil.TempDir("", "secrets")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		oldPKI := testpki.NewPKI("old")
+		writeTestPKI(dir, oldPKI)
+
+		config := &FileSecretsConfig{
+			Type:     PKIType,
+			KeyPath:  filepath.Join(dir, "key.pem"),
+			CertPath: filepath.Join(dir, "cert.pem"),
+			CAPath:   filepath.Join(dir, "ca.pem"),
+			Debounce: 10 * time.Millisecond,
+		}
+
+		c := &recordingCollector{}
+		updater := &recordingUpdater{updates: make(chan Secrets, 10)}
+
+		Convey("When I create file secrets with an invalid configuration", func() {
+			_, err1 := NewFileSecrets(nil, c)
+			_, err2 := NewFileSecrets(&FileSecretsConfig{Type: PKIType, KeyPath: config.KeyPath}, c)
+			_, err3 := NewFileSecrets(&FileSecretsConfig{Type: PSKType, KeyPath: config.KeyPath, CertPath: config.CertPath, CAPath: config.CAPath}, c)
+			_, err4 := NewFileSecrets(&FileSecretsConfig{Type: PKICompactType, KeyPath: config.KeyPath, CertPath: config.CertPath, CAPath: config.CAPath}, c)
+
+			Convey("Then I should get errors", func() {
+				So(err1, ShouldNotBeNil)
+				So(err2, ShouldNotBeNil)
+				So(err3, ShouldNotBeNil)
+				So(err4, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I create file secrets with a certificate that does not match the key", func() {
+			So(ioutil.WriteFile(config.KeyPath, testpki.NewPKI("other").Leaf.KeyPEM(), 0600), ShouldBeNil)
+			_, err := NewFileSecrets(config, c)
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I create file secrets", func() {
+			f, err := NewFileSecrets(config, c)
+			So(err, ShouldBeNil)
+
+			f.updater = updater
+
+			Convey("Then they should use the secrets of the files", func() {
+				So(f.Type(), ShouldEqual, PKIType)
+				So(f.TransmittedKey(), ShouldResemble, oldPKI.Leaf.CertPEM())
+				So(string(f.PublicSecrets().CertAuthority()), ShouldEqual, string(oldPKI.CA.CertPEM()))
+			})
+
+			Convey("Then a reload of unchanged files should not update the secrets", func() {
+				So(f.Reload(), ShouldBeNil)
+				So(len(updater.updates), ShouldEqual, 0)
+				So(len(c.records()), ShouldEqual, 0)
+			})
+
+			Convey("Then a reload of a new bundle should update the secrets", func() {
+				newPKI := testpki.NewPKI("new")
+				writeTestPKI(dir, newPKI)
+
+				So(f.Reload(), ShouldBeNil)
+				So(len(updater.updates), ShouldEqual, 1)
+				So((<-updater.updates).TransmittedKey(), ShouldResemble, newPKI.Leaf.CertPEM())
+				So(f.TransmittedKey(), ShouldResemble, newPKI.Leaf.CertPEM())
+				So(c.records(), ShouldResemble, []*collector.SecretsRecord{{Event: collector.SecretsUpdated}})
+			})
+
+			Convey("Then a reload of a mismatched bundle should be rejected", func() {
+				So(ioutil.WriteFile(config.KeyPath, testpki.NewPKI("new").Leaf.KeyPEM(), 0600), ShouldBeNil)
+
+				So(f.Reload(), ShouldNotBeNil)
+				So(len(updater.updates), ShouldEqual, 0)
+				So(f.TransmittedKey(), ShouldResemble, oldPKI.Leaf.CertPEM())
+
+				records := c.records()
+				So(len(records), ShouldEqual, 1)
+				So(records[0].Event, ShouldEqual, collector.SecretsUpdateFailed)
+				So(records[0].Error, ShouldContainSubstring, "does not match")
+			})
+
+			Convey("Then a failed update should be reported", func() {
+				updater.err = errors.New("remote enforcer unreachable")
+				writeTestPKI(dir, testpki.NewPKI("new"))
+
+				So(f.Reload(), ShouldNotBeNil)
+
+				records := c.records()
+				So(len(records), ShouldEqual, 1)
+				So(records[0].Event, ShouldEqual, collector.SecretsUpdateFailed)
+				So(records[0].Error, ShouldContainSubstring, "remote enforcer unreachable")
+				So(f.TransmittedKey(), ShouldResemble, oldPKI.Leaf.CertPEM())
+
+				Convey("Then the next reload should retry the update", func() {
+					updater.err = nil
+					So(f.Reload(), ShouldBeNil)
+					So(len(updater.updates), ShouldEqual, 2)
+					So(f.TransmittedKey(), ShouldNotResemble, oldPKI.Leaf.CertPEM())
+				})
+			})
+
+			Convey("Then a rotation should keep the secrets they wrap", func() {
+				r, err := NewRotatedSecrets(pkiSecrets(testpki.NewPKI("new")), f, time.Hour)
+				So(err, ShouldBeNil)
+				So(r.Previous(), ShouldEqual, f.Current())
+			})
+		})
+
+		Convey("When I create file secrets that pin the keys of the peers", func() {
+			config.Pinning = true
+			f, err := NewFileSecrets(config, c)
+			So(err, ShouldBeNil)
+
+			cert, err := crypto.LoadCertificate(oldPKI.Leaf.CertPEM())
+			So(err, ShouldBeNil)
+			other, err := crypto.LoadCertificate(oldPKI.CA.Issue("other").CertPEM())
+			So(err, ShouldBeNil)
+
+			_, err = f.DecodingKey("server1", cert, nil)
+			So(err, ShouldBeNil)
+
+			Convey("Then the pinned keys should be kept when the files change", func() {
+				writeTestPKI(dir, testpki.NewPKI("new"))
+				So(f.Reload(), ShouldBeNil)
+
+				_, err := f.DecodingKey("server1", other, nil)
+				So(IsPinnedKeyChanged(err), ShouldBeTrue)
+				So(len(f.PeerKeys()), ShouldEqual, 1)
+			})
+
+			Convey("Then pinning should be rejected for compact PKI secrets", func() {
+				compact := *config
+				compact.Type = PKICompactType
+				compact.TokenPath = config.CertPath
+				_, err := NewFileSecrets(&compact, c)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I watch the files", func() {
+			if runtime.GOOS != "linux" {
+				SkipSo(runtime.GOOS, ShouldEqual, "linux")
+				return
+			}
+
+			f, err := NewFileSecrets(config, c)
+			So(err, ShouldBeNil)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			So(f.Run(ctx, updater), ShouldBeNil)
+
+			newPKI := testpki.NewPKI("new")
+			writeTestPKI(dir, newPKI)
+
+			Convey("Then the new secrets should be pushed to the updater", func() {
+				select {
+				case s := <-updater.updates:
+					So(s.TransmittedKey(), ShouldResemble, newPKI.Leaf.CertPEM())
+				case <-time.After(5 * time.Second):
+					So("timeout", ShouldBeEmpty)
+				}
+			})
+		})
+	})
+}
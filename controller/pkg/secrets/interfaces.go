@@ -6,3 +6,17 @@ type PublicKeyAdder interface {
 	// PublicKeyAdd adds the given cert for the given host.
 	PublicKeyAdd(host string, cert []byte) error
 }
+
+// PeerKeyInventory lists the keys of the peers.
+type PeerKeyInventory interface {
+
+	// PeerKeys returns the keys of the peers sorted by server ID.
+	PeerKeys() []PeerKey
+}
+
+// SecretsUpdater is updated with new secrets when they change.
+type SecretsUpdater interface {
+
+	// UpdateSecrets updates the secrets.
+	UpdateSecrets(secrets Secrets) error
+}
@@ -0,0 +1,128 @@
+package secrets
+
+import (
+	gocrypto "crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/utils/crypto"
+	"github.com/aporeto-inc/trireme-lib/utils/testpki"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testKeyStoreSigner hides the private key behind the crypto.Signer
+// interface, like the keys of an HSM.
+type testKeyStoreSigner struct {
+	key gocrypto.Signer
+}
+
+func (s *testKeyStoreSigner) Public() gocrypto.PublicKey {
+	return s.key.Public()
+}
+
+func (s *testKeyStoreSigner) Sign(rand io.Reader, digest []byte, opts gocrypto.SignerOpts) ([]byte, error) {
+	return s.key.Sign(rand, digest, opts)
+}
+
+// registerTestKeyStore registers a key store with the keys of the PEMs
+func registerTestKeyStore(keys map[string][]byte) {
+
+	crypto.RegisterKeyStore("secretstest", func(uri string) (gocrypto.Signer, error) {
+		keyPEM, ok := keys[uri]
+		if !ok {
+			return nil, errors.New("key not found")
+		}
+		key, err := crypto.LoadPrivateKey(keyPEM)
+		if err != nil {
+			return nil, err
+		}
+		return &testKeyStoreSigner{key: key}, nil
+	})
+}
+
+func TestKeyStoreSecrets(t *testing.T) {
+
+	Convey("Given keys in a key store", t, func() {
+
+		domain := testpki.NewAuthority("example.org", testpki.WithURIs("spiffe://example.org"))
+		keyPEM, certPEM := issueSVID(domain, "spiffe://example.org/enforcer")
+		otherKeyPEM, _ := issueSVID(domain, "spiffe://example.org/other")
+
+		registerTestKeyStore(map[string][]byte{
+			"secretstest:enforcer": keyPEM,
+			"secretstest:other":    otherKeyPEM,
+		})
+
+		bundles := map[string][]byte{"example.org": domain.CertPEM()}
+
+		Convey("When I create PKI secrets with the key store", func() {
+			p, err := NewPKISecretsFromKeyStore("secretstest:enforcer", certPEM, domain.CertPEM(), nil)
+			So(err, ShouldBeNil)
+
+			Convey("Then the encoding key should be the signer of the key store", func() {
+				_, ok := p.EncodingKey().(*testKeyStoreSigner)
+				So(ok, ShouldBeTrue)
+				So(p.PrivateKeyPEM, ShouldBeNil)
+			})
+
+			Convey("Then the remote secrets should open the same key", func() {
+				public := p.PublicSecrets().(*PKIPublicSecrets)
+				So(public.KeyURI, ShouldEqual, "secretstest:enforcer")
+				So(public.Key, ShouldBeNil)
+
+				s, err := NewSecrets(public)
+				So(err, ShouldBeNil)
+				_, ok := s.EncodingKey().(*testKeyStoreSigner)
+				So(ok, ShouldBeTrue)
+			})
+		})
+
+		Convey("Then the keys that do not match the certificate should be rejected", func() {
+			_, err := NewPKISecretsFromKeyStore("secretstest:other", certPEM, domain.CertPEM(), nil)
+			So(err, ShouldNotBeNil)
+			_, err = NewSVIDSecretsFromKeyStore("secretstest:other", certPEM, bundles)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then the missing keys should be rejected", func() {
+			_, err := NewPKISecretsFromKeyStore("secretstest:missing", certPEM, domain.CertPEM(), nil)
+			So(err, ShouldNotBeNil)
+			_, err = NewSVIDSecretsFromKeyStore("secretstest:missing", certPEM, bundles)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When I create SVID secrets with the key store", func() {
+			s, err := NewSVIDSecretsFromKeyStore("secretstest:enforcer", certPEM, bundles)
+			So(err, ShouldBeNil)
+			So(s.SpiffeID(), ShouldEqual, "spiffe://example.org/enforcer")
+
+			Convey("Then the TLS certificate should sign with the key store", func() {
+				cert, err := s.TLSCertificate()
+				So(err, ShouldBeNil)
+				So(cert.Leaf.Raw, ShouldResemble, cert.Certificate[0])
+
+				signer, ok := cert.PrivateKey.(*testKeyStoreSigner)
+				So(ok, ShouldBeTrue)
+
+				digest := sha256.Sum256([]byte("data"))
+				signature, err := signer.Sign(rand.Reader, digest[:], gocrypto.SHA256)
+				So(err, ShouldBeNil)
+				So(cert.Leaf.CheckSignature(x509.ECDSAWithSHA256, []byte("data"), signature), ShouldBeNil)
+			})
+
+			Convey("Then the remote secrets should open the same key", func() {
+				public := s.PublicSecrets().(*SVIDPublicSecrets)
+				So(public.KeyURI, ShouldEqual, "secretstest:enforcer")
+
+				remote, err := NewSecrets(public)
+				So(err, ShouldBeNil)
+				_, ok := remote.EncodingKey().(*testKeyStoreSigner)
+				So(ok, ShouldBeTrue)
+			})
+		})
+	})
+}
@@ -0,0 +1,234 @@
+package secrets
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	cryptoutils "github.com/aporeto-inc/trireme-lib/utils/crypto"
+)
+
+// ErrPinnedKeyChanged is the error of the peers that use another key than
+// the key pinned when they were first seen
+var ErrPinnedKeyChanged = errors.New("pinned key changed")
+
+// IsPinnedKeyChanged returns true if the error is caused by a key that
+// differs from the pinned key of the peer
+func IsPinnedKeyChanged(err error) bool {
+	return errors.Is(err, ErrPinnedKeyChanged)
+}
+
+// PeerKey describes the key of a peer known by the secrets
+type PeerKey struct {
+	// ServerID is the ID of the peer in the tokens
+	ServerID string
+	// Fingerprint is the SHA-256 fingerprint of the key of the peer. It is
+	// the pinned key if pinning is enabled.
+	Fingerprint string
+	// Issuer is the subject of the CA that issued the certificate of the
+	// key, if it is known
+	Issuer string
+	// FirstSeen is the time the key was first seen
+	FirstSeen time.Time
+	// LastSeen is the time the key was last used to verify a token
+	LastSeen time.Time
+	// Rejected is the number of tokens rejected because they were signed
+	// with another key than the pinned key
+	Rejected uint64
+}
+
+// PeerKeys is the inventory of the keys of the peers. If pinning is
+// enabled, the first key seen for a peer is pinned and the other keys of
+// the peer are rejected until the peer is forgotten.
+type PeerKeys struct {
+	pinning bool
+	keys    map[string]*PeerKey
+	sync.RWMutex
+}
+
+// peerKeysHolder is implemented by the secrets that keep an inventory of
+// the keys of the peers
+type peerKeysHolder interface {
+	peerKeys() *PeerKeys
+	setPeerKeys(peers *PeerKeys)
+}
+
+// KeepPeerKeys makes the secrets use the inventory of the keys of the peers
+// of the previous secrets, so that the keys pinned with the previous secrets
+// stay pinned when they are replaced. The keys already seen by the secrets
+// are added to the inventory, and pinning stays enabled if it is enabled by
+// one of the secrets. Nothing is done if one of the secrets has no inventory.
+func KeepPeerKeys(s, previous Secrets) {
+
+	holder, ok := s.(peerKeysHolder)
+	if !ok {
+		return
+	}
+
+	previousHolder, ok := previous.(peerKeysHolder)
+	if !ok {
+		return
+	}
+
+	peers := previousHolder.peerKeys()
+	current := holder.peerKeys()
+	if peers == nil || peers == current {
+		return
+	}
+
+	if current != nil {
+		peers.merge(current)
+	}
+
+	holder.setPeerKeys(peers)
+}
+
+// NewPeerKeys creates an inventory of the keys of the peers
+func NewPeerKeys(pinning bool) *PeerKeys {
+
+	return &PeerKeys{
+		pinning: pinning,
+		keys:    map[string]*PeerKey{},
+	}
+}
+
+// Pinning returns true if the keys of the peers are pinned
+func (k *PeerKeys) Pinning() bool {
+
+	k.RLock()
+	defer k.RUnlock()
+
+	return k.pinning
+}
+
+// EnablePinning pins the keys of the peers. The keys already seen are
+// pinned.
+func (k *PeerKeys) EnablePinning() {
+
+	k.Lock()
+	defer k.Unlock()
+
+	k.pinning = true
+}
+
+// List returns the keys of the peers sorted by server ID
+func (k *PeerKeys) List() []PeerKey {
+
+	k.RLock()
+	defer k.RUnlock()
+
+	keys := make([]PeerKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, *key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ServerID < keys[j].ServerID
+	})
+
+	return keys
+}
+
+// Forget removes the key of the server, so that its next key is pinned.
+// It is used to accept a new key of a peer.
+func (k *PeerKeys) Forget(server string) {
+
+	k.Lock()
+	defer k.Unlock()
+
+	delete(k.keys, server)
+}
+
+// merge adds the keys of the servers that are only known by the other
+// inventory, and enables pinning if it is enabled by the other inventory.
+func (k *PeerKeys) merge(other *PeerKeys) {
+
+	pinning := other.Pinning()
+	keys := other.List()
+
+	k.Lock()
+	defer k.Unlock()
+
+	k.pinning = k.pinning || pinning
+	for i := range keys {
+		if _, ok := k.keys[keys[i].ServerID]; !ok {
+			key := keys[i]
+			k.keys[key.ServerID] = &key
+		}
+	}
+}
+
+// add records the key of a server that is not known yet. It does not
+// change the keys already seen.
+func (k *PeerKeys) add(server string, key crypto.PublicKey, issuer string) error {
+
+	fingerprint, err := cryptoutils.PublicKeyFingerprint(key)
+	if err != nil {
+		return err
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	if _, ok := k.keys[server]; !ok {
+		now := time.Now()
+		k.keys[server] = &PeerKey{
+			ServerID:    server,
+			Fingerprint: fingerprint,
+			Issuer:      issuer,
+			FirstSeen:   now,
+			LastSeen:    now,
+		}
+	}
+
+	return nil
+}
+
+// use records the use of the key of a server to verify a token. With
+// pinning, it returns an ErrPinnedKeyChanged error if the key is not the
+// key first seen for the server. Without pinning, the key of the server
+// is replaced.
+func (k *PeerKeys) use(server string, key crypto.PublicKey, issuer string) error {
+
+	fingerprint, err := cryptoutils.PublicKeyFingerprint(key)
+	if err != nil {
+		return err
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	now := time.Now()
+
+	known, ok := k.keys[server]
+	if !ok {
+		k.keys[server] = &PeerKey{
+			ServerID:    server,
+			Fingerprint: fingerprint,
+			Issuer:      issuer,
+			FirstSeen:   now,
+			LastSeen:    now,
+		}
+		return nil
+	}
+
+	if known.Fingerprint != fingerprint {
+		if k.pinning {
+			known.Rejected++
+			return fmt.Errorf("key %s of server %s: %w", fingerprint, server, ErrPinnedKeyChanged)
+		}
+		known.Fingerprint = fingerprint
+		known.Issuer = issuer
+		known.FirstSeen = now
+	}
+
+	if issuer != "" {
+		known.Issuer = issuer
+	}
+	known.LastSeen = now
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package secrets
+
+import (
+	gocrypto "crypto"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/utils/crypto"
+	"github.com/aporeto-inc/trireme-lib/utils/testpki"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPeerKeys(t *testing.T) {
+
+	Convey("Given PKI secrets with a cache", t, func() {
+
+		pki := testpki.NewPKI("peers")
+		p, err := NewPKISecrets(pki.Leaf.KeyPEM(), pki.Leaf.CertPEM(), pki.CA.CertPEM(), map[string]gocrypto.PublicKey{})
+		So(err, ShouldBeNil)
+
+		cert, err := crypto.LoadCertificate(pki.Leaf.CertPEM())
+		So(err, ShouldBeNil)
+		fingerprint, err := crypto.PublicKeyFingerprint(cert.PublicKey)
+		So(err, ShouldBeNil)
+
+		So(p.PublicKeyAdd("server2", pki.Leaf.CertPEM()), ShouldBeNil)
+		So(p.PublicKeyAdd("server1", pki.Leaf.CertPEM()), ShouldBeNil)
+
+		Convey("Then the inventory should list the keys of the peers", func() {
+			keys := p.PeerKeys()
+			So(len(keys), ShouldEqual, 2)
+			So(keys[0].ServerID, ShouldEqual, "server1")
+			So(keys[0].Fingerprint, ShouldEqual, fingerprint)
+			So(keys[0].Issuer, ShouldEqual, pki.CA.Cert.Subject.String())
+			So(keys[1].ServerID, ShouldEqual, "server2")
+		})
+
+		Convey("Then the use of the keys should be recorded", func() {
+			firstSeen := p.PeerKeys()[0].FirstSeen
+			time.Sleep(10 * time.Millisecond)
+
+			_, err := p.DecodingKey("server1", nil, nil)
+			So(err, ShouldBeNil)
+
+			keys := p.PeerKeys()
+			So(keys[0].FirstSeen, ShouldEqual, firstSeen)
+			So(keys[0].LastSeen.After(firstSeen), ShouldBeTrue)
+		})
+
+		Convey("When the key of a server changes without pinning", func() {
+			_, err := p.DecodingKey("server1", nil, nil)
+			So(err, ShouldBeNil)
+			So(p.PublicKeyAdd("server1", pki.CA.Issue("other").CertPEM()), ShouldBeNil)
+
+			Convey("Then the new key should be accepted", func() {
+				_, err := p.DecodingKey("server1", nil, nil)
+				So(err, ShouldBeNil)
+				So(p.PeerKeys()[0].Fingerprint, ShouldNotEqual, fingerprint)
+			})
+		})
+
+		Convey("When the key of a server changes with pinning", func() {
+			p.EnablePinning()
+
+			_, err := p.DecodingKey("server1", nil, nil)
+			So(err, ShouldBeNil)
+			So(p.PublicKeyAdd("server1", pki.CA.Issue("other").CertPEM()), ShouldBeNil)
+
+			Convey("Then the new key should be rejected", func() {
+				_, err := p.DecodingKey("server1", nil, nil)
+				So(IsPinnedKeyChanged(err), ShouldBeTrue)
+
+				keys := p.PeerKeys()
+				So(keys[0].Fingerprint, ShouldEqual, fingerprint)
+				So(keys[0].Rejected, ShouldEqual, 1)
+			})
+
+			Convey("Then the new key should be accepted once the server is forgotten", func() {
+				p.ForgetPeerKey("server1")
+
+				_, err := p.DecodingKey("server1", nil, nil)
+				So(err, ShouldBeNil)
+				So(p.PeerKeys()[0].Fingerprint, ShouldNotEqual, fingerprint)
+			})
+
+			Convey("Then the secrets created from the public secrets should pin the keys", func() {
+				s, err := NewSecrets(p.PublicSecrets())
+				So(err, ShouldBeNil)
+				So(s.(*PKISecrets).peers.Pinning(), ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given PKI secrets that pin the keys of the inband certificates", t, func() {
+
+		pki := testpki.NewPKI("inband")
+		p, err := NewPKISecrets(pki.Leaf.KeyPEM(), pki.Leaf.CertPEM(), pki.CA.CertPEM(), nil)
+		So(err, ShouldBeNil)
+		p.EnablePinning()
+
+		cert, err := p.VerifyPublicKey(pki.Leaf.CertPEM())
+		So(err, ShouldBeNil)
+		other, err := p.VerifyPublicKey(pki.CA.Issue("other").CertPEM())
+		So(err, ShouldBeNil)
+
+		_, err = p.DecodingKey("server1", cert, nil)
+		So(err, ShouldBeNil)
+
+		Convey("Then the certificates of the server with another key should be rejected", func() {
+			_, err := p.DecodingKey("server1", other, nil)
+			So(IsPinnedKeyChanged(err), ShouldBeTrue)
+
+			_, err = p.DecodingKey("server2", other, nil)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Then the previous secrets of a rotation should not accept the new key", func() {
+			previous, err := NewPKISecrets(pki.Leaf.KeyPEM(), pki.Leaf.CertPEM(), pki.CA.CertPEM(), nil)
+			So(err, ShouldBeNil)
+
+			r, err := NewRotatedSecrets(p, previous, time.Hour)
+			So(err, ShouldBeNil)
+
+			_, err = r.DecodingKey("server1", other, nil)
+			So(IsPinnedKeyChanged(err), ShouldBeTrue)
+			So(r.PeerKeys(), ShouldHaveLength, 1)
+		})
+
+		Convey("Then the secrets that replace them should keep the pinned keys", func() {
+			updated, err := NewPKISecrets(pki.Leaf.KeyPEM(), pki.Leaf.CertPEM(), pki.CA.CertPEM(), nil)
+			So(err, ShouldBeNil)
+
+			_, err = updated.DecodingKey("server2", other, nil)
+			So(err, ShouldBeNil)
+
+			KeepPeerKeys(updated, p)
+			So(updated.peers.Pinning(), ShouldBeTrue)
+			So(updated.PeerKeys(), ShouldHaveLength, 2)
+
+			_, err = updated.DecodingKey("server1", other, nil)
+			So(IsPinnedKeyChanged(err), ShouldBeTrue)
+			So(p.PeerKeys()[0].Rejected, ShouldEqual, 1)
+		})
+	})
+}
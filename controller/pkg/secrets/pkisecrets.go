@@ -1,30 +1,37 @@
 package secrets
 
 import (
-	"crypto/ecdsa"
+	"crypto"
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"sync"
 
 	"go.uber.org/zap"
 
-	"github.com/aporeto-inc/trireme-lib/utils/crypto"
+	cryptoutils "github.com/aporeto-inc/trireme-lib/utils/crypto"
 )
 
 // PKISecrets holds all PKI information
 type PKISecrets struct {
 	PrivateKeyPEM    []byte
+	KeyURI           string
 	PublicKeyPEM     []byte
 	AuthorityPEM     []byte
-	CertificateCache map[string]*ecdsa.PublicKey
-	privateKey       *ecdsa.PrivateKey
+	CertificateCache map[string]crypto.PublicKey
+	privateKey       crypto.Signer
 	publicKey        *x509.Certificate
 	certPool         *x509.CertPool
+	certificates     map[string][]*x509.Certificate
+	revocation       *cryptoutils.RevocationChecker
+	peers            *PeerKeys
+	sync.RWMutex
 }
 
-// NewPKISecrets creates new secrets for PKI implementations
-func NewPKISecrets(keyPEM, certPEM, caPEM []byte, certCache map[string]*ecdsa.PublicKey) (*PKISecrets, error) {
-	key, cert, caCertPool, err := crypto.LoadAndVerifyECSecrets(keyPEM, certPEM, caPEM)
+// NewPKISecrets creates new secrets for PKI implementations. The keys can be
+// ECDSA P-256 or P-384 keys or Ed25519 keys.
+func NewPKISecrets(keyPEM, certPEM, caPEM []byte, certCache map[string]crypto.PublicKey) (*PKISecrets, error) {
+	key, cert, caCertPool, err := cryptoutils.LoadAndVerifySecrets(keyPEM, certPEM, caPEM)
 	if err != nil {
 		return nil, fmt.Errorf("invalid certificates: %s", err)
 	}
@@ -37,11 +44,82 @@ func NewPKISecrets(keyPEM, certPEM, caPEM []byte, certCache map[string]*ecdsa.Pu
 		privateKey:       key,
 		publicKey:        cert,
 		certPool:         caCertPool,
+		certificates:     map[string][]*x509.Certificate{},
+		peers:            NewPeerKeys(false),
 	}
 
 	return p, nil
 }
 
+// NewPKISecretsFromKeyStore creates new secrets for PKI implementations with
+// a private key that stays in a key store, like an HSM. The key is
+// identified by its URI and its key store must be registered.
+func NewPKISecretsFromKeyStore(keyURI string, certPEM, caPEM []byte, certCache map[string]crypto.PublicKey) (*PKISecrets, error) {
+	key, cert, caCertPool, err := cryptoutils.LoadAndVerifyKeyStoreSecrets(keyURI, certPEM, caPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificates: %s", err)
+	}
+
+	p := &PKISecrets{
+		KeyURI:           keyURI,
+		PublicKeyPEM:     certPEM,
+		AuthorityPEM:     caPEM,
+		CertificateCache: certCache,
+		privateKey:       key,
+		publicKey:        cert,
+		certPool:         caCertPool,
+		certificates:     map[string][]*x509.Certificate{},
+		peers:            NewPeerKeys(false),
+	}
+
+	return p, nil
+}
+
+// EnableRevocation checks the revocation of the certificates of the peers,
+// including the certificates added to the cache.
+func (p *PKISecrets) EnableRevocation(config *cryptoutils.RevocationConfig) error {
+
+	revocation, err := cryptoutils.NewRevocationChecker(config)
+	if err != nil {
+		return err
+	}
+
+	p.Lock()
+	p.revocation = revocation
+	p.Unlock()
+
+	return nil
+}
+
+// EnablePinning pins the key first seen for each server. The tokens of a
+// server signed with another key are rejected with an ErrPinnedKeyChanged
+// error until the server is forgotten.
+func (p *PKISecrets) EnablePinning() {
+	p.peers.EnablePinning()
+}
+
+// PeerKeys returns the inventory of the keys of the peers
+func (p *PKISecrets) PeerKeys() []PeerKey {
+	return p.peers.List()
+}
+
+// ForgetPeerKey removes the key of the server from the inventory, so that
+// a new key of the server is accepted and pinned.
+func (p *PKISecrets) ForgetPeerKey(server string) {
+	p.peers.Forget(server)
+}
+
+// peerKeys returns the inventory of the keys of the peers
+func (p *PKISecrets) peerKeys() *PeerKeys {
+	return p.peers
+}
+
+// setPeerKeys shares an inventory of the keys of the peers, so that the
+// pinned keys are kept when the secrets are replaced
+func (p *PKISecrets) setPeerKeys(peers *PeerKeys) {
+	p.peers = peers
+}
+
 // Type implements the interface Secrets
 func (p *PKISecrets) Type() PrivateSecretsType {
 	return PKIType
@@ -57,42 +135,59 @@ func (p *PKISecrets) PublicKey() interface{} {
 	return p.publicKey
 }
 
-// DecodingKey returns the public key
+// DecodingKey returns the public key. The key is recorded in the inventory
+// of the keys of the peers and is rejected if pinning is enabled and the
+// key of the server changed.
 func (p *PKISecrets) DecodingKey(server string, ackCert interface{}, prevCert interface{}) (interface{}, error) {
 
-	// If we have a cache of certificates, just look there
-	if p.CertificateCache != nil {
-		cert, ok := p.CertificateCache[server]
+	key, issuer, err := p.decodingKey(server, ackCert, prevCert)
+	if err != nil {
+		return nil, err
+	}
 
-		if !ok {
-			return nil, fmt.Errorf("no certificate in cache for server %s", server)
-		}
+	if server == "" {
+		return key, nil
+	}
+
+	if err := p.peers.use(server, key, issuer); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// decodingKey returns the public key of the server and the issuer of its
+// certificate if it is known
+func (p *PKISecrets) decodingKey(server string, ackCert interface{}, prevCert interface{}) (crypto.PublicKey, string, error) {
 
-		return cert, nil
+	// If we have a cache of certificates, just look there
+	if p.CertificateCache != nil {
+		return p.cachedKey(server)
 	}
 
 	// If we have an inband certificate, return this one
 	if ackCert != nil {
-		return ackCert.(*x509.Certificate).PublicKey.(*ecdsa.PublicKey), nil
+		cert := ackCert.(*x509.Certificate)
+		return cert.PublicKey, cert.Issuer.String(), nil
 	}
 
 	// Otherwise, return the prevCert
 	if prevCert != nil {
-		return prevCert, nil
+		return prevCert, "", nil
 	}
 
-	return nil, errors.New("no valid certificate")
+	return nil, "", errors.New("no valid certificate")
 }
 
 // VerifyPublicKey verifies if the inband public key is correct.
 func (p *PKISecrets) VerifyPublicKey(pkey []byte) (interface{}, error) {
-	decodedCert, err := crypto.LoadAndVerifyCertificate(pkey, p.certPool)
 
+	chain, err := p.verify(pkey)
 	if err != nil {
 		return nil, err
 	}
 
-	return decodedCert, nil
+	return chain[0], nil
 }
 
 // TransmittedKey returns the PEM of the public key in the case of PKI
@@ -101,9 +196,9 @@ func (p *PKISecrets) TransmittedKey() []byte {
 	return p.PublicKeyPEM
 }
 
-// AckSize returns the default size of an ACK packet
+// AckSize returns the size of an ACK packet signed with the key
 func (p *PKISecrets) AckSize() uint32 {
-	return uint32(336)
+	return ackSize(336, p.privateKey)
 }
 
 // PublicKeyAdd validates the parameter certificate.
@@ -111,15 +206,85 @@ func (p *PKISecrets) AckSize() uint32 {
 // If Invalid, an error is returned.
 func (p *PKISecrets) PublicKeyAdd(host string, newCert []byte) error {
 
-	cert, err := crypto.LoadAndVerifyCertificate(newCert, p.certPool)
+	chain, err := p.verify(newCert)
 	if err != nil {
 		return fmt.Errorf("unable to load certificate: %s", err)
 	}
 
 	zap.L().Debug("Adding cert for host", zap.String("host", host))
 
-	p.CertificateCache[host] = cert.PublicKey.(*ecdsa.PublicKey)
-	return nil
+	p.Lock()
+	defer p.Unlock()
+
+	p.CertificateCache[host] = chain[0].PublicKey
+	p.certificates[host] = chain
+
+	return p.peers.add(host, chain[0].PublicKey, chain[0].Issuer.String())
+}
+
+// verify verifies the certificate with the CA and checks its revocation.
+// It returns the verified chain of the certificate.
+func (p *PKISecrets) verify(certPEM []byte) ([]*x509.Certificate, error) {
+
+	cert, err := cryptoutils.LoadCertificate(certPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	chains, err := cert.Verify(x509.VerifyOptions{Roots: p.certPool})
+	if err != nil {
+		return nil, err
+	}
+
+	p.RLock()
+	revocation := p.revocation
+	p.RUnlock()
+
+	if revocation != nil {
+		if err := revocation.CheckChain(chains[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	return chains[0], nil
+}
+
+// cachedKey returns the key of the server from the cache and the issuer of
+// its certificate. The certificate of the server is evicted from the cache
+// if it is revoked. It is kept if its revocation status is not known yet.
+func (p *PKISecrets) cachedKey(server string) (crypto.PublicKey, string, error) {
+
+	p.RLock()
+	key, ok := p.CertificateCache[server]
+	chain := p.certificates[server]
+	revocation := p.revocation
+	p.RUnlock()
+
+	if !ok {
+		return nil, "", fmt.Errorf("no certificate in cache for server %s", server)
+	}
+
+	issuer := ""
+	if chain != nil {
+		issuer = chain[0].Issuer.String()
+	}
+
+	if revocation == nil || chain == nil {
+		return key, issuer, nil
+	}
+
+	if err := revocation.CheckChain(chain); err != nil {
+		if !cryptoutils.IsRevoked(err) {
+			return nil, "", fmt.Errorf("certificate of server %s: %w", server, err)
+		}
+		p.Lock()
+		delete(p.CertificateCache, server)
+		delete(p.certificates, server)
+		p.Unlock()
+		return nil, "", fmt.Errorf("certificate of server %s evicted: %w", server, err)
+	}
+
+	return key, issuer, nil
 }
 
 // AuthPEM returns the Certificate Authority PEM
@@ -139,12 +304,23 @@ func (p *PKISecrets) EncodingPEM() []byte {
 
 // PublicSecrets returns the secrets that are marshallable over the RPC interface.
 func (p *PKISecrets) PublicSecrets() PublicSecrets {
-	return &PKIPublicSecrets{
+	p.RLock()
+	defer p.RUnlock()
+
+	s := &PKIPublicSecrets{
 		Type:        PKIType,
 		Key:         p.PrivateKeyPEM,
+		KeyURI:      p.KeyURI,
 		Certificate: p.PublicKeyPEM,
 		CA:          p.AuthorityPEM,
+		Pinning:     p.peers.Pinning(),
 	}
+
+	if p.revocation != nil {
+		s.Revocation = p.revocation.Config()
+	}
+
+	return s
 }
 
 // PKIPublicSecrets includes all the secrets that can be transmitted over
@@ -152,8 +328,11 @@ func (p *PKISecrets) PublicSecrets() PublicSecrets {
 type PKIPublicSecrets struct {
 	Type        PrivateSecretsType
 	Key         []byte
+	KeyURI      string
 	Certificate []byte
 	CA          []byte
+	Revocation  *cryptoutils.RevocationConfig
+	Pinning     bool
 }
 
 // SecretsType returns the type of secrets.
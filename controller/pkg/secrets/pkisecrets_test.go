@@ -1,11 +1,16 @@
 package secrets
 
 import (
+	gocrypto "crypto"
 	"crypto/ecdsa"
 	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/aporeto-inc/trireme-lib/utils/crypto"
+	"github.com/aporeto-inc/trireme-lib/utils/testpki"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -108,7 +113,7 @@ func TestPKIBasicInterfaceFunctions(t *testing.T) {
 func TestPKICache(t *testing.T) {
 	Convey("Given PKI secrets with a cache", t, func() {
 		_, cert, _, _ := crypto.LoadAndVerifyECSecrets([]byte(privateKeyPEM), []byte(publicPEM), []byte(caPEM))
-		cache := map[string]*ecdsa.PublicKey{}
+		cache := map[string]gocrypto.PublicKey{}
 		p, err := NewPKISecrets([]byte(privateKeyPEM), []byte(publicPEM), []byte(caPEM), cache)
 		So(err, ShouldBeNil)
 		So(p, ShouldNotBeNil)
@@ -135,3 +140,57 @@ func TestPKICache(t *testing.T) {
 
 	})
 }
+
+func TestPKISecretsRevocation(t *testing.T) {
+
+	Convey("Given PKI secrets with a cache and revocation checks", t, func() {
+
+		dir, err := ioutil.TempDir("", "crl")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint: errcheck
+
+		pki := testpki.NewPKI("revocation")
+		path := filepath.Join(dir, "ca.crl")
+		So(ioutil.WriteFile(path, pki.CA.CRL(1), 0600), ShouldBeNil)
+
+		p, err := NewPKISecrets(pki.Leaf.KeyPEM(), pki.Leaf.CertPEM(), pki.CA.CertPEM(), map[string]gocrypto.PublicKey{})
+		So(err, ShouldBeNil)
+		So(p.EnableRevocation(&crypto.RevocationConfig{CRLPaths: []string{path}}), ShouldBeNil)
+
+		So(p.PublicKeyAdd("server1", pki.Leaf.CertPEM()), ShouldBeNil)
+
+		Convey("Then the certificates that are not revoked should be accepted", func() {
+			_, err := p.VerifyPublicKey(pki.Leaf.CertPEM())
+			So(err, ShouldBeNil)
+
+			_, err = p.DecodingKey("server1", nil, nil)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("When the certificate is revoked", func() {
+			So(ioutil.WriteFile(path, pki.CA.CRL(2, 2), 0600), ShouldBeNil)
+			So(p.revocation.Reload(), ShouldBeNil)
+
+			Convey("Then it should be rejected", func() {
+				_, err := p.VerifyPublicKey(pki.Leaf.CertPEM())
+				So(crypto.IsRevoked(err), ShouldBeTrue)
+
+				So(p.PublicKeyAdd("server2", pki.Leaf.CertPEM()), ShouldNotBeNil)
+			})
+
+			Convey("Then it should be evicted from the cache", func() {
+				_, err := p.DecodingKey("server1", nil, nil)
+				So(crypto.IsRevoked(err), ShouldBeTrue)
+				So(p.CertificateCache, ShouldNotContainKey, "server1")
+			})
+
+			Convey("Then the secrets created from the public secrets should reject it", func() {
+				s, err := NewSecrets(p.PublicSecrets())
+				So(err, ShouldBeNil)
+
+				_, err = s.VerifyPublicKey(pki.Leaf.CertPEM())
+				So(crypto.IsRevoked(err), ShouldBeTrue)
+			})
+		})
+	})
+}
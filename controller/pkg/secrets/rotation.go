@@ -0,0 +1,279 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/metrics"
+)
+
+// wrappedSecrets are secrets that delegate to other secrets. Rotations
+// keep the wrapped secrets since the wrapper may change.
+type wrappedSecrets interface {
+	Current() Secrets
+}
+
+// RotatedSecrets are the secrets of a rotation. They sign with the current
+// secrets and also accept the keys and CAs of the previous secrets until
+// the end of a grace period, so that the peers that have not rotated yet
+// are not rejected.
+type RotatedSecrets struct {
+	current  Secrets
+	previous Secrets
+	expiry   time.Time
+	lastUsed time.Time
+	sync.RWMutex
+}
+
+// NewRotatedSecrets creates the secrets of a rotation from the previous
+// secrets to the current ones. The previous secrets are accepted during
+// the grace period. If the previous secrets are themselves rotated
+// secrets, only their current secrets are kept. Secrets that wrap other
+// secrets are replaced by the secrets they currently wrap.
+func NewRotatedSecrets(current, previous Secrets, grace time.Duration) (*RotatedSecrets, error) {
+
+	return newRotatedSecrets(current, previous, time.Now().Add(grace))
+}
+
+func newRotatedSecrets(current, previous Secrets, expiry time.Time) (*RotatedSecrets, error) {
+
+	if current == nil || previous == nil {
+		return nil, errors.New("current and previous secrets are required")
+	}
+
+	if w, ok := current.(wrappedSecrets); ok {
+		current = w.Current()
+	}
+
+	if w, ok := previous.(wrappedSecrets); ok {
+		previous = w.Current()
+	}
+
+	if current.Type() != previous.Type() {
+		return nil, fmt.Errorf("unable to rotate secrets of type %d to type %d", previous.Type(), current.Type())
+	}
+
+	// The keys pinned with the previous secrets stay pinned
+	KeepPeerKeys(current, previous)
+
+	return &RotatedSecrets{
+		current:  current,
+		previous: previous,
+		expiry:   expiry,
+	}, nil
+}
+
+// Current returns the current secrets
+func (r *RotatedSecrets) Current() Secrets {
+	return r.current
+}
+
+// Previous returns the previous secrets, or nil if the grace period is over
+func (r *RotatedSecrets) Previous() Secrets {
+
+	if time.Now().After(r.expiry) {
+		return nil
+	}
+
+	return r.previous
+}
+
+// Expiry returns the end of the grace period
+func (r *RotatedSecrets) Expiry() time.Time {
+	return r.expiry
+}
+
+// PreviousUsed records that a peer was accepted with the previous secrets
+func (r *RotatedSecrets) PreviousUsed() {
+
+	metrics.SecretsPreviousAccepted.Inc()
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.lastUsed = time.Now()
+}
+
+// PreviousLastUsed returns the last time a peer was accepted with the
+// previous secrets by this process. It is zero if they were never used.
+// The remote enforcers report their use of the previous secrets in the
+// metrics.
+func (r *RotatedSecrets) PreviousLastUsed() time.Time {
+
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.lastUsed
+}
+
+// Type implements the Secrets interface.
+func (r *RotatedSecrets) Type() PrivateSecretsType {
+	return r.current.Type()
+}
+
+// EncodingKey implements the Secrets interface.
+func (r *RotatedSecrets) EncodingKey() interface{} {
+	return r.current.EncodingKey()
+}
+
+// DecodingKey returns the decoding key of the current secrets, or of the
+// previous secrets if the current ones can not provide it.
+func (r *RotatedSecrets) DecodingKey(server string, ackCert, prevCert interface{}) (interface{}, error) {
+
+	key, err := r.current.DecodingKey(server, ackCert, prevCert)
+	if err == nil {
+		return key, nil
+	}
+
+	// The previous secrets must not accept a key rejected by the pinning
+	if IsPinnedKeyChanged(err) {
+		return nil, err
+	}
+
+	previous := r.Previous()
+	if previous == nil {
+		return nil, err
+	}
+
+	key, perr := previous.DecodingKey(server, ackCert, prevCert)
+	if perr != nil {
+		return nil, err
+	}
+
+	r.PreviousUsed()
+
+	return key, nil
+}
+
+// PublicKey implements the Secrets interface.
+func (r *RotatedSecrets) PublicKey() interface{} {
+	return r.current.PublicKey()
+}
+
+// TransmittedKey implements the Secrets interface.
+func (r *RotatedSecrets) TransmittedKey() []byte {
+	return r.current.TransmittedKey()
+}
+
+// VerifyPublicKey verifies the public key with the current secrets, or
+// with the previous secrets if it is not trusted by the current ones.
+func (r *RotatedSecrets) VerifyPublicKey(pkey []byte) (interface{}, error) {
+
+	key, err := r.current.VerifyPublicKey(pkey)
+	if err == nil {
+		return key, nil
+	}
+
+	previous := r.Previous()
+	if previous == nil {
+		return nil, err
+	}
+
+	key, perr := previous.VerifyPublicKey(pkey)
+	if perr != nil {
+		return nil, err
+	}
+
+	r.PreviousUsed()
+
+	return key, nil
+}
+
+// AckSize implements the Secrets interface.
+func (r *RotatedSecrets) AckSize() uint32 {
+	return r.current.AckSize()
+}
+
+// PeerKeys implements the PeerKeyInventory interface. The current and the
+// previous secrets share the inventory of the keys of the peers.
+func (r *RotatedSecrets) PeerKeys() []PeerKey {
+
+	if inventory, ok := r.current.(PeerKeyInventory); ok {
+		return inventory.PeerKeys()
+	}
+
+	return []PeerKey{}
+}
+
+// peerKeys returns the inventory of the keys of the peers of the current
+// secrets
+func (r *RotatedSecrets) peerKeys() *PeerKeys {
+
+	if holder, ok := r.current.(peerKeysHolder); ok {
+		return holder.peerKeys()
+	}
+
+	return nil
+}
+
+// setPeerKeys shares an inventory of the keys of the peers with the current
+// and the previous secrets
+func (r *RotatedSecrets) setPeerKeys(peers *PeerKeys) {
+
+	for _, s := range []Secrets{r.current, r.previous} {
+		if holder, ok := s.(peerKeysHolder); ok {
+			holder.setPeerKeys(peers)
+		}
+	}
+}
+
+// PublicSecrets returns the secrets that are marshallable over the RPC interface.
+func (r *RotatedSecrets) PublicSecrets() PublicSecrets {
+	return &RotatedPublicSecrets{
+		Current:  r.current.PublicSecrets(),
+		Previous: r.previous.PublicSecrets(),
+		Expiry:   r.expiry,
+	}
+}
+
+// RotatedPublicSecrets includes the secrets of a rotation that can be
+// transmitted over the RPC interface.
+type RotatedPublicSecrets struct {
+	Current  PublicSecrets
+	Previous PublicSecrets
+	Expiry   time.Time
+}
+
+// SecretsType returns the type of the current secrets.
+func (p *RotatedPublicSecrets) SecretsType() PrivateSecretsType {
+	return p.Current.SecretsType()
+}
+
+// CertAuthority returns the cert authorities of the current and previous
+// secrets, since both are trusted during the rotation.
+func (p *RotatedPublicSecrets) CertAuthority() []byte {
+
+	current := p.Current.CertAuthority()
+	previous := p.Previous.CertAuthority()
+
+	if len(previous) == 0 || bytes.Equal(current, previous) {
+		return current
+	}
+
+	return append(append(append([]byte{}, current...), '\n'), previous...)
+}
+
+// newRotatedSecretsFromPublic creates the rotated secrets from the public
+// secrets of a rotation. The current secrets are returned alone if the
+// grace period is over.
+func newRotatedSecretsFromPublic(p *RotatedPublicSecrets) (Secrets, error) {
+
+	current, err := NewSecrets(p.Current)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(p.Expiry) {
+		return current, nil
+	}
+
+	previous, err := NewSecrets(p.Previous)
+	if err != nil {
+		return nil, fmt.Errorf("invalid previous secrets: %s", err)
+	}
+
+	return newRotatedSecrets(current, previous, p.Expiry)
+}
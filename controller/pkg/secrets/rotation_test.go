@@ -0,0 +1,119 @@
+package secrets
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/utils/testpki"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// pkiSecrets creates the PKI secrets of the certificate of the PKI
+func pkiSecrets(p *testpki.PKI) *PKISecrets {
+	s, err := NewPKISecrets(p.Leaf.KeyPEM(), p.Leaf.CertPEM(), p.CA.CertPEM(), nil)
+	So(err, ShouldBeNil)
+	return s
+}
+
+func TestRotatedSecrets(t *testing.T) {
+
+	Convey("Given the PKI secrets before and after a CA rotation", t, func() {
+
+		oldPKI := testpki.NewPKI("old")
+		newPKI := testpki.NewPKI("new")
+
+		previous := pkiSecrets(oldPKI)
+		current := pkiSecrets(newPKI)
+
+		Convey("When I create rotated secrets without previous secrets", func() {
+			_, err := NewRotatedSecrets(current, nil, time.Hour)
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I create rotated secrets of different types", func() {
+			_, err := NewRotatedSecrets(current, NewPSKSecrets([]byte("psk")), time.Hour)
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I create rotated secrets with a grace period", func() {
+			r, err := NewRotatedSecrets(current, previous, time.Hour)
+			So(err, ShouldBeNil)
+
+			Convey("Then they should sign with the current secrets", func() {
+				So(r.Type(), ShouldEqual, PKIType)
+				So(r.EncodingKey(), ShouldEqual, current.EncodingKey())
+				So(r.TransmittedKey(), ShouldResemble, current.TransmittedKey())
+				So(r.Previous(), ShouldEqual, previous)
+			})
+
+			Convey("Then they should accept the certificates of both CAs", func() {
+				_, err := r.VerifyPublicKey(newPKI.Leaf.CertPEM())
+				So(err, ShouldBeNil)
+				So(r.PreviousLastUsed().IsZero(), ShouldBeTrue)
+
+				_, err = current.VerifyPublicKey(oldPKI.Leaf.CertPEM())
+				So(err, ShouldNotBeNil)
+
+				_, err = r.VerifyPublicKey(oldPKI.Leaf.CertPEM())
+				So(err, ShouldBeNil)
+				So(r.PreviousLastUsed().IsZero(), ShouldBeFalse)
+			})
+
+			Convey("Then their public secrets should carry both CAs", func() {
+				ca := string(r.PublicSecrets().CertAuthority())
+				So(ca, ShouldContainSubstring, string(newPKI.CA.CertPEM()))
+				So(ca, ShouldContainSubstring, string(oldPKI.CA.CertPEM()))
+			})
+
+			Convey("Then they should be recreated from their public secrets", func() {
+				s, err := NewSecrets(r.PublicSecrets())
+				So(err, ShouldBeNil)
+
+				rotated, ok := s.(*RotatedSecrets)
+				So(ok, ShouldBeTrue)
+				So(rotated.Expiry().Equal(r.Expiry()), ShouldBeTrue)
+
+				_, err = rotated.VerifyPublicKey(oldPKI.Leaf.CertPEM())
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then a new rotation should only keep their current secrets", func() {
+				next := testpki.NewPKI("next")
+				n, err := NewRotatedSecrets(pkiSecrets(next), r, time.Hour)
+				So(err, ShouldBeNil)
+
+				_, err = n.VerifyPublicKey(newPKI.Leaf.CertPEM())
+				So(err, ShouldBeNil)
+
+				_, err = n.VerifyPublicKey(oldPKI.Leaf.CertPEM())
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the grace period is over", func() {
+			r, err := NewRotatedSecrets(current, previous, -time.Second)
+			So(err, ShouldBeNil)
+
+			Convey("Then the previous CA should not be accepted anymore", func() {
+				So(r.Previous(), ShouldBeNil)
+
+				_, err := r.VerifyPublicKey(oldPKI.Leaf.CertPEM())
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then only the current secrets should be recreated from the public secrets", func() {
+				s, err := NewSecrets(r.PublicSecrets())
+				So(err, ShouldBeNil)
+
+				_, ok := s.(*PKISecrets)
+				So(ok, ShouldBeTrue)
+			})
+		})
+	})
+}
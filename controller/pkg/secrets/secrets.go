@@ -1,6 +1,10 @@
 package secrets
 
-import "fmt"
+import (
+	"fmt"
+
+	cryptoutils "github.com/aporeto-inc/trireme-lib/utils/crypto"
+)
 
 // Secrets is an interface implementing secrets
 type Secrets interface {
@@ -43,21 +47,75 @@ const (
 	PKICompactType
 	// PKINull is for debugging
 	PKINull
+	// SVIDType is for asymetric signing with SPIFFE X.509 SVIDs
+	SVIDType
 )
 
 // NewSecrets creates a new set of secrets based on the type.
 func NewSecrets(s PublicSecrets) (Secrets, error) {
+	if r, ok := s.(*RotatedPublicSecrets); ok {
+		return newRotatedSecretsFromPublic(r)
+	}
+
 	switch s.SecretsType() {
 	case PKIType:
 		t := s.(*PKIPublicSecrets)
-		return NewPKISecrets(t.Key, t.Certificate, t.CA, nil)
+		var p *PKISecrets
+		var err error
+		if t.KeyURI != "" {
+			p, err = NewPKISecretsFromKeyStore(t.KeyURI, t.Certificate, t.CA, nil)
+		} else {
+			p, err = NewPKISecrets(t.Key, t.Certificate, t.CA, nil)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if t.Revocation != nil {
+			if err := p.EnableRevocation(t.Revocation); err != nil {
+				return nil, err
+			}
+		}
+		if t.Pinning {
+			p.EnablePinning()
+		}
+		return p, nil
 	case PKICompactType:
 		t := s.(*CompactPKIPublicSecrets)
-		return NewCompactPKIWithTokenCA(t.Key, t.Certificate, t.CA, t.TokenCAs, t.Token)
+		p, err := NewCompactPKIWithTokenCA(t.Key, t.Certificate, t.CA, t.TokenCAs, t.Token)
+		if err != nil {
+			return nil, err
+		}
+		if t.Revocation != nil {
+			if err := p.EnableRevocation(t.Revocation); err != nil {
+				return nil, err
+			}
+		}
+		return p, nil
 	case PSKType:
 		t := s.(*PSKPublicSecrets)
 		return NewPSKSecrets(t.SharedKey), nil
+	case SVIDType:
+		t := s.(*SVIDPublicSecrets)
+		if t.KeyURI != "" {
+			return NewSVIDSecretsFromKeyStore(t.KeyURI, t.Certificate, t.Bundles)
+		}
+		return NewSVIDSecrets(t.Key, t.Certificate, t.Bundles)
 	default:
 		return nil, fmt.Errorf("Unsupported type")
 	}
 }
+
+// encodedP256SignatureSize is the size of the encoded ES256 signatures
+const encodedP256SignatureSize = 86
+
+// ackSize returns the size of the ACK packets signed with the key from
+// their size with a P-256 key. Only the size of the signature differs.
+func ackSize(p256Size uint32, key interface{}) uint32 {
+
+	size, err := cryptoutils.EncodedSignatureSize(key)
+	if err != nil {
+		return p256Size
+	}
+
+	return p256Size - encodedP256SignatureSize + uint32(size)
+}
@@ -0,0 +1,343 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	cryptoutils "github.com/aporeto-inc/trireme-lib/utils/crypto"
+)
+
+// spiffeScheme is the URI scheme of the SPIFFE IDs
+const spiffeScheme = "spiffe"
+
+// SVIDSecrets are secrets based on an X.509 SVID, the certificate of a
+// SPIFFE identity. The certificates of the peers are verified with the trust
+// bundle of the trust domain of their SPIFFE ID, so that the identities of
+// multiple trust domains can be accepted.
+type SVIDSecrets struct {
+	KeyPEM         []byte
+	KeyURI         string
+	CertificatePEM []byte
+	Bundles        map[string][]byte
+	privateKey     crypto.Signer
+	certificate    *x509.Certificate
+	spiffeID       string
+	trustDomain    string
+	pools          map[string]*x509.CertPool
+}
+
+// NewSVIDSecrets creates new secrets from an X.509 SVID and the trust bundles
+// indexed by trust domain. The certificate PEM may include the intermediate
+// certificates after the SVID. The bundle of the trust domain of the SVID is
+// required.
+func NewSVIDSecrets(keyPEM, certPEM []byte, bundles map[string][]byte) (*SVIDSecrets, error) {
+
+	key, err := cryptoutils.LoadPrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid svid key: %s", err)
+	}
+
+	s, err := newSVIDSecrets(key, certPEM, bundles)
+	if err != nil {
+		return nil, err
+	}
+
+	s.KeyPEM = keyPEM
+
+	return s, nil
+}
+
+// NewSVIDSecretsFromKeyStore creates new secrets from an X.509 SVID whose
+// private key stays in a key store, like an HSM. The key is identified by
+// its URI and its key store must be registered.
+func NewSVIDSecretsFromKeyStore(keyURI string, certPEM []byte, bundles map[string][]byte) (*SVIDSecrets, error) {
+
+	key, err := cryptoutils.OpenKeyStore(keyURI)
+	if err != nil {
+		return nil, fmt.Errorf("invalid svid key: %s", err)
+	}
+
+	s, err := newSVIDSecrets(key, certPEM, bundles)
+	if err != nil {
+		return nil, err
+	}
+
+	s.KeyURI = keyURI
+
+	return s, nil
+}
+
+// newSVIDSecrets creates the secrets of the SVID with its private key
+func newSVIDSecrets(key crypto.Signer, certPEM []byte, bundles map[string][]byte) (*SVIDSecrets, error) {
+
+	pools := map[string]*x509.CertPool{}
+	normalized := map[string][]byte{}
+	for trustDomain, bundle := range bundles {
+		trustDomain = strings.ToLower(trustDomain)
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("invalid trust bundle for trust domain %s", trustDomain)
+		}
+		pools[trustDomain] = pool
+		normalized[trustDomain] = bundle
+	}
+
+	s := &SVIDSecrets{
+		CertificatePEM: certPEM,
+		Bundles:        normalized,
+		privateKey:     key,
+		pools:          pools,
+	}
+
+	cert, err := s.verify(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid svid: %s", err)
+	}
+
+	if !cryptoutils.KeyMatchesCertificate(key, cert) {
+		return nil, errors.New("svid does not match key")
+	}
+
+	s.certificate = cert
+	s.spiffeID, s.trustDomain, _ = SpiffeID(cert)
+
+	return s, nil
+}
+
+// SpiffeID returns the SPIFFE ID of the certificate and its trust domain.
+// An error is returned if the certificate does not have exactly one valid
+// SPIFFE ID in its URI SANs.
+func SpiffeID(cert *x509.Certificate) (id string, trustDomain string, err error) {
+
+	if len(cert.URIs) != 1 {
+		return "", "", fmt.Errorf("expected one uri san, got %d", len(cert.URIs))
+	}
+
+	u := cert.URIs[0]
+	if !strings.EqualFold(u.Scheme, spiffeScheme) {
+		return "", "", fmt.Errorf("invalid scheme %s", u.Scheme)
+	}
+
+	if u.Host == "" || u.User != nil || u.Port() != "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", "", fmt.Errorf("invalid spiffe id %s", u.String())
+	}
+
+	trustDomain = strings.ToLower(u.Host)
+	id = (&url.URL{Scheme: spiffeScheme, Host: trustDomain, Path: u.Path}).String()
+
+	return id, trustDomain, nil
+}
+
+// SVIDFrom returns the SVID secrets of the secrets, including the secrets
+// wrapped by a rotation or a file watcher.
+func SVIDFrom(s Secrets) (*SVIDSecrets, bool) {
+
+	if w, ok := s.(wrappedSecrets); ok {
+		s = w.Current()
+	}
+
+	svid, ok := s.(*SVIDSecrets)
+	return svid, ok
+}
+
+// Type implements the interface Secrets
+func (s *SVIDSecrets) Type() PrivateSecretsType {
+	return SVIDType
+}
+
+// EncodingKey returns the private key of the SVID
+func (s *SVIDSecrets) EncodingKey() interface{} {
+	return s.privateKey
+}
+
+// PublicKey returns the SVID
+func (s *SVIDSecrets) PublicKey() interface{} {
+	return s.certificate
+}
+
+// DecodingKey returns the public key of the SVID of the peer
+func (s *SVIDSecrets) DecodingKey(server string, ackCert interface{}, prevCert interface{}) (interface{}, error) {
+
+	if ackCert != nil {
+		return ackCert.(*x509.Certificate).PublicKey, nil
+	}
+
+	if prevCert != nil {
+		return prevCert, nil
+	}
+
+	return nil, errors.New("no valid certificate")
+}
+
+// VerifyPublicKey verifies the SVID of the peer with the trust bundle of its
+// trust domain and returns its certificate.
+func (s *SVIDSecrets) VerifyPublicKey(pkey []byte) (interface{}, error) {
+	return s.verify(pkey)
+}
+
+// TransmittedKey returns the PEM of the SVID and its intermediates
+func (s *SVIDSecrets) TransmittedKey() []byte {
+	return s.CertificatePEM
+}
+
+// AckSize returns the size of an ACK packet signed with the key
+func (s *SVIDSecrets) AckSize() uint32 {
+	return ackSize(336, s.privateKey)
+}
+
+// SpiffeID returns the SPIFFE ID of the SVID
+func (s *SVIDSecrets) SpiffeID() string {
+	return s.spiffeID
+}
+
+// TrustDomain returns the trust domain of the SVID
+func (s *SVIDSecrets) TrustDomain() string {
+	return s.trustDomain
+}
+
+// BundlePEM returns the trust bundles of all the trust domains
+func (s *SVIDSecrets) BundlePEM() []byte {
+	return joinBundles(s.Bundles)
+}
+
+// TLSCertificate returns the TLS certificate of the SVID and of its
+// intermediate certificates. The private key is the signer of the secrets,
+// so that the key of a key store is not exported.
+func (s *SVIDSecrets) TLSCertificate() (*tls.Certificate, error) {
+
+	chain, err := loadCertificateChain(s.CertificatePEM)
+	if err != nil {
+		return nil, err
+	}
+
+	cert := &tls.Certificate{
+		PrivateKey: s.privateKey,
+		Leaf:       s.certificate,
+	}
+	for _, c := range chain {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+
+	return cert, nil
+}
+
+// PublicSecrets returns the secrets that are marshallable over the RPC interface.
+func (s *SVIDSecrets) PublicSecrets() PublicSecrets {
+	return &SVIDPublicSecrets{
+		Type:        SVIDType,
+		Key:         s.KeyPEM,
+		KeyURI:      s.KeyURI,
+		Certificate: s.CertificatePEM,
+		Bundles:     s.Bundles,
+	}
+}
+
+// verify parses the certificate chain and verifies the SVID with the trust
+// bundle of its trust domain.
+func (s *SVIDSecrets) verify(certPEM []byte) (*x509.Certificate, error) {
+
+	chain, err := loadCertificateChain(certPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf := chain[0]
+	if leaf.IsCA {
+		return nil, errors.New("svid must not be a ca")
+	}
+
+	_, trustDomain, err := SpiffeID(leaf)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, ok := s.pools[trustDomain]
+	if !ok {
+		return nil, fmt.Errorf("no trust bundle for trust domain %s", trustDomain)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, err
+	}
+
+	return leaf, nil
+}
+
+// SVIDPublicSecrets includes all the secrets that can be transmitted over
+// the RPC interface.
+type SVIDPublicSecrets struct {
+	Type        PrivateSecretsType
+	Key         []byte
+	KeyURI      string
+	Certificate []byte
+	Bundles     map[string][]byte
+}
+
+// SecretsType returns the type of secrets.
+func (p *SVIDPublicSecrets) SecretsType() PrivateSecretsType {
+	return p.Type
+}
+
+// CertAuthority returns the trust bundles of all the trust domains
+func (p *SVIDPublicSecrets) CertAuthority() []byte {
+	return joinBundles(p.Bundles)
+}
+
+// loadCertificateChain parses all the certificates of the PEM buffer
+func loadCertificateChain(certPEM []byte) ([]*x509.Certificate, error) {
+
+	chain := []*x509.Certificate{}
+
+	for block, rest := pem.Decode(certPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+
+	if len(chain) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+
+	return chain, nil
+}
+
+// joinBundles concatenates the trust bundles in the order of their trust
+// domains
+func joinBundles(bundles map[string][]byte) []byte {
+
+	trustDomains := make([]string, 0, len(bundles))
+	for trustDomain := range bundles {
+		trustDomains = append(trustDomains, trustDomain)
+	}
+	sort.Strings(trustDomains)
+
+	var buffer bytes.Buffer
+	for _, trustDomain := range trustDomains {
+		buffer.Write(bytes.TrimSpace(bundles[trustDomain]))
+		buffer.WriteByte('\n')
+	}
+
+	return buffer.Bytes()
+}
@@ -0,0 +1,148 @@
+package secrets
+
+import (
+	"crypto/x509"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/utils/testpki"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// issueSVID issues an SVID of the trust domain with the URI SANs
+func issueSVID(domain *testpki.Authority, uris ...string) (keyPEM []byte, certPEM []byte) {
+	leaf := domain.Issue("", testpki.WithURIs(uris...))
+	return leaf.KeyPEM(), leaf.CertPEM()
+}
+
+func TestSVIDSecrets(t *testing.T) {
+
+	Convey("Given two federated trust domains", t, func() {
+
+		local := testpki.NewAuthority("example.org", testpki.WithURIs("spiffe://example.org"))
+		remote := testpki.NewAuthority("partner.com", testpki.WithURIs("spiffe://partner.com"))
+		other := testpki.NewAuthority("unknown.net", testpki.WithURIs("spiffe://unknown.net"))
+
+		bundles := map[string][]byte{
+			"example.org": local.CertPEM(),
+			"Partner.com": remote.CertPEM(),
+		}
+
+		keyPEM, certPEM := issueSVID(local, "spiffe://example.org/ns/prod/sa/web")
+
+		Convey("When I create SVID secrets", func() {
+			s, err := NewSVIDSecrets(keyPEM, certPEM, bundles)
+			So(err, ShouldBeNil)
+
+			Convey("Then they should expose the SPIFFE ID of the SVID", func() {
+				So(s.Type(), ShouldEqual, SVIDType)
+				So(s.SpiffeID(), ShouldEqual, "spiffe://example.org/ns/prod/sa/web")
+				So(s.TrustDomain(), ShouldEqual, "example.org")
+				So(s.TransmittedKey(), ShouldResemble, certPEM)
+			})
+
+			Convey("Then they should accept the SVIDs of both trust domains", func() {
+				_, localPEM := issueSVID(local, "spiffe://example.org/ns/prod/sa/db")
+				cert, err := s.VerifyPublicKey(localPEM)
+				So(err, ShouldBeNil)
+				So(cert.(*x509.Certificate).URIs[0].String(), ShouldEqual, "spiffe://example.org/ns/prod/sa/db")
+
+				_, remotePEM := issueSVID(remote, "spiffe://partner.com/billing")
+				_, err = s.VerifyPublicKey(remotePEM)
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then they should reject an SVID signed for another trust domain", func() {
+				_, forgedPEM := issueSVID(remote, "spiffe://example.org/ns/prod/sa/admin")
+				_, err := s.VerifyPublicKey(forgedPEM)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then they should reject an SVID of an unknown trust domain", func() {
+				_, unknownPEM := issueSVID(other, "spiffe://unknown.net/workload")
+				_, err := s.VerifyPublicKey(unknownPEM)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then they should reject a certificate without a single SPIFFE ID", func() {
+				_, nonePEM := issueSVID(local)
+				_, err := s.VerifyPublicKey(nonePEM)
+				So(err, ShouldNotBeNil)
+
+				_, twoPEM := issueSVID(local, "spiffe://example.org/a", "spiffe://example.org/b")
+				_, err = s.VerifyPublicKey(twoPEM)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then they should be recreated from their public secrets", func() {
+				n, err := NewSecrets(s.PublicSecrets())
+				So(err, ShouldBeNil)
+				So(n.(*SVIDSecrets).SpiffeID(), ShouldEqual, s.SpiffeID())
+
+				ca := string(s.PublicSecrets().CertAuthority())
+				So(ca, ShouldContainSubstring, string(local.CertPEM()))
+				So(ca, ShouldContainSubstring, string(remote.CertPEM()))
+			})
+
+			Convey("Then they should be found in a rotation", func() {
+				r, err := NewRotatedSecrets(s, s, time.Hour)
+				So(err, ShouldBeNil)
+
+				svid, ok := SVIDFrom(r)
+				So(ok, ShouldBeTrue)
+				So(svid, ShouldEqual, s)
+			})
+		})
+
+		Convey("When I create SVID secrets without the bundle of their trust domain", func() {
+			_, err := NewSVIDSecrets(keyPEM, certPEM, map[string][]byte{"partner.com": remote.CertPEM()})
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I create SVID secrets with the key of another SVID", func() {
+			otherKey, _ := issueSVID(local, "spiffe://example.org/other")
+			_, err := NewSVIDSecrets(otherKey, certPEM, bundles)
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestSpiffeID(t *testing.T) {
+
+	Convey("Given certificates with URI SANs", t, func() {
+
+		parse := func(uri string) *x509.Certificate {
+			u, err := url.Parse(uri)
+			So(err, ShouldBeNil)
+			return &x509.Certificate{URIs: []*url.URL{u}}
+		}
+
+		Convey("Then a valid SPIFFE ID should be normalized", func() {
+			id, trustDomain, err := SpiffeID(parse("SPIFFE://Example.ORG/ns/prod"))
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, "spiffe://example.org/ns/prod")
+			So(trustDomain, ShouldEqual, "example.org")
+		})
+
+		Convey("Then invalid SPIFFE IDs should be rejected", func() {
+			for _, uri := range []string{
+				"https://example.org/ns/prod",
+				"spiffe:///ns/prod",
+				"spiffe://example.org:443/ns/prod",
+				"spiffe://user@example.org/ns/prod",
+				"spiffe://example.org/ns/prod?q=1",
+				"spiffe://example.org/ns/prod#f",
+			} {
+				_, _, err := SpiffeID(parse(uri))
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
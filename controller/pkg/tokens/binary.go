@@ -0,0 +1,475 @@
+package tokens
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/cache"
+	"github.com/aporeto-inc/trireme-lib/utils/crypto"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// The binary tokens use the same framing as the JWT tokens so that the
+// nonce is at the same position. The body of the token starts with a magic
+// byte that can not start a JWT:
+//
+//	magic | version | expiry (4 bytes) | issuer length | issuer | fields
+//
+// The fields are encoded as type | length (uvarint) | value. The signature
+// is the last field and covers all the bytes of the body before it. The
+// transmitted key follows the body of the SYN and SYN/ACK tokens.
+const (
+	binaryTokenMagic   = 0xb7
+	binaryTokenVersion = 1
+	binaryHeaderSize   = 6
+)
+
+// Types of the fields of the binary tokens
+const (
+	fieldLCL       = 0x01
+	fieldRMT       = 0x02
+	fieldEK        = 0x03
+	fieldTag       = 0x04
+	fieldTagRefs   = 0x05
+	fieldET        = 0x06
+	fieldSignature = 0xff
+)
+
+// BinaryConfig configures the binary token generator. The binary tokens
+// carry the same claims as the JWT tokens with a compact encoding. The tags
+// of the dictionary are replaced by short references.
+type BinaryConfig struct {
+	// ValidityPeriod is the validity period of the tokens
+	ValidityPeriod time.Duration
+	// Issuer is the server that issues the tokens
+	Issuer string
+	// dictionary is the dictionary of the tags
+	dictionary *TagDictionary
+	// encryption decrypts the encrypted claims
+	encryption *ClaimsEncryption
+	// secrets is the secrets used for signing and verifying the tokens
+	secrets secrets.Secrets
+	// ackSize is the size of the ack tokens
+	ackSize uint32
+	// tokenCache caches the claims of the decoded tokens
+	tokenCache cache.DataStore
+}
+
+// binaryToken is a parsed binary token
+type binaryToken struct {
+	expiry    time.Time
+	issuer    string
+	claims    *ConnectionClaims
+	signed    []byte
+	signature []byte
+}
+
+// NewBinary creates a new binary token processor. The dictionary may be nil.
+func NewBinary(validity time.Duration, issuer string, s secrets.Secrets, dictionary *TagDictionary) (*BinaryConfig, error) {
+
+	if len(issuer) > MaxServerName {
+		return nil, fmt.Errorf("server id should be max %d chars. got %s", MaxServerName, issuer)
+	}
+
+	if s == nil {
+		return nil, errors.New("secrets can not be nil")
+	}
+
+	c := &BinaryConfig{
+		ValidityPeriod: validity,
+		Issuer:         issuer,
+		dictionary:     dictionary,
+		secrets:        s,
+		tokenCache:     cache.NewCacheWithExpiration("BinaryTokenCache", time.Millisecond*500),
+	}
+
+	// The nonces of the ack tokens have a fixed size and so do the tokens
+	ack, err := c.CreateAndSign(true, &ConnectionClaims{
+		LCL: make([]byte, NonceLength),
+		RMT: make([]byte, NonceLength),
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign tokens: %s", err)
+	}
+	c.ackSize = uint32(len(ack))
+
+	return c, nil
+}
+
+// IsBinaryToken returns true if the token is a binary token. It is used to
+// accept both formats during a migration.
+func IsBinaryToken(isAck bool, data []byte) bool {
+
+	if isAck {
+		return len(data) > 0 && data[0] == binaryTokenMagic
+	}
+
+	return len(data) > tokenPosition && data[tokenPosition] == binaryTokenMagic
+}
+
+// AckSize returns the size of the ack tokens
+func (c *BinaryConfig) AckSize() uint32 {
+	return c.ackSize
+}
+
+// CreateAndSign creates a new token and signs it with the issuer key. The
+// nonce is copied in the SYN and SYN/ACK tokens.
+func (c *BinaryConfig) CreateAndSign(isAck bool, claims *ConnectionClaims, nonce []byte) (token []byte, err error) {
+
+	body := make([]byte, binaryHeaderSize, 128)
+	body[0] = binaryTokenMagic
+	body[1] = binaryTokenVersion
+	binary.BigEndian.PutUint32(body[2:binaryHeaderSize], uint32(time.Now().Add(c.ValidityPeriod).Unix()))
+
+	body = append(body, byte(len(c.Issuer)))
+	body = append(body, c.Issuer...)
+
+	if len(claims.LCL) > 0 {
+		body = appendField(body, fieldLCL, claims.LCL)
+	}
+
+	if len(claims.RMT) > 0 {
+		body = appendField(body, fieldRMT, claims.RMT)
+	}
+
+	if len(claims.EK) > 0 {
+		body = appendField(body, fieldEK, claims.EK)
+	}
+
+	if claims.T != nil {
+		body = c.appendTags(body, claims.T.Tags)
+	}
+
+	if len(claims.ET) > 0 {
+		body = appendField(body, fieldET, claims.ET)
+	}
+
+	signature, err := sign(body, c.secrets.EncodingKey())
+	if err != nil {
+		return []byte{}, err
+	}
+	body = appendField(body, fieldSignature, signature)
+
+	// Ack packets don't carry the nonce and the transmitted key
+	if isAck {
+		return body, nil
+	}
+
+	if len(body) > 0xffff {
+		return []byte{}, errors.New("token is too large")
+	}
+
+	txKey := c.secrets.TransmittedKey()
+
+	token = make([]byte, tokenPosition+len(body)+len(txKey))
+	binary.BigEndian.PutUint16(token[0:noncePosition], uint16(len(body)))
+	copy(token[noncePosition:], nonce)
+	copy(token[tokenPosition:], body)
+	copy(token[tokenPosition+len(body):], txKey)
+
+	return token, nil
+}
+
+// Decode verifies the transmitted key of the SYN and SYN/ACK tokens and
+// the signature of the token. It returns the claims, the nonce and the
+// certificate of the sender.
+func (c *BinaryConfig) Decode(isAck bool, data []byte, previousCert interface{}) (claims *ConnectionClaims, nonce []byte, publicKey interface{}, err error) {
+
+	var ackCert interface{}
+
+	body := data
+
+	nonce = make([]byte, NonceLength)
+
+	if !isAck {
+
+		if len(data) < tokenPosition {
+			return nil, nil, nil, errors.New("not enough data")
+		}
+
+		bodyLength := int(binary.BigEndian.Uint16(data[0:noncePosition]))
+		if len(data) < tokenPosition+bodyLength {
+			return nil, nil, nil, errors.New("invalid token length")
+		}
+
+		copy(nonce, data[noncePosition:tokenPosition])
+
+		body = data[tokenPosition : tokenPosition+bodyLength]
+
+		ackCert, err = c.secrets.VerifyPublicKey(data[tokenPosition+bodyLength:])
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid public key: %w", err)
+		}
+
+		if cachedClaims, cerr := c.tokenCache.Get(string(body)); cerr == nil {
+			return cachedClaims.(*ConnectionClaims), nonce, ackCert, nil
+		}
+	}
+
+	token, err := c.parse(body)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to parse token: %s", err)
+	}
+
+	err = c.verify(c.secrets, token, ackCert, previousCert)
+
+	// During a rotation, peers that have not rotated yet may have signed
+	// the token with the previous secrets. The keys rejected by the pinning
+	// are not accepted with the previous secrets.
+	if err != nil && !secrets.IsPinnedKeyChanged(err) {
+		if rotated, ok := c.secrets.(*secrets.RotatedSecrets); ok {
+			if previous := rotated.Previous(); previous != nil {
+				if c.verify(previous, token, ackCert, previousCert) == nil {
+					rotated.PreviousUsed()
+					err = nil
+				}
+			}
+		}
+	}
+
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to parse token: %w", err)
+	}
+
+	if time.Now().After(token.expiry) {
+		return nil, nil, nil, errors.New("token is expired")
+	}
+
+	if err := decryptClaims(c.encryption, token.claims, c.secrets); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if !isAck && token.claims.T == nil {
+		token.claims.T = policy.NewTagStore()
+	}
+
+	setSpiffeClaims(token.claims, ackCert)
+
+	c.tokenCache.AddOrUpdate(string(body), token.claims)
+
+	return token.claims, nonce, ackCert, nil
+}
+
+// EnableClaimsEncryption enables the decryption of the claims encrypted with
+// the shared key. The claims encrypted for the key of the secrets are always
+// decrypted.
+func (c *BinaryConfig) EnableClaimsEncryption(e *ClaimsEncryption) {
+	c.encryption = e
+}
+
+// Randomize adds a nonce to an existing token
+func (c *BinaryConfig) Randomize(token []byte, nonce []byte) (err error) {
+
+	if len(token) < tokenPosition {
+		return errors.New("token is too small")
+	}
+
+	copy(token[noncePosition:], nonce)
+
+	return nil
+}
+
+// RetrieveNonce returns the nonce of a token. It copies the value
+func (c *BinaryConfig) RetrieveNonce(token []byte) ([]byte, error) {
+
+	if len(token) < tokenPosition {
+		return []byte{}, errors.New("invalid token")
+	}
+
+	nonce := make([]byte, NonceLength)
+	copy(nonce, token[noncePosition:tokenPosition])
+
+	return nonce, nil
+}
+
+// appendTags appends the tags to the body. The consecutive tags of the
+// dictionary are replaced by a single field of references.
+func (c *BinaryConfig) appendTags(body []byte, tags []string) []byte {
+
+	refs := []byte{}
+
+	for _, tag := range tags {
+
+		if ref, ok := c.dictionary.reference(tag); ok {
+			refs = append(refs, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(refs[len(refs)-tagReferenceSize:], ref)
+			continue
+		}
+
+		if len(refs) > 0 {
+			body = appendField(body, fieldTagRefs, refs)
+			refs = refs[:0]
+		}
+
+		body = appendField(body, fieldTag, []byte(tag))
+	}
+
+	if len(refs) > 0 {
+		body = appendField(body, fieldTagRefs, refs)
+	}
+
+	return body
+}
+
+// parse decodes the body of a token. The signature is not verified.
+func (c *BinaryConfig) parse(body []byte) (*binaryToken, error) {
+
+	if len(body) < binaryHeaderSize+1 || body[0] != binaryTokenMagic {
+		return nil, errors.New("not a binary token")
+	}
+
+	if body[1] != binaryTokenVersion {
+		return nil, fmt.Errorf("unsupported version %d", body[1])
+	}
+
+	token := &binaryToken{
+		expiry: time.Unix(int64(binary.BigEndian.Uint32(body[2:binaryHeaderSize])), 0),
+		claims: &ConnectionClaims{},
+	}
+
+	issuerLength := int(body[binaryHeaderSize])
+	offset := binaryHeaderSize + 1
+	if len(body) < offset+issuerLength {
+		return nil, errors.New("invalid issuer length")
+	}
+	token.issuer = string(body[offset : offset+issuerLength])
+	offset += issuerLength
+
+	for offset < len(body) {
+
+		start := offset
+
+		fieldType, value, n, err := readField(body[offset:])
+		if err != nil {
+			return nil, err
+		}
+		offset += n
+
+		switch fieldType {
+		case fieldLCL:
+			token.claims.LCL = append([]byte{}, value...)
+		case fieldRMT:
+			token.claims.RMT = append([]byte{}, value...)
+		case fieldEK:
+			token.claims.EK = append([]byte{}, value...)
+		case fieldET:
+			token.claims.ET = append([]byte{}, value...)
+		case fieldTag:
+			c.appendTag(token.claims, string(value))
+		case fieldTagRefs:
+			if len(value)%tagReferenceSize != 0 {
+				return nil, errors.New("invalid tag references")
+			}
+			for i := 0; i < len(value); i += tagReferenceSize {
+				tag, ok := c.dictionary.tag(binary.BigEndian.Uint32(value[i : i+tagReferenceSize]))
+				if !ok {
+					return nil, errors.New("unknown tag reference")
+				}
+				c.appendTag(token.claims, tag)
+			}
+		case fieldSignature:
+			if offset != len(body) {
+				return nil, errors.New("signature is not the last field")
+			}
+			token.signed = body[:start]
+			token.signature = value
+		default:
+			return nil, fmt.Errorf("unknown field %d", fieldType)
+		}
+	}
+
+	if token.signature == nil {
+		return nil, errors.New("missing signature")
+	}
+
+	return token, nil
+}
+
+// appendTag appends a tag to the claims
+func (c *BinaryConfig) appendTag(claims *ConnectionClaims, tag string) {
+
+	if claims.T == nil {
+		claims.T = policy.NewTagStore()
+	}
+
+	claims.T.Tags = append(claims.T.Tags, tag)
+}
+
+// verify verifies the signature of the token with the key of the issuer
+func (c *BinaryConfig) verify(s secrets.Secrets, token *binaryToken, ackCert, previousCert interface{}) error {
+
+	key, err := s.DecodingKey(token.issuer, ackCert, previousCert)
+	if err != nil {
+		return err
+	}
+
+	method, err := signingMethod(key)
+	if err != nil {
+		return err
+	}
+
+	return method.Verify(string(token.signed), jwt.EncodeSegment(token.signature), key)
+}
+
+// sign signs the data with the key with the JWT signing method of the key
+func sign(data []byte, key interface{}) ([]byte, error) {
+
+	method, err := signingMethod(key)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := method.Sign(string(data), key)
+	if err != nil {
+		return nil, err
+	}
+
+	return jwt.DecodeSegment(signature)
+}
+
+// signingMethod returns the JWT signing method of a key. The pre-shared
+// keys use HS256.
+func signingMethod(key interface{}) (jwt.SigningMethod, error) {
+
+	if _, ok := key.([]byte); ok {
+		return jwt.SigningMethodHS256, nil
+	}
+
+	return crypto.SigningMethod(key)
+}
+
+// appendField appends a field to the buffer
+func appendField(buffer []byte, fieldType byte, value []byte) []byte {
+
+	var length [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(length[:], uint64(len(value)))
+
+	buffer = append(buffer, fieldType)
+	buffer = append(buffer, length[:n]...)
+
+	return append(buffer, value...)
+}
+
+// readField reads the field at the start of the buffer. It returns the type
+// and the value of the field and the number of bytes read.
+func readField(buffer []byte) (byte, []byte, int, error) {
+
+	if len(buffer) < 2 {
+		return 0, nil, 0, errors.New("invalid field")
+	}
+
+	length, n := binary.Uvarint(buffer[1:])
+	if n <= 0 || length > uint64(len(buffer)-1-n) {
+		return 0, nil, 0, errors.New("invalid field length")
+	}
+
+	start := 1 + n
+	end := start + int(length)
+
+	return buffer[0], buffer[start:end], end, nil
+}
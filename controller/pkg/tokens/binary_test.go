@@ -0,0 +1,205 @@
+package tokens
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/testpki"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTagDictionary(t *testing.T) {
+
+	Convey("Given a dictionary", t, func() {
+
+		d, err := NewTagDictionary([]string{"app=frontend", "app=frontend", "a=b"})
+		So(err, ShouldBeNil)
+
+		Convey("Then the short and duplicate tags should be ignored", func() {
+			So(d.Len(), ShouldEqual, 1)
+			_, ok := d.reference("a=b")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Then the references should be resolved", func() {
+			ref, ok := d.reference("app=frontend")
+			So(ok, ShouldBeTrue)
+			tag, ok := d.tag(ref)
+			So(ok, ShouldBeTrue)
+			So(tag, ShouldEqual, "app=frontend")
+		})
+
+		Convey("Then a nil dictionary should be empty", func() {
+			var empty *TagDictionary
+			So(empty.Len(), ShouldEqual, 0)
+			_, ok := empty.reference("app=frontend")
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func TestCreateAndVerifyBinary(t *testing.T) {
+
+	Convey("Given binary token engines with PKI secrets", t, func() {
+
+		s, cert := pkiSecrets(testpki.NewAuthority("ca"))
+
+		dictionary, err := NewTagDictionary([]string{"label1=value1", "@sys:image=nginx"})
+		So(err, ShouldBeNil)
+
+		sender, err := NewBinary(validity, "TRIREME", s, dictionary)
+		So(err, ShouldBeNil)
+
+		nonce := []byte("1234567890123456")
+		claims := &ConnectionClaims{
+			T:   policy.NewTagStoreFromSlice([]string{"label1=value1", "label2=value2", "@sys:image=nginx"}),
+			RMT: []byte(rmt),
+			EK:  []byte("service"),
+		}
+
+		Convey("Then a syn token should be decoded with the same dictionary", func() {
+			token, err := sender.CreateAndSign(false, claims, nonce)
+			So(err, ShouldBeNil)
+			So(IsBinaryToken(false, token), ShouldBeTrue)
+
+			receiver, err := NewBinary(validity, "RECEIVER", s, dictionary)
+			So(err, ShouldBeNil)
+
+			recoveredClaims, recoveredNonce, key, err := receiver.Decode(false, token, nil)
+			So(err, ShouldBeNil)
+			So(recoveredNonce, ShouldResemble, nonce)
+			So(recoveredClaims.T.Tags, ShouldResemble, claims.T.Tags)
+			So(recoveredClaims.RMT, ShouldResemble, claims.RMT)
+			So(recoveredClaims.EK, ShouldResemble, claims.EK)
+			So(key.(*x509.Certificate).Equal(cert), ShouldBeTrue)
+		})
+
+		Convey("Then a syn token should be smaller than the JWT", func() {
+			token, err := sender.CreateAndSign(false, claims, nonce)
+			So(err, ShouldBeNil)
+
+			jwtConfig, err := NewJWT(validity, "TRIREME", s)
+			So(err, ShouldBeNil)
+			jwtToken, err := jwtConfig.CreateAndSign(false, claims, nonce)
+			So(err, ShouldBeNil)
+
+			So(IsBinaryToken(false, jwtToken), ShouldBeFalse)
+			So(len(token), ShouldBeLessThan, len(jwtToken))
+		})
+
+		Convey("Then a syn token should be rejected without the dictionary", func() {
+			token, err := sender.CreateAndSign(false, claims, nonce)
+			So(err, ShouldBeNil)
+
+			receiver, err := NewBinary(validity, "RECEIVER", s, nil)
+			So(err, ShouldBeNil)
+
+			_, _, _, err = receiver.Decode(false, token, nil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then a modified syn token should be rejected", func() {
+			token, err := sender.CreateAndSign(false, claims, nonce)
+			So(err, ShouldBeNil)
+
+			token[tokenPosition+binaryHeaderSize+2] ^= 0xff
+
+			_, _, _, err = sender.Decode(false, token, nil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then an ack token should have the ack size and be verified", func() {
+			token, err := sender.CreateAndSign(true, &ackClaims, nonce)
+			So(err, ShouldBeNil)
+			So(IsBinaryToken(true, token), ShouldBeTrue)
+			So(len(token), ShouldEqual, int(sender.AckSize())-NonceLength+len(ackClaims.LCL))
+
+			recoveredClaims, _, _, err := sender.Decode(true, token, cert.PublicKey)
+			So(err, ShouldBeNil)
+			So(recoveredClaims.LCL, ShouldResemble, ackClaims.LCL)
+			So(recoveredClaims.RMT, ShouldResemble, ackClaims.RMT)
+		})
+
+		Convey("Then an ack token should be rejected with another key", func() {
+			token, err := sender.CreateAndSign(true, &ackClaims, nonce)
+			So(err, ShouldBeNil)
+
+			_, other := pkiSecrets(testpki.NewAuthority("ca"))
+			_, _, _, err = sender.Decode(true, token, other.PublicKey)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then an expired token should be rejected", func() {
+			expired, err := NewBinary(-validity, "TRIREME", s, dictionary)
+			So(err, ShouldBeNil)
+
+			token, err := expired.CreateAndSign(false, claims, nonce)
+			So(err, ShouldBeNil)
+
+			_, _, _, err = sender.Decode(false, token, nil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a binary token engine with PSK secrets", t, func() {
+
+		s := secrets.NewPSKSecrets(psk)
+		sender, err := NewBinary(validity, "TRIREME", s, nil)
+		So(err, ShouldBeNil)
+
+		Convey("Then a syn token should be signed and verified", func() {
+			token, err := sender.CreateAndSign(false, &defaultClaims, []byte(rmt))
+			So(err, ShouldBeNil)
+
+			recoveredClaims, _, _, err := sender.Decode(false, token, nil)
+			So(err, ShouldBeNil)
+			So(recoveredClaims.T.Tags, ShouldResemble, defaultClaims.T.Tags)
+		})
+
+		Convey("Then a token signed with another key should be rejected", func() {
+			other, err := NewBinary(validity, "TRIREME", secrets.NewPSKSecrets([]byte("ANOTHER KEY")), nil)
+			So(err, ShouldBeNil)
+
+			token, err := other.CreateAndSign(false, &defaultClaims, []byte(rmt))
+			So(err, ShouldBeNil)
+
+			_, _, _, err = sender.Decode(false, token, nil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestBinaryRandomizeAndRetrieveNonce(t *testing.T) {
+
+	Convey("Given a binary token", t, func() {
+
+		sender, err := NewBinary(validity, "TRIREME", secrets.NewPSKSecrets(psk), nil)
+		So(err, ShouldBeNil)
+
+		token, err := sender.CreateAndSign(false, &defaultClaims, []byte(rmt))
+		So(err, ShouldBeNil)
+
+		Convey("When the token is randomized", func() {
+			nonce := []byte("abcdefghijklmnop")
+			So(sender.Randomize(token, nonce), ShouldBeNil)
+
+			Convey("Then the nonce should be retrieved and the token should be valid", func() {
+				retrieved, err := sender.RetrieveNonce(token)
+				So(err, ShouldBeNil)
+				So(retrieved, ShouldResemble, nonce)
+
+				_, decodedNonce, _, err := sender.Decode(false, token, nil)
+				So(err, ShouldBeNil)
+				So(decodedNonce, ShouldResemble, nonce)
+			})
+		})
+
+		Convey("Then short tokens should be rejected", func() {
+			So(sender.Randomize([]byte{1, 2}, []byte(rmt)), ShouldNotBeNil)
+			_, err := sender.RetrieveNonce([]byte{1, 2})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
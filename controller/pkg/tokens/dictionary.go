@@ -0,0 +1,93 @@
+package tokens
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// tagReferenceSize is the size of the references to the tags of a dictionary
+const tagReferenceSize = 4
+
+// TagDictionary is a set of tags distributed out of band to all the
+// enforcers. The binary tokens carry a short hash of the tags of the
+// dictionary instead of the tags, so that the order of the tags in the
+// dictionaries does not matter. The tags that are not in the dictionary of
+// the receiver can not be decoded and the tokens that reference them are
+// rejected.
+type TagDictionary struct {
+	tags       map[uint32]string
+	references map[string]uint32
+}
+
+// NewTagDictionary creates a dictionary with the tags. An error is returned
+// if the hashes of two tags collide.
+func NewTagDictionary(tags []string) (*TagDictionary, error) {
+
+	d := &TagDictionary{
+		tags:       map[uint32]string{},
+		references: map[string]uint32{},
+	}
+
+	for _, tag := range tags {
+
+		if _, ok := d.references[tag]; ok {
+			continue
+		}
+
+		// Short tags are smaller than their reference
+		if len(tag) <= tagReferenceSize {
+			continue
+		}
+
+		ref := tagReference(tag)
+		if other, ok := d.tags[ref]; ok {
+			return nil, fmt.Errorf("tags %s and %s have the same reference", other, tag)
+		}
+
+		d.tags[ref] = tag
+		d.references[tag] = ref
+	}
+
+	return d, nil
+}
+
+// Len returns the number of tags of the dictionary
+func (d *TagDictionary) Len() int {
+
+	if d == nil {
+		return 0
+	}
+
+	return len(d.tags)
+}
+
+// reference returns the reference of the tag if it is in the dictionary
+func (d *TagDictionary) reference(tag string) (uint32, bool) {
+
+	if d == nil {
+		return 0, false
+	}
+
+	ref, ok := d.references[tag]
+	return ref, ok
+}
+
+// tag returns the tag of the reference
+func (d *TagDictionary) tag(ref uint32) (string, bool) {
+
+	if d == nil {
+		return "", false
+	}
+
+	tag, ok := d.tags[ref]
+	return tag, ok
+}
+
+// tagReference returns the first bytes of the hash of the tag
+func tagReference(tag string) uint32 {
+
+	hash := sha256.Sum256([]byte(tag))
+
+	return binary.BigEndian.Uint32(hash[:tagReferenceSize])
+}
@@ -0,0 +1,301 @@
+package tokens
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// claimsKeyLabel separates the keys derived for the claims from the other
+// uses of the same secrets
+const claimsKeyLabel = "trireme claims encryption"
+
+// Modes of the encrypted claims
+const (
+	// sharedMode encrypts the claims with the key shared by all the enforcers
+	sharedMode = 0x01
+	// peerMode encrypts the claims for the public key of the peer with an
+	// ephemeral ECDH key
+	peerMode = 0x02
+)
+
+// ClaimsEncryption encrypts the tags of the tokens so that they can not be
+// read on the network. The tokens are signed after the encryption and their
+// verification does not change.
+//
+// The tags are encrypted for the public key of the peer with an ephemeral
+// ECDH key, so that only the peer can decrypt them. The tags of the SYN/ACK
+// tokens are encrypted for the key of the initiator received in the SYN
+// packet. The tags of the SYN tokens are encrypted for the key of the
+// receiver if the initiator learned it from a previous SYN/ACK. Otherwise,
+// and if the key of the peer does not support ECDH, the tags are encrypted
+// with a key distributed out of band to all the enforcers, and any holder of
+// that key can read them. The EK claim carries the service context of the PU
+// and is not used as an encryption key.
+type ClaimsEncryption struct {
+	shared cipher.AEAD
+}
+
+// NewClaimsEncryption creates the encryption of the claims with the key
+// shared by all the enforcers
+func NewClaimsEncryption(sharedKey []byte) (*ClaimsEncryption, error) {
+
+	if len(sharedKey) == 0 {
+		return nil, errors.New("claims encryption key can not be empty")
+	}
+
+	aead, err := newClaimsCipher([]byte(claimsKeyLabel), sharedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClaimsEncryption{shared: aead}, nil
+}
+
+// Encrypt returns a copy of the claims where the tags are replaced by their
+// encryption. The peer key is the certificate or the public key of the peer.
+// It may be nil if it is not known.
+func (e *ClaimsEncryption) Encrypt(claims *ConnectionClaims, peerKey interface{}) (*ConnectionClaims, error) {
+
+	if claims.T == nil {
+		return claims, nil
+	}
+
+	plaintext, err := json.Marshal(claims.T.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	var sealed []byte
+	if public := ecdhPublicKey(peerKey); public != nil {
+		sealed, err = sealForPeer(plaintext, public)
+	} else {
+		sealed, err = seal(e.shared, []byte{sharedMode}, plaintext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to encrypt claims: %s", err)
+	}
+
+	encrypted := *claims
+	encrypted.T = nil
+	encrypted.ET = sealed
+
+	return &encrypted, nil
+}
+
+// decrypt replaces the encrypted tags of the claims with the tags. The
+// private key is the key of the receiver. The encryption may be nil if no
+// shared key is configured.
+func (e *ClaimsEncryption) decrypt(claims *ConnectionClaims, privateKey interface{}) error {
+
+	if len(claims.ET) == 0 {
+		return nil
+	}
+
+	var plaintext []byte
+	var err error
+
+	switch claims.ET[0] {
+	case sharedMode:
+		if e == nil {
+			return errors.New("no claims encryption key")
+		}
+		plaintext, err = open(e.shared, claims.ET[:1], claims.ET[1:])
+	case peerMode:
+		plaintext, err = openFromPeer(claims.ET, privateKey)
+	default:
+		return fmt.Errorf("unknown claims encryption mode %d", claims.ET[0])
+	}
+	if err != nil {
+		return fmt.Errorf("unable to decrypt claims: %s", err)
+	}
+
+	var tags []string
+	if err := json.Unmarshal(plaintext, &tags); err != nil {
+		return fmt.Errorf("invalid encrypted claims: %s", err)
+	}
+
+	claims.T = policy.NewTagStoreFromSlice(tags)
+	claims.ET = nil
+
+	return nil
+}
+
+// decryptClaims decrypts the claims with the key of the secrets. During a
+// rotation, the claims encrypted for the previous key are decrypted with the
+// previous secrets, since the peers may have learned the previous key.
+func decryptClaims(e *ClaimsEncryption, claims *ConnectionClaims, s secrets.Secrets) error {
+
+	err := e.decrypt(claims, s.EncodingKey())
+	if err == nil {
+		return nil
+	}
+
+	if rotated, ok := s.(*secrets.RotatedSecrets); ok {
+		if previous := rotated.Previous(); previous != nil {
+			if e.decrypt(claims, previous.EncodingKey()) == nil {
+				rotated.PreviousUsed()
+				return nil
+			}
+		}
+	}
+
+	return err
+}
+
+// sealForPeer encrypts the plaintext with a key agreed between an ephemeral
+// key and the key of the peer. The ephemeral public key is prepended to the
+// ciphertext.
+func sealForPeer(plaintext []byte, public *ecdh.PublicKey) ([]byte, error) {
+
+	ephemeral, err := public.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := ephemeral.ECDH(public)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeralPublic := ephemeral.PublicKey().Bytes()
+
+	aead, err := newClaimsCipher(ephemeralPublic, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	header := append([]byte{peerMode, byte(len(ephemeralPublic))}, ephemeralPublic...)
+
+	return seal(aead, header, plaintext)
+}
+
+// ecdhKey is a private key that agrees secrets with ECDH. The keys of the
+// key stores that support ECDH implement it without exporting the key.
+type ecdhKey interface {
+	ECDH(remote *ecdh.PublicKey) ([]byte, error)
+}
+
+// openFromPeer decrypts the ciphertext encrypted for the private key
+func openFromPeer(data []byte, privateKey interface{}) ([]byte, error) {
+
+	var private ecdhKey
+	var curve ecdh.Curve
+
+	switch key := privateKey.(type) {
+	case *ecdsa.PrivateKey:
+		k, err := key.ECDH()
+		if err != nil {
+			return nil, err
+		}
+		private, curve = k, k.Curve()
+	case crypto.Signer:
+		k, ok := key.(ecdhKey)
+		public := ecdhPublicKey(key.Public())
+		if !ok || public == nil {
+			return nil, errors.New("private key does not support ecdh")
+		}
+		private, curve = k, public.Curve()
+	default:
+		return nil, errors.New("private key does not support ecdh")
+	}
+
+	if len(data) < 2 || len(data) < 2+int(data[1]) {
+		return nil, errors.New("invalid ephemeral key")
+	}
+
+	header := data[:2+int(data[1])]
+	ephemeralPublic := header[2:]
+
+	public, err := curve.NewPublicKey(ephemeralPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := private.ECDH(public)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newClaimsCipher(ephemeralPublic, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(aead, header, data[len(header):])
+}
+
+// newClaimsCipher derives an AES-GCM cipher from the secret
+func newClaimsCipher(context, secret []byte) (cipher.AEAD, error) {
+
+	hash := sha256.New()
+	hash.Write([]byte(claimsKeyLabel)) // nolint: errcheck
+	hash.Write(context)                // nolint: errcheck
+	hash.Write(secret)                 // nolint: errcheck
+
+	block, err := aes.NewCipher(hash.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the plaintext with a random nonce. The header is
+// authenticated and the result is header | nonce | ciphertext.
+func seal(aead cipher.AEAD, header, plaintext []byte) ([]byte, error) {
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := append(append([]byte{}, header...), nonce...)
+
+	return aead.Seal(sealed, nonce, plaintext, header), nil
+}
+
+// open decrypts the data that follows the header
+func open(aead cipher.AEAD, header, data []byte) ([]byte, error) {
+
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("invalid ciphertext")
+	}
+
+	nonce := data[:aead.NonceSize()]
+
+	return aead.Open(nil, nonce, data[aead.NonceSize():], header)
+}
+
+// ecdhPublicKey returns the ECDH public key of a certificate or an ECDSA
+// public key. It returns nil for the other keys.
+func ecdhPublicKey(key interface{}) *ecdh.PublicKey {
+
+	if cert, ok := key.(*x509.Certificate); ok {
+		key = cert.PublicKey
+	}
+
+	ecdsaKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil
+	}
+
+	public, err := ecdsaKey.ECDH()
+	if err != nil {
+		return nil
+	}
+
+	return public
+}
@@ -0,0 +1,291 @@
+package tokens
+
+import (
+	"bytes"
+	gocrypto "crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	cryptoutils "github.com/aporeto-inc/trireme-lib/utils/crypto"
+	"github.com/aporeto-inc/trireme-lib/utils/testpki"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testECDHSigner hides the private key behind the crypto.Signer interface
+// and agrees secrets with ECDH, like the keys of an HSM.
+type testECDHSigner struct {
+	key *ecdsa.PrivateKey
+}
+
+func (s *testECDHSigner) Public() gocrypto.PublicKey {
+	return s.key.Public()
+}
+
+func (s *testECDHSigner) Sign(rand io.Reader, digest []byte, opts gocrypto.SignerOpts) ([]byte, error) {
+	return s.key.Sign(rand, digest, opts)
+}
+
+func (s *testECDHSigner) ECDH(remote *ecdh.PublicKey) ([]byte, error) {
+	private, err := s.key.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	return private.ECDH(remote)
+}
+
+func TestClaimsEncryption(t *testing.T) {
+
+	Convey("Given enforcers with PKI secrets and a shared claims key", t, func() {
+
+		ca := testpki.NewAuthority("ca")
+		initiatorSecrets, initiatorCert := pkiSecrets(ca)
+		responderSecrets, responderCert := pkiSecrets(ca)
+
+		encryption, err := NewClaimsEncryption([]byte("shared claims key"))
+		So(err, ShouldBeNil)
+
+		initiator, err := NewJWT(validity, "INITIATOR", initiatorSecrets)
+		So(err, ShouldBeNil)
+		initiator.EnableClaimsEncryption(encryption)
+
+		responder, err := NewJWT(validity, "RESPONDER", responderSecrets)
+		So(err, ShouldBeNil)
+		responder.EnableClaimsEncryption(encryption)
+
+		claims := &ConnectionClaims{
+			T:  policy.NewTagStoreFromSlice([]string{"app=secret-frontend", "label1=value1"}),
+			EK: []byte("service"),
+		}
+		nonce := []byte("1234567890123456")
+
+		Convey("Then the tags of a syn token should be encrypted with the shared key", func() {
+			encrypted, err := encryption.Encrypt(claims, nil)
+			So(err, ShouldBeNil)
+			So(encrypted.T, ShouldBeNil)
+			So(encrypted.ET[0], ShouldEqual, sharedMode)
+			So(claims.T, ShouldNotBeNil)
+
+			token, err := initiator.CreateAndSign(false, encrypted, nonce)
+			So(err, ShouldBeNil)
+			So(bytes.Contains(token, []byte("secret-frontend")), ShouldBeFalse)
+
+			decoded, _, _, err := responder.Decode(false, token, nil)
+			So(err, ShouldBeNil)
+			So(decoded.T.Tags, ShouldResemble, claims.T.Tags)
+			So(decoded.EK, ShouldResemble, claims.EK)
+			So(decoded.ET, ShouldBeNil)
+		})
+
+		Convey("Then the tags of a syn token should not be decrypted without the shared key", func() {
+			encrypted, err := encryption.Encrypt(claims, nil)
+			So(err, ShouldBeNil)
+
+			token, err := initiator.CreateAndSign(false, encrypted, nonce)
+			So(err, ShouldBeNil)
+
+			responder.EnableClaimsEncryption(nil)
+			_, _, _, err = responder.Decode(false, token, nil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then the tags of a syn/ack token should be encrypted for the initiator", func() {
+			encrypted, err := encryption.Encrypt(claims, initiatorCert)
+			So(err, ShouldBeNil)
+			So(encrypted.ET[0], ShouldEqual, peerMode)
+
+			token, err := responder.CreateAndSign(false, encrypted, nonce)
+			So(err, ShouldBeNil)
+			So(bytes.Contains(token, []byte("secret-frontend")), ShouldBeFalse)
+
+			decoded, _, _, err := initiator.Decode(false, token, nil)
+			So(err, ShouldBeNil)
+			So(decoded.T.Tags, ShouldResemble, claims.T.Tags)
+
+			Convey("Then the other enforcers should not decrypt them", func() {
+				otherSecrets, _ := pkiSecrets(ca)
+				other, err := NewJWT(validity, "OTHER", otherSecrets)
+				So(err, ShouldBeNil)
+				other.EnableClaimsEncryption(encryption)
+
+				_, _, _, err = other.Decode(false, token, nil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("Then the tags of a syn token should be encrypted for a known receiver", func() {
+			encrypted, err := encryption.Encrypt(claims, responderCert)
+			So(err, ShouldBeNil)
+			So(encrypted.ET[0], ShouldEqual, peerMode)
+
+			token, err := initiator.CreateAndSign(false, encrypted, nonce)
+			So(err, ShouldBeNil)
+
+			decoded, _, _, err := responder.Decode(false, token, nil)
+			So(err, ShouldBeNil)
+			So(decoded.T.Tags, ShouldResemble, claims.T.Tags)
+
+			Convey("Then the other enforcers should not decrypt them with the shared key", func() {
+				otherSecrets, _ := pkiSecrets(ca)
+				other, err := NewJWT(validity, "OTHER", otherSecrets)
+				So(err, ShouldBeNil)
+				other.EnableClaimsEncryption(encryption)
+
+				_, _, _, err = other.Decode(false, token, nil)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then the receiver should decrypt them with its previous key during a rotation", func() {
+				rotatedSecrets, _ := pkiSecrets(ca)
+				rotated, err := secrets.NewRotatedSecrets(rotatedSecrets, responderSecrets, time.Hour)
+				So(err, ShouldBeNil)
+
+				receiver, err := NewJWT(validity, "RESPONDER", rotated)
+				So(err, ShouldBeNil)
+				receiver.EnableClaimsEncryption(encryption)
+
+				decoded, _, _, err := receiver.Decode(false, token, nil)
+				So(err, ShouldBeNil)
+				So(decoded.T.Tags, ShouldResemble, claims.T.Tags)
+			})
+		})
+
+		Convey("Then a modified encryption should be rejected", func() {
+			encrypted, err := encryption.Encrypt(claims, nil)
+			So(err, ShouldBeNil)
+			encrypted.ET[len(encrypted.ET)-1] ^= 0xff
+
+			token, err := initiator.CreateAndSign(false, encrypted, nonce)
+			So(err, ShouldBeNil)
+
+			_, _, _, err = responder.Decode(false, token, nil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then the encrypted tags should be carried by binary tokens", func() {
+			binaryInitiator, err := NewBinary(validity, "INITIATOR", initiatorSecrets, nil)
+			So(err, ShouldBeNil)
+			binaryResponder, err := NewBinary(validity, "RESPONDER", responderSecrets, nil)
+			So(err, ShouldBeNil)
+			binaryInitiator.EnableClaimsEncryption(encryption)
+
+			encrypted, err := encryption.Encrypt(claims, initiatorCert)
+			So(err, ShouldBeNil)
+
+			token, err := binaryResponder.CreateAndSign(false, encrypted, nonce)
+			So(err, ShouldBeNil)
+
+			decoded, _, _, err := binaryInitiator.Decode(false, token, nil)
+			So(err, ShouldBeNil)
+			So(decoded.T.Tags, ShouldResemble, claims.T.Tags)
+		})
+	})
+
+	Convey("Given an initiator whose key is only available in a key store", t, func() {
+
+		ca := testpki.NewAuthority("ca")
+		pemSecrets, initiatorCert := pkiSecrets(ca)
+		responderSecrets, _ := pkiSecrets(ca)
+
+		cryptoutils.RegisterKeyStore("tokenstest", func(uri string) (gocrypto.Signer, error) {
+			key, err := cryptoutils.LoadPrivateKey(pemSecrets.PrivateKeyPEM)
+			if err != nil {
+				return nil, err
+			}
+			return &testECDHSigner{key: key.(*ecdsa.PrivateKey)}, nil
+		})
+
+		initiatorSecrets, err := secrets.NewPKISecretsFromKeyStore("tokenstest:initiator", pemSecrets.PublicKeyPEM, pemSecrets.AuthorityPEM, nil)
+		So(err, ShouldBeNil)
+
+		encryption, err := NewClaimsEncryption([]byte("shared claims key"))
+		So(err, ShouldBeNil)
+
+		initiator, err := NewJWT(validity, "INITIATOR", initiatorSecrets)
+		So(err, ShouldBeNil)
+		initiator.EnableClaimsEncryption(encryption)
+
+		responder, err := NewJWT(validity, "RESPONDER", responderSecrets)
+		So(err, ShouldBeNil)
+
+		claims := &ConnectionClaims{
+			T: policy.NewTagStoreFromSlice([]string{"app=secret-frontend"}),
+		}
+		nonce := []byte("1234567890123456")
+
+		Convey("Then the tokens should be signed with the key store", func() {
+			token, err := initiator.CreateAndSign(false, claims, nonce)
+			So(err, ShouldBeNil)
+
+			decoded, _, key, err := responder.Decode(false, token, nil)
+			So(err, ShouldBeNil)
+			So(decoded.T.Tags, ShouldResemble, claims.T.Tags)
+			So(key.(*x509.Certificate).Equal(initiatorCert), ShouldBeTrue)
+		})
+
+		Convey("Then the tags encrypted for the initiator should be decrypted with the key store", func() {
+			encrypted, err := encryption.Encrypt(claims, initiatorCert)
+			So(err, ShouldBeNil)
+
+			token, err := responder.CreateAndSign(false, encrypted, nonce)
+			So(err, ShouldBeNil)
+
+			decoded, _, _, err := initiator.Decode(false, token, nil)
+			So(err, ShouldBeNil)
+			So(decoded.T.Tags, ShouldResemble, claims.T.Tags)
+		})
+	})
+
+	Convey("Given a peer with an Ed25519 key", t, func() {
+
+		_, cert := pkiSecrets(testpki.NewAuthority("ca"), testpki.WithKeyGenerator(func() (gocrypto.Signer, error) {
+			_, key, err := ed25519.GenerateKey(rand.Reader)
+			return key, err
+		}))
+
+		encryption, err := NewClaimsEncryption(psk)
+		So(err, ShouldBeNil)
+
+		Convey("Then the shared key should be used", func() {
+			encrypted, err := encryption.Encrypt(&defaultClaims, cert)
+			So(err, ShouldBeNil)
+			So(encrypted.ET[0], ShouldEqual, sharedMode)
+		})
+	})
+
+	Convey("Given PSK secrets", t, func() {
+
+		s := secrets.NewPSKSecrets(psk)
+
+		Convey("Then an empty claims key should be rejected", func() {
+			_, err := NewClaimsEncryption(nil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then the tags should be encrypted and decrypted with the shared key", func() {
+			encryption, err := NewClaimsEncryption(psk)
+			So(err, ShouldBeNil)
+
+			jwtConfig, err := NewJWT(validity, "TRIREME", s)
+			So(err, ShouldBeNil)
+			jwtConfig.EnableClaimsEncryption(encryption)
+
+			encrypted, err := encryption.Encrypt(&defaultClaims, nil)
+			So(err, ShouldBeNil)
+
+			token, err := jwtConfig.CreateAndSign(false, encrypted, []byte(rmt))
+			So(err, ShouldBeNil)
+
+			decoded, _, _, err := jwtConfig.Decode(false, token, nil)
+			So(err, ShouldBeNil)
+			So(decoded.T.Tags, ShouldResemble, defaultClaims.T.Tags)
+		})
+	})
+}
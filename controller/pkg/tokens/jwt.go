@@ -9,6 +9,7 @@ import (
 
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
+	"github.com/aporeto-inc/trireme-lib/utils/crypto"
 
 	"github.com/dgrijalva/jwt-go"
 )
@@ -33,6 +34,8 @@ type JWTConfig struct {
 	Issuer string
 	// signMethod is the method used to sign the JWT
 	signMethod jwt.SigningMethod
+	// encryption decrypts the encrypted claims
+	encryption *ClaimsEncryption
 	// secrets is the secrets used for signing and verifying the JWT
 	secrets secrets.Secrets
 	// cache test
@@ -57,8 +60,14 @@ func NewJWT(validity time.Duration, issuer string, s secrets.Secrets) (*JWTConfi
 	}
 
 	switch s.Type() {
-	case secrets.PKIType, secrets.PKICompactType:
-		signMethod = jwt.SigningMethodES256
+	case secrets.PKIType, secrets.PKICompactType, secrets.SVIDType:
+		// The signing method follows the key so that the P-256 keys
+		// keep using ES256
+		method, err := crypto.SigningMethod(s.EncodingKey())
+		if err != nil {
+			return nil, fmt.Errorf("unsupported key: %s", err)
+		}
+		signMethod = method
 	case secrets.PSKType:
 		signMethod = jwt.SigningMethodHS256
 	default:
@@ -162,7 +171,7 @@ func (c *JWTConfig) Decode(isAck bool, data []byte, previousCert interface{}) (c
 		certBytes := data[tokenPosition+tokenLength+1:]
 		ackCert, err = c.secrets.VerifyPublicKey(certBytes)
 		if err != nil {
-			return nil, nil, nil, fmt.Errorf("invalid public key: %s", err)
+			return nil, nil, nil, fmt.Errorf("invalid public key: %w", err)
 		}
 
 		if cachedClaims, cerr := c.tokenCache.Get(string(token)); cerr == nil {
@@ -177,19 +186,61 @@ func (c *JWTConfig) Decode(isAck bool, data []byte, previousCert interface{}) (c
 		return c.secrets.DecodingKey(server, ackCert, previousCert)
 	})
 
-	// If error is returned or the token is not valid, reject it
+	// During a rotation, peers that have not rotated yet may have signed
+	// the token with the previous secrets. The keys rejected by the pinning
+	// are not accepted with the previous secrets.
+	if err != nil && !secrets.IsPinnedKeyChanged(tokenError(err)) {
+		if rotated, ok := c.secrets.(*secrets.RotatedSecrets); ok {
+			if previous := rotated.Previous(); previous != nil {
+				jwtClaims = &JWTClaims{}
+				jwttoken, err = jwt.ParseWithClaims(string(token), jwtClaims, func(token *jwt.Token) (interface{}, error) {
+					server := token.Claims.(*JWTClaims).Issuer
+					server = strings.Trim(server, " ")
+					return previous.DecodingKey(server, ackCert, previousCert)
+				})
+				if err == nil && jwttoken.Valid {
+					rotated.PreviousUsed()
+				}
+			}
+		}
+	}
+
+	// If error is returned or the token is not valid, reject it. The errors
+	// of the decoding key are kept so that revocations can be reported.
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("unable to parse token: %s", err)
+		return nil, nil, nil, fmt.Errorf("unable to parse token: %w", tokenError(err))
 	}
 	if !jwttoken.Valid {
 		return nil, nil, nil, errors.New("invalid token")
 	}
 
+	if err := decryptClaims(c.encryption, jwtClaims.ConnectionClaims, c.secrets); err != nil {
+		return nil, nil, nil, err
+	}
+
+	setSpiffeClaims(jwtClaims.ConnectionClaims, ackCert)
+
 	c.tokenCache.AddOrUpdate(string(token), jwtClaims.ConnectionClaims)
 
 	return jwtClaims.ConnectionClaims, nonce, ackCert, nil
 }
 
+// tokenError returns the error of the decoding key of a token that was
+// rejected, or the error itself
+func tokenError(err error) error {
+	if verr, ok := err.(*jwt.ValidationError); ok && verr.Inner != nil {
+		return verr.Inner
+	}
+	return err
+}
+
+// EnableClaimsEncryption enables the decryption of the claims encrypted with
+// the shared key. The claims encrypted for the key of the secrets are always
+// decrypted.
+func (c *JWTConfig) EnableClaimsEncryption(e *ClaimsEncryption) {
+	c.encryption = e
+}
+
 // Randomize adds a nonce to an existing token. Returns the nonce
 func (c *JWTConfig) Randomize(token []byte, nonce []byte) (err error) {
 
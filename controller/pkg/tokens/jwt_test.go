@@ -1,13 +1,20 @@
 package tokens
 
 import (
+	gocrypto "crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/crypto"
+	"github.com/aporeto-inc/trireme-lib/utils/testpki"
 	jwt "github.com/dgrijalva/jwt-go"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -172,6 +179,41 @@ func TestCreateAndVerifyPSK(t *testing.T) {
 	})
 }
 
+func TestVerifyPSKRotation(t *testing.T) {
+	Convey("Given a JWT engine with the previous pre-shared key", t, func() {
+		previous := secrets.NewPSKSecrets(psk)
+		oldConfig, _ := NewJWT(validity, "TRIREME", previous)
+		nonce := []byte("1234567890123456")
+
+		token, err := oldConfig.CreateAndSign(false, &defaultClaims, nonce)
+		So(err, ShouldBeNil)
+
+		Convey("When the key is rotated with a grace period", func() {
+			rotated, err := secrets.NewRotatedSecrets(secrets.NewPSKSecrets([]byte("I HAVE A BETTER KEY")), previous, time.Hour)
+			So(err, ShouldBeNil)
+			jwtConfig, _ := NewJWT(validity, "TRIREME", rotated)
+
+			Convey("Then the tokens signed with the previous key should be accepted", func() {
+				recoveredClaims, _, _, err := jwtConfig.Decode(false, token, nil)
+				So(err, ShouldBeNil)
+				So(string(recoveredClaims.RMT), ShouldEqual, rmt)
+				So(rotated.PreviousLastUsed().IsZero(), ShouldBeFalse)
+			})
+		})
+
+		Convey("When the key is rotated and the grace period is over", func() {
+			rotated, err := secrets.NewRotatedSecrets(secrets.NewPSKSecrets([]byte("I HAVE A BETTER KEY")), previous, -time.Second)
+			So(err, ShouldBeNil)
+			jwtConfig, _ := NewJWT(validity, "TRIREME", rotated)
+
+			Convey("Then the tokens signed with the previous key should be rejected", func() {
+				_, _, _, err := jwtConfig.Decode(false, token, nil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
 func TestCreateAndVerifyPKI(t *testing.T) {
 	Convey("Given a JWT valid engine with a PKI  key ", t, func() {
 		secrets, serr := secrets.NewPKISecrets([]byte(keyPEM), []byte(certPEM), []byte(caPool), nil)
@@ -323,3 +365,145 @@ func TestRetrieveNonce(t *testing.T) {
 		})
 	})
 }
+
+// pkiSecrets creates the PKI secrets of a certificate issued by the CA
+func pkiSecrets(ca *testpki.Authority, opts ...testpki.Option) (*secrets.PKISecrets, *x509.Certificate) {
+
+	leaf := ca.Issue("enforcer", opts...)
+
+	s, err := secrets.NewPKISecrets(leaf.KeyPEM(), leaf.CertPEM(), ca.CertPEM(), nil)
+	So(err, ShouldBeNil)
+
+	return s, leaf.Cert
+}
+
+func TestCreateAndVerifyPKIKeyTypes(t *testing.T) {
+
+	generators := map[string]func() (gocrypto.Signer, error){
+		"ES256": func() (gocrypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P256(), rand.Reader) },
+		"ES384": func() (gocrypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P384(), rand.Reader) },
+		"EdDSA": func() (gocrypto.Signer, error) {
+			_, key, err := ed25519.GenerateKey(rand.Reader)
+			return key, err
+		},
+	}
+
+	for alg, generate := range generators {
+
+		Convey("Given PKI secrets with "+alg+" keys", t, func() {
+
+			s, cert := pkiSecrets(testpki.NewAuthority("ca", testpki.WithKeyGenerator(generate)), testpki.WithKeyGenerator(generate))
+			jwtConfig, err := NewJWT(validity, "TRIREME", s)
+			So(err, ShouldBeNil)
+			So(jwtConfig.signMethod.Alg(), ShouldEqual, alg)
+
+			nonce := []byte("1234567890123456")
+
+			Convey("Then a syn token should be signed and verified", func() {
+				token, err := jwtConfig.CreateAndSign(false, &defaultClaims, nonce)
+				So(err, ShouldBeNil)
+
+				recoveredClaims, recoveredNonce, key, err := jwtConfig.Decode(false, token, nil)
+				So(err, ShouldBeNil)
+				So(recoveredNonce, ShouldResemble, nonce)
+				So(recoveredClaims.T.Tags, ShouldResemble, defaultClaims.T.Tags)
+				So(key.(*x509.Certificate).Equal(cert), ShouldBeTrue)
+			})
+
+			Convey("Then an ack token should grow with the ack size and be verified", func() {
+				token, err := jwtConfig.CreateAndSign(true, &ackClaims, nonce)
+				So(err, ShouldBeNil)
+
+				reference, _ := pkiSecrets(testpki.NewAuthority("ca", testpki.WithKeyGenerator(generators["ES256"])), testpki.WithKeyGenerator(generators["ES256"]))
+				referenceConfig, err := NewJWT(validity, "TRIREME", reference)
+				So(err, ShouldBeNil)
+				referenceToken, err := referenceConfig.CreateAndSign(true, &ackClaims, nonce)
+				So(err, ShouldBeNil)
+				So(int(s.AckSize())-len(token), ShouldEqual, int(reference.AckSize())-len(referenceToken))
+
+				recoveredClaims, _, _, err := jwtConfig.Decode(true, token, cert.PublicKey)
+				So(err, ShouldBeNil)
+				So(recoveredClaims.LCL, ShouldResemble, ackClaims.LCL)
+			})
+
+			Convey("Then an ack token should be rejected with another key", func() {
+				token, err := jwtConfig.CreateAndSign(true, &ackClaims, nonce)
+				So(err, ShouldBeNil)
+
+				_, other := pkiSecrets(testpki.NewAuthority("ca", testpki.WithKeyGenerator(generate)), testpki.WithKeyGenerator(generate))
+				_, _, _, err = jwtConfig.Decode(true, token, other.PublicKey)
+				So(err, ShouldNotBeNil)
+			})
+		})
+	}
+}
+
+// revokedSecrets are secrets that reject the certificates of the peers as
+// revoked
+type revokedSecrets struct {
+	*secrets.PKISecrets
+}
+
+func (s *revokedSecrets) VerifyPublicKey(pkey []byte) (interface{}, error) {
+	return nil, fmt.Errorf("serial 2: %w", crypto.ErrRevoked)
+}
+
+func TestDecodeRevoked(t *testing.T) {
+
+	Convey("Given a token of a peer whose certificate is revoked", t, func() {
+
+		s, _ := pkiSecrets(testpki.NewAuthority("ca"))
+		jwtConfig, err := NewJWT(validity, "TRIREME", s)
+		So(err, ShouldBeNil)
+
+		nonce := []byte("1234567890123456")
+		token, err := jwtConfig.CreateAndSign(false, &defaultClaims, nonce)
+		So(err, ShouldBeNil)
+
+		Convey("Then the token should be rejected as revoked", func() {
+			revokedConfig, err := NewJWT(validity, "TRIREME", &revokedSecrets{PKISecrets: s})
+			So(err, ShouldBeNil)
+
+			_, _, _, err = revokedConfig.Decode(false, token, nil)
+			So(err, ShouldNotBeNil)
+			So(crypto.IsRevoked(err), ShouldBeTrue)
+		})
+	})
+}
+
+func TestDecodePinnedKeyChanged(t *testing.T) {
+
+	Convey("Given secrets that pin the keys of the peers", t, func() {
+
+		ca := testpki.NewAuthority("ca")
+
+		s, _ := pkiSecrets(ca)
+		s.EnablePinning()
+		jwtConfig, err := NewJWT(validity, "receiver", s)
+		So(err, ShouldBeNil)
+
+		peer, _ := pkiSecrets(ca)
+		peerConfig, err := NewJWT(validity, "server", peer)
+		So(err, ShouldBeNil)
+
+		nonce := []byte("1234567890123456")
+		token, err := peerConfig.CreateAndSign(false, &defaultClaims, nonce)
+		So(err, ShouldBeNil)
+
+		_, _, _, err = jwtConfig.Decode(false, token, nil)
+		So(err, ShouldBeNil)
+
+		Convey("Then a token of the same server signed with another key should be rejected", func() {
+			other, _ := pkiSecrets(ca)
+			otherConfig, err := NewJWT(validity, "server", other)
+			So(err, ShouldBeNil)
+
+			token, err := otherConfig.CreateAndSign(false, &defaultClaims, nonce)
+			So(err, ShouldBeNil)
+
+			_, _, _, err = jwtConfig.Decode(false, token, nil)
+			So(err, ShouldNotBeNil)
+			So(secrets.IsPinnedKeyChanged(err), ShouldBeTrue)
+		})
+	})
+}
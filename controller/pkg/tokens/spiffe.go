@@ -0,0 +1,45 @@
+package tokens
+
+import (
+	"crypto/x509"
+
+	"github.com/aporeto-inc/trireme-lib/controller/pkg/secrets"
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// setSpiffeClaims replaces the reserved SPIFFE tags of the claims with the
+// SPIFFE ID of the verified certificate of the peer, so that a peer can not
+// claim an identity that is not in its SVID.
+func setSpiffeClaims(claims *ConnectionClaims, cert interface{}) {
+
+	if claims == nil {
+		return
+	}
+
+	if claims.T != nil {
+		tags := make([]string, 0, len(claims.T.Tags)+2)
+		for _, tag := range claims.T.Tags {
+			if !policy.IsSpiffeTag(tag) {
+				tags = append(tags, tag)
+			}
+		}
+		claims.T.Tags = tags
+	}
+
+	x509Cert, ok := cert.(*x509.Certificate)
+	if !ok {
+		return
+	}
+
+	id, trustDomain, err := secrets.SpiffeID(x509Cert)
+	if err != nil {
+		return
+	}
+
+	if claims.T == nil {
+		claims.T = policy.NewTagStore()
+	}
+
+	claims.T.AppendKeyValue(policy.SpiffeIDKey, id)
+	claims.T.AppendKeyValue(policy.SpiffeTrustDomainKey, trustDomain)
+}
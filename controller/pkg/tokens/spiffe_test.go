@@ -0,0 +1,45 @@
+package tokens
+
+import (
+	"crypto/x509"
+	"net/url"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSetSpiffeClaims(t *testing.T) {
+
+	Convey("Given claims with forged SPIFFE tags", t, func() {
+
+		claims := &ConnectionClaims{
+			T: policy.NewTagStoreFromSlice([]string{
+				"app=web",
+				policy.SpiffeIDKey + "=spiffe://example.org/admin",
+				policy.SpiffeTrustDomainKey + "=example.org",
+			}),
+		}
+
+		Convey("When the peer has no SVID", func() {
+			setSpiffeClaims(claims, nil)
+
+			Convey("Then the forged tags should be removed", func() {
+				So(claims.T.Tags, ShouldResemble, []string{"app=web"})
+			})
+		})
+
+		Convey("When the peer has a verified SVID", func() {
+			u, _ := url.Parse("spiffe://partner.com/billing")
+			setSpiffeClaims(claims, &x509.Certificate{URIs: []*url.URL{u}})
+
+			Convey("Then the tags should carry its SPIFFE ID", func() {
+				So(claims.T.Tags, ShouldResemble, []string{
+					"app=web",
+					policy.SpiffeIDKey + "=spiffe://partner.com/billing",
+					policy.SpiffeTrustDomainKey + "=partner.com",
+				})
+			})
+		})
+	})
+}
@@ -11,6 +11,8 @@ type ConnectionClaims struct {
 	LCL []byte
 	// EK is the ephemeral EC key for encryption
 	EK []byte
+	// ET is the encryption of T when the claims are encrypted
+	ET []byte `json:",omitempty"`
 }
 
 // TokenEngine is the interface to the different implementations of tokens
@@ -35,3 +37,13 @@ const (
 	// NonceLength is the length of the Nonce to be used in the secrets
 	NonceLength = 16
 )
+
+// TokenFormat is the wire format of the tokens
+type TokenFormat int
+
+const (
+	// JWTFormat encodes the tokens as JWTs
+	JWTFormat TokenFormat = iota
+	// BinaryFormat encodes the tokens with the compact binary encoding
+	BinaryFormat
+)
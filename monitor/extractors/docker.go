@@ -35,6 +35,10 @@ func DefaultMetadataExtractor(info *types.ContainerJSON) (*policy.PURuntime, err
 		"bridge": info.NetworkSettings.IPAddress,
 	}
 
+	if info.NetworkSettings.GlobalIPv6Address != "" {
+		ipa[policy.DefaultNamespaceIPv6] = info.NetworkSettings.GlobalIPv6Address
+	}
+
 	if info.HostConfig.NetworkMode == constants.DockerHostMode {
 		return policy.NewPURuntime(info.Name, info.State.Pid, "", tags, ipa, common.LinuxProcessPU, hostModeOptions(info)), nil
 	}
@@ -0,0 +1,227 @@
+package policy
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConflictMode defines how the conflicts between the rules of a policy that
+// match the same flow are resolved.
+type ConflictMode int
+
+const (
+	// DenyOverrides is the default mode. A matching reject rule overrides
+	// the accept rules and the observe apply rules are evaluated last. The
+	// rules with the same action are evaluated by decreasing priority.
+	DenyOverrides ConflictMode = iota
+	// FirstMatch evaluates the rules by decreasing priority and then in the
+	// order of the list. The first rule that matches decides the action,
+	// whatever the action is.
+	FirstMatch
+	// MostSpecific selects the most specific rule that matches. A tag
+	// selector with more clauses is more specific, and an IP rule with a
+	// longer prefix and then with fewer ports is more specific. The rules
+	// that are as specific are evaluated by priority and then by order.
+	MostSpecific
+)
+
+const (
+	conflictDenyOverrides = "deny-overrides"
+	conflictFirstMatch    = "first-match"
+	conflictMostSpecific  = "most-specific"
+)
+
+// String returns the name of the conflict mode
+func (m ConflictMode) String() string {
+	switch m {
+	case DenyOverrides:
+		return conflictDenyOverrides
+	case FirstMatch:
+		return conflictFirstMatch
+	case MostSpecific:
+		return conflictMostSpecific
+	}
+
+	return actionUnknown
+}
+
+// ParseConflictMode returns the conflict mode of a name. An empty name is
+// the default mode.
+func ParseConflictMode(name string) (ConflictMode, error) {
+
+	switch name {
+	case "", conflictDenyOverrides:
+		return DenyOverrides, nil
+	case conflictFirstMatch:
+		return FirstMatch, nil
+	case conflictMostSpecific:
+		return MostSpecific, nil
+	}
+
+	return DenyOverrides, fmt.Errorf("invalid conflict mode %s", name)
+}
+
+// RuleOrder holds the attributes of a rule that order its evaluation
+type RuleOrder struct {
+	// Index is the position of the rule in its list
+	Index int
+	// Priority is the priority of the rule
+	Priority int
+	// Specificity is the number of clauses of a tag selector or the prefix
+	// length of an IP rule
+	Specificity int
+	// Ports is the number of ports of an IP rule
+	Ports int
+	// Policy is the flow policy of the rule
+	Policy *FlowPolicy
+}
+
+// Order returns the evaluation order of the tag selector at a position of
+// its list
+func (t TagSelector) Order(index int) RuleOrder {
+	return RuleOrder{
+		Index:       index,
+		Priority:    t.Priority,
+		Specificity: len(t.Clause),
+		Policy:      t.Policy,
+	}
+}
+
+// Order returns the evaluation order of the IP rule at a position of its
+// list. An address without prefix length is a host address and a rule
+// without valid ports covers all the ports.
+func (r IPRule) Order(index int) RuleOrder {
+
+	order := RuleOrder{
+		Index:    index,
+		Priority: r.Priority,
+		Ports:    65536,
+		Policy:   r.Policy,
+	}
+
+	if _, network, err := net.ParseCIDR(r.Address); err == nil {
+		order.Specificity, _ = network.Mask.Size()
+	} else if ip := net.ParseIP(r.Address); ip != nil {
+		order.Specificity = 8 * net.IPv6len
+		if ip.To4() != nil {
+			order.Specificity = 8 * net.IPv4len
+		}
+	}
+
+	parts := strings.Split(r.Port, ":")
+	min, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return order
+	}
+	max := min
+	if len(parts) == 2 {
+		if max, err = strconv.Atoi(parts[1]); err != nil {
+			return order
+		}
+	}
+	if len(parts) <= 2 && min <= max {
+		order.Ports = max - min + 1
+	}
+
+	return order
+}
+
+// denyClass returns the rank of the action of a rule in the deny overrides
+// mode. The reject rules are first and the observe apply rules are last.
+func denyClass(p *FlowPolicy) int {
+
+	if p == nil {
+		return 1
+	}
+
+	if p.ObserveAction.ObserveApply() {
+		return 2
+	}
+
+	if p.Action.Rejected() {
+		return 0
+	}
+
+	return 1
+}
+
+// Precedes returns true if the rule a is evaluated before the rule b
+func (m ConflictMode) Precedes(a, b RuleOrder) bool {
+
+	switch m {
+	case MostSpecific:
+		if a.Specificity != b.Specificity {
+			return a.Specificity > b.Specificity
+		}
+		if a.Ports != b.Ports {
+			return a.Ports < b.Ports
+		}
+
+	case DenyOverrides:
+		if ca, cb := denyClass(a.Policy), denyClass(b.Policy); ca != cb {
+			return ca < cb
+		}
+	}
+
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+
+	// The rules of the same action and priority are evaluated from the most
+	// specific, as the longest prefix match of the ACLs
+	if m == DenyOverrides && a.Specificity != b.Specificity {
+		return a.Specificity > b.Specificity
+	}
+
+	return a.Index < b.Index
+}
+
+// Ordered returns true if the evaluation of the rules depends on their
+// order. Without priorities, the deny overrides mode only depends on the
+// actions of the rules.
+func (m ConflictMode) Ordered(priorities bool) bool {
+	return m != DenyOverrides || priorities
+}
+
+// HasPriorities returns true if a rule of the list has a priority
+func (t TagSelectorList) HasPriorities() bool {
+	for _, rule := range t {
+		if rule.Priority != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPriorities returns true if a rule of the list has a priority
+func (l IPRuleList) HasPriorities() bool {
+	for _, rule := range l {
+		if rule.Priority != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Sort returns a copy of the list in the order of evaluation of the mode
+func (l IPRuleList) Sort(mode ConflictMode) IPRuleList {
+
+	orders := make([]RuleOrder, len(l))
+	for i, rule := range l {
+		orders[i] = rule.Order(i)
+	}
+
+	sort.SliceStable(orders, func(i, j int) bool {
+		return mode.Precedes(orders[i], orders[j])
+	})
+
+	list := make(IPRuleList, len(l))
+	for i, order := range orders {
+		list[i] = l[order.Index]
+	}
+
+	return list
+}
@@ -0,0 +1,99 @@
+package policy
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseConflictMode(t *testing.T) {
+
+	Convey("When I parse the names of the conflict modes", t, func() {
+
+		Convey("Then the names should give the modes", func() {
+			for _, mode := range []ConflictMode{DenyOverrides, FirstMatch, MostSpecific} {
+				parsed, err := ParseConflictMode(mode.String())
+				So(err, ShouldBeNil)
+				So(parsed, ShouldEqual, mode)
+			}
+
+			mode, err := ParseConflictMode("")
+			So(err, ShouldBeNil)
+			So(mode, ShouldEqual, DenyOverrides)
+		})
+
+		Convey("Then an unknown name should be rejected", func() {
+			_, err := ParseConflictMode("last-match")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestIPRuleOrder(t *testing.T) {
+
+	Convey("When I get the order of IP rules", t, func() {
+
+		Convey("Then the prefix length and the ports should be computed", func() {
+			order := IPRule{Address: "10.1.0.0/16", Port: "80:89", Priority: 3}.Order(2)
+			So(order.Index, ShouldEqual, 2)
+			So(order.Priority, ShouldEqual, 3)
+			So(order.Specificity, ShouldEqual, 16)
+			So(order.Ports, ShouldEqual, 10)
+
+			order = IPRule{Address: "10.1.1.1", Port: "80"}.Order(0)
+			So(order.Specificity, ShouldEqual, 32)
+			So(order.Ports, ShouldEqual, 1)
+
+			order = IPRule{Address: "2001:db8::1", Port: "any"}.Order(0)
+			So(order.Specificity, ShouldEqual, 128)
+			So(order.Ports, ShouldEqual, 65536)
+		})
+	})
+}
+
+func TestSortIPRules(t *testing.T) {
+
+	accept := &FlowPolicy{Action: Accept}
+	reject := &FlowPolicy{Action: Reject}
+	apply := &FlowPolicy{Action: Accept, ObserveAction: ObserveApply}
+
+	rules := IPRuleList{
+		{Address: "10.0.0.0/8", Port: "80", Policy: apply},
+		{Address: "10.0.0.0/8", Port: "1:1024", Policy: accept, Priority: 1},
+		{Address: "10.1.0.0/16", Port: "80", Policy: reject},
+		{Address: "10.0.0.0/8", Port: "80", Policy: accept, Priority: 1},
+	}
+
+	Convey("When I sort IP rules", t, func() {
+
+		order := func(sorted IPRuleList) []int {
+			indexes := []int{}
+			for _, rule := range sorted {
+				for i := range rules {
+					if rules[i].Policy == rule.Policy && rules[i].Address == rule.Address && rules[i].Port == rule.Port {
+						indexes = append(indexes, i)
+					}
+				}
+			}
+			return indexes
+		}
+
+		Convey("Then the deny overrides mode should order the rules by action and then by priority", func() {
+			So(order(rules.Sort(DenyOverrides)), ShouldResemble, []int{2, 1, 3, 0})
+		})
+
+		Convey("Then the first match mode should order the rules by priority and then by position", func() {
+			So(order(rules.Sort(FirstMatch)), ShouldResemble, []int{1, 3, 0, 2})
+		})
+
+		Convey("Then the most specific mode should order the rules by prefix, ports and priority", func() {
+			So(order(rules.Sort(MostSpecific)), ShouldResemble, []int{2, 3, 0, 1})
+		})
+
+		Convey("Then the list should not be modified", func() {
+			So(rules[0].Policy, ShouldEqual, apply)
+			So(rules.HasPriorities(), ShouldBeTrue)
+			So(rules[:1].HasPriorities(), ShouldBeFalse)
+		})
+	})
+}
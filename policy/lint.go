@@ -0,0 +1,508 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Severity is the severity of a finding of the linter
+type Severity int
+
+const (
+	// SeverityWarning is a rule that is valid but does not do what it
+	// says, such as a shadowed rule
+	SeverityWarning Severity = iota
+	// SeverityError is a rule that cannot be enforced
+	SeverityError
+)
+
+// String returns the name of the severity
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+
+	return actionUnknown
+}
+
+// The lists of rules of a policy that the findings refer to
+const (
+	ListApplicationACLs  = "applicationACLs"
+	ListNetworkACLs      = "networkACLs"
+	ListReceiverRules    = "receiverRules"
+	ListTransmitterRules = "transmitterRules"
+)
+
+// Finding is a problem of a rule of a policy
+type Finding struct {
+	// Severity is the severity of the problem
+	Severity Severity
+	// List is the list of rules of the rule
+	List string
+	// Index is the position of the rule in its list
+	Index int
+	// Related is the position of the rule of the same list that causes the
+	// problem, or -1 if there is none
+	Related int
+	// Message explains the problem
+	Message string
+}
+
+// String returns a readable description of the finding
+func (f *Finding) String() string {
+	return fmt.Sprintf("%s: %s[%d]: %s", f.Severity, f.List, f.Index, f.Message)
+}
+
+// Findings is a list of findings
+type Findings []*Finding
+
+// HasErrors returns true if a finding is an error
+func (l Findings) HasErrors() bool {
+	for _, f := range l {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Errors returns the findings that are errors
+func (l Findings) Errors() Findings {
+
+	errs := Findings{}
+	for _, f := range l {
+		if f.Severity == SeverityError {
+			errs = append(errs, f)
+		}
+	}
+
+	return errs
+}
+
+// String returns the descriptions of the findings
+func (l Findings) String() string {
+
+	descriptions := make([]string, len(l))
+	for i, f := range l {
+		descriptions[i] = f.String()
+	}
+
+	return strings.Join(descriptions, "; ")
+}
+
+// Lint analyses the rules of a policy. The errors are the rules that cannot
+// be enforced, such as invalid addresses, ports or clauses. The warnings are
+// the rules that never decide a flow because a single rule that is evaluated
+// before them in the conflict mode of the policy matches all their flows, the
+// duplicate rules and the observe rules that are never reported. The findings
+// are sorted by list and position.
+func Lint(p *PUPolicy) Findings {
+
+	mode := p.ConflictMode()
+
+	findings := Findings{}
+	findings = append(findings, lintIPRules(ListApplicationACLs, p.ApplicationACLs(), mode)...)
+	findings = append(findings, lintIPRules(ListNetworkACLs, p.NetworkACLs(), mode)...)
+	findings = append(findings, lintTagSelectors(ListReceiverRules, p.ReceiverRules(), mode)...)
+	findings = append(findings, lintTagSelectors(ListTransmitterRules, p.TransmitterRules(), mode)...)
+
+	return findings
+}
+
+// ruleScope is the set of flows that a valid rule matches
+type ruleScope struct {
+	index  int
+	order  RuleOrder
+	policy *FlowPolicy
+	// covers returns true if the rule matches all the flows of another rule
+	covers func(other *ruleScope) bool
+	// ip rules
+	protocol string
+	network  *net.IPNet
+	min, max int
+	// tag selectors
+	clauses []KeyValueOperator
+}
+
+// terminal returns true if a matching rule decides the action of a flow
+func (s *ruleScope) terminal() bool {
+	return !s.policy.ObserveAction.ObserveContinue()
+}
+
+// lintIPRules checks the syntax of the IP rules of a list and their overlaps
+func lintIPRules(list string, rules IPRuleList, mode ConflictMode) Findings {
+
+	findings := Findings{}
+	scopes := []*ruleScope{}
+
+	for i, rule := range rules {
+		if rule.Policy == nil {
+			findings = append(findings, newFinding(SeverityError, list, i, -1, "rule without policy"))
+			continue
+		}
+
+		s, err := ipRuleScope(i, rule)
+		if err != nil {
+			findings = append(findings, newFinding(SeverityError, list, i, -1, err.Error()))
+			continue
+		}
+
+		if f := lintAction(list, i, rule.Policy); f != nil {
+			findings = append(findings, f)
+			continue
+		}
+
+		scopes = append(scopes, s)
+	}
+
+	findings = append(findings, lintOverlaps(list, scopes, func(other, s *ruleScope) bool {
+		return other.terminal() && mode.Precedes(other.order, s.order)
+	})...)
+	sortFindings(findings)
+
+	return findings
+}
+
+// ipRuleScope parses the address, the protocol and the ports of an IP rule
+// as the ACLs do. The ports are only used by tcp and udp.
+func ipRuleScope(index int, rule IPRule) (*ruleScope, error) {
+
+	s := &ruleScope{
+		index:    index,
+		order:    rule.Order(index),
+		policy:   rule.Policy,
+		protocol: strings.ToLower(rule.Protocol),
+	}
+
+	if s.protocol == "" {
+		return nil, errors.New("rule without protocol")
+	}
+
+	address := rule.Address
+	if !strings.Contains(address, "/") {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q", rule.Address)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			bits = 8 * net.IPv4len
+		}
+		address = fmt.Sprintf("%s/%d", address, bits)
+	}
+
+	_, network, err := net.ParseCIDR(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q", rule.Address)
+	}
+	s.network = network
+
+	s.min, s.max = 0, 65535
+	if s.protocol == "tcp" || s.protocol == "udp" {
+		if s.min, s.max, err = parsePortRange(rule.Port); err != nil {
+			return nil, err
+		}
+	}
+
+	s.covers = func(other *ruleScope) bool {
+		if s.protocol != other.protocol || len(s.network.IP) != len(other.network.IP) {
+			return false
+		}
+		ones, _ := s.network.Mask.Size()
+		otherOnes, _ := other.network.Mask.Size()
+		return ones <= otherOnes && s.network.Contains(other.network.IP) &&
+			s.min <= other.min && other.max <= s.max
+	}
+
+	return s, nil
+}
+
+// parsePortRange parses a port or a range of ports min:max
+func parsePortRange(port string) (min int, max int, err error) {
+
+	parts := strings.Split(port, ":")
+	if len(parts) > 2 {
+		return 0, 0, fmt.Errorf("invalid port %q", port)
+	}
+
+	if min, err = strconv.Atoi(parts[0]); err != nil || min < 0 || min > 65535 {
+		return 0, 0, fmt.Errorf("invalid port %q", port)
+	}
+
+	max = min
+	if len(parts) == 2 {
+		if max, err = strconv.Atoi(parts[1]); err != nil || max < 0 || max > 65535 {
+			return 0, 0, fmt.Errorf("invalid port %q", port)
+		}
+	}
+
+	if min > max {
+		return 0, 0, fmt.Errorf("invalid port range %q: min port is greater than max port", port)
+	}
+
+	return min, max, nil
+}
+
+// lintTagSelectors checks the clauses of the tag selectors of a list and
+// their overlaps
+func lintTagSelectors(list string, rules TagSelectorList, mode ConflictMode) Findings {
+
+	findings := Findings{}
+	scopes := []*ruleScope{}
+
+	for i, rule := range rules {
+		if rule.Policy == nil {
+			findings = append(findings, newFinding(SeverityError, list, i, -1, "rule without policy"))
+			continue
+		}
+
+		if len(rule.Clause) == 0 {
+			findings = append(findings, newFinding(SeverityWarning, list, i, -1, "rule without clauses never matches"))
+			continue
+		}
+
+		valid := true
+		for _, clause := range rule.Clause {
+			if err := clause.Validate(); err != nil {
+				findings = append(findings, newFinding(SeverityError, list, i, -1, err.Error()))
+				valid = false
+				break
+			}
+		}
+		if !valid {
+			continue
+		}
+
+		if f := lintAction(list, i, rule.Policy); f != nil {
+			findings = append(findings, f)
+			continue
+		}
+
+		scopes = append(scopes, tagSelectorScope(i, rule))
+	}
+
+	findings = append(findings, lintOverlaps(list, scopes, tagSelectorShadows(mode))...)
+	sortFindings(findings)
+
+	return findings
+}
+
+// tagSelectorShadows returns the function that tells if a tag selector
+// decides or reports the flows of another one before it. Only the first
+// matching observe rule is reported. In the deny overrides mode, the observe
+// rules of an action are searched before the rules that apply it.
+func tagSelectorShadows(mode ConflictMode) func(other, s *ruleScope) bool {
+
+	return func(other, s *ruleScope) bool {
+
+		if !other.terminal() && s.terminal() {
+			return false
+		}
+
+		if mode == DenyOverrides {
+			if co, cs := searchClass(other.policy), searchClass(s.policy); co != cs {
+				return co < cs
+			}
+		}
+
+		return mode.Precedes(other.order, s.order)
+	}
+}
+
+// searchClass returns the rank of the rules of a policy in the search of the
+// tag selectors in the deny overrides mode: the observe reject rules, the
+// reject rules, the observe accept rules, the accept rules and the observe
+// apply rules.
+func searchClass(p *FlowPolicy) int {
+
+	switch {
+	case p.ObserveAction.ObserveApply():
+		return 4
+	case p.Action.Rejected() && p.ObserveAction.ObserveContinue():
+		return 0
+	case p.Action.Rejected():
+		return 1
+	case p.ObserveAction.ObserveContinue():
+		return 2
+	default:
+		return 3
+	}
+}
+
+// tagSelectorScope returns the scope of a valid tag selector. A selector
+// matches all the flows of another one if each of its clauses is implied by
+// a clause of the other.
+func tagSelectorScope(index int, rule TagSelector) *ruleScope {
+
+	s := &ruleScope{
+		index:   index,
+		order:   rule.Order(index),
+		policy:  rule.Policy,
+		clauses: rule.Clause,
+	}
+
+	s.covers = func(other *ruleScope) bool {
+		for _, c := range s.clauses {
+			implied := false
+			for _, d := range other.clauses {
+				if clauseImplies(d, c) {
+					implied = true
+					break
+				}
+			}
+			if !implied {
+				return false
+			}
+		}
+		return true
+	}
+
+	return s
+}
+
+// clauseImplies returns true if the tags that match the clause d always match
+// the clause c. It only detects the simple cases and returns false when it
+// cannot decide.
+func clauseImplies(d, c KeyValueOperator) bool {
+
+	if c.Key != d.Key {
+		return false
+	}
+
+	if c.Operator == d.Operator && sameValues(c.Value, d.Value) {
+		return true
+	}
+
+	switch c.Operator {
+	case KeyExists:
+		return d.Operator != NotEqual && d.Operator != KeyNotExists
+
+	case Equal:
+		return d.Operator == Equal && containsValues(c.Value, d.Value)
+
+	case Prefix:
+		if d.Operator != Equal && d.Operator != Prefix {
+			return false
+		}
+		for _, v := range d.Value {
+			prefixed := false
+			for _, prefix := range c.Value {
+				if strings.HasPrefix(v, prefix) {
+					prefixed = true
+					break
+				}
+			}
+			if !prefixed {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// containsValues returns true if all the values are in the set
+func containsValues(set, values []string) bool {
+
+	m := map[string]bool{}
+	for _, v := range set {
+		m[v] = true
+	}
+
+	for _, v := range values {
+		if !m[v] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sameValues returns true if the lists have the same values
+func sameValues(a, b []string) bool {
+	return containsValues(a, b) && containsValues(b, a)
+}
+
+// lintAction checks that a rule accepts or rejects its flows
+func lintAction(list string, index int, p *FlowPolicy) *Finding {
+
+	if p.Action.Accepted() || p.Action.Rejected() {
+		return nil
+	}
+
+	return newFinding(SeverityWarning, list, index, -1, "rule neither accepts nor rejects its flows and is ignored")
+}
+
+// lintOverlaps finds the duplicate rules and the rules that are shadowed by a
+// single rule that decides or reports their flows before them
+func lintOverlaps(list string, scopes []*ruleScope, shadows func(other, s *ruleScope) bool) Findings {
+
+	findings := Findings{}
+
+	for _, s := range scopes {
+		for _, other := range scopes {
+			if other == s {
+				continue
+			}
+
+			// The duplicates are reported once, on the last of them
+			if other.index < s.index && other.covers(s) && s.covers(other) {
+				message := fmt.Sprintf("duplicate of rule %d", other.index)
+				if !sameAction(s.policy, other.policy) {
+					message = fmt.Sprintf("duplicate of rule %d with a different action", other.index)
+				}
+				findings = append(findings, newFinding(SeverityWarning, list, s.index, other.index, message))
+				break
+			}
+
+			if !shadows(other, s) || !other.covers(s) || s.covers(other) {
+				continue
+			}
+
+			var message string
+			switch {
+			case !s.terminal():
+				message = fmt.Sprintf("observe rule is never reported because rule %d matches its flows first", other.index)
+			case sameAction(s.policy, other.policy):
+				message = fmt.Sprintf("rule is shadowed by rule %d with the same action", other.index)
+			default:
+				message = fmt.Sprintf("rule is shadowed by rule %d with the %s action", other.index, other.policy.Action.ActionString())
+			}
+			findings = append(findings, newFinding(SeverityWarning, list, s.index, other.index, message))
+			break
+		}
+	}
+
+	return findings
+}
+
+// sameAction returns true if the policies apply the same action
+func sameAction(a, b *FlowPolicy) bool {
+	return a.Action.ActionString() == b.Action.ActionString()
+}
+
+// newFinding creates a finding
+func newFinding(severity Severity, list string, index, related int, message string) *Finding {
+	return &Finding{
+		Severity: severity,
+		List:     list,
+		Index:    index,
+		Related:  related,
+		Message:  message,
+	}
+}
+
+// sortFindings sorts the findings of a list by position
+func sortFindings(findings Findings) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Index < findings[j].Index
+	})
+}
@@ -0,0 +1,287 @@
+package policy
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// lintPolicy returns a policy with the ACLs and the receiver rules
+func lintPolicy(acls IPRuleList, rules TagSelectorList, mode ConflictMode) *PUPolicy {
+
+	p := NewPUPolicy("id", Police, acls, nil, nil, rules, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	p.SetConflictMode(mode)
+
+	return p
+}
+
+// lintACL returns a tcp ACL
+func lintACL(address, port string, action ActionType, observe ObserveActionType) IPRule {
+	return IPRule{
+		Address:  address,
+		Port:     port,
+		Protocol: "tcp",
+		Policy:   &FlowPolicy{Action: action, ObserveAction: observe},
+	}
+}
+
+// lintSelector returns a tag selector with one clause per tag
+func lintSelector(action ActionType, observe ObserveActionType, tags ...string) TagSelector {
+
+	clauses := []KeyValueOperator{}
+	for i := 0; i+1 < len(tags); i += 2 {
+		clauses = append(clauses, KeyValueOperator{Key: tags[i], Value: []string{tags[i+1]}, Operator: Equal})
+	}
+
+	return TagSelector{
+		Clause: clauses,
+		Policy: &FlowPolicy{Action: action, ObserveAction: observe},
+	}
+}
+
+func TestLint(t *testing.T) {
+
+	Convey("Given a policy", t, func() {
+
+		Convey("When the rules are valid and independent", func() {
+			findings := Lint(lintPolicy(
+				IPRuleList{
+					lintACL("10.1.0.0/16", "80", Accept, ObserveNone),
+					lintACL("10.2.0.1", "443", Reject, ObserveNone),
+					{Address: "10.3.0.0/16", Protocol: "icmp", Policy: &FlowPolicy{Action: Accept}},
+				},
+				TagSelectorList{
+					lintSelector(Accept, ObserveNone, "app", "web"),
+					lintSelector(Reject, ObserveNone, "app", "db"),
+				},
+				DenyOverrides,
+			))
+
+			Convey("Then there should be no findings", func() {
+				So(findings, ShouldBeEmpty)
+				So(findings.HasErrors(), ShouldBeFalse)
+			})
+		})
+
+		Convey("When the ACLs have invalid addresses, ports and protocols", func() {
+			findings := Lint(lintPolicy(
+				IPRuleList{
+					lintACL("10.1.0.0/33", "80", Accept, ObserveNone),
+					lintACL("10.1.0", "80", Accept, ObserveNone),
+					lintACL("10.1.0.0/16", "http", Accept, ObserveNone),
+					lintACL("10.1.0.0/16", "90:80", Accept, ObserveNone),
+					lintACL("10.1.0.0/16", "80:90:100", Accept, ObserveNone),
+					lintACL("10.1.0.0/16", "70000", Accept, ObserveNone),
+					{Address: "10.1.0.0/16", Port: "80", Policy: &FlowPolicy{Action: Accept}},
+					{Address: "10.1.0.0/16", Port: "80", Protocol: "tcp"},
+				},
+				nil,
+				DenyOverrides,
+			))
+
+			Convey("Then each rule should have an error", func() {
+				So(findings.HasErrors(), ShouldBeTrue)
+				So(len(findings), ShouldEqual, 8)
+				for i, f := range findings {
+					So(f.Severity, ShouldEqual, SeverityError)
+					So(f.List, ShouldEqual, ListApplicationACLs)
+					So(f.Index, ShouldEqual, i)
+					So(f.Related, ShouldEqual, -1)
+				}
+				So(len(findings.Errors()), ShouldEqual, 8)
+				So(findings[0].String(), ShouldEqual, `error: applicationACLs[0]: invalid address "10.1.0.0/33"`)
+			})
+		})
+
+		Convey("When a reject is shadowed by a broader accept in the first match mode", func() {
+			findings := Lint(lintPolicy(
+				IPRuleList{
+					lintACL("10.0.0.0/8", "1:1024", Accept, ObserveNone),
+					lintACL("10.1.0.0/16", "80", Reject, ObserveNone),
+					lintACL("10.1.0.0/16", "8080", Reject, ObserveNone),
+				},
+				nil,
+				FirstMatch,
+			))
+
+			Convey("Then the reject should have a warning", func() {
+				So(findings.HasErrors(), ShouldBeFalse)
+				So(len(findings), ShouldEqual, 1)
+				So(findings[0].Severity, ShouldEqual, SeverityWarning)
+				So(findings[0].Index, ShouldEqual, 1)
+				So(findings[0].Related, ShouldEqual, 0)
+				So(findings[0].Message, ShouldEqual, "rule is shadowed by rule 0 with the accept action")
+			})
+		})
+
+		Convey("When the same rules are in the deny overrides mode", func() {
+			findings := Lint(lintPolicy(
+				IPRuleList{
+					lintACL("10.0.0.0/8", "1:1024", Accept, ObserveNone),
+					lintACL("10.1.0.0/16", "80", Reject, ObserveNone),
+					lintACL("10.0.0.0/8", "80:90", Accept, ObserveNone),
+				},
+				nil,
+				DenyOverrides,
+			))
+
+			Convey("Then only the accept with fewer ports should be shadowed", func() {
+				So(len(findings), ShouldEqual, 1)
+				So(findings[0].Index, ShouldEqual, 2)
+				So(findings[0].Related, ShouldEqual, 0)
+				So(findings[0].Message, ShouldEqual, "rule is shadowed by rule 0 with the same action")
+			})
+		})
+
+		Convey("When the most specific rule wins", func() {
+			findings := Lint(lintPolicy(
+				IPRuleList{
+					lintACL("10.0.0.0/8", "80", Accept, ObserveNone),
+					lintACL("10.1.0.0/16", "80", Reject, ObserveNone),
+				},
+				nil,
+				MostSpecific,
+			))
+
+			Convey("Then the narrower rule should not be shadowed", func() {
+				So(findings, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When an observe rule is covered by a rule evaluated before it", func() {
+			findings := Lint(lintPolicy(
+				IPRuleList{
+					lintACL("10.0.0.0/8", "80", Reject, ObserveNone),
+					lintACL("10.1.0.0/16", "80", Accept, ObserveContinue),
+					lintACL("10.2.0.0/16", "80", Accept, ObserveApply),
+					lintACL("192.168.0.0/16", "80", Accept, ObserveContinue),
+				},
+				nil,
+				DenyOverrides,
+			))
+
+			Convey("Then the observe rules should never be reported", func() {
+				So(len(findings), ShouldEqual, 2)
+				So(findings[0].Index, ShouldEqual, 1)
+				So(findings[0].Message, ShouldEqual, "observe rule is never reported because rule 0 matches its flows first")
+				So(findings[1].Index, ShouldEqual, 2)
+				So(findings[1].Related, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the selectors are duplicated or shadowed", func() {
+			findings := Lint(lintPolicy(
+				nil,
+				TagSelectorList{
+					lintSelector(Accept, ObserveNone, "app", "web", "env", "prod"),
+					lintSelector(Reject, ObserveNone, "env", "prod", "app", "web"),
+					lintSelector(Reject, ObserveNone, "app", "web"),
+					lintSelector(Accept, ObserveNone, "app", "web", "env", "prod", "zone", "a"),
+				},
+				DenyOverrides,
+			))
+
+			Convey("Then the duplicate and the shadowed selectors should have warnings", func() {
+				So(findings.HasErrors(), ShouldBeFalse)
+				So(len(findings), ShouldEqual, 3)
+				So(findings[0].List, ShouldEqual, ListReceiverRules)
+				So(findings[0].Index, ShouldEqual, 0)
+				So(findings[0].Related, ShouldEqual, 2)
+				So(findings[1].Index, ShouldEqual, 1)
+				So(findings[1].Message, ShouldEqual, "duplicate of rule 0 with a different action")
+				So(findings[2].Index, ShouldEqual, 3)
+				So(findings[2].Related, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When observe selectors are covered by selectors of the same action", func() {
+			rules := TagSelectorList{
+				lintSelector(Reject, ObserveNone, "app", "web"),
+				lintSelector(Reject, ObserveContinue, "app", "web", "env", "prod"),
+				lintSelector(Accept, ObserveNone, "app", "db"),
+				lintSelector(Accept, ObserveContinue, "app", "db", "env", "prod"),
+			}
+
+			Convey("Then they should be reported in the deny overrides mode", func() {
+				So(Lint(lintPolicy(nil, rules, DenyOverrides)), ShouldBeEmpty)
+			})
+
+			Convey("Then they should never be reported in the first match mode", func() {
+				findings := Lint(lintPolicy(nil, rules, FirstMatch))
+				So(len(findings), ShouldEqual, 2)
+				So(findings[0].Index, ShouldEqual, 1)
+				So(findings[0].Message, ShouldEqual, "observe rule is never reported because rule 0 matches its flows first")
+				So(findings[1].Index, ShouldEqual, 3)
+				So(findings[1].Related, ShouldEqual, 2)
+			})
+		})
+
+		Convey("When observe accept selectors are covered by reject selectors in the deny overrides mode", func() {
+			findings := Lint(lintPolicy(
+				nil,
+				TagSelectorList{
+					lintSelector(Accept, ObserveContinue, "app", "web", "env", "prod"),
+					lintSelector(Reject, ObserveContinue, "app", "web"),
+					lintSelector(Accept, ObserveContinue, "app", "db", "env", "prod"),
+					lintSelector(Reject, ObserveNone, "app", "db"),
+				},
+				DenyOverrides,
+			))
+
+			Convey("Then they should never be reported", func() {
+				So(len(findings), ShouldEqual, 2)
+				So(findings[0].Index, ShouldEqual, 0)
+				So(findings[0].Related, ShouldEqual, 1)
+				So(findings[1].Index, ShouldEqual, 2)
+				So(findings[1].Related, ShouldEqual, 3)
+			})
+		})
+
+		Convey("When the selectors have invalid clauses or actions", func() {
+			findings := Lint(lintPolicy(
+				nil,
+				TagSelectorList{
+					{Clause: []KeyValueOperator{{Key: "app", Value: []string{"("}, Operator: Regex}}, Policy: &FlowPolicy{Action: Accept}},
+					{Policy: &FlowPolicy{Action: Accept}},
+					lintSelector(Log, ObserveNone, "app", "web"),
+					{Clause: []KeyValueOperator{{Key: "app", Operator: KeyExists}}},
+				},
+				DenyOverrides,
+			))
+
+			Convey("Then the findings should have the right severities", func() {
+				So(len(findings), ShouldEqual, 4)
+				So(findings[0].Severity, ShouldEqual, SeverityError)
+				So(findings[1].Severity, ShouldEqual, SeverityWarning)
+				So(findings[2].Severity, ShouldEqual, SeverityWarning)
+				So(findings[3].Severity, ShouldEqual, SeverityError)
+			})
+		})
+	})
+}
+
+func TestClauseImplies(t *testing.T) {
+
+	Convey("When I compare clauses", t, func() {
+
+		equal := KeyValueOperator{Key: "app", Value: []string{"web"}, Operator: Equal}
+		equals := KeyValueOperator{Key: "app", Value: []string{"web", "db"}, Operator: Equal}
+		exists := KeyValueOperator{Key: "app", Operator: KeyExists}
+		prefix := KeyValueOperator{Key: "app", Value: []string{"we"}, Operator: Prefix}
+		other := KeyValueOperator{Key: "env", Value: []string{"web"}, Operator: Equal}
+
+		Convey("Then the narrower clauses should imply the broader ones", func() {
+			So(clauseImplies(equal, equals), ShouldBeTrue)
+			So(clauseImplies(equal, exists), ShouldBeTrue)
+			So(clauseImplies(equal, prefix), ShouldBeTrue)
+			So(clauseImplies(prefix, exists), ShouldBeTrue)
+		})
+
+		Convey("Then the broader clauses should not imply the narrower ones", func() {
+			So(clauseImplies(equals, equal), ShouldBeFalse)
+			So(clauseImplies(exists, equal), ShouldBeFalse)
+			So(clauseImplies(equals, prefix), ShouldBeFalse)
+			So(clauseImplies(other, equal), ShouldBeFalse)
+		})
+	})
+}
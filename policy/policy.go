@@ -46,6 +46,8 @@ type PUPolicy struct {
 	servicesCA string
 	// scopes are the processing unit granted scopes
 	scopes []string
+	// conflictMode is how the conflicts between the rules are resolved
+	conflictMode ConflictMode
 
 	sync.Mutex
 }
@@ -165,6 +167,8 @@ func (p *PUPolicy) Clone() *PUPolicy {
 		p.scopes,
 	)
 
+	np.conflictMode = p.conflictMode
+
 	return np
 }
 
@@ -366,6 +370,22 @@ func (p *PUPolicy) Scopes() []string {
 	return p.scopes
 }
 
+// ConflictMode returns the mode that resolves the conflicts between the rules
+func (p *PUPolicy) ConflictMode() ConflictMode {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.conflictMode
+}
+
+// SetConflictMode sets the mode that resolves the conflicts between the rules
+func (p *PUPolicy) SetConflictMode(mode ConflictMode) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.conflictMode = mode
+}
+
 // ToPublicPolicy converts the object to a marshallable object.
 func (p *PUPolicy) ToPublicPolicy() *PUPolicyPublic {
 	p.Lock()
@@ -390,6 +410,7 @@ func (p *PUPolicy) ToPublicPolicy() *PUPolicyPublic {
 		ServicesCA:          p.servicesCA,
 		ServicesCertificate: p.servicesCertificate,
 		ServicesPrivateKey:  p.servicesPrivateKey,
+		ConflictMode:        p.conflictMode,
 	}
 }
 
@@ -414,6 +435,7 @@ type PUPolicyPublic struct {
 	ServicesPrivateKey  string                  `json:"servicesPrivateKey,omitempty"`
 	ServicesCA          string                  `json:"servicesCA,omitempty"`
 	Scopes              []string                `json:"scopes,omitempty"`
+	ConflictMode        ConflictMode            `json:"conflictMode,omitempty"`
 }
 
 // ToPrivatePolicy converts the object to a private object.
@@ -437,5 +459,6 @@ func (p *PUPolicyPublic) ToPrivatePolicy() *PUPolicy {
 		servicesCA:          p.ServicesCA,
 		servicesCertificate: p.ServicesCertificate,
 		servicesPrivateKey:  p.ServicesPrivateKey,
+		conflictMode:        p.ConflictMode,
 	}
 }
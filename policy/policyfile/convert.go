@@ -0,0 +1,457 @@
+package policyfile
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/aporeto-inc/trireme-lib/common"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/portspec"
+)
+
+// Actions of the policies
+const (
+	actionPolice   = "police"
+	actionAllowAll = "allowall"
+)
+
+// Actions of the flows
+const (
+	flowAccept = "accept"
+	flowReject = "reject"
+
+	observeContinue = "continue"
+	observeApply    = "apply"
+)
+
+// Types of the services
+const (
+	serviceL3   = "l3"
+	serviceTCP  = "tcp"
+	serviceHTTP = "http"
+)
+
+// Validate validates the policy
+func (p *Policy) Validate() error {
+
+	for _, tag := range p.Match {
+		if _, _, err := splitTag(tag); err != nil {
+			return fmt.Errorf("policy %s: match: %s", p.Name, err)
+		}
+	}
+
+	_, err := p.PUPolicy("", policy.NewPURuntimeWithDefaults())
+
+	return err
+}
+
+// PUPolicy returns the PUPolicy of the processing unit with the runtime.
+// The identity of the processing unit is the tags of its runtime and the
+// identity tags of the policy whose keys are not tags of the runtime.
+func (p *Policy) PUPolicy(puID string, runtime policy.RuntimeReader) (*policy.PUPolicy, error) {
+
+	puPolicy, err := p.puPolicy(puID, runtime)
+	if err != nil {
+		return nil, fmt.Errorf("policy %s: %s", p.Name, err)
+	}
+
+	return puPolicy, nil
+}
+
+// puPolicy converts the fields of the policy
+func (p *Policy) puPolicy(puID string, runtime policy.RuntimeReader) (*policy.PUPolicy, error) {
+
+	action, err := puAction(p.Action)
+	if err != nil {
+		return nil, err
+	}
+
+	mode, err := policy.ParseConflictMode(p.ConflictMode)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := tagStore(p.Identity)
+	if err != nil {
+		return nil, fmt.Errorf("identity: %s", err)
+	}
+
+	annotations, err := tagStore(p.Annotations)
+	if err != nil {
+		return nil, fmt.Errorf("annotations: %s", err)
+	}
+
+	txtags, err := tagSelectors(p.TransmitterRules)
+	if err != nil {
+		return nil, fmt.Errorf("transmitter rules: %s", err)
+	}
+
+	rxtags, err := tagSelectors(p.ReceiverRules)
+	if err != nil {
+		return nil, fmt.Errorf("receiver rules: %s", err)
+	}
+
+	appACLs, err := ipRules(p.ApplicationACLs)
+	if err != nil {
+		return nil, fmt.Errorf("application acls: %s", err)
+	}
+
+	netACLs, err := ipRules(p.NetworkACLs)
+	if err != nil {
+		return nil, fmt.Errorf("network acls: %s", err)
+	}
+
+	if err := validateNetworks(p.TriremeNetworks); err != nil {
+		return nil, fmt.Errorf("trireme networks: %s", err)
+	}
+
+	if err := validateNetworks(p.ExcludedNetworks); err != nil {
+		return nil, fmt.Errorf("excluded networks: %s", err)
+	}
+
+	exposed, err := applicationServices(p.ExposedServices)
+	if err != nil {
+		return nil, fmt.Errorf("exposed services: %s", err)
+	}
+
+	dependent, err := applicationServices(p.DependentServices)
+	if err != nil {
+		return nil, fmt.Errorf("dependent services: %s", err)
+	}
+
+	tags := runtime.Tags()
+	tags.Merge(identity)
+
+	plc := policy.NewPUPolicy(
+		puID,
+		action,
+		appACLs,
+		netACLs,
+		txtags,
+		rxtags,
+		tags,
+		annotations,
+		runtime.IPAddresses(),
+		append([]string{}, p.TriremeNetworks...),
+		append([]string{}, p.ExcludedNetworks...),
+		nil,
+		exposed,
+		dependent,
+		append([]string{}, p.Scopes...),
+	)
+
+	plc.SetConflictMode(mode)
+
+	return plc, nil
+}
+
+// puAction converts the action of a policy
+func puAction(action string) (policy.PUAction, error) {
+
+	switch strings.ToLower(action) {
+	case "", actionPolice:
+		return policy.Police, nil
+	case actionAllowAll:
+		return policy.AllowAll, nil
+	default:
+		return 0, fmt.Errorf("invalid action %s", action)
+	}
+}
+
+// tagStore converts key=value tags
+func tagStore(tags []string) (*policy.TagStore, error) {
+
+	store := policy.NewTagStore()
+	for _, tag := range tags {
+		key, value, err := splitTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		store.AppendKeyValue(key, value)
+	}
+
+	return store, nil
+}
+
+// flowPolicy converts the flow policy of a rule
+func flowPolicy(f *Flow) (*policy.FlowPolicy, error) {
+
+	p := &policy.FlowPolicy{
+		PolicyID:  f.PolicyID,
+		ServiceID: f.ServiceID,
+	}
+
+	switch strings.ToLower(f.Action) {
+	case flowAccept:
+		p.Action = policy.Accept
+	case flowReject:
+		p.Action = policy.Reject
+	default:
+		return nil, fmt.Errorf("invalid flow action %q", f.Action)
+	}
+
+	if f.Log {
+		p.Action |= policy.Log
+	}
+
+	if f.Encrypt {
+		p.Action |= policy.Encrypt
+	}
+
+	switch strings.ToLower(f.Observe) {
+	case "":
+	case observeContinue:
+		p.Action |= policy.Observe
+		p.ObserveAction = policy.ObserveContinue
+	case observeApply:
+		p.Action |= policy.Observe
+		p.ObserveAction = policy.ObserveApply
+	default:
+		return nil, fmt.Errorf("invalid observe action %q", f.Observe)
+	}
+
+	return p, nil
+}
+
+// tagSelectors converts the tag selectors of a policy
+func tagSelectors(selectors []*TagSelector) (policy.TagSelectorList, error) {
+
+	list := policy.TagSelectorList{}
+
+	for i, s := range selectors {
+		if s == nil || len(s.Clause) == 0 {
+			return nil, fmt.Errorf("rule %d: no clause", i)
+		}
+
+		clauses := make([]policy.KeyValueOperator, 0, len(s.Clause))
+		for _, c := range s.Clause {
+			kvo, err := keyValueOperator(c)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %s", i, err)
+			}
+			clauses = append(clauses, kvo)
+		}
+
+		flow, err := flowPolicy(&s.Flow)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %s", i, err)
+		}
+
+		list = append(list, policy.TagSelector{
+			Clause:   clauses,
+			Policy:   flow,
+			Priority: s.Priority,
+		})
+	}
+
+	return list, nil
+}
+
+// keyValueOperator converts a clause of a tag selector
+func keyValueOperator(c *Clause) (policy.KeyValueOperator, error) {
+
+	if c == nil {
+		return policy.KeyValueOperator{}, errors.New("empty clause")
+	}
+
+	kvo := policy.KeyValueOperator{
+		Key:      c.Key,
+		Value:    append([]string{}, c.Values...),
+		Operator: policy.Operator(c.Operator),
+	}
+
+	if err := kvo.Validate(); err != nil {
+		return policy.KeyValueOperator{}, err
+	}
+
+	return kvo, nil
+}
+
+// ipRules converts the ACLs of a policy
+func ipRules(acls []*ACL) (policy.IPRuleList, error) {
+
+	list := policy.IPRuleList{}
+
+	for i, a := range acls {
+		if a == nil {
+			return nil, fmt.Errorf("acl %d: empty acl", i)
+		}
+
+		if err := validateAddress(a.Address); err != nil {
+			return nil, fmt.Errorf("acl %d: %s", i, err)
+		}
+
+		if _, err := portspec.NewPortSpecFromString(a.Port, nil); err != nil {
+			return nil, fmt.Errorf("acl %d: invalid port %q: %s", i, a.Port, err)
+		}
+
+		protocol := strings.ToLower(a.Protocol)
+		if protocol != "tcp" && protocol != "udp" {
+			return nil, fmt.Errorf("acl %d: invalid protocol %q", i, a.Protocol)
+		}
+
+		flow, err := flowPolicy(&a.Flow)
+		if err != nil {
+			return nil, fmt.Errorf("acl %d: %s", i, err)
+		}
+
+		list = append(list, policy.IPRule{
+			Address:  a.Address,
+			Port:     a.Port,
+			Protocol: protocol,
+			Policy:   flow,
+			Priority: a.Priority,
+		})
+	}
+
+	return list, nil
+}
+
+// validateAddress validates an address in CIDR notation or an IP address
+func validateAddress(address string) error {
+
+	if strings.Contains(address, "/") {
+		if _, _, err := net.ParseCIDR(address); err != nil {
+			return fmt.Errorf("invalid address %q", address)
+		}
+		return nil
+	}
+
+	if net.ParseIP(address) == nil {
+		return fmt.Errorf("invalid address %q", address)
+	}
+
+	return nil
+}
+
+// validateNetworks validates networks in CIDR notation
+func validateNetworks(networks []string) error {
+
+	for _, network := range networks {
+		if _, _, err := net.ParseCIDR(network); err != nil {
+			return fmt.Errorf("invalid network %q", network)
+		}
+	}
+
+	return nil
+}
+
+// applicationServices converts the services of a policy
+func applicationServices(services []*Service) (policy.ApplicationServicesList, error) {
+
+	list := policy.ApplicationServicesList{}
+
+	for i, s := range services {
+		if s == nil {
+			return nil, fmt.Errorf("service %d: empty service", i)
+		}
+
+		service, err := applicationService(s)
+		if err != nil {
+			return nil, fmt.Errorf("service %d: %s", i, err)
+		}
+
+		list = append(list, service)
+	}
+
+	return list, nil
+}
+
+// applicationService converts a service
+func applicationService(s *Service) (*policy.ApplicationService, error) {
+
+	var serviceType policy.ServiceType
+	switch strings.ToLower(s.Type) {
+	case "", serviceL3:
+		serviceType = policy.ServiceL3
+	case serviceTCP:
+		serviceType = policy.ServiceTCP
+	case serviceHTTP:
+		serviceType = policy.ServiceHTTP
+	default:
+		return nil, fmt.Errorf("invalid type %q", s.Type)
+	}
+
+	if len(s.HTTPRules) > 0 && serviceType != policy.ServiceHTTP {
+		return nil, fmt.Errorf("http rules of a %s service", s.Type)
+	}
+
+	ports, err := portspec.NewPortSpecFromString(s.Ports, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ports %q: %s", s.Ports, err)
+	}
+
+	protocol, err := protocolNumber(s.Protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]*net.IPNet, 0, len(s.Addresses))
+	for _, address := range s.Addresses {
+		_, network, err := net.ParseCIDR(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q", address)
+		}
+		addresses = append(addresses, network)
+	}
+
+	tags, err := tagStore(s.Tags)
+	if err != nil {
+		return nil, fmt.Errorf("tags: %s", err)
+	}
+
+	rules := make([]*policy.HTTPRule, 0, len(s.HTTPRules))
+	for j, r := range s.HTTPRules {
+		if r == nil || len(r.URIs) == 0 || len(r.Methods) == 0 {
+			return nil, fmt.Errorf("http rule %d: uris and methods are required", j)
+		}
+		rules = append(rules, &policy.HTTPRule{
+			URIs:    append([]string{}, r.URIs...),
+			Methods: append([]string{}, r.Methods...),
+			Scopes:  append([]string{}, r.Scopes...),
+			Public:  r.Public,
+		})
+	}
+
+	networkInfo := &common.Service{
+		Ports:     ports,
+		Protocol:  protocol,
+		Addresses: addresses,
+		FQDNs:     append([]string{}, s.FQDNs...),
+	}
+
+	// The processing units of the policy files have no port mappings, the
+	// services are seen the same way by the applications.
+	privateNetworkInfo := *networkInfo
+
+	return &policy.ApplicationService{
+		ID:                 s.ID,
+		NetworkInfo:        networkInfo,
+		PrivateNetworkInfo: &privateNetworkInfo,
+		Type:               serviceType,
+		HTTPRules:          rules,
+		Tags:               tags,
+		External:           s.External,
+	}, nil
+}
+
+// protocolNumber converts the protocol of a service
+func protocolNumber(protocol string) (uint8, error) {
+
+	switch strings.ToLower(protocol) {
+	case "", "tcp":
+		return 6, nil
+	case "udp":
+		return 17, nil
+	}
+
+	number, err := strconv.ParseUint(protocol, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid protocol %q", protocol)
+	}
+
+	return uint8(number), nil
+}
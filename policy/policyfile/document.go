@@ -0,0 +1,273 @@
+// Package policyfile implements a declarative format of the policies of
+// the processing units, for the hosts that do not have a policy server.
+// The documents are written in YAML or JSON and are loaded into PUPolicy
+// objects. A Resolver enforces the policies of the documents of a file on
+// the processing units that match them.
+package policyfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// CurrentVersion is the version of the format of the policy documents
+const CurrentVersion = 1
+
+// Document is a policy document. The processing units get the first policy
+// of the document whose tags are all tags of their runtime.
+type Document struct {
+	// Version is the version of the format of the document
+	Version int `json:"version" yaml:"version"`
+	// Policies are the policies of the document
+	Policies []*Policy `json:"policies" yaml:"policies"`
+}
+
+// Policy is the policy of the processing units that match its tags
+type Policy struct {
+	// Name is the unique name of the policy in the document
+	Name string `json:"name" yaml:"name"`
+	// Match are the key=value tags that the runtime of the processing
+	// units must all have. A policy without tags matches all the
+	// processing units.
+	Match []string `json:"match,omitempty" yaml:"match,omitempty"`
+	// Action is the action of the policy, police or allowall. The default
+	// is police.
+	Action string `json:"action,omitempty" yaml:"action,omitempty"`
+	// Identity are the key=value tags added to the tags of the runtime to
+	// form the identity of the processing units
+	Identity []string `json:"identity,omitempty" yaml:"identity,omitempty"`
+	// Annotations are the key=value tags of the processing units used for
+	// accounting
+	Annotations []string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	// TransmitterRules are the rules matched on the identity of the
+	// processing units that the processing units connect to
+	TransmitterRules []*TagSelector `json:"transmitterRules,omitempty" yaml:"transmitterRules,omitempty"`
+	// ReceiverRules are the rules matched on the identity of the
+	// processing units that connect to the processing units
+	ReceiverRules []*TagSelector `json:"receiverRules,omitempty" yaml:"receiverRules,omitempty"`
+	// ApplicationACLs are the ACLs of the traffic from the processing units
+	// to the networks
+	ApplicationACLs []*ACL `json:"applicationACLs,omitempty" yaml:"applicationACLs,omitempty"`
+	// NetworkACLs are the ACLs of the traffic from the networks to the
+	// processing units
+	NetworkACLs []*ACL `json:"networkACLs,omitempty" yaml:"networkACLs,omitempty"`
+	// TriremeNetworks are the networks where the authorization is enforced
+	TriremeNetworks []string `json:"triremeNetworks,omitempty" yaml:"triremeNetworks,omitempty"`
+	// ExcludedNetworks are the networks excluded from the enforcement
+	ExcludedNetworks []string `json:"excludedNetworks,omitempty" yaml:"excludedNetworks,omitempty"`
+	// ExposedServices are the services exposed by the processing units
+	ExposedServices []*Service `json:"exposedServices,omitempty" yaml:"exposedServices,omitempty"`
+	// DependentServices are the services the processing units depend on
+	DependentServices []*Service `json:"dependentServices,omitempty" yaml:"dependentServices,omitempty"`
+	// Scopes are the scopes granted to the processing units
+	Scopes []string `json:"scopes,omitempty" yaml:"scopes,omitempty"`
+	// ConflictMode is how the conflicts between the rules that match the
+	// same flow are resolved: deny-overrides, first-match or most-specific.
+	// The default is deny-overrides.
+	ConflictMode string `json:"conflictMode,omitempty" yaml:"conflictMode,omitempty"`
+}
+
+// Flow is the flow policy of a rule
+type Flow struct {
+	// Action is the action of the rule, accept or reject
+	Action string `json:"action" yaml:"action"`
+	// Log logs the flows of the rule
+	Log bool `json:"log,omitempty" yaml:"log,omitempty"`
+	// Encrypt encrypts the flows of the rule
+	Encrypt bool `json:"encrypt,omitempty" yaml:"encrypt,omitempty"`
+	// Observe makes the rule an observation rule. With continue, the flows
+	// are only reported and the next rules apply. With apply, the action
+	// of the rule applies.
+	Observe string `json:"observe,omitempty" yaml:"observe,omitempty"`
+	// PolicyID is the ID of the rule in the reports
+	PolicyID string `json:"policyID,omitempty" yaml:"policyID,omitempty"`
+	// ServiceID is the ID of the service of the rule in the reports
+	ServiceID string `json:"serviceID,omitempty" yaml:"serviceID,omitempty"`
+	// Priority orders the evaluation of the rule. The rules with a higher
+	// priority are evaluated first.
+	Priority int `json:"priority,omitempty" yaml:"priority,omitempty"`
+}
+
+// TagSelector is a rule matched on the identity of the peers
+type TagSelector struct {
+	// Clause are the clauses that must all match the identity of the peer
+	Clause []*Clause `json:"clause" yaml:"clause"`
+	Flow   `yaml:",inline"`
+}
+
+// Clause is a clause of a tag selector
+type Clause struct {
+	// Key is the key of the tag
+	Key string `json:"key" yaml:"key"`
+	// Operator is the operator of the clause: = (equal), =! (not equal),
+	// * (key exists), !* (key does not exist), =^ (prefix), =~ (regular
+	// expression) or the numeric comparisons >, >=, < and <=
+	Operator string `json:"operator" yaml:"operator"`
+	// Values are the values of the tag. The numeric comparisons have a
+	// single value and the key operators have none.
+	Values []string `json:"values,omitempty" yaml:"values,omitempty"`
+}
+
+// ACL is a rule matched on the addresses of the peers
+type ACL struct {
+	// Address is the address of the network, in CIDR notation or as an IP
+	// address
+	Address string `json:"address" yaml:"address"`
+	// Port is the port or the port range min:max
+	Port string `json:"port" yaml:"port"`
+	// Protocol is the protocol, tcp or udp
+	Protocol string `json:"protocol" yaml:"protocol"`
+	Flow     `yaml:",inline"`
+}
+
+// Service is a service of the processing units
+type Service struct {
+	// ID is the ID of the service
+	ID string `json:"id,omitempty" yaml:"id,omitempty"`
+	// Type is the type of the service: l3, tcp or http. The default is l3.
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// Addresses are the networks of the service in CIDR notation. No
+	// addresses means all the addresses.
+	Addresses []string `json:"addresses,omitempty" yaml:"addresses,omitempty"`
+	// Ports is the port or the port range min:max of the service
+	Ports string `json:"ports" yaml:"ports"`
+	// Protocol is the protocol of the service, tcp, udp or its number. The
+	// default is tcp.
+	Protocol string `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	// FQDNs are the names of the service
+	FQDNs []string `json:"fqdns,omitempty" yaml:"fqdns,omitempty"`
+	// External marks the services that are not processing units
+	External bool `json:"external,omitempty" yaml:"external,omitempty"`
+	// Tags are the key=value tags of the service
+	Tags []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	// HTTPRules are the APIs exposed by an http service
+	HTTPRules []*HTTPRule `json:"httpRules,omitempty" yaml:"httpRules,omitempty"`
+}
+
+// HTTPRule is an API of an http service
+type HTTPRule struct {
+	// URIs are the regular expressions of the URIs of the API
+	URIs []string `json:"uris" yaml:"uris"`
+	// Methods are the HTTP methods of the API
+	Methods []string `json:"methods" yaml:"methods"`
+	// Scopes are the scopes required by the API
+	Scopes []string `json:"scopes,omitempty" yaml:"scopes,omitempty"`
+	// Public marks the APIs that do not require an authorization
+	Public bool `json:"public,omitempty" yaml:"public,omitempty"`
+}
+
+// Parse parses and validates a document in YAML or JSON. JSON documents
+// start with a brace.
+func Parse(data []byte) (*Document, error) {
+
+	d := &Document{}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(d); err != nil {
+			return nil, fmt.Errorf("invalid json document: %s", err)
+		}
+	} else if err := yaml.UnmarshalStrict(data, d); err != nil {
+		return nil, fmt.Errorf("invalid yaml document: %s", err)
+	}
+
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// LoadFile loads and validates the document of a file
+func LoadFile(path string) (*Document, error) {
+
+	data, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %s", path, err)
+	}
+
+	d, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return d, nil
+}
+
+// Validate validates the document. The policies must have unique names
+// and must be convertible to PUPolicy objects.
+func (d *Document) Validate() error {
+
+	if d.Version != CurrentVersion {
+		return fmt.Errorf("unsupported version %d", d.Version)
+	}
+
+	names := map[string]bool{}
+	for i, p := range d.Policies {
+		if p == nil {
+			return fmt.Errorf("policy %d: empty policy", i)
+		}
+
+		if p.Name == "" {
+			return fmt.Errorf("policy %d: name is required", i)
+		}
+
+		if names[p.Name] {
+			return fmt.Errorf("policy %s: duplicate name", p.Name)
+		}
+		names[p.Name] = true
+
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Match returns the first policy of the document that matches the tags of
+// the runtime, or nil if there is none.
+func (d *Document) Match(tags *policy.TagStore) *Policy {
+
+	present := map[string]bool{}
+	if tags != nil {
+		for _, tag := range tags.GetSlice() {
+			present[tag] = true
+		}
+	}
+
+	for _, p := range d.Policies {
+		matched := true
+		for _, tag := range p.Match {
+			if !present[tag] {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// splitTag splits a key=value tag
+func splitTag(tag string) (string, string, error) {
+
+	parts := strings.SplitN(tag, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("invalid tag %s: must be key=value", tag)
+	}
+
+	return parts[0], parts[1], nil
+}
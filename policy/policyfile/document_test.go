@@ -0,0 +1,258 @@
+package policyfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/common"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testDocument = `
+version: 1
+policies:
+  - name: web
+    match:
+      - "@usr:app=web"
+    identity:
+      - env=prod
+      - "@usr:app=spoofed"
+    annotations:
+      - owner=team
+    receiverRules:
+      - clause:
+          - key: "@usr:app"
+            operator: "="
+            values: [frontend]
+          - key: env
+            operator: "*"
+          - key: version
+            operator: ">="
+            values: ["2"]
+          - key: team
+            operator: "=~"
+            values: ["payments-.*"]
+        action: accept
+        log: true
+        policyID: frontend-to-web
+      - clause:
+          - key: "@usr:app"
+            operator: "*"
+        action: reject
+        observe: continue
+        policyID: observe-others
+        priority: -1
+    transmitterRules:
+      - clause:
+          - key: "@usr:app"
+            operator: "="
+            values: [db]
+        action: accept
+        encrypt: true
+    applicationACLs:
+      - address: 10.0.0.0/8
+        port: "443"
+        protocol: tcp
+        action: accept
+        policyID: internal
+        priority: 10
+    networkACLs:
+      - address: 192.168.1.1
+        port: "1000:2000"
+        protocol: UDP
+        action: reject
+        observe: apply
+    triremeNetworks: [10.0.0.0/8]
+    excludedNetworks: [10.1.0.0/16]
+    exposedServices:
+      - id: api
+        type: http
+        ports: "80"
+        addresses: [10.0.0.0/24]
+        fqdns: [web.example.org]
+        httpRules:
+          - uris: ["/api/.*"]
+            methods: [GET]
+            scopes: [read]
+    dependentServices:
+      - id: dns
+        ports: "53"
+        protocol: udp
+        external: true
+    scopes: [web]
+    conflictMode: first-match
+  - name: default
+    action: allowall
+`
+
+func TestParse(t *testing.T) {
+
+	Convey("Given a policy document in yaml", t, func() {
+
+		d, err := Parse([]byte(testDocument))
+		So(err, ShouldBeNil)
+		So(len(d.Policies), ShouldEqual, 2)
+
+		runtime := policy.NewPURuntime("web", 100, "", policy.NewTagStoreFromSlice([]string{"@usr:app=web"}), policy.ExtendedMap{"bridge": "10.0.0.2"}, common.ContainerPU, nil)
+
+		Convey("Then the processing units should get the first policy they match", func() {
+			So(d.Match(runtime.Tags()).Name, ShouldEqual, "web")
+			So(d.Match(policy.NewTagStoreFromSlice([]string{"@usr:app=db"})).Name, ShouldEqual, "default")
+			So(d.Match(nil).Name, ShouldEqual, "default")
+		})
+
+		Convey("Then the policy should be converted to a PUPolicy", func() {
+			p, err := d.Policies[0].PUPolicy("pu1", runtime)
+			So(err, ShouldBeNil)
+
+			So(p.ManagementID(), ShouldEqual, "pu1")
+			So(p.TriremeAction(), ShouldEqual, policy.Police)
+			So(p.Identity().GetSlice(), ShouldResemble, []string{"@usr:app=web", "env=prod"})
+			So(p.Annotations().GetSlice(), ShouldResemble, []string{"owner=team"})
+			So(p.IPAddresses(), ShouldResemble, policy.ExtendedMap{"bridge": "10.0.0.2"})
+			So(p.TriremeNetworks(), ShouldResemble, []string{"10.0.0.0/8"})
+			So(p.ExcludedNetworks(), ShouldResemble, []string{"10.1.0.0/16"})
+			So(p.Scopes(), ShouldResemble, []string{"web"})
+			So(p.ConflictMode(), ShouldEqual, policy.FirstMatch)
+
+			rx := p.ReceiverRules()
+			So(len(rx), ShouldEqual, 2)
+			So(rx[0].Clause, ShouldResemble, []policy.KeyValueOperator{
+				{Key: "@usr:app", Value: []string{"frontend"}, Operator: policy.Equal},
+				{Key: "env", Value: []string{}, Operator: policy.KeyExists},
+				{Key: "version", Value: []string{"2"}, Operator: policy.GreaterOrEqual},
+				{Key: "team", Value: []string{"payments-.*"}, Operator: policy.Regex},
+			})
+			So(rx[0].Policy, ShouldResemble, &policy.FlowPolicy{Action: policy.Accept | policy.Log, PolicyID: "frontend-to-web"})
+			So(rx[1].Policy.Action, ShouldEqual, policy.Reject|policy.Observe)
+			So(rx[1].Policy.ObserveAction, ShouldEqual, policy.ObserveContinue)
+			So(rx[1].Priority, ShouldEqual, -1)
+
+			tx := p.TransmitterRules()
+			So(tx[0].Policy.Action, ShouldEqual, policy.Accept|policy.Encrypt)
+
+			app := p.ApplicationACLs()
+			So(app, ShouldResemble, policy.IPRuleList{{
+				Address:  "10.0.0.0/8",
+				Port:     "443",
+				Protocol: "tcp",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "internal"},
+				Priority: 10,
+			}})
+
+			net := p.NetworkACLs()
+			So(net[0].Protocol, ShouldEqual, "udp")
+			So(net[0].Policy.ObserveAction, ShouldEqual, policy.ObserveApply)
+
+			exposed := p.ExposedServices()
+			So(len(exposed), ShouldEqual, 1)
+			So(exposed[0].ID, ShouldEqual, "api")
+			So(exposed[0].Type, ShouldEqual, policy.ServiceHTTP)
+			So(exposed[0].NetworkInfo.Ports.String(), ShouldEqual, "80")
+			So(exposed[0].NetworkInfo.Protocol, ShouldEqual, 6)
+			So(exposed[0].NetworkInfo.Addresses[0].String(), ShouldEqual, "10.0.0.0/24")
+			So(exposed[0].NetworkInfo.FQDNs, ShouldResemble, []string{"web.example.org"})
+			So(exposed[0].PrivateNetworkInfo, ShouldResemble, exposed[0].NetworkInfo)
+			So(exposed[0].HTTPRules[0].URIs, ShouldResemble, []string{"/api/.*"})
+
+			dependent := p.DependentServices()
+			So(dependent[0].NetworkInfo.Protocol, ShouldEqual, 17)
+			So(dependent[0].External, ShouldBeTrue)
+			So(dependent[0].Type, ShouldEqual, policy.ServiceL3)
+		})
+
+		Convey("Then the default policy should allow all", func() {
+			p, err := d.Policies[1].PUPolicy("pu2", runtime)
+			So(err, ShouldBeNil)
+			So(p.TriremeAction(), ShouldEqual, policy.AllowAll)
+			So(p.ConflictMode(), ShouldEqual, policy.DenyOverrides)
+		})
+	})
+
+	Convey("Given a policy document in json", t, func() {
+
+		data := `{"version": 1, "policies": [{"name": "db", "match": ["app=db"],
+			"networkACLs": [{"address": "0.0.0.0/0", "port": "5432", "protocol": "tcp", "action": "accept"}]}]}`
+
+		Convey("Then it should be parsed", func() {
+			d, err := Parse([]byte(data))
+			So(err, ShouldBeNil)
+			So(d.Policies[0].Name, ShouldEqual, "db")
+			So(d.Policies[0].NetworkACLs[0].Action, ShouldEqual, "accept")
+		})
+	})
+
+	Convey("Given invalid policy documents", t, func() {
+
+		documents := map[string]string{
+			"version":          "version: 2\npolicies: []",
+			"unknown field":    "version: 1\npolicies:\n  - name: a\n    unknown: true",
+			"json field":       `{"version": 1, "unknown": true}`,
+			"no name":          "version: 1\npolicies:\n  - action: police",
+			"duplicate name":   "version: 1\npolicies:\n  - name: a\n  - name: a",
+			"match":            "version: 1\npolicies:\n  - name: a\n    match: [app]",
+			"action":           "version: 1\npolicies:\n  - name: a\n    action: deny",
+			"conflict mode":    "version: 1\npolicies:\n  - name: a\n    conflictMode: last-match",
+			"identity":         "version: 1\npolicies:\n  - name: a\n    identity: [=web]",
+			"operator":         "version: 1\npolicies:\n  - name: a\n    receiverRules:\n      - clause: [{key: app, operator: '~', values: [a]}]\n        action: accept",
+			"values":           "version: 1\npolicies:\n  - name: a\n    receiverRules:\n      - clause: [{key: app, operator: '='}]\n        action: accept",
+			"regex":            "version: 1\npolicies:\n  - name: a\n    receiverRules:\n      - clause: [{key: app, operator: '=~', values: ['web(']}]\n        action: accept",
+			"number":           "version: 1\npolicies:\n  - name: a\n    receiverRules:\n      - clause: [{key: version, operator: '>=', values: [v2]}]\n        action: accept",
+			"exists values":    "version: 1\npolicies:\n  - name: a\n    receiverRules:\n      - clause: [{key: app, operator: '*', values: [a]}]\n        action: accept",
+			"no clause":        "version: 1\npolicies:\n  - name: a\n    receiverRules:\n      - action: accept",
+			"flow action":      "version: 1\npolicies:\n  - name: a\n    receiverRules:\n      - clause: [{key: app, operator: '*'}]\n        action: drop",
+			"observe":          "version: 1\npolicies:\n  - name: a\n    receiverRules:\n      - clause: [{key: app, operator: '*'}]\n        action: accept\n        observe: later",
+			"acl address":      "version: 1\npolicies:\n  - name: a\n    networkACLs: [{address: 10.0.0.0/33, port: '80', protocol: tcp, action: accept}]",
+			"acl port":         "version: 1\npolicies:\n  - name: a\n    networkACLs: [{address: 10.0.0.0/8, port: '90:80', protocol: tcp, action: accept}]",
+			"acl protocol":     "version: 1\npolicies:\n  - name: a\n    networkACLs: [{address: 10.0.0.0/8, port: '80', protocol: icmp, action: accept}]",
+			"network":          "version: 1\npolicies:\n  - name: a\n    excludedNetworks: [10.0.0.1]",
+			"service type":     "version: 1\npolicies:\n  - name: a\n    exposedServices: [{type: udp, ports: '80'}]",
+			"service ports":    "version: 1\npolicies:\n  - name: a\n    exposedServices: [{ports: 'http'}]",
+			"service protocol": "version: 1\npolicies:\n  - name: a\n    exposedServices: [{ports: '80', protocol: sctp}]",
+			"service address":  "version: 1\npolicies:\n  - name: a\n    exposedServices: [{ports: '80', addresses: [10.0.0.1]}]",
+			"http rules":       "version: 1\npolicies:\n  - name: a\n    exposedServices: [{ports: '80', httpRules: [{uris: [/], methods: [GET]}]}]",
+		}
+
+		Convey("Then they should be rejected", func() {
+			for name, data := range documents {
+				_, err := Parse([]byte(data))
+				So(err, ShouldNotBeNil)
+				if err == nil {
+					t.Errorf("document %s accepted", name)
+				}
+			}
+		})
+	})
+}
+
+func TestLoadFile(t *testing.T) {
+
+	Convey("Given a policy file", t, func() {
+
+		dir, err := ioutil.TempDir("", "policyfile")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		path := filepath.Join(dir, "policy.yaml")
+		So(ioutil.WriteFile(path, []byte(testDocument), 0600), ShouldBeNil)
+
+		Convey("Then it should be loaded", func() {
+			d, err := LoadFile(path)
+			So(err, ShouldBeNil)
+			So(len(d.Policies), ShouldEqual, 2)
+		})
+
+		Convey("Then the errors should name the file", func() {
+			So(ioutil.WriteFile(path, []byte("version: 3"), 0600), ShouldBeNil)
+			_, err := LoadFile(path)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, path)
+
+			_, err = LoadFile(filepath.Join(dir, "missing.yaml"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,270 @@
+package policyfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/common"
+	"github.com/aporeto-inc/trireme-lib/controller"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/filewatcher"
+)
+
+// reloadDebounce is the time to wait after a change of the file before it
+// is reloaded, so that it is completely written
+const reloadDebounce = time.Second
+
+// resolvedPU is a processing unit enforced by the resolver
+type resolvedPU struct {
+	runtime *policy.PURuntime
+	policy  *Policy
+}
+
+// Resolver implements the policy.Resolver interface with the policies of a
+// document file. The processing units get the first policy of the document
+// that matches the tags of their runtime and the processing units that
+// match no policy are not enforced until a policy matches them. When the
+// file changes, the policies of the processing units are updated through
+// the controller.
+type Resolver struct {
+	path       string
+	controller controller.TriremeController
+	document   *Document
+	pus        map[string]*resolvedPU
+	// pending are the started processing units that are not enforced
+	pending map[string]*policy.PURuntime
+	sync.Mutex
+}
+
+// NewResolver creates a resolver of the policies of the document file. The
+// policies are enforced through the controller.
+func NewResolver(path string, c controller.TriremeController) (*Resolver, error) {
+
+	if c == nil {
+		return nil, errors.New("no controller provided")
+	}
+
+	document, err := LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Resolver{
+		path:       path,
+		controller: c,
+		document:   document,
+		pus:        map[string]*resolvedPU{},
+		pending:    map[string]*policy.PURuntime{},
+	}, nil
+}
+
+// Run watches the file until the context is done and reloads it when it
+// changes.
+func (r *Resolver) Run(ctx context.Context) error {
+
+	changes, err := filewatcher.WatchDirectories(ctx, []string{filepath.Dir(r.path)})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		var debounce <-chan time.Time
+
+		for {
+			select {
+			case _, ok := <-changes:
+				if !ok {
+					return
+				}
+				debounce = time.After(reloadDebounce)
+			case <-debounce:
+				debounce = nil
+				if err := r.Reload(ctx); err != nil {
+					zap.L().Error("Unable to reload policy file", zap.String("path", r.path), zap.Error(err))
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Reload loads the file and updates the policies of the processing units
+// whose policy changed. The current document is kept if the file is
+// invalid. The processing units that match no policy anymore are not
+// enforced anymore and the processing units that were not enforced are
+// enforced if a policy matches them.
+func (r *Resolver) Reload(ctx context.Context) error {
+
+	document, err := LoadFile(r.path)
+	if err != nil {
+		return err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.document = document
+
+	var errs []error
+	for puID, pu := range r.pus {
+		matched := document.Match(pu.runtime.Tags())
+		if reflect.DeepEqual(matched, pu.policy) {
+			continue
+		}
+
+		if err := r.update(ctx, puID, pu.runtime, matched); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for puID, runtime := range r.pending {
+		matched := document.Match(runtime.Tags())
+		if matched == nil {
+			continue
+		}
+
+		if err := r.enforce(ctx, puID, runtime, matched); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to update %d processing units: %s", len(errs), errs[0])
+	}
+
+	return nil
+}
+
+// HandlePUEvent implements the policy.Resolver interface
+func (r *Resolver) HandlePUEvent(ctx context.Context, puID string, event common.Event, runtime policy.RuntimeReader) error {
+
+	r.Lock()
+	defer r.Unlock()
+
+	switch event {
+	case common.EventStart:
+		rt, err := puRuntime(runtime)
+		if err != nil {
+			return err
+		}
+		return r.enforce(ctx, puID, rt, r.document.Match(rt.Tags()))
+
+	case common.EventUpdate:
+		_, enforced := r.pus[puID]
+		if _, pending := r.pending[puID]; !enforced && !pending {
+			return nil
+		}
+		rt, err := puRuntime(runtime)
+		if err != nil {
+			return err
+		}
+		if !enforced {
+			return r.enforce(ctx, puID, rt, r.document.Match(rt.Tags()))
+		}
+		return r.update(ctx, puID, rt, r.document.Match(rt.Tags()))
+
+	case common.EventStop, common.EventDestroy:
+		return r.unenforce(ctx, puID)
+	}
+
+	return nil
+}
+
+// enforce enforces the policy on a processing unit that is not enforced.
+// The processing unit stays pending if it matches no policy or if the
+// policy cannot be enforced.
+func (r *Resolver) enforce(ctx context.Context, puID string, runtime *policy.PURuntime, matched *Policy) error {
+
+	r.pending[puID] = runtime
+
+	if matched == nil {
+		zap.L().Info("No policy for processing unit", zap.String("puID", puID))
+		return nil
+	}
+
+	puPolicy, err := matched.PUPolicy(puID, runtime)
+	if err != nil {
+		return err
+	}
+
+	if err := r.controller.Enforce(ctx, puID, puPolicy, runtime); err != nil {
+		return fmt.Errorf("unable to enforce policy %s on %s: %s", matched.Name, puID, err)
+	}
+
+	delete(r.pending, puID)
+	r.pus[puID] = &resolvedPU{runtime: runtime, policy: matched}
+
+	return nil
+}
+
+// update updates the policy of an enforced processing unit. It is not
+// enforced anymore and becomes pending if it matches no policy.
+func (r *Resolver) update(ctx context.Context, puID string, runtime *policy.PURuntime, matched *Policy) error {
+
+	if matched == nil {
+		if err := r.unenforce(ctx, puID); err != nil {
+			return err
+		}
+		r.pending[puID] = runtime
+		return nil
+	}
+
+	puPolicy, err := matched.PUPolicy(puID, runtime)
+	if err != nil {
+		return err
+	}
+
+	if err := r.controller.UpdatePolicy(ctx, puID, puPolicy, runtime); err != nil {
+		return fmt.Errorf("unable to update policy %s of %s: %s", matched.Name, puID, err)
+	}
+
+	r.pus[puID] = &resolvedPU{runtime: runtime, policy: matched}
+
+	return nil
+}
+
+// unenforce stops the enforcement on a processing unit if it is enforced
+// and forgets it
+func (r *Resolver) unenforce(ctx context.Context, puID string) error {
+
+	delete(r.pending, puID)
+
+	pu, ok := r.pus[puID]
+	if !ok {
+		return nil
+	}
+
+	delete(r.pus, puID)
+
+	puPolicy, err := pu.policy.PUPolicy(puID, pu.runtime)
+	if err != nil {
+		return err
+	}
+
+	if err := r.controller.UnEnforce(ctx, puID, puPolicy, pu.runtime); err != nil {
+		return fmt.Errorf("unable to unenforce %s: %s", puID, err)
+	}
+
+	return nil
+}
+
+// puRuntime returns the runtime of the controller
+func puRuntime(runtime policy.RuntimeReader) (*policy.PURuntime, error) {
+
+	rt, ok := runtime.(*policy.PURuntime)
+	if !ok || rt == nil {
+		return nil, fmt.Errorf("unsupported runtime %T", runtime)
+	}
+
+	return rt, nil
+}
@@ -0,0 +1,191 @@
+package policyfile
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/common"
+	mockcontroller "github.com/aporeto-inc/trireme-lib/controller/mock"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testResolverDocument = `
+version: 1
+policies:
+  - name: web
+    match: [app=web]
+    networkACLs:
+      - address: 10.0.0.0/8
+        port: "80"
+        protocol: tcp
+        action: accept
+`
+
+const testUpdatedDocument = `
+version: 1
+policies:
+  - name: web
+    match: [app=web]
+    networkACLs:
+      - address: 10.0.0.0/8
+        port: "443"
+        protocol: tcp
+        action: accept
+  - name: db
+    match: [app=db]
+`
+
+// matchACLPort matches the PU policies whose first network ACL has the port
+type matchACLPort string
+
+func (m matchACLPort) Matches(x interface{}) bool {
+	p, ok := x.(*policy.PUPolicy)
+	return ok && len(p.NetworkACLs()) > 0 && p.NetworkACLs()[0].Port == string(m)
+}
+
+func (m matchACLPort) String() string {
+	return "network acl on port " + string(m)
+}
+
+func TestResolver(t *testing.T) {
+
+	Convey("Given a resolver of a policy file", t, func() {
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		dir, err := ioutil.TempDir("", "policyfile")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		path := filepath.Join(dir, "policy.yaml")
+		So(ioutil.WriteFile(path, []byte(testResolverDocument), 0600), ShouldBeNil)
+
+		c := mockcontroller.NewMockTriremeController(ctrl)
+		r, err := NewResolver(path, c)
+		So(err, ShouldBeNil)
+
+		ctx := context.Background()
+		web := policy.NewPURuntime("web", 100, "", policy.NewTagStoreFromSlice([]string{"app=web"}), nil, common.ContainerPU, nil)
+		db := policy.NewPURuntime("db", 200, "", policy.NewTagStoreFromSlice([]string{"app=db"}), nil, common.ContainerPU, nil)
+
+		Convey("Then the processing units that match a policy should be enforced", func() {
+			c.EXPECT().Enforce(ctx, "web", matchACLPort("80"), web).Return(nil)
+			So(r.HandlePUEvent(ctx, "web", common.EventStart, web), ShouldBeNil)
+			So(r.pus, ShouldContainKey, "web")
+
+			Convey("Then they should not be enforced anymore when they stop", func() {
+				c.EXPECT().UnEnforce(ctx, "web", gomock.Any(), web).Return(nil)
+				So(r.HandlePUEvent(ctx, "web", common.EventStop, web), ShouldBeNil)
+				So(r.HandlePUEvent(ctx, "web", common.EventDestroy, web), ShouldBeNil)
+				So(r.pus, ShouldNotContainKey, "web")
+			})
+
+			Convey("Then their policy should be updated when the file changes", func() {
+				So(ioutil.WriteFile(path, []byte(testUpdatedDocument), 0600), ShouldBeNil)
+
+				c.EXPECT().UpdatePolicy(ctx, "web", matchACLPort("443"), web).Return(nil)
+				So(r.Reload(ctx), ShouldBeNil)
+
+				Convey("Then a reload of the same policies should not update them", func() {
+					So(r.Reload(ctx), ShouldBeNil)
+				})
+			})
+
+			Convey("Then an invalid file should keep the current policies", func() {
+				So(ioutil.WriteFile(path, []byte("version: 1\npolicies:\n  - name: web\n    action: deny"), 0600), ShouldBeNil)
+				So(r.Reload(ctx), ShouldNotBeNil)
+				So(r.document.Policies[0].NetworkACLs[0].Port, ShouldEqual, "80")
+			})
+
+			Convey("Then they should not be enforced anymore when they match no policy", func() {
+				So(ioutil.WriteFile(path, []byte("version: 1\npolicies: []"), 0600), ShouldBeNil)
+
+				c.EXPECT().UnEnforce(ctx, "web", gomock.Any(), web).Return(nil)
+				So(r.Reload(ctx), ShouldBeNil)
+				So(r.pus, ShouldNotContainKey, "web")
+				So(r.pending, ShouldContainKey, "web")
+
+				Convey("Then they should be enforced again when a policy matches them", func() {
+					So(ioutil.WriteFile(path, []byte(testResolverDocument), 0600), ShouldBeNil)
+
+					c.EXPECT().Enforce(ctx, "web", matchACLPort("80"), web).Return(nil)
+					So(r.Reload(ctx), ShouldBeNil)
+					So(r.pus, ShouldContainKey, "web")
+					So(r.pending, ShouldNotContainKey, "web")
+				})
+			})
+
+			Convey("Then the updates of the runtime should update their policy", func() {
+				c.EXPECT().UpdatePolicy(ctx, "web", matchACLPort("80"), web).Return(nil)
+				So(r.HandlePUEvent(ctx, "web", common.EventUpdate, web), ShouldBeNil)
+			})
+		})
+
+		Convey("Then the processing units that match no policy should not be enforced", func() {
+			So(r.HandlePUEvent(ctx, "db", common.EventStart, db), ShouldBeNil)
+			So(r.pus, ShouldNotContainKey, "db")
+
+			So(r.HandlePUEvent(ctx, "db", common.EventUpdate, db), ShouldBeNil)
+			So(r.HandlePUEvent(ctx, "db", common.EventStop, db), ShouldBeNil)
+			So(r.pending, ShouldNotContainKey, "db")
+		})
+
+		Convey("Then the processing units that match no policy should be enforced when a later document matches them", func() {
+			So(r.HandlePUEvent(ctx, "db", common.EventStart, db), ShouldBeNil)
+			So(r.pending, ShouldContainKey, "db")
+
+			So(ioutil.WriteFile(path, []byte(testUpdatedDocument), 0600), ShouldBeNil)
+
+			c.EXPECT().Enforce(ctx, "db", gomock.Any(), db).Return(nil)
+			So(r.Reload(ctx), ShouldBeNil)
+			So(r.pus, ShouldContainKey, "db")
+			So(r.pending, ShouldNotContainKey, "db")
+		})
+
+		Convey("Then the processing units that match no policy should be enforced when their tags match a policy", func() {
+			So(r.HandlePUEvent(ctx, "db", common.EventStart, db), ShouldBeNil)
+
+			relabeled := policy.NewPURuntime("db", 200, "", policy.NewTagStoreFromSlice([]string{"app=web"}), nil, common.ContainerPU, nil)
+			c.EXPECT().Enforce(ctx, "db", matchACLPort("80"), relabeled).Return(nil)
+			So(r.HandlePUEvent(ctx, "db", common.EventUpdate, relabeled), ShouldBeNil)
+			So(r.pus, ShouldContainKey, "db")
+		})
+
+		Convey("Then the errors of the controller should be returned", func() {
+			c.EXPECT().Enforce(ctx, "web", gomock.Any(), web).Return(errors.New("failed"))
+			So(r.HandlePUEvent(ctx, "web", common.EventStart, web), ShouldNotBeNil)
+			So(r.pus, ShouldNotContainKey, "web")
+
+			Convey("Then the enforcement should be retried when the file changes", func() {
+				c.EXPECT().Enforce(ctx, "web", gomock.Any(), web).Return(nil)
+				So(r.Reload(ctx), ShouldBeNil)
+				So(r.pus, ShouldContainKey, "web")
+			})
+		})
+
+		Convey("Then the runtimes that are not PURuntime should be rejected", func() {
+			So(r.HandlePUEvent(ctx, "web", common.EventStart, nil), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given an invalid policy file", t, func() {
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		Convey("Then the resolver should not be created", func() {
+			_, err := NewResolver(filepath.Join(os.TempDir(), "missing-policy.yaml"), mockcontroller.NewMockTriremeController(ctrl))
+			So(err, ShouldNotBeNil)
+
+			_, err = NewResolver("policy.yaml", nil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,40 @@
+package policy
+
+const (
+	// SpiffeIDKey is the reserved tag of the SPIFFE ID of the verified SVID
+	// of a peer. It can not be set by the peer itself.
+	SpiffeIDKey = "$spiffe:id"
+	// SpiffeTrustDomainKey is the reserved tag of the trust domain of the
+	// verified SVID of a peer.
+	SpiffeTrustDomainKey = "$spiffe:trustdomain"
+)
+
+// NewSpiffeIDClause returns a clause that selects the peers with one of the
+// SPIFFE IDs. An ID ending with * selects all the IDs with this prefix.
+func NewSpiffeIDClause(ids ...string) KeyValueOperator {
+	return KeyValueOperator{
+		Key:      SpiffeIDKey,
+		Value:    ids,
+		Operator: Equal,
+	}
+}
+
+// NewSpiffeTrustDomainClause returns a clause that selects the peers of one
+// of the trust domains.
+func NewSpiffeTrustDomainClause(trustDomains ...string) KeyValueOperator {
+	return KeyValueOperator{
+		Key:      SpiffeTrustDomainKey,
+		Value:    trustDomains,
+		Operator: Equal,
+	}
+}
+
+// IsSpiffeTag returns true if the tag is one of the reserved SPIFFE tags.
+func IsSpiffeTag(tag string) bool {
+	return hasKey(tag, SpiffeIDKey) || hasKey(tag, SpiffeTrustDomainKey)
+}
+
+// hasKey returns true if the key value tag has the key
+func hasKey(tag string, key string) bool {
+	return len(tag) > len(key) && tag[len(key)] == '=' && tag[:len(key)] == key
+}
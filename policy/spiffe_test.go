@@ -0,0 +1,30 @@
+package policy
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSpiffeTags(t *testing.T) {
+
+	Convey("Given the reserved SPIFFE tags", t, func() {
+
+		Convey("Then they should be recognized", func() {
+			So(IsSpiffeTag(SpiffeIDKey+"=spiffe://example.org/web"), ShouldBeTrue)
+			So(IsSpiffeTag(SpiffeTrustDomainKey+"=example.org"), ShouldBeTrue)
+			So(IsSpiffeTag(SpiffeIDKey), ShouldBeFalse)
+			So(IsSpiffeTag(SpiffeIDKey+"x=spiffe://example.org/web"), ShouldBeFalse)
+			So(IsSpiffeTag("app=web"), ShouldBeFalse)
+		})
+
+		Convey("Then the clauses should select on them", func() {
+			So(NewSpiffeIDClause("spiffe://example.org/*"), ShouldResemble, KeyValueOperator{
+				Key:      SpiffeIDKey,
+				Value:    []string{"spiffe://example.org/*"},
+				Operator: Equal,
+			})
+			So(NewSpiffeTrustDomainClause("example.org").Key, ShouldEqual, SpiffeTrustDomainKey)
+		})
+	})
+}
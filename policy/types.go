@@ -2,6 +2,9 @@ package policy
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
 
 	"github.com/aporeto-inc/trireme-lib/common"
 	"github.com/docker/go-connections/nat"
@@ -10,6 +13,8 @@ import (
 const (
 	// DefaultNamespace is the default namespace for applying policy
 	DefaultNamespace = "bridge"
+	// DefaultNamespaceIPv6 is the namespace of the ipv6 address of the default network
+	DefaultNamespaceIPv6 = "bridge-ipv6"
 )
 
 // constants for various actions
@@ -39,6 +44,21 @@ const (
 	KeyExists = "*"
 	// KeyNotExists means that the key doesnt exist in the incoming tags
 	KeyNotExists = "!*"
+	// Prefix is the operator of the values that start with one of the values
+	Prefix = "=^"
+	// Regex is the operator of the values that entirely match one of the
+	// regular expressions
+	Regex = "=~"
+	// GreaterThan is the operator of the numeric values greater than the value
+	GreaterThan = ">"
+	// GreaterOrEqual is the operator of the numeric values greater than or
+	// equal to the value
+	GreaterOrEqual = ">="
+	// LessThan is the operator of the numeric values less than the value
+	LessThan = "<"
+	// LessOrEqual is the operator of the numeric values less than or equal to
+	// the value
+	LessOrEqual = "<="
 )
 
 // ActionType   is the action that can be applied to a flow.
@@ -237,6 +257,9 @@ type IPRule struct {
 	Port     string
 	Protocol string
 	Policy   *FlowPolicy
+	// Priority orders the evaluation of the rule. The rules with a higher
+	// priority are evaluated first.
+	Priority int
 }
 
 // IPRuleList is a list of IP rules
@@ -258,10 +281,74 @@ type KeyValueOperator struct {
 	Operator Operator
 }
 
+// Validate validates the operator and the values of the clause. The equal,
+// not equal, prefix and regex operators require values, the numeric
+// operators require a single number and the key operators have no values.
+func (k KeyValueOperator) Validate() error {
+
+	if k.Key == "" {
+		return errors.New("clause without key")
+	}
+
+	switch k.Operator {
+	case Equal, NotEqual:
+		if len(k.Value) == 0 {
+			return fmt.Errorf("clause %s%s without values", k.Key, k.Operator)
+		}
+
+	case Prefix:
+		if len(k.Value) == 0 {
+			return fmt.Errorf("clause %s%s without values", k.Key, k.Operator)
+		}
+		for _, v := range k.Value {
+			if v == "" {
+				return fmt.Errorf("clause %s%s with an empty prefix", k.Key, k.Operator)
+			}
+		}
+
+	case Regex:
+		if len(k.Value) == 0 {
+			return fmt.Errorf("clause %s%s without values", k.Key, k.Operator)
+		}
+		for _, v := range k.Value {
+			if _, err := CompileTagRegex(v); err != nil {
+				return fmt.Errorf("clause %s%s: invalid regular expression %q: %s", k.Key, k.Operator, v, err)
+			}
+		}
+
+	case GreaterThan, GreaterOrEqual, LessThan, LessOrEqual:
+		if len(k.Value) != 1 {
+			return fmt.Errorf("clause %s%s requires a single value", k.Key, k.Operator)
+		}
+		if _, err := strconv.ParseFloat(k.Value[0], 64); err != nil {
+			return fmt.Errorf("clause %s%s: invalid number %q", k.Key, k.Operator, k.Value[0])
+		}
+
+	case KeyExists, KeyNotExists:
+		if len(k.Value) != 0 {
+			return fmt.Errorf("clause %s%s with values", k.Key, k.Operator)
+		}
+
+	default:
+		return fmt.Errorf("invalid operator %q of clause %s", k.Operator, k.Key)
+	}
+
+	return nil
+}
+
+// CompileTagRegex compiles the regular expression of a Regex clause. The
+// expression must match the entire value of the tags.
+func CompileTagRegex(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
 // TagSelector info describes a tag selector key Operator value
 type TagSelector struct {
 	Clause []KeyValueOperator
 	Policy *FlowPolicy
+	// Priority orders the evaluation of the rule. The rules with a higher
+	// priority are evaluated first.
+	Priority int
 }
 
 // TagSelectorList defines a list of TagSelectors
@@ -58,3 +58,56 @@ func TestEncodeDecodePrefix(t *testing.T) {
 		}
 	})
 }
+
+func TestKeyValueOperatorValidate(t *testing.T) {
+	Convey("When I validate clauses", t, func() {
+		valid := []KeyValueOperator{
+			{Key: "app", Value: []string{"web"}, Operator: Equal},
+			{Key: "app", Value: []string{"web"}, Operator: NotEqual},
+			{Key: "app", Operator: KeyExists},
+			{Key: "app", Operator: KeyNotExists},
+			{Key: "team", Value: []string{"payments-"}, Operator: Prefix},
+			{Key: "env", Value: []string{"prod|staging", "dev-[0-9]+"}, Operator: Regex},
+			{Key: "version", Value: []string{"2"}, Operator: GreaterOrEqual},
+			{Key: "version", Value: []string{"-1.5"}, Operator: LessThan},
+		}
+
+		invalid := []KeyValueOperator{
+			{Value: []string{"web"}, Operator: Equal},
+			{Key: "app", Operator: Equal},
+			{Key: "app", Value: []string{"web"}, Operator: KeyExists},
+			{Key: "app", Value: []string{"web"}, Operator: "~"},
+			{Key: "team", Value: []string{""}, Operator: Prefix},
+			{Key: "env", Value: []string{"prod("}, Operator: Regex},
+			{Key: "env", Operator: Regex},
+			{Key: "version", Value: []string{"v2"}, Operator: GreaterThan},
+			{Key: "version", Value: []string{"1", "2"}, Operator: LessOrEqual},
+		}
+
+		Convey("The valid clauses should be accepted", func() {
+			for _, kvo := range valid {
+				So(kvo.Validate(), ShouldBeNil)
+			}
+		})
+
+		Convey("The malformed clauses should be rejected", func() {
+			for _, kvo := range invalid {
+				So(kvo.Validate(), ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestCompileTagRegex(t *testing.T) {
+	Convey("When I compile the regular expression of a clause", t, func() {
+		re, err := CompileTagRegex("prod|staging")
+		So(err, ShouldBeNil)
+
+		Convey("It should match the entire values only", func() {
+			So(re.MatchString("prod"), ShouldBeTrue)
+			So(re.MatchString("staging"), ShouldBeTrue)
+			So(re.MatchString("production"), ShouldBeFalse)
+			So(re.MatchString("preprod"), ShouldBeFalse)
+		})
+	})
+}
@@ -23,6 +23,8 @@ type DataStore interface {
 	RemoveWithDelay(u interface{}, duration time.Duration) (err error)
 	LockedModify(u interface{}, add func(a, b interface{}) interface{}, increment interface{}) (interface{}, error)
 	SetTimeOut(u interface{}, timeout time.Duration) (err error)
+	KeyList() []interface{}
+	SizeOf() int
 	ToString() string
 }
 
@@ -349,6 +351,19 @@ func (c *Cache) RemoveWithDelay(u interface{}, duration time.Duration) error {
 
 }
 
+// KeyList returns all the keys that are currently stored in the cache.
+func (c *Cache) KeyList() []interface{} {
+	c.RLock()
+	defer c.RUnlock()
+
+	list := []interface{}{}
+	for k := range c.data {
+		list = append(list, k)
+	}
+
+	return list
+}
+
 // SizeOf returns the number of elements in the cache
 func (c *Cache) SizeOf() int {
 
@@ -93,6 +93,13 @@ func TestElements(t *testing.T) {
 			So(ok, ShouldBeTrue)
 		})
 
+		Convey("Given that I list the keys of the cache, I should get all the elements", func() {
+			keys := c.KeyList()
+			So(len(keys), ShouldEqual, 2)
+			So(keys, ShouldContain, id)
+			So(keys, ShouldContain, newid)
+		})
+
 		Convey("Given that I have an element in the cache, I should be able to delete it", func() {
 			err := c.Remove(id)
 			So(err, ShouldBeNil)
@@ -0,0 +1,56 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// SigningMethodEdDSA signs the JWTs with Ed25519 keys as defined by RFC 8037.
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+type signingMethodEdDSA struct{}
+
+// Alg implements the jwt.SigningMethod interface.
+func (m *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Sign implements the jwt.SigningMethod interface. The key must be an
+// ed25519.PrivateKey.
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok || len(privateKey) != ed25519.PrivateKeySize {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+// Verify implements the jwt.SigningMethod interface. The key must be an
+// ed25519.PublicKey.
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok || len(publicKey) != ed25519.PublicKeySize {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// LoadPrivateKey parses a private key in SEC 1 or PKCS#8 format. ECDSA P-256
+// and P-384 keys and Ed25519 keys are supported.
+func LoadPrivateKey(keyPEM []byte) (crypto.Signer, error) {
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("unable to parse pem block of private key")
+	}
+
+	var key interface{}
+	var err error
+
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported private key type %s", block.Type)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("private key can not sign")
+	}
+
+	if err := CheckPublicKey(signer.Public()); err != nil {
+		return nil, err
+	}
+
+	return signer, nil
+}
+
+// LoadAndVerifySecrets loads the private key, the certificate and the CA
+// and verifies the certificate with the CA. The keys can be of any of the
+// supported types.
+func LoadAndVerifySecrets(keyPEM, certPEM, caCertPEM []byte) (key crypto.Signer, cert *x509.Certificate, rootCertPool *x509.CertPool, err error) {
+
+	key, err = LoadPrivateKey(keyPEM)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	rootCertPool = LoadRootCertificates(caCertPEM)
+	if rootCertPool == nil {
+		return nil, nil, nil, errors.New("unable to load root certificate pool")
+	}
+
+	cert, err = LoadAndVerifyCertificate(certPEM, rootCertPool)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return key, cert, rootCertPool, nil
+}
+
+// CheckPublicKey returns an error if the public key is not of a supported
+// type.
+func CheckPublicKey(key crypto.PublicKey) error {
+
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() && k.Curve != elliptic.P384() {
+			return fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+		}
+		return nil
+	case ed25519.PublicKey:
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// KeyMatchesCertificate returns true if the public key of the certificate
+// is the public key of the private key.
+func KeyMatchesCertificate(key crypto.Signer, cert *x509.Certificate) bool {
+
+	public, ok := key.Public().(interface {
+		Equal(crypto.PublicKey) bool
+	})
+
+	return ok && public.Equal(cert.PublicKey)
+}
+
+// PublicKeyFingerprint returns the hex encoded SHA-256 hash of the DER
+// encoding of the public key.
+func PublicKeyFingerprint(key crypto.PublicKey) (string, error) {
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(der)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// SigningMethod returns the JWT signing method of a private or public key.
+// P-256 keys use ES256, P-384 keys use ES384 and Ed25519 keys use EdDSA.
+// The private keys that can not be exported, like the keys of an HSM, are
+// supported through the crypto.Signer interface.
+func SigningMethod(key interface{}) (jwt.SigningMethod, error) {
+
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return SigningMethod(&k.PublicKey)
+	case ed25519.PrivateKey:
+		return SigningMethodEdDSA, nil
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return jwt.SigningMethodES384, nil
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+		}
+	case ed25519.PublicKey:
+		return SigningMethodEdDSA, nil
+	case crypto.Signer:
+		return signerSigningMethod(k)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+// SignatureSize returns the size of the JWT signatures of a private or
+// public key.
+func SignatureSize(key interface{}) (int, error) {
+
+	method, err := SigningMethod(key)
+	if err != nil {
+		return 0, err
+	}
+
+	switch method.Alg() {
+	case jwt.SigningMethodES384.Alg():
+		return 96, nil
+	default:
+		return 64, nil
+	}
+}
+
+// EncodedSignatureSize returns the size of the JWT signatures of a private
+// or public key once encoded in the token.
+func EncodedSignatureSize(key interface{}) (int, error) {
+
+	size, err := SignatureSize(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return base64.RawURLEncoding.EncodedLen(size), nil
+}
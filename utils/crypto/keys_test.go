@@ -0,0 +1,211 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func generateTestKey(keyType string) crypto.Signer {
+
+	var key crypto.Signer
+	var err error
+
+	switch keyType {
+	case "P-256":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "P-384":
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "P-521":
+		key, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "Ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case "RSA":
+		key, err = rsa.GenerateKey(rand.Reader, 1024)
+	}
+	So(err, ShouldBeNil)
+
+	return key
+}
+
+func pkcs8PEM(key crypto.Signer) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	So(err, ShouldBeNil)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func selfSignedPEM(key crypto.Signer) []byte {
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	So(err, ShouldBeNil)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestLoadPrivateKey(t *testing.T) {
+
+	Convey("Given private keys of different types", t, func() {
+
+		Convey("Then P-256 keys should be loaded in SEC 1 and PKCS#8 format", func() {
+			key := generateTestKey("P-256").(*ecdsa.PrivateKey)
+			der, err := x509.MarshalECPrivateKey(key)
+			So(err, ShouldBeNil)
+
+			loaded, err := LoadPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+			So(err, ShouldBeNil)
+			So(loaded.Public().(*ecdsa.PublicKey).Equal(&key.PublicKey), ShouldBeTrue)
+
+			loaded, err = LoadPrivateKey(pkcs8PEM(key))
+			So(err, ShouldBeNil)
+			So(loaded.Public().(*ecdsa.PublicKey).Equal(&key.PublicKey), ShouldBeTrue)
+		})
+
+		Convey("Then P-384 and Ed25519 keys should be loaded", func() {
+			for _, keyType := range []string{"P-384", "Ed25519"} {
+				key := generateTestKey(keyType)
+				loaded, err := LoadPrivateKey(pkcs8PEM(key))
+				So(err, ShouldBeNil)
+				So(loaded.Public(), ShouldResemble, key.Public())
+			}
+		})
+
+		Convey("Then unsupported keys should be rejected", func() {
+			for _, keyType := range []string{"P-521", "RSA"} {
+				_, err := LoadPrivateKey(pkcs8PEM(generateTestKey(keyType)))
+				So(err, ShouldNotBeNil)
+			}
+
+			_, err := LoadPrivateKey([]byte("garbage"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestLoadAndVerifySecrets(t *testing.T) {
+
+	Convey("Given a self signed Ed25519 certificate", t, func() {
+
+		key := generateTestKey("Ed25519")
+		keyPEM := pkcs8PEM(key)
+		certPEM := selfSignedPEM(key)
+
+		Convey("When I load the secrets", func() {
+			loaded, cert, pool, err := LoadAndVerifySecrets(keyPEM, certPEM, certPEM)
+
+			Convey("Then they should be loaded and match", func() {
+				So(err, ShouldBeNil)
+				So(pool, ShouldNotBeNil)
+				So(KeyMatchesCertificate(loaded, cert), ShouldBeTrue)
+				So(KeyMatchesCertificate(generateTestKey("Ed25519"), cert), ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestPublicKeyFingerprint(t *testing.T) {
+
+	Convey("Given keys of the supported types", t, func() {
+
+		Convey("Then the fingerprints should identify the public keys", func() {
+			for _, keyType := range []string{"P-256", "P-384", "Ed25519"} {
+				key := generateTestKey(keyType)
+
+				fingerprint, err := PublicKeyFingerprint(key.Public())
+				So(err, ShouldBeNil)
+				So(len(fingerprint), ShouldEqual, 64)
+
+				again, err := PublicKeyFingerprint(key.Public())
+				So(err, ShouldBeNil)
+				So(again, ShouldEqual, fingerprint)
+
+				other, err := PublicKeyFingerprint(generateTestKey(keyType).Public())
+				So(err, ShouldBeNil)
+				So(other, ShouldNotEqual, fingerprint)
+			}
+
+			_, err := PublicKeyFingerprint("key")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestSigningMethod(t *testing.T) {
+
+	Convey("Given keys of the supported types", t, func() {
+
+		Convey("Then the signing method should follow the key", func() {
+			for keyType, alg := range map[string]string{"P-256": "ES256", "P-384": "ES384", "Ed25519": "EdDSA"} {
+				key := generateTestKey(keyType)
+
+				method, err := SigningMethod(key)
+				So(err, ShouldBeNil)
+				So(method.Alg(), ShouldEqual, alg)
+
+				method, err = SigningMethod(key.Public())
+				So(err, ShouldBeNil)
+				So(method.Alg(), ShouldEqual, alg)
+			}
+
+			_, err := SigningMethod(generateTestKey("RSA"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then the tokens should be signed and verified with the signing method", func() {
+			for _, keyType := range []string{"P-256", "P-384", "Ed25519"} {
+				key := generateTestKey(keyType)
+				method, err := SigningMethod(key)
+				So(err, ShouldBeNil)
+
+				token, err := jwt.NewWithClaims(method, &jwt.StandardClaims{Issuer: "test"}).SignedString(key)
+				So(err, ShouldBeNil)
+
+				size, err := EncodedSignatureSize(key)
+				So(err, ShouldBeNil)
+				So(len(token)-len(token[:lastDot(token)+1]), ShouldEqual, size)
+
+				claims := &jwt.StandardClaims{}
+				_, err = jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+					return key.Public(), nil
+				})
+				So(err, ShouldBeNil)
+				So(claims.Issuer, ShouldEqual, "test")
+
+				_, err = jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+					return generateTestKey(keyType).Public(), nil
+				})
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func lastDot(s string) int {
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] == '.' {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,88 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// KeyStoreOpener opens the private key identified by the URI in a key store.
+// The key is only used through the signer and does not need to leave the
+// key store.
+type KeyStoreOpener func(uri string) (crypto.Signer, error)
+
+var (
+	keyStores     = map[string]KeyStoreOpener{}
+	keyStoresLock sync.RWMutex
+)
+
+// RegisterKeyStore registers the opener of the keys of the URIs with the
+// scheme, like pkcs11 for the URIs of RFC 7512. The key stores usually
+// register themselves when their package is imported.
+func RegisterKeyStore(scheme string, opener KeyStoreOpener) {
+
+	keyStoresLock.Lock()
+	defer keyStoresLock.Unlock()
+
+	keyStores[scheme] = opener
+}
+
+// OpenKeyStore opens the private key identified by the URI with the key
+// store registered for its scheme. The public key must be of a supported
+// type.
+func OpenKeyStore(uri string) (crypto.Signer, error) {
+
+	i := strings.Index(uri, ":")
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid key store uri %s", uri)
+	}
+
+	keyStoresLock.RLock()
+	opener, ok := keyStores[uri[:i]]
+	keyStoresLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported key store %s", uri[:i])
+	}
+
+	signer, err := opener(uri)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open key store: %s", err)
+	}
+
+	if err := CheckPublicKey(signer.Public()); err != nil {
+		return nil, err
+	}
+
+	return signer, nil
+}
+
+// LoadAndVerifyKeyStoreSecrets opens the private key from a key store, loads
+// the certificate and the CA and verifies the certificate with the CA and
+// the key.
+func LoadAndVerifyKeyStoreSecrets(uri string, certPEM, caCertPEM []byte) (key crypto.Signer, cert *x509.Certificate, rootCertPool *x509.CertPool, err error) {
+
+	key, err = OpenKeyStore(uri)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	rootCertPool = LoadRootCertificates(caCertPEM)
+	if rootCertPool == nil {
+		return nil, nil, nil, errors.New("unable to load root certificate pool")
+	}
+
+	cert, err = LoadAndVerifyCertificate(certPEM, rootCertPool)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if !KeyMatchesCertificate(key, cert) {
+		return nil, nil, nil, errors.New("key store key does not match the certificate")
+	}
+
+	return key, cert, rootCertPool, nil
+}
@@ -0,0 +1,70 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenKeyStore(t *testing.T) {
+
+	Convey("Given a registered key store", t, func() {
+
+		keys := map[string]crypto.Signer{
+			"test:p256": &opaqueSigner{key: generateTestKey("P-256")},
+			"test:rsa":  &opaqueSigner{key: generateTestKey("RSA")},
+		}
+
+		RegisterKeyStore("test", func(uri string) (crypto.Signer, error) {
+			key, ok := keys[uri]
+			if !ok {
+				return nil, errors.New("key not found")
+			}
+			return key, nil
+		})
+
+		Convey("Then the keys should be opened with their uri", func() {
+			key, err := OpenKeyStore("test:p256")
+			So(err, ShouldBeNil)
+			So(key, ShouldEqual, keys["test:p256"])
+		})
+
+		Convey("Then the errors of the key store should be returned", func() {
+			_, err := OpenKeyStore("test:missing")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then the keys of unsupported types should be rejected", func() {
+			_, err := OpenKeyStore("test:rsa")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then the unknown key stores and invalid uris should be rejected", func() {
+			_, err := OpenKeyStore("unknown:p256")
+			So(err, ShouldNotBeNil)
+			_, err = OpenKeyStore("p256")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then the certificate should be verified with the key", func() {
+			caPEM := selfSignedPEM(keys["test:p256"])
+
+			key, cert, _, err := LoadAndVerifyKeyStoreSecrets("test:p256", caPEM, caPEM)
+			So(err, ShouldBeNil)
+			So(key, ShouldEqual, keys["test:p256"])
+
+			block, _ := pem.Decode(caPEM)
+			expected, err := x509.ParseCertificate(block.Bytes)
+			So(err, ShouldBeNil)
+			So(cert.Equal(expected), ShouldBeTrue)
+
+			keys["test:other"] = &opaqueSigner{key: generateTestKey("P-256")}
+			_, _, _, err = LoadAndVerifyKeyStoreSecrets("test:other", caPEM, caPEM)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
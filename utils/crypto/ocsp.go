@@ -0,0 +1,249 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha1" // nolint: gosec
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// The OCSP messages implement the subset of RFC 6960 needed to query the
+// status of a single certificate.
+
+// maxOCSPResponseSize is the maximum size of the OCSP responses
+const maxOCSPResponseSize = 1 << 20
+
+var (
+	oidSHA1      = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidOCSPBasic = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+	ocspSignatureAlgorithms = map[string]x509.SignatureAlgorithm{
+		"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+		"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+		"1.3.101.112":           x509.PureEd25519,
+		"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+		"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+	}
+)
+
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type ocspRequestEntry struct {
+	Cert ocspCertID
+}
+
+type ocspTBSRequest struct {
+	Version     int `asn1:"explicit,tag:0,default:0,optional"`
+	RequestList []ocspRequestEntry
+}
+
+type ocspRequest struct {
+	TBSRequest ocspTBSRequest
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Version     int `asn1:"optional,default:0,explicit,tag:0"`
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []ocspSingleResponse
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspSingleResponse struct {
+	CertID     ocspCertID
+	Good       asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked    ocspRevokedInfo  `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+// ocspCertIDFor returns the identifier of the certificate in the OCSP
+// messages
+func ocspCertIDFor(cert, issuer *x509.Certificate) (*ocspCertID, error) {
+
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, fmt.Errorf("invalid issuer public key: %s", err)
+	}
+
+	nameHash := sha1.Sum(issuer.RawSubject)          // nolint: gosec
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign()) // nolint: gosec
+
+	return &ocspCertID{
+		HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+		NameHash:      nameHash[:],
+		IssuerKeyHash: keyHash[:],
+		SerialNumber:  cert.SerialNumber,
+	}, nil
+}
+
+// queryOCSP queries the responder for the status of the certificate. It
+// returns the status and the time of its next update, if any.
+func queryOCSP(client *http.Client, url string, cert, issuer *x509.Certificate) (bool, time.Time, error) {
+
+	id, err := ocspCertIDFor(cert, issuer)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+
+	request, err := asn1.Marshal(ocspRequest{
+		TBSRequest: ocspTBSRequest{
+			RequestList: []ocspRequestEntry{{Cert: *id}},
+		},
+	})
+	if err != nil {
+		return false, time.Time{}, err
+	}
+
+	resp, err := client.Post(url, "application/ocsp-request", bytes.NewReader(request))
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return false, time.Time{}, fmt.Errorf("ocsp responder returned %s", resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxOCSPResponseSize))
+	if err != nil {
+		return false, time.Time{}, err
+	}
+
+	single, err := parseOCSPResponse(data, id, issuer)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+
+	if single.Unknown {
+		return false, single.NextUpdate, errors.New("unknown certificate")
+	}
+
+	return !single.Revoked.RevocationTime.IsZero(), single.NextUpdate, nil
+}
+
+// parseOCSPResponse verifies the response and returns the status of the
+// certificate
+func parseOCSPResponse(data []byte, id *ocspCertID, issuer *x509.Certificate) (*ocspSingleResponse, error) {
+
+	var response ocspResponse
+	if _, err := asn1.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("invalid ocsp response: %s", err)
+	}
+
+	if response.Status != 0 {
+		return nil, fmt.Errorf("ocsp request failed with status %d", response.Status)
+	}
+
+	if !response.Response.ResponseType.Equal(oidOCSPBasic) {
+		return nil, errors.New("unsupported ocsp response type")
+	}
+
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(response.Response.Response, &basic); err != nil {
+		return nil, fmt.Errorf("invalid ocsp response: %s", err)
+	}
+
+	var tbs ocspResponseData
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &tbs); err != nil {
+		return nil, fmt.Errorf("invalid ocsp response data: %s", err)
+	}
+
+	algorithm, ok := ocspSignatureAlgorithms[basic.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported ocsp signature algorithm %s", basic.SignatureAlgorithm.Algorithm)
+	}
+
+	signer, err := ocspSigner(basic.Certificates, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := signer.CheckSignature(algorithm, basic.TBSResponseData.FullBytes, basic.Signature.RightAlign()); err != nil {
+		return nil, fmt.Errorf("invalid ocsp signature: %s", err)
+	}
+
+	for i := range tbs.Responses {
+		single := &tbs.Responses[i]
+		if single.CertID.SerialNumber.Cmp(id.SerialNumber) != 0 ||
+			!bytes.Equal(single.CertID.NameHash, id.NameHash) ||
+			!bytes.Equal(single.CertID.IssuerKeyHash, id.IssuerKeyHash) {
+			continue
+		}
+		if !single.NextUpdate.IsZero() && time.Now().After(single.NextUpdate) {
+			return nil, errors.New("ocsp response expired")
+		}
+		return single, nil
+	}
+
+	return nil, errors.New("no ocsp response for the certificate")
+}
+
+// ocspSigner returns the signer of the response. It is the issuer or a
+// responder certificate delegated by the issuer.
+func ocspSigner(certificates []asn1.RawValue, issuer *x509.Certificate) (*x509.Certificate, error) {
+
+	if len(certificates) == 0 {
+		return issuer, nil
+	}
+
+	responder, err := x509.ParseCertificate(certificates[0].FullBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ocsp responder certificate: %s", err)
+	}
+
+	if bytes.Equal(responder.Raw, issuer.Raw) {
+		return issuer, nil
+	}
+
+	if err := responder.CheckSignatureFrom(issuer); err != nil {
+		return nil, fmt.Errorf("ocsp responder not delegated by the issuer: %s", err)
+	}
+
+	for _, usage := range responder.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageOCSPSigning {
+			return responder, nil
+		}
+	}
+
+	return nil, errors.New("ocsp responder not authorized to sign responses")
+}
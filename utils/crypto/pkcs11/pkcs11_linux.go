@@ -0,0 +1,367 @@
+package pkcs11
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	cryptoutils "github.com/aporeto-inc/trireme-lib/utils/crypto"
+	"github.com/miekg/pkcs11"
+)
+
+// Constants of PKCS#11 3.0 that are not defined by the library
+const (
+	ckmEDDSA = 0x00001057
+)
+
+// Object identifiers of the curves of the keys
+var (
+	oidPublicKeyECDSA   = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidPublicKeyEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+	oidNamedCurveP256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidNamedCurveP384   = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+)
+
+func init() {
+	cryptoutils.RegisterKeyStore(Scheme, func(uri string) (crypto.Signer, error) {
+		signer, err := NewSigner(uri)
+		if err != nil {
+			return nil, err
+		}
+		return signer, nil
+	})
+}
+
+var (
+	modules     = map[string]*pkcs11.Ctx{}
+	modulesLock sync.Mutex
+)
+
+// Signer is a private key of a PKCS#11 module. The key is used by the
+// module and never leaves it.
+type Signer struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	key     pkcs11.ObjectHandle
+	public  crypto.PublicKey
+
+	sync.Mutex
+}
+
+// NewSigner opens the private key identified by the PKCS#11 URI. The
+// public key is read from the public key object with the same label or
+// identifier.
+func NewSigner(uri string) (*Signer, error) {
+
+	u, err := ParseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, err := loadModule(u.ModulePath)
+	if err != nil {
+		return nil, err
+	}
+
+	slot, err := findSlot(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open session: %s", err)
+	}
+
+	s := &Signer{
+		ctx:     ctx,
+		session: session,
+	}
+
+	if err := s.open(u); err != nil {
+		ctx.CloseSession(session) // nolint: errcheck
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Public implements the crypto.Signer interface.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign implements the crypto.Signer interface. The ECDSA keys sign the
+// digest and return ASN.1 signatures. The Ed25519 keys sign the message.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+
+	var mechanism uint
+
+	switch s.public.(type) {
+	case *ecdsa.PublicKey:
+		if opts.HashFunc() == 0 || len(digest) != opts.HashFunc().Size() {
+			return nil, errors.New("invalid digest")
+		}
+		mechanism = pkcs11.CKM_ECDSA
+	case ed25519.PublicKey:
+		if opts.HashFunc() != 0 {
+			return nil, errors.New("ed25519 keys sign the message")
+		}
+		mechanism = ckmEDDSA
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.ctx.SignInit(s.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}, s.key); err != nil {
+		return nil, fmt.Errorf("unable to sign: %s", err)
+	}
+
+	signature, err := s.ctx.Sign(s.session, digest)
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign: %s", err)
+	}
+
+	if mechanism != pkcs11.CKM_ECDSA {
+		return signature, nil
+	}
+
+	// The module returns r | s
+	if len(signature)%2 != 0 {
+		return nil, errors.New("invalid ecdsa signature")
+	}
+	size := len(signature) / 2
+
+	return asn1.Marshal(struct{ R, S *big.Int }{
+		R: new(big.Int).SetBytes(signature[:size]),
+		S: new(big.Int).SetBytes(signature[size:]),
+	})
+}
+
+// ECDH returns the secret agreed between the private key and the remote
+// public key. The secret is derived by the module and only the secret is
+// extracted.
+func (s *Signer) ECDH(remote *ecdh.PublicKey) ([]byte, error) {
+
+	public, ok := s.public.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("key does not support ecdh")
+	}
+
+	size := (public.Curve.Params().BitSize + 7) / 8
+
+	mechanism := pkcs11.NewMechanism(pkcs11.CKM_ECDH1_DERIVE, pkcs11.NewECDH1DeriveParams(pkcs11.CKD_NULL, nil, remote.Bytes()))
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_GENERIC_SECRET),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, size),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, true),
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	secret, err := s.ctx.DeriveKey(s.session, []*pkcs11.Mechanism{mechanism}, s.key, template)
+	if err != nil {
+		return nil, fmt.Errorf("unable to derive secret: %s", err)
+	}
+	defer s.ctx.DestroyObject(s.session, secret) // nolint: errcheck
+
+	attributes, err := s.ctx.GetAttributeValue(s.session, secret, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_VALUE, nil)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read secret: %s", err)
+	}
+
+	return attributes[0].Value, nil
+}
+
+// Close closes the session of the signer
+func (s *Signer) Close() error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	return s.ctx.CloseSession(s.session)
+}
+
+// open logs in and finds the keys of the URI
+func (s *Signer) open(u *URI) error {
+
+	if u.PIN != "" {
+		if err := s.ctx.Login(s.session, pkcs11.CKU_USER, u.PIN); err != nil && err != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+			return fmt.Errorf("unable to login: %s", err)
+		}
+	}
+
+	key, err := s.findObject(pkcs11.CKO_PRIVATE_KEY, u)
+	if err != nil {
+		return err
+	}
+
+	publicKey, err := s.findObject(pkcs11.CKO_PUBLIC_KEY, u)
+	if err != nil {
+		return err
+	}
+
+	attributes, err := s.ctx.GetAttributeValue(s.session, publicKey, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to read public key: %s", err)
+	}
+
+	public, err := parsePublicKey(attributes[0].Value, attributes[1].Value)
+	if err != nil {
+		return err
+	}
+
+	s.key = key
+	s.public = public
+
+	return nil
+}
+
+// findObject returns the only object of the class with the label and the
+// identifier of the URI
+func (s *Signer) findObject(class uint, u *URI) (pkcs11.ObjectHandle, error) {
+
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, class)}
+	if u.Object != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, u.Object))
+	}
+	if len(u.ID) > 0 {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, u.ID))
+	}
+
+	if err := s.ctx.FindObjectsInit(s.session, template); err != nil {
+		return 0, fmt.Errorf("unable to find key: %s", err)
+	}
+
+	objects, _, err := s.ctx.FindObjects(s.session, 2)
+	if ferr := s.ctx.FindObjectsFinal(s.session); err == nil {
+		err = ferr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("unable to find key: %s", err)
+	}
+
+	switch len(objects) {
+	case 0:
+		return 0, errors.New("key not found")
+	case 1:
+		return objects[0], nil
+	default:
+		return 0, errors.New("more than one key matches the uri")
+	}
+}
+
+// loadModule loads and initializes the module once for all the keys
+func loadModule(path string) (*pkcs11.Ctx, error) {
+
+	modulesLock.Lock()
+	defer modulesLock.Unlock()
+
+	if ctx, ok := modules[path]; ok {
+		return ctx, nil
+	}
+
+	ctx := pkcs11.New(path)
+	if ctx == nil {
+		return nil, fmt.Errorf("unable to load pkcs11 module %s", path)
+	}
+
+	if err := ctx.Initialize(); err != nil && err != pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		return nil, fmt.Errorf("unable to initialize pkcs11 module %s: %s", path, err)
+	}
+
+	modules[path] = ctx
+
+	return ctx, nil
+}
+
+// findSlot returns the slot of the token of the URI. The first token is
+// used if the URI does not identify a token.
+func findSlot(ctx *pkcs11.Ctx, u *URI) (uint, error) {
+
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("unable to list slots: %s", err)
+	}
+
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			return 0, fmt.Errorf("unable to read token: %s", err)
+		}
+		if u.Token != "" && info.Label != u.Token {
+			continue
+		}
+		if u.Serial != "" && info.SerialNumber != u.Serial {
+			continue
+		}
+		return slot, nil
+	}
+
+	return 0, errors.New("token not found")
+}
+
+// parsePublicKey parses the public key from the parameters and the point
+// of the public key object.
+func parsePublicKey(params, point []byte) (crypto.PublicKey, error) {
+
+	// The point is usually encoded in an octet string
+	var raw []byte
+	if rest, err := asn1.Unmarshal(point, &raw); err == nil && len(rest) == 0 {
+		point = raw
+	}
+
+	var curve asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(params, &curve); err != nil {
+		// The Ed25519 curve can also be given by its name
+		var name string
+		if _, err := asn1.Unmarshal(params, &name); err != nil || name != "edwards25519" {
+			return nil, errors.New("unsupported curve")
+		}
+		curve = oidPublicKeyEd25519
+	}
+
+	if curve.Equal(oidPublicKeyEd25519) {
+		if len(point) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 public key")
+		}
+		return ed25519.PublicKey(point), nil
+	}
+
+	if !curve.Equal(oidNamedCurveP256) && !curve.Equal(oidNamedCurveP384) {
+		return nil, fmt.Errorf("unsupported curve %s", curve)
+	}
+
+	// Let the x509 package validate the point
+	spki, err := asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidPublicKeyECDSA,
+			Parameters: asn1.RawValue{FullBytes: params},
+		},
+		PublicKey: asn1.BitString{Bytes: point, BitLength: 8 * len(point)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParsePKIXPublicKey(spki)
+}
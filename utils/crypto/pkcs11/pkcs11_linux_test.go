@@ -0,0 +1,165 @@
+package pkcs11
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/asn1"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	cryptoutils "github.com/aporeto-inc/trireme-lib/utils/crypto"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/miekg/pkcs11"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newSoftHSMToken initializes a SoftHSM token with a P-256 key and returns
+// the URI of the key. The test is skipped if SOFTHSM2_MODULE is not the
+// path of the SoftHSM library.
+func newSoftHSMToken(t *testing.T) string {
+
+	module := os.Getenv("SOFTHSM2_MODULE")
+	if module == "" {
+		t.Skip("SOFTHSM2_MODULE is not set")
+	}
+	if _, err := exec.LookPath("softhsm2-util"); err != nil {
+		t.Skip("softhsm2-util is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "softhsm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := filepath.Join(dir, "softhsm2.conf")
+	if err := ioutil.WriteFile(config, []byte("directories.tokendir = "+dir+"\nobjectstore.backend = file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("SOFTHSM2_CONF", config) // nolint: errcheck
+
+	if out, err := exec.Command("softhsm2-util", "--init-token", "--free", "--label", "trireme", "--pin", "1234", "--so-pin", "5678").CombinedOutput(); err != nil {
+		t.Fatalf("unable to initialize token: %s %s", err, out)
+	}
+
+	uri := "pkcs11:token=trireme;object=enforcer;id=%01?module-path=" + module + "&pin-value=1234"
+
+	u, err := ParseURI(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err := loadModule(module)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slot, err := findSlot(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.CloseSession(session) // nolint: errcheck
+
+	if err := ctx.Login(session, pkcs11.CKU_USER, u.PIN); err != nil {
+		t.Fatal(err)
+	}
+
+	p256, err := asn1.Marshal(oidNamedCurveP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = ctx.GenerateKeyPair(session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, u.Object),
+			pkcs11.NewAttribute(pkcs11.CKA_ID, u.ID),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, p256),
+			pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, u.Object),
+			pkcs11.NewAttribute(pkcs11.CKA_ID, u.ID),
+			pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_DERIVE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return uri
+}
+
+func TestSoftHSMSigner(t *testing.T) {
+
+	uri := newSoftHSMToken(t)
+
+	Convey("Given a key of a SoftHSM token", t, func() {
+
+		signer, err := cryptoutils.OpenKeyStore(uri)
+		So(err, ShouldBeNil)
+		defer signer.(*Signer).Close() // nolint: errcheck
+
+		public, ok := signer.Public().(*ecdsa.PublicKey)
+		So(ok, ShouldBeTrue)
+
+		Convey("Then the tokens should be signed by the module", func() {
+			method, err := cryptoutils.SigningMethod(signer)
+			So(err, ShouldBeNil)
+			So(method.Alg(), ShouldEqual, "ES256")
+
+			token, err := jwt.NewWithClaims(method, &jwt.StandardClaims{Issuer: "test"}).SignedString(signer)
+			So(err, ShouldBeNil)
+
+			_, err = jwt.ParseWithClaims(token, &jwt.StandardClaims{}, func(*jwt.Token) (interface{}, error) {
+				return public, nil
+			})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Then the digests should be signed with ASN.1 signatures", func() {
+			digest := crypto.SHA256.New()
+			digest.Write([]byte("data")) // nolint: errcheck
+
+			signature, err := signer.Sign(rand.Reader, digest.Sum(nil), crypto.SHA256)
+			So(err, ShouldBeNil)
+			So(ecdsa.VerifyASN1(public, digest.Sum(nil), signature), ShouldBeTrue)
+
+			_, err = signer.Sign(rand.Reader, []byte("short"), crypto.SHA256)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then the secret should be agreed by the module", func() {
+			remote, err := ecdh.P256().GenerateKey(rand.Reader)
+			So(err, ShouldBeNil)
+
+			secret, err := signer.(*Signer).ECDH(remote.PublicKey())
+			So(err, ShouldBeNil)
+
+			local, err := public.ECDH()
+			So(err, ShouldBeNil)
+			expected, err := remote.ECDH(local)
+			So(err, ShouldBeNil)
+			So(secret, ShouldResemble, expected)
+		})
+
+		Convey("Then the missing keys should be rejected", func() {
+			_, err := NewSigner("pkcs11:token=trireme;object=missing?module-path=" + os.Getenv("SOFTHSM2_MODULE") + "&pin-value=1234")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,44 @@
+// +build !linux
+
+package pkcs11
+
+import (
+	"crypto"
+	"errors"
+	"io"
+
+	cryptoutils "github.com/aporeto-inc/trireme-lib/utils/crypto"
+)
+
+func init() {
+	cryptoutils.RegisterKeyStore(Scheme, func(uri string) (crypto.Signer, error) {
+		signer, err := NewSigner(uri)
+		if err != nil {
+			return nil, err
+		}
+		return signer, nil
+	})
+}
+
+// Signer is a private key of a PKCS#11 module
+type Signer struct{}
+
+// NewSigner is not supported on this platform
+func NewSigner(uri string) (*Signer, error) {
+	return nil, errors.New("pkcs11 is not supported on this platform")
+}
+
+// Public implements the crypto.Signer interface.
+func (s *Signer) Public() crypto.PublicKey {
+	return nil
+}
+
+// Sign implements the crypto.Signer interface.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return nil, errors.New("pkcs11 is not supported on this platform")
+}
+
+// Close closes the session of the signer
+func (s *Signer) Close() error {
+	return nil
+}
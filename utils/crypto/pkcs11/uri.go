@@ -0,0 +1,128 @@
+package pkcs11
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// Scheme is the scheme of the PKCS#11 URIs
+const Scheme = "pkcs11"
+
+// URI identifies a private key in a PKCS#11 module as defined by RFC 7512.
+// For example:
+//
+//	pkcs11:token=trireme;object=enforcer?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-value=1234
+type URI struct {
+	// ModulePath is the path of the library of the module
+	ModulePath string
+	// Token is the label of the token
+	Token string
+	// Serial is the serial number of the token
+	Serial string
+	// Object is the label of the key
+	Object string
+	// ID is the identifier of the key
+	ID []byte
+	// PIN is the PIN of the user
+	PIN string
+}
+
+// ParseURI parses a PKCS#11 URI. The PIN can be given in the URI or read
+// from the file of the pin-source attribute.
+func ParseURI(uri string) (*URI, error) {
+
+	if !strings.HasPrefix(uri, Scheme+":") {
+		return nil, fmt.Errorf("invalid pkcs11 uri %s", uri)
+	}
+
+	path := strings.TrimPrefix(uri, Scheme+":")
+	query := ""
+	if i := strings.Index(path, "?"); i >= 0 {
+		path, query = path[:i], path[i+1:]
+	}
+
+	u := &URI{}
+	pinSource := ""
+
+	for _, attribute := range splitAttributes(path, ";") {
+		name, value, err := parseAttribute(attribute)
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case "token":
+			u.Token = value
+		case "serial":
+			u.Serial = value
+		case "object":
+			u.Object = value
+		case "id":
+			u.ID = []byte(value)
+		}
+	}
+
+	for _, attribute := range splitAttributes(query, "&") {
+		name, value, err := parseAttribute(attribute)
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case "module-path":
+			u.ModulePath = value
+		case "pin-value":
+			u.PIN = value
+		case "pin-source":
+			pinSource = value
+		}
+	}
+
+	if u.ModulePath == "" {
+		return nil, errors.New("pkcs11 uri must have a module-path")
+	}
+
+	if u.Object == "" && len(u.ID) == 0 {
+		return nil, errors.New("pkcs11 uri must have an object or an id")
+	}
+
+	if pinSource != "" {
+		if u.PIN != "" {
+			return nil, errors.New("pkcs11 uri can not have both a pin-value and a pin-source")
+		}
+		pin, err := ioutil.ReadFile(strings.TrimPrefix(pinSource, "file:"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read pin: %s", err)
+		}
+		u.PIN = strings.TrimRight(string(pin), "\r\n")
+	}
+
+	return u, nil
+}
+
+// splitAttributes splits the attributes of a component of the URI
+func splitAttributes(component, separator string) []string {
+
+	if component == "" {
+		return nil
+	}
+
+	return strings.Split(component, separator)
+}
+
+// parseAttribute returns the name and the unescaped value of an attribute
+func parseAttribute(attribute string) (string, string, error) {
+
+	i := strings.Index(attribute, "=")
+	if i <= 0 {
+		return "", "", fmt.Errorf("invalid pkcs11 uri attribute %s", attribute)
+	}
+
+	value, err := url.PathUnescape(attribute[i+1:])
+	if err != nil {
+		return "", "", fmt.Errorf("invalid pkcs11 uri attribute %s: %s", attribute, err)
+	}
+
+	return attribute[:i], value, nil
+}
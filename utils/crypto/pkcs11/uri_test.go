@@ -0,0 +1,61 @@
+package pkcs11
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseURI(t *testing.T) {
+
+	Convey("Given pkcs11 uris", t, func() {
+
+		Convey("Then the attributes of the path and of the query should be parsed", func() {
+			u, err := ParseURI("pkcs11:token=trireme;serial=42;object=enforcer%20key;id=%01%02?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-value=1234")
+			So(err, ShouldBeNil)
+			So(u, ShouldResemble, &URI{
+				ModulePath: "/usr/lib/softhsm/libsofthsm2.so",
+				Token:      "trireme",
+				Serial:     "42",
+				Object:     "enforcer key",
+				ID:         []byte{1, 2},
+				PIN:        "1234",
+			})
+		})
+
+		Convey("Then the pin should be read from the pin source", func() {
+			dir, err := ioutil.TempDir("", "pkcs11")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir) // nolint: errcheck
+
+			pinFile := filepath.Join(dir, "pin")
+			So(ioutil.WriteFile(pinFile, []byte("5678\n"), 0600), ShouldBeNil)
+
+			u, err := ParseURI("pkcs11:object=enforcer?module-path=/lib/p11.so&pin-source=file:" + pinFile)
+			So(err, ShouldBeNil)
+			So(u.PIN, ShouldEqual, "5678")
+
+			_, err = ParseURI("pkcs11:object=enforcer?module-path=/lib/p11.so&pin-value=1&pin-source=file:" + pinFile)
+			So(err, ShouldNotBeNil)
+
+			_, err = ParseURI("pkcs11:object=enforcer?module-path=/lib/p11.so&pin-source=file:" + filepath.Join(dir, "missing"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then the invalid uris should be rejected", func() {
+			for _, uri := range []string{
+				"file:object=enforcer?module-path=/lib/p11.so",
+				"pkcs11:object=enforcer",
+				"pkcs11:token=trireme?module-path=/lib/p11.so",
+				"pkcs11:object?module-path=/lib/p11.so",
+				"pkcs11:object=%zz?module-path=/lib/p11.so",
+			} {
+				_, err := ParseURI(uri)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
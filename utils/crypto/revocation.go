@@ -0,0 +1,532 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultRevocationRefresh is the default interval between two checks
+	// of the CRL files and of the OCSP status of a certificate
+	defaultRevocationRefresh = time.Minute
+	// defaultOCSPTimeout is the default timeout of the OCSP requests
+	defaultOCSPTimeout = 2 * time.Second
+	// ocspRetryInterval is the maximum interval between two OCSP requests
+	// for a certificate after a failure
+	ocspRetryInterval = 10 * time.Second
+	// revocationQueueSize is the number of certificates that can wait for
+	// the background refresh
+	revocationQueueSize = 256
+)
+
+var (
+	// ErrRevoked is the error of the certificates that are revoked
+	ErrRevoked = errors.New("certificate revoked")
+	// ErrRevocationUnknown is the error of the certificates whose revocation
+	// status is not known when the checks fail closed
+	ErrRevocationUnknown = errors.New("certificate revocation status unknown")
+)
+
+// IsRevoked returns true if the error is caused by a revoked certificate
+func IsRevoked(err error) bool {
+	return errors.Is(err, ErrRevoked)
+}
+
+// RevocationConfig is the configuration of the revocation checks
+type RevocationConfig struct {
+	// CRLPaths are the paths of the CRLs in PEM or DER format
+	CRLPaths []string
+	// RefreshInterval is the interval between two checks of the CRL files
+	// and of the OCSP status of a certificate. The files are only loaded
+	// again if they changed.
+	RefreshInterval time.Duration
+	// OCSPURL is the URL of an optional OCSP responder
+	OCSPURL string
+	// OCSPTimeout is the timeout of the OCSP requests
+	OCSPTimeout time.Duration
+	// FailClosed rejects the certificates whose OCSP status is not known,
+	// because it was not received yet or the responder is not available.
+	// They are accepted by default.
+	FailClosed bool
+}
+
+// revocationList is a CRL with the serial numbers it revokes
+type revocationList struct {
+	list     *x509.RevocationList
+	serials  map[string]bool
+	verified map[string]bool
+}
+
+// ocspStatus is a cached status of the OCSP responder
+type ocspStatus struct {
+	revoked bool
+	// unknown is true if the responder did not give a valid status
+	unknown bool
+	expiry  time.Time
+}
+
+// revocationRequest is a certificate whose revocation status is refreshed
+// in the background. The certificate is nil if only the signatures of the
+// CRLs must be verified with the issuer.
+type revocationRequest struct {
+	cert   *x509.Certificate
+	issuer *x509.Certificate
+}
+
+// RevocationChecker checks the revocation of the certificates with CRLs
+// loaded from local files and with an optional OCSP responder. The CRL
+// files are trusted like the CA files. Their signature is verified when the
+// issuer of the certificate is known. The OCSP responder is only queried
+// for the certificates whose issuer is known.
+//
+// The checks only look up the current state. The CRL files, the signatures
+// of the CRLs and the OCSP statuses are refreshed by a background goroutine
+// that runs while there are refreshes to do, so that the checks never wait
+// for the files or the responder.
+type RevocationChecker struct {
+	config   RevocationConfig
+	client   *http.Client
+	lists    []*revocationList
+	modTimes map[string]time.Time
+	loaded   time.Time
+	statuses map[string]*ocspStatus
+	// issuers are the issuers whose signatures of the CRLs are verified
+	issuers  map[string]*x509.Certificate
+	requests chan *revocationRequest
+	running  int32
+	sync.RWMutex
+}
+
+// NewRevocationChecker creates a revocation checker and loads the CRLs
+func NewRevocationChecker(config *RevocationConfig) (*RevocationChecker, error) {
+
+	if config == nil {
+		return nil, errors.New("no revocation configuration provided")
+	}
+
+	r := &RevocationChecker{
+		config:   *config,
+		statuses: map[string]*ocspStatus{},
+		issuers:  map[string]*x509.Certificate{},
+		requests: make(chan *revocationRequest, revocationQueueSize),
+	}
+
+	if r.config.RefreshInterval <= 0 {
+		r.config.RefreshInterval = defaultRevocationRefresh
+	}
+
+	if r.config.OCSPTimeout <= 0 {
+		r.config.OCSPTimeout = defaultOCSPTimeout
+	}
+
+	r.client = &http.Client{Timeout: r.config.OCSPTimeout}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Config returns the configuration of the checker
+func (r *RevocationChecker) Config() *RevocationConfig {
+	config := r.config
+	return &config
+}
+
+// Reload loads the CRL files. The current CRLs are kept if one of the files
+// is invalid. The signatures of the new CRLs are verified with the issuers
+// that are already known.
+func (r *RevocationChecker) Reload() error {
+
+	lists := []*revocationList{}
+	modTimes := map[string]time.Time{}
+
+	for _, path := range r.config.CRLPaths {
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("unable to read crl %s: %s", path, err)
+		}
+		modTimes[path] = info.ModTime()
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("unable to read crl %s: %s", path, err)
+		}
+
+		crls, err := parseRevocationLists(data)
+		if err != nil {
+			return fmt.Errorf("invalid crl %s: %s", path, err)
+		}
+
+		for _, crl := range crls {
+			if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+				zap.L().Warn("CRL is past its next update", zap.String("path", path))
+			}
+			lists = append(lists, newRevocationList(crl))
+		}
+	}
+
+	r.RLock()
+	issuers := make([]*x509.Certificate, 0, len(r.issuers))
+	for _, issuer := range r.issuers {
+		issuers = append(issuers, issuer)
+	}
+	r.RUnlock()
+
+	for _, l := range lists {
+		for _, issuer := range issuers {
+			l.verified[string(issuer.Raw)] = l.list.CheckSignatureFrom(issuer) == nil
+		}
+	}
+
+	r.Lock()
+	r.lists = lists
+	r.modTimes = modTimes
+	r.loaded = time.Now()
+	r.Unlock()
+
+	return nil
+}
+
+// CheckChain checks every certificate of a verified chain with its issuer.
+// The root of the chain is not checked.
+func (r *RevocationChecker) CheckChain(chain []*x509.Certificate) error {
+
+	for i := 0; i < len(chain)-1; i++ {
+		if err := r.Check(chain[i], chain[i+1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Check returns an ErrRevoked error if the certificate is revoked, and an
+// ErrRevocationUnknown error if its status is not known and the checks fail
+// closed. The issuer may be nil if it is not known.
+func (r *RevocationChecker) Check(cert, issuer *x509.Certificate) error {
+
+	if err := r.checkLists(cert.RawIssuer, cert.AuthorityKeyId, cert.SerialNumber, issuer); err != nil {
+		return err
+	}
+
+	if r.config.OCSPURL == "" || issuer == nil {
+		return nil
+	}
+
+	return r.checkOCSP(cert, issuer)
+}
+
+// CheckSerial returns an ErrRevoked error if the serial number is revoked
+// by the CRL of the authority with the key id.
+func (r *RevocationChecker) CheckSerial(authorityKeyID []byte, serial *big.Int) error {
+
+	if len(authorityKeyID) == 0 || serial == nil {
+		return nil
+	}
+
+	return r.checkLists(nil, authorityKeyID, serial, nil)
+}
+
+// checkLists checks the serial number with the CRLs of the issuer. The
+// signature of a CRL that revokes the serial number is verified here only
+// if the issuer was not seen before, and the issuer is then queued for the
+// background refresh.
+func (r *RevocationChecker) checkLists(rawIssuer, authorityKeyID []byte, serial *big.Int, issuer *x509.Certificate) error {
+
+	revoked := false
+	unverified := []*revocationList{}
+
+	r.RLock()
+	expired := time.Since(r.loaded) > r.config.RefreshInterval
+	for _, l := range r.lists {
+
+		if !l.matches(rawIssuer, authorityKeyID) || !l.serials[serial.String()] {
+			continue
+		}
+
+		if issuer == nil {
+			revoked = true
+			break
+		}
+
+		verified, ok := l.verified[string(issuer.Raw)]
+		if !ok {
+			unverified = append(unverified, l)
+			continue
+		}
+
+		if verified {
+			revoked = true
+			break
+		}
+	}
+	r.RUnlock()
+
+	if len(unverified) > 0 {
+		r.schedule(&revocationRequest{issuer: issuer})
+	} else if expired {
+		r.schedule(nil)
+	}
+
+	for i := 0; i < len(unverified) && !revoked; i++ {
+		revoked = unverified[i].list.CheckSignatureFrom(issuer) == nil
+	}
+
+	if revoked {
+		return fmt.Errorf("serial %s: %w", serial.String(), ErrRevoked)
+	}
+
+	return nil
+}
+
+// checkOCSP checks the cached status of the certificate. The status is
+// queried in the background if it is not known or if it expired, and the
+// expired status is used until then.
+func (r *RevocationChecker) checkOCSP(cert, issuer *x509.Certificate) error {
+
+	r.RLock()
+	status, ok := r.statuses[ocspKey(cert, issuer)]
+	r.RUnlock()
+
+	if !ok || time.Now().After(status.expiry) {
+		r.schedule(&revocationRequest{cert: cert, issuer: issuer})
+	}
+
+	if ok && status.revoked {
+		return fmt.Errorf("serial %s: %w", cert.SerialNumber.String(), ErrRevoked)
+	}
+
+	if (!ok || status.unknown) && r.config.FailClosed {
+		return fmt.Errorf("serial %s: %w", cert.SerialNumber.String(), ErrRevocationUnknown)
+	}
+
+	return nil
+}
+
+// schedule queues a request for the background refresh and starts the
+// refresh if it is not running. The request is dropped if the queue is full
+// and is queued again by a later check.
+func (r *RevocationChecker) schedule(request *revocationRequest) {
+
+	if request != nil {
+		select {
+		case r.requests <- request:
+		default:
+		}
+	}
+
+	if atomic.CompareAndSwapInt32(&r.running, 0, 1) {
+		go r.refresh()
+	}
+}
+
+// refresh loads the CRL files again if they changed and processes the
+// queued requests until there are none left.
+func (r *RevocationChecker) refresh() {
+
+	for {
+		r.refreshLists()
+
+		for empty := false; !empty; {
+			select {
+			case request := <-r.requests:
+				if request.issuer != nil {
+					r.verifyLists(request.issuer)
+				}
+				if request.cert != nil {
+					r.refreshOCSP(request.cert, request.issuer)
+				}
+			default:
+				empty = true
+			}
+		}
+
+		atomic.StoreInt32(&r.running, 0)
+
+		// The requests queued after the queue was emptied did not start a
+		// refresh since this one was still running
+		if len(r.requests) == 0 || !atomic.CompareAndSwapInt32(&r.running, 0, 1) {
+			return
+		}
+	}
+}
+
+// refreshLists loads the CRL files again if they changed since the last
+// refresh
+func (r *RevocationChecker) refreshLists() {
+
+	r.RLock()
+	expired := time.Since(r.loaded) > r.config.RefreshInterval
+	modTimes := r.modTimes
+	r.RUnlock()
+
+	if !expired {
+		return
+	}
+
+	changed := false
+	for path, modTime := range modTimes {
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			changed = true
+			break
+		}
+	}
+
+	if !changed {
+		r.Lock()
+		r.loaded = time.Now()
+		r.Unlock()
+		return
+	}
+
+	if err := r.Reload(); err != nil {
+		zap.L().Error("Unable to reload CRLs", zap.Error(err))
+		r.Lock()
+		r.loaded = time.Now()
+		r.Unlock()
+	}
+}
+
+// verifyLists verifies the signatures of the CRLs with the issuer
+func (r *RevocationChecker) verifyLists(issuer *x509.Certificate) {
+
+	key := string(issuer.Raw)
+
+	r.RLock()
+	unverified := []*revocationList{}
+	for _, l := range r.lists {
+		if _, ok := l.verified[key]; !ok {
+			unverified = append(unverified, l)
+		}
+	}
+	r.RUnlock()
+
+	verified := make([]bool, len(unverified))
+	for i, l := range unverified {
+		verified[i] = l.list.CheckSignatureFrom(issuer) == nil
+	}
+
+	r.Lock()
+	for i, l := range unverified {
+		l.verified[key] = verified[i]
+	}
+	r.issuers[key] = issuer
+	r.Unlock()
+}
+
+// refreshOCSP queries the OCSP responder for the status of the certificate
+// if it is not known or expired. The status is cached until the next update
+// of the response, and a certificate stays revoked if the responder is not
+// available anymore.
+func (r *RevocationChecker) refreshOCSP(cert, issuer *x509.Certificate) {
+
+	key := ocspKey(cert, issuer)
+
+	r.RLock()
+	previous, ok := r.statuses[key]
+	r.RUnlock()
+
+	if ok && time.Now().Before(previous.expiry) {
+		return
+	}
+
+	status := &ocspStatus{
+		expiry: time.Now().Add(r.config.RefreshInterval),
+	}
+
+	revoked, nextUpdate, err := queryOCSP(r.client, r.config.OCSPURL, cert, issuer)
+	if err != nil {
+		zap.L().Warn("Unable to check certificate with the OCSP responder", zap.Error(err))
+		status.revoked = ok && previous.revoked
+		status.unknown = true
+		nextUpdate = time.Now().Add(ocspRetryInterval)
+	} else {
+		status.revoked = revoked
+	}
+
+	if !nextUpdate.IsZero() && nextUpdate.Before(status.expiry) {
+		status.expiry = nextUpdate
+	}
+
+	r.Lock()
+	r.statuses[key] = status
+	r.Unlock()
+}
+
+// ocspKey returns the key of the status of a certificate
+func ocspKey(cert, issuer *x509.Certificate) string {
+	return string(issuer.RawSubjectPublicKeyInfo) + cert.SerialNumber.String()
+}
+
+// newRevocationList indexes the serial numbers of the CRL
+func newRevocationList(crl *x509.RevocationList) *revocationList {
+
+	l := &revocationList{
+		list:     crl,
+		serials:  map[string]bool{},
+		verified: map[string]bool{},
+	}
+
+	for _, entry := range crl.RevokedCertificateEntries {
+		l.serials[entry.SerialNumber.String()] = true
+	}
+
+	return l
+}
+
+// matches returns true if the CRL is the CRL of the issuer
+func (l *revocationList) matches(rawIssuer, authorityKeyID []byte) bool {
+
+	if len(rawIssuer) > 0 && bytes.Equal(l.list.RawIssuer, rawIssuer) {
+		return true
+	}
+
+	return len(authorityKeyID) > 0 && bytes.Equal(l.list.AuthorityKeyId, authorityKeyID)
+}
+
+// parseRevocationLists parses the CRLs of a PEM or DER buffer
+func parseRevocationLists(data []byte) ([]*x509.RevocationList, error) {
+
+	block, rest := pem.Decode(data)
+	if block == nil {
+		crl, err := x509.ParseRevocationList(data)
+		if err != nil {
+			return nil, err
+		}
+		return []*x509.RevocationList{crl}, nil
+	}
+
+	crls := []*x509.RevocationList{}
+	for ; block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		crls = append(crls, crl)
+	}
+
+	if len(crls) == 0 {
+		return nil, errors.New("no crl found")
+	}
+
+	return crls, nil
+}
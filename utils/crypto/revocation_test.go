@@ -0,0 +1,289 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/utils/testpki"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// ocspResponder is a local OCSP responder that revokes a set of serial
+// numbers. The responses are signed by the signer.
+func ocspResponder(issuer *x509.Certificate, signer crypto.Signer, signerCert *x509.Certificate, revoked map[int64]bool) *httptest.Server {
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var request ocspRequest
+		if _, err := asn1.Unmarshal(data, &request); err != nil || len(request.TBSRequest.RequestList) != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		id := request.TBSRequest.RequestList[0].Cert
+		single := ocspSingleResponse{
+			CertID:     id,
+			ThisUpdate: time.Now().Add(-time.Minute).UTC(),
+			NextUpdate: time.Now().Add(time.Hour).UTC(),
+		}
+		if revoked[id.SerialNumber.Int64()] {
+			single.Revoked = ocspRevokedInfo{RevocationTime: time.Now().Add(-time.Minute).UTC()}
+		} else {
+			single.Good = true
+		}
+
+		keyHash := sha256.Sum256(signerCert.RawSubjectPublicKeyInfo)
+		responderID, _ := asn1.Marshal(keyHash[:]) // nolint: errcheck
+		tbs, err := asn1.Marshal(ocspResponseData{
+			ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: responderID},
+			ProducedAt:  time.Now().UTC(),
+			Responses:   []ocspSingleResponse{single},
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		digest := sha256.Sum256(tbs)
+		signature, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		basic := ocspBasicResponse{
+			TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+			Signature:          asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)},
+		}
+		if signerCert != issuer {
+			basic.Certificates = []asn1.RawValue{{FullBytes: signerCert.Raw}}
+		}
+
+		basicDER, err := asn1.Marshal(basic)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		response, err := asn1.Marshal(ocspResponse{
+			Response: ocspResponseBytes{ResponseType: oidOCSPBasic, Response: basicDER},
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(response) // nolint: errcheck
+	}))
+}
+
+func TestRevocationCRL(t *testing.T) {
+
+	Convey("Given a CA and a CRL file that revokes a certificate", t, func() {
+
+		dir, err := ioutil.TempDir("", "crl")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint: errcheck
+
+		ca := testpki.NewAuthority("ca")
+		revoked := ca.Issue("enforcer", testpki.WithSerial(10)).Cert
+		valid := ca.Issue("enforcer", testpki.WithSerial(11)).Cert
+
+		path := filepath.Join(dir, "ca.crl")
+		So(ioutil.WriteFile(path, ca.CRL(1, 10), 0600), ShouldBeNil)
+
+		r, err := NewRevocationChecker(&RevocationConfig{CRLPaths: []string{path}})
+		So(err, ShouldBeNil)
+
+		Convey("Then the revoked certificate should be rejected", func() {
+			err := r.Check(revoked, ca.Cert)
+			So(err, ShouldNotBeNil)
+			So(IsRevoked(err), ShouldBeTrue)
+
+			So(r.CheckChain([]*x509.Certificate{revoked, ca.Cert}), ShouldNotBeNil)
+			So(IsRevoked(r.CheckSerial(ca.Cert.SubjectKeyId, big.NewInt(10))), ShouldBeTrue)
+		})
+
+		Convey("Then the other certificates should be accepted", func() {
+			So(r.Check(valid, ca.Cert), ShouldBeNil)
+			So(r.Check(valid, nil), ShouldBeNil)
+			So(r.CheckSerial(ca.Cert.SubjectKeyId, big.NewInt(11)), ShouldBeNil)
+		})
+
+		Convey("Then the CRL of another CA with the same name should be ignored", func() {
+			other := testpki.NewAuthority("ca")
+			So(ioutil.WriteFile(path, other.CRL(1, 11), 0600), ShouldBeNil)
+			So(r.Reload(), ShouldBeNil)
+
+			So(r.Check(valid, ca.Cert), ShouldBeNil)
+		})
+
+		Convey("When the CRL file is updated", func() {
+			So(ioutil.WriteFile(path, ca.CRL(2, 10, 11), 0600), ShouldBeNil)
+
+			Convey("Then the new CRL should be used after a reload", func() {
+				So(r.Check(valid, ca.Cert), ShouldBeNil)
+				So(r.Reload(), ShouldBeNil)
+				So(IsRevoked(r.Check(valid, ca.Cert)), ShouldBeTrue)
+			})
+
+			Convey("Then the new CRL should be used after the refresh interval", func() {
+				r.Lock()
+				r.loaded = time.Now().Add(-2 * defaultRevocationRefresh)
+				r.modTimes[path] = time.Time{}
+				r.Unlock()
+
+				So(r.Check(valid, ca.Cert), ShouldBeNil)
+				So(eventually(func() bool { return IsRevoked(r.Check(valid, ca.Cert)) }), ShouldBeTrue)
+			})
+		})
+
+		Convey("When the CRL file is invalid", func() {
+			So(ioutil.WriteFile(path, []byte("garbage"), 0600), ShouldBeNil)
+
+			Convey("Then the reload should fail and keep the current CRL", func() {
+				So(r.Reload(), ShouldNotBeNil)
+				So(IsRevoked(r.Check(revoked, ca.Cert)), ShouldBeTrue)
+			})
+
+			Convey("Then a new checker should not be created", func() {
+				_, err := NewRevocationChecker(&RevocationConfig{CRLPaths: []string{path}})
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+// eventually returns true if the condition becomes true before a timeout
+func eventually(condition func() bool) bool {
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return true
+}
+
+// cachedStatus returns the cached OCSP status of a certificate
+func (r *RevocationChecker) cachedStatus(cert, issuer *x509.Certificate) *ocspStatus {
+
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.statuses[ocspKey(cert, issuer)]
+}
+
+func TestRevocationOCSP(t *testing.T) {
+
+	Convey("Given a CA and an OCSP responder that revokes a certificate", t, func() {
+
+		ca := testpki.NewAuthority("ca")
+		revoked := ca.Issue("enforcer", testpki.WithSerial(10)).Cert
+		valid := ca.Issue("enforcer", testpki.WithSerial(11)).Cert
+
+		Convey("When the responses are signed by the CA", func() {
+			server := ocspResponder(ca.Cert, ca.Key, ca.Cert, map[int64]bool{10: true})
+			defer server.Close()
+
+			r, err := NewRevocationChecker(&RevocationConfig{OCSPURL: server.URL})
+			So(err, ShouldBeNil)
+
+			Convey("Then the revoked certificate should be rejected once its status is received", func() {
+				So(r.Check(revoked, ca.Cert), ShouldBeNil)
+				So(eventually(func() bool { return IsRevoked(r.Check(revoked, ca.Cert)) }), ShouldBeTrue)
+				So(r.Check(valid, ca.Cert), ShouldBeNil)
+			})
+
+			Convey("Then the status should be cached", func() {
+				So(r.Check(valid, ca.Cert), ShouldBeNil)
+				So(eventually(func() bool { return r.cachedStatus(valid, ca.Cert) != nil }), ShouldBeTrue)
+				server.Close()
+				So(r.Check(valid, ca.Cert), ShouldBeNil)
+				So(r.cachedStatus(valid, ca.Cert).unknown, ShouldBeFalse)
+			})
+
+			Convey("Then the certificates of unknown issuers should not be queried", func() {
+				So(r.Check(revoked, nil), ShouldBeNil)
+			})
+		})
+
+		Convey("When the responses are signed by a delegated responder", func() {
+			responder := ca.Issue("responder", testpki.WithSerial(20), testpki.WithUsages(x509.ExtKeyUsageOCSPSigning))
+			server := ocspResponder(ca.Cert, responder.Key, responder.Cert, map[int64]bool{10: true})
+			defer server.Close()
+
+			r, err := NewRevocationChecker(&RevocationConfig{OCSPURL: server.URL})
+			So(err, ShouldBeNil)
+
+			Convey("Then the revoked certificate should be rejected", func() {
+				So(eventually(func() bool { return IsRevoked(r.Check(revoked, ca.Cert)) }), ShouldBeTrue)
+				So(r.Check(valid, ca.Cert), ShouldBeNil)
+			})
+		})
+
+		Convey("When the responses are signed by a responder that is not delegated", func() {
+			responder := ca.Issue("responder", testpki.WithSerial(20))
+			server := ocspResponder(ca.Cert, responder.Key, responder.Cert, map[int64]bool{10: true})
+			defer server.Close()
+
+			r, err := NewRevocationChecker(&RevocationConfig{OCSPURL: server.URL})
+			So(err, ShouldBeNil)
+
+			Convey("Then the responses should be ignored", func() {
+				So(r.Check(revoked, ca.Cert), ShouldBeNil)
+				So(eventually(func() bool { return r.cachedStatus(revoked, ca.Cert) != nil }), ShouldBeTrue)
+				So(r.Check(revoked, ca.Cert), ShouldBeNil)
+			})
+		})
+
+		Convey("When the responder is not available", func() {
+			config := &RevocationConfig{OCSPURL: "http://127.0.0.1:1", OCSPTimeout: time.Second}
+
+			Convey("Then the certificates should be accepted by default", func() {
+				r, err := NewRevocationChecker(config)
+				So(err, ShouldBeNil)
+
+				So(r.Check(revoked, ca.Cert), ShouldBeNil)
+				So(eventually(func() bool { return r.cachedStatus(revoked, ca.Cert) != nil }), ShouldBeTrue)
+				So(r.cachedStatus(revoked, ca.Cert).unknown, ShouldBeTrue)
+				So(r.Check(revoked, ca.Cert), ShouldBeNil)
+			})
+
+			Convey("Then the certificates should be rejected if the checks fail closed", func() {
+				config.FailClosed = true
+				r, err := NewRevocationChecker(config)
+				So(err, ShouldBeNil)
+
+				err = r.Check(valid, ca.Cert)
+				So(err, ShouldNotBeNil)
+				So(IsRevoked(err), ShouldBeFalse)
+				So(eventually(func() bool { return r.cachedStatus(valid, ca.Cert) != nil }), ShouldBeTrue)
+				So(r.Check(valid, ca.Cert), ShouldNotBeNil)
+			})
+		})
+	})
+}
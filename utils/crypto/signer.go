@@ -0,0 +1,101 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Signing methods of the keys that are only available as a crypto.Signer,
+// like the keys of an HSM. They sign with the signer and verify with the
+// standard methods, so that the tokens do not depend on where the key is
+// stored.
+var (
+	// SigningMethodSignerES256 signs the JWTs with a P-256 signer
+	SigningMethodSignerES256 = &signingMethodSigner{SigningMethod: jwt.SigningMethodES256, hash: crypto.SHA256, keySize: 32}
+	// SigningMethodSignerES384 signs the JWTs with a P-384 signer
+	SigningMethodSignerES384 = &signingMethodSigner{SigningMethod: jwt.SigningMethodES384, hash: crypto.SHA384, keySize: 48}
+	// SigningMethodSignerEdDSA signs the JWTs with an Ed25519 signer
+	SigningMethodSignerEdDSA = &signingMethodSigner{SigningMethod: SigningMethodEdDSA}
+)
+
+// ecdsaSignature is the ASN.1 encoding of the ECDSA signatures returned by
+// the signers.
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// signingMethodSigner implements jwt.SigningMethod with a crypto.Signer.
+// Verify and Alg are the ones of the standard method.
+type signingMethodSigner struct {
+	jwt.SigningMethod
+	hash    crypto.Hash
+	keySize int
+}
+
+// Sign implements the jwt.SigningMethod interface. The key must be a
+// crypto.Signer.
+func (m *signingMethodSigner) Sign(signingString string, key interface{}) (string, error) {
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	// Ed25519 signs the message and not its hash
+	if m.hash == 0 {
+		sig, err := signer.Sign(rand.Reader, []byte(signingString), crypto.Hash(0))
+		if err != nil {
+			return "", err
+		}
+		return jwt.EncodeSegment(sig), nil
+	}
+
+	hasher := m.hash.New()
+	hasher.Write([]byte(signingString)) // nolint: errcheck
+
+	der, err := signer.Sign(rand.Reader, hasher.Sum(nil), m.hash)
+	if err != nil {
+		return "", err
+	}
+
+	var sig ecdsaSignature
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return "", fmt.Errorf("invalid ecdsa signature: %s", err)
+	}
+
+	// JWS encodes the signatures as r | s with a fixed size
+	out := make([]byte, 2*m.keySize)
+	sig.R.FillBytes(out[:m.keySize])
+	sig.S.FillBytes(out[m.keySize:])
+
+	return jwt.EncodeSegment(out), nil
+}
+
+// signerSigningMethod returns the signing method of a signer from its public
+// key.
+func signerSigningMethod(signer crypto.Signer) (jwt.SigningMethod, error) {
+
+	switch k := signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return SigningMethodSignerES256, nil
+		case elliptic.P384():
+			return SigningMethodSignerES384, nil
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+		}
+	case ed25519.PublicKey:
+		return SigningMethodSignerEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported signer key type %T", k)
+	}
+}
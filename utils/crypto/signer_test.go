@@ -0,0 +1,66 @@
+package crypto
+
+import (
+	"crypto"
+	"io"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// opaqueSigner hides the private key behind the crypto.Signer interface,
+// like the keys of an HSM.
+type opaqueSigner struct {
+	key crypto.Signer
+}
+
+func (s *opaqueSigner) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+func (s *opaqueSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.key.Sign(rand, digest, opts)
+}
+
+func TestSigningMethodSigner(t *testing.T) {
+
+	Convey("Given signers that do not export their keys", t, func() {
+
+		Convey("Then the tokens should be signed with the signer and verified with the public key", func() {
+			for keyType, alg := range map[string]string{"P-256": "ES256", "P-384": "ES384", "Ed25519": "EdDSA"} {
+				signer := &opaqueSigner{key: generateTestKey(keyType)}
+
+				method, err := SigningMethod(signer)
+				So(err, ShouldBeNil)
+				So(method.Alg(), ShouldEqual, alg)
+
+				token, err := jwt.NewWithClaims(method, &jwt.StandardClaims{Issuer: "test"}).SignedString(signer)
+				So(err, ShouldBeNil)
+
+				size, err := EncodedSignatureSize(signer)
+				So(err, ShouldBeNil)
+				So(len(token)-len(token[:lastDot(token)+1]), ShouldEqual, size)
+
+				claims := &jwt.StandardClaims{}
+				_, err = jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+					return signer.Public(), nil
+				})
+				So(err, ShouldBeNil)
+				So(claims.Issuer, ShouldEqual, "test")
+			}
+		})
+
+		Convey("Then the signers of unsupported keys should be rejected", func() {
+			_, err := SigningMethod(&opaqueSigner{key: generateTestKey("P-521")})
+			So(err, ShouldNotBeNil)
+			_, err = SigningMethod(&opaqueSigner{key: generateTestKey("RSA")})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then a key that is not a signer should be rejected", func() {
+			_, err := SigningMethodSignerES256.Sign("data", []byte("key"))
+			So(err, ShouldEqual, jwt.ErrInvalidKeyType)
+		})
+	})
+}
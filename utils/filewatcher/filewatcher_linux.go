@@ -0,0 +1,71 @@
+// +build linux
+
+package filewatcher
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// watchMask are the inotify events of files written or replaced in a
+// directory. Kubernetes for instance replaces a symlink of the directory.
+const watchMask = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_CREATE | unix.IN_DELETE
+
+// WatchDirectories watches the directories with inotify until the context
+// is done. A notification is sent on the returned channel when files are
+// changed. The channel is closed when the watch ends.
+func WatchDirectories(ctx context.Context, dirs []string) (<-chan struct{}, error) {
+
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize inotify: %s", err)
+	}
+
+	// The non blocking descriptor is handled by the runtime poller so that
+	// closing the file unblocks the reads.
+	file := os.NewFile(uintptr(fd), "inotify")
+
+	for _, dir := range dirs {
+		if _, err := unix.InotifyAddWatch(fd, dir, watchMask); err != nil {
+			file.Close() // nolint
+			return nil, fmt.Errorf("unable to watch %s: %s", dir, err)
+		}
+	}
+
+	changes := make(chan struct{}, 1)
+
+	go func() {
+		<-ctx.Done()
+		file.Close() // nolint
+	}()
+
+	go func() {
+		defer close(changes)
+
+		buffer := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		for {
+			n, err := file.Read(buffer)
+			if err != nil {
+				if ctx.Err() == nil {
+					zap.L().Error("Unable to read inotify events", zap.Error(err))
+				}
+				return
+			}
+
+			if n < unix.SizeofInotifyEvent {
+				continue
+			}
+
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return changes, nil
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+package filewatcher
+
+import (
+	"context"
+	"errors"
+)
+
+// WatchDirectories is not supported on this platform.
+func WatchDirectories(ctx context.Context, dirs []string) (<-chan struct{}, error) {
+	return nil, errors.New("file watching is only supported on linux")
+}
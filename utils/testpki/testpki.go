@@ -0,0 +1,251 @@
+// Package testpki creates in-memory certificate authorities, certificates and
+// CRLs for the tests of the packages that use a PKI. The helpers fail the
+// current goconvey assertion if a certificate can not be created, and must be
+// called in a Convey block.
+package testpki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"sync"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey" // nolint
+)
+
+// KeyGenerator generates the keys of the certificates
+type KeyGenerator func() (crypto.Signer, error)
+
+// GenerateP256 generates a P-256 key. It is the default key generator.
+func GenerateP256() (crypto.Signer, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// settings are the parameters of a certificate
+type settings struct {
+	generate KeyGenerator
+	serial   int64
+	validity time.Duration
+	usages   []x509.ExtKeyUsage
+	uris     []string
+	notCA    bool
+}
+
+// Option is an option of the certificates
+type Option func(*settings)
+
+// WithKeyGenerator generates the key of the certificate with the generator
+func WithKeyGenerator(generate KeyGenerator) Option {
+	return func(s *settings) {
+		s.generate = generate
+	}
+}
+
+// WithSerial sets the serial number of the certificate
+func WithSerial(serial int64) Option {
+	return func(s *settings) {
+		s.serial = serial
+	}
+}
+
+// WithValidity sets the time the certificate is valid for. The certificates
+// are valid for an hour by default.
+func WithValidity(validity time.Duration) Option {
+	return func(s *settings) {
+		s.validity = validity
+	}
+}
+
+// WithUsages sets the extended key usages of a certificate. The certificates
+// are issued for the client and the server authentication by default.
+func WithUsages(usages ...x509.ExtKeyUsage) Option {
+	return func(s *settings) {
+		s.usages = usages
+	}
+}
+
+// WithURIs adds the URI SANs to the certificate
+func WithURIs(uris ...string) Option {
+	return func(s *settings) {
+		s.uris = uris
+	}
+}
+
+// NotCA creates an authority whose certificate is not a CA, for the tests of
+// the rejected authorities
+func NotCA() Option {
+	return func(s *settings) {
+		s.notCA = true
+	}
+}
+
+// newSettings applies the options to the default settings
+func newSettings(serial int64, opts []Option) *settings {
+
+	s := &settings{
+		generate: GenerateP256,
+		serial:   serial,
+		validity: time.Hour,
+		usages:   []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// template returns the template of a certificate with the settings
+func (s *settings) template(name string) *x509.Certificate {
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(s.serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(s.validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	for _, uri := range s.uris {
+		u, err := url.Parse(uri)
+		So(err, ShouldBeNil)
+		template.URIs = append(template.URIs, u)
+	}
+
+	return template
+}
+
+// Certificate is a certificate and its private key
+type Certificate struct {
+	Key  crypto.Signer
+	Cert *x509.Certificate
+}
+
+// newCertificate creates a certificate signed by the parent. The certificate
+// is self signed if the parent is nil.
+func newCertificate(template *x509.Certificate, parent *Certificate, key crypto.Signer) *Certificate {
+
+	issuer, signer := template, key
+	if parent != nil {
+		issuer, signer = parent.Cert, parent.Key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), signer)
+	So(err, ShouldBeNil)
+
+	cert, err := x509.ParseCertificate(der)
+	So(err, ShouldBeNil)
+
+	return &Certificate{Key: key, Cert: cert}
+}
+
+// CertPEM returns the PEM of the certificate
+func (c *Certificate) CertPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Cert.Raw})
+}
+
+// KeyPEM returns the PEM of the private key in PKCS#8 format
+func (c *Certificate) KeyPEM() []byte {
+
+	der, err := x509.MarshalPKCS8PrivateKey(c.Key)
+	So(err, ShouldBeNil)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// Authority is a CA that issues certificates and CRLs
+type Authority struct {
+	Certificate
+
+	serial int64
+	sync.Mutex
+}
+
+// NewAuthority creates a self signed CA. The CA signs the certificates and
+// the CRLs.
+func NewAuthority(name string, opts ...Option) *Authority {
+
+	s := newSettings(1, opts)
+
+	key, err := s.generate()
+	So(err, ShouldBeNil)
+
+	template := s.template(name)
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	template.BasicConstraintsValid = true
+	template.IsCA = !s.notCA
+
+	return &Authority{
+		Certificate: *newCertificate(template, nil, key),
+		serial:      1,
+	}
+}
+
+// Issue issues a certificate. The serial numbers of the certificates are
+// incremented from 2 unless they are set.
+func (a *Authority) Issue(name string, opts ...Option) *Certificate {
+
+	a.Lock()
+	a.serial++
+	serial := a.serial
+	a.Unlock()
+
+	s := newSettings(serial, opts)
+
+	key, err := s.generate()
+	So(err, ShouldBeNil)
+
+	template := s.template(name)
+	template.ExtKeyUsage = s.usages
+
+	return newCertificate(template, &a.Certificate, key)
+}
+
+// CRL returns the PEM of a CRL that revokes the serial numbers
+func (a *Authority) CRL(number int64, serials ...int64) []byte {
+
+	template := &x509.RevocationList{
+		Number:     big.NewInt(number),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+
+	for _, serial := range serials {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   big.NewInt(serial),
+			RevocationTime: time.Now().Add(-time.Minute),
+		})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, template, a.Cert, a.Key)
+	So(err, ShouldBeNil)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+// PKI is a CA and a certificate issued by it, like the PKI secrets of an
+// enforcer
+type PKI struct {
+	CA   *Authority
+	Leaf *Certificate
+}
+
+// NewPKI creates a CA named after the certificate and issues the
+// certificate with the options
+func NewPKI(name string, opts ...Option) *PKI {
+
+	ca := NewAuthority(name + "-ca")
+
+	return &PKI{
+		CA:   ca,
+		Leaf: ca.Issue(name, opts...),
+	}
+}